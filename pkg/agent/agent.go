@@ -84,7 +84,7 @@ func Run(ctx context.Context, options *Options) error {
 	exporterHandler := exporter.NewHandler("gems_agent", map[string]exporter.Collectorfunc{
 		"plugin":                 exporter.NewPluginCollectorFunc(c), // plugin exporter
 		"request":                exporter.NewRequestCollector(),     // http exporter
-		"cluster_component_cert": exporter.NewCertCollectorFunc(),    // cluster component cert
+		"cluster_component_cert": exporter.NewCertCollectorFunc(c),   // cluster component and tenant gateway cert
 	})
 
 	eg, ctx := errgroup.WithContext(ctx)
@@ -50,6 +50,9 @@ const (
 const (
 	LabelMonitorCollector = GroupName + "/monitoring"
 	LabelLogCollector     = GroupName + "/logging"
+	LabelLogPipeline      = GroupName + "/log-pipeline" // 日志管道生成的 flow/output, 值为管道名
+
+	AnnotationLogPipeline = GroupName + "/log-pipeline-spec" // 日志管道的原始配置
 
 	LabelAlertmanagerConfigName = "alertmanagerconfig.kubegems.io/name"
 	LabelAlertmanagerConfigType = "alertmanagerconfig.kubegems.io/type"
@@ -57,7 +60,8 @@ const (
 	LabelPrometheusRuleName = "prometheusrule.kubegems.io/name"
 	LabelPrometheusRuleType = "prometheusrule.kubegems.io/type"
 
-	LabelGatewayType = "gateway.kubegems.io/type" // ingress-nginx
+	LabelGatewayType        = "gateway.kubegems.io/type"        // ingress-nginx
+	LabelGatewayCertificate = "gateway.kubegems.io/certificate" // 网关同步的证书, 值为网关名
 
 	StatusEnabled  = "enabled"
 	StatusDisabled = "disabled"
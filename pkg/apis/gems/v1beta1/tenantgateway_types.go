@@ -19,6 +19,7 @@ package v1beta1
 import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -50,6 +51,93 @@ type Image struct {
 	PullPolicy string `json:"pullPolicy"`
 }
 
+// TLS defines the certificates used by ingresses of the Ingress Controller.
+type TLS struct {
+	// DefaultCertificate 默认证书所在的secret, 格式为 namespace/name
+	// 网关下没有配置TLS且没有绑定证书的ingress域名, 若被默认证书覆盖(如泛域名证书)则使用默认证书
+	// +kubebuilder:validation:Optional
+	DefaultCertificate string `json:"defaultCertificate,omitempty"`
+	// Hosts 按域名绑定的证书
+	// +kubebuilder:validation:Optional
+	Hosts []HostCertificate `json:"hosts,omitempty"`
+	// ACME 通过 cert-manager 签发证书, 需要集群中已安装 cert-manager 插件
+	// +kubebuilder:validation:Optional
+	// +nullable
+	ACME *ACME `json:"acme,omitempty"`
+}
+
+// HostCertificate binds a certificate to a host.
+type HostCertificate struct {
+	// Host 域名, 支持 *.example.com 形式的泛域名
+	Host string `json:"host"`
+	// SecretName 证书所在的secret, 格式为 namespace/name, 使用ACME签发时为空
+	// +kubebuilder:validation:Optional
+	SecretName string `json:"secretName,omitempty"`
+	// ACME 是否通过ACME签发该域名的证书
+	// +kubebuilder:validation:Optional
+	ACME bool `json:"acme,omitempty"`
+}
+
+// ACME defines the cert-manager issuer used to issue certificates.
+type ACME struct {
+	// Issuer cert-manager issuer 名称
+	Issuer string `json:"issuer"`
+	// IssuerKind cert-manager issuer 类型, 默认 ClusterIssuer
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Issuer;ClusterIssuer
+	IssuerKind string `json:"issuerKind,omitempty"`
+}
+
+// Autoscaling defines the HorizontalPodAutoscaler of the Ingress Controller.
+type Autoscaling struct {
+	// MinReplicas 最小实例数, 默认为1
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+	// MaxReplicas 最大实例数
+	// +kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas"`
+	// TargetCPUUtilizationPercentage 目标CPU使用率(相对于requests), 与 TargetRequestsPerSecond 至少设置一个
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	TargetCPUUtilizationPercentage *int32 `json:"targetCPUUtilizationPercentage,omitempty"`
+	// TargetRequestsPerSecond 单实例目标每秒请求数, 需要集群中 prometheus-adapter 提供 nginx_ingress_nginx_http_requests_per_second 指标
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	TargetRequestsPerSecond *int32 `json:"targetRequestsPerSecond,omitempty"`
+}
+
+// PodDisruptionBudget defines the PodDisruptionBudget of the Ingress Controller.
+// MinAvailable 与 MaxUnavailable 只能设置一个
+type PodDisruptionBudget struct {
+	// +kubebuilder:validation:Optional
+	MinAvailable *intstr.IntOrString `json:"minAvailable,omitempty"`
+	// +kubebuilder:validation:Optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+const (
+	PodAntiAffinityPreferred = "Preferred"
+	PodAntiAffinityRequired  = "Required"
+)
+
+// Scheduling defines the scheduling options of the Ingress Controller pods.
+type Scheduling struct {
+	// NodeSelector 节点选择
+	// +kubebuilder:validation:Optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Tolerations 容忍
+	// +kubebuilder:validation:Optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// PodAntiAffinity 网关实例间按节点反亲和, Preferred 为尽量分散, Required 为必须分散
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Preferred;Required
+	PodAntiAffinity string `json:"podAntiAffinity,omitempty"`
+	// TopologySpreadConstraints 拓扑分布约束, 未设置 labelSelector 时使用网关实例的label
+	// +kubebuilder:validation:Optional
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+}
+
 // TenantGatewaySpec defines the desired state of TenantGateway
 type TenantGatewaySpec struct {
 	// Type 负载均衡类型
@@ -80,6 +168,60 @@ type TenantGatewaySpec struct {
 	// BaseDomain is a record to auto generate domain in ingress.
 	// +kubebuilder:validation:Optional
 	BaseDomain string `json:"baseDomain"`
+	// TLS 网关证书配置
+	// +kubebuilder:validation:Optional
+	// +nullable
+	TLS *TLS `json:"tls,omitempty"`
+	// Autoscaling 自动扩缩容, 开启后 Replicas 不再生效
+	// +kubebuilder:validation:Optional
+	// +nullable
+	Autoscaling *Autoscaling `json:"autoscaling,omitempty"`
+	// PodDisruptionBudget 网关实例的中断预算, 避免节点驱逐时网关不可用
+	// +kubebuilder:validation:Optional
+	// +nullable
+	PodDisruptionBudget *PodDisruptionBudget `json:"podDisruptionBudget,omitempty"`
+	// Scheduling 网关实例的调度配置
+	// +kubebuilder:validation:Optional
+	// +nullable
+	Scheduling *Scheduling `json:"scheduling,omitempty"`
+}
+
+// AutoscalingStatus defines the observed state of the HorizontalPodAutoscaler.
+type AutoscalingStatus struct {
+	// CurrentReplicas 当前实例数
+	CurrentReplicas int32 `json:"currentReplicas"`
+	// DesiredReplicas 期望实例数
+	DesiredReplicas int32 `json:"desiredReplicas"`
+	// CurrentCPUUtilizationPercentage 当前CPU使用率
+	CurrentCPUUtilizationPercentage *int32 `json:"currentCPUUtilizationPercentage,omitempty"`
+	// CurrentRequestsPerSecond 当前单实例每秒请求数
+	CurrentRequestsPerSecond string `json:"currentRequestsPerSecond,omitempty"`
+	// LastScaleTime 上次扩缩容时间
+	LastScaleTime *metav1.Time `json:"lastScaleTime,omitempty"`
+	// Message 无法扩缩容的原因
+	Message string `json:"message,omitempty"`
+}
+
+// CertificateStatus defines the observed state of a certificate used by the gateway.
+type CertificateStatus struct {
+	// Host 证书绑定的域名, 默认证书为空
+	Host string `json:"host,omitempty"`
+	// SecretName 证书所在的secret, 格式为 namespace/name
+	SecretName string `json:"secretName"`
+	// Default 是否为默认证书
+	Default bool `json:"default,omitempty"`
+	// ACME 是否通过ACME签发
+	ACME bool `json:"acme,omitempty"`
+	// DNSNames 证书可用的域名, 域名绑定为绑定的域名, 默认证书为证书中的域名
+	DNSNames []string `json:"dnsNames,omitempty"`
+	// SyncedSecretName 同步到ingress所在namespace的secret名称, 应用编排中ingress的tls引用该secret
+	SyncedSecretName string `json:"syncedSecretName,omitempty"`
+	// Ready 证书是否可用
+	Ready bool `json:"ready"`
+	// NotAfter 证书过期时间
+	NotAfter *metav1.Time `json:"notAfter,omitempty"`
+	// Message 证书不可用的原因
+	Message string `json:"message,omitempty"`
 }
 
 // TenantGatewayStatus defines the observed state of TenantGateway
@@ -88,6 +230,10 @@ type TenantGatewayStatus struct {
 	AvailableReplicas int32 `json:"availableReplicas"`
 	// NodePort nginx service 占用的ports
 	Ports []corev1.ServicePort `json:"ports"`
+	// Certificates 网关证书状态
+	Certificates []CertificateStatus `json:"certificates,omitempty"`
+	// Autoscaling 自动扩缩容状态
+	Autoscaling *AutoscalingStatus `json:"autoscaling,omitempty"`
 }
 
 //+genclient
@@ -15,9 +15,11 @@
 package networking
 
 const (
-	AnnotationVirtualDomain = GroupName + "/virtualdomain"
-	AnnotationVirtualSpace  = GroupName + "/virtualspace"
-	AnnotationIstioGateway  = GroupName + "/istioGateway"
+	AnnotationVirtualDomain      = GroupName + "/virtualdomain"
+	AnnotationVirtualDomainHosts = GroupName + "/virtualdomainHosts" // virtualservice 上由虚拟域名添加的 hosts
+	AnnotationVirtualSpace       = GroupName + "/virtualspace"
+	AnnotationIstioGateway       = GroupName + "/istioGateway"
+	AnnotationMeshPolicy         = GroupName + "/meshPolicy" // 生成 istio 资源的原始策略配置
 
 	LabelIngressClass = GroupName + "/ingressClass" // ingress打标签用以筛选
 )
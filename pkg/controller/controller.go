@@ -178,6 +178,7 @@ func setupControllers(mgr ctrl.Manager, options *Options, setupLog logr.Logger)
 	}
 	if err := (&gemscontroller.ServiceentryReconciler{
 		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
 		Log:    ctrl.Log.WithName("controllers").WithName("ServiceEntry"),
 	}).SetupWithManager(mgr); err != nil {
 		return err
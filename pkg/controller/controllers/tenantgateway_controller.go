@@ -22,7 +22,9 @@ import (
 
 	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	networkingv1beta1 "k8s.io/api/networking/v1beta1"
 	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -37,6 +39,7 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrlhandler "sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
@@ -103,6 +106,15 @@ func (r *TenantGatewayReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		tg.Spec.Workload = &gemsv1beta1.Workload{}
 	}
 
+	// 自动扩缩容, 开启后网关实例数跟随HPA
+	hpaStatus, err := r.reconcileAutoscaling(ctx, &tg)
+	if err != nil {
+		r.Recorder.Eventf(&tg, corev1.EventTypeWarning, ReasonFailedUpdate, "Failed to sync HorizontalPodAutoscaler: %v", err)
+		log.Error(err, "Error sync HorizontalPodAutoscaler")
+		hpaStatus = tg.Status.Autoscaling
+	}
+	replicas := gatewayReplicas(&tg, hpaStatus)
+
 	found := &nginxv1beta1.NginxIngressController{}
 	if err := r.Get(ctx, types.NamespacedName{
 		Namespace: gemlabels.NamespaceGateway, // gateway资源都在这里
@@ -110,7 +122,7 @@ func (r *TenantGatewayReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 	}, found); err != nil {
 		if apierrors.IsNotFound(err) {
 			// 没有nic，执行create
-			nic := r.nginxIngressControllerForTenantGateway(&tg)
+			nic := r.nginxIngressControllerForTenantGateway(&tg, replicas)
 			if err := r.Create(ctx, nic); err != nil {
 				r.Recorder.Eventf(&tg, corev1.EventTypeWarning, ReasonFailedCreate, "Failed to create NginxIngressController %s: %v", nic.Name, err)
 				log.Info("Error create NginxIngressController")
@@ -122,8 +134,8 @@ func (r *TenantGatewayReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		}
 	} else {
 		// 找到该gateway，执行更新
-		if r.hasNginxIngressControllerChanged(found, &tg) {
-			updated := r.updateNginxIngressController(found, &tg)
+		if r.hasNginxIngressControllerChanged(found, &tg, replicas) {
+			updated := r.updateNginxIngressController(found, &tg, replicas)
 			if err := r.Update(ctx, updated); err != nil {
 				r.Recorder.Eventf(&tg, corev1.EventTypeWarning, ReasonFailedCreate, "Failed to update NginxIngressController %s: %v", found.Name, err)
 				log.Error(err, "Error update NginxIngressController")
@@ -142,44 +154,69 @@ func (r *TenantGatewayReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		}
 	}
 
+	// PDB 与调度配置
+	if err := r.reconcileAvailability(ctx, &tg); err != nil {
+		r.Recorder.Eventf(&tg, corev1.EventTypeWarning, ReasonFailedUpdate, "Failed to sync PodDisruptionBudget or scheduling: %v", err)
+		log.Error(err, "Error sync PodDisruptionBudget or scheduling")
+	}
+
+	// 证书, 配置了tls时需要定期检查证书过期时间
+	result := ctrl.Result{}
+	if tg.Spec.TLS != nil {
+		result.RequeueAfter = tlsResyncPeriod
+	}
+	certs, err := r.reconcileTLS(ctx, &tg)
+	if err != nil {
+		r.Recorder.Eventf(&tg, corev1.EventTypeWarning, ReasonFailedUpdate, "Failed to sync certificates: %v", err)
+		log.Error(err, "Error sync certificates")
+		certs = tg.Status.Certificates
+	}
+
 	// 最后处理status
 	svc := &corev1.Service{}
 	if err := r.Get(ctx, types.NamespacedName{
 		Namespace: gemlabels.NamespaceGateway, // gateway资源都在这里
 		Name:      tg.Name,
 	}, svc); err != nil {
-		return ctrl.Result{}, nil
+		return result, nil
 	}
 	dep := &appsv1.Deployment{}
 	if err := r.Get(ctx, types.NamespacedName{
 		Namespace: gemlabels.NamespaceGateway, // gateway资源都在这里
 		Name:      tg.Name,
 	}, dep); err != nil {
-		return ctrl.Result{}, nil
+		return result, nil
 	}
 
 	if !equality.Semantic.DeepEqual(svc.Spec.Ports, tg.Status.Ports) ||
-		dep.Status.AvailableReplicas != tg.Status.AvailableReplicas {
+		dep.Status.AvailableReplicas != tg.Status.AvailableReplicas ||
+		!equality.Semantic.DeepEqual(certs, tg.Status.Certificates) ||
+		!equality.Semantic.DeepEqual(hpaStatus, tg.Status.Autoscaling) {
 		tg.Status.Ports = svc.Spec.Ports
 		tg.Status.AvailableReplicas = dep.Status.AvailableReplicas
+		tg.Status.Certificates = certs
+		tg.Status.Autoscaling = hpaStatus
 		if err := r.Status().Update(ctx, &tg); err != nil {
 			log.Error(err, "failed to update tenantGateway")
-			return ctrl.Result{}, nil
+			return result, nil
 		}
 		log.Info("success to update", "gateway status", tg.Status)
 	}
-	return ctrl.Result{}, nil
+	return result, nil
 }
 
 func (r *TenantGatewayReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		Watches(&source.Kind{Type: &corev1.Service{}}, handler.NewServiceHandler(r.Client, r.Log)).
 		Watches(&source.Kind{Type: &appsv1.Deployment{}}, handler.NewDepoymentHandler(r.Client, r.Log)).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, ctrlhandler.EnqueueRequestsFromMapFunc(onCertificateSecretChange(r.Client))).
+		Watches(&source.Kind{Type: &networkingv1.Ingress{}}, ctrlhandler.EnqueueRequestsFromMapFunc(onIngressChange(r.Client))).
 		For(&gemsv1beta1.TenantGateway{}).
+		Owns(&autoscalingv2.HorizontalPodAutoscaler{}).
 		Complete(r)
 }
 
-func (r *TenantGatewayReconciler) hasNginxIngressControllerChanged(nic *nginxv1beta1.NginxIngressController, tg *gemsv1beta1.TenantGateway) bool {
+func (r *TenantGatewayReconciler) hasNginxIngressControllerChanged(nic *nginxv1beta1.NginxIngressController, tg *gemsv1beta1.TenantGateway, replicas *int32) bool {
 	// label
 	if nic.Labels[gemlabels.LabelTenant] != tg.Spec.Tenant {
 		return true
@@ -190,7 +227,7 @@ func (r *TenantGatewayReconciler) hasNginxIngressControllerChanged(nic *nginxv1b
 		return true
 	}
 
-	if nic.Spec.Replicas != nil && tg.Spec.Replicas != nil && *nic.Spec.Replicas != *tg.Spec.Replicas {
+	if nic.Spec.Replicas != nil && replicas != nil && *nic.Spec.Replicas != *replicas {
 		return true
 	}
 
@@ -236,7 +273,7 @@ func (r *TenantGatewayReconciler) hasNginxIngressControllerChanged(nic *nginxv1b
 
 var nginxMetricsPort uint16 = 9113
 
-func (r *TenantGatewayReconciler) nginxIngressControllerForTenantGateway(tg *gemsv1beta1.TenantGateway) *nginxv1beta1.NginxIngressController {
+func (r *TenantGatewayReconciler) nginxIngressControllerForTenantGateway(tg *gemsv1beta1.TenantGateway, replicas *int32) *nginxv1beta1.NginxIngressController {
 	return &nginxv1beta1.NginxIngressController{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      tg.Name,
@@ -252,7 +289,7 @@ func (r *TenantGatewayReconciler) nginxIngressControllerForTenantGateway(tg *gem
 				Type:        string(tg.Spec.Type),
 				ExtraLabels: tg.Spec.Service.ExtraLabels,
 			},
-			Replicas:     tg.Spec.Replicas,
+			Replicas:     replicas,
 			IngressClass: tg.Spec.IngressClass,
 			Image: nginxv1beta1.Image{
 				Repository: tg.Spec.Image.Repository,
@@ -265,13 +302,13 @@ func (r *TenantGatewayReconciler) nginxIngressControllerForTenantGateway(tg *gem
 	}
 }
 
-func (r *TenantGatewayReconciler) updateNginxIngressController(nic *nginxv1beta1.NginxIngressController, tg *gemsv1beta1.TenantGateway) *nginxv1beta1.NginxIngressController {
+func (r *TenantGatewayReconciler) updateNginxIngressController(nic *nginxv1beta1.NginxIngressController, tg *gemsv1beta1.TenantGateway, replicas *int32) *nginxv1beta1.NginxIngressController {
 	nic.SetLabels(map[string]string{
 		gemlabels.LabelTenant:      tg.Spec.Tenant,
 		gemlabels.LabelApplication: tg.Name,
 	})
 	nic.SetOwnerReferences([]metav1.OwnerReference{*metav1.NewControllerRef(tg, gemsv1beta1.SchemeTenantGateway)})
-	nic.Spec.Replicas = tg.Spec.Replicas
+	nic.Spec.Replicas = replicas
 	nic.Spec.IngressClass = tg.Spec.IngressClass
 	nic.Spec.Service = &nginxv1beta1.Service{
 		Type:        string(tg.Spec.Type),
@@ -0,0 +1,222 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	gemlabels "kubegems.io/kubegems/pkg/apis/gems"
+	gemsv1beta1 "kubegems.io/kubegems/pkg/apis/gems/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+//+kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
+
+// 网关单实例每秒请求数, 由 prometheus-adapter 根据 nginx exporter 的 nginx_ingress_nginx_http_requests_total 计算
+const gatewayRequestsPerSecondMetric = "nginx_ingress_nginx_http_requests_per_second"
+
+// reconcileAutoscaling 同步网关的HPA, 未开启自动扩缩容时删除HPA
+func (r *TenantGatewayReconciler) reconcileAutoscaling(ctx context.Context, tg *gemsv1beta1.TenantGateway) (*gemsv1beta1.AutoscalingStatus, error) {
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Namespace: gemlabels.NamespaceGateway, Name: tg.Name},
+	}
+	if tg.Spec.Autoscaling == nil {
+		if err := r.Delete(ctx, hpa); err != nil && !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	as := tg.Spec.Autoscaling
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, hpa, func() error {
+		hpa.Labels = map[string]string{
+			gemlabels.LabelTenant:      tg.Spec.Tenant,
+			gemlabels.LabelApplication: tg.Name,
+		}
+		hpa.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(tg, gemsv1beta1.SchemeTenantGateway)}
+		hpa.Spec.ScaleTargetRef = autoscalingv2.CrossVersionObjectReference{
+			APIVersion: appsv1.SchemeGroupVersion.String(),
+			Kind:       "Deployment",
+			Name:       tg.Name, // deployment 与网关同名
+		}
+		hpa.Spec.MinReplicas = as.MinReplicas
+		hpa.Spec.MaxReplicas = as.MaxReplicas
+		hpa.Spec.Metrics = nil
+		if as.TargetCPUUtilizationPercentage != nil {
+			hpa.Spec.Metrics = append(hpa.Spec.Metrics, autoscalingv2.MetricSpec{
+				Type: autoscalingv2.ResourceMetricSourceType,
+				Resource: &autoscalingv2.ResourceMetricSource{
+					Name: corev1.ResourceCPU,
+					Target: autoscalingv2.MetricTarget{
+						Type:               autoscalingv2.UtilizationMetricType,
+						AverageUtilization: as.TargetCPUUtilizationPercentage,
+					},
+				},
+			})
+		}
+		if as.TargetRequestsPerSecond != nil {
+			hpa.Spec.Metrics = append(hpa.Spec.Metrics, autoscalingv2.MetricSpec{
+				Type: autoscalingv2.PodsMetricSourceType,
+				Pods: &autoscalingv2.PodsMetricSource{
+					Metric: autoscalingv2.MetricIdentifier{Name: gatewayRequestsPerSecondMetric},
+					Target: autoscalingv2.MetricTarget{
+						Type:         autoscalingv2.AverageValueMetricType,
+						AverageValue: resource.NewQuantity(int64(*as.TargetRequestsPerSecond), resource.DecimalSI),
+					},
+				},
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return autoscalingStatus(hpa), nil
+}
+
+func autoscalingStatus(hpa *autoscalingv2.HorizontalPodAutoscaler) *gemsv1beta1.AutoscalingStatus {
+	status := &gemsv1beta1.AutoscalingStatus{
+		CurrentReplicas: hpa.Status.CurrentReplicas,
+		DesiredReplicas: hpa.Status.DesiredReplicas,
+		LastScaleTime:   hpa.Status.LastScaleTime,
+	}
+	for _, m := range hpa.Status.CurrentMetrics {
+		switch {
+		case m.Resource != nil && m.Resource.Name == corev1.ResourceCPU:
+			status.CurrentCPUUtilizationPercentage = m.Resource.Current.AverageUtilization
+		case m.Pods != nil && m.Pods.Metric.Name == gatewayRequestsPerSecondMetric && m.Pods.Current.AverageValue != nil:
+			status.CurrentRequestsPerSecond = m.Pods.Current.AverageValue.String()
+		}
+	}
+	for _, cond := range hpa.Status.Conditions {
+		if cond.Status == corev1.ConditionFalse &&
+			(cond.Type == autoscalingv2.AbleToScale || cond.Type == autoscalingv2.ScalingActive) {
+			status.Message = cond.Message
+			break
+		}
+	}
+	return status
+}
+
+// gatewayReplicas 网关的实例数, 开启自动扩缩容时使用HPA的期望实例数, 避免与HPA互相覆盖
+func gatewayReplicas(tg *gemsv1beta1.TenantGateway, status *gemsv1beta1.AutoscalingStatus) *int32 {
+	if tg.Spec.Autoscaling == nil {
+		return tg.Spec.Replicas
+	}
+	if status != nil && status.DesiredReplicas > 0 {
+		replicas := status.DesiredReplicas
+		return &replicas
+	}
+	if tg.Spec.Autoscaling.MinReplicas != nil {
+		return tg.Spec.Autoscaling.MinReplicas
+	}
+	minReplicas := int32(1)
+	return &minReplicas
+}
+
+// reconcilePodDisruptionBudget 同步网关的PDB, 未配置时删除PDB
+func (r *TenantGatewayReconciler) reconcilePodDisruptionBudget(ctx context.Context, tg *gemsv1beta1.TenantGateway, dep *appsv1.Deployment) error {
+	pdb := &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Namespace: gemlabels.NamespaceGateway, Name: tg.Name},
+	}
+	if tg.Spec.PodDisruptionBudget == nil {
+		if err := r.Delete(ctx, pdb); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		return nil
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, pdb, func() error {
+		pdb.Labels = map[string]string{
+			gemlabels.LabelTenant:      tg.Spec.Tenant,
+			gemlabels.LabelApplication: tg.Name,
+		}
+		pdb.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(tg, gemsv1beta1.SchemeTenantGateway)}
+		pdb.Spec.Selector = dep.Spec.Selector
+		pdb.Spec.MinAvailable = tg.Spec.PodDisruptionBudget.MinAvailable
+		pdb.Spec.MaxUnavailable = tg.Spec.PodDisruptionBudget.MaxUnavailable
+		return nil
+	})
+	return err
+}
+
+// reconcileScheduling 将调度配置设置到网关的deployment上, NginxIngressController 不支持这些配置.
+// 只 patch operator 不管理的调度字段, 不使用 Update 覆盖 operator 管理的其他字段
+func (r *TenantGatewayReconciler) reconcileScheduling(ctx context.Context, tg *gemsv1beta1.TenantGateway, dep *appsv1.Deployment) error {
+	base := dep.DeepCopy()
+	podspec := &dep.Spec.Template.Spec
+	origin := podspec.DeepCopy()
+
+	podspec.NodeSelector, podspec.Tolerations, podspec.Affinity, podspec.TopologySpreadConstraints = nil, nil, nil, nil
+	if s := tg.Spec.Scheduling; s != nil {
+		podspec.NodeSelector = s.NodeSelector
+		podspec.Tolerations = s.Tolerations
+		podspec.Affinity = gatewayAffinity(s.PodAntiAffinity, dep.Spec.Selector)
+		for _, c := range s.TopologySpreadConstraints {
+			if c.LabelSelector == nil {
+				c.LabelSelector = dep.Spec.Selector
+			}
+			podspec.TopologySpreadConstraints = append(podspec.TopologySpreadConstraints, c)
+		}
+	}
+	if equality.Semantic.DeepEqual(origin.NodeSelector, podspec.NodeSelector) &&
+		equality.Semantic.DeepEqual(origin.Tolerations, podspec.Tolerations) &&
+		equality.Semantic.DeepEqual(origin.Affinity, podspec.Affinity) &&
+		equality.Semantic.DeepEqual(origin.TopologySpreadConstraints, podspec.TopologySpreadConstraints) {
+		return nil
+	}
+	return r.Patch(ctx, dep, client.MergeFrom(base))
+}
+
+func gatewayAffinity(antiAffinity string, selector *metav1.LabelSelector) *corev1.Affinity {
+	term := corev1.PodAffinityTerm{
+		LabelSelector: selector,
+		TopologyKey:   corev1.LabelHostname,
+	}
+	switch antiAffinity {
+	case gemsv1beta1.PodAntiAffinityRequired:
+		return &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{term},
+		}}
+	case gemsv1beta1.PodAntiAffinityPreferred:
+		return &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{{Weight: 100, PodAffinityTerm: term}},
+		}}
+	default:
+		return nil
+	}
+}
+
+// reconcileAvailability 同步网关的PDB与调度配置, 需要在deployment创建后执行
+func (r *TenantGatewayReconciler) reconcileAvailability(ctx context.Context, tg *gemsv1beta1.TenantGateway) error {
+	dep := &appsv1.Deployment{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: gemlabels.NamespaceGateway, Name: tg.Name}, dep); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if err := r.reconcilePodDisruptionBudget(ctx, tg, dep); err != nil {
+		return err
+	}
+	return r.reconcileScheduling(ctx, tg, dep)
+}
@@ -0,0 +1,344 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	gemlabels "kubegems.io/kubegems/pkg/apis/gems"
+	gemsv1beta1 "kubegems.io/kubegems/pkg/apis/gems/v1beta1"
+	"kubegems.io/kubegems/pkg/apis/networking"
+	"kubegems.io/kubegems/pkg/utils/certificate"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrlhandler "sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;patch;delete
+
+// 证书需要定期检查过期时间, 证书和ingress的变更通过watch触发同步
+const tlsResyncPeriod = 6 * time.Hour
+
+var certManagerCertificateGVK = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+
+// gatewayCertBinding 网关上的一个证书绑定, host 为空时为默认证书
+type gatewayCertBinding struct {
+	status gemsv1beta1.CertificateStatus
+	secret *corev1.Secret
+	cert   *certificate.Certificate
+}
+
+// reconcileTLS 签发并检查网关证书, 将证书同步到引用了证书的ingress所在的namespace
+// ingress 的 tls 由应用编排在提交时声明(certificate.BindIngressTLS), 这里不修改 ingress, 避免与 argo 的期望状态互相覆盖
+func (r *TenantGatewayReconciler) reconcileTLS(ctx context.Context, tg *gemsv1beta1.TenantGateway) ([]gemsv1beta1.CertificateStatus, error) {
+	bindings := []*gatewayCertBinding{}
+	if tg.Spec.TLS != nil {
+		for _, h := range tg.Spec.TLS.Hosts {
+			b := &gatewayCertBinding{status: gemsv1beta1.CertificateStatus{
+				Host:             h.Host,
+				SecretName:       h.SecretName,
+				ACME:             h.ACME,
+				DNSNames:         []string{h.Host},
+				SyncedSecretName: syncedSecretPrefix(tg.Name) + hostSlug(h.Host),
+			}}
+			if h.ACME {
+				b.status.SecretName = gemlabels.NamespaceGateway + "/" + acmeSecretName(tg.Name, h.Host)
+				if err := r.ensureACMECertificate(ctx, tg, h.Host); err != nil {
+					b.status.Message = err.Error()
+					bindings = append(bindings, b)
+					continue
+				}
+			}
+			r.loadCertificate(ctx, tg, b)
+			bindings = append(bindings, b)
+		}
+		if tg.Spec.TLS.DefaultCertificate != "" {
+			b := &gatewayCertBinding{status: gemsv1beta1.CertificateStatus{
+				SecretName:       tg.Spec.TLS.DefaultCertificate,
+				Default:          true,
+				SyncedSecretName: syncedSecretPrefix(tg.Name) + "default",
+			}}
+			r.loadCertificate(ctx, tg, b)
+			if b.cert != nil {
+				b.status.DNSNames = b.cert.SANs
+				if len(b.status.DNSNames) == 0 {
+					b.status.DNSNames = []string{b.cert.Subject.CommonName}
+				}
+			}
+			bindings = append(bindings, b)
+		}
+	}
+
+	if err := r.syncIngressSecrets(ctx, tg, bindings); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]gemsv1beta1.CertificateStatus, len(bindings))
+	for i := range bindings {
+		statuses[i] = bindings[i].status
+	}
+	return statuses, nil
+}
+
+func (r *TenantGatewayReconciler) loadCertificate(ctx context.Context, tg *gemsv1beta1.TenantGateway, b *gatewayCertBinding) {
+	namespace, name, ok := strings.Cut(b.status.SecretName, "/")
+	if !ok {
+		b.status.Message = fmt.Sprintf("invalid secret %s, must be namespace/name", b.status.SecretName)
+		return
+	}
+	if err := r.checkCertificateNamespace(ctx, tg, namespace); err != nil {
+		b.status.Message = err.Error()
+		return
+	}
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, secret); err != nil {
+		if apierrors.IsNotFound(err) && b.status.ACME {
+			b.status.Message = "waiting for cert-manager to issue the certificate"
+		} else {
+			b.status.Message = err.Error()
+		}
+		return
+	}
+	// 证书必须标记为本租户所有, 避免引用网关namespace中其他租户或未归属的证书
+	if tenant := secret.Labels[gemlabels.LabelTenant]; tenant != tg.Spec.Tenant {
+		b.status.Message = fmt.Sprintf("secret %s must be labeled with %s=%s", b.status.SecretName, gemlabels.LabelTenant, tg.Spec.Tenant)
+		return
+	}
+	if len(secret.Data[corev1.TLSPrivateKeyKey]) == 0 {
+		b.status.Message = fmt.Sprintf("secret %s has no %s", b.status.SecretName, corev1.TLSPrivateKeyKey)
+		return
+	}
+	cert, err := certificate.ParseCertInfo(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		b.status.Message = err.Error()
+		return
+	}
+	b.secret, b.cert = secret, cert
+	notAfter := metav1.NewTime(cert.NotAfter)
+	b.status.NotAfter = &notAfter
+	switch {
+	case time.Now().After(cert.NotAfter):
+		b.status.Message = "certificate expired"
+	case b.status.Host != "" && !cert.Covers(b.status.Host):
+		b.status.Message = fmt.Sprintf("certificate does not cover host %s", b.status.Host)
+	default:
+		b.status.Ready = true
+	}
+}
+
+// checkCertificateNamespace 证书只能来自网关namespace或者租户自己的namespace, 避免将其他租户的私钥复制到本租户
+func (r *TenantGatewayReconciler) checkCertificateNamespace(ctx context.Context, tg *gemsv1beta1.TenantGateway, namespace string) error {
+	if namespace == gemlabels.NamespaceGateway {
+		return nil
+	}
+	ns := &corev1.Namespace{}
+	if err := r.Get(ctx, types.NamespacedName{Name: namespace}, ns); err != nil {
+		return err
+	}
+	if ns.Labels[gemlabels.LabelTenant] != tg.Spec.Tenant {
+		return fmt.Errorf("namespace %s does not belong to tenant %s", namespace, tg.Spec.Tenant)
+	}
+	return nil
+}
+
+// ensureACMECertificate 创建 cert-manager Certificate, 未安装 cert-manager 时返回错误
+func (r *TenantGatewayReconciler) ensureACMECertificate(ctx context.Context, tg *gemsv1beta1.TenantGateway, host string) error {
+	acme := tg.Spec.TLS.ACME
+	if acme == nil || acme.Issuer == "" {
+		return fmt.Errorf("acme issuer of gateway is not configured")
+	}
+	kind := acme.IssuerKind
+	if kind == "" {
+		kind = "ClusterIssuer"
+	}
+	cert := &unstructured.Unstructured{}
+	cert.SetGroupVersionKind(certManagerCertificateGVK)
+	cert.SetNamespace(gemlabels.NamespaceGateway)
+	cert.SetName(acmeSecretName(tg.Name, host))
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, cert, func() error {
+		cert.SetLabels(map[string]string{
+			gemlabels.LabelTenant:             tg.Spec.Tenant,
+			gemlabels.LabelGatewayCertificate: tg.Name,
+		})
+		cert.SetOwnerReferences([]metav1.OwnerReference{*metav1.NewControllerRef(tg, gemsv1beta1.SchemeTenantGateway)})
+		return unstructured.SetNestedField(cert.Object, map[string]interface{}{
+			"secretName": cert.GetName(),
+			"secretTemplate": map[string]interface{}{
+				"labels": map[string]interface{}{
+					gemlabels.LabelTenant: tg.Spec.Tenant,
+				},
+			},
+			"dnsNames": []interface{}{host},
+			"issuerRef": map[string]interface{}{
+				"group": certManagerCertificateGVK.Group,
+				"kind":  kind,
+				"name":  acme.Issuer,
+			},
+		}, "spec")
+	})
+	if meta.IsNoMatchError(err) {
+		return fmt.Errorf("cert-manager is not installed")
+	}
+	return err
+}
+
+// syncIngressSecrets 将证书复制到tls引用了网关证书的ingress所在的namespace, 并清理不再使用的证书副本
+func (r *TenantGatewayReconciler) syncIngressSecrets(ctx context.Context, tg *gemsv1beta1.TenantGateway, bindings []*gatewayCertBinding) error {
+	ingressClass := tg.Labels[networking.LabelIngressClass]
+	if ingressClass == "" {
+		ingressClass = tg.Spec.IngressClass
+	}
+	ingresses := &networkingv1.IngressList{}
+	if err := r.List(ctx, ingresses, client.MatchingLabels{networking.LabelIngressClass: ingressClass}); err != nil {
+		return err
+	}
+	bySecret := map[string]*gatewayCertBinding{}
+	for _, b := range bindings {
+		if b.status.Ready {
+			bySecret[b.status.SyncedSecretName] = b
+		}
+	}
+
+	inuse := map[types.NamespacedName]bool{}
+	for _, ingress := range ingresses.Items {
+		for _, t := range ingress.Spec.TLS {
+			b, ok := bySecret[t.SecretName]
+			key := types.NamespacedName{Namespace: ingress.Namespace, Name: t.SecretName}
+			if !ok || inuse[key] {
+				continue
+			}
+			if err := r.syncSecret(ctx, tg, b, ingress.Namespace); err != nil {
+				return err
+			}
+			inuse[key] = true
+		}
+	}
+
+	secrets := &corev1.SecretList{}
+	if err := r.List(ctx, secrets, client.MatchingLabels{gemlabels.LabelGatewayCertificate: tg.Name}); err != nil {
+		return err
+	}
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if secret.Namespace == gemlabels.NamespaceGateway || inuse[client.ObjectKeyFromObject(secret)] {
+			continue
+		}
+		if err := r.Delete(ctx, secret); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncSecret 将证书复制到ingress所在的namespace
+func (r *TenantGatewayReconciler) syncSecret(ctx context.Context, tg *gemsv1beta1.TenantGateway, b *gatewayCertBinding, namespace string) error {
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: b.status.SyncedSecretName}}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		secret.Labels = map[string]string{
+			gemlabels.LabelTenant:             tg.Spec.Tenant,
+			gemlabels.LabelGatewayCertificate: tg.Name,
+		}
+		secret.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(tg, gemsv1beta1.SchemeTenantGateway)}
+		secret.Type = corev1.SecretTypeTLS
+		secret.Data = map[string][]byte{
+			corev1.TLSCertKey:       b.secret.Data[corev1.TLSCertKey],
+			corev1.TLSPrivateKeyKey: b.secret.Data[corev1.TLSPrivateKeyKey],
+		}
+		return nil
+	})
+	return err
+}
+
+func syncedSecretPrefix(gateway string) string {
+	return "tgw-" + gateway + "-"
+}
+
+func acmeSecretName(gateway, host string) string {
+	return gateway + "-acme-" + hostSlug(host)
+}
+
+// hostSlug *.example.com -> wildcard-example-com
+func hostSlug(host string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(strings.ToLower(host), "*", "wildcard"), ".", "-")
+}
+
+// onCertificateSecretChange 证书更新或轮换时重新同步引用了该secret的网关
+func onCertificateSecretChange(cli client.Client) ctrlhandler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		secret, ok := obj.(*corev1.Secret)
+		if !ok || (secret.Type != corev1.SecretTypeTLS && secret.Data[corev1.TLSCertKey] == nil) {
+			return nil
+		}
+		tgs := &gemsv1beta1.TenantGatewayList{}
+		if err := cli.List(context.Background(), tgs); err != nil {
+			return nil
+		}
+		key := secret.Namespace + "/" + secret.Name
+		reqs := []reconcile.Request{}
+		for _, tg := range tgs.Items {
+			if tg.Spec.TLS == nil {
+				continue
+			}
+			referred := tg.Spec.TLS.DefaultCertificate == key
+			for _, h := range tg.Spec.TLS.Hosts {
+				if h.SecretName == key || (h.ACME && key == gemlabels.NamespaceGateway+"/"+acmeSecretName(tg.Name, h.Host)) {
+					referred = true
+				}
+			}
+			if referred {
+				reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Name: tg.Name}})
+			}
+		}
+		return reqs
+	}
+}
+
+// onIngressChange ingress tls 变化时重新同步对应ingressClass网关的证书副本
+func onIngressChange(cli client.Client) ctrlhandler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		ingressClass := obj.GetLabels()[networking.LabelIngressClass]
+		if ingressClass == "" {
+			return nil
+		}
+		tgs := &gemsv1beta1.TenantGatewayList{}
+		if err := cli.List(context.Background(), tgs); err != nil {
+			return nil
+		}
+		reqs := []reconcile.Request{}
+		for _, tg := range tgs.Items {
+			if tg.Spec.TLS == nil {
+				continue
+			}
+			if class := tg.Labels[networking.LabelIngressClass]; class == ingressClass || (class == "" && tg.Spec.IngressClass == ingressClass) {
+				reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Name: tg.Name}})
+			}
+		}
+		return reqs
+	}
+}
@@ -36,29 +36,13 @@ import (
 	gemlabels "kubegems.io/kubegems/pkg/apis/gems"
 	gemsv1beta1 "kubegems.io/kubegems/pkg/apis/gems/v1beta1"
 	"kubegems.io/kubegems/pkg/controller/handler"
-	"kubegems.io/kubegems/pkg/utils/maps"
-)
-
-const (
-	isoKindTenant      = "tenant"
-	isoKindProject     = "project"
-	isoKindEnvironment = "environment"
+	"kubegems.io/kubegems/pkg/utils/netpol"
 )
 
 type NetworkPolicyAction struct {
-	TenantISO      bool
-	ProjectISO     bool
-	EnvironmentISO bool
-
-	Tenant      string
-	Project     string
-	Environment string
-
 	Origin *netv1.NetworkPolicy
 	Modify *netv1.NetworkPolicy
 
-	Labels map[string]string
-
 	action string
 }
 
@@ -120,12 +104,13 @@ func (r *TenantNetworkPolicyReconciler) Reconcile(ctx context.Context, req ctrl.
 	// 最后统一判断差异后进行patch or create
 	statusMap := map[string]NetworkPolicyAction{}
 
-	r.handleStatusMap(ctx, statusMap, &netpol)
+	if err := r.handleStatusMap(ctx, statusMap, &netpol); err != nil {
+		return ctrl.Result{}, err
+	}
 
 	for _, action := range statusMap {
 		switch action.action {
 		case "create":
-			action.Modify.Labels = labels.Merge(action.Modify.Labels, action.Labels)
 			if err := r.Create(ctx, action.Modify); err != nil {
 				log.Info("Error create networkpolicy " + err.Error())
 			}
@@ -134,7 +119,6 @@ func (r *TenantNetworkPolicyReconciler) Reconcile(ctx context.Context, req ctrl.
 				log.Info("Error delete networkpolicy " + err.Error())
 			}
 		case "update":
-			action.Modify.Labels = labels.Merge(action.Modify.Labels, action.Labels)
 			if err := r.Update(ctx, action.Modify); err != nil {
 				log.Info("Error update networkpolicy " + err.Error())
 			}
@@ -157,96 +141,48 @@ func (r *TenantNetworkPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error
 		Complete(r)
 }
 
-func (r *TenantNetworkPolicyReconciler) handleStatusMap(ctx context.Context, st map[string]NetworkPolicyAction, netpol *gemsv1beta1.TenantNetworkPolicy) {
+// handleStatusMap 对比租户下各 namespace 已有的与期望的 NetworkPolicy, 期望结果与连通性模拟共用 netpol.TenantNetworkPolicies
+func (r *TenantNetworkPolicyReconciler) handleStatusMap(ctx context.Context, st map[string]NetworkPolicyAction, tnp *gemsv1beta1.TenantNetworkPolicy) error {
 	cidrs, err := GetCIDRs(r.Client)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	// 如果开启租户隔离，那么租户关联的所有环境对应的namespace下，都将存在np,且允许带租户label的ns访问
-	labelSel := labels.SelectorFromSet(map[string]string{gemlabels.LabelTenant: netpol.Spec.Tenant})
-	r.handleRelatedObjectList(ctx, labelSel, st, isoKindTenant, netpol.Spec.TenantIsolated)
-
-	// 如果开启项目隔离，那么项目关联的所有namespace下，都将存在np,且允许带项目label的ns访问
-	for _, proj := range netpol.Spec.ProjectNetworkPolicies {
-		labelSel := labels.SelectorFromSet(map[string]string{gemlabels.LabelProject: proj.Name})
-		r.handleRelatedObjectList(ctx, labelSel, st, isoKindProject, true)
-	}
-
-	// 如果开启环境隔离，那么项目关联的所有namespace下，都将存在np,且允许带环境label的ns访问
-	for _, env := range netpol.Spec.EnvironmentNetworkPolicies {
-		labelSel := labels.SelectorFromSet(map[string]string{gemlabels.LabelEnvironment: env.Name})
-		r.handleRelatedObjectList(ctx, labelSel, st, isoKindEnvironment, true)
-	}
-
-	for ns, action := range st {
-		defaultnetpol := DefaultNetworkPolicy(ns, "default", cidrs)
-		action.Modify = &defaultnetpol
-		if action.TenantISO {
-			AddNamespaceSelector(action.Modify, gemlabels.LabelTenant, action.Tenant)
-		}
-		if action.ProjectISO {
-			AddNamespaceSelector(action.Modify, gemlabels.LabelProject, action.Project)
-		}
-		if action.EnvironmentISO {
-			AddNamespaceSelector(action.Modify, gemlabels.LabelEnvironment, action.Environment)
-		}
-		if action.Origin == nil {
-			if !action.EnvironmentISO && !action.ProjectISO && !action.TenantISO {
-				action.action = ""
-			} else {
-				action.action = "create"
-			}
-		} else {
-			if !action.EnvironmentISO && !action.ProjectISO && !action.TenantISO {
-				action.action = "delete"
-			} else if !equality.Semantic.DeepDerivative(action.Origin, action.Modify) {
-				action.action = "update"
-			} else {
-				action.action = ""
-			}
-		}
-		st[ns] = action
-	}
-}
-
-func (r *TenantNetworkPolicyReconciler) handleRelatedObjectList(ctx context.Context, sel labels.Selector, st map[string]NetworkPolicyAction, kind string, isolated bool) {
+	sel := client.MatchingLabels{gemlabels.LabelTenant: tnp.Spec.Tenant}
 	nslist := &corev1.NamespaceList{}
+	if err := r.List(ctx, nslist, sel); err != nil {
+		return err
+	}
 	nplist := &netv1.NetworkPolicyList{}
-	r.List(ctx, nslist, &client.ListOptions{
-		LabelSelector: sel,
-		Namespace:     corev1.NamespaceAll,
-	})
-	r.List(ctx, nplist, &client.ListOptions{
-		LabelSelector: sel,
-		Namespace:     corev1.NamespaceAll,
-	})
-	npmap := map[string]netv1.NetworkPolicy{}
-	for _, np := range nplist.Items {
-		npmap[np.Namespace] = np
+	if err := r.List(ctx, nplist, sel); err != nil {
+		return err
 	}
-	for _, ns := range nslist.Items {
-		var tmpaction NetworkPolicyAction
-		if action, exist := st[ns.Name]; exist {
-			tmpaction = action
-		} else {
-			tmpaction = NetworkPolicyAction{}
-			tmpnp, exist := npmap[ns.Name]
-			if exist {
-				tmpaction.Origin = &tmpnp
-			}
+	origins := map[string]*netv1.NetworkPolicy{}
+	for i := range nplist.Items {
+		if np := &nplist.Items[i]; np.Name == netpol.DefaultNetworkPolicyName {
+			origins[np.Namespace] = np
 		}
-		tmpaction.Tenant = ns.Labels[gemlabels.LabelTenant]
-		tmpaction.Project = ns.Labels[gemlabels.LabelProject]
-		tmpaction.Environment = ns.Labels[gemlabels.LabelEnvironment]
-		switch kind {
-		case isoKindTenant:
-			tmpaction.TenantISO = isolated
-		case isoKindProject:
-			tmpaction.ProjectISO = isolated
-		case isoKindEnvironment:
-			tmpaction.EnvironmentISO = isolated
+	}
+	for ns, desired := range netpol.TenantNetworkPolicies(tnp.Spec, nslist.Items, cidrs) {
+		action := NetworkPolicyAction{Origin: origins[ns], Modify: desired}
+		switch {
+		case action.Origin == nil && desired == nil:
+			continue
+		case action.Origin == nil:
+			action.action = "create"
+		case desired == nil:
+			action.Modify = action.Origin
+			action.action = "delete"
+		case !equality.Semantic.DeepEqual(action.Origin.Spec, desired.Spec) ||
+			!equality.Semantic.DeepDerivative(desired.Labels, action.Origin.Labels):
+			modify := action.Origin.DeepCopy()
+			modify.Spec = desired.Spec
+			modify.Labels = labels.Merge(modify.Labels, desired.Labels)
+			action.Modify = modify
+			action.action = "update"
+		default:
+			continue
 		}
-		tmpaction.Labels = maps.GetLabels(ns.Labels, gemlabels.CommonLabels)
-		st[ns.Name] = tmpaction
+		st[ns] = action
 	}
+	return nil
 }
@@ -32,6 +32,7 @@ import (
 	"kubegems.io/kubegems/pkg/utils/set"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
@@ -40,7 +41,8 @@ import (
 // ServiceentryReconciler 用于为对开启 虚拟域名的 namespace 中service创建与虚拟域名相同的 serviceentry
 // 功能：
 // 1. 观察 namespace 是否具有虚拟空间标志 annotation "kubegems.io/virtualdomain={virtualdomain name}"
-// 2. 若有，则为该namespace下的service创建一个virtual service，并设置其hosts 为 {servicename}.{virtualservicename}
+// 2. 若有，则为该namespace下的service创建一个serviceentry，并设置其hosts 为 {servicename}.{virtualdomain}, 同名的 virtualservice 也会增加这些 hosts
+// serviceentry 仅在所在的 namespace 可见, 不同环境中的同名服务使用相同的域名不会冲突
 // 处理流程：
 // 1. 若 service 变化，则判断该 namespace 是否具有 annotation "kubegems.io/virtualdomain={virtualdomain name}"
 // 2. 判断 service 是否具有annotation "kubegems.io/virtualdomain={virtualdomain name}"
@@ -176,9 +178,15 @@ func (r *ServiceentryReconciler) OnChange(ctx context.Context, svc *corev1.Servi
 			Endpoints:  []*istionetworkingv1beta1.WorkloadEntry{{Address: svc.Name + "." + svc.Namespace}},
 			Location:   istionetworkingv1beta1.ServiceEntry_MESH_INTERNAL,
 			Resolution: istionetworkingv1beta1.ServiceEntry_DNS,
+			ExportTo:   []string{"."},
 		},
 	}
 
+	// service 删除时由 gc 删除 serviceentry
+	if err := controllerutil.SetControllerReference(svc, se, r.Scheme); err != nil {
+		return ctrl.Result{}, err
+	}
+
 	// ensure
 	exist := &istioclientworkingv1beta1.ServiceEntry{}
 	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(se), exist); err != nil {
@@ -191,10 +199,11 @@ func (r *ServiceentryReconciler) OnChange(ctx context.Context, svc *corev1.Servi
 		return ctrl.Result{}, err
 	}
 	// update
-	if reflect.DeepEqual(exist.Spec, se.Spec) {
+	if reflect.DeepEqual(exist.Spec, se.Spec) && metav1.IsControlledBy(exist, svc) {
 		return ctrl.Result{}, nil
 	}
 	exist.Spec = se.Spec
+	exist.OwnerReferences = se.OwnerReferences
 	if err := r.Client.Update(ctx, exist); err != nil {
 		return ctrl.Result{Requeue: true}, err
 	}
@@ -210,21 +219,58 @@ func (r *ServiceentryReconciler) updateVirtualServiceHosts(ctx context.Context,
 		return err
 	}
 
-	sets := set.NewSet[string]()
-	sets.Append(svc.Name)
-	// sets.Append(vs.Spec.Hosts...)
-	sets.Append(hosts...)
-
-	desiredhosts := sets.Slice()
-	if reflect.DeepEqual(vs.Spec.Hosts, desiredhosts) {
+	desiredhosts, added := mergeVirtualServiceHosts(vs.Spec.Hosts, vs.Annotations[networking.AnnotationVirtualDomainHosts], hosts)
+	if reflect.DeepEqual(vs.Spec.Hosts, desiredhosts) && vs.Annotations[networking.AnnotationVirtualDomainHosts] == added {
 		return nil
 	}
-	r.Log.Info("update virtualservice hosts", "virtualservice", vs, "hosts", desiredhosts)
+	r.Log.Info("update virtualservice hosts", "virtualservice", client.ObjectKeyFromObject(vs), "hosts", desiredhosts)
 
 	vs.Spec.Hosts = desiredhosts
+	if added == "" {
+		delete(vs.Annotations, networking.AnnotationVirtualDomainHosts)
+	} else {
+		if vs.Annotations == nil {
+			vs.Annotations = map[string]string{}
+		}
+		vs.Annotations[networking.AnnotationVirtualDomainHosts] = added
+	}
 	return r.Client.Update(ctx, vs)
 }
 
+// mergeVirtualServiceHosts 保留 virtualservice 上原有的 hosts, 替换上次由虚拟域名添加的 hosts(added)
+// 返回新的 hosts 及新的 added
+func mergeVirtualServiceHosts(current []string, added string, domainhosts []string) ([]string, string) {
+	previous := set.NewSet[string]()
+	for _, host := range strings.Split(added, ",") {
+		if host != "" {
+			previous.Append(host)
+		}
+	}
+	desired := set.NewSet[string]().Append(domainhosts...)
+	hosts := []string{}
+	exists := set.NewSet[string]()
+	for _, host := range current {
+		if previous.Has(host) && !desired.Has(host) {
+			continue
+		}
+		hosts = append(hosts, host)
+		exists.Append(host)
+	}
+	for _, host := range desired.Slice() {
+		if !exists.Has(host) {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts, strings.Join(desired.Slice(), ",")
+}
+
+// OnVirtualServiceChangeFunc virtualservice 变化时 enqueue 同名的 service, 重新合并虚拟域名的 hosts
+func OnVirtualServiceChangeFunc() handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		return []reconcile.Request{{NamespacedName: client.ObjectKeyFromObject(obj)}}
+	}
+}
+
 func OnNamespceChangeFunc(cli client.Client) handler.MapFunc {
 	return func(obj client.Object) []reconcile.Request {
 		switch data := obj.(type) {
@@ -247,9 +293,16 @@ func OnNamespceChangeFunc(cli client.Client) handler.MapFunc {
 }
 
 func (r *ServiceentryReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	builder := ctrl.NewControllerManagedBy(mgr).
 		For(&corev1.Service{}).
 		// 当ns发生变化时，enqueue 所有该空间下的service
-		Watches(&source.Kind{Type: &corev1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(OnNamespceChangeFunc(mgr.GetClient()))).
-		Complete(r)
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(OnNamespceChangeFunc(mgr.GetClient())))
+	// virtualservice 被应用编排覆盖时重新添加虚拟域名的 hosts, 未安装 istio 时没有 virtualservice crd, 不能 watch
+	vsgvk := istioclientworkingv1beta1.SchemeGroupVersion.WithKind("VirtualService")
+	if _, err := mgr.GetRESTMapper().RESTMapping(vsgvk.GroupKind(), vsgvk.Version); err == nil {
+		builder = builder.Watches(&source.Kind{Type: &istioclientworkingv1beta1.VirtualService{}}, handler.EnqueueRequestsFromMapFunc(OnVirtualServiceChangeFunc()))
+	} else {
+		r.Log.Info("virtualservice crd not found, skip watching virtualservices", "err", err.Error())
+	}
+	return builder.Complete(r)
 }
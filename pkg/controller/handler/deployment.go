@@ -51,7 +51,8 @@ func (h *DepoymentHandler) Update(e event.UpdateEvent, r workqueue.RateLimitingI
 		return
 	}
 
-	if newDep.Status.AvailableReplicas != oldDep.Status.AvailableReplicas {
+	// generation 变化时需要检查调度配置是否被 operator 覆盖, 调度配置未变化时 reconcile 不会 patch, 不会循环触发
+	if newDep.Status.AvailableReplicas != oldDep.Status.AvailableReplicas || newDep.Generation != oldDep.Generation {
 		h.requeueTenantGateway(oldDep.OwnerReferences, r)
 	}
 }
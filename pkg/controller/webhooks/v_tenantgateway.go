@@ -66,6 +66,13 @@ func (r *ResourceValidate) ValidateTenantGateway(ctx context.Context, req admiss
 			return admission.Denied(fmt.Sprintf("Gateway baseDomain %s must has a wildcard '*'", tg.Spec.BaseDomain))
 		}
 
+		if err := validateGatewayTLS(tg.Spec.TLS); err != nil {
+			return admission.Denied(err.Error())
+		}
+		if err := validateGatewayAvailability(tg.Spec); err != nil {
+			return admission.Denied(err.Error())
+		}
+
 		// 校验gateway、ingress是否同步
 		ingressList := networkingv1.IngressList{}
 		if err := r.Client.List(ctx, &ingressList, client.MatchingLabels(map[string]string{
@@ -82,3 +89,50 @@ func (r *ResourceValidate) ValidateTenantGateway(ctx context.Context, req admiss
 		return admission.Allowed("pass")
 	}
 }
+
+func validateGatewayTLS(tls *gemsv1beta1.TLS) error {
+	if tls == nil {
+		return nil
+	}
+	isSecretName := func(s string) bool {
+		namespace, name, ok := strings.Cut(s, "/")
+		return ok && len(validation.IsDNS1123Label(namespace)) == 0 && len(validation.IsDNS1123Subdomain(name)) == 0
+	}
+	if tls.DefaultCertificate != "" && !isSecretName(tls.DefaultCertificate) {
+		return fmt.Errorf("gateway default certificate %s not valid, must be namespace/name", tls.DefaultCertificate)
+	}
+	hosts := map[string]bool{}
+	for _, h := range tls.Hosts {
+		if errs := validation.IsDNS1123Subdomain(strings.TrimPrefix(h.Host, "*.")); len(errs) > 0 {
+			return fmt.Errorf("gateway certificate host %s not valid: %s", h.Host, strings.Join(errs, ", "))
+		}
+		if hosts[h.Host] {
+			return fmt.Errorf("gateway certificate host %s duplicated", h.Host)
+		}
+		hosts[h.Host] = true
+		switch {
+		case h.ACME && (tls.ACME == nil || tls.ACME.Issuer == ""):
+			return fmt.Errorf("gateway certificate host %s uses acme but acme issuer not configured", h.Host)
+		case !h.ACME && !isSecretName(h.SecretName):
+			return fmt.Errorf("gateway certificate secret %s of host %s not valid, must be namespace/name", h.SecretName, h.Host)
+		}
+	}
+	return nil
+}
+
+func validateGatewayAvailability(spec gemsv1beta1.TenantGatewaySpec) error {
+	if as := spec.Autoscaling; as != nil {
+		if as.TargetCPUUtilizationPercentage == nil && as.TargetRequestsPerSecond == nil {
+			return fmt.Errorf("gateway autoscaling must set at least one of cpu or requests per second target")
+		}
+		if as.MinReplicas != nil && *as.MinReplicas > as.MaxReplicas {
+			return fmt.Errorf("gateway autoscaling minReplicas %d greater than maxReplicas %d", *as.MinReplicas, as.MaxReplicas)
+		}
+	}
+	if pdb := spec.PodDisruptionBudget; pdb != nil {
+		if (pdb.MinAvailable == nil) == (pdb.MaxUnavailable == nil) {
+			return fmt.Errorf("gateway podDisruptionBudget must set exactly one of minAvailable and maxUnavailable")
+		}
+	}
+	return nil
+}
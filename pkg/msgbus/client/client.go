@@ -59,8 +59,8 @@ type MsgRequest struct {
 	AffectedUsers *set.Set[uint]
 }
 
-func (cli *MsgBusClient) Send(msgreq *MsgRequest) {
-	msg := msgbus.NotifyMessage{
+func newNotifyMessage(msgreq *MsgRequest) msgbus.NotifyMessage {
+	return msgbus.NotifyMessage{
 		MessageType: msgreq.MessageType,
 		EventKind:   msgreq.EventKind,
 		Content: msgbus.MessageContent{
@@ -74,6 +74,10 @@ func (cli *MsgBusClient) Send(msgreq *MsgRequest) {
 			AffectedUsers: msgreq.AffectedUsers.Slice(),
 		},
 	}
+}
+
+func (cli *MsgBusClient) Send(msgreq *MsgRequest) {
+	msg := newNotifyMessage(msgreq)
 
 	o, _ := json.Marshal(msgbus.MessageTarget{
 		Message: msg,
@@ -103,6 +107,15 @@ func (cli *MsgBusClient) Send(msgreq *MsgRequest) {
 	}
 
 	// message save to DB
+	cli.save(msg, msgreq)
+}
+
+// Save 只将消息保存至数据库, 不进行实时推送, 用于没有用户凭证的后台任务
+func (cli *MsgBusClient) Save(msgreq *MsgRequest) {
+	cli.save(newNotifyMessage(msgreq), msgreq)
+}
+
+func (cli *MsgBusClient) save(msg msgbus.NotifyMessage, msgreq *MsgRequest) {
 	now := time.Now()
 	if msg.MessageType == msgbus.Message || msg.MessageType == msgbus.Approve {
 		contentJson, _ := json.Marshal(msg.Content)
@@ -67,6 +67,7 @@ type ProxyObject struct {
 	Version          string
 	Resource         string
 	Action           string
+	RecordingID      string // 终端会话录像ID, 非空时关联到审计日志
 }
 
 func (p *ProxyObject) InNamespace() bool {
@@ -81,6 +82,8 @@ type DefaultAuditInstance struct {
 	cache         *cache.ModelCache
 	db            *gorm.DB
 	logQueue      chan models.AuditLog
+	sinks         []Sink
+	eventQueue    chan *Event
 }
 
 func NewAuditMiddleware(db *gorm.DB, cache *cache.ModelCache, uinterface aaa.ContextUserOperator) *DefaultAuditInstance {
@@ -89,10 +92,16 @@ func NewAuditMiddleware(db *gorm.DB, cache *cache.ModelCache, uinterface aaa.Con
 		logQueue:      make(chan models.AuditLog, 1000),
 		cache:         cache,
 		userinterface: uinterface,
+		eventQueue:    make(chan *Event, sinkQueueSize),
 	}
 	return audit
 }
 
+// SetSinks 设置实时推送审计事件的外部系统, 需要在 Consumer 之前调用
+func (audit *DefaultAuditInstance) SetSinks(sinks ...Sink) {
+	audit.sinks = sinks
+}
+
 func (audit *DefaultAuditInstance) AuditProxyFunc(c *gin.Context, proxyobj *ProxyObject) {
 	if slice.ContainStr(normalActions, c.Request.Method) {
 		return
@@ -123,6 +132,9 @@ func (audit *DefaultAuditInstance) WebsocketAuditFunc(username string, parents [
 			tags["namespace"] = p.GetNamespace()
 		}
 	}
+	if proxyobj.RecordingID != "" {
+		tags["recording"] = proxyobj.RecordingID
+	}
 	module := proxyobj.Name
 	operation := proxyobj.Action
 	return func(cmd string) {
@@ -155,20 +167,37 @@ func (audit *DefaultAuditInstance) Log(username, module, tenant, operation, name
 }
 
 func (audit *DefaultAuditInstance) Consumer(ctx context.Context) error {
+	if len(audit.sinks) > 0 {
+		go dispatchEvents(ctx, audit.sinks, audit.eventQueue)
+	}
 	for {
 		select {
 		case <-ctx.Done():
 			log.Info("audit log consumer exit")
 			return nil
 		case auditLog := <-audit.logQueue:
-			if err := audit.db.Create(&auditLog).Error; err != nil {
+			if err := appendToChain(ctx, audit.db, &auditLog); err != nil {
 				o, _ := json.Marshal(auditLog)
 				log.Errorf("can't record audit log: (%s), err: %v", string(o), err)
+				continue
 			}
+			audit.publish(&auditLog)
 		}
 	}
 }
 
+// publish 推送队列已满时丢弃, 接收方可以根据 prevHash 发现并从数据库中补齐
+func (audit *DefaultAuditInstance) publish(auditLog *models.AuditLog) {
+	if len(audit.sinks) == 0 {
+		return
+	}
+	select {
+	case audit.eventQueue <- NewEvent(auditLog):
+	default:
+		log.Warnf("audit event queue is full, event %d dropped", auditLog.ID)
+	}
+}
+
 func (audit *DefaultAuditInstance) Middleware() func(c *gin.Context) {
 	return audit.SaveAuditLog
 }
@@ -0,0 +1,214 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"kubegems.io/kubegems/pkg/service/models"
+)
+
+const (
+	auditLogChainID = 1
+	// 校验时每批读取的审计日志数量
+	verifyBatchSize = 1000
+	// 校验结果中最多返回的断链数量
+	maxChainBreaks = 100
+)
+
+type ChainBreak struct {
+	ID     uint   `json:"id"`
+	Reason string `json:"reason"`
+}
+
+type ChainVerification struct {
+	Valid   bool `json:"valid"`
+	Checked int  `json:"checked"`
+	// 校验的起始位置, 之前的审计日志已归档
+	ArchivedID uint         `json:"archivedID"`
+	FirstID    uint         `json:"firstID"`
+	LastID     uint         `json:"lastID"`
+	Breaks     []ChainBreak `json:"breaks"`
+	VerifiedAt time.Time    `json:"verifiedAt"`
+}
+
+// HashAuditLog 计算审计日志的哈希, json 字段使用规范化的格式, 不受数据库存储格式的影响
+func HashAuditLog(prevHash string, auditLog *models.AuditLog) string {
+	content, _ := json.Marshal(struct {
+		PrevHash  string          `json:"prevHash"`
+		CreatedAt string          `json:"createdAt"`
+		Username  string          `json:"username"`
+		Tenant    string          `json:"tenant"`
+		Module    string          `json:"module"`
+		Name      string          `json:"name"`
+		Action    string          `json:"action"`
+		Success   bool            `json:"success"`
+		ClientIP  string          `json:"clientIP"`
+		Labels    json.RawMessage `json:"labels"`
+		RawData   json.RawMessage `json:"rawData"`
+	}{
+		PrevHash:  prevHash,
+		CreatedAt: auditLog.CreatedAt.UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+		Username:  auditLog.Username,
+		Tenant:    auditLog.Tenant,
+		Module:    auditLog.Module,
+		Name:      auditLog.Name,
+		Action:    auditLog.Action,
+		Success:   auditLog.Success,
+		ClientIP:  auditLog.ClientIP,
+		Labels:    canonicalJSON(auditLog.Labels),
+		RawData:   canonicalJSON(auditLog.RawData),
+	})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalJSON 重新编码 json, 对象的 key 有序且没有多余的空白
+func canonicalJSON(data []byte) json.RawMessage {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return json.RawMessage("null")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		// 非 json 内容按字符串处理
+		quoted, _ := json.Marshal(string(data))
+		return quoted
+	}
+	canonical, _ := json.Marshal(v)
+	return canonical
+}
+
+// appendToChain 在事务中锁定哈希链, 计算哈希并写入审计日志, 多个实例同时写入时依然有序
+func appendToChain(ctx context.Context, db *gorm.DB, auditLog *models.AuditLog) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		chain, err := lockChain(tx)
+		if err != nil {
+			return err
+		}
+		// 数据库时间精度为毫秒, 超出的部分会被四舍五入导致哈希不一致
+		auditLog.CreatedAt = time.Now().Truncate(time.Millisecond)
+		auditLog.PrevHash = chain.LastHash
+		auditLog.Hash = HashAuditLog(auditLog.PrevHash, auditLog)
+		if err := tx.Create(auditLog).Error; err != nil {
+			return err
+		}
+		return tx.Model(chain).Updates(map[string]interface{}{"last_id": auditLog.ID, "last_hash": auditLog.Hash}).Error
+	})
+}
+
+// ArchiveChain 审计日志归档后记录归档的位置, 需要与删除审计日志在同一事务中
+func ArchiveChain(tx *gorm.DB, last *models.AuditLog) error {
+	if last.Hash == "" {
+		return nil
+	}
+	chain, err := lockChain(tx)
+	if err != nil {
+		return err
+	}
+	if last.ID <= chain.ArchivedID {
+		return nil
+	}
+	return tx.Model(chain).Updates(map[string]interface{}{"archived_id": last.ID, "archived_hash": last.Hash}).Error
+}
+
+func lockChain(tx *gorm.DB) (*models.AuditLogChain, error) {
+	chain := &models.AuditLogChain{ID: auditLogChainID}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(chain).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Take(chain, auditLogChainID).Error; err != nil {
+		return nil, err
+	}
+	return chain, nil
+}
+
+// VerifyChain 从归档位置开始校验审计日志哈希链, 可以发现被修改, 删除或插入的审计日志
+// 启用哈希链之前的审计日志没有哈希, 不参与校验
+func VerifyChain(ctx context.Context, db *gorm.DB) (*ChainVerification, error) {
+	chain := &models.AuditLogChain{}
+	if err := db.WithContext(ctx).Where("id = ?", auditLogChainID).Limit(1).Find(chain).Error; err != nil {
+		return nil, err
+	}
+	verifier := newChainVerifier(chain.ArchivedHash)
+	verifier.result.ArchivedID = chain.ArchivedID
+
+	lastid := chain.ArchivedID
+	for {
+		auditlogs := []models.AuditLog{}
+		if err := db.WithContext(ctx).Unscoped().
+			Where("id > ? AND hash <> ''", lastid).
+			Order("id").
+			Limit(verifyBatchSize).
+			Find(&auditlogs).Error; err != nil {
+			return nil, err
+		}
+		for i := range auditlogs {
+			verifier.add(&auditlogs[i])
+		}
+		if len(auditlogs) < verifyBatchSize {
+			break
+		}
+		lastid = auditlogs[len(auditlogs)-1].ID
+	}
+	return verifier.finish(chain.LastID, chain.LastHash), nil
+}
+
+type chainVerifier struct {
+	prevHash string
+	result   *ChainVerification
+}
+
+func newChainVerifier(prevHash string) *chainVerifier {
+	return &chainVerifier{prevHash: prevHash, result: &ChainVerification{Breaks: []ChainBreak{}}}
+}
+
+func (v *chainVerifier) add(auditLog *models.AuditLog) {
+	if v.result.FirstID == 0 {
+		v.result.FirstID = auditLog.ID
+	}
+	v.result.LastID = auditLog.ID
+	v.result.Checked++
+	if auditLog.PrevHash != v.prevHash {
+		v.broken(auditLog.ID, "previous hash mismatch, records before it have been deleted or inserted")
+	}
+	if HashAuditLog(auditLog.PrevHash, auditLog) != auditLog.Hash {
+		v.broken(auditLog.ID, "hash mismatch, record has been modified")
+	}
+	v.prevHash = auditLog.Hash
+}
+
+func (v *chainVerifier) finish(lastID uint, lastHash string) *ChainVerification {
+	if v.prevHash != lastHash {
+		v.broken(lastID, "latest record not found, records at the end have been deleted")
+	}
+	v.result.Valid = len(v.result.Breaks) == 0
+	v.result.VerifiedAt = time.Now()
+	return v.result
+}
+
+func (v *chainVerifier) broken(id uint, reason string) {
+	if len(v.result.Breaks) < maxChainBreaks {
+		v.result.Breaks = append(v.result.Breaks, ChainBreak{ID: id, Reason: reason})
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/datatypes"
+	"kubegems.io/kubegems/pkg/service/models"
+)
+
+func TestHashAuditLog(t *testing.T) {
+	created := time.Date(2022, 6, 1, 8, 0, 0, 123000000, time.FixedZone("CST", 8*3600))
+	auditLog := &models.AuditLog{
+		CreatedAt: created,
+		Username:  "admin",
+		Module:    "应用",
+		Action:    "创建",
+		Labels:    datatypes.JSON(`{"tenant":"t1","project":"p1"}`),
+		RawData:   datatypes.JSON(`{"request":{"size":1.50,"body":"<a>"}}`),
+	}
+	hash := HashAuditLog("", auditLog)
+
+	// 数据库中 json 格式及时区的变化不影响哈希
+	stored := *auditLog
+	stored.CreatedAt = created.UTC()
+	stored.Labels = datatypes.JSON(`{"project": "p1", "tenant": "t1"}`)
+	stored.RawData = datatypes.JSON(`{"request": {"body": "<a>", "size": 1.50}}`)
+	if got := HashAuditLog("", &stored); got != hash {
+		t.Errorf("hash of reformatted record = %s, want %s", got, hash)
+	}
+
+	modified := *auditLog
+	modified.Success = true
+	if HashAuditLog("", &modified) == hash {
+		t.Error("hash of modified record should change")
+	}
+	if HashAuditLog("prev", auditLog) == hash {
+		t.Error("hash with different previous hash should change")
+	}
+}
+
+func TestChainVerifier(t *testing.T) {
+	newChain := func(n int) []models.AuditLog {
+		logs := []models.AuditLog{}
+		prev := ""
+		for i := 1; i <= n; i++ {
+			l := models.AuditLog{ID: uint(i), Username: "admin", Action: "创建", CreatedAt: time.Unix(int64(i), 0)}
+			l.PrevHash = prev
+			l.Hash = HashAuditLog(prev, &l)
+			prev = l.Hash
+			logs = append(logs, l)
+		}
+		return logs
+	}
+	verify := func(archivedHash string, logs []models.AuditLog, last models.AuditLog) []ChainBreak {
+		v := newChainVerifier(archivedHash)
+		for i := range logs {
+			v.add(&logs[i])
+		}
+		return v.finish(last.ID, last.Hash).Breaks
+	}
+
+	tests := []struct {
+		name   string
+		verify func() []ChainBreak
+		want   []ChainBreak
+	}{
+		{
+			name: "valid",
+			verify: func() []ChainBreak {
+				logs := newChain(3)
+				return verify("", logs, logs[2])
+			},
+			want: []ChainBreak{},
+		},
+		{
+			name: "archived",
+			verify: func() []ChainBreak {
+				logs := newChain(3)
+				return verify(logs[0].Hash, logs[1:], logs[2])
+			},
+			want: []ChainBreak{},
+		},
+		{
+			name: "modified",
+			verify: func() []ChainBreak {
+				logs := newChain(3)
+				logs[1].Username = "someone"
+				return verify("", logs, logs[2])
+			},
+			want: []ChainBreak{{ID: 2, Reason: "hash mismatch, record has been modified"}},
+		},
+		{
+			name: "deleted",
+			verify: func() []ChainBreak {
+				logs := newChain(3)
+				return verify("", []models.AuditLog{logs[0], logs[2]}, logs[2])
+			},
+			want: []ChainBreak{{ID: 3, Reason: "previous hash mismatch, records before it have been deleted or inserted"}},
+		},
+		{
+			name: "latest deleted",
+			verify: func() []ChainBreak {
+				logs := newChain(3)
+				return verify("", logs[:2], logs[2])
+			},
+			want: []ChainBreak{{ID: 3, Reason: "latest record not found, records at the end have been deleted"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.verify(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("breaks = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
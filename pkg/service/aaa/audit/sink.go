@@ -0,0 +1,296 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/models"
+)
+
+const (
+	sinkQueueSize   = 1000
+	sinkMaxAttempts = 3
+	sinkTimeout     = 10 * time.Second
+	// RFC5424 facility: log audit(13), severity: informational(6)
+	syslogPriority = 13*8 + 6
+	syslogAppName  = "kubegems"
+	syslogMsgID    = "audit"
+)
+
+type Options struct {
+	SyslogAddr   string `json:"syslogAddr,omitempty" description:"stream audit events to syslog in RFC5424 format, eg. udp://siem:514, tcp://siem:514, tls://siem:6514"`
+	WebhookURL   string `json:"webhookURL,omitempty" description:"stream audit events as json to the webhook"`
+	WebhookToken string `json:"webhookToken,omitempty" description:"bearer token of the audit webhook"`
+	KafkaURL     string `json:"kafkaURL,omitempty" description:"stream audit events to kafka via kafka rest proxy(v2 api), eg. http://kafka-rest:8082"`
+	KafkaTopic   string `json:"kafkaTopic,omitempty" description:"kafka topic of audit events"`
+}
+
+func NewDefaultOptions() *Options {
+	return &Options{KafkaTopic: "kubegems-audit"}
+}
+
+// Event 推送至外部系统的审计事件
+type Event struct {
+	ID        uint            `json:"id"`
+	Timestamp time.Time       `json:"timestamp"`
+	Username  string          `json:"username"`
+	Tenant    string          `json:"tenant"`
+	Module    string          `json:"module"`
+	Name      string          `json:"name"`
+	Action    string          `json:"action"`
+	Success   bool            `json:"success"`
+	ClientIP  string          `json:"clientIP"`
+	Labels    json.RawMessage `json:"labels,omitempty"`
+	RawData   json.RawMessage `json:"rawData,omitempty"`
+	PrevHash  string          `json:"prevHash"`
+	Hash      string          `json:"hash"`
+}
+
+func NewEvent(auditLog *models.AuditLog) *Event {
+	return &Event{
+		ID:        auditLog.ID,
+		Timestamp: auditLog.CreatedAt,
+		Username:  auditLog.Username,
+		Tenant:    auditLog.Tenant,
+		Module:    auditLog.Module,
+		Name:      auditLog.Name,
+		Action:    auditLog.Action,
+		Success:   auditLog.Success,
+		ClientIP:  auditLog.ClientIP,
+		Labels:    json.RawMessage(auditLog.Labels),
+		RawData:   json.RawMessage(auditLog.RawData),
+		PrevHash:  auditLog.PrevHash,
+		Hash:      auditLog.Hash,
+	}
+}
+
+// Sink 实时推送审计事件的外部系统, 接收方可以根据 prevHash 发现丢失的事件
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, event *Event) error
+}
+
+// NewSinks 根据配置创建审计事件的推送目标
+func NewSinks(options *Options) ([]Sink, error) {
+	sinks := []Sink{}
+	if options == nil {
+		return sinks, nil
+	}
+	if options.SyslogAddr != "" {
+		sink, err := NewSyslogSink(options.SyslogAddr)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if options.WebhookURL != "" {
+		sinks = append(sinks, &WebhookSink{URL: options.WebhookURL, Token: options.WebhookToken})
+	}
+	if options.KafkaURL != "" {
+		if options.KafkaTopic == "" {
+			return nil, fmt.Errorf("kafka topic is required")
+		}
+		sinks = append(sinks, &KafkaRestSink{URL: options.KafkaURL, Topic: options.KafkaTopic})
+	}
+	return sinks, nil
+}
+
+// SyslogSink 使用 RFC5424 格式发送至 syslog, tcp/tls 使用 RFC6587 octet counting 分帧
+type SyslogSink struct {
+	Network  string
+	Address  string
+	hostname string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func NewSyslogSink(address string) (*SyslogSink, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "udp", "tcp", "tls":
+	default:
+		return nil, fmt.Errorf("unsupported syslog address %s, scheme must be one of udp, tcp or tls", address)
+	}
+	hostname, _ := os.Hostname()
+	return &SyslogSink{Network: u.Scheme, Address: u.Host, hostname: hostname}, nil
+}
+
+func (s *SyslogSink) Name() string {
+	return "syslog"
+}
+
+func (s *SyslogSink) Send(ctx context.Context, event *Event) error {
+	msg, err := formatSyslog(event, s.hostname)
+	if err != nil {
+		return err
+	}
+	if s.Network != "udp" {
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		if s.conn, err = s.dial(ctx); err != nil {
+			return err
+		}
+	}
+	_ = s.conn.SetWriteDeadline(time.Now().Add(sinkTimeout))
+	if _, err := s.conn.Write(msg); err != nil {
+		// 下次发送时重新连接
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (s *SyslogSink) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: sinkTimeout}
+	if s.Network == "tls" {
+		return (&tls.Dialer{NetDialer: dialer}).DialContext(ctx, "tcp", s.Address)
+	}
+	return dialer.DialContext(ctx, s.Network, s.Address)
+}
+
+// formatSyslog <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func formatSyslog(event *Event, hostname string) ([]byte, error) {
+	content, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	if hostname == "" {
+		hostname = "-"
+	}
+	header := fmt.Sprintf("<%d>1 %s %s %s %d %s - ",
+		syslogPriority, event.Timestamp.UTC().Format("2006-01-02T15:04:05.000000Z07:00"), hostname, syslogAppName, os.Getpid(), syslogMsgID)
+	return append([]byte(header), content...), nil
+}
+
+// WebhookSink 使用 POST 发送 json 格式的审计事件
+type WebhookSink struct {
+	URL   string
+	Token string
+}
+
+func (s *WebhookSink) Name() string {
+	return "webhook"
+}
+
+func (s *WebhookSink) Send(ctx context.Context, event *Event) error {
+	content, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": []string{"application/json"}}
+	if s.Token != "" {
+		header.Set("Authorization", "Bearer "+s.Token)
+	}
+	return postSink(ctx, s.URL, header, content)
+}
+
+// KafkaRestSink 通过 kafka rest proxy 写入 kafka, 使用审计日志 id 作为消息 key
+// https://docs.confluent.io/platform/current/kafka-rest/api.html#post--topics-(string-topic_name)
+type KafkaRestSink struct {
+	URL   string
+	Topic string
+}
+
+func (s *KafkaRestSink) Name() string {
+	return "kafka"
+}
+
+func (s *KafkaRestSink) Send(ctx context.Context, event *Event) error {
+	content, err := json.Marshal(map[string]interface{}{
+		"records": []map[string]interface{}{{"key": strconv.FormatUint(uint64(event.ID), 10), "value": event}},
+	})
+	if err != nil {
+		return err
+	}
+	header := http.Header{
+		"Content-Type": []string{"application/vnd.kafka.json.v2+json"},
+		"Accept":       []string{"application/vnd.kafka.v2+json"},
+	}
+	return postSink(ctx, strings.TrimSuffix(s.URL, "/")+"/topics/"+url.PathEscape(s.Topic), header, content)
+}
+
+func postSink(ctx context.Context, address string, header http.Header, content []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, sinkTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, address, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("post audit event to %s: %s %s", req.URL.Host, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// dispatchEvents 将审计事件依次推送至各目标, 失败时重试, 不阻塞审计日志的写入
+func dispatchEvents(ctx context.Context, sinks []Sink, events <-chan *Event) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-events:
+			for _, sink := range sinks {
+				if err := sendWithRetry(ctx, sink, event); err != nil {
+					log.Error(err, "send audit event", "sink", sink.Name(), "id", event.ID)
+				}
+			}
+		}
+	}
+}
+
+func sendWithRetry(ctx context.Context, sink Sink, event *Event) error {
+	var err error
+	for attempt := 1; attempt <= sinkMaxAttempts; attempt++ {
+		if err = sink.Send(ctx, event); err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(attempt) * time.Second):
+		}
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestFormatSyslog(t *testing.T) {
+	event := &Event{ID: 1, Timestamp: time.Date(2022, 6, 1, 8, 0, 0, 0, time.UTC), Username: "admin", Hash: "abc"}
+	msg, err := formatSyslog(event, "kubegems-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pattern := regexp.MustCompile(`^<110>1 2022-06-01T08:00:00\.000000Z kubegems-api kubegems \d+ audit - \{.*\}$`)
+	if !pattern.Match(msg) {
+		t.Errorf("formatSyslog() = %s", msg)
+	}
+}
+
+func TestHTTPSinks(t *testing.T) {
+	var gotPath, gotContentType, gotAuth string
+	var gotBody map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotContentType, gotAuth = r.URL.Path, r.Header.Get("Content-Type"), r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		gotBody = map[string]interface{}{}
+		_ = json.Unmarshal(body, &gotBody)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	event := &Event{ID: 42, Username: "admin", Hash: "abc"}
+	ctx := context.Background()
+
+	if err := (&WebhookSink{URL: server.URL + "/hook", Token: "secret"}).Send(ctx, event); err != nil {
+		t.Fatal(err)
+	}
+	if gotPath != "/hook" || gotContentType != "application/json" || gotAuth != "Bearer secret" || gotBody["hash"] != "abc" {
+		t.Errorf("webhook request = %s %s %s %v", gotPath, gotContentType, gotAuth, gotBody)
+	}
+
+	if err := (&KafkaRestSink{URL: server.URL + "/", Topic: "audit"}).Send(ctx, event); err != nil {
+		t.Fatal(err)
+	}
+	records, _ := gotBody["records"].([]interface{})
+	if gotPath != "/topics/audit" || gotContentType != "application/vnd.kafka.json.v2+json" || len(records) != 1 {
+		t.Errorf("kafka request = %s %s %v", gotPath, gotContentType, gotBody)
+	} else if record := records[0].(map[string]interface{}); record["key"] != "42" {
+		t.Errorf("kafka record key = %v, want 42", record["key"])
+	}
+
+	if err := (&WebhookSink{URL: server.URL + "/fail"}).Send(ctx, event); err == nil {
+		t.Error("webhook with error status should fail")
+	}
+}
+
+func TestNewSinks(t *testing.T) {
+	if _, err := NewSinks(&Options{SyslogAddr: "http://siem:514"}); err == nil {
+		t.Error("NewSinks() with unsupported syslog scheme should fail")
+	}
+	sinks, err := NewSinks(&Options{SyslogAddr: "tcp://siem:514", WebhookURL: "http://siem/hook", KafkaURL: "http://kafka-rest:8082", KafkaTopic: "audit"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, sink := range sinks {
+		names = append(names, sink.Name())
+	}
+	if want := "[syslog webhook kafka]"; fmt.Sprint(names) != want {
+		t.Errorf("NewSinks() = %v, want %s", names, want)
+	}
+}
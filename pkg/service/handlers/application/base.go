@@ -249,9 +249,16 @@ func (h *BaseHandler) processfunc(c *gin.Context, body interface{}, completes []
 		return processfunc(ctx, *ref)
 	}
 
+	ctx, submitted := WithSubmittedChangeRequests(ctx)
 	data, err := process(ctx)
 	if err != nil {
 		handlers.NotOK(c, err)
+		return
+	}
+	// 转为变更请求的修改视为成功, 返回变更请求
+	if cr := submitted.Last(); cr != nil && !c.Writer.Written() {
+		handlers.OK(c, cr)
+		return
 	}
 	// 如果未曾writer则响应 data，有的处理流程中会使用 sse 则不需要再次响应
 	if data != nil && !c.Writer.Written() {
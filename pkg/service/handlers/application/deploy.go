@@ -28,6 +28,7 @@ import (
 	"kubegems.io/kubegems/pkg/service/handlers/base"
 	"kubegems.io/kubegems/pkg/utils/argo"
 	"kubegems.io/kubegems/pkg/utils/git"
+	"kubegems.io/kubegems/pkg/utils/helm"
 )
 
 const StatusNoArgoApp = "NoArgoApp"
@@ -76,12 +77,14 @@ type ManifestDeploy struct {
 	Contents  []unstructured.Unstructured
 }
 
-func MustNewApplicationDeployHandler(gitoptions *git.Options, argocli *argo.Client, commonbase base.BaseHandler) *ApplicationHandler {
+func MustNewApplicationDeployHandler(gitoptions *git.Options, appstoreoptions *helm.Options, argocli *argo.Client, commonbase base.BaseHandler) *ApplicationHandler {
 	provider, err := git.NewProvider(gitoptions)
 	if err != nil {
 		panic(err)
 	}
 	database := commonbase.GetDataBase()
+	gitremote := NewGitRemoteOptions(gitoptions)
+	provider.SetRemoteResolver(ProjectRemoteResolver(database.DB(), gitremote))
 	agents := commonbase.GetAgents()
 	redis := commonbase.GetRedis()
 
@@ -95,12 +98,13 @@ func MustNewApplicationDeployHandler(gitoptions *git.Options, argocli *argo.Clie
 		Agents:      commonbase.GetAgents(),
 		BaseHandler: base,
 		ArgoCD:      argocli,
+		GitRemote:   gitremote,
 		Manifest: ManifestHandler{
 			BaseHandler:       base,
-			ManifestProcessor: &ManifestProcessor{GitProvider: provider},
+			ManifestProcessor: &ManifestProcessor{GitProvider: provider, Review: &ReviewProcessor{DB: database.DB()}, ImagePolicy: &ImagePolicyProcessor{DB: database.DB()}, GatewayTLS: &GatewayTLSProcessor{DB: database.DB(), Agents: agents}},
 		},
 		Task:                 NewTaskHandler(base),
-		ApplicationProcessor: NewApplicationProcessor(database, provider, argocli, redis, agents),
+		ApplicationProcessor: NewApplicationProcessor(database, provider, argocli, redis, agents, appstoreoptions),
 	}
 	return h
 }
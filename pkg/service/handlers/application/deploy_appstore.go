@@ -16,12 +16,14 @@ package application
 
 import (
 	"context"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
 )
 
 type HelmManifest struct {
@@ -39,6 +41,8 @@ type HelmManifest struct {
 type DeploiedHelm struct {
 	HelmManifest `json:",inline"`
 	Runtime      ManifestRuntime `json:"runtime"`
+	// AvailableVersion 仓库中可升级的版本
+	AvailableVersion string `json:"availableVersion,omitempty"`
 }
 
 // @Tags        Application
@@ -65,6 +69,15 @@ func (h *ApplicationHandler) ListAppstoreApp(c *gin.Context) {
 			return nil, err
 		}
 
+		upgrades := []models.AppstoreUpgrade{}
+		if err := h.GetDataBase().DB().WithContext(ctx).Where("environment_id = ?", c.Param("environment_id")).Find(&upgrades).Error; err != nil {
+			return nil, err
+		}
+		availableVersions := map[string]string{}
+		for _, upgrade := range upgrades {
+			availableVersions[upgrade.ApplicationName] = upgrade.AvailableVersion
+		}
+
 		list := []*DeploiedHelm{}
 		for _, app := range applist.Items {
 			deploied := CompleteDeploiedManifestRuntime(&app, &DeploiedManifest{})
@@ -79,7 +92,8 @@ func (h *ApplicationHandler) ListAppstoreApp(c *gin.Context) {
 					ChartVersion: app.Spec.Source.TargetRevision,
 					Chart:        app.Spec.Source.Chart,
 				},
-				Runtime: deploied.Runtime,
+				Runtime:          deploied.Runtime,
+				AvailableVersion: availableVersions[deploied.Name],
 			}
 			if helm := app.Spec.Source.Helm; helm != nil {
 				deploiedhelm.Values = app.Spec.Source.Helm.Values
@@ -143,6 +157,9 @@ func (h *ApplicationHandler) CreateAppstoreApp(c *gin.Context) {
 
 		// audit
 		h.SetAuditData(c, "部署", "应用商店应用", ref.Name)
+		if err := h.ApplicationProcessor.ValidateAppStoreValues(ctx, body); err != nil {
+			return nil, err
+		}
 		argoapp, err := h.ApplicationProcessor.deployHelmApplication(ctx, ref, body)
 		if err != nil {
 			return nil, err
@@ -151,6 +168,26 @@ func (h *ApplicationHandler) CreateAppstoreApp(c *gin.Context) {
 	})
 }
 
+// @Tags        Application
+// @Summary     应用商店应用升级前的values对比
+// @Description 对比当前部署的 values 与升级到目标 chart 版本后的 values, 并使用目标版本的 schema 校验
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                       true "tenaut id"
+// @Param       project_id     path     int                                       true "project id"
+// @Param       environment_id path     int                                       true "environment_id"
+// @Param       name           path     string                                    true "application name"
+// @Param       body           body     ValuesDiffRequest                         true "目标版本及values"
+// @Success     200            {object} handlers.ResponseStruct{Data=ValuesDiff} "diff"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/appstoreapplications/{name}/valuesdiff [post]
+// @Security    JWT
+func (h *ApplicationHandler) AppstoreAppValuesDiff(c *gin.Context) {
+	body := &ValuesDiffRequest{}
+	h.NamedRefFunc(c, body, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		return h.ApplicationProcessor.AppStoreValuesDiff(ctx, ref, *body)
+	})
+}
+
 // @Tags        Application
 // @Summary     应用商店应用列表
 // @Description 应用商店应用列表
@@ -174,3 +211,76 @@ func (h *ApplicationHandler) RemoveAppstoreApp(c *gin.Context) {
 		return "ok", nil
 	})
 }
+
+// @Tags        Application
+// @Summary     应用商店应用可用的升级
+// @Description 列出环境中应用商店应用的当前版本, 升级策略及仓库中满足约束的最新版本
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                                     true  "tenaut id"
+// @Param       project_id     path     int                                                     true  "project id"
+// @Param       environment_id path     int                                                     true  "environment_id"
+// @Param       refresh        query    bool                                                    false "是否立即从仓库检测, 默认返回最近一次定时检测的结果"
+// @Success     200            {object} handlers.ResponseStruct{Data=[]models.AppstoreUpgrade} "upgrades"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/appstoreupgrades [get]
+// @Security    JWT
+func (h *ApplicationHandler) ListAppstoreUpgrades(c *gin.Context) {
+	h.NoNameRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		if refresh, _ := strconv.ParseBool(c.Query("refresh")); refresh {
+			return h.ApplicationProcessor.CheckAppstoreUpgrades(ctx, ref)
+		}
+		list := []models.AppstoreUpgrade{}
+		if err := h.GetDataBase().DB().WithContext(ctx).
+			Where("environment_id = ?", c.Param("environment_id")).
+			Order("application_name").
+			Find(&list).Error; err != nil {
+			return nil, err
+		}
+		return list, nil
+	})
+}
+
+// @Tags        Application
+// @Summary     设置应用商店应用的升级策略
+// @Description 设置允许升级的 semver 版本范围, 或固定当前版本
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                                   true "tenaut id"
+// @Param       project_id     path     int                                                   true "project id"
+// @Param       environment_id path     int                                                   true "environment_id"
+// @Param       name           path     string                                                true "application name"
+// @Param       body           body     AppstoreUpgradePolicy                                 true "policy"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.AppstoreUpgrade} "upgrade"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/appstoreapplications/{name}/upgradepolicy [put]
+// @Security    JWT
+func (h *ApplicationHandler) SetAppstoreUpgradePolicy(c *gin.Context) {
+	body := &AppstoreUpgradePolicy{}
+	h.NamedRefFunc(c, body, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		h.SetAuditData(c, "设置", "应用商店应用升级策略", ref.Name)
+		return h.ApplicationProcessor.SetAppstoreUpgradePolicy(ctx, ref, *body)
+	})
+}
+
+// @Tags        Application
+// @Summary     批量升级应用商店应用
+// @Description 为每个应用提交升级 chart 并同步的异步任务, 未指定版本时升级至检测到的可用版本, 固定版本的应用需要指定版本
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                                     true "tenaut id"
+// @Param       project_id     path     int                                                     true "project id"
+// @Param       environment_id path     int                                                     true "environment_id"
+// @Param       body           body     AppstoreUpgradeRequest                                  true "applications"
+// @Success     200            {object} handlers.ResponseStruct{Data=[]AppstoreUpgradeResult} "results"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/appstoreupgrades [post]
+// @Security    JWT
+func (h *ApplicationHandler) BatchUpgradeAppstoreApp(c *gin.Context) {
+	body := &AppstoreUpgradeRequest{}
+	h.NoNameRefFunc(c, body, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		names := make([]string, 0, len(body.Applications))
+		for _, item := range body.Applications {
+			names = append(names, item.Name)
+		}
+		h.SetAuditData(c, "升级", "应用商店应用", strings.Join(names, ","))
+		return h.ApplicationProcessor.UpgradeAppstoreApplications(ctx, ref, *body)
+	})
+}
@@ -0,0 +1,333 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils"
+	"kubegems.io/kubegems/pkg/utils/git"
+)
+
+// ChangeRequestDetail 变更请求及其修改的文件
+type ChangeRequestDetail struct {
+	*models.ManifestChangeRequest
+	Diff []git.FileDiff `json:"diff"`
+	// Conflicts 合并时冲突的文件
+	Conflicts []string `json:"conflicts,omitempty"`
+}
+
+// @Tags        Application
+// @Summary     获取环境的编排审核策略
+// @Description 获取环境的编排审核策略
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                                       true "tenaut id"
+// @Param       project_id     path     int                                                       true "project id"
+// @Param       environment_id path     int                                                       true "environment id"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.ManifestReviewPolicy} "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/manifestreviewpolicy [get]
+// @Security    JWT
+func (h *ApplicationHandler) GetManifestReviewPolicy(c *gin.Context) {
+	policy := &models.ManifestReviewPolicy{EnvironmentID: utils.ToUint(c.Param("environment_id"))}
+	if err := h.GetDataBase().DB().Where("environment_id = ?", policy.EnvironmentID).Take(policy).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			handlers.NotOK(c, err)
+			return
+		}
+	}
+	handlers.OK(c, policy)
+}
+
+// @Tags        Application
+// @Summary     设置环境的编排审核策略
+// @Description 开启后该环境编排的修改提交为变更请求, 审核通过后才合并到环境分支
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                                       true "tenaut id"
+// @Param       project_id     path     int                                                       true "project id"
+// @Param       environment_id path     int                                                       true "environment id"
+// @Param       body           body     models.ManifestReviewPolicy                               true "policy"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.ManifestReviewPolicy} "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/manifestreviewpolicy [put]
+// @Security    JWT
+func (h *ApplicationHandler) SetManifestReviewPolicy(c *gin.Context) {
+	policy := &models.ManifestReviewPolicy{}
+	if err := c.BindJSON(policy); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	policy.EnvironmentID = utils.ToUint(c.Param("environment_id"))
+	if policy.Enabled && policy.RequiredApprovals < 1 {
+		policy.RequiredApprovals = 1
+	}
+	h.SetAuditData(c, "设置", "编排审核策略", strconv.Itoa(int(policy.EnvironmentID)))
+	h.SetExtraAuditData(c, models.ResEnvironment, policy.EnvironmentID)
+	if u, exist := h.GetContextUser(c); exist {
+		policy.Creator = u.GetUsername()
+	}
+	if err := h.GetDataBase().DB().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "environment_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"enabled", "required_approvals", "creator", "updated_at"}),
+	}).Create(policy).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, policy)
+}
+
+// @Tags        Application
+// @Summary     编排变更请求列表
+// @Description 编排变更请求列表
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                                                               true  "tenaut id"
+// @Param       project_id     path     int                                                                               true  "project id"
+// @Param       environment_id path     int                                                                               true  "environment id"
+// @Param       status         query    string                                                                            false "Open/Merged/Rejected/Closed"
+// @Param       application    query    string                                                                            false "应用名称"
+// @Param       page           query    int                                                                               false "page"
+// @Param       size           query    int                                                                               false "size"
+// @Success     200            {object} handlers.ResponseStruct{Data=handlers.PageData{List=[]models.ManifestChangeRequest}} "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/changerequests [get]
+// @Security    JWT
+func (h *ApplicationHandler) ListChangeRequests(c *gin.Context) {
+	query, err := handlers.GetQuery(c, nil)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	cond := &handlers.PageQueryCond{
+		Model: "ManifestChangeRequest",
+		Where: []*handlers.QArgs{handlers.Args("environment_id = ?", c.Param("environment_id"))},
+	}
+	if status := c.Query("status"); status != "" {
+		cond.Where = append(cond.Where, handlers.Args("status = ?", status))
+	}
+	if app := c.Query("application"); app != "" {
+		cond.Where = append(cond.Where, handlers.Args("application_name = ?", app))
+	}
+	list := []models.ManifestChangeRequest{}
+	total, page, size, err := query.PageList(h.GetDataBase().DB().Order("id DESC"), cond, &list)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, handlers.Page(total, list, int64(page), int64(size)))
+}
+
+// @Tags        Application
+// @Summary     编排变更请求详情
+// @Description 变更请求详情, 包含评论和修改的文件
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id        path     int                                                    true "tenaut id"
+// @Param       project_id       path     int                                                    true "project id"
+// @Param       environment_id   path     int                                                    true "environment id"
+// @Param       changerequest_id path     int                                                    true "change request id"
+// @Success     200              {object} handlers.ResponseStruct{Data=ChangeRequestDetail} "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/changerequests/{changerequest_id} [get]
+// @Security    JWT
+func (h *ApplicationHandler) GetChangeRequest(c *gin.Context) {
+	h.changeRequestFunc(c, nil, func(ctx context.Context, cr *models.ManifestChangeRequest) (interface{}, error) {
+		if err := h.GetDataBase().DB().Where("change_request_id = ?", cr.ID).Order("id").Find(&cr.Comments).Error; err != nil {
+			return nil, err
+		}
+		diff, err := h.Manifest.ChangeRequestDiff(ctx, cr)
+		if err != nil {
+			return nil, err
+		}
+		return ChangeRequestDetail{ManifestChangeRequest: cr, Diff: diff}, nil
+	})
+}
+
+// @Tags        Application
+// @Summary     评论编排变更请求
+// @Description 评论编排变更请求
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id        path     int                                                        true "tenaut id"
+// @Param       project_id       path     int                                                        true "project id"
+// @Param       environment_id   path     int                                                        true "environment id"
+// @Param       changerequest_id path     int                                                        true "change request id"
+// @Param       body             body     models.ManifestChangeComment                               true "comment"
+// @Success     200              {object} handlers.ResponseStruct{Data=models.ManifestChangeComment} "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/changerequests/{changerequest_id}/comments [post]
+// @Security    JWT
+func (h *ApplicationHandler) CommentChangeRequest(c *gin.Context) {
+	comment := &models.ManifestChangeComment{}
+	h.changeRequestFunc(c, comment, func(ctx context.Context, cr *models.ManifestChangeRequest) (interface{}, error) {
+		comment.ID = 0
+		comment.ChangeRequestID = cr.ID
+		comment.Username = AuthorFromContext(ctx).Name
+		if err := h.GetDataBase().DB().Create(comment).Error; err != nil {
+			return nil, err
+		}
+		return comment, nil
+	})
+}
+
+// @Tags        Application
+// @Summary     审核通过编排变更请求
+// @Description 变更请求的作者不能审核自己的变更
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id        path     int                                                        true "tenaut id"
+// @Param       project_id       path     int                                                        true "project id"
+// @Param       environment_id   path     int                                                        true "environment id"
+// @Param       changerequest_id path     int                                                        true "change request id"
+// @Success     200              {object} handlers.ResponseStruct{Data=models.ManifestChangeRequest} "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/changerequests/{changerequest_id}/approve [post]
+// @Security    JWT
+func (h *ApplicationHandler) ApproveChangeRequest(c *gin.Context) {
+	h.changeRequestFunc(c, nil, func(ctx context.Context, cr *models.ManifestChangeRequest) (interface{}, error) {
+		h.SetAuditData(c, "审核", "编排变更请求", changeRequestAuditName(cr))
+		if err := cr.Approve(AuthorFromContext(ctx).Name); err != nil {
+			return nil, err
+		}
+		if err := h.GetDataBase().DB().Model(cr).Update("approvals", cr.Approvals).Error; err != nil {
+			return nil, err
+		}
+		return cr, nil
+	})
+}
+
+// @Tags        Application
+// @Summary     拒绝或关闭编排变更请求
+// @Description 作者关闭时状态为 Closed, 其他人拒绝时为 Rejected, 变更分支会被删除
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id        path     int                                                        true "tenaut id"
+// @Param       project_id       path     int                                                        true "project id"
+// @Param       environment_id   path     int                                                        true "environment id"
+// @Param       changerequest_id path     int                                                        true "change request id"
+// @Success     200              {object} handlers.ResponseStruct{Data=models.ManifestChangeRequest} "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/changerequests/{changerequest_id}/close [post]
+// @Security    JWT
+func (h *ApplicationHandler) CloseChangeRequest(c *gin.Context) {
+	h.changeRequestFunc(c, nil, func(ctx context.Context, cr *models.ManifestChangeRequest) (interface{}, error) {
+		h.SetAuditData(c, "关闭", "编排变更请求", changeRequestAuditName(cr))
+		if cr.Status != models.ChangeRequestStatusOpen {
+			return nil, fmt.Errorf("change request is %s", cr.Status)
+		}
+		username := AuthorFromContext(ctx).Name
+		status := models.ChangeRequestStatusRejected
+		if username == cr.Author {
+			status = models.ChangeRequestStatusClosed
+		}
+		if err := h.Manifest.CloseChangeRequest(ctx, cr); err != nil {
+			log.FromContextOrDiscard(ctx).Error(err, "delete change branch", "branch", cr.ChangeBranch)
+		}
+		now := time.Now()
+		if err := h.GetDataBase().DB().Model(cr).Updates(map[string]interface{}{
+			"status":    status,
+			"reviewer":  username,
+			"closed_at": &now,
+		}).Error; err != nil {
+			return nil, err
+		}
+		return cr, nil
+	})
+}
+
+// @Tags        Application
+// @Summary     合并编排变更请求
+// @Description 审核通过人数满足策略后合并至环境分支并同步应用; 环境分支上同一文件已被修改时返回冲突的文件
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id        path     int                                                  true "tenaut id"
+// @Param       project_id       path     int                                                  true "project id"
+// @Param       environment_id   path     int                                                  true "environment id"
+// @Param       changerequest_id path     int                                                  true "change request id"
+// @Success     200              {object} handlers.ResponseStruct{Data=ChangeRequestDetail} "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/changerequests/{changerequest_id}/merge [post]
+// @Security    JWT
+func (h *ApplicationHandler) MergeChangeRequest(c *gin.Context) {
+	h.changeRequestFunc(c, nil, func(ctx context.Context, cr *models.ManifestChangeRequest) (interface{}, error) {
+		h.SetAuditData(c, "合并", "编排变更请求", changeRequestAuditName(cr))
+		policy := &models.ManifestReviewPolicy{}
+		if err := h.GetDataBase().DB().Where("environment_id = ?", cr.EnvironmentID).Take(policy).Error; err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, err
+			}
+			policy = nil
+		}
+		if err := cr.Mergeable(policy, AuthorFromContext(ctx).Name); err != nil {
+			return nil, err
+		}
+		merged, err := h.Manifest.MergeChangeRequest(ctx, cr)
+		if err != nil {
+			conflict := git.MergeConflictError{}
+			if errors.As(err, &conflict) {
+				c.AbortWithStatusJSON(http.StatusConflict, handlers.ResponseStruct{
+					Message:   handlers.MessageError,
+					Data:      ChangeRequestDetail{ManifestChangeRequest: cr, Conflicts: conflict.Files},
+					ErrorData: err.Error(),
+				})
+				return nil, nil
+			}
+			return nil, err
+		}
+		if err := h.Manifest.CloseChangeRequest(ctx, cr); err != nil {
+			log.FromContextOrDiscard(ctx).Error(err, "delete change branch", "branch", cr.ChangeBranch)
+		}
+		now := time.Now()
+		if err := h.GetDataBase().DB().Model(cr).Updates(map[string]interface{}{
+			"status":       models.ChangeRequestStatusMerged,
+			"merge_commit": merged,
+			"reviewer":     AuthorFromContext(ctx).Name,
+			"closed_at":    &now,
+		}).Error; err != nil {
+			return nil, err
+		}
+		if cr.ApplicationName != "" {
+			if err := h.ApplicationProcessor.Sync(ctx, ChangeRequestRef(cr)); err != nil {
+				return nil, err
+			}
+		}
+		return ChangeRequestDetail{ManifestChangeRequest: cr}, nil
+	})
+}
+
+// changeRequestFunc 获取路径中的变更请求, 变更请求必须属于路径中的环境
+func (h *ApplicationHandler) changeRequestFunc(c *gin.Context, body interface{}, fun func(ctx context.Context, cr *models.ManifestChangeRequest) (interface{}, error)) {
+	h.NoNameRefFunc(c, body, func(ctx context.Context, _ PathRef) (interface{}, error) {
+		cr := &models.ManifestChangeRequest{}
+		if err := h.GetDataBase().DB().
+			Where("environment_id = ?", c.Param("environment_id")).
+			First(cr, "id = ?", c.Param("changerequest_id")).Error; err != nil {
+			return nil, err
+		}
+		return fun(ctx, cr)
+	})
+}
+
+func changeRequestAuditName(cr *models.ManifestChangeRequest) string {
+	if cr.ApplicationName == "" {
+		return fmt.Sprintf("#%d(%s)", cr.ID, cr.EnvironmentName)
+	}
+	return fmt.Sprintf("#%d(%s/%s)", cr.ID, cr.EnvironmentName, cr.ApplicationName)
+}
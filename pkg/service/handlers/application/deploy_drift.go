@@ -0,0 +1,55 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Tags        Application
+// @Summary     环境漂移报告
+// @Description 列出环境下所有应用中与编排不一致(OutOfSync)的资源及 diff, 并标记上次同步后通过 kubectl 等人工修改的资源
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                          true "tenaut id"
+// @Param       project_id     path     int                                          true "project id"
+// @Param       environment_id path     int                                          true "environment id"
+// @Success     200            {object} handlers.ResponseStruct{Data=DriftReport} "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/driftreport [get]
+// @Security    JWT
+func (h *ApplicationHandler) EnvironmentDriftReport(c *gin.Context) {
+	h.NoNameRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		return h.ApplicationProcessor.DriftReport(ctx, ref)
+	})
+}
+
+// @Tags        Application
+// @Summary     项目漂移报告
+// @Description 列出项目下所有环境中与编排不一致(OutOfSync)的资源及 diff
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     int                                          true "tenaut id"
+// @Param       project_id path     int                                          true "project id"
+// @Success     200        {object} handlers.ResponseStruct{Data=DriftReport} "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/driftreport [get]
+// @Security    JWT
+func (h *ApplicationHandler) ProjectDriftReport(c *gin.Context) {
+	h.NoNameRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		ref.Env = ""
+		return h.ApplicationProcessor.DriftReport(ctx, ref)
+	})
+}
@@ -0,0 +1,334 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils"
+	"kubegems.io/kubegems/pkg/utils/git"
+)
+
+// encryptedCredentialPrefix 加密存储的凭据前缀, 没有前缀的为旧版本明文存储的凭据
+const encryptedCredentialPrefix = "enc:"
+
+// GitRemoteOptions 外部仓库的凭据加密以及地址限制
+type GitRemoteOptions struct {
+	encryptor    *utils.AesEncryptor
+	allowPrivate bool
+}
+
+func NewGitRemoteOptions(options *git.Options) *GitRemoteOptions {
+	key := options.CredentialKey
+	if key == "" {
+		key = options.Password
+	}
+	return &GitRemoteOptions{encryptor: utils.NewAesEncryptor(key), allowPrivate: options.AllowPrivateRemotes}
+}
+
+func (o *GitRemoteOptions) encrypt(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	encrypted, err := o.encryptor.EncryptBase64(value)
+	if err != nil {
+		return "", err
+	}
+	return encryptedCredentialPrefix + encrypted, nil
+}
+
+func (o *GitRemoteOptions) decrypt(value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedCredentialPrefix) {
+		return value, nil
+	}
+	return o.encryptor.DecryptBase64(strings.TrimPrefix(value, encryptedCredentialPrefix))
+}
+
+// ProjectRemoteResolver 项目配置了外部仓库时, 编排读写外部仓库
+// 内置 gitea 中 org 为租户名, repo 为项目名
+func ProjectRemoteResolver(db *gorm.DB, options *GitRemoteOptions) git.RemoteResolver {
+	return func(ctx context.Context, ref git.RepositoryRef) (git.Remote, git.RepositoryRef, error) {
+		project := &models.Project{}
+		if err := db.WithContext(ctx).
+			Joins("left join tenants on tenants.id = projects.tenant_id").
+			Where("tenants.tenant_name = ? and projects.project_name = ?", ref.Org, ref.Repo).
+			Take(project).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ref, nil
+			}
+			return nil, ref, err
+		}
+		repository := &models.ProjectGitRepository{}
+		if err := db.WithContext(ctx).Where("project_id = ?", project.ID).Take(repository).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ref, nil
+			}
+			return nil, ref, err
+		}
+		if err := git.ValidateRemoteAddr(ctx, repository.Kind, repository.Addr, options.allowPrivate); err != nil {
+			return nil, ref, err
+		}
+		remote, err := options.remoteOfRepository(repository)
+		if err != nil {
+			return nil, ref, err
+		}
+		remoteref := git.RepositoryRef{Org: repository.Org, Repo: repository.Repo, Branch: ref.Branch, Path: ref.Path}
+		if ref.Branch == BaseEnv && repository.Branch != "" {
+			remoteref.Branch = repository.Branch
+		}
+		return remote, remoteref, nil
+	}
+}
+
+func (o *GitRemoteOptions) remoteOfRepository(repository *models.ProjectGitRepository) (git.Remote, error) {
+	password, err := o.decrypt(repository.Password)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt password of repository: %w", err)
+	}
+	return git.NewRemote(git.RemoteOptions{
+		Kind:     repository.Kind,
+		Addr:     repository.Addr,
+		Username: repository.Username,
+		Password: password,
+	})
+}
+
+// GitRepositoryForm 设置外部仓库的表单, 凭据为空时保留已保存的值
+type GitRepositoryForm struct {
+	models.ProjectGitRepository
+	Password string `json:"password"`
+	// WebhookSecret 加密后存储, 限制长度以保证密文不超过字段长度
+	WebhookSecret string `json:"webhookSecret" binding:"max=256"`
+}
+
+// GitRepositoryResult 设置外部仓库的结果, 包含配置仓库 webhook 需要的密钥
+type GitRepositoryResult struct {
+	models.ProjectGitRepository
+	WebhookSecret string `json:"webhookSecret"`
+}
+
+// @Tags        Application
+// @Summary     获取项目编排所在的外部git仓库
+// @Description 获取项目编排所在的外部git仓库
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     int                                                       true "tenaut id"
+// @Param       project_id path     int                                                       true "project id"
+// @Success     200        {object} handlers.ResponseStruct{Data=models.ProjectGitRepository} "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/gitrepository [get]
+// @Security    JWT
+func (h *ApplicationHandler) GetGitRepository(c *gin.Context) {
+	repository := &models.ProjectGitRepository{}
+	if err := h.GetDataBase().DB().First(repository, "project_id = ?", c.Param("project_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	repository.HasPassword = repository.Password != ""
+	handlers.OK(c, repository)
+}
+
+// @Tags        Application
+// @Summary     设置项目编排所在的外部git仓库
+// @Description 支持 gitea/gitlab/github/generic, 除 generic 外仓库不存在时会自动创建; 已有的编排不会迁移, 切换仓库后需要重新部署应用
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     int                                                       true "tenaut id"
+// @Param       project_id path     int                                                       true "project id"
+// @Param       body       body     GitRepositoryForm                                         true "repository"
+// @Success     200        {object} handlers.ResponseStruct{Data=GitRepositoryResult}         "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/gitrepository [put]
+// @Security    JWT
+func (h *ApplicationHandler) SetGitRepository(c *gin.Context) {
+	form := &GitRepositoryForm{}
+	ref := PathRef{}
+	var webhookSecret string
+	process := func() error {
+		if err := c.BindJSON(form); err != nil {
+			return err
+		}
+		if err := h.BindTenantProjectedRefFunc(c, &ref); err != nil {
+			return err
+		}
+		repository := &form.ProjectGitRepository
+		repository.ProjectID = utils.ToUint(c.Param("project_id"))
+		h.SetAuditData(c, "设置", "编排仓库", ref.Project)
+
+		ctx := c.Request.Context()
+		if err := git.ValidateRemoteAddr(ctx, repository.Kind, repository.Addr, h.GitRemote.allowPrivate); err != nil {
+			return err
+		}
+		// 未提交的凭据保留已保存的值
+		existing := &models.ProjectGitRepository{}
+		if err := h.GetDataBase().DB().Where("project_id = ?", repository.ProjectID).Take(existing).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		var err error
+		if form.Password != "" {
+			if repository.Password, err = h.GitRemote.encrypt(form.Password); err != nil {
+				return err
+			}
+		} else {
+			repository.Password = existing.Password
+		}
+		webhookSecret = form.WebhookSecret
+		if webhookSecret == "" && existing.WebhookSecret != "" {
+			if webhookSecret, err = h.GitRemote.decrypt(existing.WebhookSecret); err != nil {
+				return err
+			}
+		}
+		if webhookSecret == "" {
+			secret := make([]byte, 16)
+			if _, err := rand.Read(secret); err != nil {
+				return err
+			}
+			webhookSecret = hex.EncodeToString(secret)
+		}
+		if repository.WebhookSecret, err = h.GitRemote.encrypt(webhookSecret); err != nil {
+			return err
+		}
+
+		remote, err := h.GitRemote.remoteOfRepository(repository)
+		if err != nil {
+			return err
+		}
+		if err := remote.EnsureRepo(ctx, repository.Org, repository.Repo); err != nil {
+			return fmt.Errorf("ensure repository %s/%s: %w", repository.Org, repository.Repo, err)
+		}
+		if u, exist := h.GetContextUser(c); exist {
+			repository.Creator = u.GetUsername()
+		}
+		if err := h.GetDataBase().DB().Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "project_id"}},
+			UpdateAll: true,
+		}).Create(repository).Error; err != nil {
+			return err
+		}
+		repository.HasPassword = repository.Password != ""
+		h.Manifest.GitProvider.Invalidate(ref.Tenant, ref.Project)
+		return nil
+	}
+	if err := process(); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, GitRepositoryResult{ProjectGitRepository: form.ProjectGitRepository, WebhookSecret: webhookSecret})
+}
+
+// @Tags        Application
+// @Summary     删除项目的外部git仓库设置
+// @Description 删除后编排回到内置的 gitea 仓库
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     int                                  true "tenaut id"
+// @Param       project_id path     int                                  true "project id"
+// @Success     200        {object} handlers.ResponseStruct{Data=string} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/gitrepository [delete]
+// @Security    JWT
+func (h *ApplicationHandler) DeleteGitRepository(c *gin.Context) {
+	ref := PathRef{}
+	if err := h.BindTenantProjectedRefFunc(c, &ref); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.SetAuditData(c, "删除", "编排仓库", ref.Project)
+	if err := h.GetDataBase().DB().Where("project_id = ?", c.Param("project_id")).Delete(&models.ProjectGitRepository{}).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.Manifest.GitProvider.Invalidate(ref.Tenant, ref.Project)
+	handlers.OK(c, "ok")
+}
+
+// @Tags        Application
+// @Summary     外部git仓库的 push webhook
+// @Description 收到 push 事件后立即刷新编排缓存, 支持 gitlab/github/gitea 的签名校验, 无需登录
+// @Accept      json
+// @Produce     json
+// @Param       project_id path     int                                  true "project id"
+// @Success     200        {object} handlers.ResponseStruct{Data=string} "ok"
+// @Router      /v1/gitwebhook/project/{project_id} [post]
+func (h *ApplicationHandler) GitWebhook(c *gin.Context) {
+	repository := &models.ProjectGitRepository{}
+	if err := h.GetDataBase().DB().Preload("Project.Tenant").First(repository, "project_id = ?", c.Param("project_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	secret, err := h.GitRemote.decrypt(repository.WebhookSecret)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if !VerifyWebhookSignature(c.Request.Header, body, secret) {
+		handlers.Unauthorized(c, "invalid webhook signature")
+		return
+	}
+	payload := &struct {
+		Ref string `json:"ref"`
+	}{}
+	_ = json.Unmarshal(body, payload)
+	// 非分支的事件(如tag)不处理
+	if payload.Ref != "" && !strings.HasPrefix(payload.Ref, "refs/heads/") {
+		handlers.OK(c, "ignored")
+		return
+	}
+	branch := strings.TrimPrefix(payload.Ref, "refs/heads/")
+	if err := h.Manifest.GitProvider.Refresh(c.Request.Context(), repository.Project.Tenant.TenantName, repository.Project.ProjectName, branch); err != nil {
+		log.Error(err, "refresh repository", "project", repository.Project.ProjectName, "branch", branch)
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, "ok")
+}
+
+// VerifyWebhookSignature 校验 gitlab(X-Gitlab-Token), github(X-Hub-Signature-256), gitea(X-Gitea-Signature) 的 webhook
+func VerifyWebhookSignature(header http.Header, body []byte, secret string) bool {
+	if secret == "" {
+		return false
+	}
+	if token := header.Get("X-Gitlab-Token"); token != "" {
+		return hmac.Equal([]byte(token), []byte(secret))
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if signature := header.Get("X-Hub-Signature-256"); signature != "" {
+		return hmac.Equal([]byte(signature), []byte("sha256="+expected))
+	}
+	if signature := header.Get("X-Gitea-Signature"); signature != "" {
+		return hmac.Equal([]byte(signature), []byte(expected))
+	}
+	return false
+}
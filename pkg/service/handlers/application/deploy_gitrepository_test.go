@@ -0,0 +1,74 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"kubegems.io/kubegems/pkg/utils/git"
+)
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	const (
+		secret = "secret"
+		body   = `{"ref":"refs/heads/dev"}`
+		// echo -n '{"ref":"refs/heads/dev"}' | openssl dgst -sha256 -hmac secret
+		signature = "e6910bb0930cc31ea5b507203333b453a4561a2c23cdede677e5f24caff32d1f"
+	)
+	tests := []struct {
+		name   string
+		header http.Header
+		secret string
+		want   bool
+	}{
+		{name: "gitlab", header: http.Header{"X-Gitlab-Token": {secret}}, secret: secret, want: true},
+		{name: "gitlab invalid", header: http.Header{"X-Gitlab-Token": {"other"}}, secret: secret, want: false},
+		{name: "github", header: http.Header{"X-Hub-Signature-256": {"sha256=" + signature}}, secret: secret, want: true},
+		{name: "github invalid", header: http.Header{"X-Hub-Signature-256": {signature}}, secret: secret, want: false},
+		{name: "gitea", header: http.Header{"X-Gitea-Signature": {signature}}, secret: secret, want: true},
+		{name: "no signature", header: http.Header{}, secret: secret, want: false},
+		{name: "empty secret", header: http.Header{"X-Gitlab-Token": {""}}, secret: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyWebhookSignature(tt.header, []byte(body), tt.secret); got != tt.want {
+				t.Errorf("VerifyWebhookSignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitRemoteOptions_Credentials(t *testing.T) {
+	options := NewGitRemoteOptions(&git.Options{Password: "gitea-password"})
+	encrypted, err := options.encrypt("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encrypted, encryptedCredentialPrefix) || strings.Contains(encrypted, "token") {
+		t.Errorf("encrypt() = %s", encrypted)
+	}
+	if got, err := options.decrypt(encrypted); err != nil || got != "token" {
+		t.Errorf("decrypt() = %s, %v", got, err)
+	}
+	// 旧版本明文存储的凭据
+	if got, err := options.decrypt("plain"); err != nil || got != "plain" {
+		t.Errorf("decrypt() legacy = %s, %v", got, err)
+	}
+	if got, _ := options.encrypt(""); got != "" {
+		t.Errorf("encrypt() empty = %s", got)
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils"
+	"kubegems.io/kubegems/pkg/utils/registry"
+)
+
+type ImagePolicyEvaluateRequest struct {
+	Images []string `json:"images" binding:"required,min=1"`
+}
+
+// @Tags        Application
+// @Summary     获取环境的镜像策略
+// @Description 获取环境的镜像部署策略
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                              true "tenaut id"
+// @Param       project_id     path     int                                              true "project id"
+// @Param       environment_id path     int                                              true "environment id"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.ImagePolicy} "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/imagepolicy [get]
+// @Security    JWT
+func (h *ApplicationHandler) GetImagePolicy(c *gin.Context) {
+	h.NoNameRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		return h.getImagePolicy(ctx, c.Param("environment_id"))
+	})
+}
+
+// @Tags        Application
+// @Summary     设置环境的镜像策略
+// @Description 设置允许的最高漏洞等级, 是否拒绝不可发布的镜像, 是否需要 cosign 签名; 在更新应用镜像前检查
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                              true "tenaut id"
+// @Param       project_id     path     int                                              true "project id"
+// @Param       environment_id path     int                                              true "environment id"
+// @Param       body           body     models.ImagePolicy                               true "policy"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.ImagePolicy} "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/imagepolicy [put]
+// @Security    JWT
+func (h *ApplicationHandler) SetImagePolicy(c *gin.Context) {
+	policy := &models.ImagePolicy{}
+	h.NoNameRefFunc(c, policy, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		h.SetAuditData(c, "设置", "环境镜像策略", ref.Env)
+		for _, key := range policy.PublicKeys {
+			if err := registry.VerifyPublicKey(key); err != nil {
+				return nil, err
+			}
+		}
+		policy.ID = 0
+		policy.EnvironmentID = utils.ToUint(c.Param("environment_id"))
+		if u, exist := h.GetContextUser(c); exist {
+			policy.Creator = u.GetUsername()
+		}
+		if err := h.GetDataBase().DB().WithContext(ctx).Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "environment_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{
+				"enabled", "max_severity", "block_unpublishable", "require_signature", "public_keys", "creator", "updated_at",
+			}),
+		}).Create(policy).Error; err != nil {
+			return nil, err
+		}
+		return policy, nil
+	})
+}
+
+// @Tags        Application
+// @Summary     检查镜像是否符合镜像策略
+// @Description 使用环境的镜像策略检查镜像, 返回违反的规则
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                                              true "tenaut id"
+// @Param       project_id     path     int                                                              true "project id"
+// @Param       environment_id path     int                                                              true "environment id"
+// @Param       body           body     ImagePolicyEvaluateRequest                                       true "images"
+// @Success     200            {object} handlers.ResponseStruct{Data=[]models.ImagePolicyViolation} "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/imagepolicy/evaluate [post]
+// @Security    JWT
+func (h *ApplicationHandler) EvaluateImagePolicy(c *gin.Context) {
+	body := &ImagePolicyEvaluateRequest{}
+	h.NoNameRefFunc(c, body, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		policy, err := h.getImagePolicy(ctx, c.Param("environment_id"))
+		if err != nil {
+			return nil, err
+		}
+		return h.ApplicationProcessor.EvaluateImagePolicy(ctx, policy, utils.ToUint(c.Param("project_id")), body.Images), nil
+	})
+}
+
+// @Tags        Application
+// @Summary     越过镜像策略的部署记录
+// @Description 管理员越过镜像策略部署的记录
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                                                             true  "tenaut id"
+// @Param       project_id     path     int                                                                             true  "project id"
+// @Param       environment_id path     int                                                                             true  "environment id"
+// @Param       page           query    int                                                                             false "page"
+// @Param       size           query    int                                                                             false "size"
+// @Success     200            {object} handlers.ResponseStruct{Data=handlers.PageData{List=[]models.ImagePolicyOverride}} "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/imagepolicy/overrides [get]
+// @Security    JWT
+func (h *ApplicationHandler) ListImagePolicyOverrides(c *gin.Context) {
+	h.NoNameRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		list := []models.ImagePolicyOverride{}
+		if err := h.GetDataBase().DB().WithContext(ctx).
+			Where("environment_id = ?", c.Param("environment_id")).
+			Order("id desc").
+			Find(&list).Error; err != nil {
+			return nil, err
+		}
+		return handlers.NewPageDataFromContext(c, list, nil, nil), nil
+	})
+}
+
+// overrideImagePolicy 镜像策略在提交编排时检查
+// 请求中设置 override=true 及 reason 时, 系统管理员及项目管理员可以越过策略部署, 越过的记录会被保存并审计
+func (h *ApplicationHandler) overrideImagePolicy(c *gin.Context, ref PathRef, images []string) error {
+	ctx := c.Request.Context()
+	override, _ := strconv.ParseBool(c.Query("override"))
+	if !override {
+		return nil
+	}
+	reason := c.Query("reason")
+	if reason == "" {
+		return errors.New("reason is required to override image policy")
+	}
+	u, exist := h.GetContextUser(c)
+	if !exist {
+		return errors.New("unauthorized to override image policy")
+	}
+	env, err := h.ApplicationProcessor.DataBase.GetEnvironment(ctx, ref)
+	if err != nil {
+		return err
+	}
+	auth := h.ModelCache().GetUserAuthority(u)
+	if !auth.IsSystemAdmin() && !auth.IsProjectAdmin(env.ProjectID) {
+		return errors.New("only system or project administrators can override image policy")
+	}
+	h.SetAuditData(c, "越过镜像策略更新", "应用镜像", ref.Name)
+	return h.ApplicationProcessor.OverrideImagePolicy(ctx, ref, images, ImagePolicyOverride{Operator: u.GetUsername(), Reason: reason})
+}
+
+// getImagePolicy 环境的镜像策略, 未设置时返回未启用的策略
+func (h *ApplicationHandler) getImagePolicy(ctx context.Context, envid string) (*models.ImagePolicy, error) {
+	policy := &models.ImagePolicy{}
+	err := h.GetDataBase().DB().WithContext(ctx).Where("environment_id = ?", envid).Take(policy).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.ImagePolicy{EnvironmentID: utils.ToUint(envid)}, nil
+	}
+	return policy, err
+}
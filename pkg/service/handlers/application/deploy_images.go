@@ -82,6 +82,12 @@ func (h *ApplicationHandler) BatchUpdateImages(c *gin.Context) {
 		}
 
 		h.SetAuditData(c, "更新", "应用镜像", strings.Join(updatednames, ","))
+		for _, arg := range args {
+			iref := PathRef{Tenant: ref.Tenant, Project: ref.Project, Env: ref.Env, Name: arg.Name}
+			if err := h.overrideImagePolicy(c, iref, arg.Images); err != nil {
+				return nil, err
+			}
+		}
 		if err := h.asyncBatchUpdateImages(ctx, ref, args); err != nil {
 			return nil, err
 		}
@@ -127,6 +133,9 @@ func (h *ApplicationHandler) UpdateImages(c *gin.Context) {
 			Images:       images,
 			IstioVersion: item.IstioVersion,
 		}
+		if err := h.overrideImagePolicy(c, ref, images); err != nil {
+			return nil, err
+		}
 		if err := h.asyncUpdateImages(ctx, ref, arg); err != nil {
 			return nil, err
 		}
@@ -248,6 +257,9 @@ func (h *ApplicationHandler) DirectUpdateImage(c *gin.Context) {
 		}
 		istioversion := c.Query("version")
 
+		if err := h.overrideImagePolicy(c, ref, []string{image}); err != nil {
+			return nil, err
+		}
 		// update
 		if err := h.ApplicationProcessor.UpdateImages(ctx, ref, []string{image}, istioversion); err != nil {
 			return nil, err
@@ -0,0 +1,63 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Tags        Application
+// @Summary     可导入的资源
+// @Description 列出环境 namespace 下可以导入为应用的 Deployment/StatefulSet/Service/ConfigMap/Ingress
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                                true "tenaut id"
+// @Param       project_id     path     int                                                true "project id"
+// @Param       environment_id path     int                                                true "environment id"
+// @Success     200            {object} handlers.ResponseStruct{Data=[]ImportCandidate} "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/importable-resources [get]
+// @Security    JWT
+func (h *ApplicationHandler) ListImportCandidates(c *gin.Context) {
+	h.NoNameRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		return h.ApplicationProcessor.ImportCandidates(ctx, ref)
+	})
+}
+
+// @Tags        Application
+// @Summary     从集群导入应用
+// @Description 将环境中已有的资源去除运行时字段后写入新的应用编排, 并部署为应用接管这些资源, 不会重建 pod
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                  true "tenaut id"
+// @Param       project_id     path     int                                  true "project id"
+// @Param       environment_id path     int                                  true "environment id"
+// @Param       body           body     ImportRequest                        true "import request"
+// @Success     200            {object} handlers.ResponseStruct{Data=string} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications-import [post]
+// @Security    JWT
+func (h *ApplicationHandler) Import(c *gin.Context) {
+	req := &ImportRequest{}
+	h.NoNameRefFunc(c, req, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		ref.Name = strings.ToLower(req.Name)
+		h.SetAuditData(c, "导入", "应用", ref.Name)
+		if err := h.ApplicationProcessor.Import(ctx, ref, *req); err != nil {
+			return nil, err
+		}
+		return "ok", nil
+	})
+}
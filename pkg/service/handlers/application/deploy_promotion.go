@@ -0,0 +1,215 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils"
+)
+
+// @Tags        Application
+// @Summary     获取项目的环境晋级流水线
+// @Description 获取项目的环境晋级流水线
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     int                                                    true "tenaut id"
+// @Param       project_id path     int                                                    true "project id"
+// @Success     200        {object} handlers.ResponseStruct{Data=models.PromotionPipeline} "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/promotionpipeline [get]
+// @Security    JWT
+func (h *ApplicationHandler) GetPromotionPipeline(c *gin.Context) {
+	pipeline := &models.PromotionPipeline{}
+	if err := h.GetDataBase().DB().First(pipeline, "project_id = ?", c.Param("project_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, pipeline)
+}
+
+// @Tags        Application
+// @Summary     设置项目的环境晋级流水线
+// @Description 按顺序设置晋级的环境, 如 dev -> test -> prod
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     int                                                    true "tenaut id"
+// @Param       project_id path     int                                                    true "project id"
+// @Param       body       body     models.PromotionPipeline                               true "pipeline"
+// @Success     200        {object} handlers.ResponseStruct{Data=models.PromotionPipeline} "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/promotionpipeline [put]
+// @Security    JWT
+func (h *ApplicationHandler) SetPromotionPipeline(c *gin.Context) {
+	pipeline := &models.PromotionPipeline{}
+	if err := c.BindJSON(pipeline); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if err := pipeline.Stages.Validate(); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	pipeline.ProjectID = utils.ToUint(c.Param("project_id"))
+	h.SetAuditData(c, "设置", "环境晋级流水线", strconv.Itoa(int(pipeline.ProjectID)))
+	h.SetExtraAuditData(c, models.ResProject, pipeline.ProjectID)
+
+	// 流水线中的环境必须属于该项目
+	for _, stage := range pipeline.Stages {
+		env := &models.Environment{}
+		if err := h.GetDataBase().DB().First(env, "project_id = ? and environment_name = ?", pipeline.ProjectID, stage.Environment).Error; err != nil {
+			handlers.NotOK(c, fmt.Errorf("environment %s not found in project", stage.Environment))
+			return
+		}
+	}
+	if u, exist := h.GetContextUser(c); exist {
+		pipeline.Creator = u.GetUsername()
+	}
+	if err := h.GetDataBase().DB().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "project_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"stages", "creator", "updated_at"}),
+	}).Create(pipeline).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, pipeline)
+}
+
+// @Tags        Application
+// @Summary     晋级应用到下一个环境
+// @Description 将当前环境中正在运行的编排版本和镜像digest晋级到流水线中的下一个环境, 下一个环境的 kustomization.yaml 保持不变
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                                   true "tenaut id"
+// @Param       project_id     path     int                                                   true "project id"
+// @param       environment_id path     int                                                   true "environment id"
+// @Param       name           path     string                                                true "name"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.PromotionHistory} "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/promote [post]
+// @Security    JWT
+func (h *ApplicationHandler) Promote(c *gin.Context) {
+	h.NamedRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		projectID := utils.ToUint(c.Param("project_id"))
+		pipeline := &models.PromotionPipeline{}
+		if err := h.GetDataBase().DB().First(pipeline, "project_id = ?", projectID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, errors.New("promotion pipeline is not configured for this project")
+			}
+			return nil, err
+		}
+		stage := pipeline.Stages.Next(ref.Env)
+		if stage == nil {
+			return nil, fmt.Errorf("environment %s has no next stage in promotion pipeline", ref.Env)
+		}
+		h.SetAuditData(c, "晋级", "应用", fmt.Sprintf("%s(%s->%s)", ref.Name, ref.Env, stage.Environment))
+
+		// 需要同时具有目标环境的权限
+		target := &models.Environment{}
+		if err := h.GetDataBase().DB().First(target, "project_id = ? and environment_name = ?", projectID, stage.Environment).Error; err != nil {
+			return nil, err
+		}
+		if !h.checkEnvironmentPermission(c, target.ID) {
+			return nil, nil
+		}
+
+		history := &models.PromotionHistory{
+			ProjectID:       projectID,
+			ApplicationName: ref.Name,
+			FromEnvironment: ref.Env,
+			ToEnvironment:   stage.Environment,
+			Creator:         AuthorFromContext(ctx).Name,
+		}
+		to := ref
+		to.Env = stage.Environment
+		result, err := h.ApplicationProcessor.Promote(ctx, ref, to, *stage)
+		switch {
+		case err == nil && result.ChangeRequest != nil:
+			history.Status = models.PromotionStatusReviewing
+			history.Message = fmt.Sprintf("changes submitted as change request #%d, waiting for review", result.ChangeRequest.ID)
+			history.Revision = result.Revision
+			history.Images, _ = json.Marshal(result.Images)
+		case err == nil:
+			history.Status = models.PromotionStatusSucceed
+			history.Revision = result.Revision
+			history.Images, _ = json.Marshal(result.Images)
+		case errors.As(err, &PromotionRejectedError{}):
+			history.Status, history.Message = models.PromotionStatusRejected, err.Error()
+		default:
+			history.Status, history.Message = models.PromotionStatusFailed, err.Error()
+		}
+		if dberr := h.GetDataBase().DB().Create(history).Error; dberr != nil {
+			return nil, dberr
+		}
+		if err != nil {
+			return nil, err
+		}
+		return history, nil
+	})
+}
+
+// @Tags        Application
+// @Summary     环境晋级记录
+// @Description 环境晋级记录
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id   path     int                                                                          true  "tenaut id"
+// @Param       project_id  path     int                                                                          true  "project id"
+// @Param       application query    string                                                                       false "应用名称"
+// @Param       page        query    int                                                                          false "page"
+// @Param       size        query    int                                                                          false "size"
+// @Success     200         {object} handlers.ResponseStruct{Data=handlers.PageData{List=[]models.PromotionHistory}} "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/promotionhistories [get]
+// @Security    JWT
+func (h *ApplicationHandler) ListPromotionHistories(c *gin.Context) {
+	query := h.GetDataBase().DB().Where("project_id = ?", c.Param("project_id"))
+	if app := c.Query("application"); app != "" {
+		query = query.Where("application_name = ?", app)
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 {
+		size = 10
+	}
+	var total int64
+	if err := query.Model(&models.PromotionHistory{}).Count(&total).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	list := []models.PromotionHistory{}
+	if err := query.Order("id desc").Limit(size).Offset((page - 1) * size).Find(&list).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, handlers.Page(total, list, int64(page), int64(size)))
+}
+
+// checkEnvironmentPermission 检查对另一个环境的权限, 无权限时已响应
+func (h *ApplicationHandler) checkEnvironmentPermission(c *gin.Context, envid uint) bool {
+	origin := c.Params
+	c.Params = append(gin.Params{{Key: "environment_id", Value: strconv.Itoa(int(envid))}}, origin...)
+	h.CheckByEnvironmentID(c)
+	c.Params = origin
+	return !c.IsAborted()
+}
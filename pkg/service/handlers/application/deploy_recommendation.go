@@ -0,0 +1,100 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"kubegems.io/kubegems/pkg/service/models"
+)
+
+// @Tags        Application
+// @Summary     环境下workload的资源规格建议
+// @Description 根据过去一周的使用量给出的requests/limits建议, 按预计节省费用排序
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                                                true "tenaut id"
+// @Param       project_id     path     int                                                                true "project id"
+// @param       environment_id path     int                                                                true "environment id"
+// @Success     200            {object} handlers.ResponseStruct{Data=[]models.ResourceRecommendation} "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/recommendations [get]
+// @Security    JWT
+func (h *ApplicationHandler) ListRecommendations(c *gin.Context) {
+	h.NoNameRefFunc(c, nil, func(ctx context.Context, _ PathRef) (interface{}, error) {
+		cluster, namespace := ClusterNamespaceFromCtx(ctx)
+		list := []models.ResourceRecommendation{}
+		if err := h.GetDataBase().DB().
+			Where("cluster_name = ? and namespace = ?", cluster, namespace).
+			Order("monthly_saving desc, cpu_saving_core desc").
+			Find(&list).Error; err != nil {
+			return nil, err
+		}
+		return list, nil
+	})
+}
+
+// @Tags        Application
+// @Summary     应用资源规格建议
+// @Description 将建议的requests/limits写入workload所属的应用编排并同步
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id         path     int                                  true "tenaut id"
+// @Param       project_id        path     int                                  true "project id"
+// @param       environment_id    path     int                                  true "environment id"
+// @Param       recommendation_id path     int                                  true "recommendation id"
+// @Success     200               {object} handlers.ResponseStruct{Data=string} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/recommendations/{recommendation_id}/apply [post]
+// @Security    JWT
+func (h *ApplicationHandler) ApplyRecommendation(c *gin.Context) {
+	h.NoNameRefFunc(c, nil, func(ctx context.Context, _ PathRef) (interface{}, error) {
+		cluster, namespace := ClusterNamespaceFromCtx(ctx)
+		rec := &models.ResourceRecommendation{}
+		if err := h.GetDataBase().DB().
+			Where("cluster_name = ? and namespace = ?", cluster, namespace).
+			First(rec, c.Param("recommendation_id")).Error; err != nil {
+			return nil, err
+		}
+		h.SetAuditData(c, "应用", "资源规格建议", rec.Type+"/"+rec.Name)
+
+		if rec.Ref == "" {
+			return nil, errors.New("workload is not deployed by application, can't apply recommendation")
+		}
+		suggestion := ResourceSuggestion{
+			TypeMeta:   metav1.TypeMeta{APIVersion: appsv1.SchemeGroupVersion.String(), Kind: rec.Type},
+			ObjectMeta: metav1.ObjectMeta{Name: rec.Name, Annotations: map[string]string{AnnotationRef: rec.Ref}},
+		}
+		for _, container := range rec.Containers {
+			suggestion.Spec.Template.Spec.Containers = append(suggestion.Spec.Template.Spec.Containers, Container{
+				Name:      container.Name,
+				Resources: container.Recommended,
+			})
+		}
+		if err := h.applyResourceSuggestion(ctx, suggestion); err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		rec.AppliedAt = &now
+		if err := h.GetDataBase().DB().Model(rec).Update("applied_at", now).Error; err != nil {
+			return nil, err
+		}
+		return "ok", nil
+	})
+}
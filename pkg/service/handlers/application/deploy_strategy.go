@@ -100,6 +100,9 @@ func (h *ApplicationHandler) EnableStrategyDeployment(c *gin.Context) {
 	h.NamedRefFunc(c, strategy, func(ctx context.Context, ref PathRef) (interface{}, error) {
 		// 审计
 		h.SetAuditData(c, "策略更新", "应用", ref.Name)
+		if err := h.overrideImagePolicy(c, ref, strategy.PublishImages()); err != nil {
+			return nil, err
+		}
 
 		steps := []workflow.Step{
 			{
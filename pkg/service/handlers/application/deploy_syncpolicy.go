@@ -0,0 +1,206 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils"
+)
+
+// @Tags        Application
+// @Summary     获取环境的默认同步策略
+// @Description 获取环境的默认同步策略, 包含同步窗口
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                             true "tenaut id"
+// @Param       project_id     path     int                                             true "project id"
+// @Param       environment_id path     int                                             true "environment id"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.SyncPolicy} "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/syncpolicy [get]
+// @Security    JWT
+func (h *ApplicationHandler) GetEnvironmentSyncPolicy(c *gin.Context) {
+	h.NoNameRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		return h.getSyncPolicy(ctx, c.Param("environment_id"))
+	})
+}
+
+// @Tags        Application
+// @Summary     设置环境的默认同步策略
+// @Description 设置环境下应用的自动同步, prune, self-heal, 重试策略和同步窗口; 未单独设置策略的应用立即生效
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                             true "tenaut id"
+// @Param       project_id     path     int                                             true "project id"
+// @Param       environment_id path     int                                             true "environment id"
+// @Param       body           body     models.SyncPolicy                               true "policy"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.SyncPolicy} "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/syncpolicy [put]
+// @Security    JWT
+func (h *ApplicationHandler) SetEnvironmentSyncPolicy(c *gin.Context) {
+	policy := &models.SyncPolicy{}
+	h.NoNameRefFunc(c, policy, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		h.SetAuditData(c, "设置", "环境同步策略", ref.Env)
+		policy.ApplicationName = ""
+		if _, err := ArgoSyncWindows(ref, "", policy.Windows); err != nil {
+			return nil, err
+		}
+		if err := h.saveSyncPolicy(c, policy); err != nil {
+			return nil, err
+		}
+		if err := h.ApplicationProcessor.ApplyEnvironmentSyncPolicy(ctx, ref); err != nil {
+			return nil, err
+		}
+		return policy, nil
+	})
+}
+
+// @Tags        Application
+// @Summary     获取应用的同步策略
+// @Description 应用未单独设置时返回环境的默认同步策略
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                             true "tenaut id"
+// @Param       project_id     path     int                                             true "project id"
+// @Param       environment_id path     int                                             true "environment id"
+// @Param       name           path     string                                          true "applicaiton name"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.SyncPolicy} "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/syncpolicy [get]
+// @Security    JWT
+func (h *ApplicationHandler) GetApplicationSyncPolicy(c *gin.Context) {
+	h.NamedRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		envpolicy, apppolicy, err := h.ApplicationProcessor.DataBase.GetSyncPolicies(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		if policy := EffectiveSyncPolicy(envpolicy, apppolicy); policy != nil {
+			return policy, nil
+		}
+		return &models.SyncPolicy{EnvironmentID: utils.ToUint(c.Param("environment_id"))}, nil
+	})
+}
+
+// @Tags        Application
+// @Summary     设置应用的同步策略
+// @Description 覆盖环境的默认同步策略, 同步窗口使用环境的设置
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                             true "tenaut id"
+// @Param       project_id     path     int                                             true "project id"
+// @Param       environment_id path     int                                             true "environment id"
+// @Param       name           path     string                                          true "applicaiton name"
+// @Param       body           body     models.SyncPolicy                               true "policy"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.SyncPolicy} "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/syncpolicy [put]
+// @Security    JWT
+func (h *ApplicationHandler) SetApplicationSyncPolicy(c *gin.Context) {
+	policy := &models.SyncPolicy{}
+	h.NamedRefFunc(c, policy, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		h.SetAuditData(c, "设置", "应用同步策略", ref.Name)
+		policy.ApplicationName = ref.Name
+		policy.Windows = nil
+		if err := h.saveSyncPolicy(c, policy); err != nil {
+			return nil, err
+		}
+		if err := h.ApplicationProcessor.ApplyApplicationSyncPolicy(ctx, ref); err != nil {
+			return nil, err
+		}
+		return policy, nil
+	})
+}
+
+// @Tags        Application
+// @Summary     删除应用的同步策略
+// @Description 删除后应用使用环境的默认同步策略
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                  true "tenaut id"
+// @Param       project_id     path     int                                  true "project id"
+// @Param       environment_id path     int                                  true "environment id"
+// @Param       name           path     string                               true "applicaiton name"
+// @Success     200            {object} handlers.ResponseStruct{Data=string} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/syncpolicy [delete]
+// @Security    JWT
+func (h *ApplicationHandler) DeleteApplicationSyncPolicy(c *gin.Context) {
+	h.NamedRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		h.SetAuditData(c, "删除", "应用同步策略", ref.Name)
+		if err := h.GetDataBase().DB().
+			Where("environment_id = ? and application_name = ?", c.Param("environment_id"), ref.Name).
+			Delete(&models.SyncPolicy{}).Error; err != nil {
+			return nil, err
+		}
+		if err := h.ApplicationProcessor.ApplyApplicationSyncPolicy(ctx, ref); err != nil {
+			return nil, err
+		}
+		return "ok", nil
+	})
+}
+
+// @Tags        Application
+// @Summary     同步窗口状态
+// @Description 当前是否可以同步, 当前开启的窗口, 以及下一次可以同步或窗口关闭的时间
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                            true  "tenaut id"
+// @Param       project_id     path     int                                            true  "project id"
+// @Param       environment_id path     int                                            true  "environment id"
+// @Param       application    query    string                                         false "应用名称, 为空时只计算作用于整个环境的窗口"
+// @Param       manual         query    bool                                           false "是否为手动同步"
+// @Success     200            {object} handlers.ResponseStruct{Data=SyncWindowState} "-"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/syncwindows/status [get]
+// @Security    JWT
+func (h *ApplicationHandler) SyncWindowStatus(c *gin.Context) {
+	h.NoNameRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		policy, err := h.getSyncPolicy(ctx, c.Param("environment_id"))
+		if err != nil {
+			return nil, err
+		}
+		return SyncWindowStatus(policy.Windows, c.Query("application"), time.Now(), c.Query("manual") == "true")
+	})
+}
+
+// getSyncPolicy 环境的默认同步策略, 未设置时返回空的策略
+func (h *ApplicationHandler) getSyncPolicy(ctx context.Context, envid string) (*models.SyncPolicy, error) {
+	policy := &models.SyncPolicy{}
+	err := h.GetDataBase().DB().WithContext(ctx).
+		Where("environment_id = ? and application_name = ?", envid, "").
+		Take(policy).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.SyncPolicy{EnvironmentID: utils.ToUint(envid)}, nil
+	}
+	return policy, err
+}
+
+func (h *ApplicationHandler) saveSyncPolicy(c *gin.Context, policy *models.SyncPolicy) error {
+	policy.ID = 0
+	policy.EnvironmentID = utils.ToUint(c.Param("environment_id"))
+	if u, exist := h.GetContextUser(c); exist {
+		policy.Creator = u.GetUsername()
+	}
+	return h.GetDataBase().DB().Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "environment_id"}, {Name: "application_name"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"auto_sync", "prune", "self_heal",
+			"retry_limit", "retry_backoff", "retry_backoff_factor", "retry_backoff_max_duration",
+			"windows", "creator", "updated_at",
+		}),
+	}).Create(policy).Error
+}
@@ -84,55 +84,7 @@ func (h *ApplicationHandler) UpdateWorkloadResources(c *gin.Context) {
 		if err := c.ShouldBind(&suggestion); err != nil {
 			return err
 		}
-		if suggestion.TypeMeta.GroupVersionKind().Empty() || suggestion.Name == "" {
-			return errors.New("empty resource kind or name")
-		}
-
-		if suggestion.Annotations == nil {
-			suggestion.Annotations = map[string]string{}
-		}
-		ref := &PathRef{}
-		ref.FromJsonBase64(suggestion.Annotations[AnnotationRef])
-		if ref.IsEmpty() {
-			return errors.New("not a argo managed resource")
-		}
-
-		updatefunc := func(_ context.Context, fs billy.Filesystem) error {
-			return ForFileContentFunc(fs, "", func(filename string, content []byte) error {
-				if filepath.Ext(filename) != ".yaml" {
-					return nil
-				}
-				obj, err := DecodeResource(content)
-				if err != nil {
-					return nil
-				}
-				// check Kind Name
-				if (obj.GetObjectKind().GroupVersionKind() != suggestion.TypeMeta.GroupVersionKind()) || obj.GetName() != suggestion.Name {
-					return nil
-				}
-				// update resource
-				updated := UpdatedReourcesLimits(obj, suggestion)
-				if updated {
-					content, err := yaml.Marshal(obj)
-					if err != nil {
-						return err
-					}
-					return util.WriteFile(fs, filename, content, os.ModePerm)
-				}
-				return nil
-			})
-		}
-
-		// update git
-		msg := fmt.Sprintf("update resource suggestion for %s name=%s", suggestion.GroupVersionKind().String(), suggestion.ObjectMeta.Name)
-		if err := h.Manifest.UpdateContentFunc(c.Request.Context(), *ref, updatefunc, msg); err != nil {
-			return err
-		}
-		// sync
-		if err := h.ApplicationProcessor.Sync(c.Request.Context(), *ref); err != nil {
-			return err
-		}
-		return nil
+		return h.applyResourceSuggestion(c.Request.Context(), suggestion)
 	}
 
 	if err := process(); err != nil {
@@ -142,6 +94,56 @@ func (h *ApplicationHandler) UpdateWorkloadResources(c *gin.Context) {
 	}
 }
 
+// applyResourceSuggestion 将资源建议更新到workload所属的应用编排并同步
+func (h *ApplicationHandler) applyResourceSuggestion(ctx context.Context, suggestion ResourceSuggestion) error {
+	if suggestion.TypeMeta.GroupVersionKind().Empty() || suggestion.Name == "" {
+		return errors.New("empty resource kind or name")
+	}
+
+	if suggestion.Annotations == nil {
+		suggestion.Annotations = map[string]string{}
+	}
+	ref := &PathRef{}
+	ref.FromJsonBase64(suggestion.Annotations[AnnotationRef])
+	if ref.IsEmpty() {
+		return errors.New("not a argo managed resource")
+	}
+
+	updatefunc := func(_ context.Context, fs billy.Filesystem) error {
+		return ForFileContentFunc(fs, "", func(filename string, content []byte) error {
+			if filepath.Ext(filename) != ".yaml" {
+				return nil
+			}
+			obj, err := DecodeResource(content)
+			if err != nil {
+				return nil
+			}
+			// check Kind Name
+			if (obj.GetObjectKind().GroupVersionKind() != suggestion.TypeMeta.GroupVersionKind()) || obj.GetName() != suggestion.Name {
+				return nil
+			}
+			// update resource
+			updated := UpdatedReourcesLimits(obj, suggestion)
+			if updated {
+				content, err := yaml.Marshal(obj)
+				if err != nil {
+					return err
+				}
+				return util.WriteFile(fs, filename, content, os.ModePerm)
+			}
+			return nil
+		})
+	}
+
+	// update git
+	msg := fmt.Sprintf("update resource suggestion for %s name=%s", suggestion.GroupVersionKind().String(), suggestion.ObjectMeta.Name)
+	if err := h.Manifest.UpdateContentFunc(ctx, *ref, updatefunc, msg); err != nil {
+		return err
+	}
+	// sync
+	return h.ApplicationProcessor.Sync(ctx, *ref)
+}
+
 func UpdatedReourcesLimits(obj client.Object, suggestion ResourceSuggestion) bool {
 	updated := false
 	updatefunc := func(template *corev1.PodTemplateSpec) {
@@ -19,17 +19,20 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
-	"sort"
 	"strconv"
+	"time"
 
 	"github.com/containerd/containerd/reference"
 	"github.com/gin-gonic/gin"
 	"github.com/goharbor/harbor/src/pkg/scan/vuln"
+	"gorm.io/gorm"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"kubegems.io/kubegems/pkg/log"
 	"kubegems.io/kubegems/pkg/service/handlers"
 	"kubegems.io/kubegems/pkg/service/models"
 	"kubegems.io/kubegems/pkg/utils/harbor"
+	"kubegems.io/kubegems/pkg/utils/registry"
+	"kubegems.io/kubegems/pkg/utils/slice"
 )
 
 const (
@@ -40,9 +43,11 @@ const (
 var ErrNotManagedRegistry = errors.New("unsupported image")
 
 type ImageTag struct {
-	TagName       string `json:"name"`
-	Image         string `json:"image"`
-	Unpublishable bool   `json:"unpublishable"` // 不可发布
+	TagName       string     `json:"name"`
+	Image         string     `json:"image"`
+	Unpublishable bool       `json:"unpublishable"`      // 不可发布
+	Digest        string     `json:"digest,omitempty"`   // 仓库不提供时为空
+	PushedAt      *time.Time `json:"pushedAt,omitempty"` // 推送时间, 仓库不提供时为空
 }
 
 type ImageHandler struct {
@@ -51,7 +56,7 @@ type ImageHandler struct {
 
 // @Tags        ProjectImage
 // @Summary     镜像安全报告
-// @Description 镜像安全报告
+// @Description 镜像安全报告, 不支持扫描的仓库返回空报告
 // @Accept      json
 // @Produce     json
 // @Param       tenant_id  path     int                                       true "tenaut id"
@@ -61,25 +66,13 @@ type ImageHandler struct {
 // @Router      /v1/tenant/{tenant_id}/project/{project_id}/images/vulnerabilities [get]
 // @Security    JWT
 func (h *ImageHandler) Vulnerabilities(c *gin.Context) {
-	onharborfunc := func(ctx context.Context, cli *harbor.Client, image string) (interface{}, error) {
-		vulnerabilities, err := cli.GetArtifactVulnerabilities(ctx, image)
-		if err != nil {
-			return nil, err
+	h.OnRegistryFunc(c, func(ctx context.Context, reg registry.Registry, image string) (interface{}, error) {
+		scanner, ok := reg.(registry.Scanner)
+		if !ok {
+			return vuln.Report{}, nil
 		}
-		report := vuln.Report{}
-		for _, v := range *vulnerabilities {
-			report = v
-		}
-		// sort 按照漏洞等级排序
-		sort.Slice(report.Vulnerabilities, func(i, j int) bool {
-			return report.Vulnerabilities[i].Severity.Code() > report.Vulnerabilities[j].Severity.Code()
-		})
-		return report, nil
-	}
-	ocifunc := func(ctx context.Context, cli *harbor.OCIDistributionClient, image string) (interface{}, error) {
-		return vuln.Report{}, nil
-	}
-	h.OnHarborFunc(c, onharborfunc, ocifunc)
+		return scanner.Vulnerabilities(ctx, image)
+	})
 }
 
 type ImageSummaryItem struct {
@@ -91,12 +84,12 @@ type ImageSummaryItem struct {
 	Unpublishable    bool           `json:"unpublishable,omitempty"`    // 不可发布状态，若为true则不可发布
 	Labels           []harbor.Label `json:"labels,omitempty"`           // harbor 标签
 	Status           string         `json:"status,omitempty"`
-	UpdatedAt        *metav1.Time   `json:"updatedAt,omitempty"` // time.Time.Format(RFC3339) 格式,若非可管理仓库则为空
+	UpdatedAt        *metav1.Time   `json:"updatedAt,omitempty"` // time.Time.Format(RFC3339) 格式,若仓库不提供推送时间则为空
 }
 
 // @Tags        ProjectImage
 // @Summary     镜像summary
-// @Description 镜像summary
+// @Description 镜像summary, 非 harbor 仓库仅包含 tag 信息
 // @Accept      json
 // @Produce     json
 // @Param       tenant_id  path     int                                       true "tenaut id"
@@ -106,8 +99,23 @@ type ImageSummaryItem struct {
 // @Router      /v1/tenant/{tenant_id}/project/{project_id}/images/summary [get]
 // @Security    JWT
 func (h *ImageHandler) Summary(c *gin.Context) {
-	onharborfunc := func(ctx context.Context, cli *harbor.Client, image string) (interface{}, error) {
-		artifacts, err := cli.ListArtifact(ctx, image, harbor.GetArtifactOptions{
+	h.OnRegistryFunc(c, func(ctx context.Context, reg registry.Registry, image string) (interface{}, error) {
+		summaries := []ImageSummaryItem{}
+		name := registry.RepositoryName(image)
+
+		harborreg, ok := reg.(*registry.Harbor)
+		if !ok {
+			tags, err := reg.ListTags(ctx, image)
+			if err != nil {
+				return nil, err
+			}
+			for _, tag := range tags {
+				summaries = append(summaries, ImageSummaryItem{Image: name + ":" + tag.Name})
+			}
+			return handlers.NewPageDataFromContext(c, summaries, nil, nil), nil
+		}
+
+		artifacts, err := harborreg.Client.ListArtifact(ctx, image, harbor.GetArtifactOptions{
 			WithScanOverview: true,
 			WithLabel:        true,
 			WithTag:          true,
@@ -115,28 +123,17 @@ func (h *ImageHandler) Summary(c *gin.Context) {
 		if err != nil {
 			return nil, err
 		}
-
-		summaries := []ImageSummaryItem{}
-
-		image, _ = harbor.SplitImageNameTag(image)
 		for _, artifact := range artifacts {
 			if artifact.Type != "IMAGE" || len(artifact.Tags) == 0 {
 				continue
 			}
 
 			item := ImageSummaryItem{
-				Image:            image + ":" + artifact.Tags[0].Name,
+				Image:            name + ":" + artifact.Tags[0].Name,
 				UpdatedAt:        &metav1.Time{Time: artifact.Artifact.PushTime},
 				Labels:           artifact.Labels,
 				IsHarborRegistry: true,
-				Unpublishable: func() bool {
-					for _, label := range artifact.Labels {
-						if label.Name == unpublishableLabelKey {
-							return true
-						}
-					}
-					return false
-				}(),
+				Unpublishable:    isUnpublishable(artifact.Labels),
 			}
 			for _, overview := range artifact.ScanOverview {
 				item.Report = overview.Summary
@@ -145,22 +142,13 @@ func (h *ImageHandler) Summary(c *gin.Context) {
 			}
 			summaries = append(summaries, item)
 		}
-
-		paged := handlers.NewPageDataFromContext(c, summaries, nil, nil)
-		return paged, nil
-	}
-
-	ocifunc := func(_ context.Context, _ *harbor.OCIDistributionClient, _ string) (interface{}, error) {
-		summaries := []ImageSummaryItem{}
 		return handlers.NewPageDataFromContext(c, summaries, nil, nil), nil
-	}
-
-	h.OnHarborFunc(c, onharborfunc, ocifunc)
+	})
 }
 
 // @Tags        ProjectImage
 // @Summary     镜像不可发布标记
-// @Description 镜像不可发布标记
+// @Description 镜像不可发布标记, 仅 harbor 仓库支持
 // @Accept      json
 // @Produce     json
 // @Param       tenant_id  path     int                                       true "tenaut id"
@@ -170,28 +158,28 @@ func (h *ImageHandler) Summary(c *gin.Context) {
 // @Router      /v1/tenant/{tenant_id}/project/{project_id}/images/unpublishable [get]
 // @Security    JWT
 func (h *ImageHandler) Unpublishable(c *gin.Context) {
-	onharborfunc := func(ctx context.Context, cli *harbor.Client, image string) (interface{}, error) {
+	h.OnRegistryFunc(c, func(ctx context.Context, reg registry.Registry, image string) (interface{}, error) {
+		harborreg, ok := reg.(*registry.Harbor)
+		if !ok {
+			return "ok", nil
+		}
 		isunpublishable, _ := strconv.ParseBool(c.Query("unpublishable"))
 		if isunpublishable {
-			if err := cli.AddArtifactLabelFromKey(ctx, image, unpublishableLabelKey, unpublishableLabelValue); err != nil {
+			if err := harborreg.Client.AddArtifactLabelFromKey(ctx, image, unpublishableLabelKey, unpublishableLabelValue); err != nil {
 				return nil, err
 			}
 		} else {
-			if err := cli.DeleteArtifactLabelFromKey(ctx, image, unpublishableLabelKey); err != nil {
+			if err := harborreg.Client.DeleteArtifactLabelFromKey(ctx, image, unpublishableLabelKey); err != nil {
 				return nil, err
 			}
 		}
 		return "ok", nil
-	}
-	ocifunc := func(ctx context.Context, cli *harbor.OCIDistributionClient, image string) (interface{}, error) {
-		return "ok", nil
-	}
-	h.OnHarborFunc(c, onharborfunc, ocifunc)
+	})
 }
 
 // @Tags        ProjectImage
 // @Summary     镜像扫描
-// @Description 触发镜像扫描
+// @Description 触发镜像扫描, 不支持扫描的仓库忽略
 // @Accept      json
 // @Produce     json
 // @Param       tenant_id  path     int                                       true "tenaut id"
@@ -201,16 +189,14 @@ func (h *ImageHandler) Unpublishable(c *gin.Context) {
 // @Router      /v1/tenant/{tenant_id}/project/{project_id}/images/scan [post]
 // @Security    JWT
 func (h *ImageHandler) Scan(c *gin.Context) {
-	onharborfunc := func(ctx context.Context, cli *harbor.Client, image string) (interface{}, error) {
-		if err := cli.ScanArtifact(ctx, image); err != nil {
-			return nil, err
+	h.OnRegistryFunc(c, func(ctx context.Context, reg registry.Registry, image string) (interface{}, error) {
+		if scanner, ok := reg.(registry.Scanner); ok {
+			if err := scanner.Scan(ctx, image); err != nil {
+				return nil, err
+			}
 		}
 		return "ok", nil
-	}
-	ocifunc := func(ctx context.Context, cli *harbor.OCIDistributionClient, image string) (interface{}, error) {
-		return "ok", nil
-	}
-	h.OnHarborFunc(c, onharborfunc, ocifunc)
+	})
 }
 
 // @Tags        ProjectImage
@@ -222,70 +208,132 @@ func (h *ImageHandler) Scan(c *gin.Context) {
 // @Param       project_id     path     int                                       true "project id"
 // @Param       application_id path     int                                       true "application id"
 // @Param       image          query    string                                    true "eg. kubegems/nginx:v1.14"
-// @Success     200            {object} handlers.ResponseStruct{Data=vuln.Report} "ok"
+// @Success     200            {object} handlers.ResponseStruct{Data=[]ImageTag} "ok"
 // @Router      /v1/tenant/{tenant_id}/project/{project_id}/images/tags [post]
 // @Security    JWT
 func (h *ImageHandler) ImageTags(c *gin.Context) {
-	harborfunc := func(ctx context.Context, cli *harbor.Client, image string) (interface{}, error) {
-		arts, err := cli.ListArtifact(ctx, image, harbor.GetArtifactOptions{
-			WithTag:   true,
-			WithLabel: true,
-		})
+	h.OnRegistryFunc(c, func(ctx context.Context, reg registry.Registry, image string) (interface{}, error) {
+		tags, err := reg.ListTags(ctx, image)
 		if err != nil {
 			return nil, err
 		}
-
-		unpublishableLabeld := func(labels []harbor.Label) bool {
-			for _, label := range labels {
-				if label.Name == unpublishableLabelKey {
-					return true
-				}
-			}
-			return false
-		}
-
-		name, _ := harbor.SplitImageNameTag(image)
-
+		name := registry.RepositoryName(image)
 		ret := []ImageTag{}
-		for _, item := range arts {
-			for _, tag := range item.Tags {
-				ret = append(ret, ImageTag{
-					TagName:       tag.Name,
-					Image:         name + ":" + tag.Name,
-					Unpublishable: unpublishableLabeld(item.Labels),
-				})
-			}
-		}
-		return ret, nil
-	}
-
-	ocifunc := func(ctx context.Context, cli *harbor.OCIDistributionClient, image string) (interface{}, error) {
-		fullname, _ := harbor.SplitImageNameTag(image)
-		tags, _ := cli.ListTags(ctx, image)
-		ret := []ImageTag{}
-		for _, tag := range tags.Tags {
+		for _, tag := range tags {
 			ret = append(ret, ImageTag{
-				TagName: tag,
-				Image:   fullname + ":" + tag,
+				TagName:       tag.Name,
+				Image:         name + ":" + tag.Name,
+				Unpublishable: slice.ContainStr(tag.Labels, unpublishableLabelKey),
+				Digest:        tag.Digest,
+				PushedAt:      tag.PushedAt,
 			})
 		}
 		return ret, nil
-	}
-	h.OnHarborFunc(c, harborfunc, ocifunc)
+	})
 }
 
-type (
-	HarborFunc func(ctx context.Context, cli *harbor.Client, image string) (interface{}, error)
-	OCIFunc    func(ctx context.Context, cli *harbor.OCIDistributionClient, image string) (interface{}, error)
-)
+type ImageRegistryInfo struct {
+	Kind         string                `json:"kind"`
+	Address      string                `json:"address"`
+	Managed      bool                  `json:"managed"` // 是否为项目中设置的镜像仓库
+	Capabilities []registry.Capability `json:"capabilities"`
+}
 
-type RegistryOptions struct {
-	URL      string
-	Username string
-	Password string
+// @Tags        ProjectImage
+// @Summary     镜像仓库信息
+// @Description 镜像所在仓库的类型及支持的功能
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     int                                                true "tenaut id"
+// @Param       project_id path     int                                                true "project id"
+// @Param       image      query    string                                             true "eg. kubegems/nginx:v1.14"
+// @Success     200        {object} handlers.ResponseStruct{Data=ImageRegistryInfo} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/images/registry [get]
+// @Security    JWT
+func (h *ImageHandler) RegistryInfo(c *gin.Context) {
+	h.OnRegistryFunc(c, func(ctx context.Context, reg registry.Registry, image string) (interface{}, error) {
+		options, managed := registryOptionsOf(h.GetDataBase().DB(), image, h.projectID(c))
+		address := options.Address
+		if address == "" {
+			address = registry.AddressOf(image)
+		}
+		return ImageRegistryInfo{Kind: reg.Kind(), Address: address, Managed: managed, Capabilities: reg.Capabilities()}, nil
+	})
 }
 
-func (h *ImageHandler) OnHarborFunc(c *gin.Context, harborfun HarborFunc, ocifunc OCIFunc) {
+// @Tags        ProjectImage
+// @Summary     镜像manifest
+// @Description 查询镜像 digest, 多架构镜像的各平台
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     int                                             true "tenaut id"
+// @Param       project_id path     int                                             true "project id"
+// @Param       image      query    string                                          true "eg. kubegems/nginx:v1.14"
+// @Success     200        {object} handlers.ResponseStruct{Data=registry.Manifest} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/images/manifest [get]
+// @Security    JWT
+func (h *ImageHandler) Manifest(c *gin.Context) {
+	h.OnRegistryFunc(c, func(ctx context.Context, reg registry.Registry, image string) (interface{}, error) {
+		return reg.GetManifest(ctx, image)
+	})
+}
+
+// @Tags        ProjectImage
+// @Summary     删除镜像
+// @Description 删除镜像, 相同 digest 的其他 tag 也会被删除
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     int                                  true "tenaut id"
+// @Param       project_id path     int                                  true "project id"
+// @Param       image      query    string                               true "eg. kubegems/nginx:v1.14"
+// @Success     200        {object} handlers.ResponseStruct{Data=string} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/images [delete]
+// @Security    JWT
+func (h *ImageHandler) Delete(c *gin.Context) {
+	h.OnRegistryFunc(c, func(ctx context.Context, reg registry.Registry, image string) (interface{}, error) {
+		if err := h.checkProjectAdmin(c); err != nil {
+			return nil, err
+		}
+		h.SetAuditData(c, "删除", "镜像", image)
+		if err := reg.Delete(ctx, image); err != nil {
+			return nil, err
+		}
+		return "ok", nil
+	})
+}
+
+// @Tags        ProjectImage
+// @Summary     清理镜像
+// @Description 按推送(构建)时间保留最新的若干 tag, 删除其余的 tag, dryRun 时仅返回将被删除的 tag
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     int                                                 true "tenaut id"
+// @Param       project_id path     int                                                 true "project id"
+// @Param       image      query    string                                              true "eg. kubegems/nginx"
+// @Param       body       body     ImageCleanupRequest                                 true "cleanup request"
+// @Success     200        {object} handlers.ResponseStruct{Data=ImageCleanupResult} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/images/cleanup [post]
+// @Security    JWT
+func (h *ImageHandler) Cleanup(c *gin.Context) {
+	req := ImageCleanupRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.OnRegistryFunc(c, func(ctx context.Context, reg registry.Registry, image string) (interface{}, error) {
+		if !req.DryRun {
+			if err := h.checkProjectAdmin(c); err != nil {
+				return nil, err
+			}
+			h.SetAuditData(c, "清理", "镜像", registry.RepositoryName(image))
+		}
+		return CleanupImageTags(ctx, reg, image, req)
+	})
+}
+
+type RegistryFunc func(ctx context.Context, reg registry.Registry, image string) (interface{}, error)
+
+func (h *ImageHandler) OnRegistryFunc(c *gin.Context, fun RegistryFunc) {
 	process := func(ctx context.Context) (interface{}, error) {
 		params := struct {
 			ProjectID uint `uri:"project_id" binding:"required"`
@@ -299,31 +347,11 @@ func (h *ImageHandler) OnHarborFunc(c *gin.Context, harborfun HarborFunc, ocifun
 		if image == "" {
 			return nil, fmt.Errorf("empty image name")
 		}
-
-		u, _, _, _, _ := harbor.ParseImag(image)
-		if u == "docker.io" {
-			u = "index.docker.io"
-		}
-		u = "https://" + u
-
-		options := &RegistryOptions{
-			URL: u,
-		}
-		_ = h.completeRegistryOption(options, image, params.ProjectID) // ignore
-
-		// try harbor
-		cli, err := harbor.NewClient(options.URL, options.Username, options.Password)
+		reg, err := registryOf(ctx, h.GetDataBase().DB(), image, params.ProjectID)
 		if err != nil {
 			return nil, err
 		}
-
-		if _, err := cli.SystemInfo(ctx); err != nil {
-			// try oci
-			ocicli := harbor.NewOCIDistributionClient(options.URL, options.Username, options.Password)
-			return ocifunc(ctx, ocicli, image)
-		}
-		// isharbor
-		return harborfun(ctx, cli, image)
+		return fun(ctx, reg, image)
 	}
 
 	if data, err := process(c.Request.Context()); err != nil {
@@ -333,7 +361,39 @@ func (h *ImageHandler) OnHarborFunc(c *gin.Context, harborfun HarborFunc, ocifun
 	}
 }
 
-func (h *ImageHandler) completeRegistryOption(options *RegistryOptions, imgname string, projectid uint) error {
+func (h *ImageHandler) projectID(c *gin.Context) uint {
+	projectid, _ := strconv.Atoi(c.Param("project_id"))
+	return uint(projectid)
+}
+
+// checkProjectAdmin 删除镜像仅允许系统或项目管理员操作
+func (h *ImageHandler) checkProjectAdmin(c *gin.Context) error {
+	u, exist := h.GetContextUser(c)
+	if !exist {
+		return errors.New("unauthorized")
+	}
+	auth := h.ModelCache().GetUserAuthority(u)
+	if !auth.IsSystemAdmin() && !auth.IsProjectAdmin(h.projectID(c)) {
+		return errors.New("only system or project administrators can delete images")
+	}
+	return nil
+}
+
+// registryOf 镜像所在的仓库, 项目中设置了该仓库时使用其认证信息, 启用了扩展功能的仓库为 harbor
+func registryOf(ctx context.Context, db *gorm.DB, image string, projectid uint) (registry.Registry, error) {
+	options, _ := registryOptionsOf(db, image, projectid)
+	return registry.New(ctx, image, options)
+}
+
+// registryOptionsOf 镜像所在仓库的认证信息, 返回是否为项目中设置的仓库
+func registryOptionsOf(db *gorm.DB, image string, projectid uint) (registry.Options, bool) {
+	options := registry.Options{}
+	err := completeRegistryOption(db, &options, image, projectid)
+	return options, err == nil
+}
+
+// completeRegistryOption 使用项目中的镜像仓库设置镜像仓库的地址及认证信息
+func completeRegistryOption(db *gorm.DB, options *registry.Options, imgname string, projectid uint) error {
 	spec, err := reference.Parse(imgname)
 	if err != nil {
 		return err
@@ -341,20 +401,25 @@ func (h *ImageHandler) completeRegistryOption(options *RegistryOptions, imgname
 	hostname := spec.Hostname()
 	registries := []models.Registry{}
 
-	if err := h.GetDataBase().DB().Where(&models.Registry{ProjectID: projectid}).Find(&registries).Error; err != nil {
+	if err := db.Where(&models.Registry{ProjectID: projectid}).Find(&registries).Error; err != nil {
 		return err
 	}
-	for _, registry := range registries {
-		u, err := url.Parse(registry.RegistryAddress)
+	for _, reg := range registries {
+		u, err := url.Parse(reg.RegistryAddress)
 		if err != nil {
-			log.WithField("registry", registry.RegistryAddress).Warn("invalid addr skiped")
+			log.WithField("registry", reg.RegistryAddress).Warn("invalid addr skiped")
 			continue
 		}
 		if u.Hostname() == hostname {
 			// found
-			options.URL = registry.RegistryAddress
-			options.Password = registry.Password
-			options.Username = registry.Username
+			options.Address = reg.RegistryAddress
+			options.Password = reg.Password
+			options.Username = reg.Username
+			// 启用扩展功能时已校验为 harbor 仓库, 否则仍需检测
+			if reg.EnableExtends {
+				isharbor := true
+				options.Harbor = &isharbor
+			}
 			return nil
 		}
 	}
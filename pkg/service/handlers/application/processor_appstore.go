@@ -0,0 +1,185 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	appstorehandler "kubegems.io/kubegems/pkg/service/handlers/appstore"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/helm"
+	"sigs.k8s.io/yaml"
+)
+
+// InvalidValuesError values 不符合 chart 的 schema
+type InvalidValuesError struct {
+	Chart   string
+	Version string
+	Err     error
+}
+
+func (e InvalidValuesError) Error() string {
+	return fmt.Sprintf("invalid values for chart %s-%s: %v", e.Chart, e.Version, e.Err)
+}
+
+func (e InvalidValuesError) Unwrap() error {
+	return e.Err
+}
+
+type ValuesDiffRequest struct {
+	ChartVersion string `json:"chartVersion" binding:"required"`
+	// Values 为空时使用当前部署的 values
+	Values json.RawMessage `json:"values"`
+}
+
+type ValuesDiff struct {
+	Chart       string `json:"chart"`
+	FromVersion string `json:"fromVersion"`
+	ToVersion   string `json:"toVersion"`
+	// Changes 与 chart 默认值合并后的 values 的变化
+	Changes []helm.ValuesChange `json:"changes"`
+	// UnknownValues 设置了但目标版本中不存在的配置
+	UnknownValues []string `json:"unknownValues"`
+	// ValidationError 使用目标版本 schema 校验的错误
+	ValidationError string `json:"validationError,omitempty"`
+}
+
+// chartRepoURL 将应用使用的仓库地址解析为应用商店中已登记的仓库地址, 仅允许内置仓库及数据库中登记的仓库
+func (h *ApplicationProcessor) chartRepoURL(ctx context.Context, repoURL string) (string, error) {
+	if h.AppStore == nil || h.AppStore.Addr == "" {
+		return "", errors.New("app store is not configured")
+	}
+	prefix := strings.TrimSuffix(h.AppStore.Addr, "/") + "/"
+	name := strings.TrimSuffix(repoURL, "/")
+	if !strings.HasPrefix(name, prefix) {
+		return "", fmt.Errorf("chart repository %s is not registered", repoURL)
+	}
+	name = strings.TrimPrefix(name, prefix)
+	if name == "" || strings.Contains(name, "/") {
+		return "", fmt.Errorf("chart repository %s is not registered", repoURL)
+	}
+	if name != appstorehandler.InternalChartRepoName {
+		if err := h.DataBase.DB.WithContext(ctx).Where("chart_repo_name = ?", name).Take(&models.ChartRepo{}).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return "", fmt.Errorf("chart repository %s is not registered", repoURL)
+			}
+			return "", err
+		}
+	}
+	return prefix + name, nil
+}
+
+// getChart 从已登记的仓库中获取 chart
+func (h *ApplicationProcessor) getChart(ctx context.Context, repoURL, name, version string) (*chart.Chart, error) {
+	repoURL, err := h.chartRepoURL(ctx, repoURL)
+	if err != nil {
+		return nil, err
+	}
+	repository, err := helm.NewLegencyRepository(&helm.RepositoryConfig{URL: repoURL})
+	if err != nil {
+		return nil, err
+	}
+	chrt, err := repository.GetChart(ctx, name, version)
+	if err != nil {
+		return nil, fmt.Errorf("get chart %s-%s: %w", name, version, err)
+	}
+	return chrt, nil
+}
+
+// ValidateAppStoreValues 使用 chart 的 schema 校验部署的 values
+func (h *ApplicationProcessor) ValidateAppStoreValues(ctx context.Context, form AppStoreDeployForm) error {
+	values, err := decodeHelmValues(form.Values)
+	if err != nil {
+		return err
+	}
+	chrt, err := h.getChart(ctx, form.RepoURL, form.Chart, form.ChartVersion)
+	if err != nil {
+		return err
+	}
+	if err := helm.ValidateValues(chrt, values); err != nil {
+		return InvalidValuesError{Chart: form.Chart, Version: form.ChartVersion, Err: err}
+	}
+	return nil
+}
+
+// AppStoreValuesDiff 比较当前部署的 values 与升级到目标版本后的 values
+func (h *ApplicationProcessor) AppStoreValuesDiff(ctx context.Context, ref PathRef, req ValuesDiffRequest) (*ValuesDiff, error) {
+	app, err := h.Argo.GetArgoApp(ctx, ref.FullName())
+	if err != nil {
+		return nil, err
+	}
+	source := app.Spec.Source
+	if source.Chart == "" {
+		return nil, fmt.Errorf("application %s is not deployed from app store", ref.Name)
+	}
+	current := map[string]interface{}{}
+	if source.Helm != nil && source.Helm.Values != "" {
+		if err := yaml.Unmarshal([]byte(source.Helm.Values), &current); err != nil {
+			return nil, err
+		}
+	}
+	target := current
+	if len(req.Values) > 0 {
+		if target, err = decodeHelmValues(req.Values); err != nil {
+			return nil, err
+		}
+	}
+
+	fromchart, err := h.getChart(ctx, source.RepoURL, source.Chart, source.TargetRevision)
+	if err != nil {
+		return nil, err
+	}
+	tochart, err := h.getChart(ctx, source.RepoURL, source.Chart, req.ChartVersion)
+	if err != nil {
+		return nil, err
+	}
+	from, err := chartutil.CoalesceValues(fromchart, current)
+	if err != nil {
+		return nil, err
+	}
+	to, err := chartutil.CoalesceValues(tochart, target)
+	if err != nil {
+		return nil, err
+	}
+	diff := &ValuesDiff{
+		Chart:         source.Chart,
+		FromVersion:   source.TargetRevision,
+		ToVersion:     req.ChartVersion,
+		Changes:       helm.DiffValues(from, to),
+		UnknownValues: helm.UnknownValues(target, tochart.Values),
+	}
+	if err := helm.ValidateValues(tochart, target); err != nil {
+		diff.ValidationError = err.Error()
+	}
+	return diff, nil
+}
+
+func decodeHelmValues(raw json.RawMessage) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	if len(raw) == 0 || string(raw) == "null" {
+		return values, nil
+	}
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return nil, fmt.Errorf("values must be an object: %w", err)
+	}
+	return values, nil
+}
@@ -0,0 +1,366 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"helm.sh/helm/v3/pkg/repo"
+	"k8s.io/apimachinery/pkg/labels"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/helm"
+	"kubegems.io/kubegems/pkg/utils/workflow"
+	"sigs.k8s.io/yaml"
+)
+
+const TaskFunction_Application_UpgradeChart = "application_upgrade_chart"
+
+type AppstoreUpgradePolicy struct {
+	// Constraint semver 约束, 如 ~6.2, >=16.0.0 <17.0.0
+	Constraint string `json:"constraint"`
+	Pinned     bool   `json:"pinned"`
+}
+
+type AppstoreUpgradeItem struct {
+	Name string `json:"name" binding:"required"`
+	// Version 为空时升级到检测到的可用版本, 指定时需满足版本约束且高于当前版本
+	Version string `json:"version"`
+	// Downgrade 允许 Version 低于当前版本
+	Downgrade bool `json:"downgrade"`
+}
+
+type AppstoreUpgradeRequest struct {
+	Applications []AppstoreUpgradeItem `json:"applications" binding:"required,min=1,dive"`
+}
+
+type AppstoreUpgradeResult struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	// Message 未提交升级任务的原因
+	Message string `json:"message,omitempty"`
+}
+
+// LatestChartVersion 返回 versions 中比 current 新且满足 constraint 的最新版本, 没有时返回空
+// constraint 为空时不升级到预发布版本
+func LatestChartVersion(versions repo.ChartVersions, current, constraint string) (string, error) {
+	cur, err := semver.NewVersion(current)
+	if err != nil {
+		return "", fmt.Errorf("invalid chart version %s: %w", current, err)
+	}
+	var constraints *semver.Constraints
+	if constraint != "" {
+		if constraints, err = semver.NewConstraint(constraint); err != nil {
+			return "", fmt.Errorf("invalid version constraint %s: %w", constraint, err)
+		}
+	}
+	var latest *semver.Version
+	for _, cv := range versions {
+		v, err := semver.NewVersion(cv.Version)
+		if err != nil || !v.GreaterThan(cur) {
+			continue
+		}
+		if constraints != nil {
+			if !constraints.Check(v) {
+				continue
+			}
+		} else if v.Prerelease() != "" {
+			continue
+		}
+		if latest == nil || v.GreaterThan(latest) {
+			latest = v
+		}
+	}
+	if latest == nil {
+		return "", nil
+	}
+	return latest.Original(), nil
+}
+
+// CheckUpgradeVersion 检查指定的升级版本, 需满足 constraint 且高于 current, downgrade 时允许低于 current
+func CheckUpgradeVersion(version, current, constraint string, downgrade bool) error {
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return fmt.Errorf("invalid chart version %s: %w", version, err)
+	}
+	if constraint != "" {
+		constraints, err := semver.NewConstraint(constraint)
+		if err != nil {
+			return fmt.Errorf("invalid version constraint %s: %w", constraint, err)
+		}
+		if !constraints.Check(v) {
+			return fmt.Errorf("version %s does not satisfy constraint %s", version, constraint)
+		}
+	}
+	cur, err := semver.NewVersion(current)
+	if err != nil {
+		return fmt.Errorf("invalid chart version %s: %w", current, err)
+	}
+	if v.Equal(cur) {
+		return fmt.Errorf("version %s is the current version", version)
+	}
+	if v.LessThan(cur) && !downgrade {
+		return fmt.Errorf("version %s is lower than the current version %s", version, current)
+	}
+	return nil
+}
+
+// CheckAppstoreUpgrades 对比环境中应用商店应用的 chart 版本与仓库中的版本, 记录可用的升级
+func (h *ApplicationProcessor) CheckAppstoreUpgrades(ctx context.Context, ref PathRef) ([]*models.AppstoreUpgrade, error) {
+	env, err := h.DataBase.GetEnvironment(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	applist, err := h.Argo.ListArgoApp(ctx, labels.Set{
+		LabelKeyFrom:     LabelValueFromAppStore,
+		LabelTenant:      ref.Tenant,
+		LabelProject:     ref.Project,
+		LabelEnvironment: ref.Env,
+	}.AsSelector())
+	if err != nil {
+		return nil, err
+	}
+	existing := []*models.AppstoreUpgrade{}
+	if err := h.DataBase.DB.WithContext(ctx).Where("environment_id = ?", env.ID).Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	records := make(map[string]*models.AppstoreUpgrade, len(existing))
+	for _, record := range existing {
+		records[record.ApplicationName] = record
+	}
+
+	logger := log.FromContextOrDiscard(ctx)
+	indexes := map[string]*repo.IndexFile{}
+	now := time.Now()
+	ret := []*models.AppstoreUpgrade{}
+	for _, app := range applist.Items {
+		name, source := app.Labels[LabelApplication], app.Spec.Source
+		record, ok := records[name]
+		if !ok {
+			record = &models.AppstoreUpgrade{EnvironmentID: env.ID, ApplicationName: name}
+		}
+		delete(records, name)
+		record.RepoURL, record.Chart, record.CurrentVersion = source.RepoURL, source.Chart, source.TargetRevision
+		record.CheckedAt = &now
+		if record.Pinned {
+			record.AvailableVersion = ""
+		} else if index := h.chartIndex(ctx, indexes, source.RepoURL); index != nil {
+			// 仓库不可用时保留上次的结果
+			latest, err := LatestChartVersion(index.Entries[source.Chart], record.CurrentVersion, record.VersionConstraint)
+			if err != nil {
+				logger.Error(err, "check chart upgrade", "application", name)
+			}
+			record.AvailableVersion = latest
+		}
+		if err := h.DataBase.DB.WithContext(ctx).Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "environment_id"}, {Name: "application_name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"repo_url", "chart", "current_version", "available_version", "checked_at", "updated_at"}),
+		}).Create(record).Error; err != nil {
+			return nil, err
+		}
+		ret = append(ret, record)
+	}
+	// 已删除的应用
+	for _, record := range records {
+		h.DataBase.DB.WithContext(ctx).Delete(record)
+	}
+	return ret, nil
+}
+
+// chartIndex 获取已登记仓库的 index, 仓库未登记或不可用时返回 nil
+func (h *ApplicationProcessor) chartIndex(ctx context.Context, indexes map[string]*repo.IndexFile, repoURL string) *repo.IndexFile {
+	if index, ok := indexes[repoURL]; ok {
+		return index
+	}
+	var index *repo.IndexFile
+	registered, err := h.chartRepoURL(ctx, repoURL)
+	if err == nil {
+		var repository *helm.LegencyRepository
+		if repository, err = helm.NewLegencyRepository(&helm.RepositoryConfig{URL: registered}); err == nil {
+			index, err = repository.GetIndex(ctx)
+		}
+	}
+	if err != nil {
+		log.FromContextOrDiscard(ctx).Error(err, "get chart repo index", "repo", repoURL)
+		index = nil
+	}
+	indexes[repoURL] = index
+	return index
+}
+
+// SetAppstoreUpgradePolicy 设置应用的升级版本约束或固定版本
+func (h *ApplicationProcessor) SetAppstoreUpgradePolicy(ctx context.Context, ref PathRef, policy AppstoreUpgradePolicy) (*models.AppstoreUpgrade, error) {
+	if policy.Constraint != "" {
+		if _, err := semver.NewConstraint(policy.Constraint); err != nil {
+			return nil, fmt.Errorf("invalid version constraint %s: %w", policy.Constraint, err)
+		}
+	}
+	env, err := h.DataBase.GetEnvironment(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	record := &models.AppstoreUpgrade{
+		EnvironmentID:     env.ID,
+		ApplicationName:   ref.Name,
+		VersionConstraint: policy.Constraint,
+		Pinned:            policy.Pinned,
+	}
+	if err := h.DataBase.DB.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "environment_id"}, {Name: "application_name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"version_constraint", "pinned", "updated_at"}),
+	}).Create(record).Error; err != nil {
+		return nil, err
+	}
+	// 重新检测可用的版本
+	if _, err := h.CheckAppstoreUpgrades(ctx, ref); err != nil {
+		return nil, err
+	}
+	if err := h.DataBase.DB.WithContext(ctx).
+		Where("environment_id = ? and application_name = ?", env.ID, ref.Name).
+		Take(record).Error; err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// UpgradeAppstoreApplications 为每个应用提交升级 chart 并同步的异步任务, 固定版本或没有可用升级的应用会被跳过
+func (h *ApplicationProcessor) UpgradeAppstoreApplications(ctx context.Context, ref PathRef, req AppstoreUpgradeRequest) ([]AppstoreUpgradeResult, error) {
+	envdetails, err := h.DataBase.GetEnvironmentWithCluster(ref)
+	if err != nil {
+		return nil, err
+	}
+	// 注入 cluster namespace
+	ctx = context.WithValue(ctx, contextClusterNamespaceKey{}, ClusterNamespace{
+		Cluster:   envdetails.ClusterName,
+		Namespace: envdetails.Namespace,
+	})
+	env, err := h.DataBase.GetEnvironment(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	existing := []*models.AppstoreUpgrade{}
+	if err := h.DataBase.DB.WithContext(ctx).Where("environment_id = ?", env.ID).Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	records := make(map[string]*models.AppstoreUpgrade, len(existing))
+	for _, record := range existing {
+		records[record.ApplicationName] = record
+	}
+
+	results := make([]AppstoreUpgradeResult, len(req.Applications))
+	wg := sync.WaitGroup{}
+	for i, item := range req.Applications {
+		result := &results[i]
+		result.Name, result.Version = item.Name, item.Version
+		record, ok := records[item.Name]
+		if !ok {
+			record = &models.AppstoreUpgrade{}
+		}
+		if record.Pinned {
+			result.Message = "version is pinned"
+			continue
+		}
+		iref := PathRef{Tenant: ref.Tenant, Project: ref.Project, Env: ref.Env, Name: item.Name}
+		if result.Version == "" {
+			if record.AvailableVersion == "" {
+				result.Message = "no upgrade available"
+				continue
+			}
+			result.Version = record.AvailableVersion
+		} else {
+			current := record.CurrentVersion
+			if current == "" {
+				// 尚未检测过升级的应用
+				app, err := h.Argo.GetArgoApp(ctx, iref.FullName())
+				if err != nil {
+					result.Message = err.Error()
+					continue
+				}
+				current = app.Spec.Source.TargetRevision
+			}
+			if err := CheckUpgradeVersion(result.Version, current, record.VersionConstraint, item.Downgrade); err != nil {
+				result.Message = err.Error()
+				continue
+			}
+		}
+		steps := []workflow.Step{
+			{
+				Name:     "upgrade-chart",
+				Function: TaskFunction_Application_UpgradeChart,
+				Args:     workflow.ArgsOf(iref, result.Version),
+			},
+			{
+				Name:     "sync",
+				Function: TaskFunction_Application_Sync,
+				Args:     workflow.ArgsOf(iref),
+			},
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := h.Task.SubmitTask(ctx, iref, "upgrade-chart(batch)", steps); err != nil {
+				result.Message = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// UpgradeChart 使用当前的 values 将应用商店应用升级至 chart 的指定版本, values 不符合新版本的 schema 时不升级
+func (h *ApplicationProcessor) UpgradeChart(ctx context.Context, ref PathRef, version string) error {
+	app, err := h.Argo.GetArgoApp(ctx, ref.FullName())
+	if err != nil {
+		return err
+	}
+	source := app.Spec.Source
+	if app.Labels[LabelKeyFrom] != LabelValueFromAppStore || source.Chart == "" {
+		return fmt.Errorf("application %s is not deployed from app store", ref.Name)
+	}
+	values := map[string]interface{}{}
+	if source.Helm != nil && source.Helm.Values != "" {
+		if err := yaml.Unmarshal([]byte(source.Helm.Values), &values); err != nil {
+			return err
+		}
+	}
+	chrt, err := h.getChart(ctx, source.RepoURL, source.Chart, version)
+	if err != nil {
+		return err
+	}
+	if err := helm.ValidateValues(chrt, values); err != nil {
+		return InvalidValuesError{Chart: source.Chart, Version: version, Err: err}
+	}
+	app.Spec.Source.TargetRevision = version
+	if _, err := h.Argo.UpdateApp(ctx, app); err != nil {
+		return err
+	}
+
+	env, err := h.DataBase.GetEnvironment(ctx, ref)
+	if err != nil {
+		return err
+	}
+	return h.DataBase.DB.WithContext(ctx).Model(&models.AppstoreUpgrade{}).
+		Where("environment_id = ? and application_name = ?", env.ID, ref.Name).
+		Updates(map[string]interface{}{
+			"current_version":   version,
+			"available_version": gorm.Expr("case when available_version = ? then '' else available_version end", version),
+		}).Error
+}
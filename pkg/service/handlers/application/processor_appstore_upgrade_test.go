@@ -0,0 +1,83 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+func TestLatestChartVersion(t *testing.T) {
+	versions := repo.ChartVersions{}
+	for _, v := range []string{"17.1.0-rc.1", "17.0.1", "17.0.0", "16.13.2", "16.13.0", "16.8.0", "invalid"} {
+		versions = append(versions, &repo.ChartVersion{Metadata: &chart.Metadata{Name: "redis", Version: v}})
+	}
+	tests := []struct {
+		name       string
+		current    string
+		constraint string
+		want       string
+		wantErr    bool
+	}{
+		{name: "latest stable", current: "16.8.0", want: "17.0.1"},
+		{name: "up to date", current: "17.0.1"},
+		{name: "minor constraint", current: "16.8.0", constraint: "~16.8", want: ""},
+		{name: "major constraint", current: "16.8.0", constraint: "^16", want: "16.13.2"},
+		{name: "prerelease constraint", current: "17.0.1", constraint: ">=17.0.0-0", want: "17.1.0-rc.1"},
+		{name: "invalid current", current: "latest", wantErr: true},
+		{name: "invalid constraint", current: "16.8.0", constraint: "foo", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LatestChartVersion(versions, tt.current, tt.constraint)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LatestChartVersion() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("LatestChartVersion() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckUpgradeVersion(t *testing.T) {
+	tests := []struct {
+		name       string
+		version    string
+		current    string
+		constraint string
+		downgrade  bool
+		wantErr    bool
+	}{
+		{name: "upgrade", version: "17.0.1", current: "16.8.0"},
+		{name: "upgrade within constraint", version: "16.13.2", current: "16.8.0", constraint: "^16"},
+		{name: "out of constraint", version: "17.0.1", current: "16.8.0", constraint: "^16", wantErr: true},
+		{name: "same version", version: "16.8.0", current: "16.8.0", wantErr: true},
+		{name: "downgrade not allowed", version: "16.0.0", current: "16.8.0", wantErr: true},
+		{name: "downgrade", version: "16.0.0", current: "16.8.0", downgrade: true},
+		{name: "downgrade out of constraint", version: "15.0.0", current: "16.8.0", constraint: "^16", downgrade: true, wantErr: true},
+		{name: "invalid version", version: "latest", current: "16.8.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckUpgradeVersion(tt.version, tt.current, tt.constraint, tt.downgrade); (err != nil) != tt.wantErr {
+				t.Errorf("CheckUpgradeVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -29,6 +29,7 @@ import (
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/helper/chroot"
 	"github.com/go-git/go-billy/v5/util"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/opentracing/opentracing-go"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc/codes"
@@ -46,6 +47,7 @@ import (
 	"kubegems.io/kubegems/pkg/utils/argo"
 	"kubegems.io/kubegems/pkg/utils/database"
 	"kubegems.io/kubegems/pkg/utils/git"
+	"kubegems.io/kubegems/pkg/utils/helm"
 	"kubegems.io/kubegems/pkg/utils/kube"
 	"kubegems.io/kubegems/pkg/utils/redis"
 	"kubegems.io/kubegems/pkg/utils/workflow"
@@ -72,6 +74,7 @@ func (p *ApplicationProcessor) ProvideFuntions() map[string]interface{} {
 		TaskFunction_Application_PrepareDeploymentStrategy: p.PrepareDeploymentStrategyWithImages,
 		TaskFunction_Application_WaitRollouts:              p.WaitRollouts,
 		TaskFunction_Application_Undo:                      p.Undo,
+		TaskFunction_Application_UpgradeChart:              p.UpgradeChart,
 	}
 }
 
@@ -81,17 +84,20 @@ type ApplicationProcessor struct {
 	DataBase *DatabseProcessor
 	Manifest *ManifestProcessor
 	Task     *TaskProcessor
+	// AppStore 应用商店 chart 仓库, 应用商店应用仅从其中登记的仓库获取 chart
+	AppStore *helm.Options
 
 	// 缓存已经创建的 cluster,project,repo
 	argostatuscache *sync.Map
 }
 
-func NewApplicationProcessor(db *database.Database, gitp *git.SimpleLocalProvider, argo *argo.Client, redis *redis.Client, agents *agents.ClientSet) *ApplicationProcessor {
+func NewApplicationProcessor(db *database.Database, gitp *git.SimpleLocalProvider, argo *argo.Client, redis *redis.Client, agents *agents.ClientSet, appstore *helm.Options) *ApplicationProcessor {
 	p := &ApplicationProcessor{
 		Agents:   agents,
 		Argo:     argo,
+		AppStore: appstore,
 		DataBase: &DatabseProcessor{DB: db.DB()},
-		Manifest: &ManifestProcessor{GitProvider: gitp},
+		Manifest: &ManifestProcessor{GitProvider: gitp, Review: &ReviewProcessor{DB: db.DB()}, ImagePolicy: &ImagePolicyProcessor{DB: db.DB()}, GatewayTLS: &GatewayTLSProcessor{DB: db.DB(), Agents: agents}},
 		Task:     &TaskProcessor{Workflowcli: workflow.NewClientFromRedisClient(redis.Client)},
 
 		argostatuscache: &sync.Map{},
@@ -298,7 +304,8 @@ func (h *ApplicationProcessor) Create(ctx context.Context, ref PathRef) error {
 			}
 		}
 		// set meta
-		if err := setManifestMeta(fs, manifestmeta{Creator: AuthorFromContext(ctx).Name, CreateAt: metav1.Now()}); err != nil {
+		meta := manifestmeta{Creator: AuthorFromContext(ctx).Name, CreateAt: metav1.Now(), Imported: getManifestMeta(fs).Imported}
+		if err := setManifestMeta(fs, meta); err != nil {
 			return err
 		}
 		return nil
@@ -357,19 +364,24 @@ func (h *ApplicationProcessor) deployKustomizeApplication(ctx context.Context, r
 	span, ctx := opentracing.StartSpanFromContext(ctx, "create kustomize app")
 	defer span.Finish()
 
-	var cloneurl string
-	err := h.Manifest.Func(ctx, ref, func(_ context.Context, repository Repository) error {
-		cloneurl = repository.repo.CloneURL()
+	var cloneurl, branch string
+	var auth *githttp.BasicAuth
+	var meta manifestmeta
+	err := h.Manifest.Func(ctx, ref, func(ctx context.Context, repository Repository) error {
+		cloneurl, branch, auth = repository.repo.CloneURL(), repository.repo.Branch(), repository.repo.Auth()
+		fs, err := repository.FS(ctx)
+		if err != nil {
+			return err
+		}
+		meta = getManifestMeta(fs)
 		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	opts := h.Manifest.GitProvider.Options()
-
 	// 目前只有kustomize用到 git repo
-	if _, err := h.createArgoGitRepo(ctx, cloneurl, opts.Username, opts.Password); err != nil {
+	if _, err := h.createArgoGitRepo(ctx, cloneurl, auth.Username, auth.Password); err != nil {
 		return nil, fmt.Errorf("create argo repo: %w", err)
 	}
 	setkustomizefunc := func(app *v1alpha1.Application) error {
@@ -402,14 +414,19 @@ func (h *ApplicationProcessor) deployKustomizeApplication(ctx context.Context, r
 			SyncOptions: v1alpha1.SyncOptions{"ApplyOutOfSyncOnly=true"},
 		}
 
+		commonAnnotations := map[string]string{
+			AnnotationRef: string(ref.JsonStringBase64()),
+		}
+		// 导入的资源若在 pod 模板上增加注解会导致 pod 重建
+		if meta.Imported {
+			commonAnnotations = map[string]string{}
+		}
 		app.Spec.Source = v1alpha1.ApplicationSource{
 			RepoURL:        cloneurl,
 			Path:           ref.Path(),
-			TargetRevision: ref.GitBranch(),
+			TargetRevision: branch,
 			Kustomize: &v1alpha1.ApplicationSourceKustomize{
-				CommonAnnotations: map[string]string{
-					AnnotationRef: string(ref.JsonStringBase64()),
-				},
+				CommonAnnotations: commonAnnotations,
 				// kustomize.yaml 中设置了 label，不需要再设置label了，避免对编排做出额外改动
 				CommonLabels: map[string]string{},
 				Images:       v1alpha1.KustomizeImages{}, // 设置为空
@@ -541,6 +558,19 @@ func (h *ApplicationProcessor) createArgoProjectForEnvironment(
 		return val.(*v1alpha1.AppProject), nil
 	}
 
+	// 环境的同步窗口
+	envpolicy, _, err := h.DataBase.GetSyncPolicies(ctx, PathRef{Tenant: ref.Tenant, Project: ref.Project, Env: ref.Env})
+	if err != nil {
+		return nil, err
+	}
+	if envpolicy != nil {
+		windows, err := ArgoSyncWindows(ref, namespace, envpolicy.Windows)
+		if err != nil {
+			return nil, err
+		}
+		argoprj.Spec.SyncWindows = windows
+	}
+
 	existproject, err := h.Argo.EnsureArgoProject(ctx, argoprj)
 	if err != nil {
 		return nil, err
@@ -578,6 +608,14 @@ func (h *ApplicationProcessor) createArgoGitRepo(ctx context.Context, gitCloneUr
 }
 
 func (h *ApplicationProcessor) Sync(ctx context.Context, ref PathRef, resources ...v1alpha1.SyncOperationResource) error {
+	// 修改已转为变更请求, 环境分支未变化, 合并后再同步
+	if SubmittedChangeRequest(ctx, ref) != nil {
+		return nil
+	}
+	// 同步的编排可能来自合并的变更请求或直接推送的提交, 同步前再次检查镜像策略
+	if err := h.Manifest.Func(ctx, ref, EnforceImagePolicy(false)); err != nil {
+		return err
+	}
 	if err := h.Argo.Sync(ctx, ref.FullName(), resources); err != nil {
 		if !errors.IsNotFound(err) && grpcstatus.Code(err) != grpccodes.NotFound {
 			return fmt.Errorf("sync app %s: %v", ref.Name, err)
@@ -647,6 +685,12 @@ func (h *ApplicationProcessor) deployArgoApplication(ctx context.Context, ref Pa
 	if err := updatespecfunc(argoapplication); err != nil {
 		return nil, err
 	}
+	// 自动同步及重试策略
+	envpolicy, apppolicy, err := h.DataBase.GetSyncPolicies(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	applySyncPolicy(argoapplication, EffectiveSyncPolicy(envpolicy, apppolicy))
 
 	// 这里可能涉及到argo app的更新,使用创建或者更新
 	existargoapp, err := h.Argo.EnsureArgoApp(ctx, argoapplication)
@@ -656,7 +700,17 @@ func (h *ApplicationProcessor) deployArgoApplication(ctx context.Context, ref Pa
 	return existargoapp, nil
 }
 
+// Remove 删除应用, 开启审核的环境中删除会越过审核直接删除集群中的资源, 因此拒绝删除
 func (h *ApplicationProcessor) Remove(ctx context.Context, ref PathRef) error {
+	if h.Manifest.Review != nil {
+		_, policy, err := h.Manifest.Review.Policy(ctx, ref)
+		if err != nil {
+			return err
+		}
+		if policy != nil && policy.Enabled {
+			return fmt.Errorf("environment %s requires review, applications can not be removed directly", ref.Env)
+		}
+	}
 	manifest, err := h.Manifest.Get(ctx, ref)
 	if err != nil {
 		return err
@@ -0,0 +1,228 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"kubegems.io/kubegems/pkg/log"
+)
+
+// 认为是人工修改的 field manager 前缀, kubectl edit/apply/patch/scale 等均以 kubectl 开头
+var manualFieldManagerPrefixes = []string{"kubectl", "k9s", "Mozilla"}
+
+// DriftReport 编排与集群中实际状态的漂移报告
+type DriftReport struct {
+	Tenant  string `json:"tenant"`
+	Project string `json:"project"`
+	// Environment 为空时为项目下所有环境的报告
+	Environment   string              `json:"environment,omitempty"`
+	CheckedAt     time.Time           `json:"checkedAt"`
+	OutOfSync     int                 `json:"outOfSync"`
+	ManualChanges int                 `json:"manualChanges"`
+	Applications  []*ApplicationDrift `json:"applications"`
+}
+
+type ApplicationDrift struct {
+	Environment  string           `json:"environment"`
+	Name         string           `json:"name"`
+	SyncStatus   string           `json:"syncStatus"`
+	HealthStatus string           `json:"healthStatus"`
+	LastSyncedAt *metav1.Time     `json:"lastSyncedAt,omitempty"`
+	Resources    []*ResourceDrift `json:"resources"`
+}
+
+type ResourceDrift struct {
+	ArgoResourceDiff
+	RequiresPruning bool `json:"requiresPruning"`
+	// ManualChanges 上次同步之后非 argocd 对该资源的修改
+	ManualChanges []ManualChange `json:"manualChanges,omitempty"`
+}
+
+type ManualChange struct {
+	Manager     string       `json:"manager"`
+	Operation   string       `json:"operation"`
+	Subresource string       `json:"subresource,omitempty"`
+	Time        *metav1.Time `json:"time,omitempty"`
+}
+
+// Key 资源在报告中的唯一标识
+func (r *ResourceDrift) Key(app string) string {
+	return strings.Join([]string{app, r.Group, r.Kind, r.Namespace, r.Name}, "/")
+}
+
+// ResourceKeys 报告中所有漂移的资源
+func (r *DriftReport) ResourceKeys() []string {
+	keys := []string{}
+	for _, app := range r.Applications {
+		for _, res := range app.Resources {
+			keys = append(keys, res.Key(app.Name))
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// DriftReport 遍历环境(ref.Env 为空时为项目下所有环境)中的 argo 应用, 列出 OutOfSync 的资源及其 diff
+func (p *ApplicationProcessor) DriftReport(ctx context.Context, ref PathRef) (*DriftReport, error) {
+	selector := labels.Set{
+		LabelKeyFrom: LabelValueFromApp,
+		LabelTenant:  ref.Tenant,
+		LabelProject: ref.Project,
+	}
+	if ref.Env != "" {
+		selector[LabelEnvironment] = ref.Env
+	}
+	argoappList, err := p.Argo.ListArgoApp(ctx, selector.AsSelector())
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DriftReport{
+		Tenant:       ref.Tenant,
+		Project:      ref.Project,
+		Environment:  ref.Env,
+		CheckedAt:    time.Now(),
+		Applications: []*ApplicationDrift{},
+	}
+	for i := range argoappList.Items {
+		argoapp := &argoappList.Items[i]
+		if argoapp.Status.Sync.Status != v1alpha1.SyncStatusCodeOutOfSync {
+			continue
+		}
+		appdrift, err := p.applicationDrift(ctx, argoapp)
+		if err != nil {
+			// 单个应用失败不影响整个报告
+			log.FromContextOrDiscard(ctx).Error(err, "application drift", "application", argoapp.Name)
+			continue
+		}
+		for _, res := range appdrift.Resources {
+			if len(res.ManualChanges) > 0 {
+				report.ManualChanges++
+			}
+		}
+		report.OutOfSync += len(appdrift.Resources)
+		report.Applications = append(report.Applications, appdrift)
+	}
+	sort.Slice(report.Applications, func(i, j int) bool {
+		if report.Applications[i].Environment != report.Applications[j].Environment {
+			return report.Applications[i].Environment < report.Applications[j].Environment
+		}
+		return report.Applications[i].Name < report.Applications[j].Name
+	})
+	return report, nil
+}
+
+func (p *ApplicationProcessor) applicationDrift(ctx context.Context, argoapp *v1alpha1.Application) (*ApplicationDrift, error) {
+	appdrift := &ApplicationDrift{
+		Environment:  argoapp.Labels[LabelEnvironment],
+		Name:         argoapp.Labels[LabelApplication],
+		SyncStatus:   string(argoapp.Status.Sync.Status),
+		HealthStatus: string(argoapp.Status.Health.Status),
+		Resources:    []*ResourceDrift{},
+	}
+	if op := argoapp.Status.OperationState; op != nil {
+		appdrift.LastSyncedAt = op.FinishedAt
+	}
+
+	outofsync := map[string]v1alpha1.ResourceStatus{}
+	for _, res := range argoapp.Status.Resources {
+		if res.Status == v1alpha1.SyncStatusCodeOutOfSync {
+			outofsync[argoResourceKey(res.Group, res.Kind, res.Namespace, res.Name)] = res
+		}
+	}
+	if len(outofsync) == 0 {
+		return appdrift, nil
+	}
+
+	argoname := argoapp.Name
+	diffs, err := p.Argo.DiffResources(ctx, &application.ResourcesQuery{ApplicationName: &argoname})
+	if err != nil {
+		return nil, err
+	}
+	var since time.Time
+	if appdrift.LastSyncedAt != nil {
+		since = appdrift.LastSyncedAt.Time
+	}
+	for _, diff := range diffs {
+		status, ok := outofsync[argoResourceKey(diff.Group, diff.Kind, diff.Namespace, diff.Name)]
+		if !ok {
+			continue
+		}
+		appdrift.Resources = append(appdrift.Resources, &ResourceDrift{
+			ArgoResourceDiff: convertArgoDiffToDiff(diff),
+			RequiresPruning:  status.RequiresPruning,
+			ManualChanges:    ManualChangesFromLiveState(diff.LiveState, since),
+		})
+	}
+	return appdrift, nil
+}
+
+func argoResourceKey(group, kind, namespace, name string) string {
+	return strings.Join([]string{group, kind, namespace, name}, "/")
+}
+
+// ManualChangesFromLiveState 根据 live state 中的 managedFields 找出 since 之后人工(kubectl 等)对资源的修改
+// argocd 同步时写入的 managedFields 时间不会晚于同步结束时间, 所以 since 之后的修改均来自其他客户端
+func ManualChangesFromLiveState(livestate string, since time.Time) []ManualChange {
+	if livestate == "" || livestate == "null" {
+		return nil
+	}
+	obj := &unstructured.Unstructured{}
+	if err := json.Unmarshal([]byte(livestate), &obj.Object); err != nil {
+		return nil
+	}
+	changes := []ManualChange{}
+	for _, field := range obj.GetManagedFields() {
+		if !isManualFieldManager(field.Manager) {
+			continue
+		}
+		// status 由控制器维护, 不计入编排漂移
+		if field.Subresource == "status" {
+			continue
+		}
+		if field.Time != nil && !field.Time.Time.After(since) {
+			continue
+		}
+		changes = append(changes, ManualChange{
+			Manager:     field.Manager,
+			Operation:   string(field.Operation),
+			Subresource: field.Subresource,
+			Time:        field.Time,
+		})
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+func isManualFieldManager(manager string) bool {
+	for _, prefix := range manualFieldManagerPrefixes {
+		if strings.HasPrefix(manager, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestManualChangesFromLiveState(t *testing.T) {
+	synced, _ := time.Parse(time.RFC3339, "2022-07-01T10:00:00Z")
+	livestate := `{
+	"apiVersion": "apps/v1",
+	"kind": "Deployment",
+	"metadata": {
+		"name": "nginx",
+		"managedFields": [
+			{"manager": "argocd-controller", "operation": "Update", "time": "2022-07-01T09:59:00Z"},
+			{"manager": "kubectl-client-side-apply", "operation": "Update", "time": "2022-07-01T09:59:30Z"},
+			{"manager": "kube-controller-manager", "operation": "Update", "subresource": "status", "time": "2022-07-01T11:00:00Z"},
+			{"manager": "kubectl-edit", "operation": "Update", "time": "2022-07-01T12:00:00Z"},
+			{"manager": "kubectl", "operation": "Update", "subresource": "status", "time": "2022-07-01T12:00:00Z"}
+		]
+	}
+}`
+	tests := []struct {
+		name      string
+		livestate string
+		since     time.Time
+		want      []string
+	}{
+		{
+			name:      "empty live state",
+			livestate: "",
+			since:     synced,
+			want:      nil,
+		},
+		{
+			name:      "changed after sync",
+			livestate: livestate,
+			since:     synced,
+			want:      []string{"kubectl-edit"},
+		},
+		{
+			name:      "never synced",
+			livestate: livestate,
+			since:     time.Time{},
+			want:      []string{"kubectl-client-side-apply", "kubectl-edit"},
+		},
+		{
+			name:      "invalid live state",
+			livestate: "{",
+			since:     synced,
+			want:      nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, change := range ManualChangesFromLiveState(tt.livestate, tt.since) {
+				got = append(got, change.Manager)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ManualChangesFromLiveState() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
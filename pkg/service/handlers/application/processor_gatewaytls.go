@@ -0,0 +1,106 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+	networkingv1 "k8s.io/api/networking/v1"
+	gemsv1beta1 "kubegems.io/kubegems/pkg/apis/gems/v1beta1"
+	"kubegems.io/kubegems/pkg/apis/networking"
+	"kubegems.io/kubegems/pkg/utils/agents"
+	"kubegems.io/kubegems/pkg/utils/certificate"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// GatewayTLSProcessor 在编排提交时为 ingress 声明租户网关上的证书
+// 证书由网关控制器复制到 ingress 所在的 namespace, ingress 本身只由应用编排管理
+type GatewayTLSProcessor struct {
+	DB     *gorm.DB
+	Agents *agents.ClientSet
+}
+
+// BindGatewayTLS 为编排中未配置 tls 的 ingress 域名设置网关上匹配的证书
+func BindGatewayTLS() RepositoryFunc {
+	return func(ctx context.Context, repository Repository) error {
+		if repository.gatewaytls == nil {
+			return nil
+		}
+		return repository.gatewaytls.Bind(ctx, repository)
+	}
+}
+
+func (p *GatewayTLSProcessor) Bind(ctx context.Context, repository Repository) error {
+	if repository.ref.Env == "" || repository.ref.Env == BaseEnv {
+		return nil
+	}
+	fs, err := repository.FS(ctx)
+	if err != nil {
+		return err
+	}
+	store := NewGitFsStore(fs)
+	objects, err := store.ListAll(ctx)
+	if err != nil {
+		return err
+	}
+	ingresses := []*networkingv1.Ingress{}
+	for _, obj := range objects {
+		if ingress, ok := obj.(*networkingv1.Ingress); ok && ingress.Spec.IngressClassName != nil {
+			ingresses = append(ingresses, ingress)
+		}
+	}
+	if len(ingresses) == 0 {
+		return nil
+	}
+
+	envdetails, err := (&DatabseProcessor{DB: p.DB}).GetEnvironmentWithCluster(repository.ref)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	cli, err := p.Agents.ClientOf(ctx, envdetails.ClusterName)
+	if err != nil {
+		return err
+	}
+	gateways := map[string]*gemsv1beta1.TenantGateway{}
+	for _, ingress := range ingresses {
+		class := *ingress.Spec.IngressClassName
+		tg, ok := gateways[class]
+		if !ok {
+			tgs := &gemsv1beta1.TenantGatewayList{}
+			if err := cli.List(ctx, tgs, client.MatchingLabels{networking.LabelIngressClass: class}); err != nil {
+				return err
+			}
+			if len(tgs.Items) > 0 {
+				tg = &tgs.Items[0]
+			}
+			gateways[class] = tg
+		}
+		if tg == nil || tg.Spec.Tenant != repository.ref.Tenant {
+			continue
+		}
+		if !certificate.BindIngressTLS(ingress, tg.Status.Certificates) {
+			continue
+		}
+		if err := store.Update(ctx, ingress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
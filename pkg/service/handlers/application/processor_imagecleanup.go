@@ -0,0 +1,127 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sort"
+
+	"kubegems.io/kubegems/pkg/utils/registry"
+	"kubegems.io/kubegems/pkg/utils/slice"
+)
+
+// cosign 签名, 证明及 sbom 等附属于镜像的 tag, 随镜像清理
+var cosignAttachmentTag = regexp.MustCompile(`^sha256-[0-9a-f]{64}\.(sig|att|sbom)$`)
+
+type ImageCleanupRequest struct {
+	// Keep 保留最新的 tag 数量
+	Keep int `json:"keep" binding:"required,min=1"`
+	// Exclude 不清理的 tag, 如正在使用的 tag
+	Exclude []string `json:"exclude"`
+	// DryRun 仅返回将被删除的 tag
+	DryRun bool `json:"dryRun"`
+}
+
+type ImageCleanupResult struct {
+	Kept    []registry.Tag `json:"kept"`
+	Deleted []registry.Tag `json:"deleted"`
+	// Failed 删除失败的 tag 及原因
+	Failed map[string]string `json:"failed,omitempty"`
+}
+
+// CleanupImageTags 保留最新的 tag 删除其余的 tag, 无法获取时间的 tag 以及与保留的 tag digest 相同的 tag 不会被删除
+func CleanupImageTags(ctx context.Context, reg registry.Registry, image string, req ImageCleanupRequest) (*ImageCleanupResult, error) {
+	if !registry.HasCapability(reg, registry.CapabilityDelete) {
+		return nil, registry.ErrUnsupported
+	}
+	tags, err := reg.ListTags(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+	for i, tag := range tags {
+		if tag.PushedAt != nil && tag.Digest != "" || cosignAttachmentTag.MatchString(tag.Name) {
+			continue
+		}
+		manifest, err := reg.GetManifest(ctx, registry.WithReference(image, tag.Name))
+		if err != nil {
+			continue
+		}
+		tags[i].Digest = manifest.Digest
+		if tags[i].PushedAt == nil {
+			tags[i].PushedAt = manifest.Created
+		}
+	}
+
+	result := &ImageCleanupResult{Kept: []registry.Tag{}, Deleted: []registry.Tag{}}
+	kept, candidates := planImageCleanup(tags, req.Keep, req.Exclude)
+	result.Kept = kept
+	if req.DryRun {
+		result.Deleted = candidates
+		return result, nil
+	}
+	deleted := map[string]bool{}
+	for _, tag := range candidates {
+		// 同一 digest 的 tag 在删除 manifest 时一起删除
+		if !deleted[tag.Digest] {
+			if err := reg.Delete(ctx, registry.WithReference(image, tag.Digest)); err != nil {
+				if errors.Is(err, registry.ErrUnsupported) {
+					return nil, err
+				}
+				if result.Failed == nil {
+					result.Failed = map[string]string{}
+				}
+				result.Failed[tag.Name] = err.Error()
+				continue
+			}
+			deleted[tag.Digest] = true
+		}
+		result.Deleted = append(result.Deleted, tag)
+	}
+	return result, nil
+}
+
+// planImageCleanup 按时间从新到旧排序, 返回保留及将被删除的 tag
+func planImageCleanup(tags []registry.Tag, keep int, exclude []string) ([]registry.Tag, []registry.Tag) {
+	sort.SliceStable(tags, func(i, j int) bool {
+		if tags[i].PushedAt == nil || tags[j].PushedAt == nil {
+			return tags[i].PushedAt != nil
+		}
+		return tags[i].PushedAt.After(*tags[j].PushedAt)
+	})
+	kept, candidates := []registry.Tag{}, []registry.Tag{}
+	keptDigests := map[string]bool{}
+	for _, tag := range tags {
+		if cosignAttachmentTag.MatchString(tag.Name) {
+			continue
+		}
+		if len(kept) < keep || tag.PushedAt == nil || tag.Digest == "" || slice.ContainStr(exclude, tag.Name) {
+			kept = append(kept, tag)
+			keptDigests[tag.Digest] = true
+			continue
+		}
+		candidates = append(candidates, tag)
+	}
+	deletes := []registry.Tag{}
+	for _, tag := range candidates {
+		if keptDigests[tag.Digest] {
+			kept = append(kept, tag)
+		} else {
+			deletes = append(deletes, tag)
+		}
+	}
+	return kept, deletes
+}
@@ -0,0 +1,93 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"kubegems.io/kubegems/pkg/utils/registry"
+)
+
+func Test_planImageCleanup(t *testing.T) {
+	at := func(day int) *time.Time {
+		t := time.Date(2022, 1, day, 0, 0, 0, 0, time.UTC)
+		return &t
+	}
+	names := func(tags []registry.Tag) []string {
+		ret := []string{}
+		for _, tag := range tags {
+			ret = append(ret, tag.Name)
+		}
+		return ret
+	}
+	tests := []struct {
+		name        string
+		tags        []registry.Tag
+		keep        int
+		exclude     []string
+		wantKept    []string
+		wantDeleted []string
+	}{
+		{
+			name: "keep latest",
+			tags: []registry.Tag{
+				{Name: "v1", Digest: "sha256:1", PushedAt: at(1)},
+				{Name: "v3", Digest: "sha256:3", PushedAt: at(3)},
+				{Name: "v2", Digest: "sha256:2", PushedAt: at(2)},
+			},
+			keep:        2,
+			wantKept:    []string{"v3", "v2"},
+			wantDeleted: []string{"v1"},
+		},
+		{
+			name: "keep excluded and unknown time",
+			tags: []registry.Tag{
+				{Name: "v1", Digest: "sha256:1", PushedAt: at(1)},
+				{Name: "v2", Digest: "sha256:2", PushedAt: at(2)},
+				{Name: "v3", Digest: "sha256:3", PushedAt: at(3)},
+				{Name: "unknown", Digest: "sha256:4"},
+			},
+			keep:        1,
+			exclude:     []string{"v1"},
+			wantKept:    []string{"v3", "v1", "unknown"},
+			wantDeleted: []string{"v2"},
+		},
+		{
+			name: "same digest as kept tag",
+			tags: []registry.Tag{
+				{Name: "latest", Digest: "sha256:2", PushedAt: at(3)},
+				{Name: "v2", Digest: "sha256:2", PushedAt: at(2)},
+				{Name: "v1", Digest: "sha256:1", PushedAt: at(1)},
+				{Name: "sha256-" + "1111111111111111111111111111111111111111111111111111111111111111" + ".sig", Digest: "sha256:s", PushedAt: at(4)},
+			},
+			keep:        1,
+			wantKept:    []string{"latest", "v2"},
+			wantDeleted: []string{"v1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, deleted := planImageCleanup(tt.tags, tt.keep, tt.exclude)
+			if !reflect.DeepEqual(names(kept), tt.wantKept) {
+				t.Errorf("kept = %v, want %v", names(kept), tt.wantKept)
+			}
+			if !reflect.DeepEqual(names(deleted), tt.wantDeleted) {
+				t.Errorf("deleted = %v, want %v", names(deleted), tt.wantDeleted)
+			}
+		})
+	}
+}
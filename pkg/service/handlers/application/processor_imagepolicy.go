@@ -0,0 +1,407 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/goharbor/harbor/src/pkg/scan/vuln"
+	"gorm.io/gorm"
+	corev1 "k8s.io/api/core/v1"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/harbor"
+	"kubegems.io/kubegems/pkg/utils/registry"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/yaml"
+)
+
+// harbor 扫描成功的状态
+const harborScanStatusSuccess = "Success"
+
+// ImagePolicyOverrideTTL 越过镜像策略的有效期
+const ImagePolicyOverrideTTL = 7 * 24 * time.Hour
+
+// ImagePolicyViolationError 镜像不符合环境的镜像策略, 拒绝部署
+type ImagePolicyViolationError struct {
+	Violations []models.ImagePolicyViolation
+}
+
+func (e ImagePolicyViolationError) Error() string {
+	reasons := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		reasons = append(reasons, fmt.Sprintf("%s: %s", v.Image, v.Reason))
+	}
+	return "deployment rejected by image policy: " + strings.Join(reasons, "; ")
+}
+
+// ImagePolicyOverride 管理员越过镜像策略部署, 会记录越过的原因及违反的规则
+type ImagePolicyOverride struct {
+	Operator string
+	Reason   string
+}
+
+// ImagePolicyProcessor 在编排提交及同步前使用环境的镜像策略检查渲染后编排中的镜像
+type ImagePolicyProcessor struct {
+	DB *gorm.DB
+}
+
+// Policy 返回环境及启用的镜像策略, 基础编排或未启用策略时返回的策略为 nil
+func (p *ImagePolicyProcessor) Policy(ctx context.Context, ref PathRef) (*models.Environment, *models.ImagePolicy, error) {
+	if ref.Env == "" || ref.Env == BaseEnv {
+		return nil, nil, nil
+	}
+	env, err := (&DatabseProcessor{DB: p.DB}).GetEnvironment(ctx, ref)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	policy := &models.ImagePolicy{}
+	if err := p.DB.WithContext(ctx).Where("environment_id = ?", env.ID).Take(policy).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return env, nil, nil
+		}
+		return nil, nil, err
+	}
+	if !policy.Enabled {
+		return env, nil, nil
+	}
+	return env, policy, nil
+}
+
+// EnforceImagePolicy 检查编排中的镜像, pin 为 true 时将镜像固定为检查时的 digest 并写回编排
+func EnforceImagePolicy(pin bool) RepositoryFunc {
+	return func(ctx context.Context, repository Repository) error {
+		if repository.imagepolicy == nil {
+			return nil
+		}
+		return repository.imagepolicy.Enforce(ctx, repository, pin)
+	}
+}
+
+// Enforce 使用环境的镜像策略检查编排渲染后的镜像, 被管理员越过的镜像仅检查 digest
+// 检查的是镜像 digest 对应的内容, pin 为 true 时将 digest 写回编排, 避免检查后 tag 被重新推送
+func (p *ImagePolicyProcessor) Enforce(ctx context.Context, repository Repository, pin bool) error {
+	env, policy, err := p.Policy(ctx, repository.ref)
+	if err != nil || policy == nil {
+		return err
+	}
+	overridden, err := p.overriddenImages(ctx, env.ID, repository.ref.Name)
+	if err != nil {
+		return err
+	}
+	fs, err := repository.FS(ctx)
+	if err != nil {
+		return err
+	}
+	store := NewGitFsStore(fs)
+	objects, err := store.ListAll(ctx)
+	if err != nil {
+		return err
+	}
+	kustomization := &types.Kustomization{}
+	if content, err := util.ReadFile(fs, KustimizationFilename); err == nil {
+		_ = yaml.Unmarshal(content, kustomization)
+	}
+
+	violations := []models.ImagePolicyViolation{}
+	pinned := map[string]string{}
+	check := func(image string) string {
+		if image == "" {
+			return image
+		}
+		if digested, ok := pinned[image]; ok {
+			return digested
+		}
+		digested, imageviolations := p.evaluate(ctx, policy, env.ProjectID, image)
+		// 越过仅对越过时的 digest 生效, tag 被重新推送后需要重新检查
+		if key := imageDigestKey(digested); key != "" && overridden[key] {
+			imageviolations = digestViolations(imageviolations)
+		}
+		violations = append(violations, imageviolations...)
+		pinned[image] = digested
+		return digested
+	}
+
+	// kustomization 中的 images 会替换同名镜像, 以替换后的镜像为准
+	kustomizationUpdated := false
+	for i, replacement := range kustomization.Images {
+		image := kustomizeImage(replacement)
+		digested := check(image)
+		if _, digest := splitImageDigest(digested); digest != "" && digest != replacement.Digest {
+			kustomization.Images[i].Digest = digest
+			kustomizationUpdated = true
+		}
+	}
+	for _, obj := range objects {
+		updated := false
+		ObjectPodTemplateFunc(obj, func(template *corev1.PodTemplateSpec) {
+			for _, containers := range [][]corev1.Container{template.Spec.InitContainers, template.Spec.Containers} {
+				for i, c := range containers {
+					if kustomizeImageReplaced(kustomization.Images, c.Image) {
+						continue
+					}
+					if digested := check(c.Image); digested != c.Image {
+						containers[i].Image = digested
+						updated = true
+					}
+				}
+			}
+		})
+		if updated && pin {
+			if err := store.Update(ctx, obj); err != nil {
+				return err
+			}
+		}
+	}
+	if len(violations) > 0 {
+		return ImagePolicyViolationError{Violations: violations}
+	}
+	if kustomizationUpdated && pin {
+		content, err := yaml.Marshal(kustomization)
+		if err != nil {
+			return err
+		}
+		return util.WriteFile(fs, KustimizationFilename, content, os.ModePerm)
+	}
+	return nil
+}
+
+// overriddenImages 管理员越过镜像策略且未过期的镜像, 以 imageDigestKey 为 key
+func (p *ImagePolicyProcessor) overriddenImages(ctx context.Context, envid uint, name string) (map[string]bool, error) {
+	overrides := []models.ImagePolicyOverride{}
+	if err := p.DB.WithContext(ctx).
+		Where("environment_id = ? and application_name = ? and expires_at > ?", envid, name, time.Now()).
+		Find(&overrides).Error; err != nil {
+		return nil, err
+	}
+	images := map[string]bool{}
+	for _, override := range overrides {
+		for _, image := range override.Images {
+			if key := imageDigestKey(image); key != "" {
+				images[key] = true
+			}
+		}
+	}
+	return images, nil
+}
+
+// Evaluate 返回镜像违反的策略规则
+func (p *ImagePolicyProcessor) Evaluate(ctx context.Context, policy *models.ImagePolicy, projectid uint, images []string) []models.ImagePolicyViolation {
+	violations := []models.ImagePolicyViolation{}
+	if !policy.Enabled {
+		return violations
+	}
+	for _, image := range images {
+		if image == "" {
+			continue
+		}
+		_, imageviolations := p.evaluate(ctx, policy, projectid, image)
+		violations = append(violations, imageviolations...)
+	}
+	return violations
+}
+
+// evaluate 解析镜像的 digest 并检查 digest 对应的镜像, 返回固定了 digest 的镜像及违反的规则
+func (p *ImagePolicyProcessor) evaluate(ctx context.Context, policy *models.ImagePolicy, projectid uint, image string) (string, []models.ImagePolicyViolation) {
+	reg, err := registryOf(ctx, p.DB, image, projectid)
+	if err != nil {
+		return image, []models.ImagePolicyViolation{{
+			Image: image, Rule: models.ImagePolicyRuleDigest, Reason: fmt.Sprintf("cannot access registry: %v", err),
+		}}
+	}
+	digested := image
+	if _, digest := splitImageDigest(image); digest == "" {
+		manifest, err := reg.GetManifest(ctx, image)
+		if err != nil {
+			return image, []models.ImagePolicyViolation{{
+				Image: image, Rule: models.ImagePolicyRuleDigest, Reason: fmt.Sprintf("cannot resolve image digest: %v", err),
+			}}
+		}
+		digested = image + "@" + manifest.Digest
+	}
+	return digested, p.evaluateImage(ctx, policy, reg, digested)
+}
+
+func (p *ImagePolicyProcessor) evaluateImage(ctx context.Context, policy *models.ImagePolicy, reg registry.Registry, image string) []models.ImagePolicyViolation {
+	violations := []models.ImagePolicyViolation{}
+	violate := func(rule string, format string, args ...interface{}) {
+		violations = append(violations, models.ImagePolicyViolation{Image: image, Rule: rule, Reason: fmt.Sprintf(format, args...)})
+	}
+	if policy.MaxSeverity != "" || policy.BlockUnpublishable {
+		artifact, err := harborArtifact(ctx, reg, image)
+		if err != nil {
+			// 非 harbor 仓库中的镜像没有不可发布标记
+			if policy.MaxSeverity != "" {
+				violate(models.ImagePolicyRuleSeverity, "cannot get vulnerability report: %v", err)
+			}
+		} else {
+			if policy.BlockUnpublishable && isUnpublishable(artifact.Labels) {
+				violate(models.ImagePolicyRuleUnpublishable, "image is marked as unpublishable")
+			}
+			if policy.MaxSeverity != "" {
+				if reason := SeverityViolation(artifact.ScanOverview, vuln.Severity(policy.MaxSeverity)); reason != "" {
+					violate(models.ImagePolicyRuleSeverity, reason)
+				}
+			}
+		}
+	}
+	if policy.RequireSignature {
+		if len(policy.PublicKeys) == 0 {
+			violate(models.ImagePolicyRuleSignature, "no trusted public key configured")
+		} else {
+			if err := registry.VerifyCosignSignature(ctx, reg, image, policy.PublicKeys); err != nil {
+				violate(models.ImagePolicyRuleSignature, "signature verification failed: %v", err)
+			}
+		}
+	}
+	return violations
+}
+
+// OverrideImagePolicy 管理员越过镜像策略部署镜像, 镜像违反策略时记录越过的原因及违反的规则
+// 提交编排时越过记录未过期且 digest 未变化的镜像不再检查策略规则, 但仍需要可以解析 digest
+func (p *ApplicationProcessor) OverrideImagePolicy(ctx context.Context, ref PathRef, images []string, override ImagePolicyOverride) error {
+	env, policy, err := p.Manifest.ImagePolicy.Policy(ctx, ref)
+	if err != nil || policy == nil {
+		return err
+	}
+	// 记录越过时解析的 digest, 仅越过该 digest 对应的镜像内容
+	digestedImages, violations := []string{}, []models.ImagePolicyViolation{}
+	for _, image := range images {
+		if image == "" {
+			continue
+		}
+		digested, imageviolations := p.Manifest.ImagePolicy.evaluate(ctx, policy, env.ProjectID, image)
+		digestedImages = append(digestedImages, digested)
+		violations = append(violations, imageviolations...)
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	expires := time.Now().Add(ImagePolicyOverrideTTL)
+	record := &models.ImagePolicyOverride{
+		EnvironmentID:   env.ID,
+		ApplicationName: ref.Name,
+		Images:          digestedImages,
+		Violations:      violations,
+		Reason:          override.Reason,
+		Operator:        override.Operator,
+		ExpiresAt:       &expires,
+	}
+	return p.DataBase.DB.WithContext(ctx).Create(record).Error
+}
+
+// EvaluateImagePolicy 返回镜像违反的策略规则
+func (p *ApplicationProcessor) EvaluateImagePolicy(ctx context.Context, policy *models.ImagePolicy, projectid uint, images []string) []models.ImagePolicyViolation {
+	return p.Manifest.ImagePolicy.Evaluate(ctx, policy, projectid, images)
+}
+
+// kustomizeImage kustomization 中 images 替换后的镜像
+func kustomizeImage(image types.Image) string {
+	name := image.Name
+	if image.NewName != "" {
+		name = image.NewName
+	}
+	switch {
+	case image.Digest != "":
+		return name + "@" + image.Digest
+	case image.NewTag != "":
+		return name + ":" + image.NewTag
+	default:
+		return name
+	}
+}
+
+// kustomizeImageReplaced 镜像是否会被 kustomization 中的 images 替换
+func kustomizeImageReplaced(images []types.Image, image string) bool {
+	for _, replacement := range images {
+		if replacement.Name == registry.RepositoryName(image) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitImageDigest 拆分镜像的 digest, 没有 digest 时为空
+func splitImageDigest(image string) (string, string) {
+	if i := strings.Index(image, "@"); i >= 0 {
+		return image[:i], image[i+1:]
+	}
+	return image, ""
+}
+
+// imageDigestKey 镜像仓库名及 digest, 忽略 tag, 没有 digest 时为空
+func imageDigestKey(image string) string {
+	_, digest := splitImageDigest(image)
+	if digest == "" {
+		return ""
+	}
+	return registry.RepositoryName(image) + "@" + digest
+}
+
+// digestViolations 仅保留无法解析 digest 的违规
+func digestViolations(violations []models.ImagePolicyViolation) []models.ImagePolicyViolation {
+	ret := []models.ImagePolicyViolation{}
+	for _, v := range violations {
+		if v.Rule == models.ImagePolicyRuleDigest {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+// SeverityViolation 根据 harbor 扫描结果判断漏洞等级是否超过 max, 未扫描或扫描未完成也视为不符合
+func SeverityViolation(overviews map[string]vuln.NativeReportSummary, max vuln.Severity) string {
+	if len(overviews) == 0 {
+		return "image has not been scanned"
+	}
+	for _, overview := range overviews {
+		if overview.ScanStatus != harborScanStatusSuccess {
+			return fmt.Sprintf("vulnerability scan status is %s", overview.ScanStatus)
+		}
+		if overview.Severity.Code() > max.Code() {
+			reason := fmt.Sprintf("image has %s vulnerabilities, the maximum allowed severity is %s", overview.Severity, max)
+			if overview.Summary != nil {
+				reason += fmt.Sprintf(" (%d %s)", overview.Summary.Summary[overview.Severity], overview.Severity)
+			}
+			return reason
+		}
+	}
+	return ""
+}
+
+func harborArtifact(ctx context.Context, reg registry.Registry, image string) (*harbor.Artifact, error) {
+	harborreg, ok := reg.(*registry.Harbor)
+	if !ok {
+		return nil, fmt.Errorf("%s registry does not support vulnerability scanning", reg.Kind())
+	}
+	return harborreg.Client.GetArtifact(ctx, image, harbor.GetArtifactOptions{WithScanOverview: true, WithLabel: true})
+}
+
+func isUnpublishable(labels []harbor.Label) bool {
+	for _, label := range labels {
+		if label.Name == unpublishableLabelKey {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"testing"
+
+	"github.com/goharbor/harbor/src/pkg/scan/vuln"
+	"sigs.k8s.io/kustomize/api/types"
+)
+
+func TestSeverityViolation(t *testing.T) {
+	report := func(status string, severity vuln.Severity) map[string]vuln.NativeReportSummary {
+		return map[string]vuln.NativeReportSummary{
+			"application/vnd.security.vulnerability.report; version=1.1": {
+				ScanStatus: status,
+				Severity:   severity,
+				Summary:    &vuln.VulnerabilitySummary{Total: 3, Summary: vuln.SeveritySummary{severity: 3}},
+			},
+		}
+	}
+	tests := []struct {
+		name      string
+		overviews map[string]vuln.NativeReportSummary
+		max       vuln.Severity
+		want      string
+	}{
+		{name: "not scanned", max: vuln.High, want: "image has not been scanned"},
+		{name: "scanning", overviews: report("Running", vuln.None), max: vuln.High, want: "vulnerability scan status is Running"},
+		{name: "allowed", overviews: report("Success", vuln.High), max: vuln.High},
+		{name: "no vulnerabilities", overviews: report("Success", vuln.None), max: vuln.None},
+		{
+			name:      "critical",
+			overviews: report("Success", vuln.Critical),
+			max:       vuln.High,
+			want:      "image has Critical vulnerabilities, the maximum allowed severity is High (3 Critical)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SeverityViolation(tt.overviews, tt.max); got != tt.want {
+				t.Errorf("SeverityViolation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKustomizeImage(t *testing.T) {
+	tests := []struct {
+		name  string
+		image types.Image
+		want  string
+	}{
+		{name: "name only", image: types.Image{Name: "nginx"}, want: "nginx"},
+		{name: "new tag", image: types.Image{Name: "nginx", NewTag: "1.21"}, want: "nginx:1.21"},
+		{name: "new name", image: types.Image{Name: "nginx", NewName: "registry.example.com/nginx", NewTag: "1.21"}, want: "registry.example.com/nginx:1.21"},
+		{name: "digest over tag", image: types.Image{Name: "nginx", NewTag: "1.21", Digest: "sha256:abc"}, want: "nginx@sha256:abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := kustomizeImage(tt.image); got != tt.want {
+				t.Errorf("kustomizeImage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitImageDigest(t *testing.T) {
+	tests := []struct {
+		image      string
+		wantName   string
+		wantDigest string
+	}{
+		{image: "nginx:1.21", wantName: "nginx:1.21"},
+		{image: "nginx:1.21@sha256:abc", wantName: "nginx:1.21", wantDigest: "sha256:abc"},
+		{image: "registry.example.com:5000/app@sha256:abc", wantName: "registry.example.com:5000/app", wantDigest: "sha256:abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			name, digest := splitImageDigest(tt.image)
+			if name != tt.wantName || digest != tt.wantDigest {
+				t.Errorf("splitImageDigest() = %v, %v, want %v, %v", name, digest, tt.wantName, tt.wantDigest)
+			}
+		})
+	}
+}
+
+func TestImageDigestKey(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{image: "nginx:1.21"},
+		{image: "nginx:1.21@sha256:abc", want: "nginx@sha256:abc"},
+		{image: "nginx:1.22@sha256:abc", want: "nginx@sha256:abc"},
+		{image: "registry.example.com:5000/app@sha256:abc", want: "registry.example.com:5000/app@sha256:abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			if got := imageDigestKey(tt.image); got != tt.want {
+				t.Errorf("imageDigestKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// argocd 用于跟踪资源所属应用的 label
+const LabelArgoInstance = "app.kubernetes.io/instance"
+
+// argocd 使用 annotation 跟踪资源时的注解, 格式为 <app>:<group>/<kind>:<namespace>/<name>
+const AnnotationArgoTrackingID = "argocd.argoproj.io/tracking-id"
+
+// managedByApplication 返回管理资源的 argo 应用, 未被管理时返回空
+func managedByApplication(obj client.Object) string {
+	if app := obj.GetLabels()[LabelArgoInstance]; app != "" {
+		return app
+	}
+	if id := obj.GetAnnotations()[AnnotationArgoTrackingID]; id != "" {
+		return strings.SplitN(id, ":", 2)[0]
+	}
+	return ""
+}
+
+type importableKind struct {
+	gvk     schema.GroupVersionKind
+	newList func() client.ObjectList
+	newObj  func() client.Object
+}
+
+// 支持导入的资源类型
+var importableKinds = map[string]importableKind{
+	"Deployment": {
+		gvk:     appsv1.SchemeGroupVersion.WithKind("Deployment"),
+		newList: func() client.ObjectList { return &appsv1.DeploymentList{} },
+		newObj:  func() client.Object { return &appsv1.Deployment{} },
+	},
+	"StatefulSet": {
+		gvk:     appsv1.SchemeGroupVersion.WithKind("StatefulSet"),
+		newList: func() client.ObjectList { return &appsv1.StatefulSetList{} },
+		newObj:  func() client.Object { return &appsv1.StatefulSet{} },
+	},
+	"Service": {
+		gvk:     corev1.SchemeGroupVersion.WithKind("Service"),
+		newList: func() client.ObjectList { return &corev1.ServiceList{} },
+		newObj:  func() client.Object { return &corev1.Service{} },
+	},
+	"ConfigMap": {
+		gvk:     corev1.SchemeGroupVersion.WithKind("ConfigMap"),
+		newList: func() client.ObjectList { return &corev1.ConfigMapList{} },
+		newObj:  func() client.Object { return &corev1.ConfigMap{} },
+	},
+	"Ingress": {
+		gvk:     networkingv1.SchemeGroupVersion.WithKind("Ingress"),
+		newList: func() client.ObjectList { return &networkingv1.IngressList{} },
+		newObj:  func() client.Object { return &networkingv1.Ingress{} },
+	},
+}
+
+// 导入时去除的运行时注解
+var importIgnoredAnnotations = []string{
+	corev1.LastAppliedConfigAnnotation,
+	"deployment.kubernetes.io/revision",
+	AnnotationRef,
+	AnnotationArgoTrackingID,
+}
+
+type ImportResource struct {
+	Kind string `json:"kind" binding:"required"`
+	Name string `json:"name" binding:"required"`
+}
+
+type ImportCandidate struct {
+	ImportResource
+	CreationTimestamp metav1.Time `json:"creationTimestamp"`
+}
+
+type ImportRequest struct {
+	// Name 新建的应用编排名称
+	Name        string           `json:"name" binding:"required"`
+	Description string           `json:"description"`
+	Resources   []ImportResource `json:"resources" binding:"required,min=1,dive"`
+	// Sync 导入后立即同步, 接管集群中的资源
+	Sync bool `json:"sync"`
+}
+
+// ImportCandidates 列出环境 namespace 下可以导入的资源
+func (h *ApplicationProcessor) ImportCandidates(ctx context.Context, ref PathRef) ([]ImportCandidate, error) {
+	envdetails, err := h.DataBase.GetEnvironmentWithCluster(ref)
+	if err != nil {
+		return nil, err
+	}
+	cli, err := h.Agents.ClientOf(ctx, envdetails.ClusterName)
+	if err != nil {
+		return nil, err
+	}
+	candidates := []ImportCandidate{}
+	for kind, importable := range importableKinds {
+		list := importable.newList()
+		if err := cli.List(ctx, list, client.InNamespace(envdetails.Namespace)); err != nil {
+			return nil, err
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			obj, ok := item.(client.Object)
+			if !ok {
+				continue
+			}
+			// 由其他资源创建的资源随其所有者一起管理
+			if len(obj.GetOwnerReferences()) > 0 {
+				continue
+			}
+			if kind == "ConfigMap" && obj.GetName() == "kube-root-ca.crt" {
+				continue
+			}
+			// 已被其他 argo 应用管理的资源不能导入, 否则两个应用会互相覆盖
+			if managedByApplication(obj) != "" {
+				continue
+			}
+			candidates = append(candidates, ImportCandidate{
+				ImportResource:    ImportResource{Kind: kind, Name: obj.GetName()},
+				CreationTimestamp: obj.GetCreationTimestamp(),
+			})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Kind != candidates[j].Kind {
+			return candidates[i].Kind < candidates[j].Kind
+		}
+		return candidates[i].Name < candidates[j].Name
+	})
+	return candidates, nil
+}
+
+// Import 将环境 namespace 下已有的资源写入新的应用编排, 并部署为 argo 应用
+// 导入的编排不设置 commonLabels 及 pod 模板注解, 接管时不会修改 selector, 也不会重建 pod
+func (h *ApplicationProcessor) Import(ctx context.Context, ref PathRef, req ImportRequest) error {
+	envdetails, err := h.DataBase.GetEnvironmentWithCluster(ref)
+	if err != nil {
+		return err
+	}
+	cli, err := h.Agents.ClientOf(ctx, envdetails.ClusterName)
+	if err != nil {
+		return err
+	}
+
+	files := map[string][]byte{}
+	for _, res := range req.Resources {
+		importable, ok := importableKinds[res.Kind]
+		if !ok {
+			return fmt.Errorf("unsupported import kind %s", res.Kind)
+		}
+		obj := importable.newObj()
+		if err := cli.Get(ctx, client.ObjectKey{Namespace: envdetails.Namespace, Name: res.Name}, obj); err != nil {
+			return err
+		}
+		if app := managedByApplication(obj); app != "" {
+			return fmt.Errorf("%s %s is managed by application %s", res.Kind, res.Name, app)
+		}
+		content, err := importedObjectContent(obj, importable.gvk)
+		if err != nil {
+			return fmt.Errorf("import %s %s: %w", res.Kind, res.Name, err)
+		}
+		files[strings.ToLower(res.Kind)+"-"+res.Name+".yaml"] = content
+	}
+
+	// 写入项目编排
+	baseref := PathRef{Tenant: ref.Tenant, Project: ref.Project, Name: ref.Name}
+	importfunc := func(ctx context.Context, fs billy.Filesystem) error {
+		if fis, _ := fs.ReadDir("."); len(fis) > 0 {
+			return fmt.Errorf("manifest %s already exists", ref.Name)
+		}
+		for filename, content := range files {
+			if err := util.WriteFile(fs, filename, content, os.ModePerm); err != nil {
+				return err
+			}
+		}
+		if err := InitOrUpdateKustomization(fs, nil, nil); err != nil {
+			return err
+		}
+		if err := util.WriteFile(fs, ReadmeFilename, []byte(req.Description), os.ModePerm); err != nil {
+			return err
+		}
+		return setManifestMeta(fs, manifestmeta{Creator: AuthorFromContext(ctx).Name, CreateAt: metav1.Now(), Imported: true})
+	}
+	if err := h.Manifest.UpdateContentFunc(ctx, baseref, importfunc, "import from "+envdetails.Namespace); err != nil {
+		return err
+	}
+
+	// 部署至环境
+	if err := h.Create(ctx, ref); err != nil {
+		return err
+	}
+	if req.Sync {
+		return h.Sync(ctx, ref)
+	}
+	return nil
+}
+
+// importedObjectContent 去除资源中的运行时字段及状态, 返回可写入编排的 yaml
+func importedObjectContent(obj runtime.Object, gvk schema.GroupVersionKind) ([]byte, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{Object: content}
+	u.SetGroupVersionKind(gvk)
+	cleanImportedObject(u)
+	return yaml.Marshal(u.Object)
+}
+
+func cleanImportedObject(u *unstructured.Unstructured) {
+	unstructured.RemoveNestedField(u.Object, "status")
+	for _, field := range []string{
+		"namespace", "uid", "resourceVersion", "generation", "creationTimestamp",
+		"deletionTimestamp", "deletionGracePeriodSeconds", "managedFields", "selfLink", "ownerReferences",
+	} {
+		unstructured.RemoveNestedField(u.Object, "metadata", field)
+	}
+	annotations := u.GetAnnotations()
+	for _, key := range importIgnoredAnnotations {
+		delete(annotations, key)
+	}
+	if len(annotations) == 0 {
+		unstructured.RemoveNestedField(u.Object, "metadata", "annotations")
+	} else {
+		u.SetAnnotations(annotations)
+	}
+	// argo 同步时会重新设置
+	labels := u.GetLabels()
+	delete(labels, LabelArgoInstance)
+	if len(labels) == 0 {
+		unstructured.RemoveNestedField(u.Object, "metadata", "labels")
+	} else {
+		u.SetLabels(labels)
+	}
+
+	switch u.GetKind() {
+	case "Deployment", "StatefulSet":
+		unstructured.RemoveNestedField(u.Object, "spec", "template", "metadata", "creationTimestamp")
+		if templates, ok, _ := unstructured.NestedSlice(u.Object, "spec", "volumeClaimTemplates"); ok {
+			for _, template := range templates {
+				if pvc, ok := template.(map[string]interface{}); ok {
+					unstructured.RemoveNestedField(pvc, "status")
+					unstructured.RemoveNestedField(pvc, "metadata", "creationTimestamp")
+				}
+			}
+			_ = unstructured.SetNestedSlice(u.Object, templates, "spec", "volumeClaimTemplates")
+		}
+	case "Service":
+		// clusterIP 由集群分配, headless service 需要保留
+		if clusterIP, _, _ := unstructured.NestedString(u.Object, "spec", "clusterIP"); clusterIP != corev1.ClusterIPNone {
+			unstructured.RemoveNestedField(u.Object, "spec", "clusterIP")
+			unstructured.RemoveNestedField(u.Object, "spec", "clusterIPs")
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+)
+
+func Test_importedObjectContent(t *testing.T) {
+	now := metav1.Now()
+	tests := []struct {
+		name string
+		obj  runtime.Object
+		gvk  schema.GroupVersionKind
+		want string
+	}{
+		{
+			name: "deployment",
+			obj: &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "nginx",
+					Namespace:         "default",
+					UID:               "uid",
+					ResourceVersion:   "100",
+					Generation:        3,
+					CreationTimestamp: now,
+					Labels:            map[string]string{"app": "nginx", LabelArgoInstance: "other"},
+					Annotations: map[string]string{
+						"deployment.kubernetes.io/revision": "3",
+						corev1.LastAppliedConfigAnnotation:  "{}",
+					},
+					ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "kubectl"}},
+				},
+				Spec: appsv1.DeploymentSpec{
+					Replicas: pointer.Int32(2),
+					Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "nginx"}},
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "nginx"}},
+						Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx", Image: "nginx"}}},
+					},
+				},
+				Status: appsv1.DeploymentStatus{Replicas: 2},
+			},
+			gvk: appsv1.SchemeGroupVersion.WithKind("Deployment"),
+			want: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  labels:
+    app: nginx
+  name: nginx
+spec:
+  replicas: 2
+  selector:
+    matchLabels:
+      app: nginx
+  strategy: {}
+  template:
+    metadata:
+      labels:
+        app: nginx
+    spec:
+      containers:
+      - image: nginx
+        name: nginx
+        resources: {}
+`,
+		},
+		{
+			name: "service",
+			obj: &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "default"},
+				Spec: corev1.ServiceSpec{
+					ClusterIP:  "10.0.0.1",
+					ClusterIPs: []string{"10.0.0.1"},
+					Ports:      []corev1.ServicePort{{Port: 80, TargetPort: intstr.FromInt(8080)}},
+				},
+			},
+			gvk: corev1.SchemeGroupVersion.WithKind("Service"),
+			want: `apiVersion: v1
+kind: Service
+metadata:
+  name: nginx
+spec:
+  ports:
+  - port: 80
+    targetPort: 8080
+`,
+		},
+		{
+			name: "headless service",
+			obj: &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "nginx"},
+				Spec:       corev1.ServiceSpec{ClusterIP: corev1.ClusterIPNone},
+			},
+			gvk: corev1.SchemeGroupVersion.WithKind("Service"),
+			want: `apiVersion: v1
+kind: Service
+metadata:
+  name: nginx
+spec:
+  clusterIP: None
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := importedObjectContent(tt.obj, tt.gvk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("importedObjectContent() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_managedByApplication(t *testing.T) {
+	tests := []struct {
+		name string
+		meta metav1.ObjectMeta
+		want string
+	}{
+		{name: "unmanaged", meta: metav1.ObjectMeta{Labels: map[string]string{"app": "nginx"}}},
+		{name: "instance label", meta: metav1.ObjectMeta{Labels: map[string]string{LabelArgoInstance: "other"}}, want: "other"},
+		{
+			name: "tracking annotation",
+			meta: metav1.ObjectMeta{Annotations: map[string]string{AnnotationArgoTrackingID: "other:apps/Deployment:default/nginx"}},
+			want: "other",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := managedByApplication(&corev1.ConfigMap{ObjectMeta: tt.meta}); got != tt.want {
+				t.Errorf("managedByApplication() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -44,6 +44,12 @@ const (
 
 type ManifestProcessor struct {
 	GitProvider *git.SimpleLocalProvider
+	// Review 为空时不检查环境的审核策略
+	Review *ReviewProcessor
+	// ImagePolicy 为空时不检查环境的镜像策略
+	ImagePolicy *ImagePolicyProcessor
+	// GatewayTLS 为空时不为 ingress 设置网关证书
+	GatewayTLS *GatewayTLSProcessor
 }
 
 func NewManifestProcessor(GitProvider *git.SimpleLocalProvider) (*ManifestProcessor, error) {
@@ -277,6 +283,8 @@ var detectKinds = []string{
 type manifestmeta struct {
 	Creator  string      `json:"creator"`
 	CreateAt metav1.Time `json:"createAt"`
+	// Imported 从集群中已有的资源导入, 部署时不在 pod 模板上增加注解, 避免接管时重建 pod
+	Imported bool `json:"imported,omitempty"`
 }
 
 // 从应用编排根目录获取编排详情
@@ -375,6 +383,13 @@ func decodeCreationTimestamp(ctx context.Context, repo Repository, manifests []M
 	}
 }
 
+func getManifestMeta(fs billy.Filesystem) manifestmeta {
+	meta := manifestmeta{}
+	content, _ := util.ReadFile(fs, MetaFilename)
+	_ = json.Unmarshal(content, &meta)
+	return meta
+}
+
 func setManifestMeta(fs billy.Filesystem, meta manifestmeta) error {
 	content, err := json.Marshal(meta)
 	if err != nil {
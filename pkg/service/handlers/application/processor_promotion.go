@@ -0,0 +1,263 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	rolloutsv1alpha1 "github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	corev1 "k8s.io/api/core/v1"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/git"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PromotionRejectedError 晋级条件不满足
+type PromotionRejectedError struct {
+	Reason string
+}
+
+func (e PromotionRejectedError) Error() string {
+	return "promotion rejected: " + e.Reason
+}
+
+type PromotionResult struct {
+	Revision string   `json:"revision"`
+	Images   []string `json:"images"`
+	// ChangeRequest 目标环境开启了审核时晋级转为的变更请求
+	ChangeRequest *models.ManifestChangeRequest `json:"changeRequest,omitempty"`
+}
+
+// Promote 将 from 环境中正在运行的编排版本及镜像digest晋级到 to 环境
+// to 环境的 kustomization.yaml 作为环境差异保留, 其余文件以 from 环境中的为准
+func (h *ApplicationProcessor) Promote(ctx context.Context, from, to PathRef, stage models.PromotionStage) (*PromotionResult, error) {
+	app, err := h.Argo.GetArgoApp(ctx, from.FullName())
+	if err != nil {
+		return nil, fmt.Errorf("get application %s in %s: %w", from.Name, from.Env, err)
+	}
+	revision := app.Status.Sync.Revision
+	if revision == "" {
+		return nil, PromotionRejectedError{Reason: fmt.Sprintf("application %s has not been deployed in %s", from.Name, from.Env)}
+	}
+	if stage.RequireHealthy {
+		if app.Status.Sync.Status != v1alpha1.SyncStatusCodeSynced || app.Status.Health.Status != "Healthy" {
+			return nil, PromotionRejectedError{Reason: fmt.Sprintf("application %s in %s is %s/%s", from.Name, from.Env, app.Status.Sync.Status, app.Status.Health.Status)}
+		}
+	}
+
+	envdetails, err := h.DataBase.GetEnvironmentWithCluster(from)
+	if err != nil {
+		return nil, err
+	}
+	cli, err := h.Agents.ClientOf(ctx, envdetails.ClusterName)
+	if err != nil {
+		return nil, err
+	}
+	if stage.RequireAnalysis {
+		if err := checkAnalysisRuns(ctx, cli, envdetails.Namespace, app); err != nil {
+			return nil, err
+		}
+	}
+	images, err := runningDigestImages(ctx, cli, envdetails.Namespace, from)
+	if err != nil {
+		return nil, err
+	}
+
+	// 源环境中运行版本的编排
+	var files []git.CommitFile
+	if err := h.Manifest.Func(ctx, from, Pull(), func(ctx context.Context, repository Repository) error {
+		commit, err := repository.HistoryFiles(ctx, revision)
+		if err != nil {
+			return err
+		}
+		files = commit.Files
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("read revision %s: %w", revision, err)
+	}
+
+	promotefunc := func(ctx context.Context, fs billy.Filesystem) error {
+		if err := overwriteManifestFiles(fs, files); err != nil {
+			return err
+		}
+		return FSStoreFunc(func(ctx context.Context, store GitStore) error {
+			return pinContentImages(ctx, store, images)
+		})(ctx, fs)
+	}
+	msg := fmt.Sprintf("promote from %s revision[%s] images%s", from.Env, revision, images)
+	ctx, submitted := WithSubmittedChangeRequests(ctx)
+	if err := h.Manifest.UpdateContentFunc(ctx, to, promotefunc, msg); err != nil {
+		return nil, err
+	}
+	if cr := submitted.Last(); cr != nil {
+		return &PromotionResult{Revision: revision, Images: images, ChangeRequest: cr}, nil
+	}
+	if err := h.Sync(ctx, to); err != nil {
+		return nil, err
+	}
+	// sync database
+	if manifest, err := h.Manifest.Get(ctx, to); err == nil {
+		if err := h.DataBase.SyncDeploy(ctx, to, DeploiedManifest{Manifest: *manifest}); err != nil {
+			log.Error(err, "sync database failed")
+		}
+	}
+	return &PromotionResult{Revision: revision, Images: images}, nil
+}
+
+// checkAnalysisRuns 应用下每个 Rollout 最近一次的分析需要成功
+func checkAnalysisRuns(ctx context.Context, cli client.Client, namespace string, app *v1alpha1.Application) error {
+	workloads := map[string]bool{}
+	for _, res := range app.Status.Resources {
+		workloads[res.Name] = true
+	}
+	runs := &rolloutsv1alpha1.AnalysisRunList{}
+	if err := cli.List(ctx, runs, client.InNamespace(namespace)); err != nil {
+		return err
+	}
+	latest := map[string]*rolloutsv1alpha1.AnalysisRun{}
+	for i, run := range runs.Items {
+		for _, owner := range run.OwnerReferences {
+			if owner.Kind != "Rollout" || !workloads[owner.Name] {
+				continue
+			}
+			if exist, ok := latest[owner.Name]; !ok || exist.CreationTimestamp.Before(&run.CreationTimestamp) {
+				latest[owner.Name] = &runs.Items[i]
+			}
+		}
+	}
+	for name, run := range latest {
+		switch run.Status.Phase {
+		case rolloutsv1alpha1.AnalysisPhaseSuccessful:
+		case rolloutsv1alpha1.AnalysisPhasePending, rolloutsv1alpha1.AnalysisPhaseRunning:
+			return PromotionRejectedError{Reason: fmt.Sprintf("analysis %s of %s is still %s", run.Name, name, run.Status.Phase)}
+		default:
+			return PromotionRejectedError{Reason: fmt.Sprintf("analysis %s of %s is %s: %s", run.Name, name, run.Status.Phase, run.Status.Message)}
+		}
+	}
+	return nil
+}
+
+// runningDigestImages 源环境中应用的pod实际运行的镜像, 以digest表示
+func runningDigestImages(ctx context.Context, cli client.Client, namespace string, ref PathRef) ([]string, error) {
+	pods := &corev1.PodList{}
+	if err := cli.List(ctx, pods, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	annotation := ref.JsonStringBase64()
+	imageset := map[string]bool{}
+	for _, pod := range pods.Items {
+		if pod.Annotations[AnnotationRef] != annotation {
+			continue
+		}
+		images := map[string]string{}
+		for _, c := range pod.Spec.Containers {
+			images[c.Name] = c.Image
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			if image, ok := images[status.Name]; ok {
+				imageset[DigestImage(image, status.ImageID)] = true
+			}
+		}
+	}
+	ret := make([]string, 0, len(imageset))
+	for image := range imageset {
+		ret = append(ret, image)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// DigestImage 使用 imageID 中的 digest 固定镜像版本, 无法获取 digest 时返回原镜像
+// eg. nginx:1.21 docker-pullable://nginx@sha256:xxx -> nginx@sha256:xxx
+func DigestImage(image, imageID string) string {
+	i := strings.LastIndex(imageID, "@")
+	if i < 0 || !strings.HasPrefix(imageID[i+1:], "sha256:") {
+		return image
+	}
+	return imageRepository(image) + imageID[i:]
+}
+
+// imageRepository 去除镜像的 tag 和 digest, 保留 registry 中的端口
+func imageRepository(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
+
+// overwriteManifestFiles 使用 files 覆盖编排, 保留已有的 kustomization.yaml 和元数据
+func overwriteManifestFiles(fs billy.Filesystem, files []git.CommitFile) error {
+	preserved := map[string][]byte{}
+	for _, name := range []string{KustimizationFilename, MetaFilename} {
+		if content, err := util.ReadFile(fs, name); err == nil {
+			preserved[name] = content
+		}
+	}
+	if err := util.RemoveAll(fs, "."); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if _, ok := preserved[f.Name]; ok {
+			continue
+		}
+		if err := util.WriteFile(fs, f.Name, []byte(f.Content), os.ModePerm); err != nil {
+			return err
+		}
+	}
+	for name, content := range preserved {
+		if err := util.WriteFile(fs, name, content, os.ModePerm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pinContentImages 替换同一镜像仓库的容器镜像, 不修改 istio version
+func pinContentImages(ctx context.Context, store GitStore, images []string) error {
+	objects, err := store.ListAll(ctx)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		updated := false
+		ObjectPodTemplateFunc(obj, func(template *corev1.PodTemplateSpec) {
+			for i, c := range template.Spec.Containers {
+				for _, image := range images {
+					if imageRepository(c.Image) == imageRepository(image) && c.Image != image {
+						template.Spec.Containers[i].Image = image
+						updated = true
+					}
+				}
+			}
+		})
+		if updated {
+			if err := store.Update(ctx, obj); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
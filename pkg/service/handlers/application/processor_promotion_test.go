@@ -0,0 +1,114 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"kubegems.io/kubegems/pkg/utils/git"
+)
+
+func TestDigestImage(t *testing.T) {
+	const digest = "sha256:4c0fdaa8b6341bfdeca5f18f7837462c80cff90527ee35ef185571e1c327beac"
+	tests := []struct {
+		name    string
+		image   string
+		imageID string
+		want    string
+	}{
+		{
+			name:    "docker pullable",
+			image:   "nginx:1.21",
+			imageID: "docker-pullable://nginx@" + digest,
+			want:    "nginx@" + digest,
+		},
+		{
+			name:    "registry with port",
+			image:   "registry.local:5000/library/nginx:1.21",
+			imageID: "registry.local:5000/library/nginx@" + digest,
+			want:    "registry.local:5000/library/nginx@" + digest,
+		},
+		{
+			name:    "already digest",
+			image:   "nginx@sha256:old",
+			imageID: "docker.io/library/nginx@" + digest,
+			want:    "nginx@" + digest,
+		},
+		{
+			name:    "no digest",
+			image:   "nginx:1.21",
+			imageID: digest,
+			want:    "nginx:1.21",
+		},
+		{
+			name:    "empty image id",
+			image:   "nginx",
+			imageID: "",
+			want:    "nginx",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DigestImage(tt.image, tt.imageID); got != tt.want {
+				t.Errorf("DigestImage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOverwriteManifestFiles(t *testing.T) {
+	fs := memfs.New()
+	existing := map[string]string{
+		KustimizationFilename: "namePrefix: prod-\n",
+		MetaFilename:          "creator: admin\n",
+		"removed.yaml":        "kind: ConfigMap\n",
+		"deployment.yaml":     "image: nginx:1.20\n",
+	}
+	for name, content := range existing {
+		if err := util.WriteFile(fs, name, []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := []git.CommitFile{
+		{Name: KustimizationFilename, Content: "namePrefix: dev-\n"},
+		{Name: "deployment.yaml", Content: "image: nginx:1.21\n"},
+		{Name: "config/service.yaml", Content: "kind: Service\n"},
+	}
+	if err := overwriteManifestFiles(fs, files); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		KustimizationFilename: "namePrefix: prod-\n",
+		MetaFilename:          "creator: admin\n",
+		"deployment.yaml":     "image: nginx:1.21\n",
+		"config/service.yaml": "kind: Service\n",
+	}
+	got := map[string]string{}
+	if err := ForFileContentFunc(fs, "", func(filename string, content []byte) error {
+		got[filename] = string(content)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("overwriteManifestFiles() = %v, want %v", got, want)
+	}
+}
@@ -23,8 +23,13 @@ import (
 )
 
 type Repository struct {
-	repo *git.Repository
-	path string
+	repo   *git.Repository
+	path   string
+	ref    PathRef
+	review *ReviewProcessor
+
+	imagepolicy *ImagePolicyProcessor
+	gatewaytls  *GatewayTLSProcessor
 }
 
 func (r *Repository) Diff(ctx context.Context, hash string) ([]git.FileDiff, error) {
@@ -53,7 +58,7 @@ func (h *ManifestProcessor) Func(ctx context.Context, ref PathRef, funcs ...Repo
 		log.FromContextOrDiscard(ctx).Error(err, "get repository")
 		return err
 	}
-	repo := &Repository{path: gitref.Path, repo: gitrepo}
+	repo := &Repository{path: gitref.Path, repo: gitrepo, ref: ref, review: h.Review, imagepolicy: h.ImagePolicy, gatewaytls: h.GatewayTLS}
 
 	for _, f := range funcs {
 		if err := f(ctx, *repo); err != nil {
@@ -86,6 +91,31 @@ func Commit(msg string) RepositoryFunc {
 		if msg == "" {
 			return nil
 		}
+		// ingress 使用租户网关上的证书
+		if err := BindGatewayTLS()(ctx, repository); err != nil {
+			return err
+		}
+		// 检查镜像策略并固定镜像 digest, 变更请求中的修改同样需要检查
+		if err := EnforceImagePolicy(true)(ctx, repository); err != nil {
+			return err
+		}
+		// 开启了审核的环境提交至变更分支
+		if repository.review != nil {
+			env, policy, err := repository.review.Policy(ctx, repository.ref)
+			if err != nil {
+				return err
+			}
+			if policy != nil && policy.Enabled {
+				cr, err := repository.review.Submit(ctx, repository, env, msg)
+				if err != nil {
+					return err
+				}
+				if cr != nil {
+					recordSubmittedChangeRequest(ctx, cr)
+				}
+				return nil
+			}
+		}
 		cm := &git.CommitMessage{
 			Message:   msg,
 			Committer: AuthorFromContext(ctx),
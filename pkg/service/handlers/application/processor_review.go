@@ -0,0 +1,203 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/git"
+)
+
+type submittedChangeRequestsKey struct{}
+
+// SubmittedChangeRequests 记录一次处理中因环境开启了审核而转为变更请求的修改
+type SubmittedChangeRequests struct {
+	mu    sync.Mutex
+	items []*models.ManifestChangeRequest
+}
+
+// Last 返回最近提交的变更请求, 没有时返回 nil
+func (s *SubmittedChangeRequests) Last() *models.ManifestChangeRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.items) == 0 {
+		return nil
+	}
+	return s.items[len(s.items)-1]
+}
+
+func (s *SubmittedChangeRequests) add(cr *models.ManifestChangeRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items = append(s.items, cr)
+}
+
+// WithSubmittedChangeRequests 返回记录变更请求的 ctx, 其中 Commit 转为的变更请求会记录在返回的 SubmittedChangeRequests 中
+func WithSubmittedChangeRequests(ctx context.Context) (context.Context, *SubmittedChangeRequests) {
+	submitted := &SubmittedChangeRequests{}
+	return context.WithValue(ctx, submittedChangeRequestsKey{}, submitted), submitted
+}
+
+// SubmittedChangeRequest 返回 ctx 中对 ref 提交的变更请求, 未提交时返回 nil
+func SubmittedChangeRequest(ctx context.Context, ref PathRef) *models.ManifestChangeRequest {
+	submitted, ok := ctx.Value(submittedChangeRequestsKey{}).(*SubmittedChangeRequests)
+	if !ok {
+		return nil
+	}
+	submitted.mu.Lock()
+	defer submitted.mu.Unlock()
+	for i := len(submitted.items) - 1; i >= 0; i-- {
+		if ChangeRequestRef(submitted.items[i]) == ref {
+			return submitted.items[i]
+		}
+	}
+	return nil
+}
+
+func recordSubmittedChangeRequest(ctx context.Context, cr *models.ManifestChangeRequest) {
+	if submitted, ok := ctx.Value(submittedChangeRequestsKey{}).(*SubmittedChangeRequests); ok {
+		submitted.add(cr)
+	}
+}
+
+// ReviewProcessor 处理环境编排的审核, 开启审核的环境中修改提交至变更分支
+type ReviewProcessor struct {
+	DB *gorm.DB
+}
+
+// Policy 返回环境的审核策略, 基础编排(base)不需要审核
+func (p *ReviewProcessor) Policy(ctx context.Context, ref PathRef) (*models.Environment, *models.ManifestReviewPolicy, error) {
+	if ref.Env == "" || ref.Env == BaseEnv {
+		return nil, nil, nil
+	}
+	env := &models.Environment{}
+	if err := p.DB.WithContext(ctx).
+		Joins("left join projects on projects.id = environments.project_id").
+		Joins("left join tenants on tenants.id = projects.tenant_id").
+		Where("tenants.tenant_name = ? and projects.project_name = ? and environments.environment_name = ?", ref.Tenant, ref.Project, ref.Env).
+		Take(env).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	policy := &models.ManifestReviewPolicy{}
+	if err := p.DB.WithContext(ctx).Where("environment_id = ?", env.ID).Take(policy).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return env, nil, nil
+		}
+		return nil, nil, err
+	}
+	return env, policy, nil
+}
+
+// Submit 将工作区的修改推送至变更分支并创建变更请求, 工作区没有修改时不创建并返回 nil
+func (p *ReviewProcessor) Submit(ctx context.Context, repository Repository, env *models.Environment, msg string) (*models.ManifestChangeRequest, error) {
+	ref := repository.ref
+	author := AuthorFromContext(ctx)
+	branch := fmt.Sprintf("changes/%s/%d", ref.GitBranch(), time.Now().UnixNano())
+
+	base, head, err := repository.repo.CommitPushBranch(ctx, repository.path, &git.CommitMessage{Message: msg, Committer: author}, branch)
+	if err != nil {
+		return nil, err
+	}
+	if head == "" {
+		return nil, nil
+	}
+	cr := &models.ManifestChangeRequest{
+		EnvironmentID:   env.ID,
+		TenantName:      ref.Tenant,
+		ProjectName:     ref.Project,
+		EnvironmentName: ref.Env,
+		ApplicationName: ref.Name,
+		Branch:          ref.GitBranch(),
+		ChangeBranch:    branch,
+		BaseCommit:      base,
+		HeadCommit:      head,
+		Title:           msg,
+		Author:          author.Name,
+		Status:          models.ChangeRequestStatusOpen,
+		Approvals:       models.Approvals{},
+	}
+	if err := p.DB.WithContext(ctx).Create(cr).Error; err != nil {
+		// 记录失败时清理变更分支
+		if delerr := repository.repo.DeleteBranch(ctx, branch); delerr != nil {
+			log.FromContextOrDiscard(ctx).Error(delerr, "delete change branch", "branch", branch)
+		}
+		return nil, err
+	}
+	return cr, nil
+}
+
+// ChangeRequestRef 变更请求对应的编排位置
+func ChangeRequestRef(cr *models.ManifestChangeRequest) PathRef {
+	return PathRef{Tenant: cr.TenantName, Project: cr.ProjectName, Env: cr.EnvironmentName, Name: cr.ApplicationName}
+}
+
+// ChangeRequestDiff 变更请求中修改的文件
+func (h *ManifestProcessor) ChangeRequestDiff(ctx context.Context, cr *models.ManifestChangeRequest) ([]git.FileDiff, error) {
+	var diffs []git.FileDiff
+	err := h.Func(ctx, ChangeRequestRef(cr), func(ctx context.Context, repository Repository) error {
+		hash := cr.HeadCommit
+		switch {
+		case cr.Status == models.ChangeRequestStatusMerged && cr.MergeCommit != "":
+			// 合并后变更分支已删除, 使用目标分支上的合并提交
+			hash = cr.MergeCommit
+		case cr.Status == models.ChangeRequestStatusOpen:
+			if err := repository.repo.FetchBranch(ctx, cr.ChangeBranch); err != nil {
+				return err
+			}
+		}
+		ret, err := repository.Diff(ctx, hash)
+		if err != nil {
+			return err
+		}
+		diffs = ret
+		return nil
+	})
+	return diffs, err
+}
+
+// MergeChangeRequest 合并变更分支至环境分支, 返回合并后的提交
+func (h *ManifestProcessor) MergeChangeRequest(ctx context.Context, cr *models.ManifestChangeRequest) (string, error) {
+	var merged string
+	err := h.Func(ctx, ChangeRequestRef(cr), func(ctx context.Context, repository Repository) error {
+		commit := &git.CommitMessage{
+			Message:   fmt.Sprintf("merge change request #%d: %s", cr.ID, cr.Title),
+			Committer: AuthorFromContext(ctx),
+		}
+		hash, err := repository.repo.MergeBranch(ctx, repository.path, cr.ChangeBranch, cr.BaseCommit, commit)
+		if err != nil {
+			return err
+		}
+		merged = hash
+		return nil
+	})
+	return merged, err
+}
+
+// CloseChangeRequest 删除变更分支
+func (h *ManifestProcessor) CloseChangeRequest(ctx context.Context, cr *models.ManifestChangeRequest) error {
+	return h.Func(ctx, ChangeRequestRef(cr), func(ctx context.Context, repository Repository) error {
+		return repository.repo.DeleteBranch(ctx, cr.ChangeBranch)
+	})
+}
@@ -0,0 +1,341 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/robfig/cron/v3"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/utils/pointer"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/models"
+)
+
+// 查找下一个可同步时间的最大范围
+const syncWindowSearchHorizon = 31 * 24 * time.Hour
+
+// GetEnvironment 根据租户项目环境名称获取环境
+func (p *DatabseProcessor) GetEnvironment(ctx context.Context, ref PathRef) (*models.Environment, error) {
+	env := &models.Environment{}
+	if err := p.DB.WithContext(ctx).
+		Joins("left join projects on projects.id = environments.project_id").
+		Joins("left join tenants on tenants.id = projects.tenant_id").
+		Where("tenants.tenant_name = ? and projects.project_name = ? and environments.environment_name = ?", ref.Tenant, ref.Project, ref.Env).
+		Take(env).Error; err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// GetSyncPolicies 获取环境的默认同步策略和应用的同步策略, 未设置时为 nil
+func (p *DatabseProcessor) GetSyncPolicies(ctx context.Context, ref PathRef) (envpolicy, apppolicy *models.SyncPolicy, err error) {
+	env, err := p.GetEnvironment(ctx, ref)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	policies := []*models.SyncPolicy{}
+	if err := p.DB.WithContext(ctx).
+		Where("environment_id = ? and application_name in ?", env.ID, []string{"", ref.Name}).
+		Find(&policies).Error; err != nil {
+		return nil, nil, err
+	}
+	for _, policy := range policies {
+		if policy.ApplicationName == "" {
+			envpolicy = policy
+		} else {
+			apppolicy = policy
+		}
+	}
+	return envpolicy, apppolicy, nil
+}
+
+// EffectiveSyncPolicy 应用设置了同步策略时覆盖环境的默认策略, 同步窗口始终使用环境的设置
+func EffectiveSyncPolicy(envpolicy, apppolicy *models.SyncPolicy) *models.SyncPolicy {
+	if apppolicy != nil {
+		return apppolicy
+	}
+	return envpolicy
+}
+
+// applySyncPolicy 设置 argo app 的自动同步和重试, 保留已有的 SyncOptions
+func applySyncPolicy(app *v1alpha1.Application, policy *models.SyncPolicy) {
+	if app.Spec.SyncPolicy == nil {
+		app.Spec.SyncPolicy = &v1alpha1.SyncPolicy{}
+	}
+	app.Spec.SyncPolicy.Automated = nil
+	app.Spec.SyncPolicy.Retry = nil
+	if policy == nil {
+		return
+	}
+	if policy.AutoSync {
+		app.Spec.SyncPolicy.Automated = &v1alpha1.SyncPolicyAutomated{
+			Prune:    policy.Prune,
+			SelfHeal: policy.SelfHeal,
+		}
+	}
+	if policy.RetryLimit > 0 {
+		retry := &v1alpha1.RetryStrategy{Limit: policy.RetryLimit}
+		if policy.RetryBackoff != "" {
+			retry.Backoff = &v1alpha1.Backoff{
+				Duration:    policy.RetryBackoff,
+				MaxDuration: policy.RetryBackoffMaxDuration,
+			}
+			if policy.RetryBackoffFactor > 0 {
+				retry.Backoff.Factor = pointer.Int64(policy.RetryBackoffFactor)
+			}
+		}
+		app.Spec.SyncPolicy.Retry = retry
+	}
+}
+
+// ArgoSyncWindows 转换为 AppProject 中的同步窗口, 未指定应用的窗口作用于环境的 namespace
+func ArgoSyncWindows(ref PathRef, namespace string, windows models.SyncWindows) (v1alpha1.SyncWindows, error) {
+	ret := v1alpha1.SyncWindows{}
+	for _, w := range windows {
+		// argo 会将无效的时区当作 UTC
+		if w.TimeZone != "" {
+			if _, err := time.LoadLocation(w.TimeZone); err != nil {
+				return nil, fmt.Errorf("invalid time zone %s: %w", w.TimeZone, err)
+			}
+		}
+		window := &v1alpha1.SyncWindow{
+			Kind:       w.Kind,
+			Schedule:   w.Schedule,
+			Duration:   w.Duration,
+			ManualSync: w.ManualSync,
+			TimeZone:   w.TimeZone,
+		}
+		if len(w.Applications) == 0 {
+			window.Namespaces = []string{namespace}
+		} else {
+			for _, name := range w.Applications {
+				appref := ref
+				appref.Name = name
+				window.Applications = append(window.Applications, appref.FullName())
+			}
+		}
+		if err := window.Validate(); err != nil {
+			return nil, err
+		}
+		ret = append(ret, window)
+	}
+	return ret, nil
+}
+
+// SyncWindowState 当前的同步窗口状态
+type SyncWindowState struct {
+	CanSync       bool                `json:"canSync"`
+	ActiveWindows []models.SyncWindow `json:"activeWindows"`
+	// NextOpen 当前不能同步时, 下一次可以同步的时间; 一个月内不会开放时为空
+	NextOpen *time.Time `json:"nextOpen,omitempty"`
+	// CloseAt 当前可以同步时, 下一次窗口关闭的时间
+	CloseAt *time.Time `json:"closeAt,omitempty"`
+}
+
+type syncWindowSchedule struct {
+	window   models.SyncWindow
+	schedule cron.Schedule
+	duration time.Duration
+	location *time.Location
+}
+
+func parseSyncWindows(windows models.SyncWindows, app string) ([]syncWindowSchedule, error) {
+	parser := cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
+	ret := []syncWindowSchedule{}
+	for _, w := range windows {
+		if !syncWindowMatches(w, app) {
+			continue
+		}
+		schedule, err := parser.Parse(w.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse schedule '%s': %w", w.Schedule, err)
+		}
+		duration, err := time.ParseDuration(w.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse duration '%s': %w", w.Duration, err)
+		}
+		location := time.UTC
+		if w.TimeZone != "" {
+			if location, err = time.LoadLocation(w.TimeZone); err != nil {
+				return nil, err
+			}
+		}
+		ret = append(ret, syncWindowSchedule{window: w, schedule: schedule, duration: duration, location: location})
+	}
+	return ret, nil
+}
+
+func syncWindowMatches(w models.SyncWindow, app string) bool {
+	if len(w.Applications) == 0 {
+		return true
+	}
+	for _, name := range w.Applications {
+		if name == app {
+			return true
+		}
+	}
+	return false
+}
+
+// activeAt 返回窗口在 t 时是否开启, 开启时返回结束时间, 否则返回下一次开启的时间
+func (s syncWindowSchedule) activeAt(t time.Time) (bool, time.Time) {
+	local := t.In(s.location)
+	start := s.schedule.Next(local.Add(-s.duration))
+	if !start.After(local) {
+		return true, start.Add(s.duration)
+	}
+	return false, start
+}
+
+// canSyncAt deny 窗口开启时不能同步; 存在 allow 窗口时必须有一个 allow 窗口开启; 窗口均允许手动同步时手动同步不受限制
+func canSyncAt(schedules []syncWindowSchedule, t time.Time, manual bool) (bool, []models.SyncWindow) {
+	active := []models.SyncWindow{}
+	hasAllow, activeAllow, allowManual := false, false, true
+	activeDeny, denyManual := false, true
+	for _, s := range schedules {
+		isactive, _ := s.activeAt(t)
+		if isactive {
+			active = append(active, s.window)
+		}
+		switch s.window.Kind {
+		case models.SyncWindowKindAllow:
+			hasAllow = true
+			activeAllow = activeAllow || isactive
+			allowManual = allowManual && s.window.ManualSync
+		case models.SyncWindowKindDeny:
+			if isactive {
+				activeDeny = true
+				denyManual = denyManual && s.window.ManualSync
+			}
+		}
+	}
+	if activeDeny {
+		return manual && denyManual, active
+	}
+	if hasAllow && !activeAllow {
+		return manual && allowManual, active
+	}
+	return true, active
+}
+
+// SyncWindowStatus 计算应用在 now 时的同步窗口状态, app 为空时只计算作用于整个环境的窗口
+func SyncWindowStatus(windows models.SyncWindows, app string, now time.Time, manual bool) (*SyncWindowState, error) {
+	schedules, err := parseSyncWindows(windows, app)
+	if err != nil {
+		return nil, err
+	}
+	cansync, active := canSyncAt(schedules, now, manual)
+	state := &SyncWindowState{CanSync: cansync, ActiveWindows: active}
+	if len(schedules) == 0 {
+		return state, nil
+	}
+	// 状态只会在窗口开启或结束时变化, 依次检查之后的每个变化点
+	t := now
+	for t.Before(now.Add(syncWindowSearchHorizon)) {
+		next := time.Time{}
+		for _, s := range schedules {
+			_, change := s.activeAt(t)
+			if next.IsZero() || change.Before(next) {
+				next = change
+			}
+		}
+		if !next.After(t) {
+			break
+		}
+		t = next
+		if ok, _ := canSyncAt(schedules, t, manual); ok != cansync {
+			change := t.UTC()
+			if cansync {
+				state.CloseAt = &change
+			} else {
+				state.NextOpen = &change
+			}
+			break
+		}
+	}
+	return state, nil
+}
+
+// ApplyEnvironmentSyncPolicy 更新环境 AppProject 的同步窗口, 并更新未单独设置策略的应用
+func (h *ApplicationProcessor) ApplyEnvironmentSyncPolicy(ctx context.Context, ref PathRef) error {
+	ref.Name = ""
+	envdetails, err := h.DataBase.GetEnvironmentWithCluster(ref)
+	if err != nil {
+		return err
+	}
+	argocluster, err := h.createArgoCluster(ctx, envdetails.ClusterName, envdetails.ClusterKubeConfig)
+	if err != nil {
+		return err
+	}
+	h.argostatuscache.Delete("project/" + GenArgoPrjNameFromRef(ref))
+	if _, err := h.createArgoProjectForEnvironment(ctx, ref, argocluster.Server, envdetails.Namespace); err != nil {
+		return err
+	}
+
+	selector := labels.Set{
+		LabelTenant:      ref.Tenant,
+		LabelProject:     ref.Project,
+		LabelEnvironment: ref.Env,
+	}.AsSelector()
+	applist, err := h.Argo.ListArgoApp(ctx, selector)
+	if err != nil {
+		return err
+	}
+	for i := range applist.Items {
+		appref := ref
+		appref.Name = applist.Items[i].Labels[LabelApplication]
+		if appref.Name == "" {
+			continue
+		}
+		if err := h.updateArgoAppSyncPolicy(ctx, appref, &applist.Items[i]); err != nil {
+			log.FromContextOrDiscard(ctx).Error(err, "update sync policy", "application", appref.Name)
+		}
+	}
+	return nil
+}
+
+// ApplyApplicationSyncPolicy 更新应用的同步策略, 应用尚未部署时在部署时生效
+func (h *ApplicationProcessor) ApplyApplicationSyncPolicy(ctx context.Context, ref PathRef) error {
+	app, err := h.Argo.GetArgoApp(ctx, ref.FullName())
+	if err != nil {
+		if kerrors.IsNotFound(err) || grpcstatus.Code(err) == grpccodes.NotFound {
+			return nil
+		}
+		return err
+	}
+	return h.updateArgoAppSyncPolicy(ctx, ref, app)
+}
+
+func (h *ApplicationProcessor) updateArgoAppSyncPolicy(ctx context.Context, ref PathRef, app *v1alpha1.Application) error {
+	envpolicy, apppolicy, err := h.DataBase.GetSyncPolicies(ctx, ref)
+	if err != nil {
+		return err
+	}
+	applySyncPolicy(app, EffectiveSyncPolicy(envpolicy, apppolicy))
+	_, err = h.Argo.UpdateApp(ctx, app)
+	return err
+}
@@ -0,0 +1,159 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"k8s.io/utils/pointer"
+	"kubegems.io/kubegems/pkg/service/models"
+)
+
+func TestSyncWindowStatus(t *testing.T) {
+	// 2022-07-01 是周五
+	at := func(s string) time.Time {
+		ret, _ := time.Parse(time.RFC3339, s)
+		return ret
+	}
+	ptr := func(s string) *time.Time {
+		ret := at(s)
+		return &ret
+	}
+	// 周五14点后禁止同步, 持续到周六0点
+	fridayFreeze := models.SyncWindow{Kind: models.SyncWindowKindDeny, Schedule: "0 14 * * 5", Duration: "10h"}
+	// 工作日9点到18点允许同步
+	workhours := models.SyncWindow{Kind: models.SyncWindowKindAllow, Schedule: "0 9 * * 1-5", Duration: "9h"}
+
+	tests := []struct {
+		name    string
+		windows models.SyncWindows
+		app     string
+		now     time.Time
+		manual  bool
+		want    SyncWindowState
+	}{
+		{
+			name: "no windows",
+			now:  at("2022-07-01T15:00:00Z"),
+			want: SyncWindowState{CanSync: true, ActiveWindows: []models.SyncWindow{}},
+		},
+		{
+			name:    "before freeze",
+			windows: models.SyncWindows{fridayFreeze},
+			now:     at("2022-07-01T10:00:00Z"),
+			want:    SyncWindowState{CanSync: true, ActiveWindows: []models.SyncWindow{}, CloseAt: ptr("2022-07-01T14:00:00Z")},
+		},
+		{
+			name:    "in freeze",
+			windows: models.SyncWindows{fridayFreeze},
+			now:     at("2022-07-01T15:00:00Z"),
+			want:    SyncWindowState{CanSync: false, ActiveWindows: []models.SyncWindow{fridayFreeze}, NextOpen: ptr("2022-07-02T00:00:00Z")},
+		},
+		{
+			name:    "freeze in time zone",
+			windows: models.SyncWindows{{Kind: models.SyncWindowKindDeny, Schedule: "0 14 * * 5", Duration: "10h", TimeZone: "Asia/Shanghai"}},
+			now:     at("2022-07-01T07:00:00Z"),
+			want: SyncWindowState{
+				CanSync:       false,
+				ActiveWindows: []models.SyncWindow{{Kind: models.SyncWindowKindDeny, Schedule: "0 14 * * 5", Duration: "10h", TimeZone: "Asia/Shanghai"}},
+				NextOpen:      ptr("2022-07-01T16:00:00Z"),
+			},
+		},
+		{
+			name:    "manual sync allowed in freeze",
+			windows: models.SyncWindows{{Kind: models.SyncWindowKindDeny, Schedule: "0 14 * * 5", Duration: "10h", ManualSync: true}},
+			now:     at("2022-07-01T15:00:00Z"),
+			manual:  true,
+			want: SyncWindowState{
+				CanSync:       true,
+				ActiveWindows: []models.SyncWindow{{Kind: models.SyncWindowKindDeny, Schedule: "0 14 * * 5", Duration: "10h", ManualSync: true}},
+				CloseAt:       nil,
+			},
+		},
+		{
+			name:    "freeze inside work hours",
+			windows: models.SyncWindows{fridayFreeze, workhours},
+			now:     at("2022-07-01T15:00:00Z"),
+			want:    SyncWindowState{CanSync: false, ActiveWindows: []models.SyncWindow{fridayFreeze, workhours}, NextOpen: ptr("2022-07-04T09:00:00Z")},
+		},
+		{
+			name:    "window for other application",
+			windows: models.SyncWindows{{Kind: models.SyncWindowKindDeny, Schedule: "0 14 * * 5", Duration: "10h", Applications: []string{"api"}}},
+			app:     "web",
+			now:     at("2022-07-01T15:00:00Z"),
+			want:    SyncWindowState{CanSync: true, ActiveWindows: []models.SyncWindow{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SyncWindowStatus(tt.windows, tt.app, tt.now, tt.manual)
+			if err != nil {
+				t.Fatalf("SyncWindowStatus() error = %v", err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("SyncWindowStatus() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_applySyncPolicy(t *testing.T) {
+	options := v1alpha1.SyncOptions{"ApplyOutOfSyncOnly=true"}
+	tests := []struct {
+		name   string
+		policy *models.SyncPolicy
+		want   *v1alpha1.SyncPolicy
+	}{
+		{
+			name: "no policy",
+			want: &v1alpha1.SyncPolicy{SyncOptions: options},
+		},
+		{
+			name:   "auto sync with self heal",
+			policy: &models.SyncPolicy{AutoSync: true, SelfHeal: true},
+			want: &v1alpha1.SyncPolicy{
+				SyncOptions: options,
+				Automated:   &v1alpha1.SyncPolicyAutomated{SelfHeal: true},
+			},
+		},
+		{
+			name:   "retry with backoff",
+			policy: &models.SyncPolicy{RetryLimit: 3, RetryBackoff: "5s", RetryBackoffFactor: 2, RetryBackoffMaxDuration: "3m"},
+			want: &v1alpha1.SyncPolicy{
+				SyncOptions: options,
+				Retry: &v1alpha1.RetryStrategy{
+					Limit:   3,
+					Backoff: &v1alpha1.Backoff{Duration: "5s", Factor: pointer.Int64(2), MaxDuration: "3m"},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &v1alpha1.Application{}
+			app.Spec.SyncPolicy = &v1alpha1.SyncPolicy{
+				SyncOptions: options,
+				Automated:   &v1alpha1.SyncPolicyAutomated{Prune: true},
+			}
+			applySyncPolicy(app, tt.policy)
+			if !reflect.DeepEqual(app.Spec.SyncPolicy, tt.want) {
+				t.Errorf("applySyncPolicy() = %+v, want %+v", app.Spec.SyncPolicy, tt.want)
+			}
+		})
+	}
+}
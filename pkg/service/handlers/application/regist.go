@@ -27,7 +27,8 @@ type ApplicationHandler struct {
 	Manifest             ManifestHandler
 	Task                 *TaskHandler
 	// self cluster cli
-	ArgoCD *argo.Client
+	ArgoCD    *argo.Client
+	GitRemote *GitRemoteOptions
 }
 
 // nolint: lll,funlen
@@ -70,11 +71,17 @@ func (h *ApplicationHandler) RegistRouter(rg *gin.RouterGroup) error {
 	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/appstoreapplications/:name", h.CheckByEnvironmentID, deploy.GetAppstoreApp)
 	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/appstoreapplications", h.CheckByEnvironmentID, deploy.CreateAppstoreApp)
 	rg.DELETE("/tenant/:tenant_id/project/:project_id/environment/:environment_id/appstoreapplications/:name", h.CheckByEnvironmentID, deploy.RemoveAppstoreApp)
+	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/appstoreapplications/:name/valuesdiff", h.CheckByEnvironmentID, deploy.AppstoreAppValuesDiff)
+	rg.PUT("/tenant/:tenant_id/project/:project_id/environment/:environment_id/appstoreapplications/:name/upgradepolicy", h.CheckByEnvironmentID, deploy.SetAppstoreUpgradePolicy)
+	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/appstoreupgrades", h.CheckByEnvironmentID, deploy.ListAppstoreUpgrades)
+	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/appstoreupgrades", h.CheckByEnvironmentID, deploy.BatchUpgradeAppstoreApp)
 
 	// 应用部署
 	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications", h.CheckByEnvironmentID, deploy.List)
 	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications", h.CheckByEnvironmentID, deploy.Create)
 	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications-batch", h.CheckByEnvironmentID, deploy.CreateBatch)
+	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/importable-resources", h.CheckByEnvironmentID, deploy.ListImportCandidates)
+	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications-import", h.CheckByEnvironmentID, deploy.Import)
 	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name", h.CheckByEnvironmentID, deploy.Get)
 	rg.DELETE("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name", h.CheckByEnvironmentID, deploy.Remove)
 	// 应用部署镜像更新
@@ -109,6 +116,8 @@ func (h *ApplicationHandler) RegistRouter(rg *gin.RouterGroup) error {
 
 	// 应用部署编排更新-资源建议
 	rg.PATCH("/cluster/:cluster/:group/:version/namespaces/:namespace/:resource/:name", h.CheckByClusterNamespace, deploy.UpdateWorkloadResources)
+	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/recommendations", h.CheckByEnvironmentID, deploy.ListRecommendations)
+	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/recommendations/:recommendation_id/apply", h.CheckByEnvironmentID, deploy.ApplyRecommendation)
 	// Argo CD相关操作
 	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/argohistory", h.CheckByEnvironmentID, deploy.Argohistory)
 	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/imagehistory", h.CheckByEnvironmentID, deploy.ImageHistory)
@@ -117,6 +126,45 @@ func (h *ApplicationHandler) RegistRouter(rg *gin.RouterGroup) error {
 	rg.DELETE("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/argoresource", h.CheckByEnvironmentID, deploy.DeleteArgoResource)
 	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/sync", h.CheckByEnvironmentID, deploy.Sync)
 
+	// 同步策略及同步窗口
+	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/syncpolicy", h.CheckByEnvironmentID, deploy.GetEnvironmentSyncPolicy)
+	rg.PUT("/tenant/:tenant_id/project/:project_id/environment/:environment_id/syncpolicy", h.CheckByProjectID, deploy.SetEnvironmentSyncPolicy)
+	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/syncwindows/status", h.CheckByEnvironmentID, deploy.SyncWindowStatus)
+	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/syncpolicy", h.CheckByEnvironmentID, deploy.GetApplicationSyncPolicy)
+	rg.PUT("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/syncpolicy", h.CheckByProjectID, deploy.SetApplicationSyncPolicy)
+	rg.DELETE("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/syncpolicy", h.CheckByProjectID, deploy.DeleteApplicationSyncPolicy)
+
+	// 镜像策略
+	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/imagepolicy", h.CheckByEnvironmentID, deploy.GetImagePolicy)
+	rg.PUT("/tenant/:tenant_id/project/:project_id/environment/:environment_id/imagepolicy", h.CheckByProjectID, deploy.SetImagePolicy)
+	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/imagepolicy/evaluate", h.CheckByEnvironmentID, deploy.EvaluateImagePolicy)
+	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/imagepolicy/overrides", h.CheckByEnvironmentID, deploy.ListImagePolicyOverrides)
+
+	// 漂移检测
+	rg.GET("/tenant/:tenant_id/project/:project_id/driftreport", h.CheckByProjectID, deploy.ProjectDriftReport)
+	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/driftreport", h.CheckByEnvironmentID, deploy.EnvironmentDriftReport)
+
+	// 环境晋级
+	rg.GET("/tenant/:tenant_id/project/:project_id/promotionpipeline", h.CheckByProjectID, deploy.GetPromotionPipeline)
+	rg.PUT("/tenant/:tenant_id/project/:project_id/promotionpipeline", h.CheckByProjectID, deploy.SetPromotionPipeline)
+	rg.GET("/tenant/:tenant_id/project/:project_id/promotionhistories", h.CheckByProjectID, deploy.ListPromotionHistories)
+	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/promote", h.CheckByEnvironmentID, deploy.Promote)
+
+	// 编排变更审核
+	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/manifestreviewpolicy", h.CheckByEnvironmentID, deploy.GetManifestReviewPolicy)
+	rg.PUT("/tenant/:tenant_id/project/:project_id/environment/:environment_id/manifestreviewpolicy", h.CheckByProjectID, deploy.SetManifestReviewPolicy)
+	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/changerequests", h.CheckByEnvironmentID, deploy.ListChangeRequests)
+	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/changerequests/:changerequest_id", h.CheckByEnvironmentID, deploy.GetChangeRequest)
+	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/changerequests/:changerequest_id/comments", h.CheckByEnvironmentID, deploy.CommentChangeRequest)
+	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/changerequests/:changerequest_id/approve", h.CheckByEnvironmentID, deploy.ApproveChangeRequest)
+	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/changerequests/:changerequest_id/close", h.CheckByEnvironmentID, deploy.CloseChangeRequest)
+	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/changerequests/:changerequest_id/merge", h.CheckByEnvironmentID, deploy.MergeChangeRequest)
+
+	// 外部编排仓库
+	rg.GET("/tenant/:tenant_id/project/:project_id/gitrepository", h.CheckByProjectID, deploy.GetGitRepository)
+	rg.PUT("/tenant/:tenant_id/project/:project_id/gitrepository", h.CheckByProjectID, deploy.SetGitRepository)
+	rg.DELETE("/tenant/:tenant_id/project/:project_id/gitrepository", h.CheckByProjectID, deploy.DeleteGitRepository)
+
 	// 镜像相关
 	image := ImageHandler{BaseHandler: manifest.BaseHandler}
 	rg.GET("/tenant/:tenant_id/project/:project_id/images/vulnerabilities", h.CheckByProjectID, image.Vulnerabilities)
@@ -124,6 +172,10 @@ func (h *ApplicationHandler) RegistRouter(rg *gin.RouterGroup) error {
 	rg.PUT("/tenant/:tenant_id/project/:project_id/images/unpublishable", h.CheckByProjectID, image.Unpublishable)
 	rg.POST("/tenant/:tenant_id/project/:project_id/images/scan", h.CheckByProjectID, image.Scan)
 	rg.GET("/tenant/:tenant_id/project/:project_id/images/tags", h.CheckByProjectID, image.ImageTags)
+	rg.GET("/tenant/:tenant_id/project/:project_id/images/registry", h.CheckByProjectID, image.RegistryInfo)
+	rg.GET("/tenant/:tenant_id/project/:project_id/images/manifest", h.CheckByProjectID, image.Manifest)
+	rg.DELETE("/tenant/:tenant_id/project/:project_id/images", h.CheckByProjectID, image.Delete)
+	rg.POST("/tenant/:tenant_id/project/:project_id/images/cleanup", h.CheckByProjectID, image.Cleanup)
 
 	// 策略化发布 灰度发布
 	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/strategydeploy", h.CheckByEnvironmentID, deploy.GetStrategyDeployment)
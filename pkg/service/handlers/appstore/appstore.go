@@ -16,15 +16,18 @@ package appstore
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
 	"helm.sh/helm/v3/pkg/repo"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"kubegems.io/kubegems/pkg/i18n"
 	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/utils/helm"
 	"kubegems.io/kubegems/pkg/utils/pagination"
 )
 
@@ -124,6 +127,16 @@ type AppFilesResponse struct {
 	Files   map[string]string `json:"files" description:"files"`
 	App     string            `json:"app" description:"app"`
 	Version string            `json:"version" description:"version"`
+	// Schema values 的 json schema, chart 中没有 values.schema.json 时根据 values.yaml 推断
+	Schema json.RawMessage `json:"schema" description:"values schema"`
+}
+
+type AppValuesSchema struct {
+	App     string          `json:"app"`
+	Version string          `json:"version"`
+	Schema  json.RawMessage `json:"schema"`
+	// Inferred schema 为根据 values.yaml 推断
+	Inferred bool `json:"inferred"`
 }
 
 // @Tags        Appstore
@@ -160,14 +173,73 @@ func (h *AppstoreHandler) AppFiles(c *gin.Context) {
 	for _, v := range chartfiles {
 		files[v.Name] = base64.StdEncoding.EncodeToString(v.Data)
 	}
+	schema, err := chartValuesSchema(chartfiles)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
 	ret := AppFilesResponse{
 		Files:   files,
 		App:     name,
 		Version: version,
+		Schema:  schema.Schema,
 	}
 	handlers.OK(c, ret)
 }
 
+// @Tags        Appstore
+// @Summary     APP values schema
+// @Description 获取 chart 版本的 values json schema, 用于生成部署表单; chart 中没有 values.schema.json 时根据 values.yaml 推断
+// @Accept      json
+// @Produce     json
+// @Param       name     path     string                                        true  "name"
+// @Param       version  query    string                                        true  "version"
+// @Param       reponame query    string                                        false "reponame"
+// @Success     200      {object} handlers.ResponseStruct{Data=AppValuesSchema} "schema"
+// @Router      /v1/appstore/app/{name}/schema [get]
+// @Security    JWT
+func (h *AppstoreHandler) AppSchema(c *gin.Context) {
+	name := c.Param("name")
+	version := c.Query("version")
+	reponame := c.Query("reponame")
+	if version == "" {
+		handlers.NotOK(c, i18n.Errorf(c, "invalid parameters: name=%s, version=%s", name, version))
+		return
+	}
+	if reponame == "" {
+		reponame = InternalChartRepoName
+	}
+	chartfiles, err := h.ChartmuseumClient.GetChartBufferedFiles(c.Request.Context(), reponame, name, version)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	schema, err := chartValuesSchema(chartfiles)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	schema.App, schema.Version = name, version
+	handlers.OK(c, schema)
+}
+
+func chartValuesSchema(files []*loader.BufferedFile) (*AppValuesSchema, error) {
+	chrt, err := loader.LoadFiles(files)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := helm.ValuesSchema(chrt)
+	if err != nil {
+		return nil, err
+	}
+	return &AppValuesSchema{
+		App:      chrt.Name(),
+		Version:  chrt.Metadata.Version,
+		Schema:   schema,
+		Inferred: len(chrt.Schema) == 0,
+	}, nil
+}
+
 // 针对前端显示屏蔽部分字段
 func convertChartVersion(cv *repo.ChartVersion, repourl string) Chart {
 	return Chart{
@@ -31,6 +31,7 @@ func (h *AppstoreHandler) RegistRouter(rg *gin.RouterGroup) {
 
 	rg.GET("/appstore/app", h.ListApps)
 	rg.GET("/appstore/app/:name", h.AppDetail)
+	rg.GET("/appstore/app/:name/schema", h.AppSchema)
 	rg.GET("/appstore/files", h.AppFiles)
 
 	rg.GET("/appstore/repo", h.ListExternalRepo)
@@ -15,6 +15,7 @@
 package auditloghandler
 
 import (
+	"kubegems.io/kubegems/pkg/service/aaa/audit"
 	"kubegems.io/kubegems/pkg/service/handlers"
 	"kubegems.io/kubegems/pkg/service/models"
 
@@ -110,3 +111,21 @@ func (h *AuditLogHandler) RetrieveAuditLog(c *gin.Context) {
 	}
 	handlers.OK(c, obj)
 }
+
+// VerifyAuditLog 校验审计日志哈希链
+// @Tags        AuditLog
+// @Summary     校验审计日志
+// @Description 校验审计日志哈希链, 检查审计日志是否被修改或删除, 已归档的审计日志不在校验范围内
+// @Accept      json
+// @Produce     json
+// @Success     200 {object} handlers.ResponseStruct{Data=audit.ChainVerification} "AuditLog"
+// @Router      /v1/auditlog/verify [get]
+// @Security    JWT
+func (h *AuditLogHandler) VerifyAuditLog(c *gin.Context) {
+	result, err := audit.VerifyChain(c.Request.Context(), h.GetDB())
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, result)
+}
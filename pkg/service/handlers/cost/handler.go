@@ -0,0 +1,370 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package costhandler
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils"
+)
+
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+
+	GroupByTenant  = "tenant"
+	GroupByProject = "project"
+)
+
+type CostQuery struct {
+	Start       string `form:"start"` // 2006-01-02, 默认当月1日
+	End         string `form:"end"`   // 2006-01-02, 包含当天, 默认今天
+	Cluster     string `form:"cluster"`
+	Project     string `form:"project"`
+	Environment string `form:"environment"`
+	Namespace   string `form:"namespace"` // 只对workload生效
+	Format      string `form:"format" binding:"omitempty,oneof=json csv"`
+}
+
+type MonthlyCostQuery struct {
+	Start   string `form:"start"` // 2006-01, 默认当月
+	End     string `form:"end"`   // 2006-01, 包含当月, 默认当月
+	GroupBy string `form:"groupby" binding:"omitempty,oneof=tenant project"`
+	Format  string `form:"format" binding:"omitempty,oneof=json csv"`
+}
+
+// GetClusterPrice 获取集群资源单价
+// @Tags        Cost
+// @Summary     获取集群资源单价
+// @Description 获取集群资源单价, 未设置时单价为0
+// @Accept      json
+// @Produce     json
+// @Param       cluster_id path     uint                                             true "cluster_id"
+// @Success     200        {object} handlers.ResponseStruct{Data=models.ClusterPrice} "ClusterPrice"
+// @Router      /v1/cluster/{cluster_id}/price [get]
+// @Security    JWT
+func (h *CostHandler) GetClusterPrice(c *gin.Context) {
+	cluster := models.Cluster{}
+	if err := h.GetDB().First(&cluster, c.Param("cluster_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	price := models.ClusterPrice{ClusterID: cluster.ID, Currency: models.DefaultCurrency}
+	if err := h.GetDB().Where("cluster_id = ?", cluster.ID).Find(&price).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, price)
+}
+
+// PutClusterPrice 设置集群资源单价
+// @Tags        Cost
+// @Summary     设置集群资源单价
+// @Description 设置集群资源单价, 按小时计价, 从下一次费用统计开始生效
+// @Accept      json
+// @Produce     json
+// @Param       cluster_id path     uint                                             true "cluster_id"
+// @Param       param      body     models.ClusterPrice                              true "表单"
+// @Success     200        {object} handlers.ResponseStruct{Data=models.ClusterPrice} "ClusterPrice"
+// @Router      /v1/cluster/{cluster_id}/price [put]
+// @Security    JWT
+func (h *CostHandler) PutClusterPrice(c *gin.Context) {
+	cluster := models.Cluster{}
+	if err := h.GetDB().First(&cluster, c.Param("cluster_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	action := i18n.Sprintf(context.TODO(), "update")
+	module := i18n.Sprintf(context.TODO(), "cluster price")
+	h.SetAuditData(c, action, module, cluster.ClusterName)
+
+	price := models.ClusterPrice{}
+	if err := h.GetDB().Where("cluster_id = ?", cluster.ID).Find(&price).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	id := price.ID
+	if err := c.BindJSON(&price); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	price.ID, price.ClusterID, price.Cluster = id, cluster.ID, nil
+	if price.Currency == "" {
+		price.Currency = models.DefaultCurrency
+	}
+	if err := h.GetDB().Save(&price).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, price)
+}
+
+// ListMonthlyCost 所有租户的月度费用
+// @Tags        Cost
+// @Summary     所有租户的月度费用
+// @Description 所有租户的月度费用, 按租户或项目汇总, 支持导出csv
+// @Accept      json
+// @Produce     json
+// @Param       start   query    string                                                 false "开始月份, 2006-01"
+// @Param       end     query    string                                                 false "结束月份, 2006-01"
+// @Param       groupby query    string                                                 false "tenant(默认) or project"
+// @Param       format  query    string                                                 false "json(默认) or csv"
+// @Success     200     {object} handlers.ResponseStruct{Data=[]models.CostSummary} "CostSummary"
+// @Router      /v1/cost/monthly [get]
+// @Security    JWT
+func (h *CostHandler) ListMonthlyCost(c *gin.Context) {
+	h.monthlyCost(c, "")
+}
+
+// ListTenantMonthlyCost 租户的月度费用
+// @Tags        Cost
+// @Summary     租户的月度费用
+// @Description 租户的月度费用, 按租户或项目汇总, 支持导出csv
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id path     uint                                                 true  "tenant_id"
+// @Param       start     query    string                                               false "开始月份, 2006-01"
+// @Param       end       query    string                                               false "结束月份, 2006-01"
+// @Param       groupby   query    string                                               false "tenant(默认) or project"
+// @Param       format    query    string                                               false "json(默认) or csv"
+// @Success     200       {object} handlers.ResponseStruct{Data=[]models.CostSummary} "CostSummary"
+// @Router      /v1/tenant/{tenant_id}/cost/monthly [get]
+// @Security    JWT
+func (h *CostHandler) ListTenantMonthlyCost(c *gin.Context) {
+	tenant := models.Tenant{}
+	if err := h.GetDB().First(&tenant, c.Param("tenant_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.monthlyCost(c, tenant.TenantName)
+}
+
+func (h *CostHandler) monthlyCost(c *gin.Context, tenant string) {
+	query := MonthlyCostQuery{}
+	if err := c.BindQuery(&query); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	thisMonth := time.Now().Format("2006-01")
+	start, err := time.ParseInLocation("2006-01", utils.StrOrDef(query.Start, thisMonth), time.Local)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	end, err := time.ParseInLocation("2006-01", utils.StrOrDef(query.End, thisMonth), time.Local)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	costs := []models.EnvironmentCost{}
+	tx := h.GetDB().Where("date >= ? and date < ?", start, end.AddDate(0, 1, 0))
+	if tenant != "" {
+		tx = tx.Where("tenant_name = ?", tenant)
+	}
+	if err := tx.Order("date").Find(&costs).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	summaries := models.MonthlyCostSummary(costs, query.GroupBy == GroupByProject)
+
+	if query.Format != FormatCSV {
+		handlers.OK(c, summaries)
+		return
+	}
+	header := append([]string{"month", "tenant", "project", "currency", "days"}, costHeader("request_cost")...)
+	header = append(header, costHeader("usage_cost")...)
+	rows := make([][]string, len(summaries))
+	for i, s := range summaries {
+		rows[i] = append([]string{s.Month, s.TenantName, s.ProjectName, s.Currency, strconv.Itoa(s.Days)}, costColumns(s.RequestCost)...)
+		rows[i] = append(rows[i], costColumns(s.UsageCost)...)
+	}
+	writeCSV(c, fmt.Sprintf("cost-monthly-%s-%s.csv", start.Format("200601"), end.Format("200601")), header, rows)
+}
+
+// ListEnvironmentCost 租户下环境的每日费用
+// @Tags        Cost
+// @Summary     租户下环境的每日费用
+// @Description 租户下环境的每日费用, 分别按申请量与使用量计算, 支持导出csv
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id   path     uint                                                    true  "tenant_id"
+// @Param       start       query    string                                                  false "开始日期, 2006-01-02"
+// @Param       end         query    string                                                  false "结束日期, 2006-01-02"
+// @Param       cluster     query    string                                                  false "cluster"
+// @Param       project     query    string                                                  false "project"
+// @Param       environment query    string                                                  false "environment"
+// @Param       format      query    string                                                  false "json(默认) or csv"
+// @Success     200         {object} handlers.ResponseStruct{Data=[]models.EnvironmentCost} "EnvironmentCost"
+// @Router      /v1/tenant/{tenant_id}/cost/environment [get]
+// @Security    JWT
+func (h *CostHandler) ListEnvironmentCost(c *gin.Context) {
+	query := CostQuery{}
+	tx, ok := h.dailyCostQuery(c, &query)
+	if !ok {
+		return
+	}
+	costs := []models.EnvironmentCost{}
+	if err := tx.Order("date").Order("project_name").Order("environment_name").Find(&costs).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if query.Format != FormatCSV {
+		handlers.OK(c, costs)
+		return
+	}
+	header := append([]string{"date", "cluster", "tenant", "project", "environment", "currency"}, dailyCostHeader()...)
+	rows := make([][]string, len(costs))
+	for i, cost := range costs {
+		rows[i] = append([]string{cost.Date.Format("2006-01-02"), cost.ClusterName, cost.TenantName, cost.ProjectName, cost.EnvironmentName, cost.Currency},
+			dailyCostColumns(cost.Request, cost.Usage, cost.RequestCost, cost.UsageCost)...)
+	}
+	writeCSV(c, "cost-environment.csv", header, rows)
+}
+
+// ListWorkloadCost 租户下workload的每日费用
+// @Tags        Cost
+// @Summary     租户下workload的每日费用
+// @Description 租户下workload的每日费用, 分别按申请量与使用量计算, 支持导出csv
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id   path     uint                                                 true  "tenant_id"
+// @Param       start       query    string                                               false "开始日期, 2006-01-02"
+// @Param       end         query    string                                               false "结束日期, 2006-01-02"
+// @Param       cluster     query    string                                               false "cluster"
+// @Param       project     query    string                                               false "project"
+// @Param       environment query    string                                               false "environment"
+// @Param       namespace   query    string                                               false "namespace"
+// @Param       format      query    string                                               false "json(默认) or csv"
+// @Success     200         {object} handlers.ResponseStruct{Data=[]models.WorkloadCost} "WorkloadCost"
+// @Router      /v1/tenant/{tenant_id}/cost/workload [get]
+// @Security    JWT
+func (h *CostHandler) ListWorkloadCost(c *gin.Context) {
+	query := CostQuery{}
+	tx, ok := h.dailyCostQuery(c, &query)
+	if !ok {
+		return
+	}
+	if query.Namespace != "" {
+		tx = tx.Where("namespace = ?", query.Namespace)
+	}
+	costs := []models.WorkloadCost{}
+	if err := tx.Order("date").Order("namespace").Order("name").Find(&costs).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if query.Format != FormatCSV {
+		handlers.OK(c, costs)
+		return
+	}
+	header := append([]string{"date", "cluster", "tenant", "project", "environment", "namespace", "type", "name", "currency"}, dailyCostHeader()...)
+	rows := make([][]string, len(costs))
+	for i, cost := range costs {
+		rows[i] = append([]string{cost.Date.Format("2006-01-02"), cost.ClusterName, cost.TenantName, cost.ProjectName, cost.EnvironmentName, cost.Namespace, cost.Type, cost.Name, cost.Currency},
+			dailyCostColumns(cost.Request, cost.Usage, cost.RequestCost, cost.UsageCost)...)
+	}
+	writeCSV(c, "cost-workload.csv", header, rows)
+}
+
+// dailyCostQuery 解析每日费用的查询条件, 两种费用表的过滤字段相同
+func (h *CostHandler) dailyCostQuery(c *gin.Context, query *CostQuery) (*gorm.DB, bool) {
+	if err := c.BindQuery(query); err != nil {
+		handlers.NotOK(c, err)
+		return nil, false
+	}
+	tenant := models.Tenant{}
+	if err := h.GetDB().First(&tenant, c.Param("tenant_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return nil, false
+	}
+	now := time.Now()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+	start, err := time.ParseInLocation("2006-01-02", utils.StrOrDef(query.Start, monthStart.Format("2006-01-02")), time.Local)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return nil, false
+	}
+	end, err := time.ParseInLocation("2006-01-02", utils.StrOrDef(query.End, now.Format("2006-01-02")), time.Local)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return nil, false
+	}
+
+	tx := h.GetDB().Where("tenant_name = ? and date >= ? and date < ?", tenant.TenantName, start, end.AddDate(0, 0, 1))
+	if query.Cluster != "" {
+		tx = tx.Where("cluster_name = ?", query.Cluster)
+	}
+	if query.Project != "" {
+		tx = tx.Where("project_name = ?", query.Project)
+	}
+	if query.Environment != "" {
+		tx = tx.Where("environment_name = ?", query.Environment)
+	}
+	return tx, true
+}
+
+func writeCSV(c *gin.Context, filename string, header []string, rows [][]string) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	w := csv.NewWriter(c.Writer)
+	_ = w.Write(header)
+	_ = w.WriteAll(rows)
+}
+
+func dailyCostHeader() []string {
+	header := append(amountHeader("request"), amountHeader("usage")...)
+	header = append(header, costHeader("request_cost")...)
+	return append(header, costHeader("usage_cost")...)
+}
+
+func dailyCostColumns(request, usage models.ResourceAmount, requestCost, usageCost models.ResourceCost) []string {
+	columns := append(amountColumns(request), amountColumns(usage)...)
+	columns = append(columns, costColumns(requestCost)...)
+	return append(columns, costColumns(usageCost)...)
+}
+
+func amountHeader(prefix string) []string {
+	return []string{prefix + "_cpu_core", prefix + "_memory_gib", prefix + "_storage_gib", prefix + "_gpu"}
+}
+
+func amountColumns(a models.ResourceAmount) []string {
+	return []string{
+		formatFloat(a.CPUCore),
+		formatFloat(utils.RoundTo(a.MemoryByte/models.Gi, 3)),
+		formatFloat(utils.RoundTo(a.StorageByte/models.Gi, 3)),
+		formatFloat(a.GPU),
+	}
+}
+
+func costHeader(prefix string) []string {
+	return []string{prefix + "_cpu", prefix + "_memory", prefix + "_storage", prefix + "_gpu", prefix + "_total"}
+}
+
+func costColumns(cost models.ResourceCost) []string {
+	return []string{formatFloat(cost.CPU), formatFloat(cost.Memory), formatFloat(cost.Storage), formatFloat(cost.GPU), formatFloat(cost.Total)}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
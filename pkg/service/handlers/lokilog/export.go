@@ -0,0 +1,281 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lokilog
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/logexport"
+	"kubegems.io/kubegems/pkg/utils/loki"
+	"kubegems.io/kubegems/pkg/utils/workflow"
+)
+
+const (
+	// 与 worker 中注册的任务函数一致
+	taskFunctionLogExport = "log-export"
+
+	// 单个导出任务的最大时间范围
+	maxExportRange = 31 * 24 * time.Hour
+)
+
+type ExportForm struct {
+	Query    string `form:"query" json:"query" binding:"required"`
+	Level    string `form:"level" json:"level"`
+	Start    string `form:"start" json:"start"`
+	End      string `form:"end" json:"end"`
+	Format   string `form:"format" json:"format"`
+	Compress *bool  `form:"compress" json:"compress"`
+}
+
+// Export 创建日志导出任务
+// @Tags        Log
+// @Summary     创建日志导出任务
+// @Description 异步导出 loki 查询结果, 按时间窗口分页查询并保存至存储, 完成后通过消息中心发送下载链接
+// @Accept      json
+// @Produce     json
+// @Param       cluster_name path     string                                          true  "cluster_name"
+// @Param       body         body     ExportForm                                      true  "导出参数, start/end 为纳秒时间戳或 RFC3339, 默认为最近1小时; format 为 log(默认), ndjson, csv; compress 默认为 true"
+// @Success     200          {object} handlers.ResponseStruct{Data=models.LogExportJob} "LogExportJob"
+// @Router      /v1/log/{cluster_name}/export [post]
+// @Security    JWT
+func (l *LogHandler) Export(c *gin.Context) {
+	form := ExportForm{}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	user, _ := l.GetContextUser(c)
+	job, err := newExportJob(c.Param("cluster_name"), form, time.Now())
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	job.Creator = user.GetUsername()
+	job.CreatorID = user.GetID()
+
+	l.SetAuditData(c, "导出", "日志", job.Filename)
+	if err := l.GetDB().Create(job).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	task := workflow.Task{
+		Name:  "log-export",
+		Group: "log",
+		Steps: []workflow.Step{{
+			Name:     fmt.Sprintf("export %s", job.Filename),
+			Function: taskFunctionLogExport,
+			Args:     workflow.ArgsOf(job.ID),
+		}},
+		Addtionals: map[string]string{"cluster": job.Cluster, "creator": job.Creator},
+	}
+	if err := l.Tasks.SubmitTask(c.Request.Context(), task); err != nil {
+		l.GetDB().Model(job).Updates(map[string]interface{}{"status": models.LogExportJobFailed, "message": err.Error()})
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, job)
+}
+
+func newExportJob(cluster string, form ExportForm, now time.Time) (*models.LogExportJob, error) {
+	start, end := now.Add(-time.Hour), now
+	var err error
+	if form.Start != "" {
+		if start, err = parseTime(form.Start); err != nil {
+			return nil, fmt.Errorf("invalid start time: %w", err)
+		}
+	}
+	if form.End != "" {
+		if end, err = parseTime(form.End); err != nil {
+			return nil, fmt.Errorf("invalid end time: %w", err)
+		}
+	}
+	if !end.After(start) {
+		return nil, fmt.Errorf("end time must be after start time")
+	}
+	if end.Sub(start) > maxExportRange {
+		return nil, fmt.Errorf("time range of log export can't exceed %d days", int(maxExportRange.Hours()/24))
+	}
+	format := form.Format
+	if format == "" {
+		format = logexport.FormatLog
+	}
+	if !logexport.ValidFormat(format) {
+		return nil, fmt.Errorf("unsupported log export format %s", format)
+	}
+	compress := form.Compress == nil || *form.Compress
+	query := form.Query
+	if form.Level != "" {
+		if levelExpr := loki.GenerateLevelRegex(form.Level); levelExpr != "" {
+			query = fmt.Sprintf("%s %s", query, levelExpr)
+		}
+	}
+	return &models.LogExportJob{
+		Cluster:   cluster,
+		LogQL:     query,
+		StartTime: start,
+		EndTime:   end,
+		Format:    format,
+		Compress:  compress,
+		Status:    models.LogExportJobPending,
+		Filename:  logexport.Filename(fmt.Sprintf("%s-%s", cluster, now.UTC().Format("20060102150405")), format, compress),
+	}, nil
+}
+
+// parseTime 支持纳秒/秒时间戳及 RFC3339
+func parseTime(s string) (time.Time, error) {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if len(s) <= 10 {
+			return time.Unix(i, 0), nil
+		}
+		return time.Unix(0, i), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// ListExportJobs 日志导出任务列表
+// @Tags        Log
+// @Summary     日志导出任务列表
+// @Description 日志导出任务列表, 系统管理员可以查看所有用户的任务
+// @Accept      json
+// @Produce     json
+// @Param       Cluster query    string                                                                      false "Cluster"
+// @Param       Status  query    string                                                                      false "Status"
+// @Param       page    query    int                                                                         false "page"
+// @Param       size    query    int                                                                         false "page"
+// @Success     200     {object} handlers.ResponseStruct{Data=handlers.PageData{List=[]models.LogExportJob}} "LogExportJob"
+// @Router      /v1/log/exports [get]
+// @Security    JWT
+func (l *LogHandler) ListExportJobs(c *gin.Context) {
+	var list []models.LogExportJob
+	query, err := handlers.GetQuery(c, nil)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	where := []*handlers.QArgs{}
+	user, _ := l.GetContextUser(c)
+	if !l.ModelCache().GetUserAuthority(user).IsSystemAdmin() {
+		where = append(where, handlers.Args("creator_id = ?", user.GetID()))
+	}
+	if cluster := c.Query("Cluster"); len(cluster) > 0 {
+		where = append(where, handlers.Args("cluster = ?", cluster))
+	}
+	if status := c.Query("Status"); len(status) > 0 {
+		where = append(where, handlers.Args("status = ?", status))
+	}
+	cond := &handlers.PageQueryCond{
+		Model: "LogExportJob",
+		Where: where,
+	}
+	total, page, size, err := query.PageList(l.GetDB().Order("id DESC"), cond, &list)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, handlers.Page(total, list, page, size))
+}
+
+// GetExportJob 日志导出任务详情
+// @Tags        Log
+// @Summary     日志导出任务详情
+// @Description 日志导出任务详情, 包含导出进度
+// @Accept      json
+// @Produce     json
+// @Param       export_id path     uint                                              true "export_id"
+// @Success     200       {object} handlers.ResponseStruct{Data=models.LogExportJob} "LogExportJob"
+// @Router      /v1/log/exports/{export_id} [get]
+// @Security    JWT
+func (l *LogHandler) GetExportJob(c *gin.Context) {
+	job, err := l.getExportJob(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, job)
+}
+
+// DownloadExportJob 下载导出的日志
+// @Tags        Log
+// @Summary     下载导出的日志
+// @Description 下载导出的日志, 对象存储时重定向至签名链接
+// @Produce     octet-stream
+// @Param       export_id path     uint   true "export_id"
+// @Success     200       {string} string "file"
+// @Router      /v1/log/exports/{export_id}/download [get]
+// @Security    JWT
+func (l *LogHandler) DownloadExportJob(c *gin.Context) {
+	job, err := l.getExportJob(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if job.Status != models.LogExportJobSuccess || job.StorageKey == "" {
+		handlers.NotOK(c, i18n.Errorf(c, "log export %s is not ready", job.Filename))
+		return
+	}
+	if _, ok := l.ExportStore.(*logexport.S3Store); ok {
+		link, err := l.ExportStore.SignedURL(c.Request.Context(), job.StorageKey, job.Filename, time.Minute*5)
+		if err != nil {
+			handlers.NotOK(c, err)
+			return
+		}
+		c.Redirect(http.StatusFound, link)
+		return
+	}
+	l.serveExport(c, job.StorageKey, job.Filename, logexport.ContentType(job.Format, job.Compress))
+}
+
+// DownloadSignedExport 使用签名链接下载本地存储的导出日志, 不需要登录
+func (l *LogHandler) DownloadSignedExport(c *gin.Context) {
+	key := c.Query("key")
+	if !logexport.VerifySignature(l.ExportOptions.SigningKey, key, c.Query("expires"), c.Query("signature"), time.Now()) {
+		handlers.Forbidden(c, i18n.Errorf(c, "invalid or expired download link"))
+		return
+	}
+	l.serveExport(c, key, c.Query("filename"), "application/octet-stream")
+}
+
+func (l *LogHandler) serveExport(c *gin.Context, key, filename, contentType string) {
+	content, err := l.ExportStore.Open(c.Request.Context(), key)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	defer content.Close()
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Header("Content-Type", contentType)
+	c.Status(http.StatusOK)
+	_, _ = io.Copy(c.Writer, content)
+}
+
+func (l *LogHandler) getExportJob(c *gin.Context) (*models.LogExportJob, error) {
+	job := &models.LogExportJob{}
+	if err := l.GetDB().First(job, c.Param("export_id")).Error; err != nil {
+		return nil, err
+	}
+	user, _ := l.GetContextUser(c)
+	if job.CreatorID != user.GetID() && !l.ModelCache().GetUserAuthority(user).IsSystemAdmin() {
+		return nil, i18n.Errorf(c, "you can only access your own log exports")
+	}
+	return job, nil
+}
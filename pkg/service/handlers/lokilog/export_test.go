@@ -0,0 +1,86 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lokilog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewExportJob(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	no := false
+	tests := []struct {
+		name         string
+		form         ExportForm
+		wantErr      bool
+		wantStart    time.Time
+		wantFilename string
+	}{
+		{
+			name:         "default range and compressed log",
+			form:         ExportForm{Query: `{app="demo"}`},
+			wantStart:    now.Add(-time.Hour),
+			wantFilename: "c1-20220601120000.log.gz",
+		},
+		{
+			name:         "nanosecond timestamps and csv",
+			form:         ExportForm{Query: `{app="demo"}`, Start: "1654070400000000000", End: "1654077600000000000", Format: "csv", Compress: &no},
+			wantStart:    time.Unix(0, 1654070400000000000),
+			wantFilename: "c1-20220601120000.csv",
+		},
+		{
+			name:         "rfc3339",
+			form:         ExportForm{Query: `{app="demo"}`, Start: "2022-05-30T00:00:00Z", End: "2022-06-01T00:00:00Z", Format: "ndjson"},
+			wantStart:    time.Date(2022, 5, 30, 0, 0, 0, 0, time.UTC),
+			wantFilename: "c1-20220601120000.ndjson.gz",
+		},
+		{
+			name:    "end before start",
+			form:    ExportForm{Query: `{app="demo"}`, Start: "1654077600", End: "1654070400"},
+			wantErr: true,
+		},
+		{
+			name:    "range too large",
+			form:    ExportForm{Query: `{app="demo"}`, Start: "2022-01-01T00:00:00Z", End: "2022-06-01T00:00:00Z"},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported format",
+			form:    ExportForm{Query: `{app="demo"}`, Format: "xlsx"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job, err := newExportJob("c1", tt.form, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newExportJob() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !job.StartTime.Equal(tt.wantStart) {
+				t.Errorf("start = %v, want %v", job.StartTime, tt.wantStart)
+			}
+			if job.Filename != tt.wantFilename {
+				t.Errorf("filename = %s, want %s", job.Filename, tt.wantFilename)
+			}
+			if job.Status != "Pending" {
+				t.Errorf("status = %s", job.Status)
+			}
+		})
+	}
+}
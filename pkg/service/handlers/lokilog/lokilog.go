@@ -17,10 +17,7 @@ package lokilog
 import (
 	"encoding/json"
 	"fmt"
-	"os"
-	"path"
 	"regexp"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -28,12 +25,9 @@ import (
 	"github.com/gin-gonic/gin"
 	"kubegems.io/kubegems/pkg/i18n"
 	"kubegems.io/kubegems/pkg/service/handlers"
-	"kubegems.io/kubegems/pkg/utils"
 	"kubegems.io/kubegems/pkg/utils/loki"
 )
 
-const LokiExportDir = "lokiExport"
-
 // QueryRange 获取loki查询结果
 // @Tags        Log
 // @Summary     获取loki查询结果
@@ -247,122 +241,6 @@ func (l *LogHandler) LabelValues(c *gin.Context) {
 	handlers.OK(c, labelData)
 }
 
-// Export 导出loki查询结果
-// @Tags        Log
-// @Summary     导出loki查询结果
-// @Description 导出loki查询结果
-// @Accept      json
-// @Produce     json
-// @Param       cluster_name path     string                               true  "cluster_name"
-// @Param       query        query    string                               true  "query"
-// @Param       interval     query    string                               false "interval"
-// @Param       direction    query    string                               false "direction"
-// @Param       limit        query    int                                  false "limit"
-// @Param       start        query    string                               false "start"
-// @Param       end          query    string                               false "end"
-// @Success     200          {object} handlers.ResponseStruct{Data=string} "Export"
-// @Router      /v1/log/{cluster_name}/export [get]
-// @Security    JWT
-func (l *LogHandler) Export(c *gin.Context) {
-	var query loki.QueryRangeParam
-	if err := c.ShouldBindQuery(&query); err != nil {
-		handlers.NotOK(c, err)
-		return
-	}
-
-	level := c.Param("level")
-	if level != "" {
-		levelExpr := loki.GenerateLevelRegex(level)
-		if levelExpr != "" {
-			query.Query = fmt.Sprintf("%s %s", query.Query, levelExpr)
-		}
-	}
-
-	err := utils.EnsurePathExists(LokiExportDir)
-	if err != nil {
-		handlers.NotOK(c, err)
-		return
-	}
-
-	filename := fmt.Sprintf("%s.log", time.Now().UTC().Format("20060102150405"))
-	targetOutputFile := path.Join(LokiExportDir, filename)
-	file, err := os.Create(targetOutputFile)
-	if err != nil {
-		handlers.NotOK(c, err)
-		return
-	}
-	defer file.Close()
-
-	_, err = file.WriteString("\xEF\xBB\xBF")
-	if err != nil {
-		handlers.NotOK(c, err)
-		return
-	}
-
-	res := make(map[string]interface{})
-	res["exist"] = true
-
-	ctx := c.Request.Context()
-
-	length := 1
-	index := 0
-	for {
-		if index >= 10 {
-			break
-		}
-		index++
-		if length == 0 {
-			break
-		}
-
-		queryData, err := l.LokiQueryRange(ctx, c.Param("cluster_name"), query.ToMap())
-		if err != nil {
-			index--
-			continue
-		}
-
-		resultType := queryData.ResultType
-		if resultType == loki.ResultTypeMatrix {
-			// 暂不支持matrix
-			handlers.NotOK(c, i18n.Errorf(c, "Matrix type export is not supported"))
-			return
-		}
-
-		results := queryData.Result
-		messages := loki.LokiMessages{}
-		for _, result := range results {
-			var stream loki.Stream
-			stream = stream.ToStruct(result.(map[string]interface{}))
-
-			values := stream.Entries
-			for _, value := range values {
-				messages = append(messages, loki.LokiMessage{Timestamp: value[0], Message: value[1]})
-			}
-		}
-		length = len(messages)
-
-		if length > 0 {
-			if query.Direction == "backward" {
-				sort.Sort(messages)
-				query.End = messages[len(messages)-1].Timestamp
-			} else {
-				sort.Sort(sort.Reverse(messages))
-				query.Start = messages[len(messages)-1].Timestamp
-			}
-			for _, message := range messages {
-				_, err = file.WriteString(message.Message + "\r\n")
-				if err != nil {
-					handlers.NotOK(c, err)
-					return
-				}
-			}
-		}
-	}
-
-	res["filename"] = filename
-	handlers.OK(c, res)
-}
-
 // Context 获取loki上下文
 // @Tags        Log
 // @Summary     获取loki上下文
@@ -17,18 +17,27 @@ package lokilog
 import (
 	"github.com/gin-gonic/gin"
 	"kubegems.io/kubegems/pkg/service/handlers/base"
+	"kubegems.io/kubegems/pkg/utils/logexport"
+	"kubegems.io/kubegems/pkg/utils/workflow"
 )
 
 type LogHandler struct {
 	base.BaseHandler
+	Tasks         *workflow.Client
+	ExportOptions *logexport.Options
+	ExportStore   logexport.Store
 }
 
 func (h *LogHandler) RegistRouter(rg *gin.RouterGroup) {
 	rg.GET("/log/:cluster_name/queryrange", h.QueryRange)
 	rg.GET("/log/:cluster_name/labels", h.Labels)
-	rg.GET("/log/:cluster_name/export", h.Export)
+	rg.POST("/log/:cluster_name/export", h.Export)
 	rg.GET("/log/:cluster_name/label/:label/values", h.LabelValues)
 	rg.GET("/log/:cluster_name/querylanguage", h.QueryLanguage)
 	rg.GET("/log/:cluster_name/series", h.Series)
 	rg.GET("/log/:cluster_name/context", h.Context)
+
+	rg.GET("/log/exports", h.ListExportJobs)
+	rg.GET("/log/exports/:export_id", h.GetExportJob)
+	rg.GET("/log/exports/:export_id/download", h.DownloadExportJob)
 }
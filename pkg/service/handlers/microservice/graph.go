@@ -0,0 +1,562 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package microservice
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	prommodel "github.com/prometheus/common/model"
+	"golang.org/x/sync/errgroup"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/agents"
+)
+
+const (
+	graphUnknownNode   = "unknown"
+	graphMaxRange      = 7 * 24 * time.Hour
+	graphDefaultRange  = time.Hour
+	graphMaxPoints     = 200
+	graphMinRateWindow = time.Minute
+	// 边的分组标签, 源使用 workload 所在 namespace, 目的使用 service 所在 namespace
+	graphEdgeLabels = "source_workload_namespace,source_canonical_service,destination_service_namespace,destination_canonical_service,destination_service_name"
+)
+
+// REDMetrics 请求数、错误数与延迟, 延迟单位为毫秒
+type REDMetrics struct {
+	Requests  float64 `json:"requests"`  // 时间范围内的请求总数
+	RPS       float64 `json:"rps"`       // 平均每秒请求数
+	Errors    float64 `json:"errors"`    // 5xx 请求数
+	ErrorRate float64 `json:"errorRate"` // 5xx 请求占比
+	P50       float64 `json:"p50"`
+	P95       float64 `json:"p95"`
+	P99       float64 `json:"p99"`
+}
+
+type GraphNode struct {
+	ID          string     `json:"id"` // namespace/name
+	Namespace   string     `json:"namespace"`
+	Name        string     `json:"name"`
+	Environment string     `json:"environment"` // 所属虚拟空间中的环境, 为空表示虚拟空间外的服务
+	Clusters    []string   `json:"clusters"`
+	Metrics     REDMetrics `json:"metrics"` // 该节点收到的请求
+}
+
+type GraphEdge struct {
+	Source  string     `json:"source"`
+	Target  string     `json:"target"`
+	Metrics REDMetrics `json:"metrics"`
+}
+
+type ServiceGraph struct {
+	Start time.Time    `json:"start"`
+	End   time.Time    `json:"end"`
+	Nodes []*GraphNode `json:"nodes"`
+	Edges []*GraphEdge `json:"edges"`
+}
+
+// ServiceGoldenSignals service 的黄金指标时间序列
+type ServiceGoldenSignals struct {
+	RPS       []prommodel.SamplePair `json:"rps"`
+	ErrorRate []prommodel.SamplePair `json:"errorRate"`
+	P50       []prommodel.SamplePair `json:"p50"`
+	P95       []prommodel.SamplePair `json:"p95"`
+	P99       []prommodel.SamplePair `json:"p99"`
+}
+
+// redAccumulator 累加多个集群的请求数与延迟直方图桶, 直方图桶在合并后再计算分位数
+type redAccumulator struct {
+	requests float64
+	errors   float64
+	buckets  map[float64]float64
+}
+
+func (a *redAccumulator) addRequests(code string, val float64) {
+	if math.IsNaN(val) {
+		return
+	}
+	a.requests += val
+	if strings.HasPrefix(code, "5") {
+		a.errors += val
+	}
+}
+
+func (a *redAccumulator) addBucket(le string, val float64) {
+	upper, err := strconv.ParseFloat(le, 64)
+	if err != nil || math.IsNaN(val) {
+		return
+	}
+	if a.buckets == nil {
+		a.buckets = map[float64]float64{}
+	}
+	a.buckets[upper] += val
+}
+
+func (a *redAccumulator) metrics(dur time.Duration) REDMetrics {
+	ret := REDMetrics{
+		Requests: math.Round(a.requests),
+		Errors:   math.Round(a.errors),
+		P50:      histogramQuantile(0.5, a.buckets),
+		P95:      histogramQuantile(0.95, a.buckets),
+		P99:      histogramQuantile(0.99, a.buckets),
+	}
+	if dur > 0 {
+		ret.RPS = a.requests / dur.Seconds()
+	}
+	if a.requests > 0 {
+		ret.ErrorRate = a.errors / a.requests
+	}
+	return ret
+}
+
+// histogramQuantile 与 promql 的 histogram_quantile 相同的算法, 无数据时返回 0
+func histogramQuantile(q float64, buckets map[float64]float64) float64 {
+	if len(buckets) < 2 || q < 0 || q > 1 {
+		return 0
+	}
+	uppers := make([]float64, 0, len(buckets))
+	for upper := range buckets {
+		uppers = append(uppers, upper)
+	}
+	sort.Float64s(uppers)
+	if !math.IsInf(uppers[len(uppers)-1], 1) {
+		return 0
+	}
+	counts := make([]float64, len(uppers))
+	for i, upper := range uppers {
+		counts[i] = buckets[upper]
+		// 采样时间不一致可能导致桶计数不单调
+		if i > 0 && counts[i] < counts[i-1] {
+			counts[i] = counts[i-1]
+		}
+	}
+	total := counts[len(counts)-1]
+	if total == 0 {
+		return 0
+	}
+	rank := q * total
+	b := sort.SearchFloat64s(counts, rank)
+	if b == len(uppers)-1 {
+		return uppers[len(uppers)-2]
+	}
+	if b == 0 && uppers[0] <= 0 {
+		return uppers[0]
+	}
+	var bucketStart, countStart float64
+	bucketEnd, countEnd := uppers[b], counts[b]
+	if b > 0 {
+		bucketStart, countStart = uppers[b-1], counts[b-1]
+		rank -= countStart
+		countEnd -= countStart
+	}
+	return bucketStart + (bucketEnd-bucketStart)*(rank/countEnd)
+}
+
+func graphNodeID(namespace, name string) string {
+	if name == "" || name == graphUnknownNode {
+		return graphUnknownNode
+	}
+	return namespace + "/" + name
+}
+
+func graphEdgeOf(metric prommodel.Metric) (string, string) {
+	source := graphNodeID(string(metric["source_workload_namespace"]), string(metric["source_canonical_service"]))
+	name := string(metric["destination_canonical_service"])
+	if name == "" || name == graphUnknownNode {
+		// 外部服务没有 canonical service, 使用 host
+		name = string(metric["destination_service_name"])
+	}
+	return source, graphNodeID(string(metric["destination_service_namespace"]), name)
+}
+
+type graphBuilder struct {
+	mu    sync.Mutex
+	nodes map[string]*redAccumulator
+	edges map[[2]string]*redAccumulator
+	// node id -> cluster set
+	clusters map[string]map[string]struct{}
+}
+
+func newGraphBuilder() *graphBuilder {
+	return &graphBuilder{
+		nodes:    map[string]*redAccumulator{},
+		edges:    map[[2]string]*redAccumulator{},
+		clusters: map[string]map[string]struct{}{},
+	}
+}
+
+func (g *graphBuilder) accumulators(cluster string, metric prommodel.Metric) (*redAccumulator, *redAccumulator) {
+	source, target := graphEdgeOf(metric)
+	for _, id := range []string{source, target} {
+		if _, ok := g.nodes[id]; !ok {
+			g.nodes[id] = &redAccumulator{}
+		}
+		if g.clusters[id] == nil {
+			g.clusters[id] = map[string]struct{}{}
+		}
+		if id != graphUnknownNode {
+			g.clusters[id][cluster] = struct{}{}
+		}
+	}
+	key := [2]string{source, target}
+	if _, ok := g.edges[key]; !ok {
+		g.edges[key] = &redAccumulator{}
+	}
+	return g.edges[key], g.nodes[target]
+}
+
+func (g *graphBuilder) addRequests(cluster string, vec prommodel.Vector) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, sample := range vec {
+		edge, node := g.accumulators(cluster, sample.Metric)
+		code := string(sample.Metric["response_code"])
+		edge.addRequests(code, float64(sample.Value))
+		node.addRequests(code, float64(sample.Value))
+	}
+}
+
+func (g *graphBuilder) addBuckets(cluster string, vec prommodel.Vector) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, sample := range vec {
+		edge, node := g.accumulators(cluster, sample.Metric)
+		le := string(sample.Metric["le"])
+		edge.addBucket(le, float64(sample.Value))
+		node.addBucket(le, float64(sample.Value))
+	}
+}
+
+// build 生成拓扑图, environments 为 namespace 到虚拟空间中环境名称的映射
+func (g *graphBuilder) build(start, end time.Time, environments map[string]string) *ServiceGraph {
+	dur := end.Sub(start)
+	ret := &ServiceGraph{Start: start, End: end, Nodes: []*GraphNode{}, Edges: []*GraphEdge{}}
+	for id, acc := range g.nodes {
+		node := &GraphNode{ID: id, Name: id, Clusters: []string{}, Metrics: acc.metrics(dur)}
+		if idx := strings.Index(id, "/"); idx > 0 {
+			node.Namespace, node.Name = id[:idx], id[idx+1:]
+			node.Environment = environments[node.Namespace]
+		}
+		for cluster := range g.clusters[id] {
+			node.Clusters = append(node.Clusters, cluster)
+		}
+		sort.Strings(node.Clusters)
+		ret.Nodes = append(ret.Nodes, node)
+	}
+	for key, acc := range g.edges {
+		ret.Edges = append(ret.Edges, &GraphEdge{Source: key[0], Target: key[1], Metrics: acc.metrics(dur)})
+	}
+	sort.Slice(ret.Nodes, func(i, j int) bool { return ret.Nodes[i].ID < ret.Nodes[j].ID })
+	sort.Slice(ret.Edges, func(i, j int) bool {
+		if ret.Edges[i].Source != ret.Edges[j].Source {
+			return ret.Edges[i].Source < ret.Edges[j].Source
+		}
+		return ret.Edges[i].Target < ret.Edges[j].Target
+	})
+	return ret
+}
+
+func namespacesRegexp(namespaces []string) string {
+	sorted := append([]string{}, namespaces...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, "|")
+}
+
+// graphRangeSelector 生成时间范围选择器, end 早于当前时间时使用 offset
+func graphRangeSelector(start, end, now time.Time) string {
+	ret := fmt.Sprintf("[%s]", prommodel.Duration(end.Sub(start)))
+	if offset := now.Sub(end).Truncate(time.Second); offset >= time.Second {
+		ret += fmt.Sprintf(" offset %s", prommodel.Duration(offset))
+	}
+	return ret
+}
+
+// graphQuery 生成拓扑图的 promql, 包含访问虚拟空间内服务的请求(由服务端上报)与虚拟空间内服务访问外部的请求(由客户端上报)
+// namespaces 为当前集群中的环境 namespace, allNamespaces 为虚拟空间所有集群中的环境 namespace,
+// 访问其他集群中虚拟空间服务的请求已由服务端所在集群上报, 客户端上报的请求中需要排除
+func graphQuery(metric, by string, namespaces, allNamespaces []string, rangeSelector string) string {
+	nsreg := namespacesRegexp(namespaces)
+	inbound := fmt.Sprintf(`sum by (%s,%s) (increase(%s{reporter="destination",destination_service_namespace=~"%s"}%s))`,
+		graphEdgeLabels, by, metric, nsreg, rangeSelector)
+	outbound := fmt.Sprintf(`sum by (%s,%s) (increase(%s{reporter="source",source_workload_namespace=~"%s",destination_service_namespace!~"%s"}%s))`,
+		graphEdgeLabels, by, metric, nsreg, namespacesRegexp(allNamespaces), rangeSelector)
+	return inbound + " or " + outbound
+}
+
+func parseGraphTimeRange(c *gin.Context, now time.Time) (time.Time, time.Time, error) {
+	end := now
+	if val := c.Query("end"); val != "" {
+		t, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		end = t
+	}
+	start := end.Add(-graphDefaultRange)
+	if val := c.Query("start"); val != "" {
+		t, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		start = t
+	}
+	if end.After(now) {
+		end = now
+	}
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, i18n.Errorf(c, "start time must be before end time")
+	}
+	if end.Sub(start) > graphMaxRange {
+		return time.Time{}, time.Time{}, i18n.Errorf(c, "time range must not exceed %s", graphMaxRange)
+	}
+	// promql 的时间范围精度为秒
+	return start.Truncate(time.Second), end.Truncate(time.Second), nil
+}
+
+// virtualSpaceNamespaces 按集群分组虚拟空间下的环境 namespace
+func (h *VirtualSpaceHandler) virtualSpaceNamespaces(c *gin.Context) (map[string][]string, map[string]string, error) {
+	vs := models.VirtualSpace{}
+	if err := h.GetDB().Preload("Environments.Cluster").First(&vs, c.Param("virtualspace_id")).Error; err != nil {
+		return nil, nil, err
+	}
+	clusters := map[string][]string{}
+	environments := map[string]string{}
+	for _, env := range vs.Environments {
+		if env.Cluster == nil {
+			continue
+		}
+		clusters[env.Cluster.ClusterName] = append(clusters[env.Cluster.ClusterName], env.Namespace)
+		environments[env.Namespace] = env.EnvironmentName
+	}
+	return clusters, environments, nil
+}
+
+// ServiceGraph 虚拟空间服务拓扑
+// @Tags        VirtualSpace
+// @Summary     虚拟空间服务拓扑
+// @Description 根据 istio 标准指标生成虚拟空间所有环境(跨集群)的服务调用拓扑与 RED 指标
+// @Accept      json
+// @Produce     json
+// @Param       virtualspace_id path     uint                                       true  "virtualspace_id"
+// @Param       start           query    string                                     false "开始时间，格式 2006-01-02T15:04:05Z07:00, 默认结束时间前1小时"
+// @Param       end             query    string                                     false "结束时间，格式 2006-01-02T15:04:05Z07:00, 默认当前时间"
+// @Success     200             {object} handlers.ResponseStruct{Data=ServiceGraph} "resp"
+// @Router      /v1/virtualspace/{virtualspace_id}/graph [get]
+// @Security    JWT
+func (h *VirtualSpaceHandler) ServiceGraph(c *gin.Context) {
+	now := time.Now()
+	start, end, err := parseGraphTimeRange(c, now)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	clusters, environments, err := h.virtualSpaceNamespaces(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	allNamespaces := []string{}
+	for ns := range environments {
+		allNamespaces = append(allNamespaces, ns)
+	}
+
+	builder := newGraphBuilder()
+	rangeSelector := graphRangeSelector(start, end, now)
+	eg, ctx := errgroup.WithContext(c.Request.Context())
+	for cluster, namespaces := range clusters {
+		cluster, namespaces := cluster, namespaces
+		eg.Go(func() error {
+			return h.Execute(ctx, cluster, func(ctx context.Context, cli agents.Client) error {
+				requests, err := cli.Extend().PrometheusVector(ctx, graphQuery("istio_requests_total", "response_code", namespaces, allNamespaces, rangeSelector))
+				if err != nil {
+					return err
+				}
+				builder.addRequests(cluster, requests)
+				buckets, err := cli.Extend().PrometheusVector(ctx, graphQuery("istio_request_duration_milliseconds_bucket", "le", namespaces, allNamespaces, rangeSelector))
+				if err != nil {
+					return err
+				}
+				builder.addBuckets(cluster, buckets)
+				return nil
+			})
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, builder.build(start, end, environments))
+}
+
+// graphStep 计算时间序列的步长, 不超过 graphMaxPoints 个点
+func graphStep(start, end time.Time) time.Duration {
+	step := (end.Sub(start) / graphMaxPoints).Truncate(time.Second)
+	if step < 15*time.Second {
+		step = 15 * time.Second
+	}
+	return step
+}
+
+type seriesAccumulator map[prommodel.Time]*redAccumulator
+
+func (s seriesAccumulator) at(t prommodel.Time) *redAccumulator {
+	if _, ok := s[t]; !ok {
+		s[t] = &redAccumulator{}
+	}
+	return s[t]
+}
+
+func (s seriesAccumulator) addRequests(matrix prommodel.Matrix) {
+	for _, stream := range matrix {
+		for _, point := range stream.Values {
+			s.at(point.Timestamp).addRequests(string(stream.Metric["response_code"]), float64(point.Value))
+		}
+	}
+}
+
+func (s seriesAccumulator) addBuckets(matrix prommodel.Matrix) {
+	for _, stream := range matrix {
+		for _, point := range stream.Values {
+			s.at(point.Timestamp).addBucket(string(stream.Metric["le"]), float64(point.Value))
+		}
+	}
+}
+
+// signals requests 为每秒请求数, 直接作为 rps
+func (s seriesAccumulator) signals() *ServiceGoldenSignals {
+	times := make([]prommodel.Time, 0, len(s))
+	for t := range s {
+		times = append(times, t)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	ret := &ServiceGoldenSignals{
+		RPS:       []prommodel.SamplePair{},
+		ErrorRate: []prommodel.SamplePair{},
+		P50:       []prommodel.SamplePair{},
+		P95:       []prommodel.SamplePair{},
+		P99:       []prommodel.SamplePair{},
+	}
+	for _, t := range times {
+		m := s[t].metrics(0)
+		ret.RPS = append(ret.RPS, prommodel.SamplePair{Timestamp: t, Value: prommodel.SampleValue(s[t].requests)})
+		ret.ErrorRate = append(ret.ErrorRate, prommodel.SamplePair{Timestamp: t, Value: prommodel.SampleValue(m.ErrorRate)})
+		if s[t].buckets != nil {
+			ret.P50 = append(ret.P50, prommodel.SamplePair{Timestamp: t, Value: prommodel.SampleValue(m.P50)})
+			ret.P95 = append(ret.P95, prommodel.SamplePair{Timestamp: t, Value: prommodel.SampleValue(m.P95)})
+			ret.P99 = append(ret.P99, prommodel.SamplePair{Timestamp: t, Value: prommodel.SampleValue(m.P99)})
+		}
+	}
+	return ret
+}
+
+// ServiceGoldenSignals 服务黄金指标
+// @Tags        VirtualSpace
+// @Summary     服务黄金指标
+// @Description 获取虚拟空间中服务的 rps、错误率、p50/p95/p99 延迟时间序列, 合并服务所在的所有集群
+// @Accept      json
+// @Produce     json
+// @Param       virtualspace_id path     uint                                               true  "virtualspace_id"
+// @Param       namespace       query    string                                             true  "服务所在 namespace"
+// @Param       service         query    string                                             true  "服务名称(istio canonical service)"
+// @Param       start           query    string                                             false "开始时间，格式 2006-01-02T15:04:05Z07:00, 默认结束时间前1小时"
+// @Param       end             query    string                                             false "结束时间，格式 2006-01-02T15:04:05Z07:00, 默认当前时间"
+// @Success     200             {object} handlers.ResponseStruct{Data=ServiceGoldenSignals} "resp"
+// @Router      /v1/virtualspace/{virtualspace_id}/graph/signals [get]
+// @Security    JWT
+func (h *VirtualSpaceHandler) ServiceGoldenSignals(c *gin.Context) {
+	namespace, service := c.Query("namespace"), c.Query("service")
+	if namespace == "" || service == "" {
+		handlers.NotOK(c, i18n.Errorf(c, "namespace and service are required"))
+		return
+	}
+	now := time.Now()
+	start, end, err := parseGraphTimeRange(c, now)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	clusters, environments, err := h.virtualSpaceNamespaces(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if _, ok := environments[namespace]; !ok {
+		handlers.NotOK(c, i18n.Errorf(c, "namespace %s not in the virtual space", namespace))
+		return
+	}
+
+	step := graphStep(start, end)
+	window := step
+	if window < graphMinRateWindow {
+		window = graphMinRateWindow
+	}
+	selector := fmt.Sprintf(`reporter="destination",destination_service_namespace="%s",destination_canonical_service="%s"`, namespace, service)
+	requestsQuery := fmt.Sprintf(`sum by (response_code) (rate(istio_requests_total{%s}[%s]))`, selector, prommodel.Duration(window))
+	bucketsQuery := fmt.Sprintf(`sum by (le) (rate(istio_request_duration_milliseconds_bucket{%s}[%s]))`, selector, prommodel.Duration(window))
+
+	series := seriesAccumulator{}
+	mu := sync.Mutex{}
+	eg, ctx := errgroup.WithContext(c.Request.Context())
+	for cluster, namespaces := range clusters {
+		cluster, namespaces := cluster, namespaces
+		if !containsString(namespaces, namespace) {
+			continue
+		}
+		eg.Go(func() error {
+			return h.Execute(ctx, cluster, func(ctx context.Context, cli agents.Client) error {
+				// agent 只接受 UTC 时间与以秒为单位的步长
+				startstr, endstr := start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339)
+				stepstr := strconv.Itoa(int(step.Seconds()))
+				requests, err := cli.Extend().PrometheusQueryRange(ctx, requestsQuery, startstr, endstr, stepstr)
+				if err != nil {
+					return err
+				}
+				buckets, err := cli.Extend().PrometheusQueryRange(ctx, bucketsQuery, startstr, endstr, stepstr)
+				if err != nil {
+					return err
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				series.addRequests(requests)
+				series.addBuckets(buckets)
+				return nil
+			})
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, series.signals())
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
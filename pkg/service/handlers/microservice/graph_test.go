@@ -0,0 +1,141 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package microservice
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	prommodel "github.com/prometheus/common/model"
+)
+
+func Test_histogramQuantile(t *testing.T) {
+	tests := []struct {
+		name    string
+		q       float64
+		buckets map[float64]float64
+		want    float64
+	}{
+		{name: "empty", q: 0.5, buckets: nil, want: 0},
+		{name: "no inf bucket", q: 0.5, buckets: map[float64]float64{10: 1, 100: 2}, want: 0},
+		{name: "no requests", q: 0.5, buckets: map[float64]float64{10: 0, math.Inf(1): 0}, want: 0},
+		{name: "interpolate first bucket", q: 0.5, buckets: map[float64]float64{10: 10, 100: 10, math.Inf(1): 10}, want: 5},
+		{name: "interpolate", q: 0.5, buckets: map[float64]float64{10: 0, 100: 10, math.Inf(1): 10}, want: 55},
+		{name: "inf bucket", q: 0.99, buckets: map[float64]float64{10: 1, 100: 2, math.Inf(1): 10}, want: 100},
+		{name: "non monotonic", q: 0.5, buckets: map[float64]float64{10: 4, 100: 3, math.Inf(1): 8}, want: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := histogramQuantile(tt.q, tt.buckets); got != tt.want {
+				t.Errorf("histogramQuantile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func edgeMetric(srcns, src, dstns, dst string, extra ...string) prommodel.Metric {
+	m := prommodel.Metric{
+		"source_workload_namespace":     prommodel.LabelValue(srcns),
+		"source_canonical_service":      prommodel.LabelValue(src),
+		"destination_service_namespace": prommodel.LabelValue(dstns),
+		"destination_canonical_service": prommodel.LabelValue(dst),
+		"destination_service_name":      prommodel.LabelValue(dst),
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		m[prommodel.LabelName(extra[i])] = prommodel.LabelValue(extra[i+1])
+	}
+	return m
+}
+
+func Test_graphBuilder(t *testing.T) {
+	builder := newGraphBuilder()
+	// 同一条边分布在两个集群
+	builder.addRequests("c1", prommodel.Vector{
+		{Metric: edgeMetric("shop", "web", "shop", "api", "response_code", "200"), Value: 90},
+		{Metric: edgeMetric("shop", "web", "shop", "api", "response_code", "503"), Value: 10},
+		{Metric: edgeMetric("unknown", "unknown", "shop", "web", "response_code", "200"), Value: 50},
+	})
+	builder.addRequests("c2", prommodel.Vector{
+		{Metric: edgeMetric("shop", "web", "shop", "api", "response_code", "200"), Value: 100},
+	})
+	builder.addBuckets("c1", prommodel.Vector{
+		{Metric: edgeMetric("shop", "web", "shop", "api", "le", "10"), Value: 50},
+		{Metric: edgeMetric("shop", "web", "shop", "api", "le", "+Inf"), Value: 100},
+	})
+	builder.addBuckets("c2", prommodel.Vector{
+		{Metric: edgeMetric("shop", "web", "shop", "api", "le", "10"), Value: 50},
+		{Metric: edgeMetric("shop", "web", "shop", "api", "le", "+Inf"), Value: 100},
+	})
+
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	graph := builder.build(start, start.Add(100*time.Second), map[string]string{"shop": "shop-dev"})
+	if len(graph.Nodes) != 3 || len(graph.Edges) != 2 {
+		t.Fatalf("nodes = %d, edges = %d, want 3, 2", len(graph.Nodes), len(graph.Edges))
+	}
+	api := graph.Nodes[0]
+	if api.ID != "shop/api" || api.Environment != "shop-dev" || strings.Join(api.Clusters, ",") != "c1,c2" {
+		t.Errorf("unexpected node %+v", api)
+	}
+	want := REDMetrics{Requests: 200, RPS: 2, Errors: 10, ErrorRate: 0.05, P50: 10, P95: 10, P99: 10}
+	if api.Metrics != want {
+		t.Errorf("node metrics = %+v, want %+v", api.Metrics, want)
+	}
+	if unknown := graph.Nodes[2]; unknown.ID != graphUnknownNode || len(unknown.Clusters) != 0 || unknown.Environment != "" {
+		t.Errorf("unexpected unknown node %+v", unknown)
+	}
+	if edge := graph.Edges[0]; edge.Source != "shop/web" || edge.Target != "shop/api" || edge.Metrics.Requests != 200 {
+		t.Errorf("unexpected edge %+v", edge)
+	}
+}
+
+func Test_graphRangeSelector(t *testing.T) {
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	if got := graphRangeSelector(now.Add(-time.Hour), now, now); got != "[1h]" {
+		t.Errorf("graphRangeSelector() = %v", got)
+	}
+	if got := graphRangeSelector(now.Add(-3*time.Hour), now.Add(-2*time.Hour), now); got != "[1h] offset 2h" {
+		t.Errorf("graphRangeSelector() = %v", got)
+	}
+}
+
+func Test_graphQuery(t *testing.T) {
+	got := graphQuery("istio_requests_total", "response_code", []string{"b", "a"}, []string{"c", "b", "a"}, "[1h]")
+	for _, want := range []string{
+		`istio_requests_total{reporter="destination",destination_service_namespace=~"a|b"}[1h]`,
+		`istio_requests_total{reporter="source",source_workload_namespace=~"a|b",destination_service_namespace!~"a|b|c"}[1h]`,
+		`,response_code) (increase(`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("graphQuery() = %v, want contains %v", got, want)
+		}
+	}
+}
+
+func Test_seriesAccumulator(t *testing.T) {
+	series := seriesAccumulator{}
+	series.addRequests(prommodel.Matrix{
+		{Metric: prommodel.Metric{"response_code": "200"}, Values: []prommodel.SamplePair{{Timestamp: 2000, Value: 3}, {Timestamp: 1000, Value: 1}}},
+		{Metric: prommodel.Metric{"response_code": "500"}, Values: []prommodel.SamplePair{{Timestamp: 2000, Value: 1}}},
+	})
+	signals := series.signals()
+	if len(signals.RPS) != 2 || signals.RPS[0].Timestamp != 1000 || signals.RPS[1].Value != 4 {
+		t.Errorf("rps = %v", signals.RPS)
+	}
+	if signals.ErrorRate[1].Value != 0.25 || len(signals.P99) != 0 {
+		t.Errorf("error rate = %v, p99 = %v", signals.ErrorRate, signals.P99)
+	}
+}
@@ -0,0 +1,706 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package microservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gogo/protobuf/jsonpb"
+	ptypes "github.com/gogo/protobuf/types"
+	networkingv1alpha3 "istio.io/api/networking/v1alpha3"
+	securityv1beta1 "istio.io/api/security/v1beta1"
+	typev1beta1 "istio.io/api/type/v1beta1"
+	networkingpkgv1alpha3 "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	securitypkgv1beta1 "istio.io/client-go/pkg/apis/security/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"kubegems.io/kubegems/pkg/apis/networking"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/agents"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	localRateLimitSuffix      = "-local-ratelimit"
+	authorizationPolicyPrefix = "kubegems-"
+	defaultPeerAuthName       = "default"
+
+	labelManagedBy    = "app.kubernetes.io/managed-by"
+	managedByKubegems = "kubegems"
+)
+
+// CircuitBreaking 熔断配置, 对应 DestinationRule 的 connectionPool 与 outlierDetection, 字段为 0 表示不限制
+type CircuitBreaking struct {
+	MaxConnections           int32 `json:"maxConnections"`           // 最大 tcp 连接数
+	HTTP1MaxPendingRequests  int32 `json:"http1MaxPendingRequests"`  // 最大等待请求数
+	HTTP2MaxRequests         int32 `json:"http2MaxRequests"`         // 最大并发请求数
+	MaxRequestsPerConnection int32 `json:"maxRequestsPerConnection"` // 每个连接的最大请求数
+	MaxRetries               int32 `json:"maxRetries"`               // 最大并发重试数
+
+	Consecutive5xxErrors uint32 `json:"consecutive5xxErrors"` // 连续 5xx 错误次数, 超过后驱逐实例
+	Interval             string `json:"interval"`             // 驱逐检测间隔, 如 10s
+	BaseEjectionTime     string `json:"baseEjectionTime"`     // 最短驱逐时间, 如 30s
+	MaxEjectionPercent   int32  `json:"maxEjectionPercent"`   // 最大驱逐实例百分比
+}
+
+// RetryPolicy 重试策略, 作用于 VirtualService 的所有 http 路由, attempts 为 0 时使用 istio 默认策略
+type RetryPolicy struct {
+	Attempts      int32  `json:"attempts"`
+	PerTryTimeout string `json:"perTryTimeout"` // 如 2s
+	RetryOn       string `json:"retryOn"`       // 如 5xx,gateway-error,connect-failure
+}
+
+// ServiceAuthorization 服务访问白名单, sources 为空时不限制访问
+type ServiceAuthorization struct {
+	Sources []AuthorizationSource `json:"sources"`
+}
+
+type AuthorizationSource struct {
+	Namespace      string   `json:"namespace" binding:"required"`
+	ServiceAccount string   `json:"serviceAccount"` // 为空时允许 namespace 下的所有服务
+	Methods        []string `json:"methods"`        // 为空时允许所有方法
+	Paths          []string `json:"paths"`          // 为空时允许所有路径
+}
+
+// LocalRateLimit 本地限流, 通过 EnvoyFilter 为服务的 sidecar 配置令牌桶, maxTokens 为 0 时取消限流
+type LocalRateLimit struct {
+	MaxTokens     uint32 `json:"maxTokens"`     // 令牌桶容量
+	TokensPerFill uint32 `json:"tokensPerFill"` // 每次填充的令牌数, 默认与容量相同
+	FillInterval  string `json:"fillInterval"`  // 填充间隔, 默认 1s
+}
+
+type ServiceMeshPolicies struct {
+	CircuitBreaking *CircuitBreaking      `json:"circuitBreaking"`
+	Retries         *RetryPolicy          `json:"retries"`
+	Authorization   *ServiceAuthorization `json:"authorization"`
+	RateLimit       *LocalRateLimit       `json:"rateLimit"`
+}
+
+type EnvironmentMTLS struct {
+	Mode string `json:"mode"` // STRICT, PERMISSIVE, DISABLE, 为空时删除策略(继承网格配置)
+}
+
+func parseMeshDuration(val string) (*ptypes.Duration, error) {
+	if val == "" {
+		return nil, nil
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return nil, err
+	}
+	if d < time.Millisecond {
+		return nil, fmt.Errorf("duration %s must be at least 1ms", val)
+	}
+	return ptypes.DurationProto(d), nil
+}
+
+func formatMeshDuration(d *ptypes.Duration) string {
+	if d == nil {
+		return ""
+	}
+	dur, err := ptypes.DurationFromProto(d)
+	if err != nil {
+		return ""
+	}
+	return dur.String()
+}
+
+// applyCircuitBreaking 修改 TrafficPolicy 中的熔断配置, 保留负载均衡、tls 等其他配置
+func applyCircuitBreaking(tp *networkingv1alpha3.TrafficPolicy, cb CircuitBreaking) (*networkingv1alpha3.TrafficPolicy, error) {
+	if tp == nil {
+		tp = &networkingv1alpha3.TrafficPolicy{}
+	}
+	tp.ConnectionPool = nil
+	if cb.MaxConnections > 0 {
+		tp.ConnectionPool = &networkingv1alpha3.ConnectionPoolSettings{
+			Tcp: &networkingv1alpha3.ConnectionPoolSettings_TCPSettings{MaxConnections: cb.MaxConnections},
+		}
+	}
+	if cb.HTTP1MaxPendingRequests > 0 || cb.HTTP2MaxRequests > 0 || cb.MaxRequestsPerConnection > 0 || cb.MaxRetries > 0 {
+		if tp.ConnectionPool == nil {
+			tp.ConnectionPool = &networkingv1alpha3.ConnectionPoolSettings{}
+		}
+		tp.ConnectionPool.Http = &networkingv1alpha3.ConnectionPoolSettings_HTTPSettings{
+			Http1MaxPendingRequests:  cb.HTTP1MaxPendingRequests,
+			Http2MaxRequests:         cb.HTTP2MaxRequests,
+			MaxRequestsPerConnection: cb.MaxRequestsPerConnection,
+			MaxRetries:               cb.MaxRetries,
+		}
+	}
+
+	tp.OutlierDetection = nil
+	if cb.Consecutive5xxErrors > 0 {
+		interval, err := parseMeshDuration(cb.Interval)
+		if err != nil {
+			return nil, err
+		}
+		ejection, err := parseMeshDuration(cb.BaseEjectionTime)
+		if err != nil {
+			return nil, err
+		}
+		if cb.MaxEjectionPercent < 0 || cb.MaxEjectionPercent > 100 {
+			return nil, fmt.Errorf("maxEjectionPercent must be in [0, 100]")
+		}
+		tp.OutlierDetection = &networkingv1alpha3.OutlierDetection{
+			Consecutive_5XxErrors: &ptypes.UInt32Value{Value: cb.Consecutive5xxErrors},
+			Interval:              interval,
+			BaseEjectionTime:      ejection,
+			MaxEjectionPercent:    cb.MaxEjectionPercent,
+		}
+	}
+	return tp, nil
+}
+
+func circuitBreakingOf(tp *networkingv1alpha3.TrafficPolicy) *CircuitBreaking {
+	if tp == nil || (tp.ConnectionPool == nil && tp.OutlierDetection == nil) {
+		return nil
+	}
+	cb := &CircuitBreaking{}
+	if pool := tp.ConnectionPool; pool != nil {
+		cb.MaxConnections = pool.GetTcp().GetMaxConnections()
+		cb.HTTP1MaxPendingRequests = pool.GetHttp().GetHttp1MaxPendingRequests()
+		cb.HTTP2MaxRequests = pool.GetHttp().GetHttp2MaxRequests()
+		cb.MaxRequestsPerConnection = pool.GetHttp().GetMaxRequestsPerConnection()
+		cb.MaxRetries = pool.GetHttp().GetMaxRetries()
+	}
+	if od := tp.OutlierDetection; od != nil {
+		cb.Consecutive5xxErrors = od.GetConsecutive_5XxErrors().GetValue()
+		cb.Interval = formatMeshDuration(od.Interval)
+		cb.BaseEjectionTime = formatMeshDuration(od.BaseEjectionTime)
+		cb.MaxEjectionPercent = od.MaxEjectionPercent
+	}
+	return cb
+}
+
+func (r RetryPolicy) toHTTPRetry() (*networkingv1alpha3.HTTPRetry, error) {
+	if r.Attempts < 0 {
+		return nil, fmt.Errorf("attempts must not be negative")
+	}
+	if r.Attempts == 0 {
+		return nil, nil
+	}
+	timeout, err := parseMeshDuration(r.PerTryTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &networkingv1alpha3.HTTPRetry{Attempts: r.Attempts, PerTryTimeout: timeout, RetryOn: r.RetryOn}, nil
+}
+
+// applyRetries 为所有 http 路由设置重试策略, 没有路由时增加一条指向服务自身的路由
+func applyRetries(vs *networkingpkgv1alpha3.VirtualService, host string, retry *networkingv1alpha3.HTTPRetry) {
+	if len(vs.Spec.Hosts) == 0 {
+		vs.Spec.Hosts = []string{host}
+	}
+	if len(vs.Spec.Http) == 0 && retry != nil {
+		vs.Spec.Http = []*networkingv1alpha3.HTTPRoute{{
+			Route: []*networkingv1alpha3.HTTPRouteDestination{{
+				Destination: &networkingv1alpha3.Destination{Host: host},
+				Weight:      100,
+			}},
+		}}
+	}
+	for _, route := range vs.Spec.Http {
+		route.Retries = retry
+	}
+}
+
+// reapplyRetries 重建 VirtualService 路由后按保存的重试策略重新设置未显式指定重试的路由
+func reapplyRetries(vs *networkingpkgv1alpha3.VirtualService, previous *RetryPolicy) error {
+	policy := &RetryPolicy{}
+	if !meshPolicyAnnotationOf(vs, policy) {
+		if previous == nil {
+			return nil
+		}
+		policy = previous
+	}
+	retry, err := policy.toHTTPRetry()
+	if err != nil || retry == nil {
+		return err
+	}
+	for _, route := range vs.Spec.Http {
+		if route.Retries == nil {
+			route.Retries = retry
+		}
+	}
+	return nil
+}
+
+func retriesOf(vs *networkingpkgv1alpha3.VirtualService) *RetryPolicy {
+	for _, route := range vs.Spec.Http {
+		if route.Retries != nil {
+			return &RetryPolicy{
+				Attempts:      route.Retries.Attempts,
+				PerTryTimeout: formatMeshDuration(route.Retries.PerTryTimeout),
+				RetryOn:       route.Retries.RetryOn,
+			}
+		}
+	}
+	return nil
+}
+
+func authorizationPolicySpec(selector map[string]string, authz ServiceAuthorization) securityv1beta1.AuthorizationPolicy {
+	spec := securityv1beta1.AuthorizationPolicy{
+		Selector: &typev1beta1.WorkloadSelector{MatchLabels: selector},
+		Action:   securityv1beta1.AuthorizationPolicy_ALLOW,
+	}
+	for _, src := range authz.Sources {
+		from := &securityv1beta1.Source{}
+		if src.ServiceAccount != "" {
+			// 使用后缀匹配, 不依赖集群的 trust domain
+			from.Principals = []string{fmt.Sprintf("*/ns/%s/sa/%s", src.Namespace, src.ServiceAccount)}
+		} else {
+			from.Namespaces = []string{src.Namespace}
+		}
+		rule := &securityv1beta1.Rule{From: []*securityv1beta1.Rule_From{{Source: from}}}
+		if len(src.Methods) > 0 || len(src.Paths) > 0 {
+			rule.To = []*securityv1beta1.Rule_To{{Operation: &securityv1beta1.Operation{Methods: src.Methods, Paths: src.Paths}}}
+		}
+		spec.Rules = append(spec.Rules, rule)
+	}
+	return spec
+}
+
+func localRateLimitSpec(selector map[string]string, rl LocalRateLimit) (networkingv1alpha3.EnvoyFilter, error) {
+	if rl.TokensPerFill == 0 {
+		rl.TokensPerFill = rl.MaxTokens
+	}
+	if rl.FillInterval == "" {
+		rl.FillInterval = "1s"
+	}
+	interval, err := time.ParseDuration(rl.FillInterval)
+	if err != nil {
+		return networkingv1alpha3.EnvoyFilter{}, err
+	}
+	if interval < 50*time.Millisecond {
+		return networkingv1alpha3.EnvoyFilter{}, fmt.Errorf("fillInterval must be at least 50ms")
+	}
+	percent := map[string]interface{}{"numerator": 100, "denominator": "HUNDRED"}
+	value := map[string]interface{}{
+		"name": "envoy.filters.http.local_ratelimit",
+		"typed_config": map[string]interface{}{
+			"@type":    "type.googleapis.com/udpa.type.v1.TypedStruct",
+			"type_url": "type.googleapis.com/envoy.extensions.filters.http.local_ratelimit.v3.LocalRateLimit",
+			"value": map[string]interface{}{
+				"stat_prefix": "http_local_rate_limiter",
+				"token_bucket": map[string]interface{}{
+					"max_tokens":      rl.MaxTokens,
+					"tokens_per_fill": rl.TokensPerFill,
+					"fill_interval":   fmt.Sprintf("%.3fs", interval.Seconds()),
+				},
+				"filter_enabled":  map[string]interface{}{"runtime_key": "local_rate_limit_enabled", "default_value": percent},
+				"filter_enforced": map[string]interface{}{"runtime_key": "local_rate_limit_enforced", "default_value": percent},
+				"response_headers_to_add": []interface{}{
+					map[string]interface{}{"append": false, "header": map[string]interface{}{"key": "x-local-rate-limit", "value": "true"}},
+				},
+			},
+		},
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return networkingv1alpha3.EnvoyFilter{}, err
+	}
+	patchValue := &ptypes.Struct{}
+	if err := jsonpb.UnmarshalString(string(raw), patchValue); err != nil {
+		return networkingv1alpha3.EnvoyFilter{}, err
+	}
+	return networkingv1alpha3.EnvoyFilter{
+		WorkloadSelector: &networkingv1alpha3.WorkloadSelector{Labels: selector},
+		ConfigPatches: []*networkingv1alpha3.EnvoyFilter_EnvoyConfigObjectPatch{{
+			ApplyTo: networkingv1alpha3.EnvoyFilter_HTTP_FILTER,
+			Match: &networkingv1alpha3.EnvoyFilter_EnvoyConfigObjectMatch{
+				Context: networkingv1alpha3.EnvoyFilter_SIDECAR_INBOUND,
+				ObjectTypes: &networkingv1alpha3.EnvoyFilter_EnvoyConfigObjectMatch_Listener{
+					Listener: &networkingv1alpha3.EnvoyFilter_ListenerMatch{
+						FilterChain: &networkingv1alpha3.EnvoyFilter_ListenerMatch_FilterChainMatch{
+							Filter: &networkingv1alpha3.EnvoyFilter_ListenerMatch_FilterMatch{
+								Name: "envoy.filters.network.http_connection_manager",
+							},
+						},
+					},
+				},
+			},
+			Patch: &networkingv1alpha3.EnvoyFilter_Patch{
+				Operation: networkingv1alpha3.EnvoyFilter_Patch_INSERT_BEFORE,
+				Value:     patchValue,
+			},
+		}},
+	}, nil
+}
+
+func parseMTLSMode(mode string) (securityv1beta1.PeerAuthentication_MutualTLS_Mode, error) {
+	val, ok := securityv1beta1.PeerAuthentication_MutualTLS_Mode_value[strings.ToUpper(mode)]
+	if !ok {
+		return 0, fmt.Errorf("mtls mode %s not valid, must be one of STRICT, PERMISSIVE, DISABLE", mode)
+	}
+	return securityv1beta1.PeerAuthentication_MutualTLS_Mode(val), nil
+}
+
+func setMeshPolicyAnnotation(obj metav1.Object, policy interface{}) error {
+	raw, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[networking.AnnotationMeshPolicy] = string(raw)
+	obj.SetAnnotations(annotations)
+	return nil
+}
+
+func meshPolicyAnnotationOf(obj metav1.Object, into interface{}) bool {
+	raw, ok := obj.GetAnnotations()[networking.AnnotationMeshPolicy]
+	if !ok {
+		return false
+	}
+	return json.Unmarshal([]byte(raw), into) == nil
+}
+
+func removeMeshPolicyAnnotation(obj metav1.Object) {
+	annotations := obj.GetAnnotations()
+	delete(annotations, networking.AnnotationMeshPolicy)
+	obj.SetAnnotations(annotations)
+}
+
+func authorizationPolicyName(service string) string {
+	return authorizationPolicyPrefix + service
+}
+
+// isManagedByKubegems 对象不存在或由 kubegems 创建, 避免覆盖用户自行维护的同名资源
+func isManagedByKubegems(obj metav1.Object) bool {
+	ts := obj.GetCreationTimestamp()
+	return ts.IsZero() || obj.GetLabels()[labelManagedBy] == managedByKubegems
+}
+
+func setManagedByKubegems(obj metav1.Object) {
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[labelManagedBy] = managedByKubegems
+	obj.SetLabels(labels)
+}
+
+// deleteIgnoreNotFound 删除对象, 对象不存在时不报错
+func deleteIgnoreNotFound(ctx context.Context, tc agents.Client, obj client.Object) error {
+	return client.IgnoreNotFound(tc.Delete(ctx, obj))
+}
+
+type servicePolicyFunc func(ctx context.Context, tc agents.Client, svc *v1.Service) error
+
+// servicePolicyProcess 获取环境中的 service 后在集群中执行策略修改
+func (h *VirtualSpaceHandler) servicePolicyProcess(c *gin.Context, decodebody interface{}, module string, process servicePolicyFunc) {
+	h.environmentProcess(c, decodebody, func(ctx context.Context, env models.Environment) (interface{}, error) {
+		h.SetExtraAuditDataByClusterNamespace(c, env.Cluster.ClusterName, env.Namespace)
+		h.SetAuditData(c, i18n.Sprintf(c, "update"), module, c.Param("service_name"))
+		return "ok", h.Execute(ctx, env.Cluster.ClusterName, func(ctx context.Context, tc agents.Client) error {
+			svc := &v1.Service{}
+			if err := tc.Get(ctx, client.ObjectKey{Namespace: env.Namespace, Name: c.Param("service_name")}, svc); err != nil {
+				return err
+			}
+			if len(svc.Spec.Selector) == 0 {
+				return i18n.Errorf(ctx, "service %s has no selector", svc.Name)
+			}
+			return process(ctx, tc, svc)
+		})
+	})
+}
+
+// GetServiceMeshPolicies 获取 service 的流量策略
+// @Tags        VirtualSpace
+// @Summary     获取 service 的流量策略
+// @Description 获取 service 的熔断、重试、访问白名单、限流策略
+// @Accept      json
+// @Produce     json
+// @Param       virtualspace_id path     uint                                              true "virtualspace_id"
+// @Param       environment_id  path     uint                                              true "environment_id"
+// @Param       service_name    path     string                                            true "service_name"
+// @Success     200             {object} handlers.ResponseStruct{Data=ServiceMeshPolicies} "resp"
+// @Router      /v1/virtualspace/{virtualspace_id}/environment/{environment_id}/service/{service_name}/policies [get]
+// @Security    JWT
+func (h *VirtualSpaceHandler) GetServiceMeshPolicies(c *gin.Context) {
+	h.environmentProcess(c, nil, func(ctx context.Context, env models.Environment) (interface{}, error) {
+		ret := &ServiceMeshPolicies{}
+		name := c.Param("service_name")
+		err := h.Execute(ctx, env.Cluster.ClusterName, func(ctx context.Context, tc agents.Client) error {
+			key := client.ObjectKey{Namespace: env.Namespace, Name: name}
+			dr := &networkingpkgv1alpha3.DestinationRule{}
+			if err := tc.Get(ctx, key, dr); err == nil {
+				ret.CircuitBreaking = circuitBreakingOf(dr.Spec.TrafficPolicy)
+			} else if !apierrors.IsNotFound(err) {
+				return err
+			}
+			vs := &networkingpkgv1alpha3.VirtualService{}
+			if err := tc.Get(ctx, key, vs); err == nil {
+				ret.Retries = retriesOf(vs)
+			} else if !apierrors.IsNotFound(err) {
+				return err
+			}
+			ap := &securitypkgv1beta1.AuthorizationPolicy{}
+			if err := tc.Get(ctx, client.ObjectKey{Namespace: env.Namespace, Name: authorizationPolicyName(name)}, ap); err == nil {
+				authz := &ServiceAuthorization{}
+				if isManagedByKubegems(ap) && meshPolicyAnnotationOf(ap, authz) {
+					ret.Authorization = authz
+				}
+			} else if !apierrors.IsNotFound(err) {
+				return err
+			}
+			ef := &networkingpkgv1alpha3.EnvoyFilter{}
+			if err := tc.Get(ctx, client.ObjectKey{Namespace: env.Namespace, Name: name + localRateLimitSuffix}, ef); err == nil {
+				rl := &LocalRateLimit{}
+				if meshPolicyAnnotationOf(ef, rl) {
+					ret.RateLimit = rl
+				}
+			} else if !apierrors.IsNotFound(err) {
+				return err
+			}
+			return nil
+		})
+		return ret, err
+	})
+}
+
+// ServiceCircuitBreaking service熔断
+// @Tags        VirtualSpace
+// @Summary     service熔断
+// @Description 设置 DestinationRule 的连接池与异常实例驱逐, 所有字段为 0 时取消熔断
+// @Accept      json
+// @Produce     json
+// @Param       virtualspace_id path     uint                                 true "virtualspace_id"
+// @Param       environment_id  path     uint                                 true "environment_id"
+// @Param       service_name    path     string                               true "service_name"
+// @Param       param           body     CircuitBreaking                      true "熔断配置"
+// @Success     200             {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/virtualspace/{virtualspace_id}/environment/{environment_id}/service/{service_name}/circuit_breaking [post]
+// @Security    JWT
+func (h *VirtualSpaceHandler) ServiceCircuitBreaking(c *gin.Context) {
+	req := CircuitBreaking{}
+	h.servicePolicyProcess(c, &req, i18n.Sprintf(c, "circuit breaking"), func(ctx context.Context, tc agents.Client, svc *v1.Service) error {
+		dr := &networkingpkgv1alpha3.DestinationRule{
+			ObjectMeta: metav1.ObjectMeta{Name: svc.Name, Namespace: svc.Namespace},
+		}
+		_, err := controllerutil.CreateOrUpdate(ctx, tc, dr, func() error {
+			if dr.Spec.Host == "" {
+				dr.Spec.Host = constructHostFQDN(svc.Namespace, svc.Name)
+			}
+			tp, err := applyCircuitBreaking(dr.Spec.TrafficPolicy, req)
+			if err != nil {
+				return err
+			}
+			dr.Spec.TrafficPolicy = tp
+			return nil
+		})
+		return err
+	})
+}
+
+// ServiceRetries service重试
+// @Tags        VirtualSpace
+// @Summary     service重试
+// @Description 为 VirtualService 的所有 http 路由设置重试策略, attempts 为 0 时使用 istio 默认策略
+// @Accept      json
+// @Produce     json
+// @Param       virtualspace_id path     uint                                 true "virtualspace_id"
+// @Param       environment_id  path     uint                                 true "environment_id"
+// @Param       service_name    path     string                               true "service_name"
+// @Param       param           body     RetryPolicy                          true "重试策略"
+// @Success     200             {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/virtualspace/{virtualspace_id}/environment/{environment_id}/service/{service_name}/retries [post]
+// @Security    JWT
+func (h *VirtualSpaceHandler) ServiceRetries(c *gin.Context) {
+	req := RetryPolicy{}
+	h.servicePolicyProcess(c, &req, i18n.Sprintf(c, "retry policy"), func(ctx context.Context, tc agents.Client, svc *v1.Service) error {
+		retry, err := req.toHTTPRetry()
+		if err != nil {
+			return err
+		}
+		vs := &networkingpkgv1alpha3.VirtualService{
+			ObjectMeta: metav1.ObjectMeta{Name: svc.Name, Namespace: svc.Namespace},
+		}
+		if retry == nil {
+			// 不存在时无需创建
+			if err := tc.Get(ctx, client.ObjectKeyFromObject(vs), vs); err != nil {
+				return client.IgnoreNotFound(err)
+			}
+		}
+		_, err = controllerutil.CreateOrUpdate(ctx, tc, vs, func() error {
+			applyRetries(vs, constructHostFQDN(svc.Namespace, svc.Name), retry)
+			if retry == nil {
+				removeMeshPolicyAnnotation(vs)
+				return nil
+			}
+			return setMeshPolicyAnnotation(vs, req)
+		})
+		return err
+	})
+}
+
+// ServiceAuthorizationPolicy service访问白名单
+// @Tags        VirtualSpace
+// @Summary     service访问白名单
+// @Description 生成 AuthorizationPolicy 仅允许指定的 namespace/serviceaccount 访问服务, sources 为空时删除白名单. 基于 serviceaccount 的规则需要开启 mTLS
+// @Accept      json
+// @Produce     json
+// @Param       virtualspace_id path     uint                                 true "virtualspace_id"
+// @Param       environment_id  path     uint                                 true "environment_id"
+// @Param       service_name    path     string                               true "service_name"
+// @Param       param           body     ServiceAuthorization                 true "访问白名单"
+// @Success     200             {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/virtualspace/{virtualspace_id}/environment/{environment_id}/service/{service_name}/authorization [post]
+// @Security    JWT
+func (h *VirtualSpaceHandler) ServiceAuthorizationPolicy(c *gin.Context) {
+	req := ServiceAuthorization{}
+	h.servicePolicyProcess(c, &req, i18n.Sprintf(c, "authorization policy"), func(ctx context.Context, tc agents.Client, svc *v1.Service) error {
+		ap := &securitypkgv1beta1.AuthorizationPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: authorizationPolicyName(svc.Name), Namespace: svc.Namespace},
+		}
+		if len(req.Sources) == 0 {
+			if err := tc.Get(ctx, client.ObjectKeyFromObject(ap), ap); err != nil {
+				return client.IgnoreNotFound(err)
+			}
+			if !isManagedByKubegems(ap) {
+				return i18n.Errorf(ctx, "authorization policy %s is not managed by kubegems", ap.Name)
+			}
+			return tc.Delete(ctx, ap)
+		}
+		_, err := controllerutil.CreateOrUpdate(ctx, tc, ap, func() error {
+			if !isManagedByKubegems(ap) {
+				return i18n.Errorf(ctx, "authorization policy %s is not managed by kubegems", ap.Name)
+			}
+			setManagedByKubegems(ap)
+			ap.Spec = authorizationPolicySpec(svc.Spec.Selector, req)
+			return setMeshPolicyAnnotation(ap, req)
+		})
+		return err
+	})
+}
+
+// ServiceRateLimit service限流
+// @Tags        VirtualSpace
+// @Summary     service限流
+// @Description 通过 EnvoyFilter 为服务的 sidecar 配置本地令牌桶限流, maxTokens 为 0 时取消限流
+// @Accept      json
+// @Produce     json
+// @Param       virtualspace_id path     uint                                 true "virtualspace_id"
+// @Param       environment_id  path     uint                                 true "environment_id"
+// @Param       service_name    path     string                               true "service_name"
+// @Param       param           body     LocalRateLimit                       true "限流配置"
+// @Success     200             {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/virtualspace/{virtualspace_id}/environment/{environment_id}/service/{service_name}/rate_limit [post]
+// @Security    JWT
+func (h *VirtualSpaceHandler) ServiceRateLimit(c *gin.Context) {
+	req := LocalRateLimit{}
+	h.servicePolicyProcess(c, &req, i18n.Sprintf(c, "rate limit"), func(ctx context.Context, tc agents.Client, svc *v1.Service) error {
+		ef := &networkingpkgv1alpha3.EnvoyFilter{
+			ObjectMeta: metav1.ObjectMeta{Name: svc.Name + localRateLimitSuffix, Namespace: svc.Namespace},
+		}
+		if req.MaxTokens == 0 {
+			return deleteIgnoreNotFound(ctx, tc, ef)
+		}
+		spec, err := localRateLimitSpec(svc.Spec.Selector, req)
+		if err != nil {
+			return err
+		}
+		_, err = controllerutil.CreateOrUpdate(ctx, tc, ef, func() error {
+			ef.Spec = spec
+			return setMeshPolicyAnnotation(ef, req)
+		})
+		return err
+	})
+}
+
+// GetEnvironmentMTLS 获取环境的mTLS模式
+// @Tags        VirtualSpace
+// @Summary     获取环境的mTLS模式
+// @Description 获取环境 namespace 级别 PeerAuthentication 的 mTLS 模式, 为空表示继承网格配置
+// @Accept      json
+// @Produce     json
+// @Param       virtualspace_id path     uint                                          true "virtualspace_id"
+// @Param       environment_id  path     uint                                          true "environment_id"
+// @Success     200             {object} handlers.ResponseStruct{Data=EnvironmentMTLS} "resp"
+// @Router      /v1/virtualspace/{virtualspace_id}/environment/{environment_id}/mtls [get]
+// @Security    JWT
+func (h *VirtualSpaceHandler) GetEnvironmentMTLS(c *gin.Context) {
+	h.environmentProcess(c, nil, func(ctx context.Context, env models.Environment) (interface{}, error) {
+		ret := EnvironmentMTLS{}
+		err := h.Execute(ctx, env.Cluster.ClusterName, func(ctx context.Context, tc agents.Client) error {
+			pa := &securitypkgv1beta1.PeerAuthentication{}
+			if err := tc.Get(ctx, client.ObjectKey{Namespace: env.Namespace, Name: defaultPeerAuthName}, pa); err != nil {
+				return client.IgnoreNotFound(err)
+			}
+			if mode := pa.Spec.GetMtls().GetMode(); mode != securityv1beta1.PeerAuthentication_MutualTLS_UNSET {
+				ret.Mode = mode.String()
+			}
+			return nil
+		})
+		return ret, err
+	})
+}
+
+// SetEnvironmentMTLS 设置环境的mTLS模式
+// @Tags        VirtualSpace
+// @Summary     设置环境的mTLS模式
+// @Description 设置环境 namespace 级别 PeerAuthentication 的 mTLS 模式, mode 为空时删除 PeerAuthentication
+// @Accept      json
+// @Produce     json
+// @Param       virtualspace_id path     uint                                 true "virtualspace_id"
+// @Param       environment_id  path     uint                                 true "environment_id"
+// @Param       param           body     EnvironmentMTLS                      true "mTLS模式"
+// @Success     200             {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/virtualspace/{virtualspace_id}/environment/{environment_id}/mtls [put]
+// @Security    JWT
+func (h *VirtualSpaceHandler) SetEnvironmentMTLS(c *gin.Context) {
+	req := EnvironmentMTLS{}
+	h.environmentProcess(c, &req, func(ctx context.Context, env models.Environment) (interface{}, error) {
+		h.SetExtraAuditDataByClusterNamespace(c, env.Cluster.ClusterName, env.Namespace)
+		h.SetAuditData(c, i18n.Sprintf(c, "update"), "mTLS", env.EnvironmentName)
+		return "ok", h.Execute(ctx, env.Cluster.ClusterName, func(ctx context.Context, tc agents.Client) error {
+			pa := &securitypkgv1beta1.PeerAuthentication{
+				ObjectMeta: metav1.ObjectMeta{Name: defaultPeerAuthName, Namespace: env.Namespace},
+			}
+			if req.Mode == "" {
+				if err := tc.Get(ctx, client.ObjectKeyFromObject(pa), pa); err != nil {
+					return client.IgnoreNotFound(err)
+				}
+				if !isManagedByKubegems(pa) {
+					return i18n.Errorf(ctx, "peer authentication %s is not managed by kubegems", pa.Name)
+				}
+				return tc.Delete(ctx, pa)
+			}
+			mode, err := parseMTLSMode(req.Mode)
+			if err != nil {
+				return err
+			}
+			_, err = controllerutil.CreateOrUpdate(ctx, tc, pa, func() error {
+				if !isManagedByKubegems(pa) {
+					return i18n.Errorf(ctx, "peer authentication %s is not managed by kubegems", pa.Name)
+				}
+				setManagedByKubegems(pa)
+				pa.Spec.Mtls = &securityv1beta1.PeerAuthentication_MutualTLS{Mode: mode}
+				return nil
+			})
+			return err
+		})
+	})
+}
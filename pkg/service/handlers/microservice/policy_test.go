@@ -0,0 +1,194 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package microservice
+
+import (
+	"testing"
+
+	networkingv1alpha3 "istio.io/api/networking/v1alpha3"
+	securityv1beta1 "istio.io/api/security/v1beta1"
+	networkingpkgv1alpha3 "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	securitypkgv1beta1 "istio.io/client-go/pkg/apis/security/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_applyCircuitBreaking(t *testing.T) {
+	tests := []struct {
+		name    string
+		exist   *networkingv1alpha3.TrafficPolicy
+		cb      CircuitBreaking
+		want    *CircuitBreaking
+		wantErr bool
+	}{
+		{
+			name: "full",
+			cb: CircuitBreaking{
+				MaxConnections: 100, HTTP1MaxPendingRequests: 10, HTTP2MaxRequests: 200, MaxRetries: 3,
+				Consecutive5xxErrors: 5, Interval: "10s", BaseEjectionTime: "30s", MaxEjectionPercent: 50,
+			},
+			want: &CircuitBreaking{
+				MaxConnections: 100, HTTP1MaxPendingRequests: 10, HTTP2MaxRequests: 200, MaxRetries: 3,
+				Consecutive5xxErrors: 5, Interval: "10s", BaseEjectionTime: "30s", MaxEjectionPercent: 50,
+			},
+		},
+		{
+			name:  "clear",
+			exist: &networkingv1alpha3.TrafficPolicy{ConnectionPool: &networkingv1alpha3.ConnectionPoolSettings{}},
+			cb:    CircuitBreaking{},
+			want:  nil,
+		},
+		{name: "invalid interval", cb: CircuitBreaking{Consecutive5xxErrors: 1, Interval: "10"}, wantErr: true},
+		{name: "invalid percent", cb: CircuitBreaking{Consecutive5xxErrors: 1, MaxEjectionPercent: 120}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tp, err := applyCircuitBreaking(tt.exist, tt.cb)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyCircuitBreaking() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := circuitBreakingOf(tp)
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("circuitBreakingOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_applyRetries(t *testing.T) {
+	host := "svc.default.svc.cluster.local"
+	vs := &networkingpkgv1alpha3.VirtualService{}
+	retry, err := RetryPolicy{Attempts: 3, PerTryTimeout: "2s", RetryOn: "5xx"}.toHTTPRetry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyRetries(vs, host, retry)
+	if len(vs.Spec.Hosts) != 1 || len(vs.Spec.Http) != 1 || vs.Spec.Http[0].Route[0].Destination.Host != host {
+		t.Fatalf("unexpected virtualservice spec: %v", vs.Spec.String())
+	}
+	if got := retriesOf(vs); got == nil || *got != (RetryPolicy{Attempts: 3, PerTryTimeout: "2s", RetryOn: "5xx"}) {
+		t.Errorf("retriesOf() = %v", got)
+	}
+
+	applyRetries(vs, host, nil)
+	if got := retriesOf(vs); got != nil {
+		t.Errorf("retriesOf() after reset = %v, want nil", got)
+	}
+	if _, err := (RetryPolicy{Attempts: -1}).toHTTPRetry(); err == nil {
+		t.Errorf("toHTTPRetry() with negative attempts should fail")
+	}
+}
+
+func Test_reapplyRetries(t *testing.T) {
+	policy := RetryPolicy{Attempts: 3, PerTryTimeout: "2s", RetryOn: "5xx"}
+	vs := &networkingpkgv1alpha3.VirtualService{}
+	if err := setMeshPolicyAnnotation(vs, policy); err != nil {
+		t.Fatal(err)
+	}
+	explicit := &networkingv1alpha3.HTTPRetry{Attempts: 1}
+	vs.Spec.Http = []*networkingv1alpha3.HTTPRoute{{}, {Retries: explicit}}
+	if err := reapplyRetries(vs, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := retriesOf(vs); got == nil || *got != policy {
+		t.Errorf("retriesOf() = %v, want %v", got, policy)
+	}
+	if vs.Spec.Http[1].Retries != explicit {
+		t.Errorf("explicit route retries should be kept, got %v", vs.Spec.Http[1].Retries)
+	}
+
+	// 没有注解时使用重建前的重试策略
+	vs = &networkingpkgv1alpha3.VirtualService{}
+	vs.Spec.Http = []*networkingv1alpha3.HTTPRoute{{}}
+	if err := reapplyRetries(vs, &policy); err != nil {
+		t.Fatal(err)
+	}
+	if got := retriesOf(vs); got == nil || *got != policy {
+		t.Errorf("retriesOf() = %v, want %v", got, policy)
+	}
+}
+
+func Test_isManagedByKubegems(t *testing.T) {
+	ap := &securitypkgv1beta1.AuthorizationPolicy{}
+	if !isManagedByKubegems(ap) {
+		t.Errorf("new object should be managed")
+	}
+	ap.CreationTimestamp = metav1.Now()
+	if isManagedByKubegems(ap) {
+		t.Errorf("existing object without label should not be managed")
+	}
+	setManagedByKubegems(ap)
+	if !isManagedByKubegems(ap) {
+		t.Errorf("existing object with label should be managed")
+	}
+}
+
+func Test_authorizationPolicySpec(t *testing.T) {
+	spec := authorizationPolicySpec(map[string]string{"app": "reviews"}, ServiceAuthorization{
+		Sources: []AuthorizationSource{
+			{Namespace: "shop", ServiceAccount: "productpage", Methods: []string{"GET"}},
+			{Namespace: "ops"},
+		},
+	})
+	if spec.Action != securityv1beta1.AuthorizationPolicy_ALLOW || spec.Selector.MatchLabels["app"] != "reviews" {
+		t.Fatalf("unexpected policy: %v", spec.String())
+	}
+	if len(spec.Rules) != 2 {
+		t.Fatalf("rules = %d, want 2", len(spec.Rules))
+	}
+	if got := spec.Rules[0].From[0].Source.Principals; len(got) != 1 || got[0] != "*/ns/shop/sa/productpage" {
+		t.Errorf("principals = %v", got)
+	}
+	if got := spec.Rules[0].To[0].Operation.Methods; len(got) != 1 || got[0] != "GET" {
+		t.Errorf("methods = %v", got)
+	}
+	if got := spec.Rules[1].From[0].Source.Namespaces; len(got) != 1 || got[0] != "ops" || spec.Rules[1].To != nil {
+		t.Errorf("unexpected namespace rule: %v", spec.Rules[1].String())
+	}
+}
+
+func Test_localRateLimitSpec(t *testing.T) {
+	spec, err := localRateLimitSpec(map[string]string{"app": "reviews"}, LocalRateLimit{MaxTokens: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch := spec.ConfigPatches[0]
+	if patch.ApplyTo != networkingv1alpha3.EnvoyFilter_HTTP_FILTER || patch.Match.Context != networkingv1alpha3.EnvoyFilter_SIDECAR_INBOUND {
+		t.Fatalf("unexpected patch: %v", patch.String())
+	}
+	bucket := patch.Patch.Value.Fields["typed_config"].GetStructValue().
+		Fields["value"].GetStructValue().
+		Fields["token_bucket"].GetStructValue()
+	if got := bucket.Fields["tokens_per_fill"].GetNumberValue(); got != 10 {
+		t.Errorf("tokens_per_fill = %v, want 10", got)
+	}
+	if got := bucket.Fields["fill_interval"].GetStringValue(); got != "1.000s" {
+		t.Errorf("fill_interval = %v, want 1.000s", got)
+	}
+	if _, err := localRateLimitSpec(nil, LocalRateLimit{MaxTokens: 10, FillInterval: "1ms"}); err == nil {
+		t.Errorf("localRateLimitSpec() with too short interval should fail")
+	}
+}
+
+func Test_parseMTLSMode(t *testing.T) {
+	if mode, err := parseMTLSMode("strict"); err != nil || mode != securityv1beta1.PeerAuthentication_MutualTLS_STRICT {
+		t.Errorf("parseMTLSMode(strict) = %v, %v", mode, err)
+	}
+	if _, err := parseMTLSMode("foo"); err == nil {
+		t.Errorf("parseMTLSMode(foo) should fail")
+	}
+}
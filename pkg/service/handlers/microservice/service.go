@@ -438,6 +438,8 @@ func updateOrCreateDrAndVs(
 		}
 		dr.Spec = networkingv1alpha3.DestinationRule{
 			Host: constructHostFQDN(svcDetail.Service.Namespace.Name, svcDetail.Service.Name),
+			// 保留熔断等流量策略
+			TrafficPolicy: dr.Spec.TrafficPolicy,
 		}
 		conf := config.Get()
 		for _, w := range svcDetail.Workloads {
@@ -465,12 +467,17 @@ func updateOrCreateDrAndVs(
 		vs.ObjectMeta.Labels = map[string]string{
 			"kiali_wizard": kiali_wizard_value,
 		}
+		// 保留重试策略
+		previousRetries := retriesOf(vs)
 		vs.Spec = networkingv1alpha3.VirtualService{
 			Hosts: []string{
 				constructHostFQDN(svcDetail.Service.Namespace.Name, svcDetail.Service.Name),
 			},
 		}
 		mutateVirtualService(vs)
+		if err := reapplyRetries(vs, previousRetries); err != nil {
+			return err
+		}
 		for _, v := range vs.Spec.Http {
 			for _, r := range v.Route {
 				if err := checkFQDN(r.Destination.GetHost()); err != nil {
@@ -16,14 +16,20 @@ package microservice
 
 import (
 	"context"
+	"reflect"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"kubegems.io/kubegems/pkg/apis/networking"
 	"kubegems.io/kubegems/pkg/i18n"
 	"kubegems.io/kubegems/pkg/service/handlers"
 	"kubegems.io/kubegems/pkg/service/handlers/base"
 	microservice "kubegems.io/kubegems/pkg/service/handlers/microservice/options"
 	"kubegems.io/kubegems/pkg/service/models"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 type VirtualDomainHandler struct {
@@ -189,13 +195,125 @@ func (h *VirtualDomainHandler) DeleteVirtualDomain(c *gin.Context) {
 	handlers.OK(c, "")
 }
 
-// InjectVirtualDomain 为 service 设置 serviceentry
-// TODO:
-func (h *VirtualDomainHandler) InjectVirtualDomain(c *gin.Context) {}
+// InjectVirtualDomain 为虚拟空间下的 service 设置虚拟域名
+// @Tags        VirtualDomain
+// @Summary     为虚拟空间绑定虚拟域名
+// @Description 为虚拟空间下所有环境的 service 设置虚拟域名, 各集群 controller 会为 service 生成 {service}.{virtualdomain} 的 serviceentry 并同步同名 virtualservice 的 hosts
+// @Accept      json
+// @Produce     json
+// @Param       virtualdomain_id path     uint                                   true "virtualdomain_id"
+// @Param       virtualspace_id  query    uint                                   true "virtualspace_id"
+// @Success     200              {object} handlers.ResponseStruct{Data=[]string} "设置的 cluster/namespace"
+// @Router      /v1/virtualdomain/{virtualdomain_id}/actions/inject [put]
+// @Security    JWT
+func (h *VirtualDomainHandler) InjectVirtualDomain(c *gin.Context) {
+	h.bindVirtualDomain(c, true)
+}
+
+// UnInjectVirtualDomain 为虚拟空间下的 service 取消设置虚拟域名
+// @Tags        VirtualDomain
+// @Summary     为虚拟空间解绑虚拟域名
+// @Description 取消虚拟空间下所有环境的虚拟域名, 各集群 controller 会删除对应的 serviceentry 并还原 virtualservice 的 hosts
+// @Accept      json
+// @Produce     json
+// @Param       virtualdomain_id path     uint                                   true "virtualdomain_id"
+// @Param       virtualspace_id  query    uint                                   true "virtualspace_id"
+// @Success     200              {object} handlers.ResponseStruct{Data=[]string} "取消设置的 cluster/namespace"
+// @Router      /v1/virtualdomain/{virtualdomain_id}/actions/uninject [put]
+// @Security    JWT
+func (h *VirtualDomainHandler) UnInjectVirtualDomain(c *gin.Context) {
+	h.bindVirtualDomain(c, false)
+}
+
+func (h *VirtualDomainHandler) bindVirtualDomain(c *gin.Context, bind bool) {
+	vd := models.VirtualDomain{}
+	if err := h.GetDB().First(&vd, c.Param("virtualdomain_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if bind {
+		if !vd.IsActive {
+			handlers.NotOK(c, i18n.Errorf(c, "virtual domain %s is not active", vd.VirtualDomainName))
+			return
+		}
+		if errs := validation.IsDNS1123Subdomain(vd.VirtualDomainName); len(errs) > 0 {
+			handlers.NotOK(c, i18n.Errorf(c, "virtual domain %s is not a valid domain: %s", vd.VirtualDomainName, strings.Join(errs, ", ")))
+			return
+		}
+	}
+	vs := models.VirtualSpace{}
+	if err := h.GetDB().Preload("Environments.Cluster").First(&vs, c.Query("virtualspace_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	user, _ := h.GetContextUser(c)
+	auth := h.ModelCache().GetUserAuthority(user)
+	if !auth.IsSystemAdmin() && !auth.IsVirtualSpaceAdmin(vs.ID) {
+		handlers.Forbidden(c, i18n.Sprintf(c, "you have no permission to operate the virtual space %s", vs.VirtualSpaceName))
+		return
+	}
+
+	if bind {
+		h.SetAuditData(c, i18n.Sprintf(c, "bind"), i18n.Sprintf(c, "virtual domain"), vd.VirtualDomainName)
+	} else {
+		h.SetAuditData(c, i18n.Sprintf(c, "unbind"), i18n.Sprintf(c, "virtual domain"), vd.VirtualDomainName)
+	}
+	h.SetExtraAuditData(c, models.ResVirtualSpace, vs.ID)
+
+	ctx := c.Request.Context()
+	done := []string{}
+	for _, env := range vs.Environments {
+		if env.Cluster == nil {
+			continue
+		}
+		cli, err := h.GetAgents().ClientOf(ctx, env.Cluster.ClusterName)
+		if err != nil {
+			handlers.NotOK(c, err)
+			return
+		}
+		if err := ensureNamespaceVirtualDomain(ctx, cli, env.Namespace, vd.VirtualDomainName, bind); err != nil {
+			handlers.NotOK(c, err)
+			return
+		}
+		done = append(done, env.Cluster.ClusterName+"/"+env.Namespace)
+	}
+	// 后续的处理交由各集群controller处理
+	// controller 会为 namespace 下的 service 生成serviceentry 和 更改其virtualservice hosts
+	handlers.OK(c, done)
+}
 
-// UnInjectVirtualDomain 为 service 取消设置 serviceentry
-// TODO:
-func (h *VirtualDomainHandler) UnInjectVirtualDomain(c *gin.Context) {}
+// ensureNamespaceVirtualDomain 在 namespace 的虚拟域名注解中增加/删除域名, 注解值为逗号分隔的域名列表
+func ensureNamespaceVirtualDomain(ctx context.Context, cli client.Client, namespace, domain string, bind bool) error {
+	ns := &corev1.Namespace{}
+	if err := cli.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+		return err
+	}
+	domains := []string{}
+	if val := ns.Annotations[networking.AnnotationVirtualDomain]; val != "" {
+		domains = strings.Split(val, ",")
+	}
+	newdomains := []string{}
+	for _, d := range domains {
+		if d != domain {
+			newdomains = append(newdomains, d)
+		}
+	}
+	if bind {
+		newdomains = append(newdomains, domain)
+	}
+	if reflect.DeepEqual(domains, newdomains) {
+		return nil
+	}
+	if ns.Annotations == nil {
+		ns.Annotations = make(map[string]string)
+	}
+	if len(newdomains) == 0 {
+		delete(ns.Annotations, networking.AnnotationVirtualDomain)
+	} else {
+		ns.Annotations[networking.AnnotationVirtualDomain] = strings.Join(newdomains, ",")
+	}
+	return cli.Update(ctx, ns)
+}
 
 func (h *VirtualDomainHandler) RegistRouter(rg *gin.RouterGroup) {
 	rg.GET("/virtualdomain", h.ListVirtualDomain)
@@ -204,5 +322,5 @@ func (h *VirtualDomainHandler) RegistRouter(rg *gin.RouterGroup) {
 	rg.PUT("/virtualdomain/:virtualdomain_id", h.PutVirtualDomain)
 	rg.DELETE("/virtualdomain/:virtualdomain_id", h.DeleteVirtualDomain)
 	rg.PUT("/virtualdomain/:virtualdomain_id/actions/inject", h.InjectVirtualDomain)
-	rg.PUT("/virtualdomain/:virtualdomain_id/actions/uninject", h.InjectVirtualDomain)
+	rg.PUT("/virtualdomain/:virtualdomain_id/actions/uninject", h.UnInjectVirtualDomain)
 }
@@ -0,0 +1,61 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package microservice
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"kubegems.io/kubegems/pkg/apis/networking"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_ensureNamespaceVirtualDomain(t *testing.T) {
+	tests := []struct {
+		name   string
+		exist  map[string]string
+		domain string
+		bind   bool
+		want   string
+	}{
+		{name: "bind", exist: nil, domain: "shop.internal", bind: true, want: "shop.internal"},
+		{name: "bind another", exist: map[string]string{networking.AnnotationVirtualDomain: "a.internal"}, domain: "shop.internal", bind: true, want: "a.internal,shop.internal"},
+		{name: "bind again", exist: map[string]string{networking.AnnotationVirtualDomain: "shop.internal"}, domain: "shop.internal", bind: true, want: "shop.internal"},
+		{name: "unbind", exist: map[string]string{networking.AnnotationVirtualDomain: "a.internal,shop.internal"}, domain: "shop.internal", bind: false, want: "a.internal"},
+		{name: "unbind last", exist: map[string]string{networking.AnnotationVirtualDomain: "shop.internal"}, domain: "shop.internal", bind: false, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cli := fake.NewClientBuilder().WithObjects(&corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "orders", Annotations: tt.exist},
+			}).Build()
+			if err := ensureNamespaceVirtualDomain(ctx, cli, "orders", tt.domain, tt.bind); err != nil {
+				t.Fatal(err)
+			}
+			ns := &corev1.Namespace{}
+			if err := cli.Get(ctx, client.ObjectKey{Name: "orders"}, ns); err != nil {
+				t.Fatal(err)
+			}
+			got, ok := ns.Annotations[networking.AnnotationVirtualDomain]
+			if got != tt.want || (tt.want == "" && ok) {
+				t.Errorf("ensureNamespaceVirtualDomain() annotation = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1037,6 +1037,8 @@ func (h *VirtualSpaceHandler) RegistRouter(rg *gin.RouterGroup) {
 	rg.PUT("/virtualspace/:virtualspace_id/environment/:environment_id/workload/:name/istiosidecar", h.CheckByVirtualSpaceID, h.InjectIstioSidecar)
 	rg.PUT("/virtualspace/:virtualspace_id/environment/:environment_id/workload/:name/virtualdomain", h.CheckByVirtualSpaceID, h.InjectVirtualDomain)
 	rg.GET("/virtualspace/:virtualspace_id/environment/:environment_id/istioresources", h.CheckByVirtualSpaceID, h.ListIstioResources)
+	rg.GET("/virtualspace/:virtualspace_id/graph", h.CheckByVirtualSpaceID, h.ServiceGraph)
+	rg.GET("/virtualspace/:virtualspace_id/graph/signals", h.CheckByVirtualSpaceID, h.ServiceGoldenSignals)
 
 	// service
 	rg.GET("/virtualspace/:virtualspace_id/environment/:environment_id/service", h.CheckByVirtualSpaceID, h.ListServices)
@@ -1047,6 +1049,15 @@ func (h *VirtualSpaceHandler) RegistRouter(rg *gin.RouterGroup) {
 	rg.POST("/virtualspace/:virtualspace_id/environment/:environment_id/service/:service_name/tcp_traffic_shifting", h.CheckByVirtualSpaceID, h.ServiceTCPTrafficShifting)
 	rg.POST("/virtualspace/:virtualspace_id/environment/:environment_id/service/:service_name/request_timeouts", h.CheckByVirtualSpaceID, h.ServiceRequestTimeout)
 	rg.POST("/virtualspace/:virtualspace_id/environment/:environment_id/service/:service_name/reset", h.CheckByVirtualSpaceID, h.ServicetReset)
+
+	// mesh policies
+	rg.GET("/virtualspace/:virtualspace_id/environment/:environment_id/service/:service_name/policies", h.CheckByVirtualSpaceID, h.GetServiceMeshPolicies)
+	rg.POST("/virtualspace/:virtualspace_id/environment/:environment_id/service/:service_name/circuit_breaking", h.CheckByVirtualSpaceID, h.ServiceCircuitBreaking)
+	rg.POST("/virtualspace/:virtualspace_id/environment/:environment_id/service/:service_name/retries", h.CheckByVirtualSpaceID, h.ServiceRetries)
+	rg.POST("/virtualspace/:virtualspace_id/environment/:environment_id/service/:service_name/authorization", h.CheckByVirtualSpaceID, h.ServiceAuthorizationPolicy)
+	rg.POST("/virtualspace/:virtualspace_id/environment/:environment_id/service/:service_name/rate_limit", h.CheckByVirtualSpaceID, h.ServiceRateLimit)
+	rg.GET("/virtualspace/:virtualspace_id/environment/:environment_id/mtls", h.CheckByVirtualSpaceID, h.GetEnvironmentMTLS)
+	rg.PUT("/virtualspace/:virtualspace_id/environment/:environment_id/mtls", h.CheckByVirtualSpaceID, h.SetEnvironmentMTLS)
 	// kiali
 	rg.Any("/virtualspace/:virtualspace_id/environment/:environment_id/kiali/*path", h.CheckByVirtualSpaceID, h.KialiAPI)
 }
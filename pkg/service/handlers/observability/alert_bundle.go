@@ -0,0 +1,430 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observability
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/service/observe"
+	"kubegems.io/kubegems/pkg/utils/agents"
+	"sigs.k8s.io/yaml"
+)
+
+const maxAlertBundleSize = 10 << 20
+
+type AlertBundleApplyReq struct {
+	EnvironmentIDs []uint              `json:"environmentIDs" binding:"required"`
+	Strategy       string              `json:"strategy"` // skip, overwrite, fail, 默认 skip
+	DryRun         bool                `json:"dryRun"`
+	Bundle         observe.AlertBundle `json:"bundle"`
+}
+
+type AlertBundleApplyResult struct {
+	EnvironmentID   uint                      `json:"environmentID"`
+	EnvironmentName string                    `json:"environmentName"`
+	Items           []observe.AlertImportItem `json:"items"`
+	Error           string                    `json:"error,omitempty"`
+}
+
+// ExportEnvironmentAlerts 导出环境告警规则
+// @Tags        Observability
+// @Summary     导出环境告警规则
+// @Description 导出环境的所有监控与日志告警规则为可移植的 yaml, 告警渠道使用名称, namespace 使用 $namespace 占位
+// @Accept      json
+// @Produce     application/x-yaml
+// @Param       environment_id path     string              true "环境ID"
+// @Success     200            {object} observe.AlertBundle "bundle"
+// @Router      /v1/observability/environment/{environment_id}/alerts/export [get]
+// @Security    JWT
+func (h *ObservabilityHandler) ExportEnvironmentAlerts(c *gin.Context) {
+	env, err := h.getAlertBundleEnvironment(c.Param("environment_id"))
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	_, channelNames, err := h.alertBundleChannels(env.Project.TenantID)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	var bundle *observe.AlertBundle
+	if err := h.Execute(c.Request.Context(), env.Cluster.ClusterName, func(ctx context.Context, cli agents.Client) error {
+		bundle, err = h.currentAlertBundle(ctx, cli, env.Namespace, channelNames)
+		return err
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	writeAlertBundle(c, env.EnvironmentName, bundle.RemoveManaged())
+}
+
+// ExportTenantAlerts 导出租户告警规则
+// @Tags        Observability
+// @Summary     导出租户告警规则
+// @Description 导出租户下所有环境的监控与日志告警规则, 不同环境中重名的告警规则只保留一个
+// @Accept      json
+// @Produce     application/x-yaml
+// @Param       tenant_id path     uint                true "租户ID"
+// @Success     200       {object} observe.AlertBundle "bundle"
+// @Router      /v1/observability/tenant/{tenant_id}/alerts/export [get]
+// @Security    JWT
+func (h *ObservabilityHandler) ExportTenantAlerts(c *gin.Context) {
+	tenant := models.Tenant{}
+	if err := h.GetDB().First(&tenant, c.Param("tenant_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	envs, err := h.tenantEnvironments(tenant.ID, nil)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	_, channelNames, err := h.alertBundleChannels(tenant.ID)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	ret := &observe.AlertBundle{Version: observe.AlertBundleVersion}
+	for _, env := range envs {
+		if err := h.Execute(c.Request.Context(), env.Cluster.ClusterName, func(ctx context.Context, cli agents.Client) error {
+			bundle, err := h.currentAlertBundle(ctx, cli, env.Namespace, channelNames)
+			if err != nil {
+				return err
+			}
+			ret.Merge(bundle.RemoveManaged())
+			return nil
+		}); err != nil {
+			handlers.NotOK(c, errors.Wrapf(err, "environment %s", env.EnvironmentName))
+			return
+		}
+	}
+	writeAlertBundle(c, tenant.TenantName, ret)
+}
+
+// ImportEnvironmentAlerts 导入环境告警规则
+// @Tags        Observability
+// @Summary     导入环境告警规则
+// @Description 导入 yaml 告警规则包, strategy 指定与已有告警规则重名时的处理方式, dryRun 时仅返回导入计划与差异
+// @Accept      application/x-yaml
+// @Produce     json
+// @Param       environment_id path     string                                                  true  "环境ID"
+// @Param       strategy       query    string                                                  false "skip(默认), overwrite, fail"
+// @Param       dryRun         query    bool                                                    false "是否只演练"
+// @Param       form           body     observe.AlertBundle                                     true  "告警规则包"
+// @Success     200            {object} handlers.ResponseStruct{Data=[]observe.AlertImportItem} "resp"
+// @Router      /v1/observability/environment/{environment_id}/alerts/import [post]
+// @Security    JWT
+func (h *ObservabilityHandler) ImportEnvironmentAlerts(c *gin.Context) {
+	env, err := h.getAlertBundleEnvironment(c.Param("environment_id"))
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxAlertBundleSize))
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	bundle := &observe.AlertBundle{}
+	if err := yaml.Unmarshal(body, bundle); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if err := bundle.Validate(); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	dryRun, _ := strconv.ParseBool(c.Query("dryRun"))
+	channels, channelNames, err := h.alertBundleChannels(env.Project.TenantID)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if !dryRun {
+		h.SetExtraAuditDataByClusterNamespace(c, env.Cluster.ClusterName, env.Namespace)
+		h.SetAuditData(c, i18n.Sprintf(c, "import"), i18n.Sprintf(c, "alert rule"), env.EnvironmentName)
+	}
+
+	h.m.Lock()
+	defer h.m.Unlock()
+	items, err := h.importAlertBundle(c.Request.Context(), env, bundle, c.Query("strategy"), dryRun, channels, channelNames)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, items)
+}
+
+// ApplyTenantAlerts 批量应用告警规则
+// @Tags        Observability
+// @Summary     批量应用告警规则
+// @Description 将告警规则包应用到租户下的多个环境, 单个环境失败不影响其他环境
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id path     uint                                                       true "租户ID"
+// @Param       form      body     AlertBundleApplyReq                                        true "body"
+// @Success     200       {object} handlers.ResponseStruct{Data=[]AlertBundleApplyResult} "resp"
+// @Router      /v1/observability/tenant/{tenant_id}/alerts/apply [post]
+// @Security    JWT
+func (h *ObservabilityHandler) ApplyTenantAlerts(c *gin.Context) {
+	req := AlertBundleApplyReq{}
+	if err := c.BindJSON(&req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if err := req.Bundle.Validate(); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	tenant := models.Tenant{}
+	if err := h.GetDB().First(&tenant, c.Param("tenant_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	envs, err := h.tenantEnvironments(tenant.ID, req.EnvironmentIDs)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if len(envs) != len(req.EnvironmentIDs) {
+		handlers.NotOK(c, i18n.Errorf(c, "some environments not found in tenant %s", tenant.TenantName))
+		return
+	}
+	channels, channelNames, err := h.alertBundleChannels(tenant.ID)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if !req.DryRun {
+		h.SetExtraAuditData(c, models.ResTenant, tenant.ID)
+		h.SetAuditData(c, i18n.Sprintf(c, "apply"), i18n.Sprintf(c, "alert rule"), fmt.Sprintf("%d environments", len(envs)))
+	}
+
+	h.m.Lock()
+	defer h.m.Unlock()
+	ret := []AlertBundleApplyResult{}
+	for i := range envs {
+		env := &envs[i]
+		result := AlertBundleApplyResult{EnvironmentID: env.ID, EnvironmentName: env.EnvironmentName}
+		result.Items, err = h.importAlertBundle(c.Request.Context(), env, &req.Bundle, req.Strategy, req.DryRun, channels, channelNames)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		ret = append(ret, result)
+	}
+	handlers.OK(c, ret)
+}
+
+func writeAlertBundle(c *gin.Context, name string, bundle *observe.AlertBundle) {
+	bts, err := yaml.Marshal(bundle)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"-alerts.yaml"))
+	c.Data(200, "application/x-yaml", bts)
+}
+
+func (h *ObservabilityHandler) getAlertBundleEnvironment(id string) (*models.Environment, error) {
+	env := &models.Environment{}
+	if err := h.GetDB().Preload("Cluster").Preload("Project").First(env, id).Error; err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// tenantEnvironments 租户下的环境, ids 为空时返回所有环境
+func (h *ObservabilityHandler) tenantEnvironments(tenantID uint, ids []uint) ([]models.Environment, error) {
+	envs := []models.Environment{}
+	query := h.GetDB().Preload("Cluster").Preload("Project").
+		Where("project_id in (?)", h.GetDB().Model(&models.Project{}).Select("id").Where("tenant_id = ?", tenantID))
+	if ids != nil {
+		query = query.Where("id in (?)", ids)
+	}
+	if err := query.Find(&envs).Error; err != nil {
+		return nil, err
+	}
+	return envs, nil
+}
+
+// alertBundleChannels 租户可用的告警渠道, 与系统预置渠道重名时优先使用租户的渠道
+func (h *ObservabilityHandler) alertBundleChannels(tenantID uint) (map[string]*models.AlertChannel, map[uint]string, error) {
+	channels := []*models.AlertChannel{}
+	if err := h.GetDB().Order("tenant_id").Find(&channels, "tenant_id = ? or tenant_id is null", tenantID).Error; err != nil {
+		return nil, nil, err
+	}
+	byName := map[string]*models.AlertChannel{}
+	names := map[uint]string{}
+	for _, ch := range channels {
+		names[ch.ID] = ch.Name
+		if exist, ok := byName[ch.Name]; ok && exist.TenantID != nil {
+			continue
+		}
+		byName[ch.Name] = ch
+	}
+	return byName, names, nil
+}
+
+func (h *ObservabilityHandler) currentAlertBundle(ctx context.Context, cli agents.Client, namespace string, channelNames map[uint]string) (*observe.AlertBundle, error) {
+	observecli := observe.NewClient(cli, h.GetDB())
+	monitorAlerts, err := observecli.ListMonitorAlertRules(ctx, namespace, false, h.GetDataBase().NewPromqlTplMapperFromDB().FindPromqlTpl)
+	if err != nil {
+		return nil, err
+	}
+	loggingAlerts, err := observecli.ListLoggingAlertRules(ctx, namespace, false)
+	if err != nil && !kerrors.IsNotFound(err) {
+		// 未开启日志的集群没有日志告警规则
+		return nil, err
+	}
+	return observe.NewAlertBundle(monitorAlerts, loggingAlerts, channelNames), nil
+}
+
+// importAlertBundle 导入告警规则包到环境, dryRun 时只校验并返回导入计划
+func (h *ObservabilityHandler) importAlertBundle(ctx context.Context, env *models.Environment, bundle *observe.AlertBundle,
+	strategy string, dryRun bool, channels map[string]*models.AlertChannel, channelNames map[uint]string,
+) ([]observe.AlertImportItem, error) {
+	namespace := env.Namespace
+	// 表达式无法移植的告警规则不导入, 只在导入计划中报告
+	portable := *bundle
+	unported := portable.RemoveUnported()
+	// 转换并校验, 演练时同样需要校验
+	monitorRules := []observe.MonitorAlertRule{}
+	for _, r := range portable.MonitorAlerts {
+		rule, err := r.ToMonitorAlertRule(namespace, channels)
+		if err != nil {
+			return nil, err
+		}
+		if err := observe.MutateMonitorAlert(&rule, h.GetDataBase().FindPromqlTpl); err != nil {
+			return nil, errors.Wrapf(err, "alert rule %s", r.Name)
+		}
+		monitorRules = append(monitorRules, rule)
+	}
+	loggingRules := []observe.LoggingAlertRule{}
+	for _, r := range portable.LoggingAlerts {
+		rule, err := r.ToLoggingAlertRule(namespace, channels)
+		if err != nil {
+			return nil, err
+		}
+		if err := observe.MutateLoggingAlert(&rule); err != nil {
+			return nil, errors.Wrapf(err, "alert rule %s", r.Name)
+		}
+		loggingRules = append(loggingRules, rule)
+	}
+
+	var items []observe.AlertImportItem
+	err := h.Execute(ctx, env.Cluster.ClusterName, func(ctx context.Context, cli agents.Client) error {
+		current, err := h.currentAlertBundle(ctx, cli, namespace, channelNames)
+		if err != nil {
+			return err
+		}
+		if items, err = observe.PlanAlertImport(current, monitorRules, loggingRules, channelNames, strategy); err != nil {
+			return err
+		}
+		items = append(items, unported...)
+		if dryRun {
+			return nil
+		}
+		actions := map[string]observe.Action{}
+		for _, item := range items {
+			switch item.Action {
+			case observe.AlertImportCreate:
+				actions[item.Name] = observe.Add
+			case observe.AlertImportUpdate:
+				actions[item.Name] = observe.Update
+			}
+		}
+
+		observecli := observe.NewClient(cli, h.GetDB())
+		applied := []observe.BaseAlertRule{}
+		// 监控告警规则按 PrometheusRule 分组提交
+		sources := map[string][]observe.MonitorAlertRule{}
+		for _, rule := range monitorRules {
+			if _, ok := actions[rule.Name]; ok {
+				sources[rule.Source] = append(sources[rule.Source], rule)
+			}
+		}
+		for source, rules := range sources {
+			raw, err := observecli.GetRawMonitorAlertResource(ctx, namespace, source, h.GetDataBase().NewPromqlTplMapperFromDB().FindPromqlTpl)
+			if err != nil {
+				return err
+			}
+			receivers := []observe.AlertReceiver{}
+			for _, rule := range rules {
+				if err := raw.ModifyAlertRule(rule, actions[rule.Name]); err != nil {
+					return err
+				}
+				receivers = append(receivers, rule.Receivers...)
+				applied = append(applied, rule.BaseAlertRule)
+			}
+			if err := observecli.CreateOrUpdateAlertEmailSecret(ctx, namespace, receivers); err != nil {
+				return err
+			}
+			if err := observecli.CommitRawMonitorAlertResource(ctx, raw); err != nil {
+				return err
+			}
+		}
+
+		changedLogging := []observe.LoggingAlertRule{}
+		for _, rule := range loggingRules {
+			if _, ok := actions[rule.Name]; ok {
+				changedLogging = append(changedLogging, rule)
+			}
+		}
+		if len(changedLogging) > 0 {
+			// 监控告警规则可能已修改同一个 AlertmanagerConfig, 需要重新获取
+			raw, err := observecli.GetRawLoggingAlertResource(ctx, namespace)
+			if err != nil {
+				return err
+			}
+			receivers := []observe.AlertReceiver{}
+			for _, rule := range changedLogging {
+				if err := raw.ModifyLoggingAlertRule(rule, actions[rule.Name]); err != nil {
+					return err
+				}
+				receivers = append(receivers, rule.Receivers...)
+				applied = append(applied, rule.BaseAlertRule)
+			}
+			if err := observecli.CreateOrUpdateAlertEmailSecret(ctx, namespace, receivers); err != nil {
+				return err
+			}
+			if err := observecli.CommitRawLoggingAlertResource(ctx, raw); err != nil {
+				return err
+			}
+		}
+
+		// 启用状态
+		for _, rule := range applied {
+			if rule.IsOpen {
+				err = deleteSilenceIfExist(ctx, namespace, rule.Name, cli)
+			} else {
+				err = createSilenceIfNotExist(ctx, namespace, rule.Name, cli)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return items, err
+}
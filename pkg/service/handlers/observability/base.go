@@ -59,6 +59,25 @@ func (h *ObservabilityHandler) RegistRouter(rg *gin.RouterGroup) {
 	rg.PUT("/observability/cluster/:cluster/namespaces/:namespace/logging/alerts/:name", h.CheckByClusterNamespace, h.UpdateLoggingAlertRule)
 	rg.DELETE("/observability/cluster/:cluster/namespaces/:namespace/logging/alerts/:name", h.CheckByClusterNamespace, h.DeleteLoggingAlertRule)
 
+	rg.GET("/observability/cluster/:cluster/namespaces/:namespace/logging/pipelines", h.CheckByClusterNamespace, h.ListLogPipeline)
+	rg.GET("/observability/cluster/:cluster/namespaces/:namespace/logging/pipelines/:name", h.CheckByClusterNamespace, h.GetLogPipeline)
+	rg.POST("/observability/cluster/:cluster/namespaces/:namespace/logging/pipelines", h.CheckByClusterNamespace, h.CreateLogPipeline)
+	rg.PUT("/observability/cluster/:cluster/namespaces/:namespace/logging/pipelines/:name", h.CheckByClusterNamespace, h.UpdateLogPipeline)
+	rg.DELETE("/observability/cluster/:cluster/namespaces/:namespace/logging/pipelines/:name", h.CheckByClusterNamespace, h.DeleteLogPipeline)
+	rg.POST("/observability/cluster/:cluster/namespaces/:namespace/logging/pipelines/actions/test", h.CheckByClusterNamespace, h.TestLogPipeline)
+
+	rg.GET("/observability/cluster/:cluster/namespaces/:namespace/logging/metrics", h.CheckByClusterNamespace, h.ListLoggingMetric)
+	rg.POST("/observability/cluster/:cluster/namespaces/:namespace/logging/metrics", h.CheckByClusterNamespace, h.CreateLoggingMetric)
+	rg.PUT("/observability/cluster/:cluster/namespaces/:namespace/logging/metrics/:name", h.CheckByClusterNamespace, h.UpdateLoggingMetric)
+	rg.DELETE("/observability/cluster/:cluster/namespaces/:namespace/logging/metrics/:name", h.CheckByClusterNamespace, h.DeleteLoggingMetric)
+
+	rg.GET("/observability/cluster/:cluster/namespaces/:namespace/logging/reports", h.CheckByClusterNamespace, h.ListLogReport)
+	rg.GET("/observability/cluster/:cluster/namespaces/:namespace/logging/reports/:report_id", h.CheckByClusterNamespace, h.GetLogReport)
+	rg.POST("/observability/cluster/:cluster/namespaces/:namespace/logging/reports", h.CheckByClusterNamespace, h.CreateLogReport)
+	rg.PUT("/observability/cluster/:cluster/namespaces/:namespace/logging/reports/:report_id", h.CheckByClusterNamespace, h.UpdateLogReport)
+	rg.DELETE("/observability/cluster/:cluster/namespaces/:namespace/logging/reports/:report_id", h.CheckByClusterNamespace, h.DeleteLogReport)
+	rg.POST("/observability/cluster/:cluster/namespaces/:namespace/logging/reports/:report_id/preview", h.CheckByClusterNamespace, h.PreviewLogReport)
+
 	rg.POST("/observability/cluster/:cluster/namespaces/:namespace/alerts/:name/actions/enable", h.CheckByClusterNamespace, h.EnableAlertRule)
 	rg.POST("/observability/cluster/:cluster/namespaces/:namespace/alerts/:name/actions/disable", h.CheckByClusterNamespace, h.DisableAlertRule)
 	rg.GET("/observability/cluster/:cluster/namespaces/:namespace/alerts/:name/history", h.CheckByClusterNamespace, h.AlertHistory)
@@ -68,6 +87,10 @@ func (h *ObservabilityHandler) RegistRouter(rg *gin.RouterGroup) {
 	rg.GET("/observability/tenant/:tenant_id/alerts/graph", h.CheckByTenantID, h.AlertGraph)
 	rg.GET("/observability/tenant/:tenant_id/alerts/group", h.CheckByTenantID, h.AlertByGroup)
 	rg.GET("/observability/tenant/:tenant_id/alerts/search", h.CheckByTenantID, h.SearchAlert)
+	rg.GET("/observability/tenant/:tenant_id/alerts/export", h.CheckByTenantID, h.ExportTenantAlerts)
+	rg.POST("/observability/tenant/:tenant_id/alerts/apply", h.CheckByTenantID, h.ApplyTenantAlerts)
+	rg.GET("/observability/environment/:environment_id/alerts/export", h.CheckByEnvironmentID, h.ExportEnvironmentAlerts)
+	rg.POST("/observability/environment/:environment_id/alerts/import", h.CheckByEnvironmentID, h.ImportEnvironmentAlerts)
 
 	// metrics
 	rg.GET("/observability/cluster/:cluster/namespaces/:namespace/monitor/metrics/queryrange", h.CheckByClusterNamespace, h.QueryRange)
@@ -92,6 +115,14 @@ func (h *ObservabilityHandler) RegistRouter(rg *gin.RouterGroup) {
 	rg.PUT("/observability/environment/:environment_id/monitor/dashboard/:dashboard_id", h.CheckByEnvironmentID, h.UpdateDashboard)
 	rg.DELETE("/observability/environment/:environment_id/monitor/dashboard/:dashboard_id", h.CheckByEnvironmentID, h.DeleteDashboard)
 
+	rg.GET("/observability/environment/:environment_id/monitor/slos", h.CheckByEnvironmentID, h.ListSLO)
+	rg.GET("/observability/environment/:environment_id/monitor/slos/:slo_id", h.CheckByEnvironmentID, h.GetSLO)
+	rg.POST("/observability/environment/:environment_id/monitor/slos", h.CheckByEnvironmentID, h.CreateSLO)
+	rg.PUT("/observability/environment/:environment_id/monitor/slos/:slo_id", h.CheckByEnvironmentID, h.UpdateSLO)
+	rg.DELETE("/observability/environment/:environment_id/monitor/slos/:slo_id", h.CheckByEnvironmentID, h.DeleteSLO)
+	rg.GET("/observability/environment/:environment_id/monitor/slos/:slo_id/budget", h.CheckByEnvironmentID, h.SLOBudget)
+	rg.GET("/observability/environment/:environment_id/monitor/slos/:slo_id/budget/history", h.CheckByEnvironmentID, h.SLOBudgetHistory)
+
 	rg.GET("/observability/template/dashboard", h.ListDashboardTemplates)
 	rg.GET("/observability/template/dashboard/:name", h.GetDashboardTemplate)
 	rg.POST("/observability/template/dashboard", h.CheckIsSysADMIN, h.AddDashboardTemplates)
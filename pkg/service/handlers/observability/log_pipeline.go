@@ -0,0 +1,331 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observability
+
+import (
+	"context"
+
+	v1beta1 "github.com/banzaicloud/logging-operator/pkg/sdk/logging/api/v1beta1"
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"kubegems.io/kubegems/pkg/apis/gems"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/observe"
+	"kubegems.io/kubegems/pkg/utils/agents"
+	"kubegems.io/kubegems/pkg/utils/slice"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ListLogPipeline 日志管道列表
+// @Tags        Observability
+// @Summary     日志管道列表
+// @Description 日志管道列表
+// @Accept      json
+// @Produce     json
+// @Param       cluster   path     string                                              true "cluster"
+// @Param       namespace path     string                                              true "namespace"
+// @Success     200       {object} handlers.ResponseStruct{Data=[]observe.LogPipeline} "resp"
+// @Router      /v1/observability/cluster/{cluster}/namespaces/{namespace}/logging/pipelines [get]
+// @Security    JWT
+func (h *ObservabilityHandler) ListLogPipeline(c *gin.Context) {
+	cluster := c.Param("cluster")
+	namespace := c.Param("namespace")
+
+	flowList := v1beta1.FlowList{}
+	if err := h.Execute(c.Request.Context(), cluster, func(ctx context.Context, cli agents.Client) error {
+		return cli.List(ctx, &flowList, client.InNamespace(namespace), client.HasLabels{gems.LabelLogPipeline})
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	ret := []observe.LogPipeline{}
+	for i := range flowList.Items {
+		p, err := observe.LogPipelineFromFlow(&flowList.Items[i])
+		if err != nil {
+			handlers.NotOK(c, err)
+			return
+		}
+		ret = append(ret, *p)
+	}
+	handlers.OK(c, ret)
+}
+
+// GetLogPipeline 日志管道详情
+// @Tags        Observability
+// @Summary     日志管道详情
+// @Description 日志管道详情
+// @Accept      json
+// @Produce     json
+// @Param       cluster   path     string                                            true "cluster"
+// @Param       namespace path     string                                            true "namespace"
+// @Param       name      path     string                                            true "name"
+// @Success     200       {object} handlers.ResponseStruct{Data=observe.LogPipeline} "resp"
+// @Router      /v1/observability/cluster/{cluster}/namespaces/{namespace}/logging/pipelines/{name} [get]
+// @Security    JWT
+func (h *ObservabilityHandler) GetLogPipeline(c *gin.Context) {
+	cluster := c.Param("cluster")
+	namespace := c.Param("namespace")
+
+	flow := v1beta1.Flow{}
+	if err := h.Execute(c.Request.Context(), cluster, func(ctx context.Context, cli agents.Client) error {
+		return cli.Get(ctx, client.ObjectKey{Namespace: namespace, Name: c.Param("name")}, &flow)
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	p, err := observe.LogPipelineFromFlow(&flow)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, p)
+}
+
+// CreateLogPipeline 创建日志管道
+// @Tags        Observability
+// @Summary     创建日志管道
+// @Description 创建日志管道, 为应用配置多行合并、脱敏、解析、采样及额外的输出(elasticsearch/kafka/s3)
+// @Accept      json
+// @Produce     json
+// @Param       cluster   path     string                                            true "cluster"
+// @Param       namespace path     string                                            true "namespace"
+// @Param       form      body     observe.LogPipeline                               true "body"
+// @Success     200       {object} handlers.ResponseStruct{Data=observe.LogPipeline} "resp"
+// @Router      /v1/observability/cluster/{cluster}/namespaces/{namespace}/logging/pipelines [post]
+// @Security    JWT
+func (h *ObservabilityHandler) CreateLogPipeline(c *gin.Context) {
+	h.applyLogPipeline(c, false)
+}
+
+// UpdateLogPipeline 更新日志管道
+// @Tags        Observability
+// @Summary     更新日志管道
+// @Description 更新日志管道
+// @Accept      json
+// @Produce     json
+// @Param       cluster   path     string                                            true "cluster"
+// @Param       namespace path     string                                            true "namespace"
+// @Param       name      path     string                                            true "name"
+// @Param       form      body     observe.LogPipeline                               true "body"
+// @Success     200       {object} handlers.ResponseStruct{Data=observe.LogPipeline} "resp"
+// @Router      /v1/observability/cluster/{cluster}/namespaces/{namespace}/logging/pipelines/{name} [put]
+// @Security    JWT
+func (h *ObservabilityHandler) UpdateLogPipeline(c *gin.Context) {
+	h.applyLogPipeline(c, true)
+}
+
+func (h *ObservabilityHandler) applyLogPipeline(c *gin.Context, update bool) {
+	cluster := c.Param("cluster")
+	namespace := c.Param("namespace")
+
+	req, err := getLogPipelineReq(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if update {
+		req.Name = c.Param("name")
+	}
+	if err := observe.MutateLogPipeline(req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.SetExtraAuditDataByClusterNamespace(c, cluster, namespace)
+	if update {
+		h.SetAuditData(c, "更新", "日志管道", req.Name)
+	} else {
+		h.SetAuditData(c, "创建", "日志管道", req.Name)
+	}
+
+	if err := h.Execute(c.Request.Context(), cluster, func(ctx context.Context, cli agents.Client) error {
+		existFlow := v1beta1.Flow{}
+		err := cli.Get(ctx, client.ObjectKey{Namespace: namespace, Name: req.Name}, &existFlow)
+		switch {
+		case err == nil && !update:
+			return i18n.Errorf(c, "log pipeline %s already exists", req.Name)
+		case kerrors.IsNotFound(err) && update:
+			return i18n.Errorf(c, "log pipeline %s not found", req.Name)
+		case err != nil && !kerrors.IsNotFound(err):
+			return err
+		}
+		if update && existFlow.Labels[gems.LabelLogPipeline] == "" {
+			return i18n.Errorf(c, "log pipeline %s not found", req.Name)
+		}
+
+		// 应用不能同时被其他 flow 采集
+		podList := corev1.PodList{}
+		flowList := v1beta1.FlowList{}
+		if err := cli.List(ctx, &podList, client.InNamespace(namespace)); err != nil {
+			return err
+		}
+		if err := cli.List(ctx, &flowList, client.InNamespace(namespace)); err != nil {
+			return err
+		}
+		logstatus := getAppsLogStatus(podList, flowList)
+		for appname, applabel := range req.Apps {
+			if !slice.ContainStr(applables, applabel) {
+				return i18n.Errorf(c, "app label %s is not valid, must be one of %v", applabel, applables)
+			}
+			if status, ok := logstatus[appname]; ok && status.CollectedBy != "" && status.CollectedBy != req.Name {
+				return i18n.Errorf(c, "app %s has been collected by flow %s", appname, status.CollectedBy)
+			}
+		}
+
+		// 先创建 output, 避免 flow 引用不存在的 output
+		outputs := req.ToOutputs()
+		for i := range outputs {
+			if err := applyObject(ctx, cli, &outputs[i], &v1beta1.Output{}); err != nil {
+				return err
+			}
+		}
+		flow, err := req.ToFlow()
+		if err != nil {
+			return err
+		}
+		if err := applyObject(ctx, cli, flow, &v1beta1.Flow{}); err != nil {
+			return err
+		}
+		return cleanLogPipelineOutputs(ctx, cli, req)
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, req)
+}
+
+// DeleteLogPipeline 删除日志管道
+// @Tags        Observability
+// @Summary     删除日志管道
+// @Description 删除日志管道及其创建的 Output
+// @Accept      json
+// @Produce     json
+// @Param       cluster   path     string                               true "cluster"
+// @Param       namespace path     string                               true "namespace"
+// @Param       name      path     string                               true "name"
+// @Success     200       {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/observability/cluster/{cluster}/namespaces/{namespace}/logging/pipelines/{name} [delete]
+// @Security    JWT
+func (h *ObservabilityHandler) DeleteLogPipeline(c *gin.Context) {
+	cluster := c.Param("cluster")
+	namespace := c.Param("namespace")
+	name := c.Param("name")
+
+	h.SetExtraAuditDataByClusterNamespace(c, cluster, namespace)
+	h.SetAuditData(c, "删除", "日志管道", name)
+	if err := h.Execute(c.Request.Context(), cluster, func(ctx context.Context, cli agents.Client) error {
+		flow := v1beta1.Flow{}
+		if err := cli.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &flow); err != nil {
+			return err
+		}
+		if flow.Labels[gems.LabelLogPipeline] == "" {
+			return i18n.Errorf(c, "log pipeline %s not found", name)
+		}
+		if err := cli.Delete(ctx, &flow); err != nil {
+			return err
+		}
+		return cleanLogPipelineOutputs(ctx, cli, &observe.LogPipeline{Name: name, Namespace: namespace})
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, "ok")
+}
+
+type LogPipelineTestReq struct {
+	Pipeline observe.LogPipeline `json:"pipeline"`
+	Lines    []string            `json:"lines"` // 样例日志, 每行一条
+}
+
+// TestLogPipeline 测试日志管道
+// @Tags        Observability
+// @Summary     测试日志管道
+// @Description 在样例日志上运行管道, 返回多行合并、脱敏、解析、采样后的结果
+// @Accept      json
+// @Produce     json
+// @Param       cluster   path     string                                                      true "cluster"
+// @Param       namespace path     string                                                      true "namespace"
+// @Param       form      body     LogPipelineTestReq                                          true "body"
+// @Success     200       {object} handlers.ResponseStruct{Data=observe.LogPipelineTestResult} "resp"
+// @Router      /v1/observability/cluster/{cluster}/namespaces/{namespace}/logging/pipelines/actions/test [post]
+// @Security    JWT
+func (h *ObservabilityHandler) TestLogPipeline(c *gin.Context) {
+	req := LogPipelineTestReq{}
+	if err := c.BindJSON(&req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if len(req.Lines) == 0 || len(req.Lines) > 1000 {
+		handlers.NotOK(c, i18n.Errorf(c, "sample lines must be between 1 and 1000"))
+		return
+	}
+	req.Pipeline.Namespace = c.Param("namespace")
+	if err := observe.MutateLogPipeline(&req.Pipeline); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	ret, err := observe.RunLogPipeline(&req.Pipeline, req.Lines)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, ret)
+}
+
+func getLogPipelineReq(c *gin.Context) (*observe.LogPipeline, error) {
+	req := &observe.LogPipeline{}
+	if err := c.BindJSON(req); err != nil {
+		return nil, err
+	}
+	req.Namespace = c.Param("namespace")
+	if len(req.ClusterOutputs) == 0 {
+		req.ClusterOutputs = []string{defaultGlobalOutput}
+	}
+	return req, nil
+}
+
+// applyObject 创建或覆盖对象的 labels/annotations/spec
+func applyObject(ctx context.Context, cli agents.Client, obj client.Object, exist client.Object) error {
+	if err := cli.Get(ctx, client.ObjectKeyFromObject(obj), exist); err != nil {
+		if !kerrors.IsNotFound(err) {
+			return err
+		}
+		return cli.Create(ctx, obj)
+	}
+	obj.SetResourceVersion(exist.GetResourceVersion())
+	return cli.Update(ctx, obj)
+}
+
+// cleanLogPipelineOutputs 删除管道不再使用的 Output
+func cleanLogPipelineOutputs(ctx context.Context, cli agents.Client, p *observe.LogPipeline) error {
+	outputList := v1beta1.OutputList{}
+	if err := cli.List(ctx, &outputList, client.InNamespace(p.Namespace), client.MatchingLabels{gems.LabelLogPipeline: p.Name}); err != nil {
+		return err
+	}
+	inuse := map[string]bool{}
+	for _, o := range p.Outputs {
+		inuse[p.OutputName(o)] = true
+	}
+	for i := range outputList.Items {
+		if inuse[outputList.Items[i].Name] {
+			continue
+		}
+		if err := cli.Delete(ctx, &outputList.Items[i]); err != nil && !kerrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
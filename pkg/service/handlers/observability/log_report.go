@@ -0,0 +1,401 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observability
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/service/observe"
+	"kubegems.io/kubegems/pkg/utils/agents"
+	"kubegems.io/kubegems/pkg/utils/prometheus/channels"
+)
+
+// ListLoggingMetric 日志指标列表
+// @Tags        Observability
+// @Summary     日志指标列表
+// @Description 日志指标列表, 日志指标作为 loki 记录规则写入 prometheus, 可以通过 promql 在监控面板中查询
+// @Accept      json
+// @Produce     json
+// @Param       cluster   path     string                                                true "cluster"
+// @Param       namespace path     string                                                true "namespace"
+// @Success     200       {object} handlers.ResponseStruct{Data=[]observe.LoggingMetric} "resp"
+// @Router      /v1/observability/cluster/{cluster}/namespaces/{namespace}/logging/metrics [get]
+// @Security    JWT
+func (h *ObservabilityHandler) ListLoggingMetric(c *gin.Context) {
+	cluster := c.Param("cluster")
+	namespace := c.Param("namespace")
+
+	ret := []observe.LoggingMetric{}
+	if err := h.Execute(c.Request.Context(), cluster, func(ctx context.Context, cli agents.Client) error {
+		raw, err := observe.NewClient(cli, h.GetDB()).GetRawLoggingAlertResource(ctx, namespace)
+		if err != nil {
+			return err
+		}
+		ret = raw.ToLoggingMetrics()
+		return nil
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, ret)
+}
+
+// CreateLoggingMetric 创建日志指标
+// @Tags        Observability
+// @Summary     创建日志指标
+// @Description 创建日志指标
+// @Accept      json
+// @Produce     json
+// @Param       cluster   path     string                                              true "cluster"
+// @Param       namespace path     string                                              true "namespace"
+// @Param       form      body     observe.LoggingMetric                               true "body"
+// @Success     200       {object} handlers.ResponseStruct{Data=observe.LoggingMetric} "resp"
+// @Router      /v1/observability/cluster/{cluster}/namespaces/{namespace}/logging/metrics [post]
+// @Security    JWT
+func (h *ObservabilityHandler) CreateLoggingMetric(c *gin.Context) {
+	h.modifyLoggingMetric(c, observe.Add)
+}
+
+// UpdateLoggingMetric 更新日志指标
+// @Tags        Observability
+// @Summary     更新日志指标
+// @Description 更新日志指标
+// @Accept      json
+// @Produce     json
+// @Param       cluster   path     string                                              true "cluster"
+// @Param       namespace path     string                                              true "namespace"
+// @Param       name      path     string                                              true "name"
+// @Param       form      body     observe.LoggingMetric                               true "body"
+// @Success     200       {object} handlers.ResponseStruct{Data=observe.LoggingMetric} "resp"
+// @Router      /v1/observability/cluster/{cluster}/namespaces/{namespace}/logging/metrics/{name} [put]
+// @Security    JWT
+func (h *ObservabilityHandler) UpdateLoggingMetric(c *gin.Context) {
+	h.modifyLoggingMetric(c, observe.Update)
+}
+
+// DeleteLoggingMetric 删除日志指标
+// @Tags        Observability
+// @Summary     删除日志指标
+// @Description 删除日志指标
+// @Accept      json
+// @Produce     json
+// @Param       cluster   path     string                               true "cluster"
+// @Param       namespace path     string                               true "namespace"
+// @Param       name      path     string                               true "name"
+// @Success     200       {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/observability/cluster/{cluster}/namespaces/{namespace}/logging/metrics/{name} [delete]
+// @Security    JWT
+func (h *ObservabilityHandler) DeleteLoggingMetric(c *gin.Context) {
+	cluster := c.Param("cluster")
+	namespace := c.Param("namespace")
+	name := c.Param("name")
+
+	h.SetExtraAuditDataByClusterNamespace(c, cluster, namespace)
+	h.SetAuditData(c, "删除", "日志指标", name)
+
+	h.m.Lock()
+	defer h.m.Unlock()
+	if err := h.Execute(c.Request.Context(), cluster, func(ctx context.Context, cli agents.Client) error {
+		observecli := observe.NewClient(cli, h.GetDB())
+		raw, err := observecli.GetRawLoggingAlertResource(ctx, namespace)
+		if err != nil {
+			return err
+		}
+		if err := raw.ModifyLoggingMetric(observe.LoggingMetric{Namespace: namespace, Name: name}, observe.Delete); err != nil {
+			return err
+		}
+		return observecli.CommitRawLoggingAlertResource(ctx, raw)
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, "ok")
+}
+
+func (h *ObservabilityHandler) modifyLoggingMetric(c *gin.Context, act observe.Action) {
+	cluster := c.Param("cluster")
+	namespace := c.Param("namespace")
+
+	req := observe.LoggingMetric{}
+	if err := c.BindJSON(&req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	req.Namespace = namespace
+	if act == observe.Update {
+		req.Name = c.Param("name")
+	}
+	if err := observe.MutateLoggingMetric(&req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.SetExtraAuditDataByClusterNamespace(c, cluster, namespace)
+	if act == observe.Add {
+		h.SetAuditData(c, "创建", "日志指标", req.Name)
+	} else {
+		h.SetAuditData(c, "更新", "日志指标", req.Name)
+	}
+
+	h.m.Lock()
+	defer h.m.Unlock()
+	if err := h.Execute(c.Request.Context(), cluster, func(ctx context.Context, cli agents.Client) error {
+		observecli := observe.NewClient(cli, h.GetDB())
+		raw, err := observecli.GetRawLoggingAlertResource(ctx, namespace)
+		if err != nil {
+			return err
+		}
+		if err := raw.ModifyLoggingMetric(req, act); err != nil {
+			return err
+		}
+		return observecli.CommitRawLoggingAlertResource(ctx, raw)
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, req)
+}
+
+// ListLogReport 定时日志报告列表
+// @Tags        Observability
+// @Summary     定时日志报告列表
+// @Description 定时日志报告列表
+// @Accept      json
+// @Produce     json
+// @Param       cluster   path     string                                           true "cluster"
+// @Param       namespace path     string                                           true "namespace"
+// @Success     200       {object} handlers.ResponseStruct{Data=[]models.LogReport} "resp"
+// @Router      /v1/observability/cluster/{cluster}/namespaces/{namespace}/logging/reports [get]
+// @Security    JWT
+func (h *ObservabilityHandler) ListLogReport(c *gin.Context) {
+	ret := []models.LogReport{}
+	if err := h.GetDB().Order("id").Find(&ret, "cluster = ? and namespace = ?", c.Param("cluster"), c.Param("namespace")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, ret)
+}
+
+// GetLogReport 定时日志报告详情
+// @Tags        Observability
+// @Summary     定时日志报告详情
+// @Description 定时日志报告详情
+// @Accept      json
+// @Produce     json
+// @Param       cluster   path     string                                         true "cluster"
+// @Param       namespace path     string                                         true "namespace"
+// @Param       report_id path     uint                                           true "report_id"
+// @Success     200       {object} handlers.ResponseStruct{Data=models.LogReport} "resp"
+// @Router      /v1/observability/cluster/{cluster}/namespaces/{namespace}/logging/reports/{report_id} [get]
+// @Security    JWT
+func (h *ObservabilityHandler) GetLogReport(c *gin.Context) {
+	report, err := h.getLogReport(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, report)
+}
+
+// CreateLogReport 创建定时日志报告
+// @Tags        Observability
+// @Summary     创建定时日志报告
+// @Description 创建定时日志报告, 按 cron 查询日志并将 TopN 聚合结果发送至告警渠道
+// @Accept      json
+// @Produce     json
+// @Param       cluster   path     string                                         true "cluster"
+// @Param       namespace path     string                                         true "namespace"
+// @Param       form      body     models.LogReport                               true "body"
+// @Success     200       {object} handlers.ResponseStruct{Data=models.LogReport} "resp"
+// @Router      /v1/observability/cluster/{cluster}/namespaces/{namespace}/logging/reports [post]
+// @Security    JWT
+func (h *ObservabilityHandler) CreateLogReport(c *gin.Context) {
+	report := &models.LogReport{}
+	if err := c.BindJSON(report); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	report.ID = 0
+	report.Cluster = c.Param("cluster")
+	report.Namespace = c.Param("namespace")
+	report.LastRunAt = nil
+	if user, exist := h.GetContextUser(c); exist {
+		report.Creator = user.GetUsername()
+	}
+	if err := h.checkLogReport(report); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.SetExtraAuditDataByClusterNamespace(c, report.Cluster, report.Namespace)
+	h.SetAuditData(c, "创建", "定时日志报告", report.Name)
+	if err := h.GetDB().Create(report).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, report)
+}
+
+// UpdateLogReport 更新定时日志报告
+// @Tags        Observability
+// @Summary     更新定时日志报告
+// @Description 更新定时日志报告
+// @Accept      json
+// @Produce     json
+// @Param       cluster   path     string                                         true "cluster"
+// @Param       namespace path     string                                         true "namespace"
+// @Param       report_id path     uint                                           true "report_id"
+// @Param       form      body     models.LogReport                               true "body"
+// @Success     200       {object} handlers.ResponseStruct{Data=models.LogReport} "resp"
+// @Router      /v1/observability/cluster/{cluster}/namespaces/{namespace}/logging/reports/{report_id} [put]
+// @Security    JWT
+func (h *ObservabilityHandler) UpdateLogReport(c *gin.Context) {
+	report, err := h.getLogReport(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	req := &models.LogReport{}
+	if err := c.BindJSON(req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	report.Name = req.Name
+	report.LogQL = req.LogQL
+	report.TimeRange = req.TimeRange
+	report.Cron = req.Cron
+	report.TopN = req.TopN
+	report.GroupBy = req.GroupBy
+	report.ChannelIDs = req.ChannelIDs
+	report.Enabled = req.Enabled
+	if err := h.checkLogReport(report); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.SetExtraAuditDataByClusterNamespace(c, report.Cluster, report.Namespace)
+	h.SetAuditData(c, "更新", "定时日志报告", report.Name)
+	if err := h.GetDB().Save(report).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, report)
+}
+
+// DeleteLogReport 删除定时日志报告
+// @Tags        Observability
+// @Summary     删除定时日志报告
+// @Description 删除定时日志报告
+// @Accept      json
+// @Produce     json
+// @Param       cluster   path     string                               true "cluster"
+// @Param       namespace path     string                               true "namespace"
+// @Param       report_id path     uint                                 true "report_id"
+// @Success     200       {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/observability/cluster/{cluster}/namespaces/{namespace}/logging/reports/{report_id} [delete]
+// @Security    JWT
+func (h *ObservabilityHandler) DeleteLogReport(c *gin.Context) {
+	report, err := h.getLogReport(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.SetExtraAuditDataByClusterNamespace(c, report.Cluster, report.Namespace)
+	h.SetAuditData(c, "删除", "定时日志报告", report.Name)
+	if err := h.GetDB().Delete(report).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, "ok")
+}
+
+// PreviewLogReport 预览定时日志报告
+// @Tags        Observability
+// @Summary     预览定时日志报告
+// @Description 立即生成报告, send=true 时同时发送至告警渠道
+// @Accept      json
+// @Produce     json
+// @Param       cluster   path     string                                                 true  "cluster"
+// @Param       namespace path     string                                                 true  "namespace"
+// @Param       report_id path     uint                                                   true  "report_id"
+// @Param       send      query    bool                                                   false "send"
+// @Success     200       {object} handlers.ResponseStruct{Data=observe.LogReportResult} "resp"
+// @Router      /v1/observability/cluster/{cluster}/namespaces/{namespace}/logging/reports/{report_id}/preview [post]
+// @Security    JWT
+func (h *ObservabilityHandler) PreviewLogReport(c *gin.Context) {
+	report, err := h.getLogReport(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	var result *observe.LogReportResult
+	if err := h.Execute(c.Request.Context(), report.Cluster, func(ctx context.Context, cli agents.Client) error {
+		result, err = observe.GenerateLogReport(ctx, cli, report, time.Now())
+		return err
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if c.Query("send") == "true" {
+		h.SetExtraAuditDataByClusterNamespace(c, report.Cluster, report.Namespace)
+		h.SetAuditData(c, "发送", "定时日志报告", report.Name)
+		if err := observe.SendLogReport(h.GetDB(), report, result); err != nil {
+			handlers.NotOK(c, err)
+			return
+		}
+	}
+	handlers.OK(c, result)
+}
+
+func (h *ObservabilityHandler) getLogReport(c *gin.Context) (*models.LogReport, error) {
+	report := &models.LogReport{}
+	if err := h.GetDB().First(report, "id = ? and cluster = ? and namespace = ?",
+		c.Param("report_id"), c.Param("cluster"), c.Param("namespace")).Error; err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (h *ObservabilityHandler) checkLogReport(report *models.LogReport) error {
+	if err := observe.MutateLogReport(report); err != nil {
+		return err
+	}
+	// 只能使用环境所属租户的渠道及系统预置渠道
+	env := &models.Environment{}
+	if err := h.GetDB().Preload("Project").
+		Where("cluster_id in (?)", h.GetDB().Model(&models.Cluster{}).Select("id").Where("cluster_name = ?", report.Cluster)).
+		First(env, "namespace = ?", report.Namespace).Error; err != nil {
+		return err
+	}
+	chs := []models.AlertChannel{}
+	if err := h.GetDB().
+		Where("id in ?", []uint(report.ChannelIDs)).
+		Where("tenant_id = ? or tenant_id is null", env.Project.TenantID).
+		Find(&chs).Error; err != nil {
+		return err
+	}
+	if len(report.ChannelIDs) == 0 || len(chs) != len(report.ChannelIDs) {
+		return i18n.Errorf(context.TODO(), "log report channels not found")
+	}
+	// 短信及语音渠道不能发送报告
+	for _, ch := range chs {
+		if ch.ChannelConfig.ChannelIf == nil || !channels.SupportsReport(ch.ChannelConfig.ChannelIf) {
+			return i18n.Errorf(context.TODO(), "channel %s can not send log reports", ch.Name)
+		}
+	}
+	return nil
+}
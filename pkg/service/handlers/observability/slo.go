@@ -0,0 +1,427 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observability
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1alpha1"
+	prommodel "github.com/prometheus/common/model"
+	"gorm.io/gorm"
+	"kubegems.io/kubegems/pkg/apis/gems"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/service/observe"
+	"kubegems.io/kubegems/pkg/utils/agents"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ListSLO SLO列表
+// @Tags        Observability
+// @Summary     SLO列表
+// @Description SLO列表
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                     true "环境ID"
+// @Success     200            {object} handlers.ResponseStruct{Data=[]models.SLO} "resp"
+// @Router      /v1/observability/environment/{environment_id}/monitor/slos [get]
+// @Security    JWT
+func (h *ObservabilityHandler) ListSLO(c *gin.Context) {
+	ret := []models.SLO{}
+	if err := h.GetDB().Find(&ret, "environment_id = ?", c.Param("environment_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, ret)
+}
+
+// GetSLO SLO详情
+// @Tags        Observability
+// @Summary     SLO详情
+// @Description SLO详情
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                   true "环境ID"
+// @Param       slo_id         path     uint                                     true "slo id"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.SLO} "resp"
+// @Router      /v1/observability/environment/{environment_id}/monitor/slos/{slo_id} [get]
+// @Security    JWT
+func (h *ObservabilityHandler) GetSLO(c *gin.Context) {
+	slo, err := h.getSLO(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, slo)
+}
+
+// CreateSLO 创建SLO
+// @Tags        Observability
+// @Summary     创建SLO
+// @Description 创建SLO, 同时生成多窗口多燃烧率告警规则
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                   true "环境ID"
+// @Param       form           body     models.SLO                               true "body"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.SLO} "resp"
+// @Router      /v1/observability/environment/{environment_id}/monitor/slos [post]
+// @Security    JWT
+func (h *ObservabilityHandler) CreateSLO(c *gin.Context) {
+	env, err := h.getSLOEnvironment(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	slo := &models.SLO{}
+	if err := c.BindJSON(slo); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	slo.ID = 0
+	slo.EnvironmentID = &env.ID
+	if user, exist := h.GetContextUser(c); exist {
+		slo.Creator = user.GetUsername()
+	}
+	channels, err := h.checkSLO(slo, env)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.SetAuditData(c, i18n.Sprintf(c, "create"), "SLO", slo.Name)
+	h.SetExtraAuditData(c, models.ResEnvironment, env.ID)
+
+	h.m.Lock()
+	defer h.m.Unlock()
+	if err := h.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(slo).Error; err != nil {
+			return err
+		}
+		return h.syncSLOAlertRules(c.Request.Context(), env, slo, channels, true)
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, slo)
+}
+
+// UpdateSLO 更新SLO
+// @Tags        Observability
+// @Summary     更新SLO
+// @Description 更新SLO, 同时更新告警规则
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                   true "环境ID"
+// @Param       slo_id         path     uint                                     true "slo id"
+// @Param       form           body     models.SLO                               true "body"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.SLO} "resp"
+// @Router      /v1/observability/environment/{environment_id}/monitor/slos/{slo_id} [put]
+// @Security    JWT
+func (h *ObservabilityHandler) UpdateSLO(c *gin.Context) {
+	env, err := h.getSLOEnvironment(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	slo, err := h.getSLO(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	req := &models.SLO{}
+	if err := c.BindJSON(req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if req.Name != slo.Name {
+		handlers.NotOK(c, i18n.Errorf(c, "SLO name can't be modified"))
+		return
+	}
+	req.ID, req.EnvironmentID, req.Creator, req.CreatedAt = slo.ID, slo.EnvironmentID, slo.Creator, slo.CreatedAt
+	channels, err := h.checkSLO(req, env)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.SetAuditData(c, i18n.Sprintf(c, "update"), "SLO", req.Name)
+	h.SetExtraAuditData(c, models.ResEnvironment, env.ID)
+
+	h.m.Lock()
+	defer h.m.Unlock()
+	if err := h.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(req).Error; err != nil {
+			return err
+		}
+		return h.syncSLOAlertRules(c.Request.Context(), env, req, channels, false)
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, req)
+}
+
+// DeleteSLO 删除SLO
+// @Tags        Observability
+// @Summary     删除SLO
+// @Description 删除SLO及其告警规则
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                               true "环境ID"
+// @Param       slo_id         path     uint                                 true "slo id"
+// @Success     200            {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/observability/environment/{environment_id}/monitor/slos/{slo_id} [delete]
+// @Security    JWT
+func (h *ObservabilityHandler) DeleteSLO(c *gin.Context) {
+	env, err := h.getSLOEnvironment(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	slo, err := h.getSLO(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.SetAuditData(c, i18n.Sprintf(c, "delete"), "SLO", slo.Name)
+	h.SetExtraAuditData(c, models.ResEnvironment, env.ID)
+
+	h.m.Lock()
+	defer h.m.Unlock()
+	if err := h.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(slo).Error; err != nil {
+			return err
+		}
+		return h.syncSLOAlertRules(c.Request.Context(), env, slo, nil, false)
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, "ok")
+}
+
+// SLOBudget SLO错误预算
+// @Tags        Observability
+// @Summary     SLO错误预算
+// @Description 统计窗口内的 SLI、已消耗与剩余的错误预算及最近1小时的燃烧率
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                         true "环境ID"
+// @Param       slo_id         path     uint                                           true "slo id"
+// @Success     200            {object} handlers.ResponseStruct{Data=observe.SLOBudget} "resp"
+// @Router      /v1/observability/environment/{environment_id}/monitor/slos/{slo_id}/budget [get]
+// @Security    JWT
+func (h *ObservabilityHandler) SLOBudget(c *gin.Context) {
+	env, err := h.getSLOEnvironment(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	slo, err := h.getSLO(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	var ret observe.SLOBudget
+	if err := h.Execute(c.Request.Context(), env.Cluster.ClusterName, func(ctx context.Context, cli agents.Client) error {
+		goodQuery, totalQuery := observe.SLOEventsQuery(slo)
+		values := []float64{}
+		for _, query := range []string{goodQuery, totalQuery, observe.SLOCurrentBurnRate(slo)} {
+			vec, err := cli.Extend().PrometheusVector(ctx, query)
+			if err != nil {
+				return err
+			}
+			if len(vec) == 0 {
+				values = append(values, 0)
+			} else {
+				values = append(values, float64(vec[0].Value))
+			}
+		}
+		ret = observe.NewSLOBudget(slo, values[0], values[1], values[2])
+		return nil
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, ret)
+}
+
+// SLOBudgetHistory SLO剩余错误预算历史
+// @Tags        Observability
+// @Summary     SLO剩余错误预算历史
+// @Description 每个时间点往前一个统计窗口的剩余错误预算百分比
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                      true  "环境ID"
+// @Param       slo_id         path     uint                                        true  "slo id"
+// @Param       start          query    string                                      false "开始时间，格式 2006-01-02T15:04:05Z07:00, 默认7天前"
+// @Param       end            query    string                                      false "结束时间，格式 2006-01-02T15:04:05Z07:00, 默认当前时间"
+// @Param       step           query    int                                         false "步长, 单位秒, 默认自动计算"
+// @Success     200            {object} handlers.ResponseStruct{Data=model.Matrix} "resp"
+// @Router      /v1/observability/environment/{environment_id}/monitor/slos/{slo_id}/budget/history [get]
+// @Security    JWT
+func (h *ObservabilityHandler) SLOBudgetHistory(c *gin.Context) {
+	env, err := h.getSLOEnvironment(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	slo, err := h.getSLO(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	end := time.Now()
+	if val := c.Query("end"); val != "" {
+		if end, err = time.Parse(time.RFC3339, val); err != nil {
+			handlers.NotOK(c, err)
+			return
+		}
+	}
+	start := end.Add(-7 * 24 * time.Hour)
+	if val := c.Query("start"); val != "" {
+		if start, err = time.Parse(time.RFC3339, val); err != nil {
+			handlers.NotOK(c, err)
+			return
+		}
+	}
+	if !start.Before(end) {
+		handlers.NotOK(c, i18n.Errorf(c, "start time must be before end time"))
+		return
+	}
+	step := ""
+	if val := c.Query("step"); val != "" {
+		if _, err := strconv.Atoi(val); err != nil {
+			handlers.NotOK(c, err)
+			return
+		}
+		step = val
+	}
+
+	var ret prommodel.Matrix
+	if err := h.Execute(c.Request.Context(), env.Cluster.ClusterName, func(ctx context.Context, cli agents.Client) error {
+		ret, err = cli.Extend().PrometheusQueryRange(ctx, observe.SLORemainingBudget(slo),
+			start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339), step)
+		return err
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, ret)
+}
+
+func (h *ObservabilityHandler) getSLOEnvironment(c *gin.Context) (*models.Environment, error) {
+	env := &models.Environment{}
+	if err := h.GetDB().Preload("Cluster").Preload("Project").First(env, c.Param("environment_id")).Error; err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+func (h *ObservabilityHandler) getSLO(c *gin.Context) (*models.SLO, error) {
+	slo := &models.SLO{}
+	if err := h.GetDB().First(slo, "id = ? and environment_id = ?", c.Param("slo_id"), c.Param("environment_id")).Error; err != nil {
+		return nil, err
+	}
+	return slo, nil
+}
+
+// checkSLO 校验 SLO 并返回告警渠道
+func (h *ObservabilityHandler) checkSLO(slo *models.SLO, env *models.Environment) ([]models.AlertChannel, error) {
+	if err := observe.MutateSLO(slo, env.Namespace); err != nil {
+		return nil, err
+	}
+	var count int64
+	if err := h.GetDB().Model(&models.SLO{}).
+		Where("environment_id = ? and name = ? and id != ?", env.ID, slo.Name, slo.ID).
+		Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, i18n.Errorf(context.TODO(), "SLO %s already exists", slo.Name)
+	}
+	// 只能使用环境所属租户的渠道及系统预置渠道
+	channels := []models.AlertChannel{}
+	if err := h.GetDB().Where("tenant_id = ? or tenant_id is null", env.Project.TenantID).
+		Find(&channels, "id in ?", []uint(slo.ChannelIDs)).Error; err != nil {
+		return nil, err
+	}
+	if len(slo.ChannelIDs) == 0 || len(channels) != len(slo.ChannelIDs) {
+		return nil, i18n.Errorf(context.TODO(), "SLO channels not found")
+	}
+	return channels, nil
+}
+
+// syncSLOAlertRules 更新集群中 SLO 的告警规则, channels 为空时删除告警规则
+func (h *ObservabilityHandler) syncSLOAlertRules(ctx context.Context, env *models.Environment, slo *models.SLO, channels []models.AlertChannel, create bool) error {
+	return h.Execute(ctx, env.Cluster.ClusterName, func(ctx context.Context, cli agents.Client) error {
+		observecli := observe.NewClient(cli, h.GetDB())
+		raw, err := observecli.GetRawMonitorAlertResource(ctx, env.Namespace, observe.SLOAlertCRDName, h.GetDataBase().NewPromqlTplMapperFromDB().FindPromqlTpl)
+		if err != nil {
+			return err
+		}
+		var rules []observe.MonitorAlertRule
+		if len(channels) > 0 {
+			if rules, err = observe.SLOAlertRules(slo, env.Namespace, channels); err != nil {
+				return err
+			}
+		}
+		if create {
+			// 与其他告警规则重名检查
+			if err := checkSLOAlertNames(ctx, cli, env.Namespace, rules); err != nil {
+				return err
+			}
+		}
+		if err := raw.SyncSLOAlertRules(slo, rules); err != nil {
+			return err
+		}
+		receivers := []observe.AlertReceiver{}
+		for _, rule := range rules {
+			receivers = append(receivers, rule.Receivers...)
+		}
+		if err := observecli.CreateOrUpdateAlertEmailSecret(ctx, env.Namespace, receivers); err != nil {
+			return err
+		}
+		if err := observecli.CommitRawMonitorAlertResource(ctx, raw); err != nil {
+			return err
+		}
+		if len(channels) == 0 {
+			// 清理silence规则
+			for _, name := range observe.SLOAlertNames(slo) {
+				if err := deleteSilenceIfExist(ctx, env.Namespace, name, cli); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func checkSLOAlertNames(ctx context.Context, cli agents.Client, namespace string, rules []observe.MonitorAlertRule) error {
+	amconfigList := v1alpha1.AlertmanagerConfigList{}
+	if err := cli.List(ctx, &amconfigList, client.InNamespace(namespace), client.HasLabels([]string{
+		gems.LabelAlertmanagerConfigName,
+	})); err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if err := checkAlertName(rule.Name, amconfigList.Items); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -15,6 +15,7 @@
 package proxy
 
 import (
+	"context"
 	"net/http"
 	"net/http/httputil"
 	"path"
@@ -27,8 +28,11 @@ import (
 	"kubegems.io/kubegems/pkg/log"
 	"kubegems.io/kubegems/pkg/service/handlers"
 	"kubegems.io/kubegems/pkg/service/handlers/base"
+	"kubegems.io/kubegems/pkg/service/handlers/sessionrecording"
 	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/service/models/cache"
 	"kubegems.io/kubegems/pkg/utils/agents"
+	"kubegems.io/kubegems/pkg/utils/recording"
 )
 
 const (
@@ -43,6 +47,7 @@ var upgrader = websocket.Upgrader{
 
 type ProxyHandler struct {
 	base.BaseHandler
+	Recordings *sessionrecording.Manager
 }
 
 // 不需要swagger
@@ -132,32 +137,54 @@ func (h *ProxyHandler) ProxyWebsocket(c *gin.Context) {
 		headers.Add(key, strings.Join(values, ","))
 	}
 
-	proxyConn, _, err := v.DialWebsocket(c.Request.Context(), proxyPath, headers)
-	if err != nil {
-		handlers.NotOK(c, err)
+	user, exist := h.GetContextUser(c)
+	if !exist {
+		handlers.Unauthorized(c, i18n.Errorf(c, "unauthorized, please login first"))
 		return
 	}
-	localConn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	proxyConn, _, err := v.DialWebsocket(c.Request.Context(), proxyPath, headers)
 	if err != nil {
 		handlers.NotOK(c, err)
 		return
 	}
-	user, exist := h.GetContextUser(c)
-	if !exist {
-		handlers.Unauthorized(c, i18n.Errorf(c, "unauthorized, please login first"))
-		return
-	}
-	var auditFunc func(string)
+	var parents []cache.CommonResourceIface
 	env := h.ModelCache().FindEnvironment(cluster, proxyobj.Namespace)
 	if env != nil {
 		log.Infof("proxy websocket, cluster is [%v], proxyobj is [%v]", cluster, proxyobj)
-		parents := h.ModelCache().FindParents(models.ResEnvironment, env.GetID())
-		auditFunc = h.WebsocketAuditFunc(user.GetUsername(), parents, c.ClientIP(), proxyobj)
+		parents = h.ModelCache().FindParents(models.ResEnvironment, env.GetID())
 	} else {
 		log.Infof("proxy websocket can't find env, cluster is [%v], proxyobj is [%v]", cluster, proxyobj)
-		auditFunc = h.WebsocketAuditFunc(user.GetUsername(), nil, c.ClientIP(), proxyobj)
 	}
-	Transport(localConn, proxyConn, c, user, auditFunc)
+	// 需要录像的会话无法开始录像时拒绝会话
+	session, err := h.Recordings.Start(c.Request.Context(), user.GetUsername(), c.ClientIP(), c.Query("container"), proxyobj, parents)
+	if err != nil {
+		log.Error(err, "start session recording", "cluster", cluster, "proxyobj", proxyobj)
+		if h.Recordings.Required() {
+			proxyConn.Close()
+			handlers.NotOK(c, i18n.Errorf(c, "session recording is required but failed to start: %v", err))
+			return
+		}
+	}
+	if session != nil {
+		proxyobj.RecordingID = session.Record.SessionID
+		defer func() {
+			if err := h.Recordings.Finish(context.Background(), session); err != nil {
+				log.Error(err, "save session recording", "session", session.Record.SessionID)
+			}
+		}()
+	}
+	localConn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		proxyConn.Close()
+		handlers.NotOK(c, err)
+		return
+	}
+	auditFunc := h.WebsocketAuditFunc(user.GetUsername(), parents, c.ClientIP(), proxyobj)
+	var recorder *recording.Recorder
+	if session != nil {
+		recorder = session.Recorder
+	}
+	Transport(localConn, proxyConn, c, user, auditFunc, recorder, h.Recordings.Required())
 }
 
 func getTargetPath(name string, req *http.Request) (realpath string) {
@@ -23,6 +23,7 @@ import (
 	"github.com/gorilla/websocket"
 	"kubegems.io/kubegems/pkg/log"
 	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/recording"
 )
 
 type Msg struct {
@@ -37,7 +38,8 @@ type xtermMessage struct {
 	Cols    uint16 `json:"cols"`  // msgtype=resize情况下使用
 }
 
-func Transport(local, proxy *websocket.Conn, c *gin.Context, user models.CommonUserIface, auditFunc func(string)) {
+// closeOnTruncated 为 true 时录像被截断后关闭会话, 避免未录制的操作
+func Transport(local, proxy *websocket.Conn, c *gin.Context, user models.CommonUserIface, auditFunc func(string), recorder *recording.Recorder, closeOnTruncated bool) {
 	p := WebSocketProxy{
 		RequestContext: c,
 		Source:         local,
@@ -51,6 +53,10 @@ func Transport(local, proxy *websocket.Conn, c *gin.Context, user models.CommonU
 	}
 
 	p.AuditFunc = auditFunc
+	p.Recorder = recorder
+	if recorder != nil && closeOnTruncated {
+		p.Truncated = recorder.Exceeded()
+	}
 	p.proxy()
 }
 
@@ -63,9 +69,28 @@ type WebSocketProxy struct {
 	Done           chan bool
 	Username       string
 	AuditFunc      func(string)
+	Recorder       *recording.Recorder // 非空时录制终端会话
+	Truncated      <-chan struct{}     // 关闭时结束会话
 	buf            *bytes.Buffer
 }
 
+// record 按顺序录制客户端输入及终端大小调整
+func (wsp *WebSocketProxy) record(msg []byte) {
+	if wsp.Recorder == nil {
+		return
+	}
+	tmsg := xtermMessage{}
+	if err := json.Unmarshal(msg, &tmsg); err != nil {
+		return
+	}
+	switch tmsg.MsgType {
+	case "input":
+		wsp.Recorder.Input(tmsg.Input)
+	case "resize":
+		wsp.Recorder.Resize(tmsg.Cols, tmsg.Rows)
+	}
+}
+
 func (wsp *WebSocketProxy) audit(msg []byte) {
 	tmsg := xtermMessage{}
 	_ = json.Unmarshal(msg, &tmsg)
@@ -99,6 +124,7 @@ func (wsp *WebSocketProxy) sourceRead() {
 			return
 		}
 		go wsp.audit(msg)
+		wsp.record(msg)
 
 		wsp.SourceChan <- Msg{msgtype, msg}
 	}
@@ -111,6 +137,9 @@ func (wsp *WebSocketProxy) targetRead() {
 			wsp.Done <- true
 			return
 		}
+		if wsp.Recorder != nil {
+			wsp.Recorder.Output(lmsg)
+		}
 		wsp.TargetChan <- Msg{lt, lmsg}
 	}
 }
@@ -128,6 +157,11 @@ func (wsp *WebSocketProxy) proxy() {
 			if e := wsp.Source.WriteMessage(msg.MsgType, msg.Content); e != nil {
 				wsp.Done <- true
 			}
+		case <-wsp.Truncated:
+			log.Warnf("session recording of %s exceeds the size limit, close the session", wsp.Username)
+			wsp.Target.Close()
+			wsp.Source.Close()
+			return
 		case <-wsp.Done:
 			wsp.Target.Close()
 			wsp.Source.Close()
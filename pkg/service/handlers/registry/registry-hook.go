@@ -23,6 +23,7 @@ import (
 	"kubegems.io/kubegems/pkg/service/handlers/registry/synchronizer"
 	"kubegems.io/kubegems/pkg/service/models"
 	"kubegems.io/kubegems/pkg/utils/harbor"
+	"kubegems.io/kubegems/pkg/utils/registry"
 )
 
 func (h *RegistryHandler) onChange(ctx context.Context, tx *gorm.DB, v *models.Registry) error {
@@ -65,7 +66,7 @@ func (h *RegistryHandler) validate(ctx context.Context, v *models.Registry) erro
 		}
 	}
 	// validate username/password
-	if err := harbor.TryLogin(ctx, v.RegistryAddress, v.Username, v.Password); err != nil {
+	if err := registry.TryLogin(ctx, v.RegistryAddress, v.Username, v.Password); err != nil {
 		return i18n.Errorf(ctx, "validate username and password to the registry faild: %w", err)
 	}
 	return nil
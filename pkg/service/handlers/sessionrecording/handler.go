@@ -0,0 +1,154 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sessionrecording
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/handlers/base"
+	"kubegems.io/kubegems/pkg/service/models"
+)
+
+type SessionRecordingHandler struct {
+	base.BaseHandler
+	Manager *Manager
+}
+
+func (h *SessionRecordingHandler) RegistRouter(rg *gin.RouterGroup) {
+	rg.GET("/sessionrecordings", h.CheckIsSysADMIN, h.ListSessionRecording)
+	rg.GET("/sessionrecordings/:id", h.CheckIsSysADMIN, h.RetrieveSessionRecording)
+	rg.GET("/sessionrecordings/:id/cast", h.CheckIsSysADMIN, h.ReplaySessionRecording)
+}
+
+// ListSessionRecording 终端会话录像列表
+// @Tags        SessionRecording
+// @Summary     终端会话录像列表
+// @Description 终端会话录像列表, 包含 pod shell, pod debug 以及 kubectl 终端
+// @Accept      json
+// @Produce     json
+// @Param       Username      query    string                                                                          false "Username"
+// @Param       Cluster       query    string                                                                          false "Cluster"
+// @Param       Namespace     query    string                                                                          false "Namespace"
+// @Param       Kind          query    string                                                                          false "shell, debug or kubectl"
+// @Param       SessionID     query    string                                                                          false "SessionID, 审计日志中的 recording 标签"
+// @Param       StartedAt_gte query    string                                                                          false "StartedAt_gte"
+// @Param       StartedAt_lte query    string                                                                          false "StartedAt_lte"
+// @Param       page          query    int                                                                             false "page"
+// @Param       size          query    int                                                                             false "page"
+// @Success     200           {object} handlers.ResponseStruct{Data=handlers.PageData{List=[]models.SessionRecording}} "SessionRecording"
+// @Router      /v1/sessionrecordings [get]
+// @Security    JWT
+func (h *SessionRecordingHandler) ListSessionRecording(c *gin.Context) {
+	var list []models.SessionRecording
+	query, err := handlers.GetQuery(c, nil)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	where := []*handlers.QArgs{}
+	for param, column := range map[string]string{
+		"Username":  "username",
+		"Cluster":   "cluster",
+		"Namespace": "namespace",
+		"Kind":      "kind",
+		"SessionID": "session_id",
+	} {
+		if value := c.Query(param); len(value) > 0 {
+			where = append(where, handlers.Args(column+" = ?", value))
+		}
+	}
+	if start := c.Query("StartedAt_gte"); len(start) > 0 {
+		where = append(where, handlers.Args("started_at > ?", start))
+	}
+	if end := c.Query("StartedAt_lte"); len(end) > 0 {
+		where = append(where, handlers.Args("started_at < ?", end))
+	}
+	cond := &handlers.PageQueryCond{
+		Model: "SessionRecording",
+		Where: where,
+	}
+	total, page, size, err := query.PageList(h.GetDB().Order("id DESC"), cond, &list)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, handlers.Page(total, list, page, size))
+}
+
+// RetrieveSessionRecording 终端会话录像详情
+// @Tags        SessionRecording
+// @Summary     终端会话录像详情
+// @Description 终端会话录像详情, id 可以为录像ID或者会话ID
+// @Accept      json
+// @Produce     json
+// @Param       id  path     string                                                true "id"
+// @Success     200 {object} handlers.ResponseStruct{Data=models.SessionRecording} "SessionRecording"
+// @Router      /v1/sessionrecordings/{id} [get]
+// @Security    JWT
+func (h *SessionRecordingHandler) RetrieveSessionRecording(c *gin.Context) {
+	record, err := h.getRecording(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, record)
+}
+
+// ReplaySessionRecording 回放终端会话录像
+// @Tags        SessionRecording
+// @Summary     回放终端会话录像
+// @Description 获取 asciicast v2 格式的录像内容, 可直接用于 asciinema-player 回放
+// @Produce     application/x-asciicast
+// @Param       id  path     string true "id"
+// @Success     200 {string} string "asciicast"
+// @Router      /v1/sessionrecordings/{id}/cast [get]
+// @Security    JWT
+func (h *SessionRecordingHandler) ReplaySessionRecording(c *gin.Context) {
+	if h.Manager == nil {
+		handlers.NotOK(c, i18n.Errorf(c, "session recording is not enabled"))
+		return
+	}
+	record, err := h.getRecording(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	content, err := h.Manager.Open(c.Request.Context(), record)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	defer content.Close()
+
+	c.Header("Content-Disposition", "inline; filename="+record.SessionID+".cast")
+	c.Header("Content-Length", strconv.FormatInt(record.Size, 10))
+	c.Header("Content-Type", "application/x-asciicast")
+	c.Status(http.StatusOK)
+	_, _ = io.Copy(c.Writer, content)
+}
+
+func (h *SessionRecordingHandler) getRecording(c *gin.Context) (*models.SessionRecording, error) {
+	record := &models.SessionRecording{}
+	id := c.Param("id")
+	if _, err := strconv.ParseUint(id, 10, 64); err == nil {
+		return record, h.GetDB().First(record, id).Error
+	}
+	return record, h.GetDB().First(record, "session_id = ?", id).Error
+}
@@ -0,0 +1,313 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sessionrecording
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/common/model"
+	"gorm.io/gorm"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/aaa/audit"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/service/models/cache"
+	"kubegems.io/kubegems/pkg/utils/recording"
+)
+
+const (
+	KindShell   = "shell"
+	KindDebug   = "debug"
+	KindKubectl = "kubectl"
+
+	retentionInterval = time.Hour
+	// 会话过程中保存录像的间隔
+	flushInterval = 10 * time.Second
+)
+
+// Manager 记录终端会话并保存录像, 未启用录像时为 nil
+type Manager struct {
+	DB        *gorm.DB
+	Store     recording.Store
+	Options   *recording.Options
+	retention time.Duration
+}
+
+func NewManager(ctx context.Context, db *gorm.DB, options *recording.Options) (*Manager, error) {
+	if options == nil || !options.Enabled {
+		return nil, nil
+	}
+	store, err := recording.NewStore(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manager{DB: db, Store: store, Options: options}
+	if options.Retention != "" {
+		retention, err := model.ParseDuration(options.Retention)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recording retention: %w", err)
+		}
+		m.retention = time.Duration(retention)
+	}
+	return m, nil
+}
+
+// SessionKind 需要录像的会话类型, pod 的 shell, debug 以及 kubectl 终端
+func SessionKind(obj *audit.ProxyObject) (string, bool) {
+	switch {
+	case obj.Resource == "pods" && obj.Action == KindShell:
+		return KindShell, true
+	case obj.Resource == "pods" && obj.Action == KindDebug:
+		return KindDebug, true
+	case obj.Group == "system" && obj.Resource == "kubectl":
+		return KindKubectl, true
+	default:
+		return "", false
+	}
+}
+
+type Session struct {
+	*recording.Recorder
+	Record models.SessionRecording
+
+	manager *Manager
+	// unsaved 保存失败的内容, 下次与新的内容一起保存
+	unsaved []byte
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// Required 开启录像时, 无法录像的会话是否需要拒绝
+func (m *Manager) Required() bool {
+	return m != nil && m.Options.Required
+}
+
+// Start 开始录像并创建录像记录, 录像内容在会话过程中分块保存; 非录像的会话类型返回 nil
+func (m *Manager) Start(ctx context.Context, username, ip, container string, obj *audit.ProxyObject, parents []cache.CommonResourceIface) (*Session, error) {
+	if m == nil {
+		return nil, nil
+	}
+	kind, ok := SessionKind(obj)
+	if !ok {
+		return nil, nil
+	}
+	record := models.SessionRecording{
+		SessionID: uuid.NewString(),
+		Username:  username,
+		ClientIP:  ip,
+		Kind:      kind,
+		Cluster:   obj.Cluster,
+		Namespace: obj.Namespace,
+		Container: container,
+	}
+	if kind != KindKubectl {
+		record.Pod = obj.Name
+	}
+	for _, p := range parents {
+		switch p.GetKind() {
+		case models.ResTenant:
+			record.Tenant = p.GetName()
+		case models.ResProject:
+			record.Project = p.GetName()
+		case models.ResEnvironment:
+			record.Environment = p.GetName()
+		}
+	}
+	title := fmt.Sprintf("%s %s/%s/%s %s", kind, record.Cluster, record.Namespace, record.Pod, username)
+	recorder := recording.NewRecorder(title, m.Options.MaxSize)
+	record.StartedAt = recorder.StartedAt()
+	record.EndedAt = record.StartedAt
+	record.StorageKey = path.Join(record.StartedAt.Format("2006/01/02"), record.SessionID)
+	if err := m.DB.WithContext(ctx).Create(&record).Error; err != nil {
+		return nil, err
+	}
+	session := &Session{
+		Recorder: recorder,
+		Record:   record,
+		manager:  m,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go session.run()
+	return session, nil
+}
+
+// run 定期或缓存满时保存录像内容
+func (s *Session) run() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	exceeded := s.Exceeded()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		case <-s.Full():
+		case <-exceeded:
+			// 截断后立即保存, 记录中标记为已截断
+			exceeded = nil
+		}
+		if err := s.flush(context.Background()); err != nil {
+			log.Error(err, "save session recording chunk", "session", s.Record.SessionID)
+		}
+	}
+}
+
+// flush 将缓存的内容保存为新的块并更新记录
+func (s *Session) flush(ctx context.Context) error {
+	s.unsaved = append(s.unsaved, s.Chunk()...)
+	if len(s.unsaved) == 0 {
+		return nil
+	}
+	key := chunkKey(s.Record.StorageKey, s.Record.Chunks)
+	if err := s.manager.Store.Put(ctx, key, bytes.NewReader(s.unsaved), int64(len(s.unsaved))); err != nil {
+		return err
+	}
+	record := &s.Record
+	record.Chunks++
+	record.Size += int64(len(s.unsaved))
+	record.Truncated = s.Truncated
+	record.Duration = s.Duration().Seconds()
+	record.EndedAt = record.StartedAt.Add(s.Duration())
+	s.unsaved = nil
+	return s.manager.DB.WithContext(ctx).Model(record).Updates(map[string]interface{}{
+		"chunks":    record.Chunks,
+		"size":      record.Size,
+		"truncated": record.Truncated,
+		"duration":  record.Duration,
+		"ended_at":  record.EndedAt,
+	}).Error
+}
+
+// Finish 结束录像, 保存剩余的内容
+func (m *Manager) Finish(ctx context.Context, session *Session) error {
+	session.Close()
+	close(session.done)
+	<-session.stopped
+	return session.flush(ctx)
+}
+
+// Open 读取完整的录像内容
+func (m *Manager) Open(ctx context.Context, record *models.SessionRecording) (io.ReadCloser, error) {
+	if record.Chunks == 0 {
+		return m.Store.Open(ctx, record.StorageKey)
+	}
+	return &chunkReader{ctx: ctx, store: m.Store, keys: recordingKeys(record)}, nil
+}
+
+// chunkReader 按顺序读取录像的各个块
+type chunkReader struct {
+	ctx     context.Context
+	store   recording.Store
+	keys    []string
+	current io.ReadCloser
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.keys) == 0 {
+				return 0, io.EOF
+			}
+			rc, err := r.store.Open(r.ctx, r.keys[0])
+			if err != nil {
+				return 0, err
+			}
+			r.current, r.keys = rc, r.keys[1:]
+		}
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
+
+func chunkKey(prefix string, i int) string {
+	return fmt.Sprintf("%s/%06d.cast", prefix, i)
+}
+
+// recordingKeys 录像内容的所有存储路径
+func recordingKeys(record *models.SessionRecording) []string {
+	if record.Chunks == 0 {
+		return []string{record.StorageKey}
+	}
+	keys := make([]string, 0, record.Chunks)
+	for i := 0; i < record.Chunks; i++ {
+		keys = append(keys, chunkKey(record.StorageKey, i))
+	}
+	return keys
+}
+
+// RunRetention 定期删除超过保留时间的录像
+func (m *Manager) RunRetention(ctx context.Context) error {
+	if m == nil || m.retention <= 0 {
+		return nil
+	}
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+	for {
+		if err := m.cleanup(ctx, time.Now().Add(-m.retention)); err != nil {
+			log.Error(err, "cleanup session recordings")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Manager) cleanup(ctx context.Context, before time.Time) error {
+	for {
+		records := []models.SessionRecording{}
+		if err := m.DB.WithContext(ctx).Where("started_at < ?", before).Order("id").Limit(100).Find(&records).Error; err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			return nil
+		}
+		ids := make([]uint, 0, len(records))
+		for i := range records {
+			for _, key := range recordingKeys(&records[i]) {
+				if err := m.Store.Delete(ctx, key); err != nil {
+					return err
+				}
+			}
+			ids = append(ids, records[i].ID)
+		}
+		if err := m.DB.WithContext(ctx).Where("id in ?", ids).Delete(&models.SessionRecording{}).Error; err != nil {
+			return err
+		}
+	}
+}
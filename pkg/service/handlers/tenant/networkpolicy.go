@@ -0,0 +1,304 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tenanthandler
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	gemlabels "kubegems.io/kubegems/pkg/apis/gems"
+	"kubegems.io/kubegems/pkg/apis/gems/v1beta1"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/agents"
+	"kubegems.io/kubegems/pkg/utils/netpol"
+	"kubegems.io/kubegems/pkg/utils/set"
+	"kubegems.io/kubegems/pkg/utils/slice"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// networkPolicySystemNamespaces 可以作为连通性检查源或目标的系统 namespace
+var networkPolicySystemNamespaces = []string{
+	gemlabels.NamespaceSystem,
+	gemlabels.NamespaceGateway,
+	gemlabels.NamespaceMonitor,
+	gemlabels.NamespaceLogging,
+	"kube-system",
+	"istio-system",
+}
+
+// NetworkPolicyMatrixForm 连通性矩阵参数, Proposed 不为空时使用其替换当前的租户网络策略进行模拟
+type NetworkPolicyMatrixForm struct {
+	// Level environment or workload
+	Level    string                           `json:"level"`
+	Proposed *v1beta1.TenantNetworkPolicySpec `json:"proposed"`
+}
+
+// NetworkPolicyEndpoint 访问的源或目标, Pod 为空时使用 Labels 在 Namespace 中构造一个虚拟 pod
+type NetworkPolicyEndpoint struct {
+	Namespace string            `json:"namespace" binding:"required"`
+	Pod       string            `json:"pod"`
+	Service   string            `json:"service"`
+	Labels    map[string]string `json:"labels"`
+}
+
+type NetworkPolicyCheckForm struct {
+	From     NetworkPolicyEndpoint            `json:"from" binding:"required"`
+	To       NetworkPolicyEndpoint            `json:"to" binding:"required"`
+	Port     intstr.IntOrString               `json:"port"`
+	Protocol v1.Protocol                      `json:"protocol"`
+	Proposed *v1beta1.TenantNetworkPolicySpec `json:"proposed"`
+}
+
+type NetworkPolicyCheckResult struct {
+	Allowed bool                      `json:"allowed"`
+	Reason  string                    `json:"reason"`
+	Pod     *netpol.Verdict           `json:"pod,omitempty"`
+	Service *netpol.ServiceVerdict    `json:"service,omitempty"`
+	Current *NetworkPolicyCheckResult `json:"current,omitempty"`
+}
+
+// @Tags        NetworkIsolated
+// @Summary     租户网络连通性矩阵
+// @Description 根据当前的租户网络策略和集群中的NetworkPolicy计算租户下环境(或工作负载)之间的连通性
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     uint                                               true  "tenant_id"
+// @Param       cluster_id path     uint                                               true  "cluster_id"
+// @Param       level      query    string                                             false "environment(默认) or workload"
+// @Success     200        {object} handlers.ResponseStruct{Data=netpol.Matrix} "object"
+// @Router      /v1/tenant/{tenant_id}/cluster/{cluster_id}/networkpolicy/matrix [get]
+// @Security    JWT
+func (h *TenantHandler) NetworkPolicyMatrix(c *gin.Context) {
+	h.networkPolicyMatrix(c, &NetworkPolicyMatrixForm{Level: c.Query("level")})
+}
+
+// @Tags        NetworkIsolated
+// @Summary     模拟租户网络策略变更后的连通性矩阵
+// @Description 使用提交的租户网络策略替换当前配置后计算连通性矩阵, 不会修改集群
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     uint                                               true "tenant_id"
+// @Param       cluster_id path     uint                                               true "cluster_id"
+// @Param       param      body     NetworkPolicyMatrixForm                            true "表单"
+// @Success     200        {object} handlers.ResponseStruct{Data=netpol.Matrix} "object"
+// @Router      /v1/tenant/{tenant_id}/cluster/{cluster_id}/networkpolicy/matrix [post]
+// @Security    JWT
+func (h *TenantHandler) SimulateNetworkPolicyMatrix(c *gin.Context) {
+	form := &NetworkPolicyMatrixForm{}
+	if err := c.BindJSON(form); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.networkPolicyMatrix(c, form)
+}
+
+func (h *TenantHandler) networkPolicyMatrix(c *gin.Context, form *NetworkPolicyMatrixForm) {
+	if form.Level == "" {
+		form.Level = netpol.LevelEnvironment
+	}
+	if !slice.ContainStr([]string{netpol.LevelEnvironment, netpol.LevelWorkload}, form.Level) {
+		handlers.NotOK(c, i18n.Errorf(c, "invalid level %s", form.Level))
+		return
+	}
+	tenant, cluster, err := h.getTenantAndCluster(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	ctx := c.Request.Context()
+	var matrix netpol.Matrix
+	err = h.Execute(ctx, cluster.ClusterName, func(ctx context.Context, cli agents.Client) error {
+		snapshot, tenantNamespaces, err := loadNetworkPolicySnapshot(ctx, cli, tenant.TenantName, nil, form.Proposed)
+		if err != nil {
+			return err
+		}
+		matrix = snapshot.ConnectivityMatrix(tenantNamespaces, form.Level)
+		return nil
+	})
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, matrix)
+}
+
+// @Tags        NetworkIsolated
+// @Summary     检查网络连通性
+// @Description 判断pod(或指定标签的虚拟pod)能否访问目标pod或service的端口, 并给出放行或拒绝的规则, 提交proposed时同时给出当前配置下的结果
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     uint                                                         true "tenant_id"
+// @Param       cluster_id path     uint                                                         true "cluster_id"
+// @Param       param      body     NetworkPolicyCheckForm                                       true "表单"
+// @Success     200        {object} handlers.ResponseStruct{Data=NetworkPolicyCheckResult} "object"
+// @Router      /v1/tenant/{tenant_id}/cluster/{cluster_id}/networkpolicy/check [post]
+// @Security    JWT
+func (h *TenantHandler) CheckNetworkPolicy(c *gin.Context) {
+	form := &NetworkPolicyCheckForm{}
+	if err := c.BindJSON(form); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if form.To.Service == "" && form.Port.Type == intstr.Int && form.Port.IntVal == 0 {
+		form.Port = netpol.AnyPort
+	}
+	tenant, cluster, err := h.getTenantAndCluster(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	var result *NetworkPolicyCheckResult
+	err = h.Execute(ctx, cluster.ClusterName, func(ctx context.Context, cli agents.Client) error {
+		namespaces := []string{form.From.Namespace, form.To.Namespace}
+		snapshot, _, err := loadNetworkPolicySnapshot(ctx, cli, tenant.TenantName, namespaces, form.Proposed)
+		if err != nil {
+			return err
+		}
+		if result, err = checkNetworkPolicy(snapshot, form); err != nil {
+			return err
+		}
+		if form.Proposed != nil {
+			current, _, err := loadNetworkPolicySnapshot(ctx, cli, tenant.TenantName, namespaces, nil)
+			if err != nil {
+				return err
+			}
+			if result.Current, err = checkNetworkPolicy(current, form); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, result)
+}
+
+func checkNetworkPolicy(snapshot *netpol.Cluster, form *NetworkPolicyCheckForm) (*NetworkPolicyCheckResult, error) {
+	src, err := networkPolicyEndpointPod(snapshot, form.From)
+	if err != nil {
+		return nil, err
+	}
+	if form.To.Service != "" {
+		svc := snapshot.Service(form.To.Namespace, form.To.Service)
+		if svc == nil {
+			return nil, kerrors.NewNotFound(v1.Resource("services"), form.To.Service)
+		}
+		v := snapshot.CanReachService(src, svc, form.Port.IntVal)
+		return &NetworkPolicyCheckResult{Allowed: v.Allowed, Reason: v.Reason, Service: &v}, nil
+	}
+	dst, err := networkPolicyEndpointPod(snapshot, form.To)
+	if err != nil {
+		return nil, err
+	}
+	v := snapshot.CanReach(src, dst, form.Port, form.Protocol)
+	return &NetworkPolicyCheckResult{Allowed: v.Allowed, Reason: v.Reason, Pod: &v}, nil
+}
+
+func networkPolicyEndpointPod(snapshot *netpol.Cluster, ep NetworkPolicyEndpoint) (*v1.Pod, error) {
+	if ep.Pod == "" {
+		pod := &v1.Pod{}
+		pod.Namespace, pod.Name, pod.Labels = ep.Namespace, "*", ep.Labels
+		return pod, nil
+	}
+	if pod := snapshot.Pod(ep.Namespace, ep.Pod); pod != nil {
+		return pod, nil
+	}
+	return nil, kerrors.NewNotFound(v1.Resource("pods"), ep.Pod)
+}
+
+func (h *TenantHandler) getTenantAndCluster(c *gin.Context) (*models.Tenant, *models.Cluster, error) {
+	tenant := &models.Tenant{}
+	if err := h.GetDB().First(tenant, "id = ?", c.Param(PrimaryKeyName)).Error; err != nil {
+		return nil, nil, err
+	}
+	cluster := &models.Cluster{}
+	if err := h.GetDB().Select("id, cluster_name").First(cluster, "id = ?", c.Param("cluster_id")).Error; err != nil {
+		return nil, nil, err
+	}
+	return tenant, cluster, nil
+}
+
+// loadNetworkPolicySnapshot 读取集群中计算连通性需要的资源, pod 和 service 只读取租户的 namespace 以及 extraNamespaces
+// proposed 不为空时使用其生成的 NetworkPolicy 替换当前租户网络策略生成的 NetworkPolicy
+func loadNetworkPolicySnapshot(ctx context.Context, cli agents.Client, tenant string, extraNamespaces []string,
+	proposed *v1beta1.TenantNetworkPolicySpec,
+) (*netpol.Cluster, []string, error) {
+	snapshot := &netpol.Cluster{}
+	nslist := &v1.NamespaceList{}
+	if err := cli.List(ctx, nslist); err != nil {
+		return nil, nil, err
+	}
+	snapshot.Namespaces = nslist.Items
+
+	tenantNamespaces := []string{}
+	for _, ns := range nslist.Items {
+		if ns.Labels[gemlabels.LabelTenant] == tenant && ns.Labels[gemlabels.LabelEnvironment] != "" {
+			tenantNamespaces = append(tenantNamespaces, ns.Name)
+		}
+	}
+	// 只允许检查本租户以及系统的 namespace, 避免泄露其他租户的 pod, service 和网络策略
+	for _, ns := range extraNamespaces {
+		if !slice.ContainStr(tenantNamespaces, ns) && !slice.ContainStr(networkPolicySystemNamespaces, ns) {
+			return nil, nil, i18n.Errorf(ctx, "namespace %s does not belong to tenant %s", ns, tenant)
+		}
+	}
+	for _, ns := range set.NewSet[string]().Append(tenantNamespaces...).Append(extraNamespaces...).Remove("").Slice() {
+		podlist := &v1.PodList{}
+		if err := cli.List(ctx, podlist, client.InNamespace(ns)); err != nil {
+			return nil, nil, err
+		}
+		for _, pod := range podlist.Items {
+			if pod.Status.Phase == v1.PodRunning || pod.Status.Phase == v1.PodPending {
+				snapshot.Pods = append(snapshot.Pods, pod)
+			}
+		}
+		svclist := &v1.ServiceList{}
+		if err := cli.List(ctx, svclist, client.InNamespace(ns)); err != nil {
+			return nil, nil, err
+		}
+		snapshot.Services = append(snapshot.Services, svclist.Items...)
+	}
+
+	nplist := &networkingv1.NetworkPolicyList{}
+	if err := cli.List(ctx, nplist); err != nil {
+		return nil, nil, err
+	}
+	snapshot.Policies = nplist.Items
+
+	if proposed != nil {
+		spec := *proposed
+		spec.Tenant = tenant
+		nodelist := &v1.NodeList{}
+		if err := cli.List(ctx, nodelist); err != nil {
+			return nil, nil, err
+		}
+		cidrs := []string{}
+		for _, node := range nodelist.Items {
+			cidrs = append(cidrs, node.Spec.PodCIDRs...)
+		}
+		snapshot.Policies = netpol.ApplyTenantNetworkPolicy(snapshot.Policies, spec, snapshot.Namespaces, cidrs)
+	}
+	return snapshot, tenantNamespaces, nil
+}
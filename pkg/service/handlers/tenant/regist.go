@@ -64,4 +64,8 @@ func (h *TenantHandler) RegistRouter(rg *gin.RouterGroup) {
 	rg.PUT("/tenant/:tenant_id/cluster/:cluster_id/tenantgateways/:name", h.CheckByTenantID, h.UpdateTenantGateway)
 	rg.DELETE("/tenant/:tenant_id/cluster/:cluster_id/tenantgateways/:name", h.CheckByTenantID, h.DeleteTenantGateway)
 	rg.GET("/tenant/:tenant_id/cluster/:cluster_id/tenantgateways/:name/addresses", h.CheckByTenantID, h.GetObjectTenantGatewayAddr)
+
+	rg.GET("/tenant/:tenant_id/cluster/:cluster_id/networkpolicy/matrix", h.CheckByTenantID, h.NetworkPolicyMatrix)
+	rg.POST("/tenant/:tenant_id/cluster/:cluster_id/networkpolicy/matrix", h.CheckByTenantID, h.SimulateNetworkPolicyMatrix)
+	rg.POST("/tenant/:tenant_id/cluster/:cluster_id/networkpolicy/check", h.CheckByTenantID, h.CheckNetworkPolicy)
 }
@@ -1503,8 +1503,11 @@ func (h *TenantHandler) getGateway(ctx context.Context, cluster string, name str
 	return gateway, err
 }
 
-func (h *TenantHandler) createGateway(ctx context.Context, cluster string, gateway *v1beta1.TenantGateway) error {
+func (h *TenantHandler) createGateway(ctx context.Context, cluster, tenant string, gateway *v1beta1.TenantGateway) error {
 	return h.Execute(ctx, cluster, func(ctx context.Context, cli agents.Client) error {
+		if err := validateGatewayCertificates(ctx, cli, tenant, gateway); err != nil {
+			return err
+		}
 		dep := appsv1.Deployment{}
 		err := cli.Get(ctx, types.NamespacedName{
 			Namespace: gemlabels.NamespaceGateway,
@@ -1521,12 +1524,42 @@ func (h *TenantHandler) createGateway(ctx context.Context, cluster string, gatew
 	})
 }
 
-func (h *TenantHandler) updateGateway(ctx context.Context, cluster string, gateway *v1beta1.TenantGateway) error {
+func (h *TenantHandler) updateGateway(ctx context.Context, cluster, tenant string, gateway *v1beta1.TenantGateway) error {
 	return h.Execute(ctx, cluster, func(ctx context.Context, cli agents.Client) error {
+		if err := validateGatewayCertificates(ctx, cli, tenant, gateway); err != nil {
+			return err
+		}
 		return cli.Update(ctx, gateway)
 	})
 }
 
+// validateGatewayCertificates 网关证书只能引用网关namespace或者租户自己namespace中的secret
+func validateGatewayCertificates(ctx context.Context, cli agents.Client, tenant string, gateway *v1beta1.TenantGateway) error {
+	if gateway.Spec.TLS == nil {
+		return nil
+	}
+	secrets := []string{gateway.Spec.TLS.DefaultCertificate}
+	for _, host := range gateway.Spec.TLS.Hosts {
+		if !host.ACME {
+			secrets = append(secrets, host.SecretName)
+		}
+	}
+	for _, secret := range secrets {
+		namespace, _, ok := strings.Cut(secret, "/")
+		if !ok || namespace == gemlabels.NamespaceGateway {
+			continue
+		}
+		ns := &v1.Namespace{}
+		if err := cli.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+			return err
+		}
+		if ns.Labels[gemlabels.LabelTenant] != tenant {
+			return i18n.Errorf(ctx, "certificate secret %s is not in the gateway namespace or namespaces of tenant %s", secret, tenant)
+		}
+	}
+	return nil
+}
+
 func (h *TenantHandler) deleteGateway(ctx context.Context, cluster string, name string) error {
 	return h.Execute(ctx, cluster, func(ctx context.Context, cli agents.Client) error {
 		return cli.Delete(ctx, &v1beta1.TenantGateway{
@@ -1622,7 +1655,7 @@ func (h *TenantHandler) CreateTenantGateway(c *gin.Context) {
 	h.SetExtraAuditData(c, models.ResTenant, tenant.ID)
 	ctx := c.Request.Context()
 
-	if err := h.createGateway(ctx, cluster.ClusterName, &tg); err != nil {
+	if err := h.createGateway(ctx, cluster.ClusterName, tenant.TenantName, &tg); err != nil {
 		handlers.NotOK(c, err)
 		return
 	}
@@ -1666,6 +1699,7 @@ func (h *TenantHandler) UpdateTenantGateway(c *gin.Context) {
 	action := i18n.Sprintf(context.TODO(), "update")
 	module := i18n.Sprintf(context.TODO(), "cluster tenant gateway")
 	h.SetAuditData(c, action, module, i18n.Sprintf(c, "tenant %s / cluster %s", tg.Spec.Tenant, cluster.ClusterName))
+	tenantName := tg.Spec.Tenant
 	if tg.Name != defaultGatewayName {
 		tenantid, _ := strconv.Atoi(c.Param("tenant_id"))
 		tenant := models.Tenant{ID: uint(tenantid)}
@@ -1674,9 +1708,10 @@ func (h *TenantHandler) UpdateTenantGateway(c *gin.Context) {
 			return
 		}
 		h.SetExtraAuditData(c, models.ResTenant, tenant.ID)
+		tenantName = tenant.TenantName
 	}
 
-	err := h.updateGateway(ctx, cluster.ClusterName, &tg)
+	err := h.updateGateway(ctx, cluster.ClusterName, tenantName, &tg)
 	if err != nil {
 		handlers.NotOK(c, err)
 		return
@@ -147,7 +147,9 @@ func initBaseData(db *gorm.DB) error {
 func migrateModels(db *gorm.DB) error {
 	return db.AutoMigrate(
 		// 审计表
-		&AuditLog{},
+		&AuditLog{}, &AuditLogChain{},
+		// 终端会话录像表
+		&SessionRecording{},
 		// 用户表
 		&User{}, &UserToken{},
 		// 系统角色表
@@ -176,6 +178,10 @@ func migrateModels(db *gorm.DB) error {
 		&LogQueryHistory{},
 		// 日志查询快照表
 		&LogQuerySnapshot{},
+		// 日志导出任务表
+		&LogExportJob{},
+		// 定时日志报告表
+		&LogReport{},
 		// workload 资源建议表
 		&Workload{},
 		// 容器资源建议表
@@ -184,6 +190,36 @@ func migrateModels(db *gorm.DB) error {
 		&TenantResourceQuotaApply{},
 		// ??
 		&EnvironmentResource{},
+		// 集群资源单价表
+		&ClusterPrice{},
+		// 环境每日费用表
+		&EnvironmentCost{},
+		// workload每日费用表
+		&WorkloadCost{},
+		// workload资源规格建议表
+		&ResourceRecommendation{},
+		// 环境晋级流水线表
+		&PromotionPipeline{},
+		// 环境晋级记录表
+		&PromotionHistory{},
+		// 项目外部git仓库表
+		&ProjectGitRepository{},
+		// 环境编排审核策略表
+		&ManifestReviewPolicy{},
+		// 编排变更请求表
+		&ManifestChangeRequest{},
+		// 编排变更请求评论表
+		&ManifestChangeComment{},
+		// 应用同步策略表
+		&SyncPolicy{},
+		// 环境编排漂移检测记录表
+		&EnvironmentDrift{},
+		// 应用商店应用升级记录表
+		&AppstoreUpgrade{},
+		// 环境镜像部署策略表
+		&ImagePolicy{},
+		// 越过镜像策略部署记录表
+		&ImagePolicyOverride{},
 		// 消息表
 		&Message{},
 		// 用户消息表
@@ -204,6 +240,8 @@ func migrateModels(db *gorm.DB) error {
 		&AlertChannel{},
 		// 监控面板表
 		&MonitorDashboard{}, &MonitorDashboardTpl{},
+		// 服务等级目标表
+		&SLO{},
 		// 登陆源
 		&AuthSource{},
 		// promql templates
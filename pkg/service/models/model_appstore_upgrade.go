@@ -0,0 +1,38 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import "time"
+
+// AppstoreUpgrade 应用商店应用的升级策略及仓库中可用的新版本
+type AppstoreUpgrade struct {
+	ID              uint         `gorm:"primarykey"`
+	EnvironmentID   uint         `gorm:"uniqueIndex:uniq_idx_env_app"`
+	Environment     *Environment `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	ApplicationName string       `gorm:"type:varchar(255);uniqueIndex:uniq_idx_env_app"`
+	RepoURL         string
+	Chart           string `gorm:"type:varchar(255)"`
+	CurrentVersion  string `gorm:"type:varchar(50)"`
+	// VersionConstraint 允许升级的版本范围, semver 约束, 如 ~6.2 只升级 6.2.x; 为空时为任意更新的版本
+	VersionConstraint string `gorm:"type:varchar(100)"`
+	// Pinned 固定当前版本, 不检查升级也不参与批量升级
+	Pinned bool
+	// AvailableVersion 满足约束的最新版本, 为空时没有可用的升级
+	AvailableVersion string `gorm:"type:varchar(50)"`
+	// NotifiedVersion 最近一次通知的可升级版本, 同一版本只通知一次
+	NotifiedVersion string `gorm:"type:varchar(50)"`
+	CheckedAt       *time.Time
+	UpdatedAt       time.Time
+}
@@ -51,4 +51,20 @@ type AuditLog struct {
 	Labels datatypes.JSON
 	// 原始数据 记录的是request和response以及http_code
 	RawData datatypes.JSON
+	// 上一条审计日志的哈希, 用于检测日志被删除
+	PrevHash string `gorm:"type:varchar(64)"`
+	// 本条审计日志内容及 PrevHash 的哈希, 用于检测日志被修改
+	Hash string `gorm:"type:varchar(64);index"`
+}
+
+// AuditLogChain 审计日志哈希链的状态, 仅有一条记录, 写入审计日志时加锁保证哈希链有序
+type AuditLogChain struct {
+	ID uint `gorm:"primarykey"`
+	// 最新一条审计日志
+	LastID   uint
+	LastHash string `gorm:"type:varchar(64)"`
+	// 已归档(导出至文件并删除)的最后一条审计日志, 校验时从此处开始
+	ArchivedID   uint
+	ArchivedHash string `gorm:"type:varchar(64)"`
+	UpdatedAt    time.Time
 }
@@ -0,0 +1,163 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"time"
+
+	"kubegems.io/kubegems/pkg/utils"
+)
+
+const DefaultCurrency = "CNY"
+
+// ClusterPrice 集群资源单价, 按小时计价
+type ClusterPrice struct {
+	ID        uint     `gorm:"primarykey"`
+	ClusterID uint     `gorm:"uniqueIndex"`
+	Cluster   *Cluster `gorm:"constraint:OnUpdate:RESTRICT,OnDelete:CASCADE;" binding:"-"`
+	// 币种
+	Currency string `gorm:"type:varchar(16);default:CNY"`
+	// 每核每小时
+	CPUCoreHour float64 `binding:"gte=0"`
+	// 每GiB内存每小时
+	MemoryGiBHour float64 `binding:"gte=0"`
+	// 每GiB存储每小时
+	StorageGiBHour float64 `binding:"gte=0"`
+	// 每卡每小时
+	GPUHour   float64 `binding:"gte=0"`
+	UpdatedAt time.Time
+}
+
+// ResourceAmount 资源量, 内存与存储单位为byte
+type ResourceAmount struct {
+	CPUCore     float64
+	MemoryByte  float64
+	StorageByte float64
+	GPU         float64
+}
+
+func (a ResourceAmount) Add(o ResourceAmount) ResourceAmount {
+	return ResourceAmount{
+		CPUCore:     a.CPUCore + o.CPUCore,
+		MemoryByte:  a.MemoryByte + o.MemoryByte,
+		StorageByte: a.StorageByte + o.StorageByte,
+		GPU:         a.GPU + o.GPU,
+	}
+}
+
+// ResourceCost 资源费用
+type ResourceCost struct {
+	CPU     float64
+	Memory  float64
+	Storage float64
+	GPU     float64
+	Total   float64
+}
+
+func (c ResourceCost) Add(o ResourceCost) ResourceCost {
+	return ResourceCost{
+		CPU:     utils.RoundTo(c.CPU+o.CPU, 4),
+		Memory:  utils.RoundTo(c.Memory+o.Memory, 4),
+		Storage: utils.RoundTo(c.Storage+o.Storage, 4),
+		GPU:     utils.RoundTo(c.GPU+o.GPU, 4),
+		Total:   utils.RoundTo(c.Total+o.Total, 4),
+	}
+}
+
+// DailyCost 按一天内的平均资源量计算当天费用
+func (p *ClusterPrice) DailyCost(amount ResourceAmount) ResourceCost {
+	const hours = 24
+	cost := ResourceCost{
+		CPU:     utils.RoundTo(amount.CPUCore*p.CPUCoreHour*hours, 4),
+		Memory:  utils.RoundTo(amount.MemoryByte/Gi*p.MemoryGiBHour*hours, 4),
+		Storage: utils.RoundTo(amount.StorageByte/Gi*p.StorageGiBHour*hours, 4),
+		GPU:     utils.RoundTo(amount.GPU*p.GPUHour*hours, 4),
+	}
+	cost.Total = utils.RoundTo(cost.CPU+cost.Memory+cost.Storage+cost.GPU, 4)
+	return cost
+}
+
+// EnvironmentCost 环境每日费用, 分别按申请量(requests)与实际使用量计算
+type EnvironmentCost struct {
+	ID uint `gorm:"primarykey"`
+	// 统计日期, 当天0点
+	Date            time.Time `gorm:"index"`
+	ClusterName     string    `gorm:"type:varchar(50)"`
+	TenantName      string    `gorm:"type:varchar(50);index"`
+	ProjectName     string    `gorm:"type:varchar(50)"`
+	EnvironmentName string    `gorm:"type:varchar(50)"`
+	Currency        string    `gorm:"type:varchar(16)"`
+
+	Request     ResourceAmount `gorm:"embedded;embeddedPrefix:request_"`
+	Usage       ResourceAmount `gorm:"embedded;embeddedPrefix:usage_"`
+	RequestCost ResourceCost   `gorm:"embedded;embeddedPrefix:request_cost_"`
+	UsageCost   ResourceCost   `gorm:"embedded;embeddedPrefix:usage_cost_"`
+}
+
+// WorkloadCost workload每日费用, 存储费用只统计到环境
+type WorkloadCost struct {
+	ID uint `gorm:"primarykey"`
+	// 统计日期, 当天0点
+	Date            time.Time `gorm:"index"`
+	ClusterName     string    `gorm:"type:varchar(50)"`
+	TenantName      string    `gorm:"type:varchar(50);index"`
+	ProjectName     string    `gorm:"type:varchar(50)"`
+	EnvironmentName string    `gorm:"type:varchar(50)"`
+	Namespace       string    `gorm:"type:varchar(50)"`
+	Type            string    `gorm:"type:varchar(50)"`
+	Name            string    `gorm:"type:varchar(255)"`
+	Currency        string    `gorm:"type:varchar(16)"`
+
+	Request     ResourceAmount `gorm:"embedded;embeddedPrefix:request_"`
+	Usage       ResourceAmount `gorm:"embedded;embeddedPrefix:usage_"`
+	RequestCost ResourceCost   `gorm:"embedded;embeddedPrefix:request_cost_"`
+	UsageCost   ResourceCost   `gorm:"embedded;embeddedPrefix:usage_cost_"`
+}
+
+// CostSummary 月度费用汇总, 不参与数据库迁移
+type CostSummary struct {
+	Month       string // eg. 2022-06
+	TenantName  string
+	ProjectName string `json:",omitempty"` // 按租户汇总时为空
+	Currency    string
+	Days        int // 有费用记录的天数
+	RequestCost ResourceCost
+	UsageCost   ResourceCost
+}
+
+// MonthlyCostSummary 将环境每日费用按月汇总到租户或项目
+func MonthlyCostSummary(costs []EnvironmentCost, byProject bool) []*CostSummary {
+	type key struct{ month, tenant, project, currency string }
+	index := map[key]*CostSummary{}
+	days := map[key]map[string]bool{}
+	ret := []*CostSummary{}
+	for _, cost := range costs {
+		k := key{month: cost.Date.Format("2006-01"), tenant: cost.TenantName, currency: cost.Currency}
+		if byProject {
+			k.project = cost.ProjectName
+		}
+		summary, ok := index[k]
+		if !ok {
+			summary = &CostSummary{Month: k.month, TenantName: k.tenant, ProjectName: k.project, Currency: k.currency}
+			index[k], days[k] = summary, map[string]bool{}
+			ret = append(ret, summary)
+		}
+		days[k][cost.Date.Format("2006-01-02")] = true
+		summary.Days = len(days[k])
+		summary.RequestCost = summary.RequestCost.Add(cost.RequestCost)
+		summary.UsageCost = summary.UsageCost.Add(cost.UsageCost)
+	}
+	return ret
+}
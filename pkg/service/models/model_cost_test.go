@@ -0,0 +1,90 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClusterPrice_DailyCost(t *testing.T) {
+	price := &ClusterPrice{CPUCoreHour: 0.1, MemoryGiBHour: 0.05, StorageGiBHour: 0.001, GPUHour: 2}
+	tests := []struct {
+		name   string
+		amount ResourceAmount
+		want   ResourceCost
+	}{
+		{
+			name:   "empty",
+			amount: ResourceAmount{},
+			want:   ResourceCost{},
+		},
+		{
+			name:   "all",
+			amount: ResourceAmount{CPUCore: 2, MemoryByte: 4 * Gi, StorageByte: 100 * Gi, GPU: 1},
+			want:   ResourceCost{CPU: 4.8, Memory: 4.8, Storage: 2.4, GPU: 48, Total: 60},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := price.DailyCost(tt.amount); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ClusterPrice.DailyCost() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMonthlyCostSummary(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+	costs := []EnvironmentCost{
+		{Date: day("2022-06-01"), TenantName: "t1", ProjectName: "p1", EnvironmentName: "dev", Currency: "CNY", RequestCost: ResourceCost{CPU: 1, Total: 1}, UsageCost: ResourceCost{CPU: 0.5, Total: 0.5}},
+		{Date: day("2022-06-01"), TenantName: "t1", ProjectName: "p1", EnvironmentName: "prod", Currency: "CNY", RequestCost: ResourceCost{CPU: 2, Total: 2}},
+		{Date: day("2022-06-02"), TenantName: "t1", ProjectName: "p2", EnvironmentName: "dev", Currency: "CNY", RequestCost: ResourceCost{GPU: 3, Total: 3}},
+		{Date: day("2022-07-01"), TenantName: "t1", ProjectName: "p1", EnvironmentName: "dev", Currency: "CNY", RequestCost: ResourceCost{CPU: 1, Total: 1}},
+	}
+	tests := []struct {
+		name      string
+		byProject bool
+		want      []*CostSummary
+	}{
+		{
+			name: "by tenant",
+			want: []*CostSummary{
+				{Month: "2022-06", TenantName: "t1", Currency: "CNY", Days: 2, RequestCost: ResourceCost{CPU: 3, GPU: 3, Total: 6}, UsageCost: ResourceCost{CPU: 0.5, Total: 0.5}},
+				{Month: "2022-07", TenantName: "t1", Currency: "CNY", Days: 1, RequestCost: ResourceCost{CPU: 1, Total: 1}},
+			},
+		},
+		{
+			name:      "by project",
+			byProject: true,
+			want: []*CostSummary{
+				{Month: "2022-06", TenantName: "t1", ProjectName: "p1", Currency: "CNY", Days: 1, RequestCost: ResourceCost{CPU: 3, Total: 3}, UsageCost: ResourceCost{CPU: 0.5, Total: 0.5}},
+				{Month: "2022-06", TenantName: "t1", ProjectName: "p2", Currency: "CNY", Days: 1, RequestCost: ResourceCost{GPU: 3, Total: 3}},
+				{Month: "2022-07", TenantName: "t1", ProjectName: "p1", Currency: "CNY", Days: 1, RequestCost: ResourceCost{CPU: 1, Total: 1}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MonthlyCostSummary(costs, tt.byProject); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MonthlyCostSummary() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// EnvironmentDrift 环境最近一次漂移检测的结果, 用于判断是否出现了新的漂移
+type EnvironmentDrift struct {
+	ID            uint         `gorm:"primarykey"`
+	EnvironmentID uint         `gorm:"uniqueIndex"`
+	Environment   *Environment `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	// Resources 漂移的资源, 格式为 application/group/kind/namespace/name
+	Resources     DriftResources
+	OutOfSync     int
+	ManualChanges int
+	CheckedAt     time.Time
+	// NotifiedAt 最近一次发送漂移通知的时间
+	NotifiedAt *time.Time
+}
+
+type DriftResources []string
+
+func (d *DriftResources) Scan(value interface{}) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New(fmt.Sprint("Failed to unmarshal JSONB value:", value))
+	}
+	result := DriftResources{}
+	err := json.Unmarshal(bytes, &result)
+	*d = result
+	return err
+}
+
+func (d DriftResources) Value() (driver.Value, error) {
+	if d == nil {
+		return json.Marshal([]string{})
+	}
+	return json.Marshal(d)
+}
+
+func (DriftResources) GormDataType() string {
+	return "json"
+}
+
+// Added 返回 current 中有而 d 中没有的资源
+func (d DriftResources) Added(current []string) []string {
+	exists := make(map[string]struct{}, len(d))
+	for _, r := range d {
+		exists[r] = struct{}{}
+	}
+	added := []string{}
+	for _, r := range current {
+		if _, ok := exists[r]; !ok {
+			added = append(added, r)
+		}
+	}
+	return added
+}
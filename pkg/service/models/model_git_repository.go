@@ -0,0 +1,41 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import "time"
+
+// ProjectGitRepository 项目应用编排所在的外部git仓库, 未设置时使用内置的 gitea
+type ProjectGitRepository struct {
+	ID        uint     `gorm:"primarykey"`
+	ProjectID uint     `gorm:"uniqueIndex"`
+	Project   *Project `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	// Kind gitea/gitlab/github/generic
+	Kind string `gorm:"type:varchar(20)" binding:"required,oneof=gitea gitlab github generic"`
+	Addr string `gorm:"type:varchar(255)"`
+	// Org 仓库所在的组织, gitlab 中为 group 的完整路径
+	Org  string `gorm:"type:varchar(255)" binding:"required"`
+	Repo string `gorm:"type:varchar(255)" binding:"required"`
+	// Branch 存放基础编排的分支, 为空时使用 base; 各环境仍使用与环境同名的分支
+	Branch   string `gorm:"type:varchar(255)"`
+	Username string `gorm:"type:varchar(255)"`
+	// Password 加密存储, 不返回给前端
+	Password    string `gorm:"type:varchar(512)" json:"-"`
+	HasPassword bool   `gorm:"-" json:"hasPassword"`
+	// WebhookSecret 仓库 push webhook 的校验密钥, 加密存储, 仅在设置仓库时返回
+	WebhookSecret string `gorm:"type:varchar(512)" json:"-"`
+	Creator       string
+	CreatedAt     time.Time `sql:"DEFAULT:'current_timestamp'"`
+	UpdatedAt     time.Time
+}
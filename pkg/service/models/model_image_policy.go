@@ -0,0 +1,120 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	ImagePolicyRuleSeverity      = "severity"
+	ImagePolicyRuleUnpublishable = "unpublishable"
+	ImagePolicyRuleSignature     = "signature"
+	// ImagePolicyRuleDigest 无法解析镜像 digest, 镜像需要固定为检查时的 digest
+	ImagePolicyRuleDigest = "digest"
+)
+
+// ImagePolicy 环境的镜像部署策略, 提交及同步编排前检查, 不符合时拒绝部署
+type ImagePolicy struct {
+	ID            uint         `gorm:"primarykey"`
+	EnvironmentID uint         `gorm:"uniqueIndex"`
+	Environment   *Environment `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	Enabled       bool
+	// MaxSeverity 允许的最高漏洞等级, 为空时不检查; 设置后未扫描或无法获取扫描结果的镜像也会被拒绝
+	MaxSeverity string `gorm:"type:varchar(20)" binding:"omitempty,oneof=None Unknown Negligible Low Medium High Critical"`
+	// BlockUnpublishable 拒绝在 harbor 中被标记为不可发布的镜像
+	BlockUnpublishable bool
+	// RequireSignature 镜像需要有 PublicKeys 中任一公钥的 cosign 签名
+	RequireSignature bool
+	// PublicKeys PEM 格式的 cosign 公钥
+	PublicKeys StringList
+	Creator    string
+	CreatedAt  time.Time `sql:"DEFAULT:'current_timestamp'"`
+	UpdatedAt  time.Time
+}
+
+// ImagePolicyOverride 管理员越过镜像策略部署的记录
+type ImagePolicyOverride struct {
+	ID              uint         `gorm:"primarykey"`
+	EnvironmentID   uint         `gorm:"index"`
+	Environment     *Environment `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	ApplicationName string       `gorm:"type:varchar(255)"`
+	// Images 越过策略的镜像, 包含越过时解析的 digest, 仅对该 digest 生效
+	Images     StringList
+	Violations ImagePolicyViolations
+	Reason     string
+	Operator   string
+	// ExpiresAt 过期后需要重新越过
+	ExpiresAt *time.Time `gorm:"index"`
+	CreatedAt time.Time  `sql:"DEFAULT:'current_timestamp'"`
+}
+
+type ImagePolicyViolation struct {
+	Image  string
+	Rule   string
+	Reason string
+}
+
+type StringList []string
+
+func (s *StringList) Scan(value interface{}) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New(fmt.Sprint("Failed to unmarshal JSONB value:", value))
+	}
+	result := StringList{}
+	err := json.Unmarshal(bytes, &result)
+	*s = result
+	return err
+}
+
+func (s StringList) Value() (driver.Value, error) {
+	if s == nil {
+		return json.Marshal([]string{})
+	}
+	return json.Marshal(s)
+}
+
+func (StringList) GormDataType() string {
+	return "json"
+}
+
+type ImagePolicyViolations []ImagePolicyViolation
+
+func (v *ImagePolicyViolations) Scan(value interface{}) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New(fmt.Sprint("Failed to unmarshal JSONB value:", value))
+	}
+	result := ImagePolicyViolations{}
+	err := json.Unmarshal(bytes, &result)
+	*v = result
+	return err
+}
+
+func (v ImagePolicyViolations) Value() (driver.Value, error) {
+	if v == nil {
+		return json.Marshal([]ImagePolicyViolation{})
+	}
+	return json.Marshal(v)
+}
+
+func (ImagePolicyViolations) GormDataType() string {
+	return "json"
+}
@@ -125,3 +125,38 @@ func (snapshot *LogQuerySnapshot) BeforeDelete(tx *gorm.DB) error {
 	}
 	return nil
 }
+
+const (
+	LogExportJobPending = "Pending"
+	LogExportJobRunning = "Running"
+	LogExportJobSuccess = "Success"
+	LogExportJobFailed  = "Failed"
+)
+
+// LogExportJob 异步日志导出任务
+type LogExportJob struct {
+	ID        uint   `gorm:"primarykey"`
+	Cluster   string `gorm:"type:varchar(50);index"`
+	LogQL     string `gorm:"type:varchar(1024)"`
+	StartTime time.Time
+	EndTime   time.Time
+	// 导出格式 log, ndjson, csv
+	Format   string `gorm:"type:varchar(16)"`
+	Compress bool
+	// 状态 Pending, Running, Success, Failed
+	Status string `gorm:"type:varchar(16);index"`
+	// 进度 0-100
+	Progress int
+	Lines    int64
+	Size     int64
+	Filename string `gorm:"type:varchar(256)"`
+	// 存储中的路径
+	StorageKey string `gorm:"type:varchar(512)"`
+	Message    string `gorm:"type:varchar(1024)"`
+	Creator    string `gorm:"type:varchar(50);index"`
+	CreatorID  uint
+	CreatedAt  time.Time `sql:"DEFAULT:'current_timestamp'"`
+	FinishedAt *time.Time
+	// 过期后删除导出文件
+	ExpiredAt *time.Time `gorm:"index"`
+}
@@ -0,0 +1,79 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	LogReportGroupByMessage = ""
+
+	LogReportStatusSuccess = "Success"
+	LogReportStatusFailed  = "Failed"
+)
+
+// LogReport 定时日志报告, 按 cron 执行日志查询并将 TopN 聚合结果发送至告警渠道
+type LogReport struct {
+	ID        uint   `gorm:"primarykey"`
+	Name      string `gorm:"type:varchar(50)" binding:"required"`
+	Cluster   string `gorm:"type:varchar(50);index"`
+	Namespace string `gorm:"type:varchar(50);index"`
+	// 日志查询, 需要限定 namespace
+	LogQL string `gorm:"type:varchar(1024)" binding:"required"`
+	// 查询的时间范围, 例如 24h
+	TimeRange string `gorm:"type:varchar(16)"`
+	// 标准 cron 表达式, 例如 0 9 * * *
+	Cron string `gorm:"type:varchar(64)" binding:"required"`
+	TopN int
+	// 聚合的标签, 为空时按日志内容聚合
+	GroupBy    string `gorm:"type:varchar(64)"`
+	ChannelIDs UintList
+	Enabled    bool
+	Creator    string `gorm:"type:varchar(50)"`
+	LastRunAt  *time.Time
+	LastStatus string    `gorm:"type:varchar(16)"`
+	LastError  string    `gorm:"type:varchar(1024)"`
+	CreatedAt  time.Time `sql:"DEFAULT:'current_timestamp'"`
+	UpdatedAt  time.Time
+}
+
+type UintList []uint
+
+func (s *UintList) Scan(value interface{}) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New(fmt.Sprint("Failed to unmarshal JSONB value:", value))
+	}
+	result := UintList{}
+	err := json.Unmarshal(bytes, &result)
+	*s = result
+	return err
+}
+
+func (s UintList) Value() (driver.Value, error) {
+	if s == nil {
+		return json.Marshal([]uint{})
+	}
+	return json.Marshal(s)
+}
+
+func (UintList) GormDataType() string {
+	return "json"
+}
@@ -0,0 +1,155 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	ChangeRequestStatusOpen     = "Open"
+	ChangeRequestStatusMerged   = "Merged"
+	ChangeRequestStatusRejected = "Rejected"
+	ChangeRequestStatusClosed   = "Closed" // 作者主动关闭
+)
+
+// ManifestReviewPolicy 环境的编排审核策略, 开启后对该环境编排的修改需要审核后才能合并
+type ManifestReviewPolicy struct {
+	ID            uint         `gorm:"primarykey"`
+	EnvironmentID uint         `gorm:"uniqueIndex"`
+	Environment   *Environment `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	Enabled       bool
+	// RequiredApprovals 合并需要的审核通过人数, 作者本人不能审核
+	RequiredApprovals int `binding:"min=0"`
+	Creator           string
+	CreatedAt         time.Time `sql:"DEFAULT:'current_timestamp'"`
+	UpdatedAt         time.Time
+}
+
+// ManifestChangeRequest 编排的变更请求, 变更提交在 ChangeBranch 上, 审核通过后合并至环境分支
+type ManifestChangeRequest struct {
+	ID              uint         `gorm:"primarykey"`
+	EnvironmentID   uint         `gorm:"index"`
+	Environment     *Environment `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	TenantName      string       `gorm:"type:varchar(50)"`
+	ProjectName     string       `gorm:"type:varchar(50)"`
+	EnvironmentName string       `gorm:"type:varchar(50)"`
+	// ApplicationName 为空时为环境下多个应用的变更
+	ApplicationName string `gorm:"type:varchar(255)"`
+	// Branch 合并的目标分支
+	Branch       string `gorm:"type:varchar(255)"`
+	ChangeBranch string `gorm:"type:varchar(255)"`
+	// BaseCommit 变更基于的提交, 用于检测合并冲突
+	BaseCommit  string `gorm:"type:varchar(64)"`
+	HeadCommit  string `gorm:"type:varchar(64)"`
+	MergeCommit string `gorm:"type:varchar(64)"`
+	Title       string
+	Author      string
+	Status      string `gorm:"type:varchar(20);index"`
+	Approvals   Approvals
+	// Reviewer 合并或拒绝的人
+	Reviewer  string
+	Comments  []*ManifestChangeComment `gorm:"foreignKey:ChangeRequestID"`
+	CreatedAt time.Time                `sql:"DEFAULT:'current_timestamp'"`
+	UpdatedAt time.Time
+	ClosedAt  *time.Time
+}
+
+// ManifestChangeComment 变更请求的评论
+type ManifestChangeComment struct {
+	ID              uint                   `gorm:"primarykey"`
+	ChangeRequestID uint                   `gorm:"index"`
+	ChangeRequest   *ManifestChangeRequest `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	Username        string
+	Content         string    `binding:"required"`
+	CreatedAt       time.Time `sql:"DEFAULT:'current_timestamp'"`
+}
+
+// Approvals 审核通过的用户
+type Approvals []string
+
+func (a *Approvals) Scan(value interface{}) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New(fmt.Sprint("Failed to unmarshal JSONB value:", value))
+	}
+	result := Approvals{}
+	err := json.Unmarshal(bytes, &result)
+	*a = result
+	return err
+}
+
+func (a Approvals) Value() (driver.Value, error) {
+	if a == nil {
+		return json.Marshal([]string{})
+	}
+	return json.Marshal(a)
+}
+
+func (Approvals) GormDataType() string {
+	return "json"
+}
+
+func (a Approvals) Has(username string) bool {
+	for _, u := range a {
+		if u == username {
+			return true
+		}
+	}
+	return false
+}
+
+// Approve 作者不能审核自己的变更, 同一用户只计一次
+func (cr *ManifestChangeRequest) Approve(username string) error {
+	if cr.Status != ChangeRequestStatusOpen {
+		return fmt.Errorf("change request is %s", cr.Status)
+	}
+	if cr.Author == username {
+		return errors.New("the author can not approve own change request")
+	}
+	if !cr.Approvals.Has(username) {
+		cr.Approvals = append(cr.Approvals, username)
+	}
+	return nil
+}
+
+// Mergeable 审核通过人数满足策略时才可以合并, 策略至少需要一人审核
+// 作者本人的审核不计入, 且作者不能合并自己的变更请求
+func (cr *ManifestChangeRequest) Mergeable(policy *ManifestReviewPolicy, merger string) error {
+	if cr.Status != ChangeRequestStatusOpen {
+		return fmt.Errorf("change request is %s", cr.Status)
+	}
+	if merger == cr.Author {
+		return errors.New("the author can not merge own change request")
+	}
+	required := 1
+	if policy != nil && policy.RequiredApprovals > required {
+		required = policy.RequiredApprovals
+	}
+	approvals := 0
+	for _, approver := range cr.Approvals {
+		if approver != cr.Author {
+			approvals++
+		}
+	}
+	if approvals < required {
+		return fmt.Errorf("change request requires %d approvals, got %d", required, approvals)
+	}
+	return nil
+}
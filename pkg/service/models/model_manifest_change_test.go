@@ -0,0 +1,126 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestManifestChangeRequest_Approve(t *testing.T) {
+	tests := []struct {
+		name     string
+		cr       ManifestChangeRequest
+		username string
+		want     Approvals
+		wantErr  bool
+	}{
+		{
+			name:     "approve",
+			cr:       ManifestChangeRequest{Author: "alice", Status: ChangeRequestStatusOpen},
+			username: "bob",
+			want:     Approvals{"bob"},
+		},
+		{
+			name:     "approve twice",
+			cr:       ManifestChangeRequest{Author: "alice", Status: ChangeRequestStatusOpen, Approvals: Approvals{"bob"}},
+			username: "bob",
+			want:     Approvals{"bob"},
+		},
+		{
+			name:     "author can not approve",
+			cr:       ManifestChangeRequest{Author: "alice", Status: ChangeRequestStatusOpen},
+			username: "alice",
+			wantErr:  true,
+		},
+		{
+			name:     "closed",
+			cr:       ManifestChangeRequest{Author: "alice", Status: ChangeRequestStatusRejected},
+			username: "bob",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cr.Approve(tt.username)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Approve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(tt.cr.Approvals, tt.want) {
+				t.Errorf("Approve() approvals = %v, want %v", tt.cr.Approvals, tt.want)
+			}
+		})
+	}
+}
+
+func TestManifestChangeRequest_Mergeable(t *testing.T) {
+	tests := []struct {
+		name    string
+		cr      ManifestChangeRequest
+		policy  *ManifestReviewPolicy
+		merger  string
+		wantErr bool
+	}{
+		{
+			name:    "no approvals",
+			cr:      ManifestChangeRequest{Author: "alice", Status: ChangeRequestStatusOpen},
+			policy:  &ManifestReviewPolicy{Enabled: true, RequiredApprovals: 1},
+			wantErr: true,
+		},
+		{
+			name:   "enough approvals",
+			cr:     ManifestChangeRequest{Author: "alice", Status: ChangeRequestStatusOpen, Approvals: Approvals{"bob", "carol"}},
+			policy: &ManifestReviewPolicy{Enabled: true, RequiredApprovals: 2},
+		},
+		{
+			name:    "not enough approvals",
+			cr:      ManifestChangeRequest{Author: "alice", Status: ChangeRequestStatusOpen, Approvals: Approvals{"bob"}},
+			policy:  &ManifestReviewPolicy{Enabled: true, RequiredApprovals: 2},
+			wantErr: true,
+		},
+		{
+			name:   "policy removed still requires one approval",
+			cr:     ManifestChangeRequest{Author: "alice", Status: ChangeRequestStatusOpen, Approvals: Approvals{"bob"}},
+			policy: nil,
+		},
+		{
+			name:    "already merged",
+			cr:      ManifestChangeRequest{Author: "alice", Status: ChangeRequestStatusMerged, Approvals: Approvals{"bob"}},
+			policy:  &ManifestReviewPolicy{Enabled: true, RequiredApprovals: 1},
+			wantErr: true,
+		},
+		{
+			name:    "author approval not counted",
+			cr:      ManifestChangeRequest{Author: "alice", Status: ChangeRequestStatusOpen, Approvals: Approvals{"alice"}},
+			policy:  &ManifestReviewPolicy{Enabled: true, RequiredApprovals: 1},
+			merger:  "bob",
+			wantErr: true,
+		},
+		{
+			name:    "author can not merge",
+			cr:      ManifestChangeRequest{Author: "alice", Status: ChangeRequestStatusOpen, Approvals: Approvals{"bob"}},
+			policy:  &ManifestReviewPolicy{Enabled: true, RequiredApprovals: 1},
+			merger:  "alice",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cr.Mergeable(tt.policy, tt.merger); (err != nil) != tt.wantErr {
+				t.Errorf("Mergeable() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
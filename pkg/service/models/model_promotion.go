@@ -0,0 +1,112 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+const (
+	PromotionStatusSucceed   = "Succeed"
+	PromotionStatusFailed    = "Failed"
+	PromotionStatusRejected  = "Rejected"  // 未通过健康检查或分析
+	PromotionStatusReviewing = "Reviewing" // 目标环境开启了编排审核, 等待变更请求合并
+)
+
+// PromotionPipeline 项目的环境晋级流水线, 应用按 Stages 的顺序逐级晋级
+type PromotionPipeline struct {
+	ID        uint            `gorm:"primarykey"`
+	ProjectID uint            `gorm:"uniqueIndex"`
+	Project   *Project        `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	Stages    PromotionStages `binding:"required,min=2,dive"`
+	Creator   string
+	CreatedAt time.Time `sql:"DEFAULT:'current_timestamp'"`
+	UpdatedAt time.Time
+}
+
+// PromotionStage 流水线中的一个环境, 检查条件作用于晋级到该环境之前的上一级环境
+type PromotionStage struct {
+	Environment string `binding:"required"`
+	// RequireHealthy 要求上一级环境中的应用已同步且健康
+	RequireHealthy bool
+	// RequireAnalysis 要求上一级环境中的应用最近一次灰度分析成功
+	RequireAnalysis bool
+}
+
+type PromotionStages []PromotionStage
+
+func (s *PromotionStages) Scan(value interface{}) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New(fmt.Sprint("Failed to unmarshal JSONB value:", value))
+	}
+	result := PromotionStages{}
+	err := json.Unmarshal(bytes, &result)
+	*s = result
+	return err
+}
+
+func (s PromotionStages) Value() (driver.Value, error) {
+	return json.Marshal(s)
+}
+
+func (PromotionStages) GormDataType() string {
+	return "json"
+}
+
+// Validate 环境不能重复
+func (s PromotionStages) Validate() error {
+	seen := map[string]bool{}
+	for _, stage := range s {
+		if seen[stage.Environment] {
+			return fmt.Errorf("duplicated environment %s in promotion stages", stage.Environment)
+		}
+		seen[stage.Environment] = true
+	}
+	return nil
+}
+
+// Next 返回环境的下一级, 最后一级或者不在流水线中时返回nil
+func (s PromotionStages) Next(env string) *PromotionStage {
+	for i := range s {
+		if s[i].Environment == env && i+1 < len(s) {
+			return &s[i+1]
+		}
+	}
+	return nil
+}
+
+// PromotionHistory 晋级记录
+type PromotionHistory struct {
+	ID              uint   `gorm:"primarykey"`
+	ProjectID       uint   `gorm:"index"`
+	ApplicationName string `gorm:"type:varchar(50);index"`
+	FromEnvironment string `gorm:"type:varchar(50)"`
+	ToEnvironment   string `gorm:"type:varchar(50)"`
+	// Revision 源环境中正在运行的编排版本
+	Revision string `gorm:"type:varchar(64)"`
+	// Images 晋级的镜像, 以digest固定
+	Images    datatypes.JSON
+	Status    string `gorm:"type:varchar(20)"`
+	Message   string
+	Creator   string
+	CreatedAt time.Time `sql:"DEFAULT:'current_timestamp'"`
+}
@@ -0,0 +1,189 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// 请求量在使用量分位数上预留的余量
+	recommendRequestMargin = 1.15
+	recommendCPULimitRatio = 1.5
+	recommendMemLimitRatio = 1.3
+
+	minCPURequestCores    = 0.01      // 10m
+	minMemoryRequestBytes = 16 * Mi   // 16Mi
+	recommendChangedRatio = 0.1       // 变化小于10%时不建议调整
+	recommendMilliCPUStep = 5         // cpu 按5m取整
+	recommendMemoryStep   = int64(Mi) // 内存按Mi取整
+)
+
+// ResourceRecommendation workload资源规格建议
+type ResourceRecommendation struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `sql:"DEFAULT:'current_timestamp'"`
+
+	ClusterName     string `gorm:"type:varchar(50);index:idx_recommendation_namespace"`
+	TenantName      string `gorm:"type:varchar(50)"`
+	ProjectName     string `gorm:"type:varchar(50)"`
+	EnvironmentName string `gorm:"type:varchar(50)"`
+	Namespace       string `gorm:"type:varchar(50);index:idx_recommendation_namespace"`
+	Type            string `gorm:"type:varchar(50)"`
+	Name            string `gorm:"type:varchar(255)"`
+	// Ref workload 所属应用编排的标记, 为空时不是由应用编排部署的, 无法直接应用建议
+	Ref      string `gorm:"type:varchar(1024)" json:"-"`
+	Replicas int32
+
+	// Window 统计窗口
+	Window     string
+	Containers ContainerRecommendations
+	// Confidence 置信度[0,1], 统计窗口内数据的完整程度
+	Confidence float64
+	// 调整后节省的requests总量(乘以副本数), 为负时表示需要增加
+	CPUSavingCore    float64
+	MemorySavingByte float64
+	// 按集群单价预计每月节省的费用, 集群未设置单价时为0
+	MonthlySaving float64
+	Currency      string
+
+	AppliedAt *time.Time
+}
+
+// ContainerUsage 容器在统计窗口内的使用量, 取workload所有副本中的最大值
+type ContainerUsage struct {
+	CPUP90Core    float64
+	CPUP99Core    float64
+	MemoryP95Byte float64
+	MemoryMaxByte float64
+}
+
+type ContainerRecommendation struct {
+	Name        string
+	Usage       ContainerUsage
+	Current     corev1.ResourceRequirements
+	Recommended corev1.ResourceRequirements
+}
+
+type ContainerRecommendations []ContainerRecommendation
+
+func (c *ContainerRecommendations) Scan(value interface{}) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New(fmt.Sprint("Failed to unmarshal JSONB value:", value))
+	}
+	result := ContainerRecommendations{}
+	err := json.Unmarshal(bytes, &result)
+	*c = result
+	return err
+}
+
+func (c ContainerRecommendations) Value() (driver.Value, error) {
+	return json.Marshal(c)
+}
+
+func (ContainerRecommendations) GormDataType() string {
+	return "json"
+}
+
+// RecommendContainer 根据使用量计算容器的requests与limits
+// cpu request 取P90, memory request 取P95, 并预留余量; 只有当前设置了limit的资源才建议limit
+func RecommendContainer(name string, current corev1.ResourceRequirements, usage ContainerUsage) ContainerRecommendation {
+	cpuRequest := math.Max(usage.CPUP90Core*recommendRequestMargin, minCPURequestCores)
+	memoryRequest := math.Max(usage.MemoryP95Byte*recommendRequestMargin, minMemoryRequestBytes)
+
+	recommended := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    cpuQuantity(cpuRequest),
+			corev1.ResourceMemory: memoryQuantity(memoryRequest),
+		},
+	}
+	if _, ok := current.Limits[corev1.ResourceCPU]; ok {
+		limit := math.Max(math.Max(usage.CPUP99Core*recommendCPULimitRatio, cpuRequest), minCPULimitCores)
+		setLimit(&recommended, corev1.ResourceCPU, cpuQuantity(limit))
+	}
+	if _, ok := current.Limits[corev1.ResourceMemory]; ok {
+		limit := math.Max(math.Max(usage.MemoryMaxByte*recommendMemLimitRatio, memoryRequest), minMemoryLimitBytes)
+		setLimit(&recommended, corev1.ResourceMemory, memoryQuantity(limit))
+	}
+	// 保留其他资源(如GPU)的设置
+	for k, v := range current.Requests {
+		if k != corev1.ResourceCPU && k != corev1.ResourceMemory {
+			recommended.Requests[k] = v
+		}
+	}
+	for k, v := range current.Limits {
+		if k != corev1.ResourceCPU && k != corev1.ResourceMemory {
+			setLimit(&recommended, k, v)
+		}
+	}
+	return ContainerRecommendation{Name: name, Usage: usage, Current: current, Recommended: recommended}
+}
+
+// Changed 建议值与当前值相差超过10%
+func (r ContainerRecommendation) Changed() bool {
+	changed := func(current, recommended corev1.ResourceList, name corev1.ResourceName) bool {
+		cur, ok := current[name]
+		if !ok {
+			_, ok := recommended[name]
+			return ok
+		}
+		rec := recommended[name]
+		return math.Abs(float64(rec.MilliValue()-cur.MilliValue())) > float64(cur.MilliValue())*recommendChangedRatio
+	}
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		if changed(r.Current.Requests, r.Recommended.Requests, name) || changed(r.Current.Limits, r.Recommended.Limits, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestSaving 调整后单个副本节省的requests
+func (r ContainerRecommendation) RequestSaving() (cpuCore, memoryByte float64) {
+	cur, rec := r.Current.Requests, r.Recommended.Requests
+	cpuCore = float64(cur.Cpu().MilliValue()-rec.Cpu().MilliValue()) / 1000
+	memoryByte = float64(cur.Memory().Value() - rec.Memory().Value())
+	return
+}
+
+func setLimit(r *corev1.ResourceRequirements, name corev1.ResourceName, q resource.Quantity) {
+	if r.Limits == nil {
+		r.Limits = corev1.ResourceList{}
+	}
+	r.Limits[name] = q
+}
+
+func cpuQuantity(cores float64) resource.Quantity {
+	milli := ceilStep(cores*1000, recommendMilliCPUStep)
+	return *resource.NewMilliQuantity(milli, resource.DecimalSI)
+}
+
+func memoryQuantity(bytes float64) resource.Quantity {
+	return *resource.NewQuantity(ceilStep(bytes, recommendMemoryStep), resource.BinarySI)
+}
+
+// ceilStep 按step向上取整, 忽略浮点误差
+func ceilStep(v float64, step int64) int64 {
+	return int64(math.Ceil(v/float64(step)-1e-9)) * step
+}
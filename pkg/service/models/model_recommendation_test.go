@@ -0,0 +1,103 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestRecommendContainer(t *testing.T) {
+	resources := func(cpuReq, memReq, cpuLim, memLim string) corev1.ResourceRequirements {
+		r := corev1.ResourceRequirements{Requests: corev1.ResourceList{}}
+		if cpuReq != "" {
+			r.Requests[corev1.ResourceCPU] = resource.MustParse(cpuReq)
+		}
+		if memReq != "" {
+			r.Requests[corev1.ResourceMemory] = resource.MustParse(memReq)
+		}
+		if cpuLim != "" || memLim != "" {
+			r.Limits = corev1.ResourceList{}
+		}
+		if cpuLim != "" {
+			r.Limits[corev1.ResourceCPU] = resource.MustParse(cpuLim)
+		}
+		if memLim != "" {
+			r.Limits[corev1.ResourceMemory] = resource.MustParse(memLim)
+		}
+		return r
+	}
+	tests := []struct {
+		name        string
+		current     corev1.ResourceRequirements
+		usage       ContainerUsage
+		want        corev1.ResourceRequirements
+		wantChanged bool
+		wantCPU     float64
+		wantMemory  float64
+	}{
+		{
+			name:        "over provisioned",
+			current:     resources("1", "1Gi", "2", "2Gi"),
+			usage:       ContainerUsage{CPUP90Core: 0.1, CPUP99Core: 0.2, MemoryP95Byte: 100 * Mi, MemoryMaxByte: 150 * Mi},
+			want:        resources("115m", "115Mi", "300m", "195Mi"),
+			wantChanged: true,
+			wantCPU:     0.885,
+			wantMemory:  909 * Mi,
+		},
+		{
+			name:        "no limits and minimum requests",
+			current:     resources("10m", "16Mi", "", ""),
+			usage:       ContainerUsage{CPUP90Core: 0.001, CPUP99Core: 0.002, MemoryP95Byte: Mi, MemoryMaxByte: Mi},
+			want:        resources("10m", "16Mi", "", ""),
+			wantChanged: false,
+		},
+		{
+			name:        "under provisioned",
+			current:     resources("100m", "128Mi", "", "256Mi"),
+			usage:       ContainerUsage{CPUP90Core: 0.5, CPUP99Core: 0.8, MemoryP95Byte: 200 * Mi, MemoryMaxByte: 300 * Mi},
+			want:        resources("575m", "230Mi", "", "390Mi"),
+			wantChanged: true,
+			wantCPU:     -0.475,
+			wantMemory:  -102 * Mi,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RecommendContainer("app", tt.current, tt.usage)
+			for _, list := range []struct{ got, want corev1.ResourceList }{
+				{got.Recommended.Requests, tt.want.Requests},
+				{got.Recommended.Limits, tt.want.Limits},
+			} {
+				if len(list.got) != len(list.want) {
+					t.Fatalf("RecommendContainer() = %v, want %v", got.Recommended, tt.want)
+				}
+				for k, v := range list.want {
+					if q := list.got[k]; q.Cmp(v) != 0 {
+						t.Errorf("RecommendContainer() %s = %s, want %s", k, q.String(), v.String())
+					}
+				}
+			}
+			if changed := got.Changed(); changed != tt.wantChanged {
+				t.Errorf("Changed() = %v, want %v", changed, tt.wantChanged)
+			}
+			if cpu, memory := got.RequestSaving(); cpu != tt.wantCPU || memory != tt.wantMemory {
+				t.Errorf("RequestSaving() = %v %v, want %v %v", cpu, memory, tt.wantCPU, tt.wantMemory)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import "time"
+
+// SessionRecording 终端会话录像, 录像内容以 asciicast v2 格式保存在 StorageKey 中
+type SessionRecording struct {
+	ID uint `gorm:"primarykey"`
+	// 会话 ID, 记录在该会话的审计日志标签 recording 中
+	SessionID string `gorm:"type:varchar(64);uniqueIndex"`
+	Username  string `gorm:"type:varchar(50);index"`
+	ClientIP  string `gorm:"type:varchar(255)"`
+	// 会话类型 shell, debug, kubectl
+	Kind        string `gorm:"type:varchar(50)"`
+	Tenant      string `gorm:"type:varchar(50)"`
+	Project     string `gorm:"type:varchar(50)"`
+	Environment string `gorm:"type:varchar(50)"`
+	Cluster     string `gorm:"type:varchar(50);index"`
+	Namespace   string `gorm:"type:varchar(255)"`
+	Pod         string `gorm:"type:varchar(255)"`
+	Container   string `gorm:"type:varchar(255)"`
+	StorageKey  string `gorm:"type:varchar(512)"`
+	// Chunks 录像分块保存在 StorageKey 下的块数, 为 0 时录像整体保存在 StorageKey 中
+	Chunks int
+	Size   int64
+	// 超过大小限制, 之后的内容没有记录
+	Truncated bool
+	StartedAt time.Time `gorm:"index"`
+	EndedAt   time.Time
+	// 时长, 单位秒
+	Duration float64
+}
@@ -0,0 +1,49 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import "time"
+
+const (
+	SLOTypeAvailability = "availability"
+	SLOTypeLatency      = "latency"
+)
+
+// SLO 服务等级目标, SLI 为好事件与总事件的比例, 由 good/total 两个计数器指标计算
+type SLO struct {
+	ID   uint   `gorm:"primarykey" json:"id"`
+	Name string `gorm:"type:varchar(50)" binding:"required" json:"name"`
+	// 服务名称(istio canonical service), good/total 为空时根据服务生成默认的 SLI
+	Service string `gorm:"type:varchar(128)" json:"service"`
+	// SLI 类型, availability 或 latency
+	Type string `gorm:"type:varchar(16)" json:"type"`
+	// 延迟 SLI 的阈值, 单位毫秒, 需要是直方图的桶边界, 如 500
+	LatencyThreshold string `gorm:"type:varchar(16)" json:"latencyThreshold"`
+	// 好事件计数器的选择器, 如 istio_requests_total{destination_service_namespace="ns",response_code!~"5.."}
+	GoodQuery string `gorm:"type:varchar(1024)" json:"goodQuery"`
+	// 总事件计数器的选择器
+	TotalQuery string `gorm:"type:varchar(1024)" json:"totalQuery"`
+	// 目标百分比, 如 99.9
+	Target float64 `json:"target"`
+	// 统计窗口, 如 30d
+	Window     string     `gorm:"type:varchar(16)" json:"window"`
+	ChannelIDs UintList   `json:"channelIDs"`
+	Creator    string     `gorm:"type:varchar(50)" json:"creator"`
+	CreatedAt  *time.Time `json:"createdAt"`
+	UpdatedAt  *time.Time `json:"updatedAt"`
+
+	EnvironmentID *uint        `json:"environmentID"`
+	Environment   *Environment `gorm:"constraint:OnUpdate:RESTRICT,OnDelete:CASCADE;" json:"environment,omitempty"`
+}
@@ -0,0 +1,94 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	SyncWindowKindAllow = "allow"
+	SyncWindowKindDeny  = "deny"
+)
+
+// SyncPolicy 应用的 Argo CD 同步策略, ApplicationName 为空时为环境的默认策略
+// 同步窗口只在环境的默认策略中生效, 设置在环境对应的 AppProject 上
+type SyncPolicy struct {
+	ID            uint         `gorm:"primarykey"`
+	EnvironmentID uint         `gorm:"uniqueIndex:uniq_idx_env_app"`
+	Environment   *Environment `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	// ApplicationName 为空时为环境默认策略
+	ApplicationName string `gorm:"type:varchar(255);uniqueIndex:uniq_idx_env_app"`
+	// AutoSync 编排变更后自动同步
+	AutoSync bool
+	// Prune 自动同步时删除编排中已不存在的资源
+	Prune bool
+	// SelfHeal 集群中的资源被修改后自动恢复为编排中的状态
+	SelfHeal bool
+	// RetryLimit 同步失败的重试次数, 0 为不重试
+	RetryLimit int64 `binding:"min=0"`
+	// RetryBackoff 重试的间隔, 如 5s, 每次重试乘以 RetryBackoffFactor, 最长为 RetryBackoffMaxDuration
+	RetryBackoff            string      `gorm:"type:varchar(20)"`
+	RetryBackoffFactor      int64       `binding:"min=0"`
+	RetryBackoffMaxDuration string      `gorm:"type:varchar(20)"`
+	Windows                 SyncWindows `binding:"dive"`
+	Creator                 string
+	CreatedAt               time.Time `sql:"DEFAULT:'current_timestamp'"`
+	UpdatedAt               time.Time
+}
+
+// SyncWindow 同步窗口, deny 窗口内禁止同步, 设置了 allow 窗口时只能在 allow 窗口内同步
+type SyncWindow struct {
+	Kind string `binding:"required,oneof=allow deny"`
+	// Schedule 窗口开始时间, cron 格式, 如 0 14 * * 5 为每周五14点
+	Schedule string `binding:"required"`
+	// Duration 窗口持续时间, 如 10h
+	Duration string `binding:"required"`
+	// TimeZone 为空时为 UTC
+	TimeZone string
+	// Applications 窗口作用的应用, 为空时作用于环境下所有应用
+	Applications []string
+	// ManualSync 窗口内是否允许手动同步
+	ManualSync  bool
+	Description string
+}
+
+type SyncWindows []SyncWindow
+
+func (s *SyncWindows) Scan(value interface{}) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New(fmt.Sprint("Failed to unmarshal JSONB value:", value))
+	}
+	result := SyncWindows{}
+	err := json.Unmarshal(bytes, &result)
+	*s = result
+	return err
+}
+
+func (s SyncWindows) Value() (driver.Value, error) {
+	if s == nil {
+		return json.Marshal([]SyncWindow{})
+	}
+	return json.Marshal(s)
+}
+
+func (SyncWindows) GormDataType() string {
+	return "json"
+}
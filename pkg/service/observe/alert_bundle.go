@@ -0,0 +1,449 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observe
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	AlertBundleVersion = "v1"
+	// 告警规则包中表示目标 namespace 的占位符
+	AlertBundleNamespace = "$namespace"
+
+	AlertKindMonitor = prometheus.AlertTypeMonitor
+	AlertKindLogging = prometheus.AlertTypeLogging
+
+	// 导入时与已有告警规则重名的处理策略
+	ImportStrategySkip      = "skip"      // 跳过
+	ImportStrategyOverwrite = "overwrite" // 覆盖
+	ImportStrategyFail      = "fail"      // 终止导入
+
+	AlertImportCreate    = "create"
+	AlertImportUpdate    = "update"
+	AlertImportSkip      = "skip"
+	AlertImportUnchanged = "unchanged"
+	AlertImportConflict  = "conflict" // 与不同类型或来源的告警规则重名
+	AlertImportUnported  = "unported" // 表达式无法移植到目标 namespace, 不导入
+)
+
+// AlertBundle 可移植的告警规则包, 不包含 namespace 与告警渠道 ID
+type AlertBundle struct {
+	Version       string               `json:"version"`
+	MonitorAlerts []BundleMonitorAlert `json:"monitorAlerts,omitempty"`
+	LoggingAlerts []BundleLoggingAlert `json:"loggingAlerts,omitempty"`
+}
+
+type BundleAlertRule struct {
+	Name          string           `json:"name"`
+	Expr          string           `json:"expr,omitempty"` // 使用模板时为空, namespace 使用 $namespace 占位
+	For           string           `json:"for,omitempty"`
+	Message       string           `json:"message,omitempty"`
+	InhibitLabels []string         `json:"inhibitLabels,omitempty"`
+	AlertLevels   []AlertLevel     `json:"alertLevels"`
+	Receivers     []BundleReceiver `json:"receivers"`
+	Disabled      bool             `json:"disabled,omitempty"`
+}
+
+type BundleReceiver struct {
+	Channel  string `json:"channel"` // 告警渠道名称
+	Interval string `json:"interval,omitempty"`
+}
+
+type BundleMonitorAlert struct {
+	BundleAlertRule `json:",inline"`
+	PromqlGenerator *prometheus.PromqlGenerator `json:"promqlGenerator,omitempty"`
+	Source          string                      `json:"source,omitempty"` // 为空时使用默认的 PrometheusRule
+}
+
+type BundleLoggingAlert struct {
+	BundleAlertRule `json:",inline"`
+	LogqlGenerator  *LogqlGenerator `json:"logqlGenerator,omitempty"`
+}
+
+type AlertImportItem struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	Diff   string `json:"diff,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// rewriteNamespaceMatchers 将 namespace="from" 替换为 namespace="to", 以其他方式选择 namespace 的表达式无法移植
+func rewriteNamespaceMatchers(matchers []*labels.Matcher, from, to string) error {
+	for i, m := range matchers {
+		if m.Name != "namespace" {
+			continue
+		}
+		if m.Type != labels.MatchEqual || m.Value != from {
+			return fmt.Errorf("selector %s can't be ported to other namespace", m.String())
+		}
+		matchers[i] = labels.MustNewMatcher(labels.MatchEqual, m.Name, to)
+	}
+	return nil
+}
+
+// rewriteExprNamespace 通过 promql/logql 解析器替换表达式中选择器的 namespace
+func rewriteExprNamespace(kind, expr, from, to string) (string, error) {
+	if expr == "" {
+		return "", nil
+	}
+	if kind == AlertKindLogging {
+		ranges, err := logqlStreamSelectorRanges(expr)
+		if err != nil {
+			return "", err
+		}
+		sb := strings.Builder{}
+		last := 0
+		for _, r := range ranges {
+			matchers, err := parser.ParseMetricSelector(expr[r[0]:r[1]])
+			if err != nil {
+				return "", err
+			}
+			if err := rewriteNamespaceMatchers(matchers, from, to); err != nil {
+				return "", err
+			}
+			selectors := make([]string, 0, len(matchers))
+			for _, m := range matchers {
+				selectors = append(selectors, m.String())
+			}
+			sb.WriteString(expr[last:r[0]])
+			sb.WriteString("{" + strings.Join(selectors, ",") + "}")
+			last = r[1]
+		}
+		sb.WriteString(expr[last:])
+		return sb.String(), nil
+	}
+	node, err := parser.ParseExpr(expr)
+	if err != nil {
+		return "", err
+	}
+	for _, matchers := range parser.ExtractSelectors(node) {
+		if err := rewriteNamespaceMatchers(matchers, from, to); err != nil {
+			return "", err
+		}
+	}
+	return node.String(), nil
+}
+
+// portableExpr 导出时将 namespace 替换为占位符, 无法移植的表达式保持原样, 导入时在计划中报告
+func portableExpr(kind, expr, namespace string) string {
+	ret, err := rewriteExprNamespace(kind, expr, namespace, AlertBundleNamespace)
+	if err != nil {
+		return expr
+	}
+	return ret
+}
+
+func localizeExpr(kind, expr, namespace string) (string, error) {
+	return rewriteExprNamespace(kind, expr, AlertBundleNamespace, namespace)
+}
+
+// newBundleAlertRule channelNames 用于获取告警渠道的最新名称, 默认渠道由平台自动添加, 不导出
+func newBundleAlertRule(kind string, r BaseAlertRule, templated bool, channelNames map[uint]string) BundleAlertRule {
+	ret := BundleAlertRule{
+		Name:          r.Name,
+		For:           r.For,
+		Message:       r.Message,
+		InhibitLabels: r.InhibitLabels,
+		AlertLevels:   r.AlertLevels,
+		Receivers:     []BundleReceiver{},
+		Disabled:      !r.IsOpen,
+	}
+	if !templated {
+		ret.Expr = portableExpr(kind, r.Expr, r.Namespace)
+	}
+	sort.Strings(ret.InhibitLabels)
+	for _, rec := range r.Receivers {
+		if rec.AlertChannel == nil || rec.AlertChannel.ID == models.DefaultChannel.ID {
+			continue
+		}
+		name, ok := channelNames[rec.AlertChannel.ID]
+		if !ok {
+			name = rec.AlertChannel.Name
+		}
+		ret.Receivers = append(ret.Receivers, BundleReceiver{Channel: name, Interval: rec.Interval})
+	}
+	sort.Slice(ret.Receivers, func(i, j int) bool { return ret.Receivers[i].Channel < ret.Receivers[j].Channel })
+	return ret
+}
+
+// NewAlertBundle 导出告警规则
+func NewAlertBundle(monitorAlerts []MonitorAlertRule, loggingAlerts []LoggingAlertRule, channelNames map[uint]string) *AlertBundle {
+	ret := &AlertBundle{Version: AlertBundleVersion}
+	for _, r := range monitorAlerts {
+		if r.IsExtraAlert() {
+			continue
+		}
+		alert := BundleMonitorAlert{
+			BundleAlertRule: newBundleAlertRule(AlertKindMonitor, r.BaseAlertRule, !r.PromqlGenerator.Notpl(), channelNames),
+		}
+		if !r.PromqlGenerator.Notpl() {
+			alert.PromqlGenerator = r.PromqlGenerator
+		}
+		if r.Source != prometheus.DefaultAlertCRDName {
+			alert.Source = r.Source
+		}
+		ret.MonitorAlerts = append(ret.MonitorAlerts, alert)
+	}
+	for _, r := range loggingAlerts {
+		if r.IsExtraAlert() {
+			continue
+		}
+		alert := BundleLoggingAlert{
+			BundleAlertRule: newBundleAlertRule(AlertKindLogging, r.BaseAlertRule, !r.LogqlGenerator.IsEmpty(), channelNames),
+		}
+		if !r.LogqlGenerator.IsEmpty() {
+			alert.LogqlGenerator = r.LogqlGenerator
+		}
+		ret.LoggingAlerts = append(ret.LoggingAlerts, alert)
+	}
+	sort.Slice(ret.MonitorAlerts, func(i, j int) bool { return ret.MonitorAlerts[i].Name < ret.MonitorAlerts[j].Name })
+	sort.Slice(ret.LoggingAlerts, func(i, j int) bool { return ret.LoggingAlerts[i].Name < ret.LoggingAlerts[j].Name })
+	return ret
+}
+
+// RemoveManaged 移除 SLO 生成的告警规则, 这些规则由 SLO 管理, 不能导出
+func (b *AlertBundle) RemoveManaged() *AlertBundle {
+	monitorAlerts := []BundleMonitorAlert{}
+	for _, r := range b.MonitorAlerts {
+		if r.Source != SLOAlertCRDName {
+			monitorAlerts = append(monitorAlerts, r)
+		}
+	}
+	b.MonitorAlerts = monitorAlerts
+	return b
+}
+
+// RemoveUnported 移除表达式无法移植的告警规则, 返回在导入计划中报告的条目
+func (b *AlertBundle) RemoveUnported() []AlertImportItem {
+	ret := []AlertImportItem{}
+	unported := func(kind string, r BundleAlertRule) bool {
+		if _, err := localizeExpr(kind, r.Expr, AlertBundleNamespace); err != nil {
+			ret = append(ret, AlertImportItem{Kind: kind, Name: r.Name, Action: AlertImportUnported, Reason: err.Error()})
+			return true
+		}
+		return false
+	}
+	monitorAlerts := []BundleMonitorAlert{}
+	for _, r := range b.MonitorAlerts {
+		if !unported(AlertKindMonitor, r.BundleAlertRule) {
+			monitorAlerts = append(monitorAlerts, r)
+		}
+	}
+	loggingAlerts := []BundleLoggingAlert{}
+	for _, r := range b.LoggingAlerts {
+		if !unported(AlertKindLogging, r.BundleAlertRule) {
+			loggingAlerts = append(loggingAlerts, r)
+		}
+	}
+	b.MonitorAlerts, b.LoggingAlerts = monitorAlerts, loggingAlerts
+	return ret
+}
+
+// Merge 合并告警规则包, 重名的告警规则保留先出现的
+func (b *AlertBundle) Merge(other *AlertBundle) {
+	names := map[string]bool{}
+	for _, r := range b.MonitorAlerts {
+		names[r.Name] = true
+	}
+	for _, r := range b.LoggingAlerts {
+		names[r.Name] = true
+	}
+	for _, r := range other.MonitorAlerts {
+		if !names[r.Name] {
+			b.MonitorAlerts = append(b.MonitorAlerts, r)
+			names[r.Name] = true
+		}
+	}
+	for _, r := range other.LoggingAlerts {
+		if !names[r.Name] {
+			b.LoggingAlerts = append(b.LoggingAlerts, r)
+			names[r.Name] = true
+		}
+	}
+}
+
+func (b *AlertBundle) Validate() error {
+	if b.Version != AlertBundleVersion {
+		return fmt.Errorf("alert bundle version %s not supported, must be %s", b.Version, AlertBundleVersion)
+	}
+	names := map[string]bool{}
+	check := func(r BundleAlertRule) error {
+		if r.Name == "" {
+			return fmt.Errorf("alert rule name can't be empty")
+		}
+		if names[r.Name] {
+			return fmt.Errorf("alert rule %s duplicated in bundle", r.Name)
+		}
+		names[r.Name] = true
+		return nil
+	}
+	for _, r := range b.MonitorAlerts {
+		if err := check(r.BundleAlertRule); err != nil {
+			return err
+		}
+		if r.Source == SLOAlertCRDName {
+			return fmt.Errorf("alert rule %s: alert rules of SLO can't be imported", r.Name)
+		}
+	}
+	for _, r := range b.LoggingAlerts {
+		if err := check(r.BundleAlertRule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r BundleAlertRule) toBaseAlertRule(kind, namespace string, channels map[string]*models.AlertChannel) (BaseAlertRule, error) {
+	expr, err := localizeExpr(kind, r.Expr, namespace)
+	if err != nil {
+		return BaseAlertRule{}, fmt.Errorf("alert rule %s: %w", r.Name, err)
+	}
+	ret := BaseAlertRule{
+		Namespace:     namespace,
+		Name:          r.Name,
+		Expr:          expr,
+		For:           r.For,
+		Message:       r.Message,
+		InhibitLabels: r.InhibitLabels,
+		AlertLevels:   r.AlertLevels,
+		IsOpen:        !r.Disabled,
+	}
+	for _, rec := range r.Receivers {
+		channel, ok := channels[rec.Channel]
+		if !ok {
+			return ret, fmt.Errorf("alert rule %s: channel %s not found", r.Name, rec.Channel)
+		}
+		ret.Receivers = append(ret.Receivers, AlertReceiver{AlertChannel: channel, Interval: rec.Interval})
+	}
+	return ret, nil
+}
+
+// ToMonitorAlertRule 转换为目标 namespace 的告警规则, 还需要通过 MutateMonitorAlert 校验并生成表达式
+func (r BundleMonitorAlert) ToMonitorAlertRule(namespace string, channels map[string]*models.AlertChannel) (MonitorAlertRule, error) {
+	base, err := r.toBaseAlertRule(AlertKindMonitor, namespace, channels)
+	if err != nil {
+		return MonitorAlertRule{}, err
+	}
+	source := r.Source
+	if source == "" {
+		source = prometheus.DefaultAlertCRDName
+	}
+	return MonitorAlertRule{BaseAlertRule: base, PromqlGenerator: r.PromqlGenerator, Source: source}, nil
+}
+
+// ToLoggingAlertRule 转换为目标 namespace 的告警规则, 还需要通过 MutateLoggingAlert 校验并生成表达式
+func (r BundleLoggingAlert) ToLoggingAlertRule(namespace string, channels map[string]*models.AlertChannel) (LoggingAlertRule, error) {
+	base, err := r.toBaseAlertRule(AlertKindLogging, namespace, channels)
+	if err != nil {
+		return LoggingAlertRule{}, err
+	}
+	return LoggingAlertRule{BaseAlertRule: base, LogqlGenerator: r.LogqlGenerator}, nil
+}
+
+type bundleEntry struct {
+	kind   string
+	source string
+	raw    string
+}
+
+func (b *AlertBundle) entries() (map[string]bundleEntry, []string) {
+	ret := map[string]bundleEntry{}
+	names := []string{}
+	for _, r := range b.MonitorAlerts {
+		source := r.Source
+		if source == "" {
+			source = prometheus.DefaultAlertCRDName
+		}
+		// 来源单独比较, 避免显式指定默认来源时产生差异
+		r.Source = ""
+		bts, _ := yaml.Marshal(r)
+		ret[r.Name] = bundleEntry{kind: AlertKindMonitor, source: source, raw: string(bts)}
+		names = append(names, r.Name)
+	}
+	for _, r := range b.LoggingAlerts {
+		bts, _ := yaml.Marshal(r)
+		ret[r.Name] = bundleEntry{kind: AlertKindLogging, raw: string(bts)}
+		names = append(names, r.Name)
+	}
+	return ret, names
+}
+
+func alertRuleDiff(name, from, to string) string {
+	diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(from),
+		B:        difflib.SplitLines(to),
+		FromFile: name + " (current)",
+		ToFile:   name + " (bundle)",
+		Context:  3,
+	})
+	return diff
+}
+
+// PlanAlertImport 对比已有的告警规则, 生成导入计划与差异, 策略为 fail 时存在重名的告警规则则返回错误
+// 导入的告警规则需要已经过 MutateMonitorAlert/MutateLoggingAlert, 与已有规则按相同方式导出后比较, 避免产生无意义的差异
+func PlanAlertImport(current *AlertBundle, monitorRules []MonitorAlertRule, loggingRules []LoggingAlertRule,
+	channelNames map[uint]string, strategy string,
+) ([]AlertImportItem, error) {
+	switch strategy {
+	case "":
+		strategy = ImportStrategySkip
+	case ImportStrategySkip, ImportStrategyOverwrite, ImportStrategyFail:
+	default:
+		return nil, fmt.Errorf("import strategy %s not supported", strategy)
+	}
+	exists, _ := current.entries()
+	incoming, names := NewAlertBundle(monitorRules, loggingRules, channelNames).entries()
+
+	ret := []AlertImportItem{}
+	for _, name := range names {
+		in := incoming[name]
+		item := AlertImportItem{Kind: in.kind, Name: name}
+		old, ok := exists[name]
+		switch {
+		case !ok:
+			item.Action = AlertImportCreate
+			item.Diff = alertRuleDiff(name, "", in.raw)
+		case old.kind != in.kind || old.source != in.source:
+			if strategy == ImportStrategyFail {
+				return nil, fmt.Errorf("alert rule %s already exists as %s alert in %s", name, old.kind, old.source)
+			}
+			item.Action = AlertImportConflict
+			item.Reason = fmt.Sprintf("alert rule %s already exists as %s alert in %s", name, old.kind, old.source)
+		case old.raw == in.raw:
+			item.Action = AlertImportUnchanged
+		case strategy == ImportStrategyFail:
+			return nil, fmt.Errorf("alert rule %s already exists", name)
+		case strategy == ImportStrategyOverwrite:
+			item.Action = AlertImportUpdate
+			item.Diff = alertRuleDiff(name, old.raw, in.raw)
+		default:
+			item.Action = AlertImportSkip
+			item.Diff = alertRuleDiff(name, old.raw, in.raw)
+		}
+		ret = append(ret, item)
+	}
+	return ret, nil
+}
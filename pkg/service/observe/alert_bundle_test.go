@@ -0,0 +1,393 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observe
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+)
+
+var bundleTestLevels = []AlertLevel{{CompareOp: ">", CompareValue: "1", Severity: "error"}}
+
+func bundleTestChannels() map[string]*models.AlertChannel {
+	return map[string]*models.AlertChannel{
+		"ops": {ID: 2, Name: "ops"},
+	}
+}
+
+func TestNewAlertBundle(t *testing.T) {
+	monitorAlerts := []MonitorAlertRule{
+		{
+			BaseAlertRule: BaseAlertRule{
+				Namespace:   "myns",
+				Name:        "b-raw",
+				Expr:        `sum(rate(http_requests_total{namespace="myns"}[5m]))`,
+				AlertLevels: bundleTestLevels,
+				Receivers: []AlertReceiver{
+					{AlertChannel: models.DefaultChannel},
+					{AlertChannel: &models.AlertChannel{ID: 2, Name: "old-name"}, Interval: "10m"},
+				},
+				IsOpen: true,
+			},
+			PromqlGenerator: &prometheus.PromqlGenerator{},
+			Source:          prometheus.DefaultAlertCRDName,
+		},
+		{
+			BaseAlertRule: BaseAlertRule{
+				Namespace:   "myns",
+				Name:        "a-tpl",
+				Expr:        `container_cpu{namespace="myns"}`,
+				AlertLevels: bundleTestLevels,
+			},
+			PromqlGenerator: &prometheus.PromqlGenerator{Scope: "containers", Resource: "container", Rule: "cpuUsage"},
+			Source:          "custom",
+		},
+		{
+			BaseAlertRule: BaseAlertRule{Namespace: "myns", Name: "extra"},
+			Source:        prometheus.DefaultAlertCRDName,
+		},
+		{
+			BaseAlertRule: BaseAlertRule{Namespace: "myns", Name: "slo-fast", AlertLevels: bundleTestLevels},
+			Source:        SLOAlertCRDName,
+		},
+	}
+	loggingAlerts := []LoggingAlertRule{
+		{
+			BaseAlertRule: BaseAlertRule{
+				Namespace:   "myns",
+				Name:        "log",
+				Expr:        `sum(count_over_time({namespace="myns"} |~ "error" [1m]))`,
+				AlertLevels: bundleTestLevels,
+				IsOpen:      true,
+			},
+			LogqlGenerator: &LogqlGenerator{Duration: "1m", Match: "error"},
+		},
+	}
+	got := NewAlertBundle(monitorAlerts, loggingAlerts, map[uint]string{2: "ops"})
+
+	names := []string{}
+	for _, r := range got.MonitorAlerts {
+		names = append(names, r.Name)
+	}
+	if want := []string{"a-tpl", "b-raw", "slo-fast"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("monitor alerts = %v, want %v", names, want)
+	}
+	tpl, raw := got.MonitorAlerts[0], got.MonitorAlerts[1]
+	if tpl.Expr != "" || tpl.PromqlGenerator == nil || tpl.Source != "custom" || !tpl.Disabled {
+		t.Errorf("templated alert = %+v", tpl)
+	}
+	if want := `sum(rate(http_requests_total{namespace="$namespace"}[5m]))`; raw.Expr != want {
+		t.Errorf("expr = %s, want %s", raw.Expr, want)
+	}
+	if raw.PromqlGenerator != nil || raw.Source != "" || raw.Disabled {
+		t.Errorf("raw alert = %+v", raw)
+	}
+	if want := []BundleReceiver{{Channel: "ops", Interval: "10m"}}; !reflect.DeepEqual(raw.Receivers, want) {
+		t.Errorf("receivers = %v, want %v", raw.Receivers, want)
+	}
+	if len(got.LoggingAlerts) != 1 || got.LoggingAlerts[0].Expr != "" || got.LoggingAlerts[0].LogqlGenerator == nil {
+		t.Errorf("logging alerts = %+v", got.LoggingAlerts)
+	}
+
+	got.RemoveManaged()
+	if len(got.MonitorAlerts) != 2 {
+		t.Errorf("RemoveManaged() left %d monitor alerts, want 2", len(got.MonitorAlerts))
+	}
+}
+
+func TestPortableExpr(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		expr     string
+		want     string
+		unported bool
+	}{
+		{
+			name: "promql",
+			kind: AlertKindMonitor,
+			expr: `sum(rate(http_requests_total{namespace="myns",code=~"5.."}[5m])) / sum(rate(http_requests_total{namespace="myns"}[5m]))`,
+			want: `sum(rate(http_requests_total{code=~"5..",namespace="$namespace"}[5m])) / sum(rate(http_requests_total{namespace="$namespace"}[5m]))`,
+		},
+		{
+			name: "namespace in other label",
+			kind: AlertKindMonitor,
+			expr: `up{job="namespace=\"myns\"",namespace="myns"}`,
+			want: `up{job="namespace=\"myns\"",namespace="$namespace"}`,
+		},
+		{name: "other namespace", kind: AlertKindMonitor, expr: `up{namespace="other"}`, unported: true},
+		{name: "namespace regex", kind: AlertKindMonitor, expr: `up{namespace=~"myns|other"}`, unported: true},
+		{
+			name: "logql",
+			kind: AlertKindLogging,
+			expr: `sum(count_over_time({namespace="myns", container="app"} |~ "{namespace=\"myns\"}" [1m]))`,
+			want: `sum(count_over_time({namespace="$namespace",container="app"} |~ "{namespace=\"myns\"}" [1m]))`,
+		},
+		{name: "logql other namespace", kind: AlertKindLogging, expr: `count_over_time({namespace!="myns"}[1m])`, unported: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := portableExpr(tt.kind, tt.expr, "myns")
+			if tt.unported {
+				if got != tt.expr {
+					t.Errorf("portableExpr() = %s, want unchanged", got)
+				}
+				if _, err := localizeExpr(tt.kind, got, "target"); err == nil {
+					t.Errorf("localizeExpr() should fail for %s", got)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("portableExpr() = %s, want %s", got, tt.want)
+			}
+			localized, err := localizeExpr(tt.kind, got, "myns")
+			if err != nil {
+				t.Fatalf("localizeExpr() error = %v", err)
+			}
+			if again := portableExpr(tt.kind, localized, "myns"); again != got {
+				t.Errorf("portableExpr(localizeExpr()) = %s, want %s", again, got)
+			}
+		})
+	}
+}
+
+func TestAlertBundle_RemoveUnported(t *testing.T) {
+	b := &AlertBundle{
+		Version: AlertBundleVersion,
+		MonitorAlerts: []BundleMonitorAlert{
+			{BundleAlertRule: BundleAlertRule{Name: "a", Expr: `up{namespace="$namespace"}`}},
+			{BundleAlertRule: BundleAlertRule{Name: "b", Expr: `up{namespace="prod"}`}},
+			{BundleAlertRule: BundleAlertRule{Name: "tpl"}},
+		},
+		LoggingAlerts: []BundleLoggingAlert{{BundleAlertRule: BundleAlertRule{Name: "c", Expr: `count_over_time({namespace=~".+"}[1m])`}}},
+	}
+	got := b.RemoveUnported()
+	names := []string{}
+	for _, item := range got {
+		if item.Action != AlertImportUnported || item.Reason == "" {
+			t.Errorf("unported item = %+v", item)
+		}
+		names = append(names, item.Name)
+	}
+	if want := []string{"b", "c"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("RemoveUnported() = %v, want %v", names, want)
+	}
+	if len(b.MonitorAlerts) != 2 || len(b.LoggingAlerts) != 0 {
+		t.Errorf("bundle after RemoveUnported() = %+v", b)
+	}
+}
+
+func TestAlertBundle_Merge(t *testing.T) {
+	b := &AlertBundle{
+		Version:       AlertBundleVersion,
+		MonitorAlerts: []BundleMonitorAlert{{BundleAlertRule: BundleAlertRule{Name: "a", For: "1m"}}},
+	}
+	b.Merge(&AlertBundle{
+		MonitorAlerts: []BundleMonitorAlert{
+			{BundleAlertRule: BundleAlertRule{Name: "a", For: "5m"}},
+			{BundleAlertRule: BundleAlertRule{Name: "b"}},
+		},
+		LoggingAlerts: []BundleLoggingAlert{{BundleAlertRule: BundleAlertRule{Name: "b"}}, {BundleAlertRule: BundleAlertRule{Name: "c"}}},
+	})
+	if len(b.MonitorAlerts) != 2 || b.MonitorAlerts[0].For != "1m" {
+		t.Errorf("monitor alerts = %+v", b.MonitorAlerts)
+	}
+	if len(b.LoggingAlerts) != 1 || b.LoggingAlerts[0].Name != "c" {
+		t.Errorf("logging alerts = %+v", b.LoggingAlerts)
+	}
+}
+
+func TestAlertBundle_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		bundle  AlertBundle
+		wantErr string
+	}{
+		{
+			name: "ok",
+			bundle: AlertBundle{
+				Version:       AlertBundleVersion,
+				MonitorAlerts: []BundleMonitorAlert{{BundleAlertRule: BundleAlertRule{Name: "a"}}},
+				LoggingAlerts: []BundleLoggingAlert{{BundleAlertRule: BundleAlertRule{Name: "b"}}},
+			},
+		},
+		{
+			name:    "version",
+			bundle:  AlertBundle{Version: "v0"},
+			wantErr: "not supported",
+		},
+		{
+			name: "empty name",
+			bundle: AlertBundle{
+				Version:       AlertBundleVersion,
+				LoggingAlerts: []BundleLoggingAlert{{}},
+			},
+			wantErr: "can't be empty",
+		},
+		{
+			name: "duplicated across kinds",
+			bundle: AlertBundle{
+				Version:       AlertBundleVersion,
+				MonitorAlerts: []BundleMonitorAlert{{BundleAlertRule: BundleAlertRule{Name: "a"}}},
+				LoggingAlerts: []BundleLoggingAlert{{BundleAlertRule: BundleAlertRule{Name: "a"}}},
+			},
+			wantErr: "duplicated",
+		},
+		{
+			name: "slo rules",
+			bundle: AlertBundle{
+				Version:       AlertBundleVersion,
+				MonitorAlerts: []BundleMonitorAlert{{BundleAlertRule: BundleAlertRule{Name: "a"}, Source: SLOAlertCRDName}},
+			},
+			wantErr: "SLO",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.bundle.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want contains %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBundleMonitorAlert_ToMonitorAlertRule(t *testing.T) {
+	alert := BundleMonitorAlert{
+		BundleAlertRule: BundleAlertRule{
+			Name:        "a",
+			Expr:        `up{namespace="$namespace"}`,
+			AlertLevels: bundleTestLevels,
+			Receivers:   []BundleReceiver{{Channel: "ops", Interval: "10m"}},
+			Disabled:    true,
+		},
+	}
+	got, err := alert.ToMonitorAlertRule("other", bundleTestChannels())
+	if err != nil {
+		t.Fatalf("ToMonitorAlertRule() error = %v", err)
+	}
+	if got.Namespace != "other" || got.Expr != `up{namespace="other"}` || got.IsOpen {
+		t.Errorf("ToMonitorAlertRule() = %+v", got.BaseAlertRule)
+	}
+	if got.Source != prometheus.DefaultAlertCRDName {
+		t.Errorf("source = %s, want %s", got.Source, prometheus.DefaultAlertCRDName)
+	}
+	if len(got.Receivers) != 1 || got.Receivers[0].AlertChannel.ID != 2 || got.Receivers[0].Interval != "10m" {
+		t.Errorf("receivers = %+v", got.Receivers)
+	}
+
+	alert.Receivers = []BundleReceiver{{Channel: "missing"}}
+	if _, err := alert.ToMonitorAlertRule("other", bundleTestChannels()); err == nil {
+		t.Errorf("ToMonitorAlertRule() with missing channel should fail")
+	}
+}
+
+func TestPlanAlertImport(t *testing.T) {
+	// 已有及导入的规则都经过校验, 包含平台自动添加的默认渠道
+	base := func(name, forDuration string) BaseAlertRule {
+		return BaseAlertRule{
+			Namespace: "myns", Name: name, For: forDuration, AlertLevels: bundleTestLevels, IsOpen: true,
+			Receivers: []AlertReceiver{{AlertChannel: models.DefaultChannel}},
+		}
+	}
+	monitorRule := func(name, forDuration, source string) MonitorAlertRule {
+		return MonitorAlertRule{BaseAlertRule: base(name, forDuration), Source: source}
+	}
+	current := NewAlertBundle(
+		[]MonitorAlertRule{
+			monitorRule("same", "1m", prometheus.DefaultAlertCRDName),
+			monitorRule("changed", "1m", prometheus.DefaultAlertCRDName),
+			monitorRule("other-source", "1m", "custom"),
+		},
+		[]LoggingAlertRule{{BaseAlertRule: base("log", "1m")}},
+		nil,
+	)
+	monitorRules := []MonitorAlertRule{
+		monitorRule("same", "1m", prometheus.DefaultAlertCRDName),
+		monitorRule("changed", "5m", prometheus.DefaultAlertCRDName),
+		monitorRule("other-source", "1m", prometheus.DefaultAlertCRDName),
+		monitorRule("new", "1m", prometheus.DefaultAlertCRDName),
+		monitorRule("log", "1m", prometheus.DefaultAlertCRDName),
+	}
+	actions := func(items []AlertImportItem) map[string]string {
+		ret := map[string]string{}
+		for _, item := range items {
+			ret[item.Name] = item.Action
+		}
+		return ret
+	}
+
+	tests := []struct {
+		name     string
+		strategy string
+		rules    []MonitorAlertRule
+		want     map[string]string
+		wantErr  bool
+	}{
+		{
+			name:     "default skip",
+			strategy: "",
+			want: map[string]string{
+				"same": AlertImportUnchanged, "changed": AlertImportSkip, "other-source": AlertImportConflict,
+				"new": AlertImportCreate, "log": AlertImportConflict,
+			},
+		},
+		{
+			name:     "overwrite",
+			strategy: ImportStrategyOverwrite,
+			want: map[string]string{
+				"same": AlertImportUnchanged, "changed": AlertImportUpdate, "other-source": AlertImportConflict,
+				"new": AlertImportCreate, "log": AlertImportConflict,
+			},
+		},
+		{name: "fail", strategy: ImportStrategyFail, wantErr: true},
+		{name: "fail on conflict", strategy: ImportStrategyFail, rules: monitorRules[2:3], wantErr: true},
+		{name: "fail unchanged", strategy: ImportStrategyFail, rules: monitorRules[:1], want: map[string]string{"same": AlertImportUnchanged}},
+		{name: "unsupported", strategy: "merge", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := tt.rules
+			if rules == nil {
+				rules = monitorRules
+			}
+			got, err := PlanAlertImport(current, rules, nil, nil, tt.strategy)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PlanAlertImport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(actions(got), tt.want) {
+				t.Errorf("PlanAlertImport() = %v, want %v", actions(got), tt.want)
+			}
+			for _, item := range got {
+				if item.Name == "changed" && !strings.Contains(item.Diff, "+for: 5m") {
+					t.Errorf("diff = %s", item.Diff)
+				}
+			}
+		})
+	}
+}
@@ -115,6 +115,10 @@ func (raw *RawLoggingAlertRule) ToAlerts(hasDetail bool) (AlertRuleList[LoggingA
 	inhibitRuleMap := raw.Base.GetInhibitRuleMap()
 	ret := AlertRuleList[LoggingAlertRule]{}
 	for _, group := range raw.RuleGroups.Groups {
+		// 日志指标的记录规则
+		if isLoggingMetricGroup(group) {
+			continue
+		}
 		alertrule, err := rawToLoggingAlertRule(raw.Base.AMConfig.Namespace, group)
 		if err != nil {
 			log.Error(err, "convert logging alert rule")
@@ -175,6 +179,12 @@ func (raw *RawLoggingAlertRule) ModifyLoggingAlertRule(r LoggingAlertRule, act A
 	}
 
 	groups := []rulefmt.RuleGroup{}
+	// 保留日志指标的记录规则
+	for _, group := range raw.RuleGroups.Groups {
+		if isLoggingMetricGroup(group) {
+			groups = append(groups, group)
+		}
+	}
 	for _, alertrule := range alertRules {
 		if alertrule.IsExtraAlert() {
 			continue
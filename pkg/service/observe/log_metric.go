@@ -0,0 +1,246 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observe
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/rulefmt"
+	"github.com/prometheus/prometheus/promql/parser"
+	"gopkg.in/yaml.v3"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+)
+
+const (
+	// 日志指标的记录规则组名前缀, 与日志告警规则保存在同一个 rulegroups 中
+	LoggingMetricGroupPrefix = "logmetric-"
+	LoggingMetricLabel       = "gems_log_metric"
+
+	defaultLoggingMetricInterval = "1m"
+)
+
+var metricNameReg = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// LoggingMetric 基于日志的指标, 作为 loki 记录规则由 loki ruler 计算后写入 prometheus
+type LoggingMetric struct {
+	Namespace string `json:"namespace"`
+	// 指标名
+	Name string `json:"name" binding:"required"`
+	// logql 指标查询, 为空时使用 LogqlGenerator 生成
+	Expr           string          `json:"expr"`
+	LogqlGenerator *LogqlGenerator `json:"logqlGenerator,omitempty"`
+	// 计算间隔
+	Interval string `json:"interval"`
+	// 在监控面板中查询该指标的 promql
+	Promql string `json:"promql,omitempty"`
+}
+
+func MutateLoggingMetric(req *LoggingMetric) error {
+	if !metricNameReg.MatchString(req.Name) {
+		return fmt.Errorf("metric name %s not valid", req.Name)
+	}
+	if req.Interval == "" {
+		req.Interval = defaultLoggingMetricInterval
+	}
+	if _, err := model.ParseDuration(req.Interval); err != nil {
+		return fmt.Errorf("interval %s not valid: %w", req.Interval, err)
+	}
+	if req.LogqlGenerator.IsEmpty() {
+		if req.Expr == "" {
+			return fmt.Errorf("模板与原生logql不能同时为空")
+		}
+		if !HasNamespaceSelector(req.Expr, req.Namespace) {
+			return fmt.Errorf(`logql must select namespace="%s"`, req.Namespace)
+		}
+	} else {
+		if _, err := model.ParseDuration(req.LogqlGenerator.Duration); err != nil {
+			return fmt.Errorf("duration %s not valid: %w", req.LogqlGenerator.Duration, err)
+		}
+		if _, err := regexp.Compile(req.LogqlGenerator.Match); err != nil {
+			return fmt.Errorf("match %s not valid: %w", req.LogqlGenerator.Match, err)
+		}
+		req.Expr = req.LogqlGenerator.ToLogql(req.Namespace)
+	}
+	req.Promql = loggingMetricPromql(req.Namespace, req.Name)
+	return nil
+}
+
+// HasNamespaceSelector logql 的每个日志流选择器中是否都包含 namespace="<namespace>" 的相等匹配
+func HasNamespaceSelector(logql, namespace string) bool {
+	selectors, err := logqlStreamSelectors(logql)
+	if err != nil || len(selectors) == 0 {
+		return false
+	}
+	for _, selector := range selectors {
+		matchers, err := parser.ParseMetricSelector(selector)
+		if err != nil {
+			return false
+		}
+		found := false
+		for _, m := range matchers {
+			if m.Name == "namespace" && m.Type == labels.MatchEqual && m.Value == namespace {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// logqlStreamSelectors 提取 logql 中的日志流选择器 {...}, 跳过字符串中的内容(如 line_format 模板)
+func logqlStreamSelectors(logql string) ([]string, error) {
+	ranges, err := logqlStreamSelectorRanges(logql)
+	if err != nil {
+		return nil, err
+	}
+	selectors := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		selectors = append(selectors, logql[r[0]:r[1]])
+	}
+	return selectors, nil
+}
+
+// logqlStreamSelectorRanges 日志流选择器在 logql 中的位置 [start, end)
+func logqlStreamSelectorRanges(logql string) ([][2]int, error) {
+	ranges := [][2]int{}
+	start := -1
+	for i := 0; i < len(logql); i++ {
+		switch c := logql[i]; c {
+		case '"', '`':
+			end := i + 1
+			for ; end < len(logql) && logql[end] != c; end++ {
+				if c == '"' && logql[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(logql) {
+				return nil, fmt.Errorf("unterminated string in logql")
+			}
+			i = end
+		case '{':
+			if start >= 0 {
+				return nil, fmt.Errorf("unexpected { in stream selector")
+			}
+			start = i
+		case '}':
+			if start < 0 {
+				return nil, fmt.Errorf("unexpected } in logql")
+			}
+			ranges = append(ranges, [2]int{start, i + 1})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		return nil, fmt.Errorf("unterminated stream selector in logql")
+	}
+	return ranges, nil
+}
+
+func loggingMetricPromql(namespace, name string) string {
+	return fmt.Sprintf(`%s{%s="%s"}`, name, prometheus.AlertNamespaceLabel, namespace)
+}
+
+func isLoggingMetricGroup(group rulefmt.RuleGroup) bool {
+	if !strings.HasPrefix(group.Name, LoggingMetricGroupPrefix) || len(group.Rules) == 0 {
+		return false
+	}
+	return group.Rules[0].Record.Value != ""
+}
+
+// ToLoggingMetrics 当前 namespace 的日志指标
+func (raw *RawLoggingAlertRule) ToLoggingMetrics() []LoggingMetric {
+	ret := []LoggingMetric{}
+	for _, group := range raw.RuleGroups.Groups {
+		if !isLoggingMetricGroup(group) {
+			continue
+		}
+		rule := group.Rules[0]
+		ret = append(ret, LoggingMetric{
+			Namespace: raw.Base.AMConfig.Namespace,
+			Name:      rule.Record.Value,
+			Expr:      rule.Expr.Value,
+			Interval:  group.Interval.String(),
+			Promql:    loggingMetricPromql(raw.Base.AMConfig.Namespace, rule.Record.Value),
+		})
+	}
+	return ret
+}
+
+func (raw *RawLoggingAlertRule) ModifyLoggingMetric(m LoggingMetric, act Action) error {
+	groupName := LoggingMetricGroupPrefix + m.Name
+	index := -1
+	for i, group := range raw.RuleGroups.Groups {
+		if group.Name == groupName {
+			index = i
+			break
+		}
+	}
+	switch act {
+	case Add:
+		if index != -1 {
+			return fmt.Errorf("logging metric %s already exists", m.Name)
+		}
+		group, err := loggingMetricToRaw(m)
+		if err != nil {
+			return err
+		}
+		raw.RuleGroups.Groups = append(raw.RuleGroups.Groups, group)
+	case Update:
+		if index == -1 {
+			return fmt.Errorf("logging metric %s not found", m.Name)
+		}
+		group, err := loggingMetricToRaw(m)
+		if err != nil {
+			return err
+		}
+		raw.RuleGroups.Groups[index] = group
+	case Delete:
+		if index == -1 {
+			return fmt.Errorf("logging metric %s not found", m.Name)
+		}
+		raw.RuleGroups.Groups = append(raw.RuleGroups.Groups[:index], raw.RuleGroups.Groups[index+1:]...)
+	}
+	return nil
+}
+
+func loggingMetricToRaw(m LoggingMetric) (rulefmt.RuleGroup, error) {
+	interval, err := model.ParseDuration(m.Interval)
+	if err != nil {
+		return rulefmt.RuleGroup{}, err
+	}
+	if time.Duration(interval) <= 0 {
+		return rulefmt.RuleGroup{}, fmt.Errorf("interval %s not valid", m.Interval)
+	}
+	return rulefmt.RuleGroup{
+		Name:     LoggingMetricGroupPrefix + m.Name,
+		Interval: interval,
+		Rules: []rulefmt.RuleNode{{
+			Record: yaml.Node{Kind: yaml.ScalarNode, Value: m.Name},
+			Expr:   yaml.Node{Kind: yaml.ScalarNode, Value: m.Expr},
+			Labels: map[string]string{
+				prometheus.AlertNamespaceLabel: m.Namespace,
+				LoggingMetricLabel:             m.Name,
+			},
+		}},
+	}, nil
+}
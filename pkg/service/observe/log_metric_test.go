@@ -0,0 +1,150 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observe
+
+import (
+	"testing"
+
+	"github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1alpha1"
+	"github.com/prometheus/prometheus/pkg/rulefmt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMutateLoggingMetric(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      LoggingMetric
+		wantExpr string
+		wantErr  bool
+	}{
+		{
+			name: "generator",
+			req: LoggingMetric{
+				Namespace:      "myns",
+				Name:           "error_lines",
+				LogqlGenerator: &LogqlGenerator{Duration: "1m", Match: "error", LabelPairs: map[string]string{"pod": "mypod"}},
+			},
+			wantExpr: "sum(count_over_time({pod=~\"mypod\", namespace=\"myns\"} |~ `error` [1m]))without(fluentd_thread)",
+		},
+		{
+			name:     "expr",
+			req:      LoggingMetric{Namespace: "myns", Name: "lines", Expr: `sum(count_over_time({namespace="myns"}[1m]))`},
+			wantExpr: `sum(count_over_time({namespace="myns"}[1m]))`,
+		},
+		{
+			name:    "other namespace",
+			req:     LoggingMetric{Namespace: "myns", Name: "lines", Expr: `sum(count_over_time({namespace="other"}[1m]))`},
+			wantErr: true,
+		},
+		{
+			name:    "invalid name",
+			req:     LoggingMetric{Namespace: "myns", Name: "error-lines", Expr: `sum(count_over_time({namespace="myns"}[1m]))`},
+			wantErr: true,
+		},
+		{
+			name:    "invalid interval",
+			req:     LoggingMetric{Namespace: "myns", Name: "lines", Expr: `sum(count_over_time({namespace="myns"}[1m]))`, Interval: "xx"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := MutateLoggingMetric(&tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MutateLoggingMetric() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if tt.req.Expr != tt.wantExpr {
+				t.Errorf("MutateLoggingMetric() expr = %v, want %v", tt.req.Expr, tt.wantExpr)
+			}
+			if tt.req.Interval != defaultLoggingMetricInterval {
+				t.Errorf("MutateLoggingMetric() interval = %v, want %v", tt.req.Interval, defaultLoggingMetricInterval)
+			}
+		})
+	}
+}
+
+func TestHasNamespaceSelector(t *testing.T) {
+	tests := []struct {
+		name  string
+		logql string
+		want  bool
+	}{
+		{name: "equal", logql: `sum(count_over_time({namespace="myns", pod=~"a.*"}[1m]))`, want: true},
+		{name: "line_format template", logql: `{namespace="myns"} | line_format "{{.pod}}"`, want: true},
+		{name: "regex", logql: `{namespace=~"myns"}`, want: false},
+		{name: "other namespace", logql: `{namespace="other"}`, want: false},
+		{name: "label suffix", logql: `{kubernetes_namespace="myns"}`, want: false},
+		{name: "in line filter", logql: `{namespace="other"} |= "namespace=\"myns\""`, want: false},
+		{name: "one of selectors", logql: `sum(count_over_time({namespace="myns"}[1m])) + sum(count_over_time({namespace="other"}[1m]))`, want: false},
+		{name: "all selectors", logql: `sum(count_over_time({namespace="myns"}[1m])) / sum(count_over_time({namespace="myns", container="app"}[1m]))`, want: true},
+		{name: "no selector", logql: `namespace="myns"`, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HasNamespaceSelector(tt.logql, "myns"); got != tt.want {
+				t.Errorf("HasNamespaceSelector() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRawLoggingAlertRule_ModifyLoggingMetric(t *testing.T) {
+	raw := &RawLoggingAlertRule{
+		Base: &BaseAlertResource{
+			AMConfig: &v1alpha1.AlertmanagerConfig{ObjectMeta: metav1.ObjectMeta{Namespace: "myns"}},
+		},
+		RuleGroups: &rulefmt.RuleGroups{
+			Groups: []rulefmt.RuleGroup{{Name: "alert-1"}},
+		},
+	}
+	m := LoggingMetric{Namespace: "myns", Name: "lines", Expr: `sum(count_over_time({namespace="myns"}[1m]))`}
+	if err := MutateLoggingMetric(&m); err != nil {
+		t.Fatal(err)
+	}
+	if err := raw.ModifyLoggingMetric(m, Add); err != nil {
+		t.Fatal(err)
+	}
+	if err := raw.ModifyLoggingMetric(m, Add); err == nil {
+		t.Error("ModifyLoggingMetric() add exists metric want error")
+	}
+	metrics := raw.ToLoggingMetrics()
+	if len(metrics) != 1 || metrics[0].Name != "lines" || metrics[0].Expr != m.Expr || metrics[0].Interval != "1m" {
+		t.Fatalf("ToLoggingMetrics() = %v", metrics)
+	}
+	if metrics[0].Promql != `lines{gems_namespace="myns"}` {
+		t.Errorf("ToLoggingMetrics() promql = %v", metrics[0].Promql)
+	}
+
+	m.Interval = "5m"
+	if err := raw.ModifyLoggingMetric(m, Update); err != nil {
+		t.Fatal(err)
+	}
+	if metrics := raw.ToLoggingMetrics(); metrics[0].Interval != "5m" {
+		t.Errorf("ToLoggingMetrics() interval = %v, want 5m", metrics[0].Interval)
+	}
+
+	if err := raw.ModifyLoggingMetric(m, Delete); err != nil {
+		t.Fatal(err)
+	}
+	if len(raw.RuleGroups.Groups) != 1 || raw.RuleGroups.Groups[0].Name != "alert-1" {
+		t.Errorf("ModifyLoggingMetric() delete, groups = %v", raw.RuleGroups.Groups)
+	}
+	if err := raw.ModifyLoggingMetric(m, Delete); err == nil {
+		t.Error("ModifyLoggingMetric() delete not exists metric want error")
+	}
+}
@@ -0,0 +1,412 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observe
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+
+	"github.com/banzaicloud/logging-operator/pkg/sdk/logging/api/v1beta1"
+	"github.com/banzaicloud/logging-operator/pkg/sdk/logging/model/filter"
+	"github.com/banzaicloud/logging-operator/pkg/sdk/logging/model/output"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"kubegems.io/kubegems/pkg/apis/gems"
+)
+
+const (
+	LogParserJSON   = "json"
+	LogParserRegexp = "regexp"
+
+	defaultLogPipelineKey    = "message"
+	defaultLogMaskReplace    = "****"
+	defaultMultilineFlush    = 5
+	logPipelineSampleKey     = "_gems_sample"
+	logPipelineSampleDropped = "drop"
+)
+
+// 内置的脱敏规则, 表达式需同时兼容 ruby(fluentd) 与 go(测试接口)
+var LogMaskPresets = map[string]LogMask{
+	"credit_card": {Regexp: `\b(?:\d[ -]?){12,15}\d\b`, Replace: defaultLogMaskReplace},
+	"id_card":     {Regexp: `\b\d{17}[\dXx]\b`, Replace: defaultLogMaskReplace},
+	"phone":       {Regexp: `\b1[3-9]\d{9}\b`, Replace: defaultLogMaskReplace},
+	"email":       {Regexp: `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`, Replace: defaultLogMaskReplace},
+	"token":       {Regexp: `(?i)(bearer\s+|(?:token|password|passwd|secret|api[_-]?key)["']?\s*[:=]\s*["']?)[A-Za-z0-9\-._~+/]+=*`, Replace: `\1` + defaultLogMaskReplace},
+}
+
+// LogPipeline 应用日志管道, 对应一个 logging-operator Flow 及其额外的 Output
+// 处理顺序: 多行合并 -> 脱敏 -> 解析 -> 采样 -> 输出
+type LogPipeline struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace"`
+	Apps      map[string]string `json:"apps"` // 要采集的应用, appname-applabel key-value
+	// 日志内容所在字段, 默认 message
+	Key       string        `json:"key"`
+	Multiline *LogMultiline `json:"multiline,omitempty"`
+	Masks     []LogMask     `json:"masks,omitempty"`
+	Parser    *LogParser    `json:"parser,omitempty"`
+	Sampling  *LogSampling  `json:"sampling,omitempty"`
+	// 额外的输出, 在当前 namespace 下创建 Output
+	Outputs        []LogPipelineOutput `json:"outputs,omitempty"`
+	ClusterOutputs []string            `json:"clusterOutputs"`
+}
+
+type LogMultiline struct {
+	// 新日志的起始行正则, 不匹配的行合并到上一条日志
+	StartRegexp string `json:"startRegexp"`
+	// 等待后续行的超时时间, 秒
+	FlushInterval int `json:"flushInterval"`
+}
+
+type LogMask struct {
+	// 内置规则, credit_card/id_card/phone/email/token, 与 regexp 二选一
+	Preset  string `json:"preset,omitempty"`
+	Regexp  string `json:"regexp,omitempty"`
+	Replace string `json:"replace,omitempty"` // 支持 \1 引用分组
+}
+
+type LogParser struct {
+	Type string `json:"type"` // json, regexp
+	// regexp 类型的表达式, 使用命名分组 (?<name>...) 提取字段
+	Expression string `json:"expression,omitempty"`
+	// 解析后是否保留原始日志字段
+	KeepOriginal bool `json:"keepOriginal"`
+}
+
+type LogSampling struct {
+	// 保留的日志百分比, 1-100
+	Percent int `json:"percent"`
+}
+
+type LogPipelineOutput struct {
+	Name          string                      `json:"name"`
+	Elasticsearch *output.ElasticsearchOutput `json:"elasticsearch,omitempty"`
+	Kafka         *output.KafkaOutputConfig   `json:"kafka,omitempty"`
+	S3            *output.S3OutputConfig      `json:"s3,omitempty"`
+}
+
+func MutateLogPipeline(p *LogPipeline) error {
+	if errs := validation.IsDNS1123Label(p.Name); len(errs) > 0 {
+		return fmt.Errorf("pipeline name %s not valid: %s", p.Name, strings.Join(errs, ", "))
+	}
+	if len(p.Apps) == 0 {
+		return fmt.Errorf("must specify at least one app")
+	}
+	if p.Key == "" {
+		p.Key = defaultLogPipelineKey
+	}
+	if p.Multiline != nil {
+		if _, err := compileLogRegexp(p.Multiline.StartRegexp); err != nil || p.Multiline.StartRegexp == "" {
+			return fmt.Errorf("multiline start regexp %s not valid: %v", p.Multiline.StartRegexp, err)
+		}
+		if p.Multiline.FlushInterval <= 0 {
+			p.Multiline.FlushInterval = defaultMultilineFlush
+		}
+	}
+	for i := range p.Masks {
+		mask := &p.Masks[i]
+		if mask.Preset != "" {
+			preset, ok := LogMaskPresets[mask.Preset]
+			if !ok {
+				return fmt.Errorf("mask preset %s not found", mask.Preset)
+			}
+			mask.Regexp = preset.Regexp
+			if mask.Replace == "" {
+				mask.Replace = preset.Replace
+			}
+		}
+		if _, err := compileLogRegexp(mask.Regexp); err != nil || mask.Regexp == "" {
+			return fmt.Errorf("mask regexp %s not valid: %v", mask.Regexp, err)
+		}
+		if mask.Replace == "" {
+			mask.Replace = defaultLogMaskReplace
+		}
+	}
+	if p.Parser != nil {
+		switch p.Parser.Type {
+		case LogParserJSON:
+		case LogParserRegexp:
+			reg, err := compileLogRegexp(p.Parser.Expression)
+			if err != nil {
+				return fmt.Errorf("parser expression %s not valid: %w", p.Parser.Expression, err)
+			}
+			if strings.Join(reg.SubexpNames(), "") == "" {
+				return fmt.Errorf("parser expression must contain named groups")
+			}
+		default:
+			return fmt.Errorf("parser type %s not supported", p.Parser.Type)
+		}
+	}
+	if p.Sampling != nil && (p.Sampling.Percent <= 0 || p.Sampling.Percent > 100) {
+		return fmt.Errorf("sampling percent must be in [1, 100]")
+	}
+	names := map[string]bool{}
+	for _, o := range p.Outputs {
+		if errs := validation.IsDNS1123Label(o.Name); len(errs) > 0 {
+			return fmt.Errorf("output name %s not valid: %s", o.Name, strings.Join(errs, ", "))
+		}
+		if names[o.Name] {
+			return fmt.Errorf("output %s duplicated", o.Name)
+		}
+		names[o.Name] = true
+		count := 0
+		for _, set := range []bool{o.Elasticsearch != nil, o.Kafka != nil, o.S3 != nil} {
+			if set {
+				count++
+			}
+		}
+		if count != 1 {
+			return fmt.Errorf("output %s must specify exactly one of elasticsearch, kafka, s3", o.Name)
+		}
+	}
+	return nil
+}
+
+// compileLogRegexp 将 ruby 风格的命名分组转换为 go 风格后编译
+func compileLogRegexp(expr string) (*regexp.Regexp, error) {
+	return regexp.Compile(strings.ReplaceAll(expr, "(?<", "(?P<"))
+}
+
+// OutputName 管道输出生成的 Output 名称
+func (p *LogPipeline) OutputName(o LogPipelineOutput) string {
+	return p.Name + "-" + o.Name
+}
+
+// ToFlow 生成管道对应的 Flow, 原始配置保存在注解中
+func (p *LogPipeline) ToFlow() (*v1beta1.Flow, error) {
+	spec, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	flow := &v1beta1.Flow{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        p.Name,
+			Namespace:   p.Namespace,
+			Labels:      map[string]string{gems.LabelLogPipeline: p.Name},
+			Annotations: map[string]string{gems.AnnotationLogPipeline: string(spec)},
+		},
+		Spec: v1beta1.FlowSpec{
+			Filters:          p.filters(),
+			GlobalOutputRefs: p.ClusterOutputs,
+		},
+	}
+	appnames := []string{}
+	for appname, applabel := range p.Apps {
+		flow.Spec.Match = append(flow.Spec.Match, v1beta1.Match{
+			Select: &v1beta1.Select{Labels: map[string]string{applabel: appname}},
+		})
+		appnames = append(appnames, appname)
+	}
+	flow.Labels[gems.LabelLogCollector] = strings.Join(appnames, ", ")
+	for _, o := range p.Outputs {
+		flow.Spec.LocalOutputRefs = append(flow.Spec.LocalOutputRefs, p.OutputName(o))
+	}
+	return flow, nil
+}
+
+func (p *LogPipeline) filters() []v1beta1.Filter {
+	filters := []v1beta1.Filter{}
+	if p.Multiline != nil {
+		filters = append(filters, v1beta1.Filter{Concat: &filter.Concat{
+			Key:                  p.Key,
+			MultilineStartRegexp: rubyRegexp(p.Multiline.StartRegexp),
+			FlushInterval:        p.Multiline.FlushInterval,
+		}})
+	}
+	if len(p.Masks) > 0 {
+		replaces := make([]filter.Replace, 0, len(p.Masks))
+		for _, mask := range p.Masks {
+			replaces = append(replaces, filter.Replace{
+				Key:        p.Key,
+				Expression: rubyRegexp(mask.Regexp),
+				Replace:    mask.Replace,
+			})
+		}
+		filters = append(filters, v1beta1.Filter{RecordModifier: &filter.RecordModifier{Replaces: replaces}})
+	}
+	if p.Parser != nil {
+		emitInvalid := false
+		parser := &filter.ParserConfig{
+			KeyName:                  p.Key,
+			ReserveData:              true,
+			RemoveKeyNameField:       !p.Parser.KeepOriginal,
+			EmitInvalidRecordToError: &emitInvalid, // 解析失败的日志原样保留
+			Parse:                    filter.ParseSection{Type: p.Parser.Type},
+		}
+		if p.Parser.Type == LogParserRegexp {
+			parser.Parse.Expression = rubyRegexp(p.Parser.Expression)
+		}
+		filters = append(filters, v1beta1.Filter{Parser: parser})
+	}
+	if p.Sampling != nil && p.Sampling.Percent < 100 {
+		filters = append(filters,
+			v1beta1.Filter{RecordModifier: &filter.RecordModifier{
+				Records: []filter.Record{{
+					logPipelineSampleKey: fmt.Sprintf(`${rand(100) < %d ? 'keep' : '%s'}`, p.Sampling.Percent, logPipelineSampleDropped),
+				}},
+			}},
+			v1beta1.Filter{Grep: &filter.GrepConfig{
+				Exclude: []filter.ExcludeSection{{Key: logPipelineSampleKey, Pattern: rubyRegexp("^" + logPipelineSampleDropped + "$")}},
+			}},
+			v1beta1.Filter{RecordModifier: &filter.RecordModifier{RemoveKeys: logPipelineSampleKey}},
+		)
+	}
+	return filters
+}
+
+func rubyRegexp(expr string) string {
+	return "/" + expr + "/"
+}
+
+// ToOutputs 生成管道额外输出对应的 Output
+func (p *LogPipeline) ToOutputs() []v1beta1.Output {
+	ret := make([]v1beta1.Output, 0, len(p.Outputs))
+	for _, o := range p.Outputs {
+		ret = append(ret, v1beta1.Output{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      p.OutputName(o),
+				Namespace: p.Namespace,
+				Labels:    map[string]string{gems.LabelLogPipeline: p.Name},
+			},
+			Spec: v1beta1.OutputSpec{
+				ElasticsearchOutput: o.Elasticsearch,
+				KafkaOutputConfig:   o.Kafka,
+				S3OutputConfig:      o.S3,
+			},
+		})
+	}
+	return ret
+}
+
+// LogPipelineFromFlow 从 Flow 注解中还原管道配置
+func LogPipelineFromFlow(flow *v1beta1.Flow) (*LogPipeline, error) {
+	spec, ok := flow.Annotations[gems.AnnotationLogPipeline]
+	if !ok {
+		return nil, fmt.Errorf("flow %s is not a log pipeline", flow.Name)
+	}
+	p := &LogPipeline{}
+	if err := json.Unmarshal([]byte(spec), p); err != nil {
+		return nil, err
+	}
+	p.Name = flow.Name
+	p.Namespace = flow.Namespace
+	return p, nil
+}
+
+type LogPipelineTestResult struct {
+	Records []LogPipelineTestRecord `json:"records"`
+	Outputs []string                `json:"outputs"` // 日志将被发送到的输出
+}
+
+type LogPipelineTestRecord struct {
+	Input   string                 `json:"input"`
+	Record  map[string]interface{} `json:"record"`
+	Dropped bool                   `json:"dropped"`         // 被采样丢弃
+	Error   string                 `json:"error,omitempty"` // 解析失败原因, 失败时保留原始日志
+}
+
+// 采样使用的随机数, 便于测试替换
+var logSampleRand = rand.Intn
+
+// RunLogPipeline 在样例日志上模拟运行管道, 正则使用 go 的语法, 与 fluentd 的 ruby 正则在高级语法上可能存在差异
+func RunLogPipeline(p *LogPipeline, lines []string) (*LogPipelineTestResult, error) {
+	ret := &LogPipelineTestResult{Records: []LogPipelineTestRecord{}, Outputs: append([]string{}, p.ClusterOutputs...)}
+	for _, o := range p.Outputs {
+		ret.Outputs = append(ret.Outputs, p.OutputName(o))
+	}
+
+	inputs := lines
+	if p.Multiline != nil {
+		start, err := compileLogRegexp(p.Multiline.StartRegexp)
+		if err != nil {
+			return nil, err
+		}
+		inputs = []string{}
+		for _, line := range lines {
+			if len(inputs) == 0 || start.MatchString(line) {
+				inputs = append(inputs, line)
+			} else {
+				inputs[len(inputs)-1] += "\n" + line
+			}
+		}
+	}
+
+	masks := make([]*regexp.Regexp, len(p.Masks))
+	for i, mask := range p.Masks {
+		reg, err := compileLogRegexp(mask.Regexp)
+		if err != nil {
+			return nil, err
+		}
+		masks[i] = reg
+	}
+	var parser *regexp.Regexp
+	if p.Parser != nil && p.Parser.Type == LogParserRegexp {
+		reg, err := compileLogRegexp(p.Parser.Expression)
+		if err != nil {
+			return nil, err
+		}
+		parser = reg
+	}
+
+	for _, input := range inputs {
+		msg := input
+		for i, reg := range masks {
+			msg = reg.ReplaceAllString(msg, rubyReplacement(p.Masks[i].Replace))
+		}
+		rec := LogPipelineTestRecord{Input: input, Record: map[string]interface{}{p.Key: msg}}
+		if p.Parser != nil {
+			parsed := map[string]interface{}{}
+			switch p.Parser.Type {
+			case LogParserJSON:
+				if err := json.Unmarshal([]byte(msg), &parsed); err != nil {
+					rec.Error = err.Error()
+				}
+			case LogParserRegexp:
+				match := parser.FindStringSubmatch(msg)
+				if match == nil {
+					rec.Error = "pattern not matched"
+				}
+				for i, name := range parser.SubexpNames() {
+					if name != "" && match != nil {
+						parsed[name] = match[i]
+					}
+				}
+			}
+			if rec.Error == "" {
+				if !p.Parser.KeepOriginal {
+					delete(rec.Record, p.Key)
+				}
+				for k, v := range parsed {
+					rec.Record[k] = v
+				}
+			}
+		}
+		if p.Sampling != nil && p.Sampling.Percent < 100 {
+			rec.Dropped = logSampleRand(100) >= p.Sampling.Percent
+		}
+		ret.Records = append(ret.Records, rec)
+	}
+	return ret, nil
+}
+
+var rubyBackref = regexp.MustCompile(`\\(\d)`)
+
+// rubyReplacement 将 ruby 的分组引用 \1 转换为 go 的 ${1}
+func rubyReplacement(repl string) string {
+	return rubyBackref.ReplaceAllString(repl, `$${$1}`)
+}
@@ -0,0 +1,178 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observe
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/banzaicloud/logging-operator/pkg/sdk/logging/model/output"
+)
+
+func TestMutateLogPipeline(t *testing.T) {
+	tests := []struct {
+		name     string
+		pipeline LogPipeline
+		wantErr  bool
+	}{
+		{
+			name: "valid",
+			pipeline: LogPipeline{
+				Name:      "app-pipeline",
+				Apps:      map[string]string{"app": "app"},
+				Multiline: &LogMultiline{StartRegexp: `^\d{4}-\d{2}-\d{2}`},
+				Masks:     []LogMask{{Preset: "credit_card"}, {Regexp: `secret=\S+`}},
+				Parser:    &LogParser{Type: LogParserRegexp, Expression: `^(?<time>\S+) (?<level>\w+) (?<msg>.*)$`},
+				Sampling:  &LogSampling{Percent: 10},
+				Outputs:   []LogPipelineOutput{{Name: "es", Elasticsearch: &output.ElasticsearchOutput{}}},
+			},
+		},
+		{
+			name:     "no apps",
+			pipeline: LogPipeline{Name: "p"},
+			wantErr:  true,
+		},
+		{
+			name:     "unknown preset",
+			pipeline: LogPipeline{Name: "p", Apps: map[string]string{"app": "app"}, Masks: []LogMask{{Preset: "unknown"}}},
+			wantErr:  true,
+		},
+		{
+			name:     "regexp parser without named groups",
+			pipeline: LogPipeline{Name: "p", Apps: map[string]string{"app": "app"}, Parser: &LogParser{Type: LogParserRegexp, Expression: `^(\S+)`}},
+			wantErr:  true,
+		},
+		{
+			name:     "invalid sampling",
+			pipeline: LogPipeline{Name: "p", Apps: map[string]string{"app": "app"}, Sampling: &LogSampling{Percent: 0}},
+			wantErr:  true,
+		},
+		{
+			name: "output with multiple sinks",
+			pipeline: LogPipeline{Name: "p", Apps: map[string]string{"app": "app"}, Outputs: []LogPipelineOutput{
+				{Name: "o", Elasticsearch: &output.ElasticsearchOutput{}, Kafka: &output.KafkaOutputConfig{}},
+			}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := MutateLogPipeline(&tt.pipeline); (err != nil) != tt.wantErr {
+				t.Errorf("MutateLogPipeline() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLogPipeline_ToFlow(t *testing.T) {
+	p := &LogPipeline{
+		Name:           "app-pipeline",
+		Namespace:      "myns",
+		Apps:           map[string]string{"myapp": "app"},
+		Masks:          []LogMask{{Preset: "phone"}},
+		Parser:         &LogParser{Type: LogParserJSON},
+		Sampling:       &LogSampling{Percent: 10},
+		Outputs:        []LogPipelineOutput{{Name: "kafka", Kafka: &output.KafkaOutputConfig{}}},
+		ClusterOutputs: []string{"loki"},
+	}
+	if err := MutateLogPipeline(p); err != nil {
+		t.Fatal(err)
+	}
+	flow, err := p.ToFlow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(flow.Spec.LocalOutputRefs, []string{"app-pipeline-kafka"}) {
+		t.Errorf("ToFlow() local outputs = %v", flow.Spec.LocalOutputRefs)
+	}
+	// 脱敏 + 解析 + 采样(标记, 过滤, 删除标记)
+	if len(flow.Spec.Filters) != 5 {
+		t.Fatalf("ToFlow() filters = %d, want 5", len(flow.Spec.Filters))
+	}
+	if r := flow.Spec.Filters[0].RecordModifier; r == nil || r.Replaces[0].Expression != "/"+LogMaskPresets["phone"].Regexp+"/" {
+		t.Errorf("ToFlow() mask filter = %v", r)
+	}
+	if parser := flow.Spec.Filters[1].Parser; parser == nil || parser.KeyName != "message" || *parser.EmitInvalidRecordToError {
+		t.Errorf("ToFlow() parser filter = %v", parser)
+	}
+	if flow.Spec.Filters[3].Grep == nil {
+		t.Errorf("ToFlow() sampling filter not found")
+	}
+
+	got, err := LogPipelineFromFlow(flow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, p) {
+		t.Errorf("LogPipelineFromFlow() = %v, want %v", got, p)
+	}
+	if outputs := p.ToOutputs(); len(outputs) != 1 || outputs[0].Name != "app-pipeline-kafka" || outputs[0].Spec.KafkaOutputConfig == nil {
+		t.Errorf("ToOutputs() = %v", outputs)
+	}
+}
+
+func TestRunLogPipeline(t *testing.T) {
+	logSampleRand = func(int) int { return 50 }
+	p := &LogPipeline{
+		Name:      "p",
+		Apps:      map[string]string{"app": "app"},
+		Multiline: &LogMultiline{StartRegexp: `^\d{4}-`},
+		Masks:     []LogMask{{Preset: "credit_card"}, {Preset: "token"}},
+		Parser:    &LogParser{Type: LogParserRegexp, Expression: `(?s)^(?<time>\S+) (?<level>\w+) (?<msg>.*)$`},
+		Sampling:  &LogSampling{Percent: 60},
+	}
+	if err := MutateLogPipeline(p); err != nil {
+		t.Fatal(err)
+	}
+	lines := []string{
+		"2022-10-01T08:00:00Z INFO pay with card 4111 1111 1111 1111",
+		"2022-10-01T08:00:01Z ERROR request failed, Authorization: Bearer abc.def",
+		"\tat com.example.Main",
+		"not matched",
+	}
+	got, err := RunLogPipeline(p, lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]interface{}{
+		{"time": "2022-10-01T08:00:00Z", "level": "INFO", "msg": "pay with card ****"},
+		{"time": "2022-10-01T08:00:01Z", "level": "ERROR", "msg": "request failed, Authorization: Bearer ****\n\tat com.example.Main\nnot matched"},
+	}
+	if len(got.Records) != len(want) {
+		t.Fatalf("RunLogPipeline() records = %v", got.Records)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got.Records[i].Record, want[i]) {
+			t.Errorf("RunLogPipeline() record %d = %v, want %v", i, got.Records[i].Record, want[i])
+		}
+		if got.Records[i].Dropped {
+			t.Errorf("RunLogPipeline() record %d dropped", i)
+		}
+	}
+
+	p.Parser = &LogParser{Type: LogParserJSON, KeepOriginal: true}
+	p.Multiline = nil
+	p.Sampling.Percent = 10
+	got, err = RunLogPipeline(p, []string{`{"user":"a","token":"xyz"}`, "plain"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := got.Records[0]; rec.Error != "" || rec.Record["user"] != "a" || rec.Record["message"] != `{"user":"a","token":"****"}` || !rec.Dropped {
+		t.Errorf("RunLogPipeline() json record = %+v", rec)
+	}
+	if rec := got.Records[1]; rec.Error == "" || rec.Record["message"] != "plain" {
+		t.Errorf("RunLogPipeline() invalid json record = %+v", rec)
+	}
+}
@@ -0,0 +1,352 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/agents"
+	"kubegems.io/kubegems/pkg/utils/logexport"
+	"kubegems.io/kubegems/pkg/utils/loki"
+	"kubegems.io/kubegems/pkg/utils/prometheus/channels"
+)
+
+const (
+	defaultLogReportTopN      = 10
+	maxLogReportTopN          = 100
+	defaultLogReportTimeRange = "24h"
+	maxLogReportTimeRange     = 7 * 24 * time.Hour
+	// 按日志内容聚合时最多读取的日志条数, 超过后按已读取的日志统计
+	maxLogReportLines = 100000
+	// 按日志内容聚合时将时间范围分为多个窗口, 每个窗口最多读取 maxLogReportLines/窗口数 条, 使采样覆盖整个时间范围
+	maxLogReportSampleWindows = 48
+	maxReportKeyLength        = 200
+)
+
+var (
+	labelNameReg     = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	errReportSampled = errors.New("log report sampled")
+
+	// 日志内容聚合前替换掉其中变化的部分
+	messageNormalizers = []struct {
+		reg  *regexp.Regexp
+		repl string
+	}{
+		{regexp.MustCompile(`\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?`), "<TIME>"},
+		{regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`), "<UUID>"},
+		{regexp.MustCompile(`\b(\d{1,3}\.){3}\d{1,3}(:\d+)?\b`), "<IP>"},
+		{regexp.MustCompile(`\b(0x[0-9a-fA-F]+|[0-9a-fA-F]{12,})\b`), "<HEX>"},
+		{regexp.MustCompile(`\d+`), "<N>"},
+		{regexp.MustCompile(`\s+`), " "},
+	}
+)
+
+type LogReportItem struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+}
+
+type LogReportResult struct {
+	Name      string          `json:"name"`
+	Cluster   string          `json:"cluster"`
+	Namespace string          `json:"namespace"`
+	Start     time.Time       `json:"start"`
+	End       time.Time       `json:"end"`
+	GroupBy   string          `json:"groupBy"`
+	Total     int64           `json:"total"`
+	Sampled   bool            `json:"sampled"` // 日志过多时仅统计了部分日志
+	Items     []LogReportItem `json:"items"`
+}
+
+// MutateLogReport 校验并设置默认值
+func MutateLogReport(r *models.LogReport) error {
+	if _, err := cron.ParseStandard(r.Cron); err != nil {
+		return fmt.Errorf("cron %s not valid: %w", r.Cron, err)
+	}
+	if r.TimeRange == "" {
+		r.TimeRange = defaultLogReportTimeRange
+	}
+	dur, err := model.ParseDuration(r.TimeRange)
+	if err != nil {
+		return fmt.Errorf("time range %s not valid: %w", r.TimeRange, err)
+	}
+	if time.Duration(dur) <= 0 || time.Duration(dur) > maxLogReportTimeRange {
+		return fmt.Errorf("time range of log report must be in (0, 7d]")
+	}
+	if r.TopN <= 0 {
+		r.TopN = defaultLogReportTopN
+	}
+	if r.TopN > maxLogReportTopN {
+		return fmt.Errorf("topN can't exceed %d", maxLogReportTopN)
+	}
+	if r.GroupBy != models.LogReportGroupByMessage && !labelNameReg.MatchString(r.GroupBy) {
+		return fmt.Errorf("group by label %s not valid", r.GroupBy)
+	}
+	if !HasNamespaceSelector(r.LogQL, r.Namespace) {
+		return fmt.Errorf(`logql must select namespace="%s"`, r.Namespace)
+	}
+	return nil
+}
+
+// NextLogReportTime 报告下一次的执行时间
+func NextLogReportTime(r *models.LogReport) (time.Time, error) {
+	schedule, err := cron.ParseStandard(r.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	last := r.CreatedAt
+	if r.LastRunAt != nil {
+		last = *r.LastRunAt
+	}
+	return schedule.Next(last), nil
+}
+
+// GenerateLogReport 查询 [now-TimeRange, now] 的日志并做 TopN 聚合
+func GenerateLogReport(ctx context.Context, cli agents.Client, r *models.LogReport, now time.Time) (*LogReportResult, error) {
+	dur, err := model.ParseDuration(r.TimeRange)
+	if err != nil {
+		return nil, err
+	}
+	result := &LogReportResult{
+		Name:      r.Name,
+		Cluster:   r.Cluster,
+		Namespace: r.Namespace,
+		Start:     now.Add(-time.Duration(dur)),
+		End:       now,
+		GroupBy:   r.GroupBy,
+	}
+	if r.GroupBy != models.LogReportGroupByMessage {
+		err = topByLabel(ctx, cli, r, result)
+	} else {
+		err = topByMessage(ctx, cli, r, result)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func topByLabel(ctx context.Context, cli agents.Client, r *models.LogReport, result *LogReportResult) error {
+	counts := fmt.Sprintf("sum by (%s) (count_over_time(%s [%s]))", r.GroupBy, r.LogQL, r.TimeRange)
+	queries := map[string]string{
+		"total": fmt.Sprintf("sum(count_over_time(%s [%s]))", r.LogQL, r.TimeRange),
+		"top":   fmt.Sprintf("topk(%d, %s)", r.TopN, counts),
+	}
+	for name, expr := range queries {
+		data := &loki.QueryResponseData{}
+		if err := cli.DoRequest(ctx, agents.Request{
+			Path:  "/custom/loki/v1/query",
+			Query: agents.QueryFrom(map[string]string{"query": expr, "time": strconv.FormatInt(result.End.UnixNano(), 10)}),
+			Into:  agents.WrappedResponse(data),
+		}); err != nil {
+			return err
+		}
+		for _, sample := range data.Result {
+			metric, value := parseVectorSample(sample)
+			if name == "total" {
+				result.Total += value
+				continue
+			}
+			key := metric[r.GroupBy]
+			if key == "" {
+				key = "<empty>"
+			}
+			result.Items = append(result.Items, LogReportItem{Key: key, Count: value})
+		}
+	}
+	sortReportItems(result.Items)
+	return nil
+}
+
+func parseVectorSample(sample interface{}) (map[string]string, int64) {
+	m, ok := sample.(map[string]interface{})
+	if !ok {
+		return nil, 0
+	}
+	metric := map[string]string{}
+	if labels, ok := m["metric"].(map[string]interface{}); ok {
+		for k, v := range labels {
+			metric[k] = fmt.Sprint(v)
+		}
+	}
+	value := int64(0)
+	if pair, ok := m["value"].([]interface{}); ok && len(pair) == 2 {
+		if s, ok := pair[1].(string); ok {
+			f, _ := strconv.ParseFloat(s, 64)
+			value = int64(f)
+		}
+	}
+	return metric, value
+}
+
+type messageCounter struct {
+	counts map[string]int64
+	total  int64
+	// limit 当前窗口的截止条数
+	limit int64
+}
+
+func (c *messageCounter) Write(entry logexport.Entry) error {
+	if c.total >= c.limit {
+		return errReportSampled
+	}
+	c.counts[NormalizeLogMessage(entry.Line)]++
+	c.total++
+	return nil
+}
+
+// logReportSampleWindows 将 [start, end) 按小时分为最多 maxLogReportSampleWindows 个等长的窗口
+func logReportSampleWindows(start, end time.Time) [][2]time.Time {
+	n := int((end.Sub(start) + time.Hour - 1) / time.Hour)
+	if n > maxLogReportSampleWindows {
+		n = maxLogReportSampleWindows
+	}
+	if n < 1 {
+		n = 1
+	}
+	step := end.Sub(start) / time.Duration(n)
+	windows := make([][2]time.Time, 0, n)
+	for i := 0; i < n; i++ {
+		wstart, wend := start.Add(step*time.Duration(i)), start.Add(step*time.Duration(i+1))
+		if i == n-1 {
+			wend = end
+		}
+		windows = append(windows, [2]time.Time{wstart, wend})
+	}
+	return windows
+}
+
+func topByMessage(ctx context.Context, cli agents.Client, r *models.LogReport, result *LogReportResult) error {
+	counter := &messageCounter{counts: map[string]int64{}}
+	query := func(ctx context.Context, query map[string]string) (*loki.QueryResponseData, error) {
+		ret := &loki.QueryResponseData{}
+		err := cli.DoRequest(ctx, agents.Request{
+			Path:  "/custom/loki/v1/queryrange",
+			Query: agents.QueryFrom(query),
+			Into:  agents.WrappedResponse(ret),
+		})
+		return ret, err
+	}
+	// 每个窗口最多读取相同的条数, 避免只统计到时间范围开始的日志
+	windows := logReportSampleWindows(result.Start, result.End)
+	perWindow := int64(maxLogReportLines / len(windows))
+	for _, window := range windows {
+		counter.limit = counter.total + perWindow
+		req := logexport.Request{Query: r.LogQL, Start: window[0], End: window[1], Window: time.Hour}
+		if err := logexport.Export(ctx, query, req, counter, nil); err != nil {
+			if !errors.Is(err, errReportSampled) {
+				return err
+			}
+			result.Sampled = true
+		}
+	}
+	result.Total = counter.total
+	result.Items = topItems(counter.counts, r.TopN)
+	return nil
+}
+
+func topItems(counts map[string]int64, n int) []LogReportItem {
+	items := make([]LogReportItem, 0, len(counts))
+	for k, v := range counts {
+		items = append(items, LogReportItem{Key: k, Count: v})
+	}
+	sortReportItems(items)
+	if len(items) > n {
+		items = items[:n]
+	}
+	return items
+}
+
+func sortReportItems(items []LogReportItem) {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].Key < items[j].Key
+	})
+}
+
+// NormalizeLogMessage 将日志中的时间, ID, 数字等替换为占位符, 使相同类型的日志可以聚合
+func NormalizeLogMessage(line string) string {
+	for _, n := range messageNormalizers {
+		line = n.reg.ReplaceAllString(line, n.repl)
+	}
+	line = strings.TrimSpace(line)
+	if len([]rune(line)) > maxReportKeyLength {
+		line = string([]rune(line)[:maxReportKeyLength]) + "..."
+	}
+	return line
+}
+
+// Title 报告标题
+func (r *LogReportResult) Title() string {
+	return fmt.Sprintf("Kubegems 日志报告 [%s] [集群:%s] [namespace:%s]", r.Name, r.Cluster, r.Namespace)
+}
+
+// Text 报告内容
+func (r *LogReportResult) Text() string {
+	sb := &strings.Builder{}
+	fmt.Fprintln(sb, r.Title())
+	fmt.Fprintf(sb, "时间范围: %s ~ %s\n", r.Start.Format("2006-01-02 15:04:05"), r.End.Format("2006-01-02 15:04:05"))
+	if r.Sampled {
+		fmt.Fprintf(sb, "日志较多, 以下为在时间范围内均匀采样的 %d 条日志的统计\n", r.Total)
+	} else {
+		fmt.Fprintf(sb, "日志总数: %d\n", r.Total)
+	}
+	groupBy := "日志内容"
+	if r.GroupBy != models.LogReportGroupByMessage {
+		groupBy = r.GroupBy
+	}
+	fmt.Fprintf(sb, "按%s Top %d:\n", groupBy, len(r.Items))
+	for i, item := range r.Items {
+		fmt.Fprintf(sb, "%d. [%d] %s\n", i+1, item.Count, item.Key)
+	}
+	return sb.String()
+}
+
+// SendLogReport 将报告发送至报告配置的告警渠道
+func SendLogReport(db *gorm.DB, r *models.LogReport, result *LogReportResult) error {
+	if len(r.ChannelIDs) == 0 {
+		return fmt.Errorf("log report %s has no channels", r.Name)
+	}
+	chs := []models.AlertChannel{}
+	if err := db.Find(&chs, "id in ?", []uint(r.ChannelIDs)).Error; err != nil {
+		return err
+	}
+	errs := []string{}
+	for _, ch := range chs {
+		if ch.ChannelConfig.ChannelIf == nil {
+			continue
+		}
+		if err := channels.SendReport(ch.ChannelConfig.ChannelIf, result.Title(), result.Text()); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", ch.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("send log report failed: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observe
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"kubegems.io/kubegems/pkg/service/models"
+)
+
+func TestMutateLogReport(t *testing.T) {
+	tests := []struct {
+		name    string
+		report  models.LogReport
+		wantErr bool
+	}{
+		{
+			name:   "default",
+			report: models.LogReport{Namespace: "myns", LogQL: `{namespace="myns"} |= "error"`, Cron: "0 9 * * *"},
+		},
+		{
+			name:   "group by label",
+			report: models.LogReport{Namespace: "myns", LogQL: `{namespace="myns"}`, Cron: "0 9 * * *", GroupBy: "pod", TimeRange: "1h", TopN: 5},
+		},
+		{
+			name:    "invalid cron",
+			report:  models.LogReport{Namespace: "myns", LogQL: `{namespace="myns"}`, Cron: "every day"},
+			wantErr: true,
+		},
+		{
+			name:    "time range too long",
+			report:  models.LogReport{Namespace: "myns", LogQL: `{namespace="myns"}`, Cron: "0 9 * * *", TimeRange: "30d"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid group by",
+			report:  models.LogReport{Namespace: "myns", LogQL: `{namespace="myns"}`, Cron: "0 9 * * *", GroupBy: "app-name"},
+			wantErr: true,
+		},
+		{
+			name:    "other namespace",
+			report:  models.LogReport{Namespace: "myns", LogQL: `{namespace="other"}`, Cron: "0 9 * * *"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := MutateLogReport(&tt.report)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MutateLogReport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (tt.report.TimeRange == "" || tt.report.TopN == 0) {
+				t.Errorf("MutateLogReport() defaults not set: %+v", tt.report)
+			}
+		})
+	}
+}
+
+func TestNextLogReportTime(t *testing.T) {
+	created := time.Date(2022, 10, 1, 8, 30, 0, 0, time.Local)
+	lastRun := time.Date(2022, 10, 2, 9, 0, 0, 0, time.Local)
+	tests := []struct {
+		name   string
+		report models.LogReport
+		want   time.Time
+	}{
+		{
+			name:   "never run",
+			report: models.LogReport{Cron: "0 9 * * *", CreatedAt: created},
+			want:   time.Date(2022, 10, 1, 9, 0, 0, 0, time.Local),
+		},
+		{
+			name:   "run before",
+			report: models.LogReport{Cron: "0 9 * * *", CreatedAt: created, LastRunAt: &lastRun},
+			want:   time.Date(2022, 10, 3, 9, 0, 0, 0, time.Local),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NextLogReportTime(&tt.report)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("NextLogReportTime() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeLogMessage(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{
+			line: "2022-10-01T08:30:00.123Z request 550e8400-e29b-41d4-a716-446655440000 from 10.0.0.12:8080 failed",
+			want: "<TIME> request <UUID> from <IP> failed",
+		},
+		{
+			line: "retry   3 times,  cost 120ms",
+			want: "retry <N> times, cost <N>ms",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			if got := NormalizeLogMessage(tt.line); got != tt.want {
+				t.Errorf("NormalizeLogMessage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_topItems(t *testing.T) {
+	counts := map[string]int64{"a": 1, "b": 3, "c": 3, "d": 2}
+	want := []LogReportItem{{Key: "b", Count: 3}, {Key: "c", Count: 3}, {Key: "d", Count: 2}}
+	if got := topItems(counts, 3); !reflect.DeepEqual(got, want) {
+		t.Errorf("topItems() = %v, want %v", got, want)
+	}
+}
+
+func Test_logReportSampleWindows(t *testing.T) {
+	start := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		end     time.Time
+		want    int
+		wantLen time.Duration
+	}{
+		{name: "less than one hour", end: start.Add(30 * time.Minute), want: 1, wantLen: 30 * time.Minute},
+		{name: "hourly", end: start.Add(24 * time.Hour), want: 24, wantLen: time.Hour},
+		{name: "capped", end: start.Add(7 * 24 * time.Hour), want: maxLogReportSampleWindows, wantLen: 7 * 24 * time.Hour / maxLogReportSampleWindows},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := logReportSampleWindows(start, tt.end)
+			if len(got) != tt.want {
+				t.Fatalf("logReportSampleWindows() = %d windows, want %d", len(got), tt.want)
+			}
+			if !got[0][0].Equal(start) || !got[len(got)-1][1].Equal(tt.end) {
+				t.Errorf("logReportSampleWindows() covers %v ~ %v, want %v ~ %v", got[0][0], got[len(got)-1][1], start, tt.end)
+			}
+			for i, w := range got {
+				if w[1].Sub(w[0]) != tt.wantLen {
+					t.Errorf("window %d length = %v, want %v", i, w[1].Sub(w[0]), tt.wantLen)
+				}
+				if i > 0 && !w[0].Equal(got[i-1][1]) {
+					t.Errorf("window %d is not contiguous", i)
+				}
+			}
+		})
+	}
+}
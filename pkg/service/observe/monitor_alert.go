@@ -166,7 +166,11 @@ func (raw *RawMonitorAlertResource) ModifyAlertRule(newAlertRule MonitorAlertRul
 	if err != nil {
 		return err
 	}
+	return raw.setAlertRules(alertRules, act)
+}
 
+// setAlertRules 根据告警规则重新生成 PrometheusRule 与 AlertmanagerConfig
+func (raw *RawMonitorAlertResource) setAlertRules(alertRules AlertRuleList[MonitorAlertRule], act Action) error {
 	groups := []monitoringv1.RuleGroup{}
 	for _, alertRule := range alertRules {
 		if alertRule.BaseAlertRule.IsExtraAlert() {
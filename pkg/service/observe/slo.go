@@ -0,0 +1,337 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observe
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	prommodel "github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+)
+
+const (
+	// SLO 告警规则所在的 PrometheusRule 和 AlertmanagerConfig
+	SLOAlertCRDName = "kubegems-slo-alert-rule"
+
+	sloDefaultWindow       = "30d"
+	sloMinWindow           = 24 * time.Hour
+	sloMaxWindow           = 90 * 24 * time.Hour
+	sloAlertRepeatInterval = "1h"
+	sloBurnRateWindow      = "1h"
+)
+
+// burnRateWindow 多窗口告警的长短窗口, 在长窗口内消耗 BudgetPercent 的错误预算时告警
+type burnRateWindow struct {
+	Long          time.Duration
+	Short         time.Duration
+	BudgetPercent float64
+}
+
+type burnRateAlert struct {
+	Suffix   string
+	Severity string
+	For      string
+	Windows  []burnRateWindow
+}
+
+// 参考 Google SRE workbook 的多窗口多燃烧率告警
+var sloBurnRateAlerts = []burnRateAlert{
+	{
+		Suffix:   "fast-burn",
+		Severity: prometheus.SeverityCritical,
+		For:      "2m",
+		Windows: []burnRateWindow{
+			{Long: time.Hour, Short: 5 * time.Minute, BudgetPercent: 2},
+			{Long: 6 * time.Hour, Short: 30 * time.Minute, BudgetPercent: 5},
+		},
+	},
+	{
+		Suffix:   "slow-burn",
+		Severity: prometheus.SeverityError,
+		For:      "15m",
+		Windows: []burnRateWindow{
+			{Long: 24 * time.Hour, Short: 2 * time.Hour, BudgetPercent: 10},
+			{Long: 72 * time.Hour, Short: 6 * time.Hour, BudgetPercent: 10},
+		},
+	},
+}
+
+// SLOBudget 统计窗口内的错误预算, 百分比均为 0-100
+type SLOBudget struct {
+	Window      string  `json:"window"`
+	Target      float64 `json:"target"`
+	SLI         float64 `json:"sli"`         // 窗口内好事件占比, 无数据时为 100
+	TotalEvents float64 `json:"totalEvents"` // 窗口内总事件数
+	BadEvents   float64 `json:"badEvents"`   // 窗口内坏事件数
+	ErrorBudget float64 `json:"errorBudget"` // 窗口内允许的坏事件数
+	Consumed    float64 `json:"consumed"`    // 已消耗的错误预算百分比, 可能超过 100
+	Remaining   float64 `json:"remaining"`   // 剩余的错误预算百分比, 可能为负
+	BurnRate    float64 `json:"burnRate"`    // 最近1小时的燃烧率, 1 表示恰好在窗口结束时耗尽预算
+}
+
+func MutateSLO(slo *models.SLO, namespace string) error {
+	if slo.Window == "" {
+		slo.Window = sloDefaultWindow
+	}
+	window, err := prommodel.ParseDuration(slo.Window)
+	if err != nil {
+		return fmt.Errorf("SLO 统计窗口 %s 格式错误: %w", slo.Window, err)
+	}
+	if time.Duration(window) < sloMinWindow || time.Duration(window) > sloMaxWindow {
+		return fmt.Errorf("SLO 统计窗口需要在 1d 到 90d 之间")
+	}
+	if slo.Target <= 0 || slo.Target >= 100 {
+		return fmt.Errorf("SLO 目标需要在 0 到 100 之间(不包含)")
+	}
+	if slo.Type == "" {
+		slo.Type = models.SLOTypeAvailability
+	}
+	switch slo.Type {
+	case models.SLOTypeAvailability:
+	case models.SLOTypeLatency:
+		if slo.LatencyThreshold != "" {
+			if _, err := strconv.ParseFloat(slo.LatencyThreshold, 64); err != nil {
+				return fmt.Errorf("延迟阈值 %s 必须是数字", slo.LatencyThreshold)
+			}
+		}
+	default:
+		return fmt.Errorf("SLO 类型 %s 不支持", slo.Type)
+	}
+
+	if slo.GoodQuery == "" && slo.TotalQuery == "" {
+		if err := setDefaultSLI(slo, namespace); err != nil {
+			return err
+		}
+	}
+	for _, query := range []string{slo.GoodQuery, slo.TotalQuery} {
+		expr, err := parser.ParseExpr(query)
+		if err != nil {
+			return fmt.Errorf("SLI 查询 %s 格式错误: %w", query, err)
+		}
+		selector, ok := expr.(*parser.VectorSelector)
+		if !ok {
+			return fmt.Errorf("SLI 查询 %s 必须是计数器指标的选择器", query)
+		}
+		if !hasNamespaceMatcher(selector.LabelMatchers, namespace) {
+			return fmt.Errorf(`SLI 查询 %[1]s 必须限定 namespace, 如 {namespace="%[2]s"} 或 {destination_service_namespace="%[2]s"}`, query, namespace)
+		}
+	}
+	return nil
+}
+
+// hasNamespaceMatcher 选择器中是否包含 namespace 或 destination_service_namespace 的相等匹配
+func hasNamespaceMatcher(matchers []*labels.Matcher, namespace string) bool {
+	for _, m := range matchers {
+		if (m.Name == "namespace" || m.Name == "destination_service_namespace") &&
+			m.Type == labels.MatchEqual && m.Value == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// istioLatencyBuckets istio_request_duration_milliseconds 默认的 bucket 边界
+var istioLatencyBuckets = []float64{0.5, 1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000, 300000, 600000, 1800000, 3600000}
+
+// normalizeLatencyThreshold 延迟阈值需要是 istio 指标的 bucket 边界, 返回与指标中 le 标签一致的格式(如 500, 1.8e+06)
+func normalizeLatencyThreshold(threshold string) (string, error) {
+	value, err := strconv.ParseFloat(threshold, 64)
+	if err != nil {
+		return "", fmt.Errorf("延迟阈值 %s 必须是数字", threshold)
+	}
+	valid := make([]string, 0, len(istioLatencyBuckets))
+	for _, bucket := range istioLatencyBuckets {
+		if bucket == value {
+			return strconv.FormatFloat(bucket, 'g', 6, 64), nil
+		}
+		valid = append(valid, formatFloat(bucket))
+	}
+	return "", fmt.Errorf("延迟阈值 %s 需要是 istio 延迟指标的 bucket 之一(毫秒): %s", threshold, strings.Join(valid, ", "))
+}
+
+// setDefaultSLI 根据 istio 标准指标生成服务的 SLI
+func setDefaultSLI(slo *models.SLO, namespace string) error {
+	if slo.Service == "" {
+		return fmt.Errorf("SLI 查询与服务不能同时为空")
+	}
+	selector := fmt.Sprintf(`reporter="destination",destination_service_namespace="%s",destination_canonical_service="%s"`, namespace, slo.Service)
+	switch slo.Type {
+	case models.SLOTypeLatency:
+		if slo.LatencyThreshold == "" {
+			return fmt.Errorf("延迟 SLO 的阈值不能为空")
+		}
+		threshold, err := normalizeLatencyThreshold(slo.LatencyThreshold)
+		if err != nil {
+			return err
+		}
+		slo.LatencyThreshold = threshold
+		slo.GoodQuery = fmt.Sprintf(`istio_request_duration_milliseconds_bucket{%s,le="%s"}`, selector, slo.LatencyThreshold)
+		slo.TotalQuery = fmt.Sprintf(`istio_request_duration_milliseconds_count{%s}`, selector)
+	default:
+		slo.GoodQuery = fmt.Sprintf(`istio_requests_total{%s,response_code!~"5.."}`, selector)
+		slo.TotalQuery = fmt.Sprintf(`istio_requests_total{%s}`, selector)
+	}
+	return nil
+}
+
+// sloErrorBudgetRatio 允许的坏事件占比, 去除浮点误差以便生成可读的 promql
+func sloErrorBudgetRatio(slo *models.SLO) float64 {
+	return math.Round((100-slo.Target)*1e8) / 1e10
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// SLOErrorRatio 窗口内坏事件占比
+func SLOErrorRatio(slo *models.SLO, window string) string {
+	return fmt.Sprintf("(1 - sum(rate(%s[%s])) / sum(rate(%s[%s])))", slo.GoodQuery, window, slo.TotalQuery, window)
+}
+
+// SLOBurnRate 窗口内的错误预算燃烧率
+func SLOBurnRate(slo *models.SLO, window string) string {
+	return fmt.Sprintf("%s / %s", SLOErrorRatio(slo, window), formatFloat(sloErrorBudgetRatio(slo)))
+}
+
+// SLORemainingBudget 统计窗口内剩余的错误预算百分比, 用于查询历史
+func SLORemainingBudget(slo *models.SLO) string {
+	return fmt.Sprintf("(1 - %s) * 100", SLOBurnRate(slo, slo.Window))
+}
+
+// SLOEventsQuery 统计窗口内的好事件数与总事件数
+func SLOEventsQuery(slo *models.SLO) (string, string) {
+	return fmt.Sprintf("sum(increase(%s[%s]))", slo.GoodQuery, slo.Window),
+		fmt.Sprintf("sum(increase(%s[%s]))", slo.TotalQuery, slo.Window)
+}
+
+// SLOCurrentBurnRate 最近1小时的燃烧率
+func SLOCurrentBurnRate(slo *models.SLO) string {
+	return SLOBurnRate(slo, sloBurnRateWindow)
+}
+
+func NewSLOBudget(slo *models.SLO, good, total, burnRate float64) SLOBudget {
+	ret := SLOBudget{
+		Window:      slo.Window,
+		Target:      slo.Target,
+		SLI:         100,
+		TotalEvents: math.Round(total),
+		Remaining:   100,
+	}
+	if !math.IsNaN(burnRate) && !math.IsInf(burnRate, 0) {
+		ret.BurnRate = burnRate
+	}
+	if total <= 0 || math.IsNaN(total) || math.IsNaN(good) {
+		return ret
+	}
+	bad := math.Max(total-good, 0)
+	ret.BadEvents = math.Round(bad)
+	ret.SLI = (1 - bad/total) * 100
+	ret.ErrorBudget = total * sloErrorBudgetRatio(slo)
+	ret.Consumed = bad / ret.ErrorBudget * 100
+	ret.Remaining = 100 - ret.Consumed
+	return ret
+}
+
+// SLOAlertNames SLO 生成的所有告警规则名称
+func SLOAlertNames(slo *models.SLO) []string {
+	ret := []string{}
+	for _, alert := range sloBurnRateAlerts {
+		ret = append(ret, sloAlertName(slo, alert))
+	}
+	return ret
+}
+
+func sloAlertName(slo *models.SLO, alert burnRateAlert) string {
+	return fmt.Sprintf("slo-%s-%s", slo.Name, alert.Suffix)
+}
+
+// SLOAlertRules 生成多窗口多燃烧率告警, 长窗口超过统计窗口的组合被忽略
+func SLOAlertRules(slo *models.SLO, namespace string, channels []models.AlertChannel) ([]MonitorAlertRule, error) {
+	window, err := prommodel.ParseDuration(slo.Window)
+	if err != nil {
+		return nil, err
+	}
+	receivers := []AlertReceiver{}
+	hasDefault := false
+	for i := range channels {
+		receivers = append(receivers, AlertReceiver{AlertChannel: &channels[i], Interval: sloAlertRepeatInterval})
+		if channels[i].ID == models.DefaultChannel.ID {
+			hasDefault = true
+		}
+	}
+	if !hasDefault {
+		receivers = append(receivers, AlertReceiver{AlertChannel: models.DefaultChannel, Interval: sloAlertRepeatInterval})
+	}
+
+	ret := []MonitorAlertRule{}
+	for _, alert := range sloBurnRateAlerts {
+		conditions := []string{}
+		for _, w := range alert.Windows {
+			if w.Long > time.Duration(window) {
+				continue
+			}
+			factor := formatFloat(math.Round(w.BudgetPercent/100*float64(window)/float64(w.Long)*100) / 100)
+			conditions = append(conditions, fmt.Sprintf("(%s > %s and %s > %s)",
+				SLOBurnRate(slo, prommodel.Duration(w.Long).String()), factor,
+				SLOBurnRate(slo, prommodel.Duration(w.Short).String()), factor))
+		}
+		if len(conditions) == 0 {
+			continue
+		}
+		name := sloAlertName(slo, alert)
+		ret = append(ret, MonitorAlertRule{
+			BaseAlertRule: BaseAlertRule{
+				Namespace: namespace,
+				Name:      name,
+				// 告警值为长窗口的燃烧率
+				Expr: "(" + strings.Join(conditions, " or ") + ")",
+				For:  alert.For,
+				Message: fmt.Sprintf("%s: [cluster:{{ $externalLabels.%s }}] SLO %s error budget burning (%s), burn rate: %s",
+					name, prometheus.AlertClusterKey, slo.Name, alert.Suffix, prometheus.ValueAnnotationExpr),
+				AlertLevels: []AlertLevel{{CompareOp: ">", CompareValue: "0", Severity: alert.Severity}},
+				Receivers:   receivers,
+				IsOpen:      true,
+			},
+			Source: SLOAlertCRDName,
+		})
+	}
+	return ret, nil
+}
+
+// SyncSLOAlertRules 使用 rules 替换 SLO 已有的告警规则, rules 为空时删除
+func (raw *RawMonitorAlertResource) SyncSLOAlertRules(slo *models.SLO, rules []MonitorAlertRule) error {
+	alertRules, err := raw.ToAlerts(false)
+	if err != nil {
+		return err
+	}
+	names := map[string]bool{}
+	for _, name := range SLOAlertNames(slo) {
+		names[name] = true
+	}
+	kept := AlertRuleList[MonitorAlertRule]{}
+	for _, rule := range alertRules {
+		if !names[rule.Name] {
+			kept = append(kept, rule)
+		}
+	}
+	return raw.setAlertRules(append(kept, rules...), Update)
+}
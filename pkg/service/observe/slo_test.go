@@ -0,0 +1,161 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observe
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+)
+
+func TestMutateSLO(t *testing.T) {
+	tests := []struct {
+		name      string
+		slo       models.SLO
+		wantGood  string
+		wantTotal string
+		wantErr   bool
+	}{
+		{
+			name:      "default availability",
+			slo:       models.SLO{Service: "reviews", Target: 99.9},
+			wantGood:  `istio_requests_total{reporter="destination",destination_service_namespace="myns",destination_canonical_service="reviews",response_code!~"5.."}`,
+			wantTotal: `istio_requests_total{reporter="destination",destination_service_namespace="myns",destination_canonical_service="reviews"}`,
+		},
+		{
+			name:      "default latency",
+			slo:       models.SLO{Service: "reviews", Type: models.SLOTypeLatency, LatencyThreshold: "500", Target: 99},
+			wantGood:  `istio_request_duration_milliseconds_bucket{reporter="destination",destination_service_namespace="myns",destination_canonical_service="reviews",le="500"}`,
+			wantTotal: `istio_request_duration_milliseconds_count{reporter="destination",destination_service_namespace="myns",destination_canonical_service="reviews"}`,
+		},
+		{
+			name:      "custom",
+			slo:       models.SLO{GoodQuery: `http_requests_total{namespace="myns",code!~"5.."}`, TotalQuery: `http_requests_total{namespace="myns"}`, Target: 99.5, Window: "7d"},
+			wantGood:  `http_requests_total{namespace="myns",code!~"5.."}`,
+			wantTotal: `http_requests_total{namespace="myns"}`,
+		},
+		{
+			name:      "latency threshold normalized",
+			slo:       models.SLO{Service: "reviews", Type: models.SLOTypeLatency, LatencyThreshold: "1800000.0", Target: 99},
+			wantGood:  `istio_request_duration_milliseconds_bucket{reporter="destination",destination_service_namespace="myns",destination_canonical_service="reviews",le="1.8e+06"}`,
+			wantTotal: `istio_request_duration_milliseconds_count{reporter="destination",destination_service_namespace="myns",destination_canonical_service="reviews"}`,
+		},
+		{name: "latency threshold not bucket", slo: models.SLO{Service: "reviews", Type: models.SLOTypeLatency, LatencyThreshold: "300", Target: 99}, wantErr: true},
+		{name: "latency without threshold", slo: models.SLO{Service: "reviews", Type: models.SLOTypeLatency, Target: 99}, wantErr: true},
+		{name: "invalid target", slo: models.SLO{Service: "reviews", Target: 100}, wantErr: true},
+		{name: "window too short", slo: models.SLO{Service: "reviews", Target: 99, Window: "1h"}, wantErr: true},
+		{name: "no service", slo: models.SLO{Target: 99}, wantErr: true},
+		{name: "not selector", slo: models.SLO{GoodQuery: `sum(http_requests_total{namespace="myns"})`, TotalQuery: `http_requests_total{namespace="myns"}`, Target: 99}, wantErr: true},
+		{name: "other namespace", slo: models.SLO{GoodQuery: `http_requests_total{namespace="other"}`, TotalQuery: `http_requests_total{namespace="other"}`, Target: 99}, wantErr: true},
+		{name: "namespace regex", slo: models.SLO{GoodQuery: `http_requests_total{namespace=~"myns|other"}`, TotalQuery: `http_requests_total{namespace="myns"}`, Target: 99}, wantErr: true},
+		{name: "namespace in other label", slo: models.SLO{GoodQuery: `http_requests_total{path="namespace=\"myns\""}`, TotalQuery: `http_requests_total{namespace="myns"}`, Target: 99}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := MutateSLO(&tt.slo, "myns")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MutateSLO() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.slo.GoodQuery != tt.wantGood || tt.slo.TotalQuery != tt.wantTotal {
+				t.Errorf("MutateSLO() good = %s, total = %s", tt.slo.GoodQuery, tt.slo.TotalQuery)
+			}
+			if tt.slo.Window == "" || tt.slo.Type == "" {
+				t.Errorf("MutateSLO() should set default window and type")
+			}
+		})
+	}
+}
+
+func TestSLOAlertRules(t *testing.T) {
+	slo := &models.SLO{Name: "reviews", Service: "reviews", Target: 99.9}
+	if err := MutateSLO(slo, "myns"); err != nil {
+		t.Fatal(err)
+	}
+	channels := []models.AlertChannel{{ID: 100, Name: "ops"}}
+	rules, err := SLOAlertRules(slo, "myns", channels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("rules = %d, want 2", len(rules))
+	}
+	fast, slow := rules[0], rules[1]
+	if fast.Name != "slo-reviews-fast-burn" || fast.AlertLevels[0].Severity != prometheus.SeverityCritical {
+		t.Errorf("unexpected fast burn rule %s %v", fast.Name, fast.AlertLevels)
+	}
+	for _, want := range []string{"[1h]))) / 0.001 > 14.4 and", "[5m]))) / 0.001 > 14.4)", "[6h]))) / 0.001 > 6 and"} {
+		if !strings.Contains(fast.Expr, want) {
+			t.Errorf("fast burn expr %s should contain %s", fast.Expr, want)
+		}
+	}
+	for _, want := range []string{"[1d]))) / 0.001 > 3 and", "[3d]))) / 0.001 > 1 and"} {
+		if !strings.Contains(slow.Expr, want) {
+			t.Errorf("slow burn expr %s should contain %s", slow.Expr, want)
+		}
+	}
+	if len(fast.Receivers) != 2 || fast.Receivers[1].AlertChannel.ID != models.DefaultChannel.ID {
+		t.Errorf("receivers should contain default channel: %v", fast.Receivers)
+	}
+
+	// 转换为 PrometheusRule 后能够还原
+	group, err := monitorAlertRuleToRaw(fast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := rawToMonitorAlertRule("myns", group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.Expr != fast.Expr || back.AlertLevels[0] != fast.AlertLevels[0] {
+		t.Errorf("round trip expr = %s, levels = %v", back.Expr, back.AlertLevels)
+	}
+
+	// 统计窗口小于长窗口时忽略
+	slo.Window = "1d"
+	rules, err = SLOAlertRules(slo, "myns", channels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || strings.Contains(rules[1].Expr, "[3d]") || !strings.Contains(rules[0].Expr, "> 0.48 and") {
+		t.Errorf("unexpected rules for 1d window: %v", rules)
+	}
+}
+
+func TestNewSLOBudget(t *testing.T) {
+	slo := &models.SLO{Target: 99, Window: "30d"}
+	tests := []struct {
+		name                          string
+		good, total, burnRate         float64
+		wantSLI, wantRemaining, wantB float64
+	}{
+		{name: "no data", good: 0, total: 0, burnRate: math.NaN(), wantSLI: 100, wantRemaining: 100, wantB: 0},
+		{name: "half consumed", good: 995, total: 1000, burnRate: 0.5, wantSLI: 99.5, wantRemaining: 50, wantB: 0.5},
+		{name: "exhausted", good: 970, total: 1000, burnRate: 3, wantSLI: 97, wantRemaining: -200, wantB: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewSLOBudget(slo, tt.good, tt.total, tt.burnRate)
+			if math.Abs(got.SLI-tt.wantSLI) > 1e-9 || math.Abs(got.Remaining-tt.wantRemaining) > 1e-6 || got.BurnRate != tt.wantB {
+				t.Errorf("NewSLOBudget() = %+v", got)
+			}
+		})
+	}
+}
@@ -15,15 +15,18 @@
 package options
 
 import (
+	"kubegems.io/kubegems/pkg/service/aaa/audit"
 	microservice "kubegems.io/kubegems/pkg/service/handlers/microservice/options"
 	"kubegems.io/kubegems/pkg/utils/argo"
 	"kubegems.io/kubegems/pkg/utils/database"
 	"kubegems.io/kubegems/pkg/utils/git"
 	"kubegems.io/kubegems/pkg/utils/helm"
 	"kubegems.io/kubegems/pkg/utils/jwt"
+	"kubegems.io/kubegems/pkg/utils/logexport"
 	"kubegems.io/kubegems/pkg/utils/mongo"
 	"kubegems.io/kubegems/pkg/utils/msgbus"
 	"kubegems.io/kubegems/pkg/utils/prometheus"
+	"kubegems.io/kubegems/pkg/utils/recording"
 	"kubegems.io/kubegems/pkg/utils/redis"
 	"kubegems.io/kubegems/pkg/utils/system"
 )
@@ -36,6 +39,7 @@ type Options struct {
 	Exporter     *prometheus.ExporterOptions       `json:"exporter,omitempty"`
 	Git          *git.Options                      `json:"git,omitempty"`
 	JWT          *jwt.Options                      `json:"jwt,omitempty"`
+	LogExport    *logexport.Options                `json:"logExport,omitempty"`
 	LogLevel     string                            `json:"logLevel,omitempty"`
 	Msgbus       *msgbus.Options                   `json:"msgbus,omitempty"`
 	Mysql        *database.Options                 `json:"mysql,omitempty"`
@@ -43,6 +47,8 @@ type Options struct {
 	Microservice *microservice.MicroserviceOptions `json:"microservice,omitempty"`
 	Mongo        *mongo.Options                    `json:"mongo,omitempty"`
 	Models       *ModelsOptions                    `json:"models,omitempty"`
+	Audit        *audit.Options                    `json:"audit,omitempty"`
+	Recording    *recording.Options                `json:"recording,omitempty"`
 }
 
 type ModelsOptions struct {
@@ -63,6 +69,7 @@ func DefaultOptions() *Options {
 		Exporter:     prometheus.DefaultExporterOptions(),
 		Git:          git.NewDefaultOptions(),
 		JWT:          jwt.DefaultOptions(),
+		LogExport:    logexport.NewDefaultOptions(),
 		LogLevel:     "debug",
 		Msgbus:       msgbus.DefaultMsgbusOptions(),
 		Mysql:        database.NewDefaultOptions(),
@@ -71,6 +78,8 @@ func DefaultOptions() *Options {
 		Microservice: microservice.NewDefaultOptions(),
 		Mongo:        mongo.DefaultOptions(),
 		Models:       NewDefaultModelsOptions(),
+		Audit:        audit.NewDefaultOptions(),
+		Recording:    recording.NewDefaultOptions(),
 	}
 	defaultoptions.System.Listen = ":8020"
 	return defaultoptions
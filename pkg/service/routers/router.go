@@ -19,7 +19,6 @@ import (
 	"fmt"
 	"net"
 	"net/http"
-	"os"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -44,6 +43,7 @@ import (
 	authsource "kubegems.io/kubegems/pkg/service/handlers/authsource"
 	"kubegems.io/kubegems/pkg/service/handlers/base"
 	clusterhandler "kubegems.io/kubegems/pkg/service/handlers/cluster"
+	costhandler "kubegems.io/kubegems/pkg/service/handlers/cost"
 	environmenthandler "kubegems.io/kubegems/pkg/service/handlers/environment"
 	eventhandler "kubegems.io/kubegems/pkg/service/handlers/event"
 	loginhandler "kubegems.io/kubegems/pkg/service/handlers/login"
@@ -60,6 +60,7 @@ import (
 	proxyhandler "kubegems.io/kubegems/pkg/service/handlers/proxy"
 	registryhandler "kubegems.io/kubegems/pkg/service/handlers/registry"
 	sel "kubegems.io/kubegems/pkg/service/handlers/sels"
+	"kubegems.io/kubegems/pkg/service/handlers/sessionrecording"
 	systemrolehandler "kubegems.io/kubegems/pkg/service/handlers/systemrole"
 	tenanthandler "kubegems.io/kubegems/pkg/service/handlers/tenant"
 	userhandler "kubegems.io/kubegems/pkg/service/handlers/users"
@@ -71,9 +72,11 @@ import (
 	"kubegems.io/kubegems/pkg/utils/argo"
 	"kubegems.io/kubegems/pkg/utils/database"
 	"kubegems.io/kubegems/pkg/utils/git"
+	"kubegems.io/kubegems/pkg/utils/logexport"
 	"kubegems.io/kubegems/pkg/utils/prometheus/exporter"
 	"kubegems.io/kubegems/pkg/utils/redis"
 	"kubegems.io/kubegems/pkg/utils/tracing"
+	"kubegems.io/kubegems/pkg/utils/workflow"
 	"kubegems.io/kubegems/pkg/version"
 )
 
@@ -105,6 +108,7 @@ type Router struct {
 	Argo          *argo.Client
 	GitProvider   *git.SimpleLocalProvider
 	auditInstance *audit.DefaultAuditInstance
+	recordings    *sessionrecording.Manager
 	gin           *gin.Engine
 }
 
@@ -134,6 +138,9 @@ func (r *Router) Run(ctx context.Context) error {
 	eg.Go(func() error {
 		return r.auditInstance.Consumer(ctx)
 	})
+	eg.Go(func() error {
+		return r.recordings.RunRetention(ctx)
+	})
 	return eg.Wait()
 }
 
@@ -152,6 +159,16 @@ func (r *Router) Complete(ctx context.Context) error {
 	}
 	// audit
 	r.auditInstance = audit.NewAuditMiddleware(r.Database.DB(), cache, userif)
+	auditSinks, err := audit.NewSinks(r.Opts.Audit)
+	if err != nil {
+		return err
+	}
+	r.auditInstance.SetSinks(auditSinks...)
+	// session recording
+	r.recordings, err = sessionrecording.NewManager(ctx, r.Database.DB(), r.Opts.Recording)
+	if err != nil {
+		return err
+	}
 
 	// base handler
 	basehandler := base.NewHandler(
@@ -174,9 +191,6 @@ func (r *Router) Complete(ctx context.Context) error {
 
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler, ginSwagger.URL("/swagger/doc.json")))
 
-	dir, _ := os.Getwd()
-	router.StaticFS("/lokiExport", http.Dir(dir+"/lokiExport"))
-
 	// authMiddleware, err := auth.NewAuthMiddleware(r.Opts.JWT, r.Database, r.Redis, aaa.NewUserInfoHandler())
 	// if err != nil {
 	// 	return err
@@ -266,6 +280,9 @@ func (r *Router) Complete(ctx context.Context) error {
 	auditlogHandler := &auditloghandler.AuditLogHandler{BaseHandler: basehandler}
 	auditlogHandler.RegistRouter(rg)
 
+	sessionRecordingHandler := &sessionrecording.SessionRecordingHandler{BaseHandler: basehandler, Manager: r.recordings}
+	sessionRecordingHandler.RegistRouter(rg)
+
 	// 租户
 	tenantHandler := &tenanthandler.TenantHandler{BaseHandler: basehandler}
 	tenantHandler.RegistRouter(rg)
@@ -283,8 +300,19 @@ func (r *Router) Complete(ctx context.Context) error {
 	approveHandler.RegistRouter(rg)
 
 	// 日志
-	lokilogHandler := &lokiloghandler.LogHandler{BaseHandler: basehandler}
+	logExportStore, err := logexport.NewStore(ctx, r.Opts.LogExport)
+	if err != nil {
+		return err
+	}
+	lokilogHandler := &lokiloghandler.LogHandler{
+		BaseHandler:   basehandler,
+		Tasks:         workflow.NewClientFromRedisClient(r.Redis.Client),
+		ExportOptions: r.Opts.LogExport,
+		ExportStore:   logExportStore,
+	}
 	lokilogHandler.RegistRouter(rg)
+	// 签名的日志导出下载链接, 不需要登录
+	router.GET(logexport.LocalDownloadPath, lokilogHandler.DownloadSignedExport)
 
 	// 事件
 	eventHandler := &eventhandler.EventHandler{LogHandler: lokilogHandler}
@@ -336,13 +364,19 @@ func (r *Router) Complete(ctx context.Context) error {
 	workloadHandler := &workloadreshandler.WorkloadHandler{BaseHandler: basehandler}
 	workloadHandler.RegistRouter(rg)
 
+	// 费用统计
+	costHandler := &costhandler.CostHandler{BaseHandler: basehandler}
+	costHandler.RegistRouter(rg)
+
 	// sels
 	selHandler := &sel.SelsHandler{BaseHandler: basehandler}
 	selHandler.RegistRouter(rg)
 
 	// app handler
-	appHandler := applicationhandler.MustNewApplicationDeployHandler(r.Opts.Git, r.Argo, basehandler)
+	appHandler := applicationhandler.MustNewApplicationDeployHandler(r.Opts.Git, r.Opts.Appstore, r.Argo, basehandler)
 	appHandler.RegistRouter(rg)
+	// 外部编排仓库的 webhook 无需登录, 使用签名校验
+	router.POST("/v1/gitwebhook/project/:project_id", appHandler.GitWebhook)
 
 	// authsource
 	authSourceHandler.RegistRouter(rg)
@@ -362,7 +396,7 @@ func (r *Router) Complete(ctx context.Context) error {
 	(&announcement.AnnouncementHandler{BaseHandler: basehandler}).RegistRouter(rg)
 
 	// workload 的反向代理
-	proxyHandler := proxyhandler.ProxyHandler{BaseHandler: basehandler}
+	proxyHandler := proxyhandler.ProxyHandler{BaseHandler: basehandler, Recordings: r.recordings}
 	rg.Any("/proxy/cluster/:cluster/*action", proxyHandler.Proxy)
 	router.Any("/v1/service-proxy/cluster/:cluster/namespace/:namespace/service/:service/port/:port/*action", proxyHandler.ProxyService)
 
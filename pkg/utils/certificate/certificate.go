@@ -110,3 +110,30 @@ func formatKeyID(id []byte) string {
 
 	return s
 }
+
+// MatchHost 判断域名是否匹配, pattern 支持 *.example.com 形式的泛域名, 通配符只匹配一级域名
+func MatchHost(pattern, host string) bool {
+	pattern, host = strings.ToLower(pattern), strings.ToLower(host)
+	if pattern == host {
+		return true
+	}
+	if !strings.HasPrefix(pattern, "*.") {
+		return false
+	}
+	idx := strings.Index(host, ".")
+	return idx > 0 && host[idx:] == pattern[1:]
+}
+
+// Covers 证书是否可用于域名 host, 没有 SAN 时使用 CommonName
+func (c *Certificate) Covers(host string) bool {
+	names := c.SANs
+	if len(names) == 0 {
+		names = []string{c.Subject.CommonName}
+	}
+	for _, name := range names {
+		if MatchHost(name, host) {
+			return true
+		}
+	}
+	return false
+}
@@ -69,3 +69,25 @@ RaDPwmn9di6rji+Q4BVN1qKhh2AOgGiEK/qVNywAg7s4OOLVN4Se2gwuxlYOlNEn
 		})
 	}
 }
+
+func TestMatchHost(t *testing.T) {
+	tests := []struct {
+		pattern string
+		host    string
+		want    bool
+	}{
+		{pattern: "example.com", host: "example.com", want: true},
+		{pattern: "Example.com", host: "example.COM", want: true},
+		{pattern: "*.example.com", host: "a.example.com", want: true},
+		{pattern: "*.example.com", host: "a.b.example.com", want: false},
+		{pattern: "*.example.com", host: "example.com", want: false},
+		{pattern: "a.example.com", host: "b.example.com", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+"/"+tt.host, func(t *testing.T) {
+			if got := MatchHost(tt.pattern, tt.host); got != tt.want {
+				t.Errorf("MatchHost() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
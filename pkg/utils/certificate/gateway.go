@@ -0,0 +1,67 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certificate
+
+import (
+	networkingv1 "k8s.io/api/networking/v1"
+	gemsv1beta1 "kubegems.io/kubegems/pkg/apis/gems/v1beta1"
+)
+
+// GatewayCertificateFor 返回网关上可用于 host 的证书, 域名绑定的证书优先于默认证书
+func GatewayCertificateFor(certs []gemsv1beta1.CertificateStatus, host string) *gemsv1beta1.CertificateStatus {
+	for _, isDefault := range []bool{false, true} {
+		for i := range certs {
+			cert := &certs[i]
+			if !cert.Ready || cert.Default != isDefault || cert.SyncedSecretName == "" {
+				continue
+			}
+			for _, name := range cert.DNSNames {
+				if MatchHost(name, host) {
+					return cert
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// BindIngressTLS 为 ingress 中没有配置 tls 的域名设置网关上匹配的证书, 返回是否修改了 ingress
+func BindIngressTLS(ingress *networkingv1.Ingress, certs []gemsv1beta1.CertificateStatus) bool {
+	updated := false
+	for _, rule := range ingress.Spec.Rules {
+		if rule.Host == "" || IngressHasTLS(ingress, rule.Host) {
+			continue
+		}
+		cert := GatewayCertificateFor(certs, rule.Host)
+		if cert == nil {
+			continue
+		}
+		ingress.Spec.TLS = append(ingress.Spec.TLS, networkingv1.IngressTLS{Hosts: []string{rule.Host}, SecretName: cert.SyncedSecretName})
+		updated = true
+	}
+	return updated
+}
+
+// IngressHasTLS ingress 中是否已经为 host 配置了 tls
+func IngressHasTLS(ingress *networkingv1.Ingress, host string) bool {
+	for _, t := range ingress.Spec.TLS {
+		for _, h := range t.Hosts {
+			if MatchHost(h, host) {
+				return true
+			}
+		}
+	}
+	return false
+}
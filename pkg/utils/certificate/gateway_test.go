@@ -0,0 +1,69 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certificate
+
+import (
+	"reflect"
+	"testing"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	gemsv1beta1 "kubegems.io/kubegems/pkg/apis/gems/v1beta1"
+)
+
+func TestBindIngressTLS(t *testing.T) {
+	certs := []gemsv1beta1.CertificateStatus{
+		{Default: true, Ready: true, DNSNames: []string{"*.example.com"}, SyncedSecretName: "tgw-gw-default"},
+		{Host: "api.example.com", Ready: true, DNSNames: []string{"api.example.com"}, SyncedSecretName: "tgw-gw-api-example-com"},
+		{Host: "web.example.org", Ready: false, DNSNames: []string{"web.example.org"}, SyncedSecretName: "tgw-gw-web-example-org"},
+	}
+	tests := []struct {
+		name        string
+		hosts       []string
+		tls         []networkingv1.IngressTLS
+		want        []networkingv1.IngressTLS
+		wantUpdated bool
+	}{
+		{
+			name:        "host binding before default",
+			hosts:       []string{"api.example.com", "www.example.com"},
+			want:        []networkingv1.IngressTLS{{Hosts: []string{"api.example.com"}, SecretName: "tgw-gw-api-example-com"}, {Hosts: []string{"www.example.com"}, SecretName: "tgw-gw-default"}},
+			wantUpdated: true,
+		},
+		{
+			name:  "keep existing tls",
+			hosts: []string{"api.example.com"},
+			tls:   []networkingv1.IngressTLS{{Hosts: []string{"api.example.com"}, SecretName: "mine"}},
+			want:  []networkingv1.IngressTLS{{Hosts: []string{"api.example.com"}, SecretName: "mine"}},
+		},
+		{
+			name:  "not ready or not covered",
+			hosts: []string{"web.example.org", ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ingress := &networkingv1.Ingress{Spec: networkingv1.IngressSpec{TLS: tt.tls}}
+			for _, h := range tt.hosts {
+				ingress.Spec.Rules = append(ingress.Spec.Rules, networkingv1.IngressRule{Host: h})
+			}
+			if got := BindIngressTLS(ingress, certs); got != tt.wantUpdated {
+				t.Errorf("BindIngressTLS() = %v, want %v", got, tt.wantUpdated)
+			}
+			if !reflect.DeepEqual(ingress.Spec.TLS, tt.want) {
+				t.Errorf("BindIngressTLS() tls = %v, want %v", ingress.Spec.TLS, tt.want)
+			}
+		})
+	}
+}
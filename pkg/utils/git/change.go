@@ -0,0 +1,232 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"kubegems.io/kubegems/pkg/log"
+)
+
+// MergeConflictError 变更分支与目标分支修改了相同的文件
+type MergeConflictError struct {
+	Files []string
+}
+
+func (e MergeConflictError) Error() string {
+	return fmt.Sprintf("merge conflict on files: %s", strings.Join(e.Files, ","))
+}
+
+// CommitPushBranch 将 path 下的变更提交并推送至新的分支 branch, 而不是当前分支
+// 推送后当前分支回到提交前的版本; 没有变更时返回的 head 为空
+func (r *Repository) CommitPushBranch(ctx context.Context, path string, commit *CommitMessage, branch string) (base, head string, err error) {
+	log.FromContextOrDiscard(ctx).Info("commit push change branch", "path", path, "branch", branch)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	wt, err := r.repository.Worktree()
+	if err != nil {
+		return "", "", err
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return "", "", fmt.Errorf("unable to get wt status: %w", err)
+	}
+	if status.IsClean() {
+		return "", "", nil
+	}
+	baseref, err := r.repository.Head()
+	if err != nil {
+		return "", "", err
+	}
+	hash, err := r.commitPath(wt, status, path, commit)
+	if err != nil {
+		return "", "", err
+	}
+	// 当前分支回到提交前, 变更只存在于 branch 中
+	defer func() {
+		if reseterr := wt.Reset(&git.ResetOptions{Commit: baseref.Hash(), Mode: git.HardReset}); reseterr != nil && err == nil {
+			err = reseterr
+		}
+	}()
+
+	changeref := plumbing.NewBranchReferenceName(branch)
+	if err := r.repository.Storer.SetReference(plumbing.NewHashReference(changeref, hash)); err != nil {
+		return "", "", err
+	}
+	defer r.repository.Storer.RemoveReference(changeref)
+
+	refspec := config.RefSpec(fmt.Sprintf("+%s:%s", changeref, changeref))
+	if err := r.repository.PushContext(ctx, &git.PushOptions{Auth: r.auth, RefSpecs: []config.RefSpec{refspec}}); err != nil {
+		return "", "", err
+	}
+	return baseref.Hash().String(), hash.String(), nil
+}
+
+// FetchBranch 获取远端分支, 使得分支上的提交可以用于 Diff/HistoryFiles
+func (r *Repository) FetchBranch(ctx context.Context, branch string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.fetchBranch(ctx, branch)
+}
+
+func (r *Repository) fetchBranch(ctx context.Context, branch string) error {
+	refspec := config.RefSpec(fmt.Sprintf("+%s:refs/remotes/origin/%s", plumbing.NewBranchReferenceName(branch), branch))
+	if err := r.repository.FetchContext(ctx, &git.FetchOptions{Auth: r.auth, RefSpecs: []config.RefSpec{refspec}, Force: true}); err != nil {
+		if !errors.Is(err, git.NoErrAlreadyUpToDate) {
+			return fmt.Errorf("failed to fetch branch %s: %w", branch, err)
+		}
+	}
+	return nil
+}
+
+// DeleteBranch 删除远端分支
+func (r *Repository) DeleteBranch(ctx context.Context, branch string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	refspec := config.RefSpec(":" + plumbing.NewBranchReferenceName(branch).String())
+	if err := r.repository.PushContext(ctx, &git.PushOptions{Auth: r.auth, RefSpecs: []config.RefSpec{refspec}}); err != nil {
+		if !errors.Is(err, git.NoErrAlreadyUpToDate) {
+			return err
+		}
+	}
+	return nil
+}
+
+// MergeBranch 将变更分支 branch 上基于 base 的修改合并到当前分支并推送
+// 当前分支在 base 之后没有修改时直接快进; 否则按文件合并, path 下双方修改了同一文件时返回 MergeConflictError
+func (r *Repository) MergeBranch(ctx context.Context, path, branch, base string, commit *CommitMessage) (string, error) {
+	if err := r.resetOriginLatest(ctx, git.HardReset); err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.fetchBranch(ctx, branch); err != nil {
+		return "", err
+	}
+	head, err := r.repository.ResolveRevision(plumbing.Revision("refs/remotes/origin/" + branch))
+	if err != nil {
+		return "", err
+	}
+	current, err := r.repository.Head()
+	if err != nil {
+		return "", err
+	}
+	wt, err := r.repository.Worktree()
+	if err != nil {
+		return "", err
+	}
+
+	merged := *head
+	if current.Hash().String() != base {
+		changes, err := r.changedFiles(ctx, base, head.String(), path)
+		if err != nil {
+			return "", err
+		}
+		targetChanges, err := r.changedFiles(ctx, base, current.Hash().String(), path)
+		if err != nil {
+			return "", err
+		}
+		if conflicts := conflictFiles(changes, targetChanges); len(conflicts) > 0 {
+			return "", MergeConflictError{Files: conflicts}
+		}
+		// 按文件应用变更分支上的修改
+		for name, content := range changes {
+			if content == nil {
+				if err := wt.Filesystem.Remove(name); err != nil && !os.IsNotExist(err) {
+					return "", err
+				}
+				continue
+			}
+			if err := util.WriteFile(wt.Filesystem, name, content, os.ModePerm); err != nil {
+				return "", err
+			}
+		}
+		status, err := wt.Status()
+		if err != nil {
+			return "", err
+		}
+		if merged, err = r.commitPath(wt, status, path, commit); err != nil {
+			return "", err
+		}
+	} else if err := wt.Reset(&git.ResetOptions{Commit: merged, Mode: git.HardReset}); err != nil {
+		return "", err
+	}
+
+	if err := r.repository.PushContext(ctx, &git.PushOptions{Auth: r.auth}); err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		// 推送失败时回到远端版本, 避免本地残留未推送的提交
+		_ = wt.Reset(&git.ResetOptions{Commit: current.Hash(), Mode: git.HardReset})
+		return "", err
+	}
+	return merged.String(), nil
+}
+
+// changedFiles from 到 to 之间 path 下修改的文件及修改后的内容, 删除的文件内容为 nil
+func (r *Repository) changedFiles(ctx context.Context, from, to, path string) (map[string][]byte, error) {
+	if path != "" && !strings.HasSuffix(path, "/") {
+		path = path + "/"
+	}
+	fromcommit, err := r.repository.CommitObject(plumbing.NewHash(from))
+	if err != nil {
+		return nil, err
+	}
+	tocommit, err := r.repository.CommitObject(plumbing.NewHash(to))
+	if err != nil {
+		return nil, err
+	}
+	patch, err := fromcommit.PatchContext(ctx, tocommit)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string][]byte{}
+	for _, filepatch := range patch.FilePatches() {
+		from, to := filepatch.Files()
+		if from != nil && strings.HasPrefix(from.Path(), path) {
+			files[from.Path()] = nil
+		}
+		if to != nil && strings.HasPrefix(to.Path(), path) {
+			content, err := readDiffFileContent(r, to)
+			if err != nil {
+				return nil, err
+			}
+			files[to.Path()] = content
+		}
+	}
+	return files, nil
+}
+
+func conflictFiles(a, b map[string][]byte) []string {
+	conflicts := []string{}
+	for name, content := range a {
+		if other, ok := b[name]; ok && string(other) != string(content) {
+			conflicts = append(conflicts, name)
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
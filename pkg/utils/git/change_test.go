@@ -0,0 +1,61 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_conflictFiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		change map[string][]byte
+		target map[string][]byte
+		want   []string
+	}{
+		{
+			name:   "different files",
+			change: map[string][]byte{"app/deployment.yaml": []byte("a")},
+			target: map[string][]byte{"app/service.yaml": []byte("b")},
+			want:   []string{},
+		},
+		{
+			name:   "same file same content",
+			change: map[string][]byte{"app/deployment.yaml": []byte("a")},
+			target: map[string][]byte{"app/deployment.yaml": []byte("a")},
+			want:   []string{},
+		},
+		{
+			name:   "same file modified",
+			change: map[string][]byte{"app/deployment.yaml": []byte("a"), "app/service.yaml": []byte("b")},
+			target: map[string][]byte{"app/deployment.yaml": []byte("c"), "app/service.yaml": []byte("b")},
+			want:   []string{"app/deployment.yaml"},
+		},
+		{
+			name:   "deleted and modified",
+			change: map[string][]byte{"app/service.yaml": nil, "app/configmap.yaml": []byte("a")},
+			target: map[string][]byte{"app/service.yaml": []byte("b"), "app/configmap.yaml": nil},
+			want:   []string{"app/configmap.yaml", "app/service.yaml"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := conflictFiles(tt.change, tt.target); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("conflictFiles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -20,41 +20,61 @@ import (
 	"net/http"
 
 	"code.gitea.io/sdk/gitea"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
 )
 
 type GiteaRemote struct {
 	*gitea.Client
+	addr string
+	auth *githttp.BasicAuth
 }
 
-func (h *GiteaRemote) EnsureRepo(ctx context.Context, orgname, reponame string) (*gitea.Repository, error) {
-	repo, resp, err := h.GetRepo(orgname, reponame)
+func NewGiteaRemote(addr, username, password string) (*GiteaRemote, error) {
+	giteacli, err := gitea.NewClient(addr, gitea.SetBasicAuth(username, password))
+	if err != nil {
+		return nil, err
+	}
+	return &GiteaRemote{
+		Client: giteacli,
+		addr:   addr,
+		auth:   &githttp.BasicAuth{Username: username, Password: password},
+	}, nil
+}
+
+func (h *GiteaRemote) CloneURL(org, repo string) string {
+	return fmt.Sprintf("%s/%s/%s.git", h.addr, org, repo)
+}
+
+func (h *GiteaRemote) Auth() *githttp.BasicAuth {
+	return h.auth
+}
+
+func (h *GiteaRemote) EnsureRepo(ctx context.Context, orgname, reponame string) error {
+	_, resp, err := h.GetRepo(orgname, reponame)
 	if err != nil {
 		if resp == nil || resp.StatusCode != http.StatusNotFound {
-			return nil, err
+			return err
 		}
 		// create a new repo
 		org, resp, err := h.GetOrg(orgname)
 		if err != nil {
 			if resp == nil || resp.StatusCode != http.StatusNotFound {
-				return nil, err
+				return err
 			}
 			org, _, err = h.CreateOrg(gitea.CreateOrgOption{
 				Name: orgname, FullName: orgname,
 				Description: fmt.Sprintf("org for tenaut %s", orgname),
 			})
 			if err != nil {
-				return nil, err
+				return err
 			}
 		}
 
-		repo, _, err = h.CreateOrgRepo(org.FullName, gitea.CreateRepoOption{
+		_, _, err = h.CreateOrgRepo(org.FullName, gitea.CreateRepoOption{
 			Name:        reponame,
 			Description: fmt.Sprintf("repo for project [%s]", reponame),
 		})
-		if err != nil {
-			return nil, err
-		}
-		return repo, nil
+		return err
 	}
-	return repo, nil
+	return nil
 }
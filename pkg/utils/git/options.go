@@ -34,6 +34,10 @@ type Options struct {
 	Addr     string `json:"addr" description:"git addr"`
 	Username string `json:"username" description:"git username"`
 	Password string `json:"password" description:"git password"`
+	// CredentialKey 加密外部仓库凭据的密钥, 为空时使用 Password
+	CredentialKey string `json:"credentialKey" description:"key to encrypt credentials of external git repositories, use password if empty"`
+	// AllowPrivateRemotes 是否允许内网地址的外部仓库, 回环地址与链路本地地址始终不允许
+	AllowPrivateRemotes bool `json:"allowPrivateRemotes" description:"allow external git repositories in private networks"`
 }
 
 func NewDefaultOptions() *Options {
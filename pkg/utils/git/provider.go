@@ -17,12 +17,11 @@ package git
 import (
 	"context"
 	"errors"
-	"fmt"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
-	"code.gitea.io/sdk/gitea"
 	"github.com/go-git/go-billy/v5/memfs"
 	"github.com/go-git/go-billy/v5/util"
 	"github.com/go-git/go-git/v5"
@@ -30,7 +29,6 @@ import (
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/transport"
-	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/go-git/go-git/v5/storage/memory"
 	"kubegems.io/kubegems/pkg/log"
 )
@@ -43,7 +41,7 @@ const (
 type SimpleLocalProvider struct {
 	options     *Options
 	gitea       *GiteaRemote
-	auth        *http.BasicAuth
+	resolver    RemoteResolver
 	caches      sync.Map
 	cacheexpire time.Duration
 	committer   object.Signature
@@ -56,19 +54,13 @@ type cacheKey struct {
 }
 
 func NewProvider(options *Options) (*SimpleLocalProvider, error) {
-	giteacli, err := gitea.NewClient(options.Addr, gitea.SetBasicAuth(options.Username, options.Password))
+	giteaRemote, err := NewGiteaRemote(options.Addr, options.Username, options.Password)
 	if err != nil {
 		return nil, err
 	}
 	return &SimpleLocalProvider{
 		options: options,
-		gitea: &GiteaRemote{
-			Client: giteacli,
-		},
-		auth: &http.BasicAuth{
-			Username: options.Username,
-			Password: options.Password,
-		},
+		gitea:   giteaRemote,
 		committer: object.Signature{
 			Name:  DefaultCommiter.Name,
 			Email: DefaultCommiter.Email,
@@ -89,7 +81,55 @@ func (p *SimpleLocalProvider) Options() *Options {
 }
 
 func (p *SimpleLocalProvider) GenerateCloneURL(ctx context.Context, ref RepositoryRef) string {
-	return fmt.Sprintf("%s/%s/%s.git", p.options.Addr, ref.Org, ref.Repo)
+	return p.gitea.CloneURL(ref.Org, ref.Repo)
+}
+
+// SetRemoteResolver 设置仓库的外部git服务, 未设置时所有仓库均在内置的 gitea 中
+func (p *SimpleLocalProvider) SetRemoteResolver(resolver RemoteResolver) {
+	p.resolver = resolver
+}
+
+// Invalidate 清除仓库的所有分支缓存, 在仓库的远程服务变更后调用
+func (p *SimpleLocalProvider) Invalidate(org, repo string) {
+	p.caches.Range(func(key, _ interface{}) bool {
+		if k := key.(cacheKey); k.Org == org && k.Repo == repo {
+			p.caches.Delete(key)
+		}
+		return true
+	})
+}
+
+// Refresh 立即拉取已缓存的仓库, branch 为远程服务上的分支名, 为空时拉取所有分支
+// 用于 webhook 通知仓库变更
+func (p *SimpleLocalProvider) Refresh(ctx context.Context, org, repo, branch string) error {
+	var errs []string
+	p.caches.Range(func(key, value interface{}) bool {
+		k, cached := key.(cacheKey), value.(*Repository)
+		if k.Org != org || k.Repo != repo || (branch != "" && cached.branch != branch) {
+			return true
+		}
+		if err := cached.Pull(ctx); err != nil {
+			errs = append(errs, err.Error())
+		}
+		return true
+	})
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ";"))
+	}
+	return nil
+}
+
+func (p *SimpleLocalProvider) remoteOf(ctx context.Context, ref RepositoryRef) (Remote, RepositoryRef, error) {
+	if p.resolver != nil {
+		remote, remoteref, err := p.resolver(ctx, ref)
+		if err != nil {
+			return nil, ref, err
+		}
+		if remote != nil {
+			return remote, remoteref, nil
+		}
+	}
+	return p.gitea, ref, nil
 }
 
 func (p *SimpleLocalProvider) Get(ctx context.Context, ref RepositoryRef) (*Repository, error) {
@@ -105,18 +145,23 @@ func (p *SimpleLocalProvider) Get(ctx context.Context, ref RepositoryRef) (*Repo
 	// from cache
 	obj, ok := p.caches.Load(target)
 	if !ok {
-		// clone
-		_, err := p.gitea.EnsureRepo(ctx, org, repo)
+		remote, remoteref, err := p.remoteOf(ctx, RepositoryRef{Org: org, Repo: repo, Branch: branch, Path: ref.Path})
 		if err != nil {
 			return nil, err
 		}
-		cloneurl := p.GenerateCloneURL(ctx, ref)
+		branch := remoteref.Branch
+		auth := remote.Auth()
+		// clone
+		if err := remote.EnsureRepo(ctx, remoteref.Org, remoteref.Repo); err != nil {
+			return nil, err
+		}
+		cloneurl := remote.CloneURL(remoteref.Org, remoteref.Repo)
 
 		logger = logger.WithValues("url", cloneurl, "branch", branch)
 		logger.Info("cloning")
 		repository, err := git.CloneContext(ctx, memory.NewStorage(), memfs.New(), &git.CloneOptions{
 			URL:           cloneurl,
-			Auth:          p.auth,
+			Auth:          auth,
 			ReferenceName: plumbing.NewBranchReferenceName(branch),
 			SingleBranch:  true,
 			Tags:          git.NoTags,
@@ -169,13 +214,14 @@ func (p *SimpleLocalProvider) Get(ctx context.Context, ref RepositoryRef) (*Repo
 				return nil, err
 			}
 			// git push
-			if err := repository.PushContext(ctx, &git.PushOptions{Auth: p.auth}); err != nil {
+			if err := repository.PushContext(ctx, &git.PushOptions{Auth: auth}); err != nil {
 				return nil, err
 			}
 		}
 		tocacherepo := &Repository{
 			repository: repository,
 			cloneurl:   cloneurl,
+			auth:       auth,
 			p:          p,
 			branch:     branch,
 			lastsync:   time.Now(),
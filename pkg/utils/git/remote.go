@@ -34,7 +34,7 @@ func (p *SimpleLocalProvider) GetRemoteRepoRevMeta(ctx context.Context, repourl
 	// clone now
 	repository, err := git.CloneContext(ctx, memory.NewStorage(), nil, &git.CloneOptions{
 		URL:  repourl,
-		Auth: p.auth,
+		Auth: p.gitea.Auth(),
 	})
 	if err != nil {
 		return nil, err
@@ -0,0 +1,256 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+const (
+	RemoteKindGitea   = "gitea"
+	RemoteKindGitLab  = "gitlab"
+	RemoteKindGitHub  = "github"
+	RemoteKindGeneric = "generic"
+)
+
+// Remote 托管git仓库的服务
+type Remote interface {
+	// CloneURL 仓库的 clone 地址
+	CloneURL(org, repo string) string
+	// EnsureRepo 确保仓库存在, 支持的服务上会自动创建仓库
+	EnsureRepo(ctx context.Context, org, repo string) error
+	// Auth clone/push 使用的认证
+	Auth() *githttp.BasicAuth
+}
+
+// RemoteOptions 外部git服务的配置, Password 可以为 access token
+type RemoteOptions struct {
+	Kind     string `json:"kind"`
+	Addr     string `json:"addr"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// RemoteResolver 返回仓库实际所在的服务以及在该服务上的位置, remote 为 nil 时使用内置的 gitea
+type RemoteResolver func(ctx context.Context, ref RepositoryRef) (Remote, RepositoryRef, error)
+
+func NewRemote(options RemoteOptions) (Remote, error) {
+	options.Addr = strings.TrimSuffix(options.Addr, "/")
+	auth := &githttp.BasicAuth{Username: options.Username, Password: options.Password}
+	switch options.Kind {
+	case RemoteKindGitea:
+		return NewGiteaRemote(options.Addr, options.Username, options.Password)
+	case RemoteKindGitLab:
+		return &GitLabRemote{addr: options.Addr, auth: auth}, nil
+	case RemoteKindGitHub:
+		return &GitHubRemote{addr: options.Addr, auth: auth}, nil
+	case RemoteKindGeneric:
+		return &GenericRemote{addr: options.Addr, auth: auth}, nil
+	default:
+		return nil, fmt.Errorf("unsupported git remote kind %s", options.Kind)
+	}
+}
+
+// ValidateRemoteAddr 校验外部git服务的地址, 只允许 http/https, 避免通过外部仓库访问服务端所在的内网.
+// 回环地址, 链路本地地址(如云厂商的 metadata 服务)始终不允许, allowPrivate 为 false 时也不允许私有网络地址
+func ValidateRemoteAddr(ctx context.Context, kind, addr string, allowPrivate bool) error {
+	if addr == "" {
+		if kind == RemoteKindGitHub {
+			return nil
+		}
+		return fmt.Errorf("address of %s remote is required", kind)
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return fmt.Errorf("invalid git remote address %s: %w", addr, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("git remote address %s must be http or https", addr)
+	}
+	if u.Hostname() == "" || u.User != nil {
+		return fmt.Errorf("invalid git remote address %s", addr)
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("resolve git remote address %s: %w", addr, err)
+	}
+	for _, ip := range ips {
+		if ip.IP.IsLoopback() || ip.IP.IsLinkLocalUnicast() || ip.IP.IsLinkLocalMulticast() ||
+			ip.IP.IsUnspecified() || ip.IP.IsMulticast() || (!allowPrivate && ip.IP.IsPrivate()) {
+			return fmt.Errorf("git remote address %s resolves to disallowed address %s", addr, ip.IP)
+		}
+	}
+	return nil
+}
+
+// GenericRemote 仅支持 clone/push 的git服务, 仓库需要预先创建
+type GenericRemote struct {
+	addr string
+	auth *githttp.BasicAuth
+}
+
+func (r *GenericRemote) CloneURL(org, repo string) string {
+	return fmt.Sprintf("%s/%s/%s.git", r.addr, org, repo)
+}
+
+func (r *GenericRemote) EnsureRepo(ctx context.Context, org, repo string) error {
+	return nil
+}
+
+func (r *GenericRemote) Auth() *githttp.BasicAuth {
+	return r.auth
+}
+
+// GitLabRemote org 为 group 的完整路径, 如 platform/manifests
+type GitLabRemote struct {
+	addr string
+	auth *githttp.BasicAuth
+}
+
+func (r *GitLabRemote) CloneURL(org, repo string) string {
+	return fmt.Sprintf("%s/%s/%s.git", r.addr, org, repo)
+}
+
+func (r *GitLabRemote) Auth() *githttp.BasicAuth {
+	return r.auth
+}
+
+func (r *GitLabRemote) EnsureRepo(ctx context.Context, org, repo string) error {
+	fullpath := escapePath(org + "/" + repo)
+	if err := r.do(ctx, http.MethodGet, "/projects/"+fullpath, nil, nil); err == nil || !isNotFound(err) {
+		return err
+	}
+	namespace := &struct {
+		ID int `json:"id"`
+	}{}
+	if err := r.do(ctx, http.MethodGet, "/namespaces/"+escapePath(org), nil, namespace); err != nil {
+		return fmt.Errorf("get gitlab namespace %s: %w", org, err)
+	}
+	body := map[string]interface{}{"name": repo, "path": repo, "namespace_id": namespace.ID, "visibility": "private"}
+	return r.do(ctx, http.MethodPost, "/projects", body, nil)
+}
+
+func (r *GitLabRemote) do(ctx context.Context, method, path string, body, into interface{}) error {
+	return doJSON(ctx, method, r.addr+"/api/v4"+path, body, into, func(req *http.Request) {
+		req.Header.Set("PRIVATE-TOKEN", r.auth.Password)
+	})
+}
+
+// GitHubRemote addr 为 https://github.com 或者 GitHub Enterprise 的地址
+type GitHubRemote struct {
+	addr string
+	auth *githttp.BasicAuth
+}
+
+func (r *GitHubRemote) CloneURL(org, repo string) string {
+	return fmt.Sprintf("%s/%s/%s.git", r.githubAddr(), org, repo)
+}
+
+func (r *GitHubRemote) Auth() *githttp.BasicAuth {
+	return r.auth
+}
+
+func (r *GitHubRemote) EnsureRepo(ctx context.Context, org, repo string) error {
+	if err := r.do(ctx, http.MethodGet, fmt.Sprintf("/repos/%s/%s", org, repo), nil, nil); err == nil || !isNotFound(err) {
+		return err
+	}
+	body := map[string]interface{}{"name": repo, "private": true}
+	// 个人仓库与组织仓库的创建接口不同
+	if strings.EqualFold(org, r.auth.Username) {
+		return r.do(ctx, http.MethodPost, "/user/repos", body, nil)
+	}
+	return r.do(ctx, http.MethodPost, fmt.Sprintf("/orgs/%s/repos", org), body, nil)
+}
+
+func (r *GitHubRemote) githubAddr() string {
+	if r.addr == "" {
+		return "https://github.com"
+	}
+	return r.addr
+}
+
+func (r *GitHubRemote) apiAddr() string {
+	if addr := r.githubAddr(); addr != "https://github.com" {
+		return addr + "/api/v3"
+	}
+	return "https://api.github.com"
+}
+
+func (r *GitHubRemote) do(ctx context.Context, method, path string, body, into interface{}) error {
+	return doJSON(ctx, method, r.apiAddr()+path, body, into, func(req *http.Request) {
+		req.Header.Set("Authorization", "token "+r.auth.Password)
+		req.Header.Set("Accept", "application/vnd.github+json")
+	})
+}
+
+type remoteStatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e remoteStatusError) Error() string {
+	return fmt.Sprintf("remote responsed %d: %s", e.StatusCode, e.Message)
+}
+
+func isNotFound(err error) bool {
+	statuserr, ok := err.(remoteStatusError)
+	return ok && statuserr.StatusCode == http.StatusNotFound
+}
+
+func escapePath(path string) string {
+	return strings.ReplaceAll(path, "/", "%2F")
+}
+
+func doJSON(ctx context.Context, method, url string, body, into interface{}, setheader func(req *http.Request)) error {
+	var reader io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(content)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setheader(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		content, _ := io.ReadAll(resp.Body)
+		return remoteStatusError{StatusCode: resp.StatusCode, Message: string(content)}
+	}
+	if into != nil {
+		return json.NewDecoder(resp.Body).Decode(into)
+	}
+	return nil
+}
@@ -30,6 +30,7 @@ import (
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/format/diff"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/opentracing/opentracing-go"
 	"kubegems.io/kubegems/pkg/log"
 )
@@ -39,6 +40,7 @@ type Repository struct {
 	p          *SimpleLocalProvider
 	branch     string
 	cloneurl   string
+	auth       *githttp.BasicAuth
 	lastsync   time.Time
 	repository *git.Repository
 
@@ -47,6 +49,11 @@ type Repository struct {
 
 func (r *Repository) CloneURL() string { return r.cloneurl }
 
+// Branch 远程服务上的分支名
+func (r *Repository) Branch() string { return r.branch }
+
+func (r *Repository) Auth() *githttp.BasicAuth { return r.auth }
+
 func (r *Repository) Pull(ctx context.Context) error {
 	if r.pulllock == nil {
 		r.pulllock = &pulllock{}
@@ -63,7 +70,7 @@ func (r *Repository) resetOriginLatest(ctx context.Context, resetmode git.ResetM
 	defer r.mu.Unlock()
 
 	// git remote update
-	if err := r.repository.FetchContext(ctx, &git.FetchOptions{Auth: r.p.auth, Force: true}); err != nil {
+	if err := r.repository.FetchContext(ctx, &git.FetchOptions{Auth: r.auth, Force: true}); err != nil {
 		if !errors.Is(err, git.NoErrAlreadyUpToDate) {
 			return fmt.Errorf("failed to fetch remote: %w", err)
 		}
@@ -306,39 +313,13 @@ func (r *Repository) CommitPush(ctx context.Context, path string, commit *Commit
 	}
 	if !status.IsClean() {
 		log.FromContextOrDiscard(ctx).Info("wotktree not clean do commit", "path", path)
-		// add 删除的文件会失败 https://github.com/go-git/go-git/pull/242
-		// 如果该bug修好了就可以改为
-		// if err := wt.AddWithOptions(&git.AddOptions{Path: ref.Path}); err != nil {
-		// return fmt.Errorf("failed to add worktree changes: %w", err)
-		// }
-
-		// git add {path}
-		for filename, filestatus := range status {
-			if !strings.HasPrefix(filename, path) {
-				continue
-			}
-			if filestatus.Worktree == git.Deleted {
-				if _, err := wt.Remove(filename); err != nil {
-					return fmt.Errorf("failed to stash %s: %w", filename, err)
-				}
-			} else {
-				if err := wt.AddWithOptions(&git.AddOptions{Path: filename}); err != nil {
-					return fmt.Errorf("failed to stash %s: %w", filename, err)
-				}
-			}
-		}
-
-		// git commit -m {msg}
-		if _, err := wt.Commit(commit.Message, &git.CommitOptions{
-			Author:    &object.Signature{Name: commit.Committer.Name, Email: commit.Committer.Email, When: time.Now()},
-			Committer: r.p.commiter(),
-		}); err != nil {
-			return fmt.Errorf("failed to commit %w", err)
+		if _, err := r.commitPath(wt, status, path, commit); err != nil {
+			return err
 		}
 	}
 
 	// git push origin {branch}
-	if err := r.repository.PushContext(ctx, &git.PushOptions{Auth: r.p.auth}); err != nil {
+	if err := r.repository.PushContext(ctx, &git.PushOptions{Auth: r.auth}); err != nil {
 		if errors.Is(err, git.NoErrAlreadyUpToDate) {
 			return nil
 		}
@@ -347,6 +328,41 @@ func (r *Repository) CommitPush(ctx context.Context, path string, commit *Commit
 	return nil
 }
 
+// commitPath 提交 path 下的变更
+func (r *Repository) commitPath(wt *git.Worktree, status git.Status, path string, commit *CommitMessage) (plumbing.Hash, error) {
+	// add 删除的文件会失败 https://github.com/go-git/go-git/pull/242
+	// 如果该bug修好了就可以改为
+	// if err := wt.AddWithOptions(&git.AddOptions{Path: ref.Path}); err != nil {
+	// return fmt.Errorf("failed to add worktree changes: %w", err)
+	// }
+
+	// git add {path}
+	for filename, filestatus := range status {
+		if !strings.HasPrefix(filename, path) {
+			continue
+		}
+		if filestatus.Worktree == git.Deleted {
+			if _, err := wt.Remove(filename); err != nil {
+				return plumbing.ZeroHash, fmt.Errorf("failed to stash %s: %w", filename, err)
+			}
+		} else {
+			if err := wt.AddWithOptions(&git.AddOptions{Path: filename}); err != nil {
+				return plumbing.ZeroHash, fmt.Errorf("failed to stash %s: %w", filename, err)
+			}
+		}
+	}
+
+	// git commit -m {msg}
+	hash, err := wt.Commit(commit.Message, &git.CommitOptions{
+		Author:    &object.Signature{Name: commit.Committer.Name, Email: commit.Committer.Email, When: time.Now()},
+		Committer: r.p.commiter(),
+	})
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to commit %w", err)
+	}
+	return hash, nil
+}
+
 func isNonFastForwardError(err error) bool {
 	if err == nil {
 		return false
@@ -0,0 +1,193 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+const (
+	ValuesChangeAdded   = "added"
+	ValuesChangeRemoved = "removed"
+	ValuesChangeChanged = "changed"
+)
+
+// GetChart 从仓库下载指定版本的 chart
+func (r *LegencyRepository) GetChart(ctx context.Context, name, version string) (*chart.Chart, error) {
+	index, err := r.GetIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cv, err := index.Get(name, version)
+	if err != nil {
+		return nil, err
+	}
+	if len(cv.URLs) == 0 {
+		return nil, fmt.Errorf("chart %s-%s has no downloadable url", name, version)
+	}
+	iocloser, err := r.GetFile(ctx, cv.URLs[0])
+	if err != nil {
+		return nil, err
+	}
+	defer iocloser.Close()
+	content, err := ioutil.ReadAll(iocloser)
+	if err != nil {
+		return nil, err
+	}
+	return loader.LoadArchive(bytes.NewReader(content))
+}
+
+// ValuesSchema 返回 chart 的 values.schema.json, 不存在时根据 values.yaml 推断
+func ValuesSchema(chrt *chart.Chart) (json.RawMessage, error) {
+	if len(chrt.Schema) > 0 {
+		return chrt.Schema, nil
+	}
+	return json.Marshal(InferValuesSchema(chrt.Values))
+}
+
+// InferValuesSchema 根据 values 的默认值推断 json schema
+// 推断的 schema 只约束结构和明显的类型, 数字允许为字符串(如 cpu: 1 和 cpu: 500m), 不限制额外的字段
+func InferValuesSchema(values map[string]interface{}) map[string]interface{} {
+	schema := inferSchema(values)
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	return schema
+}
+
+func inferSchema(value interface{}) map[string]interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		properties := map[string]interface{}{}
+		for key, val := range v {
+			properties[key] = inferSchema(val)
+		}
+		return map[string]interface{}{"type": "object", "properties": properties}
+	case []interface{}:
+		return map[string]interface{}{"type": "array", "default": v}
+	case bool:
+		return map[string]interface{}{"type": "boolean", "default": v}
+	case string:
+		return map[string]interface{}{"type": "string", "default": v}
+	case int, int64, float64:
+		return map[string]interface{}{"type": []string{"number", "string"}, "default": v}
+	default:
+		// null 等无法推断类型
+		return map[string]interface{}{}
+	}
+}
+
+// ValidateValues 将 values 与 chart 默认值合并后使用 values.schema.json 校验
+// chart 没有 schema 时不校验, 推断的 schema 仅用于前端展示, 以免拒绝之前可以部署的 values
+func ValidateValues(chrt *chart.Chart, values map[string]interface{}) error {
+	merged, err := chartutil.CoalesceValues(chrt, values)
+	if err != nil {
+		return err
+	}
+	if len(chrt.Schema) == 0 {
+		return nil
+	}
+	return chartutil.ValidateAgainstSchema(chrt, merged)
+}
+
+type ValuesChange struct {
+	Path string      `json:"path"`
+	Kind string      `json:"kind"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// DiffValues 比较两份 values, 返回按路径排序的差异, 列表作为整体比较
+func DiffValues(from, to map[string]interface{}) []ValuesChange {
+	fromflat, toflat := map[string]interface{}{}, map[string]interface{}{}
+	flattenValues("", from, fromflat)
+	flattenValues("", to, toflat)
+
+	changes := []ValuesChange{}
+	for path, fromval := range fromflat {
+		toval, ok := toflat[path]
+		if !ok {
+			changes = append(changes, ValuesChange{Path: path, Kind: ValuesChangeRemoved, From: fromval})
+			continue
+		}
+		if !reflect.DeepEqual(fromval, toval) {
+			changes = append(changes, ValuesChange{Path: path, Kind: ValuesChangeChanged, From: fromval, To: toval})
+		}
+	}
+	for path, toval := range toflat {
+		if _, ok := fromflat[path]; !ok {
+			changes = append(changes, ValuesChange{Path: path, Kind: ValuesChangeAdded, To: toval})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+// UnknownValues 返回 values 中在 defaults 里不存在的路径, 通常是拼写错误或新版本中已移除的配置
+// defaults 中为空对象或 null 的路径下允许任意的字段
+func UnknownValues(values, defaults map[string]interface{}) []string {
+	unknown := []string{}
+	unknownValues("", values, defaults, &unknown)
+	sort.Strings(unknown)
+	return unknown
+}
+
+func unknownValues(prefix string, values, defaults map[string]interface{}, unknown *[]string) {
+	for key, val := range values {
+		path := joinValuesPath(prefix, key)
+		def, ok := defaults[key]
+		if !ok {
+			*unknown = append(*unknown, path)
+			continue
+		}
+		valmap, ok1 := val.(map[string]interface{})
+		defmap, ok2 := def.(map[string]interface{})
+		if ok1 && ok2 && len(defmap) > 0 {
+			unknownValues(path, valmap, defmap, unknown)
+		}
+	}
+}
+
+func flattenValues(prefix string, values map[string]interface{}, into map[string]interface{}) {
+	for key, val := range values {
+		path := joinValuesPath(prefix, key)
+		if m, ok := val.(map[string]interface{}); ok && len(m) > 0 {
+			flattenValues(path, m, into)
+			continue
+		}
+		into[path] = val
+	}
+}
+
+func joinValuesPath(prefix, key string) string {
+	if strings.Contains(key, ".") {
+		key = `"` + key + `"`
+	}
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
@@ -0,0 +1,119 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helm
+
+import (
+	"reflect"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+func TestValidateValues(t *testing.T) {
+	defaults := map[string]interface{}{
+		"replicaCount": float64(1),
+		"image": map[string]interface{}{
+			"repository": "nginx",
+			"tag":        "1.21",
+		},
+		"ingress": map[string]interface{}{
+			"enabled": false,
+		},
+		"resources": map[string]interface{}{},
+	}
+	schema := []byte(`{
+	"$schema": "http://json-schema.org/draft-07/schema#",
+	"type": "object",
+	"required": ["image"],
+	"properties": {
+		"replicaCount": {"type": "integer", "minimum": 1}
+	}
+}`)
+	tests := []struct {
+		name    string
+		schema  []byte
+		values  map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name:   "no schema valid",
+			values: map[string]interface{}{"replicaCount": float64(3), "resources": map[string]interface{}{"cpu": "500m"}},
+		},
+		{
+			name:   "no schema not validated",
+			values: map[string]interface{}{"ingress": map[string]interface{}{"enabled": "false"}, "image": "nginx:1.21"},
+		},
+		{
+			name:   "schema valid",
+			schema: schema,
+			values: map[string]interface{}{"replicaCount": float64(2)},
+		},
+		{
+			name:    "schema invalid",
+			schema:  schema,
+			values:  map[string]interface{}{"replicaCount": float64(0)},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chrt := &chart.Chart{
+				Metadata: &chart.Metadata{Name: "nginx", Version: "1.0.0"},
+				Values:   defaults,
+				Schema:   tt.schema,
+			}
+			if err := ValidateValues(chrt, tt.values); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateValues() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDiffValues(t *testing.T) {
+	from := map[string]interface{}{
+		"image":    map[string]interface{}{"repository": "nginx", "tag": "1.21"},
+		"service":  map[string]interface{}{"port": float64(80)},
+		"affinity": map[string]interface{}{},
+	}
+	to := map[string]interface{}{
+		"image":    map[string]interface{}{"repository": "nginx", "tag": "1.22"},
+		"affinity": map[string]interface{}{},
+		"metrics":  map[string]interface{}{"enabled": false},
+	}
+	want := []ValuesChange{
+		{Path: "image.tag", Kind: ValuesChangeChanged, From: "1.21", To: "1.22"},
+		{Path: "metrics.enabled", Kind: ValuesChangeAdded, To: false},
+		{Path: "service.port", Kind: ValuesChangeRemoved, From: float64(80)},
+	}
+	if got := DiffValues(from, to); !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffValues() = %v, want %v", got, want)
+	}
+}
+
+func TestUnknownValues(t *testing.T) {
+	defaults := map[string]interface{}{
+		"image":     map[string]interface{}{"repository": "nginx", "tag": "1.21"},
+		"resources": map[string]interface{}{},
+	}
+	values := map[string]interface{}{
+		"image":     map[string]interface{}{"tga": "1.22"},
+		"resources": map[string]interface{}{"limits": map[string]interface{}{"cpu": "1"}},
+		"replicas":  float64(2),
+	}
+	want := []string{"image.tga", "replicas"}
+	if got := UnknownValues(values, defaults); !reflect.DeepEqual(got, want) {
+		t.Errorf("UnknownValues() = %v, want %v", got, want)
+	}
+}
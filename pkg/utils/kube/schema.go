@@ -24,6 +24,7 @@ import (
 	networkingpkgv1alpha3 "istio.io/client-go/pkg/apis/networking/v1alpha3"
 	istiov1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
 	networkingpkgv1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+	securitypkgv1beta1 "istio.io/client-go/pkg/apis/security/v1beta1"
 	istiopkgv1alpha1 "istio.io/istio/operator/pkg/apis/istio/v1alpha1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -51,6 +52,7 @@ func AddToschema(schema *runtime.Scheme) {
 	_ = loggingv1beta1.AddToScheme(schema)
 	_ = networkingpkgv1alpha3.AddToScheme(schema)
 	_ = networkingpkgv1beta1.AddToScheme(schema)
+	_ = securitypkgv1beta1.AddToScheme(schema)
 	_ = modelsv1beta1.AddToScheme(schema)
 }
 
@@ -0,0 +1,196 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logexport
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"kubegems.io/kubegems/pkg/utils/agents"
+	"kubegems.io/kubegems/pkg/utils/loki"
+)
+
+const (
+	queryRetries  = 3
+	maxPageGrowth = 16
+)
+
+// QueryRangeFunc 执行 loki query_range 查询
+type QueryRangeFunc func(ctx context.Context, query map[string]string) (*loki.QueryResponseData, error)
+
+// AgentQueryRange 通过集群 agent 查询 loki
+func AgentQueryRange(cs *agents.ClientSet, cluster string) QueryRangeFunc {
+	return func(ctx context.Context, query map[string]string) (*loki.QueryResponseData, error) {
+		cli, err := cs.ClientOf(ctx, cluster)
+		if err != nil {
+			return nil, err
+		}
+		ret := &loki.QueryResponseData{}
+		err = cli.DoRequest(ctx, agents.Request{
+			Path:  "/custom/loki/v1/queryrange",
+			Query: agents.QueryFrom(query),
+			Into:  agents.WrappedResponse(ret),
+		})
+		return ret, err
+	}
+}
+
+type Request struct {
+	Query    string
+	Start    time.Time
+	End      time.Time
+	Window   time.Duration
+	PageSize int
+}
+
+// ProgressFunc 每个时间窗口结束后回调, percent 为 0-100
+type ProgressFunc func(percent int, lines int64) error
+
+// EntryWriter 接收导出的日志, 返回错误时停止导出
+type EntryWriter interface {
+	Write(entry Entry) error
+}
+
+// Export 按时间窗口正序分页查询 loki, 写入 writer
+// 每页从上一页最后一条日志的时间开始查询, 相同时间的日志去重
+// 同一时间的日志超过 PageSize*maxPageGrowth 条时返回错误
+func Export(ctx context.Context, query QueryRangeFunc, req Request, w EntryWriter, progress ProgressFunc) error {
+	if !req.End.After(req.Start) {
+		return fmt.Errorf("end time must be after start time")
+	}
+	if req.Window <= 0 {
+		req.Window = time.Hour
+	}
+	if req.PageSize <= 0 {
+		req.PageSize = 5000
+	}
+	total := req.End.Sub(req.Start)
+	last, lines := int64(-1), int64(0)
+	seen := map[string]struct{}{}
+	for windowStart := req.Start; windowStart.Before(req.End); {
+		windowEnd := windowStart.Add(req.Window)
+		if windowEnd.After(req.End) {
+			windowEnd = req.End
+		}
+		cursor := windowStart.UnixNano()
+		if last > cursor {
+			cursor = last
+		}
+		limit := req.PageSize
+		for {
+			entries, err := queryPage(ctx, query, req.Query, cursor, windowEnd.UnixNano(), limit)
+			if err != nil {
+				return err
+			}
+			written := 0
+			for _, entry := range entries {
+				ts := entry.Timestamp.UnixNano()
+				key := LabelsString(entry.Labels) + entry.Line
+				if ts == last {
+					if _, ok := seen[key]; ok {
+						continue
+					}
+				} else {
+					last = ts
+					seen = map[string]struct{}{}
+				}
+				seen[key] = struct{}{}
+				if err := w.Write(entry); err != nil {
+					return err
+				}
+				written++
+				lines++
+			}
+			if len(entries) < limit {
+				break
+			}
+			switch {
+			case written > 0:
+				limit = req.PageSize
+			case limit < req.PageSize*maxPageGrowth:
+				// 同一时间的日志超过一页, 扩大分页重新查询
+				limit *= 2
+			default:
+				// 仍然超过时无法继续分页, 跳过会丢失日志, 直接失败
+				return fmt.Errorf("more than %d log entries at %s, narrow the query to export", limit, time.Unix(0, last).UTC().Format(time.RFC3339Nano))
+			}
+			cursor = last
+		}
+		windowStart = windowEnd
+		if progress != nil {
+			percent := int(windowEnd.Sub(req.Start) * 100 / total)
+			if err := progress(percent, lines); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func queryPage(ctx context.Context, query QueryRangeFunc, expr string, start, end int64, limit int) ([]Entry, error) {
+	params := map[string]string{
+		"query":     expr,
+		"start":     strconv.FormatInt(start, 10),
+		"end":       strconv.FormatInt(end, 10),
+		"direction": "forward",
+		"limit":     strconv.Itoa(limit),
+	}
+	var (
+		data *loki.QueryResponseData
+		err  error
+	)
+	for i := 0; i < queryRetries; i++ {
+		if data, err = query(ctx, params); err == nil || i == queryRetries-1 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(i+1) * time.Second):
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if data.ResultType == loki.ResultTypeMatrix {
+		return nil, fmt.Errorf("matrix type export is not supported")
+	}
+	entries := []Entry{}
+	for _, result := range data.Result {
+		m, ok := result.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var stream loki.Stream
+		stream = stream.ToStruct(m)
+		for _, value := range stream.Entries {
+			if len(value) < 2 {
+				continue
+			}
+			ns, err := strconv.ParseInt(value[0], 10, 64)
+			if err != nil {
+				continue
+			}
+			entries = append(entries, Entry{Timestamp: time.Unix(0, ns), Labels: stream.Labels, Line: value[1]})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp.Before(entries[j].Timestamp)
+	})
+	return entries, nil
+}
@@ -0,0 +1,224 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logexport
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"kubegems.io/kubegems/pkg/utils/loki"
+)
+
+// fakeLoki 按 start(包含)/end(包含)/limit 正序返回日志
+type fakeLoki struct {
+	entries [][2]int64 // 时间戳, 序号
+	queries int
+}
+
+func (f *fakeLoki) QueryRange(ctx context.Context, query map[string]string) (*loki.QueryResponseData, error) {
+	f.queries++
+	start, _ := strconv.ParseInt(query["start"], 10, 64)
+	end, _ := strconv.ParseInt(query["end"], 10, 64)
+	limit, _ := strconv.Atoi(query["limit"])
+	values := []interface{}{}
+	for _, e := range f.entries {
+		if e[0] >= start && e[0] <= end && len(values) < limit {
+			values = append(values, []interface{}{strconv.FormatInt(e[0], 10), "line-" + strconv.FormatInt(e[1], 10)})
+		}
+	}
+	return &loki.QueryResponseData{
+		ResultType: "streams",
+		Result:     []interface{}{map[string]interface{}{"stream": map[string]interface{}{"app": "demo"}, "values": values}},
+	}, nil
+}
+
+func readAll(t *testing.T, w *Writer, compress bool) string {
+	t.Helper()
+	content, size, err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r io.Reader = io.LimitReader(content, size)
+	if compress {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gz
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestExport(t *testing.T) {
+	base := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) int64 { return base.Add(d).UnixNano() }
+	tests := []struct {
+		name     string
+		entries  [][2]int64
+		window   time.Duration
+		pageSize int
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:     "paging in one window",
+			entries:  [][2]int64{{at(1 * time.Minute), 1}, {at(2 * time.Minute), 2}, {at(3 * time.Minute), 3}, {at(4 * time.Minute), 4}, {at(5 * time.Minute), 5}},
+			window:   time.Hour,
+			pageSize: 2,
+			want:     []string{"line-1", "line-2", "line-3", "line-4", "line-5"},
+		},
+		{
+			name:     "same timestamp across pages",
+			entries:  [][2]int64{{at(1 * time.Minute), 1}, {at(1 * time.Minute), 2}, {at(1 * time.Minute), 3}, {at(2 * time.Minute), 4}},
+			window:   time.Hour,
+			pageSize: 2,
+			want:     []string{"line-1", "line-2", "line-3", "line-4"},
+		},
+		{
+			name:     "entries on window boundary exported once",
+			entries:  [][2]int64{{at(30 * time.Minute), 1}, {at(time.Hour), 2}, {at(90 * time.Minute), 3}},
+			window:   time.Hour,
+			pageSize: 10,
+			want:     []string{"line-1", "line-2", "line-3"},
+		},
+		{
+			name:     "too many entries at one timestamp",
+			entries:  sameTimestampEntries(at(time.Minute), 2*maxPageGrowth+1),
+			window:   time.Hour,
+			pageSize: 2,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeLoki{entries: tt.entries}
+			w, err := NewWriter(FormatLog, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Remove()
+			percents := []int{}
+			req := Request{Query: `{app="demo"}`, Start: base, End: base.Add(2 * time.Hour), Window: tt.window, PageSize: tt.pageSize}
+			err = Export(context.Background(), fake.QueryRange, req, w, func(percent int, lines int64) error {
+				percents = append(percents, percent)
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Export() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := strings.Split(strings.TrimSpace(readAll(t, w, false)), "\n")
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Export() = %v, want %v", got, tt.want)
+			}
+			if len(percents) == 0 || percents[len(percents)-1] != 100 {
+				t.Errorf("progress = %v, want end with 100", percents)
+			}
+		})
+	}
+}
+
+func sameTimestampEntries(ts int64, n int) [][2]int64 {
+	entries := make([][2]int64, 0, n)
+	for i := 0; i < n; i++ {
+		entries = append(entries, [2]int64{ts, int64(i)})
+	}
+	return entries
+}
+
+func TestWriterFormats(t *testing.T) {
+	entry := Entry{
+		Timestamp: time.Date(2022, 1, 1, 0, 0, 0, 5, time.UTC),
+		Labels:    map[string]string{"pod": "p1", "app": "demo"},
+		Line:      `hello, "world"`,
+	}
+	for _, compress := range []bool{false, true} {
+		w, err := NewWriter(FormatNDJSON, compress)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Write(entry); err != nil {
+			t.Fatal(err)
+		}
+		got := ndjsonEntry{}
+		if err := json.Unmarshal([]byte(readAll(t, w, compress)), &got); err != nil {
+			t.Fatal(err)
+		}
+		w.Remove()
+		if got.Line != entry.Line || got.Labels["pod"] != "p1" || got.Timestamp != "2022-01-01T00:00:00.000000005Z" {
+			t.Errorf("ndjson(compress=%v) = %+v", compress, got)
+		}
+	}
+
+	w, err := NewWriter(FormatCSV, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Remove()
+	if err := w.Write(entry); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(strings.NewReader(readAll(t, w, false))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1][1] != `{app="demo", pod="p1"}` || records[1][2] != entry.Line {
+		t.Errorf("csv = %v", records)
+	}
+
+	if _, err := NewWriter("xml", false); err == nil {
+		t.Error("unsupported format should fail")
+	}
+}
+
+func TestSignature(t *testing.T) {
+	now := time.Unix(1000, 0)
+	expires := now.Add(time.Hour).Unix()
+	signature := Sign("secret", "a/b.log", expires)
+	tests := []struct {
+		name       string
+		signingKey string
+		key        string
+		expires    string
+		now        time.Time
+		want       bool
+	}{
+		{name: "valid", signingKey: "secret", key: "a/b.log", expires: strconv.FormatInt(expires, 10), now: now, want: true},
+		{name: "expired", signingKey: "secret", key: "a/b.log", expires: strconv.FormatInt(expires, 10), now: now.Add(2 * time.Hour)},
+		{name: "other key", signingKey: "secret", key: "a/c.log", expires: strconv.FormatInt(expires, 10), now: now},
+		{name: "changed expires", signingKey: "secret", key: "a/b.log", expires: strconv.FormatInt(expires+3600, 10), now: now},
+		{name: "empty signing key", signingKey: "", key: "a/b.log", expires: strconv.FormatInt(expires, 10), now: now},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifySignature(tt.signingKey, tt.key, tt.expires, signature, tt.now); got != tt.want {
+				t.Errorf("VerifySignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logexport
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	StorageLocal = "local"
+	StorageS3    = "s3"
+
+	// LocalDownloadPath 本地存储时签名下载链接的路径, 不需要登录
+	LocalDownloadPath = "/v1/log/exports/download"
+)
+
+type Options struct {
+	Storage        string `json:"storage" description:"storage of log exports, s3 or local, local dir must be shared by service and worker"`
+	Dir            string `json:"dir" description:"directory of log exports when storage is local"`
+	SharedDir      bool   `json:"sharedDir" description:"local dir is shared by service and worker, required when storage is local"`
+	S3URL          string `json:"s3URL" description:"s3 endpoint"`
+	S3Bucket       string `json:"s3Bucket" description:"s3 bucket"`
+	S3Region       string `json:"s3Region" description:"s3 region"`
+	S3AccessKey    string `json:"s3AccessKey" description:"s3 access key"`
+	S3SecretKey    string `json:"s3SecretKey" description:"s3 secret key"`
+	SigningKey     string `json:"signingKey" description:"key to sign download links when storage is local"`
+	LinkExpiration string `json:"linkExpiration" description:"expiration of download links, eg. 24h"`
+	Retention      string `json:"retention" description:"retention of exported files, eg. 7d"`
+	Window         string `json:"window" description:"time window of each loki query, eg. 1h"`
+	PageSize       int    `json:"pageSize" description:"max entries of each loki query"`
+}
+
+func NewDefaultOptions() *Options {
+	return &Options{
+		Storage:        StorageS3,
+		Dir:            "data/logexports",
+		S3Region:       "us-east-1",
+		LinkExpiration: "24h",
+		Retention:      "7d",
+		Window:         "1h",
+		PageSize:       5000,
+	}
+}
+
+// Store 导出文件的存储
+type Store interface {
+	Put(ctx context.Context, key string, content io.ReadSeeker, size int64, contentType string) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	// SignedURL 生成有效期内可直接下载的链接
+	SignedURL(ctx context.Context, key, filename string, expires time.Duration) (string, error)
+}
+
+func NewStore(ctx context.Context, options *Options) (Store, error) {
+	switch options.Storage {
+	case StorageLocal:
+		// 导出由 worker 写入, 由 service 提供下载, 本地目录必须共享
+		if !options.SharedDir {
+			return nil, fmt.Errorf("local log export storage requires a dir shared by service and worker, set sharedDir or use s3")
+		}
+		return &LocalStore{Dir: options.Dir, SigningKey: options.SigningKey}, nil
+	case StorageS3, "":
+		return NewS3Store(ctx, options)
+	default:
+		return nil, fmt.Errorf("unsupported log export storage %s", options.Storage)
+	}
+}
+
+type LocalStore struct {
+	Dir        string
+	SigningKey string
+}
+
+func (s *LocalStore) Put(ctx context.Context, key string, content io.ReadSeeker, size int64, contentType string) error {
+	filename, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0o750); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *LocalStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	filename, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(filename)
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	filename, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStore) SignedURL(ctx context.Context, key, filename string, expires time.Duration) (string, error) {
+	if s.SigningKey == "" {
+		return "", fmt.Errorf("signing key of log export is not set")
+	}
+	expiresAt := time.Now().Add(expires).Unix()
+	values := url.Values{
+		"key":       []string{key},
+		"filename":  []string{filename},
+		"expires":   []string{strconv.FormatInt(expiresAt, 10)},
+		"signature": []string{Sign(s.SigningKey, key, expiresAt)},
+	}
+	return LocalDownloadPath + "?" + values.Encode(), nil
+}
+
+func (s *LocalStore) path(key string) (string, error) {
+	cleaned := filepath.Clean("/" + key)
+	if strings.Contains(key, "..") || cleaned == "/" {
+		return "", fmt.Errorf("invalid log export key %s", key)
+	}
+	return filepath.Join(s.Dir, cleaned), nil
+}
+
+// Sign 本地存储下载链接的签名
+func Sign(signingKey, key string, expiresAt int64) string {
+	mac := hmac.New(sha256.New, []byte(signingKey))
+	mac.Write([]byte(key + "\n" + strconv.FormatInt(expiresAt, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature 校验本地存储下载链接的签名及有效期
+func VerifySignature(signingKey, key, expires, signature string, now time.Time) bool {
+	if signingKey == "" {
+		return false
+	}
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || now.Unix() > expiresAt {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(signingKey, key, expiresAt)), []byte(signature))
+}
+
+type S3Store struct {
+	bucket  string
+	cli     *s3.Client
+	presign *s3.PresignClient
+}
+
+func NewS3Store(ctx context.Context, options *Options) (*S3Store, error) {
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithCredentialsProvider(
+			credentials.StaticCredentialsProvider{Value: aws.Credentials{AccessKeyID: options.S3AccessKey, SecretAccessKey: options.S3SecretKey}},
+		),
+		config.WithEndpointResolverWithOptions(
+			aws.EndpointResolverWithOptionsFunc(
+				func(service, region string, opts ...interface{}) (aws.Endpoint, error) {
+					return aws.Endpoint{URL: options.S3URL}, nil
+				},
+			),
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+	cli := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.Region = options.S3Region
+		o.UsePathStyle = true
+	})
+	return &S3Store{bucket: options.S3Bucket, cli: cli, presign: s3.NewPresignClient(cli)}, nil
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, content io.ReadSeeker, size int64, contentType string) error {
+	_, err := s.cli.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(key),
+		Body:          content,
+		ContentLength: size,
+		ContentType:   aws.String(contentType),
+	})
+	return err
+}
+
+func (s *S3Store) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.cli.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	_, err := s.cli.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+	return err
+}
+
+func (s *S3Store) SignedURL(ctx context.Context, key, filename string, expires time.Duration) (string, error) {
+	req, err := s.presign.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket:                     aws.String(s.bucket),
+		Key:                        aws.String(key),
+		ResponseContentDisposition: aws.String(fmt.Sprintf("attachment; filename=%q", filename)),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
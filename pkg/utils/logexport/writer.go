@@ -0,0 +1,188 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logexport
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	FormatLog    = "log"
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+func ValidFormat(format string) bool {
+	switch format {
+	case FormatLog, FormatNDJSON, FormatCSV:
+		return true
+	default:
+		return false
+	}
+}
+
+// Filename 导出文件名
+func Filename(name, format string, compress bool) string {
+	filename := name + "." + format
+	if compress {
+		filename += ".gz"
+	}
+	return filename
+}
+
+func ContentType(format string, compress bool) string {
+	if compress {
+		return "application/gzip"
+	}
+	switch format {
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	default:
+		return "text/plain; charset=utf-8"
+	}
+}
+
+// Entry 一条日志
+type Entry struct {
+	Timestamp time.Time
+	Labels    map[string]string
+	Line      string
+}
+
+type ndjsonEntry struct {
+	Timestamp string            `json:"timestamp"`
+	Labels    map[string]string `json:"labels"`
+	Line      string            `json:"line"`
+}
+
+// Writer 将日志按格式写入临时文件, 可选 gzip 压缩
+type Writer struct {
+	format string
+	file   *os.File
+	gz     *gzip.Writer
+	out    io.Writer
+	csv    *csv.Writer
+	Lines  int64
+}
+
+func NewWriter(format string, compress bool) (*Writer, error) {
+	if !ValidFormat(format) {
+		return nil, fmt.Errorf("unsupported log export format %s", format)
+	}
+	file, err := ioutil.TempFile("", "kubegems-logexport-*")
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{format: format, file: file, out: file}
+	if compress {
+		w.gz = gzip.NewWriter(file)
+		w.out = w.gz
+	}
+	if format == FormatCSV {
+		w.csv = csv.NewWriter(w.out)
+		if err := w.csv.Write([]string{"timestamp", "labels", "line"}); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+func (w *Writer) Write(entry Entry) error {
+	var err error
+	switch w.format {
+	case FormatNDJSON:
+		var line []byte
+		line, err = json.Marshal(ndjsonEntry{
+			Timestamp: entry.Timestamp.UTC().Format(time.RFC3339Nano),
+			Labels:    entry.Labels,
+			Line:      entry.Line,
+		})
+		if err == nil {
+			_, err = w.out.Write(append(line, '\n'))
+		}
+	case FormatCSV:
+		err = w.csv.Write([]string{entry.Timestamp.UTC().Format(time.RFC3339Nano), LabelsString(entry.Labels), entry.Line})
+	default:
+		_, err = io.WriteString(w.out, strings.TrimSuffix(entry.Line, "\n")+"\n")
+	}
+	if err != nil {
+		return err
+	}
+	w.Lines++
+	return nil
+}
+
+// Size 已写入文件的大小, 压缩时不包含缓冲区中的内容
+func (w *Writer) Size() int64 {
+	info, err := w.file.Stat()
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// Close 结束写入并返回可以 seek 的文件内容及大小
+func (w *Writer) Close() (io.ReadSeeker, int64, error) {
+	if w.csv != nil {
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return nil, 0, err
+		}
+	}
+	if w.gz != nil {
+		if err := w.gz.Close(); err != nil {
+			return nil, 0, err
+		}
+	}
+	size, err := w.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	return w.file, size, nil
+}
+
+// Remove 删除临时文件
+func (w *Writer) Remove() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
+
+// LabelsString 按 key 排序的 {k="v", ...} 格式
+func LabelsString(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", k, labels[k]))
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
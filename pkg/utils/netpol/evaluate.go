@@ -0,0 +1,334 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpol
+
+import (
+	"fmt"
+	"net"
+
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Cluster 用于离线计算的集群快照, 按照 NetworkPolicy 的语义进行判断, 不依赖 CNI 实现
+type Cluster struct {
+	Namespaces []corev1.Namespace
+	Pods       []corev1.Pod
+	Services   []corev1.Service
+	Policies   []netv1.NetworkPolicy
+}
+
+func (c *Cluster) namespace(name string) *corev1.Namespace {
+	for i := range c.Namespaces {
+		if c.Namespaces[i].Name == name {
+			return &c.Namespaces[i]
+		}
+	}
+	return nil
+}
+
+func (c *Cluster) Pod(namespace, name string) *corev1.Pod {
+	for i := range c.Pods {
+		if c.Pods[i].Namespace == namespace && c.Pods[i].Name == name {
+			return &c.Pods[i]
+		}
+	}
+	return nil
+}
+
+func (c *Cluster) Service(namespace, name string) *corev1.Service {
+	for i := range c.Services {
+		if c.Services[i].Namespace == namespace && c.Services[i].Name == name {
+			return &c.Services[i]
+		}
+	}
+	return nil
+}
+
+// Rule 放行或拒绝流量的规则
+type Rule struct {
+	Namespace string `json:"namespace"`
+	Policy    string `json:"policy"`
+	// Direction ingress or egress
+	Direction string `json:"direction"`
+	// Index 规则在 policy 中的下标, 为 -1 时表示该 policy 隔离了目标但没有规则放行
+	Index int `json:"index"`
+}
+
+// Verdict 单次连通性判断的结果
+type Verdict struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason"`
+	// AllowedBy 放行流量的规则
+	AllowedBy []Rule `json:"allowedBy,omitempty"`
+	// DeniedBy 隔离了源或目标但没有规则放行的 policy
+	DeniedBy []Rule `json:"deniedBy,omitempty"`
+}
+
+const (
+	DirectionIngress = "ingress"
+	DirectionEgress  = "egress"
+)
+
+// AnyPort 不限定端口, 只要有任意端口可达即认为可达
+var AnyPort = intstr.FromInt(0)
+
+// CanReach 判断 pod src 能否访问 pod dst 的 port 端口
+func (c *Cluster) CanReach(src, dst *corev1.Pod, port intstr.IntOrString, protocol corev1.Protocol) Verdict {
+	if protocol == "" {
+		protocol = corev1.ProtocolTCP
+	}
+	egress := c.evaluate(src, dst, port, protocol, DirectionEgress)
+	if !egress.Allowed {
+		return egress
+	}
+	ingress := c.evaluate(src, dst, port, protocol, DirectionIngress)
+	ingress.AllowedBy = append(egress.AllowedBy, ingress.AllowedBy...)
+	return ingress
+}
+
+// ServiceVerdict 访问 service 时每个后端 pod 的判断结果
+type ServiceVerdict struct {
+	Allowed  bool               `json:"allowed"`
+	Reason   string             `json:"reason"`
+	Backends map[string]Verdict `json:"backends,omitempty"`
+}
+
+// CanReachService 判断 pod src 能否通过 service 的 port 端口访问其后端, 只要有一个后端可达即认为可达
+func (c *Cluster) CanReachService(src *corev1.Pod, svc *corev1.Service, port int32) ServiceVerdict {
+	var svcport *corev1.ServicePort
+	for i := range svc.Spec.Ports {
+		if svc.Spec.Ports[i].Port == port {
+			svcport = &svc.Spec.Ports[i]
+			break
+		}
+	}
+	if svcport == nil {
+		return ServiceVerdict{Reason: fmt.Sprintf("service %s/%s has no port %d", svc.Namespace, svc.Name, port)}
+	}
+	target := svcport.TargetPort
+	if target.Type == intstr.Int && target.IntVal == 0 {
+		target = intstr.FromInt(int(svcport.Port))
+	}
+	ret := ServiceVerdict{Backends: map[string]Verdict{}}
+	if len(svc.Spec.Selector) == 0 {
+		ret.Reason = fmt.Sprintf("service %s/%s has no selector", svc.Namespace, svc.Name)
+		return ret
+	}
+	sel := labels.SelectorFromSet(svc.Spec.Selector)
+	for i := range c.Pods {
+		pod := &c.Pods[i]
+		if pod.Namespace != svc.Namespace || !sel.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		v := c.CanReach(src, pod, target, svcport.Protocol)
+		ret.Backends[pod.Name] = v
+		ret.Allowed = ret.Allowed || v.Allowed
+	}
+	switch {
+	case len(ret.Backends) == 0:
+		ret.Reason = fmt.Sprintf("service %s/%s has no backend pods", svc.Namespace, svc.Name)
+	case ret.Allowed:
+		ret.Reason = "at least one backend pod is reachable"
+	default:
+		ret.Reason = "no backend pod is reachable"
+	}
+	return ret
+}
+
+func (c *Cluster) evaluate(src, dst *corev1.Pod, port intstr.IntOrString, protocol corev1.Protocol, direction string) Verdict {
+	// 入站看目标 pod 上的策略, 出站看源 pod 上的策略
+	subject, peer := dst, src
+	policyType := netv1.PolicyTypeIngress
+	if direction == DirectionEgress {
+		subject, peer = src, dst
+		policyType = netv1.PolicyTypeEgress
+	}
+
+	ret := Verdict{}
+	isolated := false
+	for i := range c.Policies {
+		np := &c.Policies[i]
+		if np.Namespace != subject.Namespace || !hasPolicyType(np, policyType) || !selectorMatches(&np.Spec.PodSelector, subject.Labels) {
+			continue
+		}
+		isolated = true
+		matched := false
+		if direction == DirectionIngress {
+			for idx, rule := range np.Spec.Ingress {
+				if c.peersMatch(rule.From, np.Namespace, peer) && portsMatch(rule.Ports, dst, port, protocol) {
+					ret.AllowedBy = append(ret.AllowedBy, Rule{Namespace: np.Namespace, Policy: np.Name, Direction: direction, Index: idx})
+					matched = true
+				}
+			}
+		} else {
+			for idx, rule := range np.Spec.Egress {
+				if c.peersMatch(rule.To, np.Namespace, peer) && portsMatch(rule.Ports, dst, port, protocol) {
+					ret.AllowedBy = append(ret.AllowedBy, Rule{Namespace: np.Namespace, Policy: np.Name, Direction: direction, Index: idx})
+					matched = true
+				}
+			}
+		}
+		if !matched {
+			ret.DeniedBy = append(ret.DeniedBy, Rule{Namespace: np.Namespace, Policy: np.Name, Direction: direction, Index: -1})
+		}
+	}
+	switch {
+	case !isolated:
+		ret.Allowed = true
+		ret.Reason = fmt.Sprintf("pod %s/%s is not isolated for %s", subject.Namespace, subject.Name, direction)
+	case len(ret.AllowedBy) > 0:
+		ret.Allowed = true
+		ret.DeniedBy = nil
+		ret.Reason = fmt.Sprintf("%s allowed by networkpolicy %s/%s rule %d", direction, ret.AllowedBy[0].Namespace, ret.AllowedBy[0].Policy, ret.AllowedBy[0].Index)
+	default:
+		ret.Reason = fmt.Sprintf("pod %s/%s is isolated for %s and no rule allows the traffic", subject.Namespace, subject.Name, direction)
+	}
+	return ret
+}
+
+func hasPolicyType(np *netv1.NetworkPolicy, t netv1.PolicyType) bool {
+	// 未设置 policyTypes 时, 默认包含 Ingress, 存在 egress 规则时包含 Egress
+	if len(np.Spec.PolicyTypes) == 0 {
+		return t == netv1.PolicyTypeIngress || (t == netv1.PolicyTypeEgress && len(np.Spec.Egress) > 0)
+	}
+	for _, pt := range np.Spec.PolicyTypes {
+		if pt == t {
+			return true
+		}
+	}
+	return false
+}
+
+func selectorMatches(sel *metav1.LabelSelector, lbs map[string]string) bool {
+	s, err := metav1.LabelSelectorAsSelector(sel)
+	if err != nil {
+		return false
+	}
+	return s.Matches(labels.Set(lbs))
+}
+
+// peersMatch 规则中 peers 为空时匹配所有来源
+func (c *Cluster) peersMatch(peers []netv1.NetworkPolicyPeer, policyNamespace string, pod *corev1.Pod) bool {
+	if len(peers) == 0 {
+		return true
+	}
+	for _, peer := range peers {
+		if c.peerMatch(peer, policyNamespace, pod) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Cluster) peerMatch(peer netv1.NetworkPolicyPeer, policyNamespace string, pod *corev1.Pod) bool {
+	if peer.IPBlock != nil {
+		return ipBlockMatch(peer.IPBlock, pod.Status.PodIP)
+	}
+	if peer.NamespaceSelector != nil {
+		ns := c.namespace(pod.Namespace)
+		if ns == nil {
+			return false
+		}
+		// namespace 的 kubernetes.io/metadata.name 标签由 apiserver 自动添加
+		nslabels := labels.Merge(ns.Labels, map[string]string{corev1.LabelMetadataName: ns.Name})
+		if !selectorMatches(peer.NamespaceSelector, nslabels) {
+			return false
+		}
+	} else if pod.Namespace != policyNamespace {
+		return false
+	}
+	if peer.PodSelector != nil {
+		return selectorMatches(peer.PodSelector, pod.Labels)
+	}
+	return true
+}
+
+func ipBlockMatch(block *netv1.IPBlock, ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	if _, cidr, err := net.ParseCIDR(block.CIDR); err != nil || !cidr.Contains(addr) {
+		return false
+	}
+	for _, except := range block.Except {
+		if _, cidr, err := net.ParseCIDR(except); err == nil && cidr.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// portsMatch 规则中 ports 为空时匹配所有端口, 命名端口根据目标 pod 的容器端口解析
+func portsMatch(ports []netv1.NetworkPolicyPort, dst *corev1.Pod, port intstr.IntOrString, protocol corev1.Protocol) bool {
+	if len(ports) == 0 {
+		return true
+	}
+	number, name := resolvePort(dst, port, protocol)
+	for _, p := range ports {
+		proto := corev1.ProtocolTCP
+		if p.Protocol != nil {
+			proto = *p.Protocol
+		}
+		if proto != protocol {
+			continue
+		}
+		if p.Port == nil || port == AnyPort {
+			return true
+		}
+		if p.Port.Type == intstr.String {
+			if name != "" && p.Port.StrVal == name {
+				return true
+			}
+			continue
+		}
+		if number == 0 {
+			continue
+		}
+		if p.EndPort != nil {
+			if number >= p.Port.IntVal && number <= *p.EndPort {
+				return true
+			}
+		} else if number == p.Port.IntVal {
+			return true
+		}
+	}
+	return false
+}
+
+func resolvePort(pod *corev1.Pod, port intstr.IntOrString, protocol corev1.Protocol) (int32, string) {
+	for _, container := range pod.Spec.Containers {
+		for _, cp := range container.Ports {
+			proto := cp.Protocol
+			if proto == "" {
+				proto = corev1.ProtocolTCP
+			}
+			if proto != protocol {
+				continue
+			}
+			if (port.Type == intstr.String && cp.Name == port.StrVal) || (port.Type == intstr.Int && cp.ContainerPort == port.IntVal) {
+				return cp.ContainerPort, cp.Name
+			}
+		}
+	}
+	if port.Type == intstr.Int {
+		return port.IntVal, ""
+	}
+	return 0, port.StrVal
+}
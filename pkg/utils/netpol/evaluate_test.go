@@ -0,0 +1,180 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpol
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"kubegems.io/kubegems/pkg/apis/gems"
+	"kubegems.io/kubegems/pkg/apis/gems/v1beta1"
+)
+
+func testNamespace(name, tenant, project, env string) corev1.Namespace {
+	return corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{
+		gems.LabelTenant:      tenant,
+		gems.LabelProject:     project,
+		gems.LabelEnvironment: env,
+	}}}
+}
+
+func testPod(namespace, name, ip string, lbs map[string]string) corev1.Pod {
+	return corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: lbs},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name:  "app",
+			Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}},
+		}}},
+		Status: corev1.PodStatus{PodIP: ip},
+	}
+}
+
+func testCluster() *Cluster {
+	return &Cluster{
+		Namespaces: []corev1.Namespace{
+			testNamespace("dev", "t1", "p1", "dev"),
+			testNamespace("test", "t1", "p1", "test"),
+			testNamespace("other", "t1", "p2", "other"),
+			testNamespace("foreign", "t2", "p3", "foreign"),
+		},
+		Pods: []corev1.Pod{
+			testPod("dev", "web", "10.0.0.1", map[string]string{"app": "web"}),
+			testPod("test", "web", "10.0.1.1", map[string]string{"app": "web"}),
+			testPod("other", "api", "10.0.2.1", map[string]string{"app": "api"}),
+			testPod("foreign", "api", "10.0.3.1", map[string]string{"app": "api"}),
+		},
+		Services: []corev1.Service{{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "dev", Name: "web"},
+			Spec: corev1.ServiceSpec{
+				Selector: map[string]string{"app": "web"},
+				Ports:    []corev1.ServicePort{{Port: 80, TargetPort: intstr.FromString("http"), Protocol: corev1.ProtocolTCP}},
+			},
+		}},
+	}
+}
+
+func TestCanReach(t *testing.T) {
+	c := testCluster()
+	spec := v1beta1.TenantNetworkPolicySpec{
+		Tenant:                 "t1",
+		TenantIsolated:         true,
+		ProjectNetworkPolicies: []v1beta1.ProjectNetworkPolicy{{Name: "p1"}},
+	}
+	c.Policies = ApplyTenantNetworkPolicy(nil, spec, c.Namespaces, []string{"10.0.0.0/8"})
+
+	tests := []struct {
+		name    string
+		src     [2]string
+		dst     [2]string
+		allowed bool
+	}{
+		{name: "same project", src: [2]string{"test", "web"}, dst: [2]string{"dev", "web"}, allowed: true},
+		{name: "other project", src: [2]string{"other", "api"}, dst: [2]string{"dev", "web"}, allowed: false},
+		{name: "other tenant", src: [2]string{"foreign", "api"}, dst: [2]string{"dev", "web"}, allowed: false},
+		{name: "to foreign tenant not isolated", src: [2]string{"dev", "web"}, dst: [2]string{"foreign", "api"}, allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := c.CanReach(c.Pod(tt.src[0], tt.src[1]), c.Pod(tt.dst[0], tt.dst[1]), intstr.FromInt(8080), corev1.ProtocolTCP)
+			if v.Allowed != tt.allowed {
+				t.Errorf("CanReach() = %v, want %v, reason: %s", v.Allowed, tt.allowed, v.Reason)
+			}
+			if !v.Allowed && len(v.DeniedBy) == 0 {
+				t.Errorf("CanReach() denied without policy")
+			}
+		})
+	}
+}
+
+func TestCanReachService(t *testing.T) {
+	c := testCluster()
+	tcp := corev1.ProtocolTCP
+	port := intstr.FromInt(8080)
+	c.Policies = []netv1.NetworkPolicy{{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "dev", Name: "only-8080-from-test"},
+		Spec: netv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			Ingress: []netv1.NetworkPolicyIngressRule{{
+				From: []netv1.NetworkPolicyPeer{{NamespaceSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{corev1.LabelMetadataName: "test"},
+				}}},
+				Ports: []netv1.NetworkPolicyPort{{Protocol: &tcp, Port: &port}},
+			}},
+		},
+	}}
+	svc := c.Service("dev", "web")
+	if v := c.CanReachService(c.Pod("test", "web"), svc, 80); !v.Allowed {
+		t.Errorf("CanReachService() from test denied: %s", v.Reason)
+	}
+	if v := c.CanReachService(c.Pod("other", "api"), svc, 80); v.Allowed {
+		t.Errorf("CanReachService() from other allowed")
+	}
+	if v := c.CanReachService(c.Pod("test", "web"), svc, 81); v.Allowed {
+		t.Errorf("CanReachService() on unknown port allowed")
+	}
+}
+
+func TestConnectivityMatrix(t *testing.T) {
+	c := testCluster()
+	spec := v1beta1.TenantNetworkPolicySpec{
+		Tenant:                     "t1",
+		EnvironmentNetworkPolicies: []v1beta1.EnvironmentNetworkPolicy{{Project: "p1", Name: "dev"}},
+	}
+	c.Policies = ApplyTenantNetworkPolicy(nil, spec, c.Namespaces, []string{"10.0.0.0/8"})
+	m := c.ConnectivityMatrix([]string{"dev", "test"}, LevelEnvironment)
+	if len(m.Nodes) != 2 {
+		t.Fatalf("ConnectivityMatrix() nodes = %d, want 2", len(m.Nodes))
+	}
+	want := [][]string{
+		{StatusAllowed, StatusAllowed},
+		{StatusDenied, StatusAllowed},
+	}
+	for i := range want {
+		for j := range want[i] {
+			if got := m.Cells[i][j].Status; got != want[i][j] {
+				t.Errorf("ConnectivityMatrix() cell[%d][%d] = %s, want %s", i, j, got, want[i][j])
+			}
+		}
+	}
+}
+
+func TestTenantNetworkPolicies(t *testing.T) {
+	namespaces := []corev1.Namespace{
+		testNamespace("dev", "t1", "p1", "dev"),
+		testNamespace("other", "t1", "p2", "other"),
+		// 其他租户下的同名项目不受影响
+		testNamespace("foreign", "t2", "p1", "foreign"),
+	}
+	spec := v1beta1.TenantNetworkPolicySpec{
+		Tenant:                 "t1",
+		ProjectNetworkPolicies: []v1beta1.ProjectNetworkPolicy{{Name: "p1"}},
+	}
+	got := TenantNetworkPolicies(spec, namespaces, []string{"10.0.0.0/8"})
+	if len(got) != 2 {
+		t.Fatalf("TenantNetworkPolicies() managed %d namespaces, want 2", len(got))
+	}
+	if np := got["dev"]; np == nil || np.Labels[gems.LabelProject] != "p1" || !hasKindLabel(np, gems.LabelProject) {
+		t.Errorf("TenantNetworkPolicies() dev = %v", np)
+	}
+	if np, ok := got["other"]; !ok || np != nil {
+		t.Errorf("TenantNetworkPolicies() other = %v, want nil", np)
+	}
+	if _, ok := got["foreign"]; ok {
+		t.Errorf("TenantNetworkPolicies() should not manage namespaces of other tenants")
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpol
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"kubegems.io/kubegems/pkg/apis/gems"
+	"kubegems.io/kubegems/pkg/apis/gems/v1beta1"
+	"kubegems.io/kubegems/pkg/utils/maps"
+)
+
+// DefaultNetworkPolicyName 由 TenantNetworkPolicy 生成的 NetworkPolicy 名称
+const DefaultNetworkPolicyName = "default"
+
+// DefaultNetworkPolicy 默认的隔离策略, 允许集群外部、插件namespace以及第三条规则中selector匹配的namespace访问
+func DefaultNetworkPolicy(namespace, name string, cidrs []string) netv1.NetworkPolicy {
+	np := netv1.NetworkPolicy{}
+	np.Name = name
+	np.Namespace = namespace
+	np.Spec = netv1.NetworkPolicySpec{
+		PolicyTypes: []netv1.PolicyType{netv1.PolicyTypeIngress},
+		Ingress: []netv1.NetworkPolicyIngressRule{
+			{
+				From: []netv1.NetworkPolicyPeer{
+					{
+						IPBlock: &netv1.IPBlock{
+							CIDR:   "0.0.0.0/0",
+							Except: cidrs,
+						},
+					},
+				},
+			},
+			{
+				From: []netv1.NetworkPolicyPeer{
+					{
+						NamespaceSelector: &metav1.LabelSelector{
+							MatchExpressions: []metav1.LabelSelectorRequirement{
+								{
+									Key:      gems.LabelPlugins,
+									Operator: metav1.LabelSelectorOpExists,
+								},
+							},
+						},
+					},
+				},
+			},
+			{
+				From: []netv1.NetworkPolicyPeer{
+					{
+						NamespaceSelector: &metav1.LabelSelector{
+							MatchExpressions: []metav1.LabelSelectorRequirement{},
+						},
+					},
+				},
+			},
+		},
+	}
+	return np
+}
+
+func validNetworkPolicy(np *netv1.NetworkPolicy) bool {
+	if len(np.Spec.Ingress) < 3 {
+		return false
+	}
+	if len(np.Spec.Ingress[2].From) == 0 {
+		return false
+	}
+	return true
+}
+
+func hasKindLabel(netpol *netv1.NetworkPolicy, kind string) bool {
+	for _, exp := range netpol.Spec.Ingress[2].From[0].NamespaceSelector.MatchExpressions {
+		if exp.Key == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func AddNamespaceSelector(np *netv1.NetworkPolicy, kind, value string) {
+	if !validNetworkPolicy(np) {
+		return
+	}
+	if hasKindLabel(np, kind) {
+		return
+	}
+	sel := metav1.LabelSelectorRequirement{Key: kind, Operator: metav1.LabelSelectorOpIn, Values: []string{value}}
+	np.Spec.Ingress[2].From[0].NamespaceSelector.MatchExpressions = append(np.Spec.Ingress[2].From[0].NamespaceSelector.MatchExpressions, sel)
+}
+
+func DelNamespaceSelector(np *netv1.NetworkPolicy, kind string) {
+	if !validNetworkPolicy(np) {
+		return
+	}
+	index := -1
+	origin := np.Spec.Ingress[2].From[0].NamespaceSelector.MatchExpressions
+	for idx, item := range origin {
+		if item.Key == kind {
+			index = idx
+		}
+	}
+	if index != -1 {
+		np.Spec.Ingress[2].From[0].NamespaceSelector.MatchExpressions = append(origin[:index], origin[index+1:]...)
+	}
+}
+
+// Isolation 单个namespace上各级别的隔离开关
+type Isolation struct {
+	Tenant      bool
+	Project     bool
+	Environment bool
+}
+
+func (i Isolation) Isolated() bool {
+	return i.Tenant || i.Project || i.Environment
+}
+
+// IsolationOf 计算 TenantNetworkPolicy 作用在 namespace 上的隔离开关
+func IsolationOf(spec v1beta1.TenantNetworkPolicySpec, ns *corev1.Namespace) Isolation {
+	iso := Isolation{}
+	if ns.Labels[gems.LabelTenant] != spec.Tenant {
+		return iso
+	}
+	iso.Tenant = spec.TenantIsolated
+	for _, proj := range spec.ProjectNetworkPolicies {
+		if proj.Name == ns.Labels[gems.LabelProject] {
+			iso.Project = true
+		}
+	}
+	for _, env := range spec.EnvironmentNetworkPolicies {
+		if env.Name == ns.Labels[gems.LabelEnvironment] {
+			iso.Environment = true
+		}
+	}
+	return iso
+}
+
+// DesiredNetworkPolicy 计算 TenantNetworkPolicy 在 namespace 中期望的 NetworkPolicy, 不需要隔离时返回 nil
+func DesiredNetworkPolicy(spec v1beta1.TenantNetworkPolicySpec, ns *corev1.Namespace, cidrs []string) *netv1.NetworkPolicy {
+	iso := IsolationOf(spec, ns)
+	if !iso.Isolated() {
+		return nil
+	}
+	np := DefaultNetworkPolicy(ns.Name, DefaultNetworkPolicyName, cidrs)
+	if iso.Tenant {
+		AddNamespaceSelector(&np, gems.LabelTenant, ns.Labels[gems.LabelTenant])
+	}
+	if iso.Project {
+		AddNamespaceSelector(&np, gems.LabelProject, ns.Labels[gems.LabelProject])
+	}
+	if iso.Environment {
+		AddNamespaceSelector(&np, gems.LabelEnvironment, ns.Labels[gems.LabelEnvironment])
+	}
+	return &np
+}
+
+// TenantNetworkPolicies 计算租户下各 namespace 期望的 NetworkPolicy, 不需要隔离的 namespace 值为 nil,
+// TenantNetworkPolicy controller 与连通性模拟共用该结果, 保证模拟与实际下发的策略一致
+func TenantNetworkPolicies(spec v1beta1.TenantNetworkPolicySpec, namespaces []corev1.Namespace, cidrs []string) map[string]*netv1.NetworkPolicy {
+	ret := map[string]*netv1.NetworkPolicy{}
+	for i := range namespaces {
+		ns := &namespaces[i]
+		if ns.Labels[gems.LabelTenant] != spec.Tenant {
+			continue
+		}
+		np := DesiredNetworkPolicy(spec, ns, cidrs)
+		if np != nil {
+			np.Labels = maps.GetLabels(ns.Labels, gems.CommonLabels)
+		}
+		ret[ns.Name] = np
+	}
+	return ret
+}
+
+// ApplyTenantNetworkPolicy 用 spec 期望的结果替换 policies 中该租户 namespace 下由 TenantNetworkPolicy 管理的 NetworkPolicy,
+// 用于在变更前模拟新的隔离配置
+func ApplyTenantNetworkPolicy(policies []netv1.NetworkPolicy, spec v1beta1.TenantNetworkPolicySpec, namespaces []corev1.Namespace, cidrs []string) []netv1.NetworkPolicy {
+	desired := TenantNetworkPolicies(spec, namespaces, cidrs)
+	ret := []netv1.NetworkPolicy{}
+	for _, np := range policies {
+		if _, managed := desired[np.Namespace]; managed && np.Name == DefaultNetworkPolicyName {
+			continue
+		}
+		ret = append(ret, np)
+	}
+	for i := range namespaces {
+		if np := desired[namespaces[i].Name]; np != nil {
+			ret = append(ret, *np)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,162 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpol
+
+import (
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"kubegems.io/kubegems/pkg/apis/gems"
+)
+
+const (
+	LevelEnvironment = "environment"
+	LevelWorkload    = "workload"
+
+	StatusAllowed = "allowed"
+	StatusPartial = "partial"
+	StatusDenied  = "denied"
+)
+
+// MatrixNode 矩阵中的一个节点, 环境级别时 Workload 为空
+type MatrixNode struct {
+	Namespace   string `json:"namespace"`
+	Tenant      string `json:"tenant"`
+	Project     string `json:"project"`
+	Environment string `json:"environment"`
+	Workload    string `json:"workload,omitempty"`
+
+	pods []*corev1.Pod
+}
+
+// MatrixCell Cells[i][j] 表示 Nodes[i] 访问 Nodes[j] 的连通情况
+type MatrixCell struct {
+	Status string `json:"status"`
+	// Allowed/Total 参与计算的 pod 对中可达的数量
+	Allowed int `json:"allowed"`
+	Total   int `json:"total"`
+	// Sample 一个具有代表性的判断结果, 优先选择被拒绝的
+	Sample *Verdict `json:"sample,omitempty"`
+}
+
+type Matrix struct {
+	Level string         `json:"level"`
+	Nodes []MatrixNode   `json:"nodes"`
+	Cells [][]MatrixCell `json:"cells"`
+}
+
+// ConnectivityMatrix 计算 namespaces 之间(或其中工作负载之间)任意端口的连通性矩阵
+// 没有 pod 的 namespace 使用一个无标签的虚拟 pod 代表
+func (c *Cluster) ConnectivityMatrix(namespaces []string, level string) Matrix {
+	nodes := c.matrixNodes(namespaces, level)
+	cells := make([][]MatrixCell, len(nodes))
+	for i := range nodes {
+		cells[i] = make([]MatrixCell, len(nodes))
+		for j := range nodes {
+			cells[i][j] = c.cell(nodes[i].pods, nodes[j].pods)
+		}
+	}
+	return Matrix{Level: level, Nodes: nodes, Cells: cells}
+}
+
+func (c *Cluster) cell(srcs, dsts []*corev1.Pod) MatrixCell {
+	cell := MatrixCell{}
+	for _, src := range srcs {
+		for _, dst := range dsts {
+			v := c.CanReach(src, dst, AnyPort, corev1.ProtocolTCP)
+			cell.Total++
+			if v.Allowed {
+				cell.Allowed++
+				if cell.Sample == nil {
+					cell.Sample = &v
+				}
+			} else if cell.Sample == nil || cell.Sample.Allowed {
+				cell.Sample = &v
+			}
+		}
+	}
+	switch cell.Allowed {
+	case cell.Total:
+		cell.Status = StatusAllowed
+	case 0:
+		cell.Status = StatusDenied
+	default:
+		cell.Status = StatusPartial
+	}
+	return cell
+}
+
+func (c *Cluster) matrixNodes(namespaces []string, level string) []MatrixNode {
+	nodes := []MatrixNode{}
+	for _, nsname := range namespaces {
+		ns := c.namespace(nsname)
+		if ns == nil {
+			continue
+		}
+		base := MatrixNode{
+			Namespace:   ns.Name,
+			Tenant:      ns.Labels[gems.LabelTenant],
+			Project:     ns.Labels[gems.LabelProject],
+			Environment: ns.Labels[gems.LabelEnvironment],
+		}
+		pods := []*corev1.Pod{}
+		for i := range c.Pods {
+			if c.Pods[i].Namespace == ns.Name {
+				pods = append(pods, &c.Pods[i])
+			}
+		}
+		if len(pods) == 0 {
+			pods = append(pods, &corev1.Pod{})
+			pods[0].Namespace, pods[0].Name = ns.Name, "*"
+		}
+		if level != LevelWorkload {
+			base.pods = pods
+			nodes = append(nodes, base)
+			continue
+		}
+		workloads := map[string][]*corev1.Pod{}
+		for _, pod := range pods {
+			name := WorkloadName(pod)
+			workloads[name] = append(workloads[name], pod)
+		}
+		names := make([]string, 0, len(workloads))
+		for name := range workloads {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			node := base
+			node.Workload = name
+			node.pods = workloads[name]
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// WorkloadName 根据 ownerReference 推断 pod 所属的工作负载名称
+func WorkloadName(pod *corev1.Pod) string {
+	for _, ref := range pod.OwnerReferences {
+		if ref.Kind == "ReplicaSet" {
+			if hash, ok := pod.Labels[appsv1.DefaultDeploymentUniqueLabelKey]; ok {
+				return strings.TrimSuffix(ref.Name, "-"+hash)
+			}
+		}
+		return ref.Name
+	}
+	return pod.Name
+}
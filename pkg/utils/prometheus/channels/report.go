@@ -0,0 +1,133 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package channels
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+)
+
+// ReportSender 可以直接发送报告的渠道
+type ReportSender interface {
+	SendReport(title, content string) error
+}
+
+// SupportsReport 渠道是否可以发送报告, 短信及语音渠道不能发送报告
+func SupportsReport(ch ChannelIf) bool {
+	_, ok := ch.(ReportSender)
+	return ok
+}
+
+// SendReport 通过告警渠道发送报告, 报告不作为告警发送
+func SendReport(ch ChannelIf, title, content string) error {
+	sender, ok := ch.(ReportSender)
+	if !ok {
+		return fmt.Errorf("channel %T does not support sending reports", ch)
+	}
+	return sender.SendReport(title, content)
+}
+
+func (e *Email) SendReport(title, content string) error {
+	auth := sasl.NewPlainClient("", e.From, e.AuthPassword)
+	receivers := strings.Split(e.To, ",")
+	buf := bytes.NewBufferString("From: " + e.From + "\r\n" +
+		"To: " + e.To + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("utf-8", title) + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		strings.ReplaceAll(content, "\n", "\r\n"))
+	return smtp.SendMail(e.SMTPServer, auth, e.From, receivers, buf)
+}
+
+// WebhookReport webhook 渠道发送的报告内容
+type WebhookReport struct {
+	Type    string `json:"type"` // 固定为 report, 用于与告警区分
+	Title   string `json:"title"`
+	Content string `json:"content"`
+}
+
+func (w *Webhook) SendReport(title, content string) error {
+	cli := &http.Client{Timeout: 30 * time.Second}
+	if w.InsecureSkipVerify {
+		cli.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+		}
+	}
+	return postJSON(cli, w.URL, WebhookReport{Type: "report", Title: title, Content: content})
+}
+
+// SendReport 通过飞书机器人发送文本消息
+// https://open.feishu.cn/document/client-docs/bot-v3/add-custom-bot
+func (f *Feishu) SendReport(title, content string) error {
+	text := title + "\n" + content
+	if f.At != "" {
+		text += fmt.Sprintf("\n<at user_id=\"%s\"></at>", f.At)
+	}
+	msg := map[string]interface{}{
+		"msg_type": "text",
+		"content":  map[string]string{"text": text},
+	}
+	if f.SignSecret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		sign, err := feishuSign(timestamp, f.SignSecret)
+		if err != nil {
+			return err
+		}
+		msg["timestamp"], msg["sign"] = timestamp, sign
+	}
+	return postJSON(&http.Client{Timeout: 30 * time.Second}, f.URL, msg)
+}
+
+// feishuSign 飞书机器人签名, 以 timestamp + "\n" + secret 为 key 对空内容做 HmacSHA256
+func feishuSign(timestamp, secret string) (string, error) {
+	h := hmac.New(sha256.New, []byte(timestamp+"\n"+secret))
+	if _, err := h.Write(nil); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+func postJSON(cli *http.Client, u string, body interface{}) error {
+	buf := bytes.NewBuffer(nil)
+	if err := json.NewEncoder(buf).Encode(body); err != nil {
+		return err
+	}
+	resp, err := cli.Post(u, "application/json", buf)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		bts, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("send report failed, status: %d, resp: %s", resp.StatusCode, bts)
+	}
+	return nil
+}
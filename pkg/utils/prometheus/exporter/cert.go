@@ -15,26 +15,32 @@
 package exporter
 
 import (
+	"context"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"kubegems.io/kubegems/pkg/agent/cluster"
+	gemsv1beta1 "kubegems.io/kubegems/pkg/apis/gems/v1beta1"
 	"kubegems.io/kubegems/pkg/log"
 	"kubegems.io/kubegems/pkg/utils/clusterinfo"
 )
 
 type CertCollector struct {
-	certExpiredAt *prometheus.Desc
-	mutex         sync.Mutex
+	certExpiredAt        *prometheus.Desc
+	gatewayCertExpiredAt *prometheus.Desc
+	clus                 cluster.Interface
+	mutex                sync.Mutex
 }
 
-func NewCertCollectorFunc() func(*log.Logger) (Collector, error) {
+func NewCertCollectorFunc(cluster cluster.Interface) func(*log.Logger) (Collector, error) {
 	return func(logger *log.Logger) (Collector, error) {
-		return NewCertCollector(logger)
+		return NewCertCollector(logger, cluster)
 	}
 }
 
-func NewCertCollector(_ *log.Logger) (Collector, error) {
+func NewCertCollector(_ *log.Logger, clus cluster.Interface) (Collector, error) {
 	c := &CertCollector{
 		certExpiredAt: prometheus.NewDesc(
 			prometheus.BuildFQName(getNamespace(), "cluster_component_cert", "expiration_remain_seconds"),
@@ -42,6 +48,13 @@ func NewCertCollector(_ *log.Logger) (Collector, error) {
 			[]string{"component"},
 			nil,
 		),
+		gatewayCertExpiredAt: prometheus.NewDesc(
+			prometheus.BuildFQName(getNamespace(), "tenant_gateway_cert", "expiration_remain_seconds"),
+			"Gems tenant gateway cert expiration remain seconds",
+			[]string{"tenant", "gateway", "host", "secret", "default"},
+			nil,
+		),
+		clus: clus,
 	}
 	return c, nil
 }
@@ -61,5 +74,25 @@ func (c *CertCollector) Update(ch chan<- prometheus.Metric) error {
 		"apiserver",
 	)
 
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	tgs := &gemsv1beta1.TenantGatewayList{}
+	if err := c.clus.GetClient().List(ctx, tgs); err != nil {
+		log.Error(err, "list tenant gateways failed")
+		return err
+	}
+	for _, tg := range tgs.Items {
+		for _, cert := range tg.Status.Certificates {
+			if cert.NotAfter == nil {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(
+				c.gatewayCertExpiredAt,
+				prometheus.GaugeValue,
+				time.Until(cert.NotAfter.Time).Seconds(),
+				tg.Spec.Tenant, tg.Name, cert.Host, cert.SecretName, strconv.FormatBool(cert.Default),
+			)
+		}
+	}
 	return nil
 }
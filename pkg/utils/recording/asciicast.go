@@ -0,0 +1,197 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package recording
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// asciicast v2 格式, https://docs.asciinema.org/manual/asciicast/v2/
+const (
+	EventOutput = "o"
+	EventInput  = "i"
+	EventResize = "r"
+
+	defaultWidth  = 80
+	defaultHeight = 24
+	// 默认每 1MiB 保存一次
+	defaultChunkSize = 1 << 20
+)
+
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Recorder 将终端会话记录为 asciicast v2 格式, 内容缓存在内存中, 由调用方通过 Chunk 分块取出保存
+type Recorder struct {
+	mu      sync.Mutex
+	header  Header
+	start   time.Time
+	end     time.Time
+	pending bytes.Buffer
+	// size 已记录的事件大小, 不包含 header
+	size          int64
+	written       bool
+	headerWritten bool
+	closed        bool
+	full          chan struct{}
+	exceeded      chan struct{}
+	// ChunkSize 缓存的内容超过该大小时通过 Full 通知调用方取出
+	ChunkSize int
+	// MaxSize 事件的最大大小, 超过后不再记录输出及终端大小调整, 客户端输入仍然记录
+	MaxSize   int64
+	Truncated bool
+}
+
+func NewRecorder(title string, maxsize int64) *Recorder {
+	now := time.Now()
+	return &Recorder{
+		header: Header{
+			Version:   2,
+			Width:     defaultWidth,
+			Height:    defaultHeight,
+			Timestamp: now.Unix(),
+			Title:     title,
+			Env:       map[string]string{"TERM": "xterm", "SHELL": "/bin/sh"},
+		},
+		start:     now,
+		full:      make(chan struct{}, 1),
+		exceeded:  make(chan struct{}),
+		ChunkSize: defaultChunkSize,
+		MaxSize:   maxsize,
+	}
+}
+
+func (r *Recorder) Output(data []byte) {
+	r.record(EventOutput, string(data))
+}
+
+func (r *Recorder) Input(data string) {
+	r.record(EventInput, data)
+}
+
+// Resize 第一个事件前的调整作为终端的初始大小
+func (r *Recorder) Resize(cols, rows uint16) {
+	r.mu.Lock()
+	if !r.written {
+		r.header.Width, r.header.Height = int(cols), int(rows)
+		r.mu.Unlock()
+		return
+	}
+	r.mu.Unlock()
+	r.record(EventResize, strconv.Itoa(int(cols))+"x"+strconv.Itoa(int(rows)))
+}
+
+func (r *Recorder) record(kind string, data string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed || (r.Truncated && kind != EventInput) {
+		return
+	}
+	elapsed := float64(time.Since(r.start).Microseconds()) / 1e6
+	line, err := json.Marshal([]interface{}{elapsed, kind, data})
+	if err != nil {
+		return
+	}
+	if !r.Truncated && r.MaxSize > 0 && r.size+int64(len(line))+1 > r.MaxSize {
+		r.Truncated = true
+		close(r.exceeded)
+		if kind != EventInput {
+			return
+		}
+	}
+	r.writeHeader()
+	r.pending.Write(append(line, '\n'))
+	r.size += int64(len(line)) + 1
+	r.written = true
+	if r.ChunkSize > 0 && r.pending.Len() >= r.ChunkSize {
+		select {
+		case r.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// writeHeader header 在第一个事件或结束时写入, 之前的 Resize 可以修改终端大小
+func (r *Recorder) writeHeader() {
+	if r.headerWritten {
+		return
+	}
+	header, err := json.Marshal(r.header)
+	if err != nil {
+		return
+	}
+	r.pending.Write(append(header, '\n'))
+	r.headerWritten = true
+}
+
+// Full 缓存的内容超过 ChunkSize 时收到通知
+func (r *Recorder) Full() <-chan struct{} {
+	return r.full
+}
+
+// Exceeded 记录的内容超过 MaxSize 被截断时关闭
+func (r *Recorder) Exceeded() <-chan struct{} {
+	return r.exceeded
+}
+
+// Chunk 取出缓存的内容, 按顺序拼接所有的块即为完整的 asciicast 内容
+// 第一个事件前没有内容, Close 后的第一个块至少包含 header
+func (r *Recorder) Chunk() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		r.writeHeader()
+	}
+	if r.pending.Len() == 0 {
+		return nil
+	}
+	chunk := make([]byte, r.pending.Len())
+	copy(chunk, r.pending.Bytes())
+	r.pending.Reset()
+	return chunk
+}
+
+// Close 停止记录
+func (r *Recorder) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.closed {
+		r.closed = true
+		r.end = time.Now()
+	}
+}
+
+func (r *Recorder) StartedAt() time.Time {
+	return r.start
+}
+
+func (r *Recorder) Duration() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return r.end.Sub(r.start)
+	}
+	return time.Since(r.start)
+}
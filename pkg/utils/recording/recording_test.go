@@ -0,0 +1,227 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package recording
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func readCast(t *testing.T, r *Recorder) (Header, [][]interface{}) {
+	t.Helper()
+	data := r.Chunk()
+	if more := r.Chunk(); len(more) != 0 {
+		t.Fatalf("chunk should be empty after taken, got %q", more)
+	}
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	header := Header{}
+	events := [][]interface{}{}
+	for i := 0; scanner.Scan(); i++ {
+		if i == 0 {
+			if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		event := []interface{}{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	return header, events
+}
+
+func TestRecorder(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxsize    int64
+		record     func(r *Recorder)
+		wantWidth  int
+		wantHeight int
+		wantEvents []string
+		truncated  bool
+	}{
+		{
+			name: "resize before first event sets header size",
+			record: func(r *Recorder) {
+				r.Resize(120, 40)
+				r.Output([]byte("$ "))
+				r.Input("ls\r")
+				r.Resize(100, 30)
+			},
+			wantWidth:  120,
+			wantHeight: 40,
+			wantEvents: []string{"o:$ ", "i:ls\r", "r:100x30"},
+		},
+		{
+			name: "default size",
+			record: func(r *Recorder) {
+				r.Output([]byte("hello"))
+			},
+			wantWidth:  defaultWidth,
+			wantHeight: defaultHeight,
+			wantEvents: []string{"o:hello"},
+		},
+		{
+			name:    "truncated after max size",
+			maxsize: 40,
+			record: func(r *Recorder) {
+				r.Output([]byte("first"))
+				r.Output([]byte(strings.Repeat("x", 64)))
+				r.Output([]byte("last"))
+			},
+			wantWidth:  defaultWidth,
+			wantHeight: defaultHeight,
+			wantEvents: []string{"o:first"},
+			truncated:  true,
+		},
+		{
+			name:    "input recorded after truncated",
+			maxsize: 40,
+			record: func(r *Recorder) {
+				r.Output([]byte("first"))
+				r.Output([]byte(strings.Repeat("x", 64)))
+				r.Input("rm -rf /tmp/data\r")
+				r.Resize(100, 30)
+				r.Output([]byte("last"))
+			},
+			wantWidth:  defaultWidth,
+			wantHeight: defaultHeight,
+			wantEvents: []string{"o:first", "i:rm -rf /tmp/data\r"},
+			truncated:  true,
+		},
+		{
+			name: "no events after close",
+			record: func(r *Recorder) {
+				r.Output([]byte("a"))
+				r.Close()
+				r.Output([]byte("b"))
+			},
+			wantWidth:  defaultWidth,
+			wantHeight: defaultHeight,
+			wantEvents: []string{"o:a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRecorder("test", tt.maxsize)
+			tt.record(r)
+			r.Close()
+
+			header, events := readCast(t, r)
+			if header.Version != 2 || header.Title != "test" {
+				t.Errorf("unexpected header %+v", header)
+			}
+			if header.Width != tt.wantWidth || header.Height != tt.wantHeight {
+				t.Errorf("size = %dx%d, want %dx%d", header.Width, header.Height, tt.wantWidth, tt.wantHeight)
+			}
+			got := []string{}
+			for _, event := range events {
+				if len(event) != 3 {
+					t.Fatalf("invalid event %v", event)
+				}
+				if _, ok := event[0].(float64); !ok {
+					t.Errorf("invalid event time %v", event[0])
+				}
+				got = append(got, event[1].(string)+":"+event[2].(string))
+			}
+			if strings.Join(got, "|") != strings.Join(tt.wantEvents, "|") {
+				t.Errorf("events = %q, want %q", got, tt.wantEvents)
+			}
+			if r.Truncated != tt.truncated {
+				t.Errorf("truncated = %v, want %v", r.Truncated, tt.truncated)
+			}
+			select {
+			case <-r.Exceeded():
+				if !tt.truncated {
+					t.Errorf("exceeded closed but not truncated")
+				}
+			default:
+				if tt.truncated {
+					t.Errorf("exceeded not closed after truncated")
+				}
+			}
+		})
+	}
+}
+
+func TestRecorder_Chunk(t *testing.T) {
+	r := NewRecorder("test", 0)
+	r.ChunkSize = 16
+	if chunk := r.Chunk(); chunk != nil {
+		t.Errorf("chunk before first event = %q, want empty", chunk)
+	}
+	r.Resize(120, 40)
+	r.Output([]byte("hello"))
+	select {
+	case <-r.Full():
+	default:
+		t.Error("recorder should notify when chunk is full")
+	}
+	first := r.Chunk()
+	r.Output([]byte("world"))
+	r.Close()
+	second := r.Chunk()
+
+	header := Header{}
+	if err := json.Unmarshal([]byte(strings.SplitN(string(first), "\n", 2)[0]), &header); err != nil {
+		t.Fatal(err)
+	}
+	if header.Width != 120 || header.Height != 40 {
+		t.Errorf("size = %dx%d, want 120x40", header.Width, header.Height)
+	}
+	if strings.Count(string(first)+string(second), "\n") != 3 {
+		t.Errorf("content = %q, want header and two events", string(first)+string(second))
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	ctx := context.Background()
+	store := &LocalStore{Dir: t.TempDir()}
+
+	for _, key := range []string{"../escape.cast", "a/../../b.cast", ""} {
+		if err := store.Put(ctx, key, strings.NewReader("x"), 1); err == nil {
+			t.Errorf("Put(%q) should fail", key)
+		}
+	}
+
+	key := "2022/01/02/session.cast"
+	if err := store.Put(ctx, key, strings.NewReader("content"), 7); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := store.Open(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "content" {
+		t.Errorf("content = %q", data)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Open(ctx, key); err == nil {
+		t.Error("recording should be deleted")
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("delete missing recording: %v", err)
+	}
+}
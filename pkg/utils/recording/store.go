@@ -0,0 +1,180 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package recording
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	StorageLocal = "local"
+	StorageS3    = "s3"
+)
+
+type Options struct {
+	Enabled     bool   `json:"enabled" description:"record pod shell, pod debug and kubectl terminal sessions"`
+	Required    bool   `json:"required" description:"refuse terminal sessions when recording can not be started"`
+	Storage     string `json:"storage" description:"storage of recordings, local or s3"`
+	Dir         string `json:"dir" description:"directory of recordings when storage is local"`
+	S3URL       string `json:"s3URL" description:"s3 endpoint"`
+	S3Bucket    string `json:"s3Bucket" description:"s3 bucket"`
+	S3Region    string `json:"s3Region" description:"s3 region"`
+	S3AccessKey string `json:"s3AccessKey" description:"s3 access key"`
+	S3SecretKey string `json:"s3SecretKey" description:"s3 secret key"`
+	Retention   string `json:"retention" description:"retention of recordings, eg. 90d"`
+	MaxSize     int64  `json:"maxSize" description:"max size in bytes of a recording, output after that is not recorded"`
+}
+
+func NewDefaultOptions() *Options {
+	return &Options{
+		Enabled:   false,
+		Required:  true,
+		Storage:   StorageLocal,
+		Dir:       "data/recordings",
+		S3Region:  "us-east-1",
+		Retention: "90d",
+		MaxSize:   256 << 20,
+	}
+}
+
+// Store 录像的存储
+type Store interface {
+	Put(ctx context.Context, key string, content io.Reader, size int64) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+func NewStore(ctx context.Context, options *Options) (Store, error) {
+	switch options.Storage {
+	case StorageLocal, "":
+		return &LocalStore{Dir: options.Dir}, nil
+	case StorageS3:
+		return NewS3Store(ctx, options)
+	default:
+		return nil, fmt.Errorf("unsupported recording storage %s", options.Storage)
+	}
+}
+
+type LocalStore struct {
+	Dir string
+}
+
+func (s *LocalStore) Put(ctx context.Context, key string, content io.Reader, size int64) error {
+	filename, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0o750); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *LocalStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	filename, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(filename)
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	filename, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStore) path(key string) (string, error) {
+	cleaned := filepath.Clean("/" + key)
+	if strings.Contains(key, "..") || cleaned == "/" {
+		return "", fmt.Errorf("invalid recording key %s", key)
+	}
+	return filepath.Join(s.Dir, cleaned), nil
+}
+
+type S3Store struct {
+	bucket string
+	cli    *s3.Client
+}
+
+func NewS3Store(ctx context.Context, options *Options) (*S3Store, error) {
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithCredentialsProvider(
+			credentials.StaticCredentialsProvider{Value: aws.Credentials{AccessKeyID: options.S3AccessKey, SecretAccessKey: options.S3SecretKey}},
+		),
+		config.WithEndpointResolverWithOptions(
+			aws.EndpointResolverWithOptionsFunc(
+				func(service, region string, opts ...interface{}) (aws.Endpoint, error) {
+					return aws.Endpoint{URL: options.S3URL}, nil
+				},
+			),
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+	cli := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.Region = options.S3Region
+		o.UsePathStyle = true
+	})
+	return &S3Store{bucket: options.S3Bucket, cli: cli}, nil
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, content io.Reader, size int64) error {
+	_, err := s.cli.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(key),
+		Body:          content,
+		ContentLength: size,
+		ContentType:   aws.String("application/x-asciicast"),
+	})
+	return err
+}
+
+func (s *S3Store) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.cli.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	_, err := s.cli.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+	return err
+}
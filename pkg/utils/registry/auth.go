@@ -0,0 +1,241 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// token 未返回过期时间时的默认有效期
+// https://docs.docker.com/registry/spec/auth/token/#requesting-a-token
+const defaultTokenExpiresIn = 60 * time.Second
+
+type challenge struct {
+	Scheme string
+	Params map[string]string
+}
+
+type cachedToken struct {
+	token   string
+	expires time.Time
+}
+
+// authTransport 按照仓库返回的 WWW-Authenticate 使用 Basic 认证或获取 Bearer token, token 按仓库及操作缓存
+// 兼容 distribution, Docker Hub, harbor, ACR 及使用 Basic 认证的 ECR 等
+type authTransport struct {
+	Base     http.RoundTripper
+	Username string
+	Password string
+
+	mu     sync.Mutex
+	basic  map[string]bool
+	tokens map[string]cachedToken
+}
+
+func newAuthTransport(base http.RoundTripper, username, password string) *authTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &authTransport{
+		Base:     base,
+		Username: username,
+		Password: password,
+		basic:    map[string]bool{},
+		tokens:   map[string]cachedToken{},
+	}
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := tokenCacheKey(req)
+	resp, err := t.Base.RoundTrip(t.authorize(req, key))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	// 请求均没有 body, 可以重试
+	for _, c := range parseChallenges(resp.Header.Values("WWW-Authenticate")) {
+		switch strings.ToLower(c.Scheme) {
+		case "basic":
+			if t.Username == "" && t.Password == "" {
+				return resp, nil
+			}
+			resp.Body.Close()
+			t.mu.Lock()
+			t.basic[req.URL.Host] = true
+			t.mu.Unlock()
+			return t.Base.RoundTrip(t.authorize(req, key))
+		case "bearer":
+			token, err := t.fetchToken(req.Context(), c.Params)
+			if err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+			resp.Body.Close()
+			t.mu.Lock()
+			t.tokens[key] = token
+			t.mu.Unlock()
+			return t.Base.RoundTrip(t.authorize(req, key))
+		}
+	}
+	return resp, nil
+}
+
+func (t *authTransport) authorize(req *http.Request, key string) *http.Request {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cloned := req.Clone(req.Context())
+	if token, ok := t.tokens[key]; ok && time.Now().Before(token.expires) {
+		cloned.Header.Set("Authorization", "Bearer "+token.token)
+	} else if t.basic[req.URL.Host] {
+		cloned.SetBasicAuth(t.Username, t.Password)
+	}
+	return cloned
+}
+
+func (t *authTransport) fetchToken(ctx context.Context, params map[string]string) (cachedToken, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return cachedToken{}, fmt.Errorf("invalid token realm %q", params["realm"])
+	}
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	for _, scope := range strings.Fields(params["scope"]) {
+		query.Add("scope", scope)
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return cachedToken{}, err
+	}
+	if t.Username != "" || t.Password != "" {
+		req.SetBasicAuth(t.Username, t.Password)
+	}
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		return cachedToken{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return cachedToken{}, fmt.Errorf("get token from %s: %s %s", realm.Host, resp.Status, strings.TrimSpace(string(body)))
+	}
+	// nolint: tagliatelle
+	tokenresp := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenresp); err != nil {
+		return cachedToken{}, err
+	}
+	token := tokenresp.Token
+	if token == "" {
+		token = tokenresp.AccessToken
+	}
+	if token == "" {
+		return cachedToken{}, errors.New("empty token returned from " + realm.Host)
+	}
+	expiresIn := defaultTokenExpiresIn
+	if tokenresp.ExpiresIn > 0 {
+		expiresIn = time.Duration(tokenresp.ExpiresIn) * time.Second
+	}
+	// 预留时间避免使用时过期
+	return cachedToken{token: token, expires: time.Now().Add(expiresIn * 9 / 10)}, nil
+}
+
+// tokenCacheKey 同一仓库的读取与删除需要不同 scope 的 token
+func tokenCacheKey(req *http.Request) string {
+	action := "pull"
+	if req.Method == http.MethodDelete {
+		action = "delete"
+	}
+	name := req.URL.Path
+	for _, sep := range []string{"/manifests/", "/blobs/", "/tags/"} {
+		if i := strings.Index(name, sep); i >= 0 {
+			name = name[:i]
+			break
+		}
+	}
+	return req.URL.Host + name + ":" + action
+}
+
+// parseChallenges 解析 WWW-Authenticate, 如 Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/nginx:pull"
+func parseChallenges(headers []string) []challenge {
+	challenges := []challenge{}
+	for _, header := range headers {
+		header = strings.TrimSpace(header)
+		scheme, rest := header, ""
+		if i := strings.IndexByte(header, ' '); i >= 0 {
+			scheme, rest = header[:i], header[i+1:]
+		}
+		if scheme == "" {
+			continue
+		}
+		challenges = append(challenges, challenge{Scheme: scheme, Params: parseChallengeParams(rest)})
+	}
+	return challenges
+}
+
+func parseChallengeParams(s string) map[string]string {
+	params := map[string]string{}
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimLeft(s, ", ") {
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimSpace(s[eq+1:])
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			// 引号中的值可能包含逗号
+			end := 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			value = strings.ReplaceAll(s[1:min(end, len(s))], `\"`, `"`)
+			s = s[min(end+1, len(s)):]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+		params[key] = value
+	}
+	return params
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
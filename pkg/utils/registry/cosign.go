@@ -0,0 +1,145 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// https://github.com/sigstore/cosign/blob/main/specs/SIGNATURE_SPEC.md
+const (
+	CosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+	CosignSimpleSigningType   = "application/vnd.dev.cosign.simplesigning.v1+json"
+)
+
+var ErrSignatureNotFound = errors.New("no cosign signature found")
+
+// cosign simple signing 格式的签名内容
+type cosignPayload struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// VerifyCosignSignature 校验镜像是否有 publickeys 中任一公钥的 cosign 签名
+func VerifyCosignSignature(ctx context.Context, reg Registry, image string, publickeys []string) error {
+	manifest, err := reg.GetManifest(ctx, image)
+	if err != nil {
+		return err
+	}
+	// 签名保存在 sha256-<digest>.sig tag 中
+	sigimage := WithReference(image, strings.Replace(manifest.Digest, ":", "-", 1)+".sig")
+	signature, err := reg.GetManifest(ctx, sigimage)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSignatureNotFound, err)
+	}
+	var lasterr error = ErrSignatureNotFound
+	for _, layer := range signature.Layers {
+		sig, ok := layer.Annotations[CosignSignatureAnnotation]
+		if !ok {
+			continue
+		}
+		payload, err := reg.GetBlob(ctx, image, layer.Digest)
+		if err != nil {
+			lasterr = err
+			continue
+		}
+		if lasterr = VerifyCosignPayload(payload, sig, manifest.Digest, publickeys); lasterr == nil {
+			return nil
+		}
+	}
+	return lasterr
+}
+
+// VerifyCosignPayload 校验 base64 编码的签名及签名内容中的镜像 digest
+func VerifyCosignPayload(payload []byte, signature string, digest string, publickeys []string) error {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	verified := false
+	for _, key := range publickeys {
+		pub, err := parsePublicKey(key)
+		if err != nil {
+			return err
+		}
+		if verifySignature(pub, payload, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return errors.New("signature is not signed by any of the trusted keys")
+	}
+	content := &cosignPayload{}
+	if err := json.Unmarshal(payload, content); err != nil {
+		return fmt.Errorf("invalid signature payload: %w", err)
+	}
+	if content.Critical.Image.DockerManifestDigest != digest {
+		return fmt.Errorf("signature is for digest %s, not %s", content.Critical.Image.DockerManifestDigest, digest)
+	}
+	return nil
+}
+
+// VerifyPublicKey 检查是否为支持的 PEM 格式公钥
+func VerifyPublicKey(key string) error {
+	pub, err := parsePublicKey(key)
+	if err != nil {
+		return err
+	}
+	switch pub.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		return nil
+	default:
+		return fmt.Errorf("unsupported public key type %T", pub)
+	}
+}
+
+func parsePublicKey(key string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return nil, errors.New("invalid public key: not PEM encoded")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+func verifySignature(pub crypto.PublicKey, payload, sig []byte) bool {
+	hashed := sha256.Sum256(payload)
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, hashed[:], sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], sig) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, payload, sig)
+	default:
+		return false
+	}
+}
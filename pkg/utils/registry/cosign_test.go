@@ -0,0 +1,111 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+)
+
+func TestVerifyCosignPayload(t *testing.T) {
+	genkey := func() (*ecdsa.PrivateKey, string) {
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.MarshalPKIXPublicKey(priv.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return priv, string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	}
+	sign := func(priv crypto.Signer, payload string) string {
+		hashed := sha256.Sum256([]byte(payload))
+		sig, err := priv.Sign(rand.Reader, hashed[:], crypto.SHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.StdEncoding.EncodeToString(sig)
+	}
+	trusted, trustedpub := genkey()
+	untrusted, _ := genkey()
+	_, otherpub := genkey()
+
+	const digest = "sha256:4b6c8a1f2d7b9d4d1b0a8d2a2b3f6e6c5d7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a"
+	payload := `{"critical":{"identity":{"docker-reference":"harbor.example.com/library/nginx"},"image":{"docker-manifest-digest":"` + digest + `"},"type":"cosign container image signature"},"optional":null}`
+
+	tests := []struct {
+		name      string
+		payload   string
+		signature string
+		digest    string
+		keys      []string
+		wantErr   bool
+	}{
+		{
+			name:      "trusted",
+			payload:   payload,
+			signature: sign(trusted, payload),
+			digest:    digest,
+			keys:      []string{otherpub, trustedpub},
+		},
+		{
+			name:      "untrusted key",
+			payload:   payload,
+			signature: sign(untrusted, payload),
+			digest:    digest,
+			keys:      []string{trustedpub},
+			wantErr:   true,
+		},
+		{
+			name:      "other image",
+			payload:   payload,
+			signature: sign(trusted, payload),
+			digest:    "sha256:0000000000000000000000000000000000000000000000000000000000000000",
+			keys:      []string{trustedpub},
+			wantErr:   true,
+		},
+		{
+			name:      "invalid signature",
+			payload:   payload,
+			signature: "not-base64!",
+			digest:    digest,
+			keys:      []string{trustedpub},
+			wantErr:   true,
+		},
+		{
+			name:      "invalid key",
+			payload:   payload,
+			signature: sign(trusted, payload),
+			digest:    digest,
+			keys:      []string{"not a key"},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := VerifyCosignPayload([]byte(tt.payload), tt.signature, tt.digest, tt.keys); (err != nil) != tt.wantErr {
+				t.Errorf("VerifyCosignPayload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
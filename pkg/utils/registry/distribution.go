@@ -0,0 +1,270 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	specsv1 "github.com/opencontainers/distribution-spec/specs-go/v1"
+)
+
+// manifest 及 config 的最大读取长度
+const maxManifestSize = 4 << 20
+
+var manifestAcceptTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// Distribution 实现 OCI Distribution 规范的仓库, 如 docker registry, Docker Hub, ACR, ECR 等
+// https://github.com/opencontainers/distribution-spec/blob/main/spec.md#endpoints
+type Distribution struct {
+	Server string
+	client *http.Client
+}
+
+func NewDistribution(server, username, password string) *Distribution {
+	return &Distribution{
+		Server: strings.TrimSuffix(server, "/"),
+		client: &http.Client{Transport: newAuthTransport(http.DefaultTransport, username, password), Timeout: time.Minute},
+	}
+}
+
+func (d *Distribution) Kind() string {
+	return KindDistribution
+}
+
+func (d *Distribution) Capabilities() []Capability {
+	return []Capability{CapabilityListTags, CapabilityManifest, CapabilityDelete}
+}
+
+// end-1	GET	/v2/	200	404/401
+func (d *Distribution) Ping(ctx context.Context) error {
+	resp, err := d.do(ctx, http.MethodGet, "/v2/", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// end-8a	GET	/v2/<name>/tags/list
+// 分页时按照 Link header 继续获取
+func (d *Distribution) ListTags(ctx context.Context, image string) ([]Tag, error) {
+	repository, _, err := ParseImage(image)
+	if err != nil {
+		return nil, err
+	}
+	tags := []Tag{}
+	path := "/v2/" + repository + "/tags/list"
+	for path != "" {
+		resp, err := d.do(ctx, http.MethodGet, path, nil)
+		if err != nil {
+			return nil, err
+		}
+		list := &specsv1.TagList{}
+		err = json.NewDecoder(resp.Body).Decode(list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range list.Tags {
+			tags = append(tags, Tag{Name: tag})
+		}
+		path = nextLink(resp.Header.Get("Link"))
+	}
+	return tags, nil
+}
+
+// end-3	GET	/v2/<name>/manifests/<reference>
+func (d *Distribution) GetManifest(ctx context.Context, image string) (*Manifest, error) {
+	repository, reference, err := ParseImage(image)
+	if err != nil {
+		return nil, err
+	}
+	content, digest, mediatype, err := d.getManifest(ctx, repository, reference)
+	if err != nil {
+		return nil, err
+	}
+	raw := struct {
+		MediaType string       `json:"mediaType"`
+		Config    Descriptor   `json:"config"`
+		Layers    []Descriptor `json:"layers"`
+		Manifests []struct {
+			Descriptor
+			Platform Platform `json:"platform"`
+		} `json:"manifests"`
+	}{}
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return nil, err
+	}
+	if raw.MediaType != "" {
+		mediatype = raw.MediaType
+	}
+	manifest := &Manifest{
+		Digest:    digest,
+		MediaType: mediatype,
+		Size:      int64(len(content)),
+		Platforms: []Platform{},
+		Layers:    raw.Layers,
+	}
+	for _, m := range raw.Manifests {
+		platform := m.Platform
+		platform.Digest = m.Digest
+		manifest.Platforms = append(manifest.Platforms, platform)
+	}
+	if raw.Config.Digest != "" && len(raw.Manifests) == 0 {
+		config := struct {
+			Created      *time.Time `json:"created"`
+			OS           string     `json:"os"`
+			Architecture string     `json:"architecture"`
+			Variant      string     `json:"variant"`
+		}{}
+		// cosign 签名等非镜像的 config 不是镜像配置, 忽略解析错误
+		if content, err := d.getBlob(ctx, repository, raw.Config.Digest); err == nil && json.Unmarshal(content, &config) == nil {
+			manifest.Created = config.Created
+			if config.OS != "" {
+				manifest.Platforms = append(manifest.Platforms, Platform{
+					OS: config.OS, Architecture: config.Architecture, Variant: config.Variant, Digest: digest,
+				})
+			}
+		}
+	}
+	return manifest, nil
+}
+
+// end-2	GET	/v2/<name>/blobs/<digest>
+func (d *Distribution) GetBlob(ctx context.Context, image string, digest string) ([]byte, error) {
+	repository, _, err := ParseImage(image)
+	if err != nil {
+		return nil, err
+	}
+	return d.getBlob(ctx, repository, digest)
+}
+
+// end-9	DELETE	/v2/<name>/manifests/<reference>
+// 多数仓库仅支持按 digest 删除, tag 先解析为 digest; 仓库未开启删除时返回 ErrUnsupported
+func (d *Distribution) Delete(ctx context.Context, image string) error {
+	repository, reference, err := ParseImage(image)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(reference, "sha256:") {
+		if _, reference, _, err = d.getManifest(ctx, repository, reference); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, d.Server+"/v2/"+repository+"/manifests/"+reference, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusAccepted, http.StatusOK:
+		return nil
+	case http.StatusMethodNotAllowed:
+		return fmt.Errorf("delete %s: %w", image, ErrUnsupported)
+	default:
+		return responseError(http.MethodDelete, req.URL.Path, resp)
+	}
+}
+
+func (d *Distribution) getManifest(ctx context.Context, repository, reference string) ([]byte, string, string, error) {
+	resp, err := d.do(ctx, http.MethodGet, "/v2/"+repository+"/manifests/"+reference, http.Header{"Accept": manifestAcceptTypes})
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return nil, "", "", err
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		digest = fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+	}
+	return content, digest, resp.Header.Get("Content-Type"), nil
+}
+
+func (d *Distribution) getBlob(ctx context.Context, repository, digest string) ([]byte, error) {
+	resp, err := d.do(ctx, http.MethodGet, "/v2/"+repository+"/blobs/"+digest, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+}
+
+func (d *Distribution) do(ctx context.Context, method string, path string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, d.Server+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, responseError(method, req.URL.Path, resp)
+	}
+	return resp, nil
+}
+
+func responseError(method, path string, resp *http.Response) error {
+	errresp := &specsv1.ErrorResponse{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(errresp); err != nil || len(errresp.Errors) == 0 {
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	msgs := []string{}
+	for _, e := range errresp.Errors {
+		msgs = append(msgs, e.Code+": "+e.Message)
+	}
+	return fmt.Errorf("%s %s: %s %s", method, path, resp.Status, strings.Join(msgs, "; "))
+}
+
+// nextLink 解析分页的 Link header, 如 </v2/library/nginx/tags/list?last=b&n=100>; rel="next"
+func nextLink(link string) string {
+	if link == "" || !strings.Contains(link, `rel="next"`) {
+		return ""
+	}
+	start, end := strings.IndexByte(link, '<'), strings.IndexByte(link, '>')
+	if start < 0 || end < start {
+		return ""
+	}
+	u, err := url.Parse(link[start+1 : end])
+	if err != nil {
+		return ""
+	}
+	return u.RequestURI()
+}
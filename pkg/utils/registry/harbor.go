@@ -0,0 +1,76 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"sort"
+
+	"github.com/goharbor/harbor/src/pkg/scan/vuln"
+	"kubegems.io/kubegems/pkg/utils/harbor"
+)
+
+// Harbor 在 OCI Distribution 之上使用 harbor api 提供推送时间, 标签及漏洞扫描
+type Harbor struct {
+	*Distribution
+	Client *harbor.Client
+}
+
+func (h *Harbor) Kind() string {
+	return KindHarbor
+}
+
+func (h *Harbor) Capabilities() []Capability {
+	return append(h.Distribution.Capabilities(), CapabilityScan, CapabilityLabel)
+}
+
+func (h *Harbor) ListTags(ctx context.Context, image string) ([]Tag, error) {
+	artifacts, err := h.Client.ListArtifact(ctx, image, harbor.GetArtifactOptions{WithTag: true, WithLabel: true})
+	if err != nil {
+		return nil, err
+	}
+	tags := []Tag{}
+	for _, artifact := range artifacts {
+		labels := make([]string, 0, len(artifact.Labels))
+		for _, label := range artifact.Labels {
+			labels = append(labels, label.Name)
+		}
+		for _, tag := range artifact.Tags {
+			pushedAt := tag.PushTime
+			tags = append(tags, Tag{Name: tag.Name, Digest: artifact.Digest, Labels: labels, PushedAt: &pushedAt})
+		}
+	}
+	return tags, nil
+}
+
+func (h *Harbor) Scan(ctx context.Context, image string) error {
+	return h.Client.ScanArtifact(ctx, image)
+}
+
+// Vulnerabilities 漏洞按照等级从高到低排序
+func (h *Harbor) Vulnerabilities(ctx context.Context, image string) (*vuln.Report, error) {
+	vulnerabilities, err := h.Client.GetArtifactVulnerabilities(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+	report := vuln.Report{}
+	for _, v := range *vulnerabilities {
+		report = v
+	}
+	sort.Slice(report.Vulnerabilities, func(i, j int) bool {
+		return report.Vulnerabilities[i].Severity.Code() > report.Vulnerabilities[j].Severity.Code()
+	})
+	return &report, nil
+}
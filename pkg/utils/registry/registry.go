@@ -0,0 +1,199 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	dockerreference "github.com/containerd/containerd/reference/docker"
+	"github.com/goharbor/harbor/src/pkg/scan/vuln"
+	"kubegems.io/kubegems/pkg/utils/harbor"
+)
+
+const (
+	KindDistribution = "distribution"
+	KindHarbor       = "harbor"
+)
+
+// Capability 镜像仓库支持的功能
+type Capability string
+
+const (
+	CapabilityListTags Capability = "listTags" // 列出 tag
+	CapabilityManifest Capability = "manifest" // 查询 digest 及多架构镜像的平台
+	CapabilityDelete   Capability = "delete"   // 删除镜像
+	CapabilityScan     Capability = "scan"     // 漏洞扫描
+	CapabilityLabel    Capability = "label"    // 镜像标签(如不可发布)
+)
+
+var ErrUnsupported = errors.New("operation is not supported by the registry")
+
+type Tag struct {
+	Name   string   `json:"name"`
+	Digest string   `json:"digest,omitempty"`
+	Labels []string `json:"labels,omitempty"`
+	// PushedAt 推送时间, 仓库不提供时为空
+	PushedAt *time.Time `json:"pushedAt,omitempty"`
+}
+
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+	Digest       string `json:"digest,omitempty"`
+}
+
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type Manifest struct {
+	Digest    string `json:"digest"`
+	MediaType string `json:"mediaType"`
+	Size      int64  `json:"size"`
+	// Platforms 多架构镜像为 index 中的各平台, 单架构镜像为 config 中的平台
+	Platforms []Platform   `json:"platforms"`
+	Layers    []Descriptor `json:"layers,omitempty"`
+	// Created 镜像构建时间, 仅单架构镜像可以获取
+	Created *time.Time `json:"created,omitempty"`
+}
+
+// Registry 镜像仓库的统一抽象, image 均为完整的镜像名称, 如 registry.example.com/project/app:v1
+type Registry interface {
+	Kind() string
+	Capabilities() []Capability
+	ListTags(ctx context.Context, image string) ([]Tag, error)
+	GetManifest(ctx context.Context, image string) (*Manifest, error)
+	GetBlob(ctx context.Context, image string, digest string) ([]byte, error)
+	// Delete 删除镜像 manifest, 同一 digest 的其他 tag 也会被删除
+	Delete(ctx context.Context, image string) error
+}
+
+// Scanner 支持漏洞扫描的仓库
+type Scanner interface {
+	Scan(ctx context.Context, image string) error
+	Vulnerabilities(ctx context.Context, image string) (*vuln.Report, error)
+}
+
+type Options struct {
+	// Address 仓库地址, 为空时使用镜像中的域名
+	Address  string
+	Username string
+	Password string
+	// Harbor 是否为 harbor 仓库, 为空时自动检测
+	Harbor *bool
+}
+
+// New 根据仓库类型返回对应实现, 未指定类型时通过 harbor systeminfo 接口检测
+func New(ctx context.Context, image string, options Options) (Registry, error) {
+	address := options.Address
+	if address == "" {
+		address = AddressOf(image)
+	}
+	distribution := NewDistribution(address, options.Username, options.Password)
+
+	isharbor := false
+	if options.Harbor != nil {
+		isharbor = *options.Harbor
+	} else if !isDockerHub(address) {
+		cli, err := harbor.NewClient(address, options.Username, options.Password)
+		if err != nil {
+			return nil, err
+		}
+		_, err = cli.SystemInfo(ctx)
+		isharbor = err == nil
+	}
+	if !isharbor {
+		return distribution, nil
+	}
+	cli, err := harbor.NewClient(address, options.Username, options.Password)
+	if err != nil {
+		return nil, err
+	}
+	return &Harbor{Distribution: distribution, Client: cli}, nil
+}
+
+// TryLogin 使用认证信息访问仓库 /v2/ 接口, 兼容 Basic 及 Bearer token 认证
+func TryLogin(ctx context.Context, address, username, password string) error {
+	return NewDistribution(address, username, password).Ping(ctx)
+}
+
+// AddressOf 镜像所在仓库的地址, docker.io 的镜像使用 registry-1.docker.io
+func AddressOf(image string) string {
+	domain := "docker.io"
+	if named, err := dockerreference.ParseNormalizedNamed(image); err == nil {
+		domain = dockerreference.Domain(named)
+	}
+	if domain == "docker.io" || domain == "index.docker.io" {
+		domain = "registry-1.docker.io"
+	}
+	return "https://" + domain
+}
+
+func isDockerHub(address string) bool {
+	address = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(address, "https://"), "http://"), "/")
+	return address == "registry-1.docker.io" || address == "docker.io" || address == "index.docker.io"
+}
+
+// ParseImage 返回镜像的仓库路径及 tag 或 digest, docker.io 的官方镜像路径为 library/<name>
+func ParseImage(image string) (repository, reference string, err error) {
+	named, err := dockerreference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", "", err
+	}
+	repository = dockerreference.Path(named)
+	if digested, ok := named.(dockerreference.Digested); ok {
+		return repository, digested.Digest().String(), nil
+	}
+	if tagged, ok := named.(dockerreference.Tagged); ok {
+		return repository, tagged.Tag(), nil
+	}
+	return repository, "latest", nil
+}
+
+// WithReference 替换镜像的 tag 或 digest
+func WithReference(image, reference string) string {
+	name := RepositoryName(image)
+	if strings.HasPrefix(reference, "sha256:") {
+		return name + "@" + reference
+	}
+	return name + ":" + reference
+}
+
+// RepositoryName 去除镜像的 tag 及 digest
+func RepositoryName(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
+
+func HasCapability(reg Registry, capability Capability) bool {
+	for _, c := range reg.Capabilities() {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
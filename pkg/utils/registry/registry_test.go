@@ -0,0 +1,164 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseChallenges(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []string
+		want    []challenge
+	}{
+		{
+			name:    "bearer",
+			headers: []string{`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/nginx:pull"`},
+			want: []challenge{{Scheme: "Bearer", Params: map[string]string{
+				"realm": "https://auth.docker.io/token", "service": "registry.docker.io", "scope": "repository:library/nginx:pull",
+			}}},
+		},
+		{
+			name:    "scope with comma",
+			headers: []string{`Bearer realm="https://harbor.example.com/service/token", service="harbor-registry", scope="repository:library/app:pull,push"`},
+			want: []challenge{{Scheme: "Bearer", Params: map[string]string{
+				"realm": "https://harbor.example.com/service/token", "service": "harbor-registry", "scope": "repository:library/app:pull,push",
+			}}},
+		},
+		{
+			name:    "basic",
+			headers: []string{`Basic realm="ECR"`},
+			want:    []challenge{{Scheme: "Basic", Params: map[string]string{"realm": "ECR"}}},
+		},
+		{
+			name:    "unquoted",
+			headers: []string{`Bearer realm=https://example.com/token,service=example`},
+			want:    []challenge{{Scheme: "Bearer", Params: map[string]string{"realm": "https://example.com/token", "service": "example"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseChallenges(tt.headers); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseChallenges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseImage(t *testing.T) {
+	tests := []struct {
+		image          string
+		wantRepository string
+		wantReference  string
+	}{
+		{image: "nginx", wantRepository: "library/nginx", wantReference: "latest"},
+		{image: "kubegems/nginx:v1", wantRepository: "kubegems/nginx", wantReference: "v1"},
+		{image: "registry.example.com/app:v1", wantRepository: "app", wantReference: "v1"},
+		{image: "registry.example.com:5000/a/b/app@sha256:4b6c8a1f2d7b9d4d1b0a8d2a2b3f6e6c5d7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a", wantRepository: "a/b/app", wantReference: "sha256:4b6c8a1f2d7b9d4d1b0a8d2a2b3f6e6c5d7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			repository, reference, err := ParseImage(tt.image)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if repository != tt.wantRepository || reference != tt.wantReference {
+				t.Errorf("ParseImage() = %s, %s, want %s, %s", repository, reference, tt.wantRepository, tt.wantReference)
+			}
+		})
+	}
+}
+
+// 模拟使用 Bearer token 认证且未开启删除的仓库
+func newTestRegistry(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	var server *httptest.Server
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if username, password, _ := r.BasicAuth(); username != "user" || password != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"token": "token-" + r.URL.Query().Get("scope"), "expires_in": 300})
+	})
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		const scope = "repository:library/app:pull"
+		if r.Header.Get("Authorization") != "Bearer token-"+scope {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test",scope="`+scope+`"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Method + " " + r.URL.RequestURI() {
+		case "GET /v2/library/app/tags/list":
+			w.Header().Set("Link", `</v2/library/app/tags/list?last=v1&n=1>; rel="next"`)
+			_, _ = w.Write([]byte(`{"name":"library/app","tags":["v1"]}`))
+		case "GET /v2/library/app/tags/list?last=v1&n=1":
+			_, _ = w.Write([]byte(`{"name":"library/app","tags":["v2"]}`))
+		case "GET /v2/library/app/manifests/v1":
+			w.Header().Set("Docker-Content-Digest", "sha256:index")
+			_, _ = w.Write([]byte(`{"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[
+				{"digest":"sha256:amd64","platform":{"os":"linux","architecture":"amd64"}},
+				{"digest":"sha256:arm64","platform":{"os":"linux","architecture":"arm64","variant":"v8"}}]}`))
+		case "DELETE /v2/library/app/manifests/sha256:index":
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDistribution(t *testing.T) {
+	server := newTestRegistry(t)
+	ctx := context.Background()
+	image := "registry.example.com/library/app:v1"
+
+	if err := NewDistribution(server.URL, "user", "wrong").Ping(ctx); err == nil {
+		t.Error("Ping() with wrong password should fail")
+	}
+
+	d := NewDistribution(server.URL, "user", "pass")
+	tags, err := d.ListTags(ctx, image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Tag{{Name: "v1"}, {Name: "v2"}}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("ListTags() = %v, want %v", tags, want)
+	}
+
+	manifest, err := d.GetManifest(ctx, image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPlatforms := []Platform{
+		{OS: "linux", Architecture: "amd64", Digest: "sha256:amd64"},
+		{OS: "linux", Architecture: "arm64", Variant: "v8", Digest: "sha256:arm64"},
+	}
+	if manifest.Digest != "sha256:index" || !reflect.DeepEqual(manifest.Platforms, wantPlatforms) {
+		t.Errorf("GetManifest() = %+v", manifest)
+	}
+
+	if err := d.Delete(ctx, image); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Delete() error = %v, want ErrUnsupported", err)
+	}
+}
@@ -16,8 +16,11 @@ package utils
 
 import (
 	"bytes"
+	"crypto/aes"
 	"crypto/cipher"
 	"crypto/des"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -244,6 +247,55 @@ func (e *DesEncryptor) UnPadding(data []byte) []byte {
 	return data[:(length - unpadding)]
 }
 
+// AesEncryptor 使用 AES-GCM 加密存储的凭据, 密钥为任意长度的字符串
+type AesEncryptor struct {
+	Key []byte
+}
+
+func NewAesEncryptor(key string) *AesEncryptor {
+	sum := sha256.Sum256([]byte(key))
+	return &AesEncryptor{Key: sum[:]}
+}
+
+func (e *AesEncryptor) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(e.Key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (e *AesEncryptor) EncryptBase64(input string) (string, error) {
+	gcm, err := e.gcm()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := cryptorand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(input), nil)), nil
+}
+
+func (e *AesEncryptor) DecryptBase64(input string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(input)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := e.gcm()
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("invalid encrypted data")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
 const (
 	BYTE = 1 << (10 * iota)
 	KILOBYTE
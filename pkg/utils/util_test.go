@@ -194,6 +194,20 @@ func TestDesEncryptor_DecryptBase64(t *testing.T) {
 	})
 }
 
+func TestAesEncryptor(t *testing.T) {
+	e := NewAesEncryptor("kubegems")
+	encrypted, err := e.EncryptBase64("test123")
+	if err != nil {
+		t.Fatalf("AesEncryptor.EncryptBase64() error = %v", err)
+	}
+	if got, err := e.DecryptBase64(encrypted); err != nil || got != "test123" {
+		t.Errorf("AesEncryptor.DecryptBase64() = %v, %v", got, err)
+	}
+	if _, err := NewAesEncryptor("other").DecryptBase64(encrypted); err == nil {
+		t.Errorf("AesEncryptor.DecryptBase64() with wrong key should fail")
+	}
+}
+
 func TestConvertBytes(t *testing.T) {
 	type args struct {
 		bytes float64
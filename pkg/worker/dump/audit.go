@@ -21,7 +21,9 @@ import (
 	"strconv"
 	"time"
 
+	"gorm.io/gorm"
 	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/aaa/audit"
 	"kubegems.io/kubegems/pkg/service/models"
 	"kubegems.io/kubegems/pkg/utils"
 )
@@ -49,7 +51,7 @@ func (d *Dump) ExportAuditlogs(destDir string, dur time.Duration) {
 	defer file.Close()
 
 	w := csv.NewWriter(file)
-	w.Write([]string{"id", "user_name", "tenant", "module", "action", "success", "raw_data", "labels", "client_ip", "name", "created_at", "updated_at", "deleted_at"})
+	w.Write([]string{"id", "user_name", "tenant", "module", "action", "success", "raw_data", "labels", "client_ip", "name", "created_at", "updated_at", "deleted_at", "prev_hash", "hash"})
 
 	count := 0
 	for {
@@ -58,7 +60,7 @@ func (d *Dump) ExportAuditlogs(destDir string, dur time.Duration) {
 		if err = d.DB.DB().
 			Unscoped(). // 有delete_at 字段
 			Where("created_at < ?", endTime).
-			Order("created_at").
+			Order("id"). // 按哈希链的顺序归档
 			Limit(100).
 			Find(&auditlogs).Error; err != nil {
 			log.Error(err, "find auditlogs error")
@@ -88,6 +90,8 @@ func (d *Dump) ExportAuditlogs(destDir string, dur time.Duration) {
 				auditlogs[i].CreatedAt.Format("2006-01-02 15:04:05.000"),      // mysql datetime 格式
 				auditlogs[i].UpdatedAt.Format("2006-01-02 15:04:05.000"),      // mysql datetime 格式
 				auditlogs[i].DeletedAt.Time.Format("2006-01-02 15:04:05.000"), // mysql datetime 格式
+				auditlogs[i].PrevHash,
+				auditlogs[i].Hash,
 			}
 			ids[i] = auditlogs[i].ID
 		}
@@ -96,11 +100,14 @@ func (d *Dump) ExportAuditlogs(destDir string, dur time.Duration) {
 			return
 		}
 
-		// 删除数据
-		if err := d.DB.DB().
-			Unscoped(). // 有delete_at 字段，永久删除
-			Where("id in ?", ids).
-			Delete(&models.AuditLog{}).Error; err != nil {
+		// 删除数据, 并记录哈希链归档的位置
+		if err := d.DB.DB().Transaction(func(tx *gorm.DB) error {
+			// 有delete_at 字段，永久删除
+			if err := tx.Unscoped().Where("id in ?", ids).Delete(&models.AuditLog{}).Error; err != nil {
+				return err
+			}
+			return audit.ArchiveChain(tx, &auditlogs[len(auditlogs)-1])
+		}); err != nil {
 			log.Error(err, "delete auditlogs")
 			return
 		}
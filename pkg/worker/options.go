@@ -19,31 +19,40 @@ import (
 	"kubegems.io/kubegems/pkg/utils/database"
 	"kubegems.io/kubegems/pkg/utils/git"
 	"kubegems.io/kubegems/pkg/utils/helm"
+	"kubegems.io/kubegems/pkg/utils/jwt"
+	"kubegems.io/kubegems/pkg/utils/logexport"
+	"kubegems.io/kubegems/pkg/utils/msgbus"
 	"kubegems.io/kubegems/pkg/utils/prometheus"
 	"kubegems.io/kubegems/pkg/utils/redis"
 	"kubegems.io/kubegems/pkg/worker/dump"
 )
 
 type Options struct {
-	AppStore *helm.Options               `json:"appStore,omitempty"`
-	Argo     *argo.Options               `json:"argo,omitempty"`
-	Dump     *dump.DumpOptions           `json:"dump,omitempty"`
-	Exporter *prometheus.ExporterOptions `json:"exporter,omitempty"`
-	Git      *git.Options                `json:"git,omitempty"`
-	LogLevel string                      `json:"logLevel,omitempty"`
-	Mysql    *database.Options           `json:"mysql,omitempty"`
-	Redis    *redis.Options              `json:"redis,omitempty"`
+	AppStore  *helm.Options               `json:"appStore,omitempty"`
+	Argo      *argo.Options               `json:"argo,omitempty"`
+	Dump      *dump.DumpOptions           `json:"dump,omitempty"`
+	Exporter  *prometheus.ExporterOptions `json:"exporter,omitempty"`
+	Git       *git.Options                `json:"git,omitempty"`
+	JWT       *jwt.Options                `json:"jwt,omitempty"`
+	LogExport *logexport.Options          `json:"logExport,omitempty"`
+	LogLevel  string                      `json:"logLevel,omitempty"`
+	Msgbus    *msgbus.Options             `json:"msgbus,omitempty"`
+	Mysql     *database.Options           `json:"mysql,omitempty"`
+	Redis     *redis.Options              `json:"redis,omitempty"`
 }
 
 func DefaultOptions() *Options {
 	return &Options{
-		AppStore: helm.NewDefaultOptions(),
-		Argo:     argo.NewDefaultArgoOptions(),
-		Dump:     dump.NewDefaultDumpOptions(),
-		Exporter: prometheus.DefaultExporterOptions(),
-		Git:      git.NewDefaultOptions(),
-		LogLevel: "debug",
-		Mysql:    database.NewDefaultOptions(),
-		Redis:    redis.NewDefaultOptions(),
+		AppStore:  helm.NewDefaultOptions(),
+		Argo:      argo.NewDefaultArgoOptions(),
+		Dump:      dump.NewDefaultDumpOptions(),
+		Exporter:  prometheus.DefaultExporterOptions(),
+		Git:       git.NewDefaultOptions(),
+		JWT:       jwt.DefaultOptions(),
+		LogExport: logexport.NewDefaultOptions(),
+		LogLevel:  "debug",
+		Msgbus:    msgbus.DefaultMsgbusOptions(),
+		Mysql:     database.NewDefaultOptions(),
+		Redis:     redis.NewDefaultOptions(),
 	}
 }
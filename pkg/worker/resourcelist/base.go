@@ -44,5 +44,19 @@ func (c *ResourceCache) Start() {
 	}); err != nil {
 		log.Error(err, "environment sync")
 	}
+	if _, err := cron.AddFunc("@daily", func() {
+		if err := c.CostSync(); err != nil {
+			log.Error(err, "cost sync")
+		}
+	}); err != nil {
+		log.Error(err, "cost sync")
+	}
+	if _, err := cron.AddFunc("@daily", func() {
+		if err := c.RecommendationSync(); err != nil {
+			log.Error(err, "recommendation sync")
+		}
+	}); err != nil {
+		log.Error(err, "recommendation sync")
+	}
 	cron.Start()
 }
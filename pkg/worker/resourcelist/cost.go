@@ -0,0 +1,235 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resourcelist
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	promemodel "github.com/prometheus/common/model"
+	"gorm.io/gorm"
+	v1 "k8s.io/api/core/v1"
+	"kubegems.io/kubegems/pkg/apis/gems"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils"
+	"kubegems.io/kubegems/pkg/utils/agents"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// 费用按前一天的平均申请量与平均使用量计算, 查询在计费日结束时刻求值(见 costQueryAt)
+const (
+	namespaceCPURequestCore_LastDay     = `avg_over_time(sum(kube_pod_container_resource_requests{resource="cpu"})by(namespace)[1d:5m])`
+	namespaceMemoryRequestByte_LastDay  = `avg_over_time(sum(kube_pod_container_resource_requests{resource="memory"})by(namespace)[1d:5m])`
+	namespaceGPURequest_LastDay         = `avg_over_time(sum(kube_pod_container_resource_requests{resource="nvidia_com_gpu"})by(namespace)[1d:5m])`
+	namespaceStorageRequestByte_LastDay = `avg_over_time(sum(kube_persistentvolumeclaim_resource_requests_storage_bytes)by(namespace)[1d:5m])`
+	namespaceCPUUsageCore_LastDay       = `avg_over_time(sum(gems_container_cpu_usage_cores)by(namespace)[1d:5m])`
+	namespaceMemoryUsageByte_LastDay    = `avg_over_time(sum(gems_container_memory_usage_bytes)by(namespace)[1d:5m])`
+	namespaceStorageUsageByte_LastDay   = `avg_over_time(sum(gems_pvc_usage_bytes)by(namespace)[1d:5m])`
+
+	// 通过 gems_pod_workload 将pod的requests关联到workload
+	workloadRequest_LastDay = `avg_over_time(sum(kube_pod_container_resource_requests{resource="%s"}
+	* on(namespace, pod) group_left(owner_kind, workload) max(gems_pod_workload{owner_kind=~"Deployment|StatefulSet|DaemonSet"})by(namespace, pod, owner_kind, workload))by(namespace, owner_kind, workload)[1d:5m])`
+	workloadCPUUsageCore_LastDay    = `avg_over_time(sum(gems_container_cpu_usage_cores{owner_kind=~"Deployment|StatefulSet|DaemonSet", container!~"istio-proxy|"})by(namespace, owner_kind, workload)[1d:5m])`
+	workloadMemoryUsageByte_LastDay = `avg_over_time(sum(gems_container_memory_usage_bytes{owner_kind=~"Deployment|StatefulSet|DaemonSet", container!~"istio-proxy|"})by(namespace, owner_kind, workload)[1d:5m])`
+)
+
+// costQueryAt 使用 @ 修饰符将查询的 [1d:5m] 子查询固定在 end 时刻求值, 使结果与执行时间无关
+func costQueryAt(query string, end time.Time) string {
+	return strings.ReplaceAll(query, "[1d:5m]", fmt.Sprintf("[1d:5m] @ %d", end.Unix()))
+}
+
+type costQuery struct {
+	query string
+	set   func(c *costAmount, v float64)
+}
+
+// costAmount 一个计费对象的资源量
+type costAmount struct {
+	metric  promemodel.Metric
+	request models.ResourceAmount
+	usage   models.ResourceAmount
+}
+
+// GPU 为独占资源, 使用量即申请量
+var namespaceCostQueries = []costQuery{
+	{query: namespaceCPURequestCore_LastDay, set: func(c *costAmount, v float64) { c.request.CPUCore = v }},
+	{query: namespaceMemoryRequestByte_LastDay, set: func(c *costAmount, v float64) { c.request.MemoryByte = v }},
+	{query: namespaceGPURequest_LastDay, set: func(c *costAmount, v float64) { c.request.GPU, c.usage.GPU = v, v }},
+	{query: namespaceStorageRequestByte_LastDay, set: func(c *costAmount, v float64) { c.request.StorageByte = v }},
+	{query: namespaceCPUUsageCore_LastDay, set: func(c *costAmount, v float64) { c.usage.CPUCore = v }},
+	{query: namespaceMemoryUsageByte_LastDay, set: func(c *costAmount, v float64) { c.usage.MemoryByte = v }},
+	{query: namespaceStorageUsageByte_LastDay, set: func(c *costAmount, v float64) { c.usage.StorageByte = v }},
+}
+
+var workloadCostQueries = []costQuery{
+	{query: fmt.Sprintf(workloadRequest_LastDay, "cpu"), set: func(c *costAmount, v float64) { c.request.CPUCore = v }},
+	{query: fmt.Sprintf(workloadRequest_LastDay, "memory"), set: func(c *costAmount, v float64) { c.request.MemoryByte = v }},
+	{query: fmt.Sprintf(workloadRequest_LastDay, "nvidia_com_gpu"), set: func(c *costAmount, v float64) { c.request.GPU, c.usage.GPU = v, v }},
+	{query: workloadCPUUsageCore_LastDay, set: func(c *costAmount, v float64) { c.usage.CPUCore = v }},
+	{query: workloadMemoryUsageByte_LastDay, set: func(c *costAmount, v float64) { c.usage.MemoryByte = v }},
+}
+
+// CostSync 按集群单价计算前一天各环境及workload的费用, 未配置单价的集群不计费
+func (c *ResourceCache) CostSync() error {
+	log.Info("start cost sync")
+	start := time.Now()
+	// 统计区间为 [date, end)
+	end := utils.DayStartTime(start)
+	date := end.Add(-24 * time.Hour)
+
+	prices := []models.ClusterPrice{}
+	if err := c.DB.DB().Preload("Cluster").Find(&prices).Error; err != nil {
+		return errors.Wrap(err, "failed to list cluster prices")
+	}
+	priceMap := map[string]*models.ClusterPrice{}
+	for i := range prices {
+		if prices[i].Cluster != nil {
+			priceMap[prices[i].Cluster.ClusterName] = &prices[i]
+		}
+	}
+
+	if err := c.Agents.ExecuteInEachCluster(context.Background(), func(ctx context.Context, cli agents.Client) error {
+		price, ok := priceMap[cli.Name()]
+		if !ok {
+			return nil
+		}
+		nsList := v1.NamespaceList{}
+		if err := cli.List(ctx, &nsList, client.HasLabels([]string{gems.LabelEnvironment})); err != nil {
+			return err
+		}
+		namespaces := map[string]map[string]string{}
+		for _, ns := range nsList.Items {
+			namespaces[ns.Name] = ns.Labels
+		}
+
+		nsAmounts, err := queryCostAmounts(ctx, cli, namespaceCostQueries, end, func(m promemodel.Metric) string {
+			return string(m[NamespaceKey])
+		})
+		if err != nil {
+			return err
+		}
+		workloadAmounts, err := queryCostAmounts(ctx, cli, workloadCostQueries, end, func(m promemodel.Metric) string {
+			tmp := strings.Split(string(m[WorkloadNameKey]), ":") // eg. Deployment:nginx
+			if m[NamespaceKey] == "" || len(tmp) != 2 {
+				return ""
+			}
+			return fmt.Sprintf("%s_%s_%s", m[NamespaceKey], m[WorkloadTypeKey], tmp[1])
+		})
+		if err != nil {
+			return err
+		}
+
+		envCosts := []models.EnvironmentCost{}
+		for ns, amount := range nsAmounts {
+			labels, ok := namespaces[ns]
+			if !ok {
+				continue
+			}
+			envCosts = append(envCosts, models.EnvironmentCost{
+				Date:            date,
+				ClusterName:     cli.Name(),
+				TenantName:      labels[gems.LabelTenant],
+				ProjectName:     labels[gems.LabelProject],
+				EnvironmentName: labels[gems.LabelEnvironment],
+				Currency:        price.Currency,
+				Request:         amount.request,
+				Usage:           amount.usage,
+				RequestCost:     price.DailyCost(amount.request),
+				UsageCost:       price.DailyCost(amount.usage),
+			})
+		}
+		workloadCosts := []models.WorkloadCost{}
+		for _, amount := range workloadAmounts {
+			ns := string(amount.metric[NamespaceKey])
+			labels, ok := namespaces[ns]
+			if !ok {
+				continue
+			}
+			workloadCosts = append(workloadCosts, models.WorkloadCost{
+				Date:            date,
+				ClusterName:     cli.Name(),
+				TenantName:      labels[gems.LabelTenant],
+				ProjectName:     labels[gems.LabelProject],
+				EnvironmentName: labels[gems.LabelEnvironment],
+				Namespace:       ns,
+				Type:            string(amount.metric[WorkloadTypeKey]),
+				Name:            strings.Split(string(amount.metric[WorkloadNameKey]), ":")[1],
+				Currency:        price.Currency,
+				Request:         amount.request,
+				Usage:           amount.usage,
+				RequestCost:     price.DailyCost(amount.request),
+				UsageCost:       price.DailyCost(amount.usage),
+			})
+		}
+
+		// 重复执行时覆盖当天的记录, 删除与写入在同一个事务中, 避免失败时丢失当天的数据
+		if err := c.DB.DB().Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("date = ? and cluster_name = ?", date, cli.Name()).Delete(&models.EnvironmentCost{}).Error; err != nil {
+				return errors.Wrap(err, "failed to delete environment costs")
+			}
+			if err := tx.Where("date = ? and cluster_name = ?", date, cli.Name()).Delete(&models.WorkloadCost{}).Error; err != nil {
+				return errors.Wrap(err, "failed to delete workload costs")
+			}
+			if len(envCosts) > 0 {
+				if err := tx.CreateInBatches(envCosts, 100).Error; err != nil {
+					return errors.Wrap(err, "failed to save environment costs")
+				}
+			}
+			if len(workloadCosts) > 0 {
+				if err := tx.CreateInBatches(workloadCosts, 100).Error; err != nil {
+					return errors.Wrap(err, "failed to save workload costs")
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		log.Infof("cluster %s cost collect succeed, environments: %d, workloads: %d", cli.Name(), len(envCosts), len(workloadCosts))
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	log.Info("finish cost sync", "duration", time.Since(start).String())
+	return nil
+}
+
+func queryCostAmounts(ctx context.Context, cli agents.Client, queries []costQuery, end time.Time, keyOf func(promemodel.Metric) string) (map[string]*costAmount, error) {
+	amounts := map[string]*costAmount{}
+	for _, q := range queries {
+		vector, err := cli.Extend().PrometheusVector(ctx, costQueryAt(q.query, end))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to exec promql")
+		}
+		for _, sample := range vector {
+			key := keyOf(sample.Metric)
+			if key == "" || math.IsInf(float64(sample.Value), 0) || math.IsNaN(float64(sample.Value)) {
+				continue
+			}
+			amount, ok := amounts[key]
+			if !ok {
+				amount = &costAmount{metric: sample.Metric}
+				amounts[key] = amount
+			}
+			q.set(amount, utils.RoundTo(float64(sample.Value), 3))
+		}
+	}
+	return amounts, nil
+}
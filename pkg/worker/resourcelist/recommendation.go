@@ -0,0 +1,244 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resourcelist
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+	promemodel "github.com/prometheus/common/model"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"kubegems.io/kubegems/pkg/apis/application"
+	"kubegems.io/kubegems/pkg/apis/gems"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils"
+	"kubegems.io/kubegems/pkg/utils/agents"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// 按workload的容器汇总, 取所有副本中的最大值
+const (
+	recommendWindow     = "1w"
+	recommendStep       = 5 * time.Minute
+	recommendWindowSize = 7 * 24 * time.Hour
+
+	containerCPUUsageCore_P90    = `max(quantile_over_time(0.9, gems_container_cpu_usage_cores{namespace="%[1]s", owner_kind=~"Deployment|StatefulSet|DaemonSet", container!~"istio-proxy|"}[1w:5m]))by(namespace, owner_kind, workload, container)`
+	containerCPUUsageCore_P99    = `max(quantile_over_time(0.99, gems_container_cpu_usage_cores{namespace="%[1]s", owner_kind=~"Deployment|StatefulSet|DaemonSet", container!~"istio-proxy|"}[1w:5m]))by(namespace, owner_kind, workload, container)`
+	containerMemoryUsageByte_P95 = `max(quantile_over_time(0.95, gems_container_memory_usage_bytes{namespace="%[1]s", owner_kind=~"Deployment|StatefulSet|DaemonSet", container!~"istio-proxy|"}[1w:5m]))by(namespace, owner_kind, workload, container)`
+	containerMemoryUsageByte_Max = `max(max_over_time(gems_container_memory_usage_bytes{namespace="%[1]s", owner_kind=~"Deployment|StatefulSet|DaemonSet", container!~"istio-proxy|"}[1w:5m]))by(namespace, owner_kind, workload, container)`
+	// 窗口内有数据的采样点数, 用于计算置信度
+	containerUsageSamples = `count_over_time(max(gems_container_cpu_usage_cores{namespace="%[1]s", owner_kind=~"Deployment|StatefulSet|DaemonSet", container!~"istio-proxy|"})by(namespace, owner_kind, workload, container)[1w:5m])`
+)
+
+// recommendWorkload 集群中的workload
+type recommendWorkload struct {
+	kind        string
+	name        string
+	replicas    int32
+	annotations map[string]string
+	containers  []v1.Container
+}
+
+// RecommendationSync 根据过去一周的使用量计算workload的资源规格建议
+func (c *ResourceCache) RecommendationSync() error {
+	log.Info("start recommendation sync")
+	start := time.Now()
+
+	prices := []models.ClusterPrice{}
+	if err := c.DB.DB().Preload("Cluster").Find(&prices).Error; err != nil {
+		return errors.Wrap(err, "failed to list cluster prices")
+	}
+	priceMap := map[string]*models.ClusterPrice{}
+	for i := range prices {
+		if prices[i].Cluster != nil {
+			priceMap[prices[i].Cluster.ClusterName] = &prices[i]
+		}
+	}
+
+	if err := c.Agents.ExecuteInEachCluster(context.Background(), func(ctx context.Context, cli agents.Client) error {
+		nsList := v1.NamespaceList{}
+		if err := cli.List(ctx, &nsList, client.HasLabels([]string{gems.LabelEnvironment})); err != nil {
+			return err
+		}
+		recommendations := []models.ResourceRecommendation{}
+		for _, ns := range nsList.Items {
+			recs, err := recommendNamespace(ctx, cli, &ns, priceMap[cli.Name()])
+			if err != nil {
+				return err
+			}
+			recommendations = append(recommendations, recs...)
+		}
+
+		// 每次全量更新集群的建议
+		if err := c.DB.DB().Where("cluster_name = ?", cli.Name()).Delete(&models.ResourceRecommendation{}).Error; err != nil {
+			return errors.Wrap(err, "failed to delete recommendations")
+		}
+		if len(recommendations) > 0 {
+			if err := c.DB.DB().CreateInBatches(recommendations, 100).Error; err != nil {
+				return errors.Wrap(err, "failed to save recommendations")
+			}
+		}
+		log.Infof("cluster %s recommendation succeed, total: %d", cli.Name(), len(recommendations))
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	log.Info("finish recommendation sync", "duration", time.Since(start).String())
+	return nil
+}
+
+func recommendNamespace(ctx context.Context, cli agents.Client, ns *v1.Namespace, price *models.ClusterPrice) ([]models.ResourceRecommendation, error) {
+	workloads, err := listRecommendWorkloads(ctx, cli, ns.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(workloads) == 0 {
+		return nil, nil
+	}
+
+	// key: Deployment:nginx/container
+	usages := map[string]*models.ContainerUsage{}
+	samples := map[string]float64{}
+	for _, q := range []struct {
+		query string
+		set   func(key string, v float64)
+	}{
+		{query: containerCPUUsageCore_P90, set: func(key string, v float64) { usageOf(usages, key).CPUP90Core = v }},
+		{query: containerCPUUsageCore_P99, set: func(key string, v float64) { usageOf(usages, key).CPUP99Core = v }},
+		{query: containerMemoryUsageByte_P95, set: func(key string, v float64) { usageOf(usages, key).MemoryP95Byte = v }},
+		{query: containerMemoryUsageByte_Max, set: func(key string, v float64) { usageOf(usages, key).MemoryMaxByte = v }},
+		{query: containerUsageSamples, set: func(key string, v float64) { samples[key] = v }},
+	} {
+		vector, err := cli.Extend().PrometheusVector(ctx, fmt.Sprintf(q.query, ns.Name))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to exec promql")
+		}
+		for _, sample := range vector {
+			if math.IsInf(float64(sample.Value), 0) || math.IsNaN(float64(sample.Value)) {
+				continue
+			}
+			q.set(recommendContainerKey(sample.Metric), float64(sample.Value))
+		}
+	}
+
+	expectedSamples := float64(recommendWindowSize / recommendStep)
+	ret := []models.ResourceRecommendation{}
+	for _, w := range workloads {
+		rec := models.ResourceRecommendation{
+			ClusterName:     cli.Name(),
+			TenantName:      ns.Labels[gems.LabelTenant],
+			ProjectName:     ns.Labels[gems.LabelProject],
+			EnvironmentName: ns.Labels[gems.LabelEnvironment],
+			Namespace:       ns.Name,
+			Type:            w.kind,
+			Name:            w.name,
+			Ref:             w.annotations[application.AnnotationRef],
+			Replicas:        w.replicas,
+			Window:          recommendWindow,
+			Confidence:      1,
+		}
+		changed := false
+		for _, container := range w.containers {
+			key := w.kind + ":" + w.name + "/" + container.Name
+			usage, ok := usages[key]
+			if !ok {
+				// 没有使用量数据的容器不做调整
+				rec.Containers = nil
+				break
+			}
+			containerRec := models.RecommendContainer(container.Name, container.Resources, *usage)
+			changed = changed || containerRec.Changed()
+			rec.Containers = append(rec.Containers, containerRec)
+			rec.Confidence = math.Min(rec.Confidence, utils.RoundTo(math.Min(samples[key]/expectedSamples, 1), 2))
+
+			cpu, memory := containerRec.RequestSaving()
+			rec.CPUSavingCore += cpu * float64(w.replicas)
+			rec.MemorySavingByte += memory * float64(w.replicas)
+		}
+		if len(rec.Containers) == 0 || !changed {
+			continue
+		}
+		rec.CPUSavingCore = utils.RoundTo(rec.CPUSavingCore, 3)
+		if price != nil {
+			daily := price.DailyCost(models.ResourceAmount{CPUCore: rec.CPUSavingCore, MemoryByte: rec.MemorySavingByte})
+			rec.MonthlySaving = utils.RoundTo(daily.Total*30, 2)
+			rec.Currency = price.Currency
+		}
+		ret = append(ret, rec)
+	}
+	return ret, nil
+}
+
+func listRecommendWorkloads(ctx context.Context, cli agents.Client, namespace string) ([]recommendWorkload, error) {
+	workloads := []recommendWorkload{}
+	deployments := appsv1.DeploymentList{}
+	if err := cli.List(ctx, &deployments, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for _, d := range deployments.Items {
+		workloads = append(workloads, recommendWorkload{
+			kind: Deployment, name: d.Name, replicas: replicasOf(d.Spec.Replicas),
+			annotations: d.Annotations, containers: d.Spec.Template.Spec.Containers,
+		})
+	}
+	statefulsets := appsv1.StatefulSetList{}
+	if err := cli.List(ctx, &statefulsets, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for _, s := range statefulsets.Items {
+		workloads = append(workloads, recommendWorkload{
+			kind: StatefulSet, name: s.Name, replicas: replicasOf(s.Spec.Replicas),
+			annotations: s.Annotations, containers: s.Spec.Template.Spec.Containers,
+		})
+	}
+	daemonsets := appsv1.DaemonSetList{}
+	if err := cli.List(ctx, &daemonsets, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for _, d := range daemonsets.Items {
+		workloads = append(workloads, recommendWorkload{
+			kind: DaemonSet, name: d.Name, replicas: d.Status.DesiredNumberScheduled,
+			annotations: d.Annotations, containers: d.Spec.Template.Spec.Containers,
+		})
+	}
+	return workloads, nil
+}
+
+func usageOf(usages map[string]*models.ContainerUsage, key string) *models.ContainerUsage {
+	usage, ok := usages[key]
+	if !ok {
+		usage = &models.ContainerUsage{}
+		usages[key] = usage
+	}
+	return usage
+}
+
+// recommendContainerKey workload 标签格式为 Deployment:nginx
+func recommendContainerKey(metric promemodel.Metric) string {
+	return fmt.Sprintf("%s/%s", metric[WorkloadNameKey], metric[ContainerKey])
+}
+
+func replicasOf(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
@@ -20,6 +20,7 @@ import (
 	"kubegems.io/kubegems/pkg/utils/argo"
 	"kubegems.io/kubegems/pkg/utils/database"
 	"kubegems.io/kubegems/pkg/utils/git"
+	"kubegems.io/kubegems/pkg/utils/helm"
 	"kubegems.io/kubegems/pkg/utils/redis"
 )
 
@@ -27,8 +28,8 @@ type ApplicationTasker struct {
 	*application.ApplicationProcessor
 }
 
-func MustNewApplicationTasker(db *database.Database, gitp *git.SimpleLocalProvider, argo *argo.Client, redis *redis.Client, agents *agents.ClientSet) *ApplicationTasker {
-	app := application.NewApplicationProcessor(db, gitp, argo, redis, agents)
+func MustNewApplicationTasker(db *database.Database, gitp *git.SimpleLocalProvider, argo *argo.Client, redis *redis.Client, agents *agents.ClientSet, appstore *helm.Options) *ApplicationTasker {
+	app := application.NewApplicationProcessor(db, gitp, argo, redis, agents, appstore)
 	return &ApplicationTasker{ApplicationProcessor: app}
 }
 
@@ -0,0 +1,117 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/log"
+	msgclient "kubegems.io/kubegems/pkg/msgbus/client"
+	"kubegems.io/kubegems/pkg/service/handlers/application"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/database"
+	"kubegems.io/kubegems/pkg/utils/jwt"
+	"kubegems.io/kubegems/pkg/utils/msgbus"
+	"kubegems.io/kubegems/pkg/utils/set"
+	"kubegems.io/kubegems/pkg/utils/workflow"
+)
+
+// AppstoreUpgradeTasker 定时检测应用商店应用在仓库中的新版本, 出现新的可升级版本时通知环境成员及项目管理员
+type AppstoreUpgradeTasker struct {
+	DB     *database.Database
+	App    *application.ApplicationProcessor
+	Msgbus *msgclient.MsgBusClient
+	JWT    *jwt.Options
+}
+
+func (t *AppstoreUpgradeTasker) Check(ctx context.Context) error {
+	envs, err := listTaskEnvironments(ctx, t.DB)
+	if err != nil {
+		return err
+	}
+	logger := log.FromContextOrDiscard(ctx)
+	for _, env := range envs {
+		if err := t.checkEnvironment(ctx, env); err != nil {
+			logger.Error(err, "check appstore upgrades", "environment", env.EnvironmentName)
+		}
+	}
+	return nil
+}
+
+func (t *AppstoreUpgradeTasker) checkEnvironment(ctx context.Context, env taskEnvironment) error {
+	ref := application.PathRef{Tenant: env.TenantName, Project: env.ProjectName, Env: env.EnvironmentName}
+	records, err := t.App.CheckAppstoreUpgrades(ctx, ref)
+	if err != nil {
+		return err
+	}
+	pending := []*models.AppstoreUpgrade{}
+	for _, record := range records {
+		if record.AvailableVersion != "" && record.AvailableVersion != record.NotifiedVersion {
+			pending = append(pending, record)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	t.notify(ctx, env, pending)
+	for _, record := range pending {
+		if err := t.DB.DB().WithContext(ctx).Model(record).Update("notified_version", record.AvailableVersion).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *AppstoreUpgradeTasker) notify(ctx context.Context, env taskEnvironment, pending []*models.AppstoreUpgrade) {
+	upgrades := make([]string, 0, len(pending))
+	for _, record := range pending {
+		upgrades = append(upgrades, fmt.Sprintf("%s(%s %s -> %s)", record.ApplicationName, record.Chart, record.CurrentVersion, record.AvailableVersion))
+	}
+	users := set.NewSet[uint]().
+		Append(t.DB.EnvUsers(env.EnvironmentID)...).
+		Append(t.DB.ProjectAdmins(env.ProjectID)...)
+	msg := &msgclient.MsgRequest{
+		MessageType:   msgbus.Message,
+		EventKind:     msgbus.Update,
+		ResourceType:  msgbus.Environment,
+		ResourceID:    env.EnvironmentID,
+		Username:      "system",
+		Detail:        i18n.Sprintf(ctx, "chart upgrades available for app store applications in environment %s/%s: %s", env.ProjectName, env.EnvironmentName, strings.Join(upgrades, ", ")),
+		ToUsers:       users,
+		AffectedUsers: set.NewSet[uint](),
+	}
+	sendSystemMessage(t.Msgbus, t.JWT, msg)
+}
+
+const TaskFunction_AppstoreUpgradeCheck = "appstore-upgrade-check"
+
+func (t *AppstoreUpgradeTasker) ProvideFuntions() map[string]interface{} {
+	return map[string]interface{}{
+		TaskFunction_AppstoreUpgradeCheck: t.Check,
+	}
+}
+
+func (t *AppstoreUpgradeTasker) Crontasks() map[string]Task {
+	return map[string]Task{
+		"@every 6h": {
+			Name:  "appstore-upgrade-check",
+			Group: "helm",
+			Steps: []workflow.Step{{Function: TaskFunction_AppstoreUpgradeCheck}},
+		},
+	}
+}
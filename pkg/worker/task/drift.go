@@ -0,0 +1,134 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/log"
+	msgclient "kubegems.io/kubegems/pkg/msgbus/client"
+	"kubegems.io/kubegems/pkg/service/handlers/application"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/database"
+	"kubegems.io/kubegems/pkg/utils/jwt"
+	"kubegems.io/kubegems/pkg/utils/msgbus"
+	"kubegems.io/kubegems/pkg/utils/set"
+	"kubegems.io/kubegems/pkg/utils/workflow"
+)
+
+// 通知中最多列出的资源数量
+const driftNotifyMaxResources = 10
+
+// DriftTasker 定时检测各环境中应用的漂移, 出现新的漂移资源时通知环境成员及项目管理员
+type DriftTasker struct {
+	DB     *database.Database
+	App    *application.ApplicationProcessor
+	Msgbus *msgclient.MsgBusClient
+	JWT    *jwt.Options
+}
+
+func (t *DriftTasker) Check(ctx context.Context) error {
+	envs, err := listTaskEnvironments(ctx, t.DB)
+	if err != nil {
+		return err
+	}
+	logger := log.FromContextOrDiscard(ctx)
+	for _, env := range envs {
+		if err := t.checkEnvironment(ctx, env); err != nil {
+			logger.Error(err, "check environment drift", "environment", env.EnvironmentName)
+		}
+	}
+	return nil
+}
+
+func (t *DriftTasker) checkEnvironment(ctx context.Context, env taskEnvironment) error {
+	ref := application.PathRef{Tenant: env.TenantName, Project: env.ProjectName, Env: env.EnvironmentName}
+	report, err := t.App.DriftReport(ctx, ref)
+	if err != nil {
+		return err
+	}
+
+	// 没有上次的记录时视为空, 其他错误时不更新记录, 避免重复通知
+	previous := &models.EnvironmentDrift{}
+	if err := t.DB.DB().WithContext(ctx).Where("environment_id = ?", env.EnvironmentID).Take(previous).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+	}
+
+	current := &models.EnvironmentDrift{
+		EnvironmentID: env.EnvironmentID,
+		Resources:     report.ResourceKeys(),
+		OutOfSync:     report.OutOfSync,
+		ManualChanges: report.ManualChanges,
+		CheckedAt:     report.CheckedAt,
+		NotifiedAt:    previous.NotifiedAt,
+	}
+	added := previous.Resources.Added(current.Resources)
+	if len(added) > 0 {
+		t.notify(ctx, env, report, added)
+		now := time.Now()
+		current.NotifiedAt = &now
+	}
+	return t.DB.DB().WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "environment_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"resources", "out_of_sync", "manual_changes", "checked_at", "notified_at"}),
+	}).Create(current).Error
+}
+
+func (t *DriftTasker) notify(ctx context.Context, env taskEnvironment, report *application.DriftReport, added []string) {
+	resources := added
+	if len(resources) > driftNotifyMaxResources {
+		resources = resources[:driftNotifyMaxResources]
+	}
+	users := set.NewSet[uint]().
+		Append(t.DB.EnvUsers(env.EnvironmentID)...).
+		Append(t.DB.ProjectAdmins(env.ProjectID)...)
+	msg := &msgclient.MsgRequest{
+		MessageType:   msgbus.Message,
+		EventKind:     msgbus.Update,
+		ResourceType:  msgbus.Environment,
+		ResourceID:    env.EnvironmentID,
+		Username:      "system",
+		Detail:        i18n.Sprintf(ctx, "drift detected in environment %s/%s: %d new out of sync resources (%d manually changed): %s", env.ProjectName, env.EnvironmentName, len(added), report.ManualChanges, strings.Join(resources, ", ")),
+		ToUsers:       users,
+		AffectedUsers: set.NewSet[uint](),
+	}
+	sendSystemMessage(t.Msgbus, t.JWT, msg)
+}
+
+const TaskFunction_DriftCheck = "drift-check"
+
+func (t *DriftTasker) ProvideFuntions() map[string]interface{} {
+	return map[string]interface{}{
+		TaskFunction_DriftCheck: t.Check,
+	}
+}
+
+func (t *DriftTasker) Crontasks() map[string]Task {
+	return map[string]Task{
+		"@every 30m": {
+			Name:  "drift-check",
+			Group: "application",
+			Steps: []workflow.Step{{Function: TaskFunction_DriftCheck}},
+		},
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/log"
+	msgclient "kubegems.io/kubegems/pkg/msgbus/client"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/agents"
+	"kubegems.io/kubegems/pkg/utils/database"
+	"kubegems.io/kubegems/pkg/utils/jwt"
+	"kubegems.io/kubegems/pkg/utils/logexport"
+	"kubegems.io/kubegems/pkg/utils/msgbus"
+	"kubegems.io/kubegems/pkg/utils/set"
+	"kubegems.io/kubegems/pkg/utils/workflow"
+)
+
+// LogExportTasker 异步导出日志至对象存储, 完成后通过消息中心发送下载链接
+type LogExportTasker struct {
+	DB      *database.Database
+	Agents  *agents.ClientSet
+	Options *logexport.Options
+	Store   logexport.Store
+	Msgbus  *msgclient.MsgBusClient
+	JWT     *jwt.Options
+}
+
+func NewLogExportTasker(ctx context.Context, db *database.Database, cs *agents.ClientSet, options *logexport.Options,
+	msgbuscli *msgclient.MsgBusClient, jwtOptions *jwt.Options,
+) (*LogExportTasker, error) {
+	store, err := logexport.NewStore(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	return &LogExportTasker{DB: db, Agents: cs, Options: options, Store: store, Msgbus: msgbuscli, JWT: jwtOptions}, nil
+}
+
+func (t *LogExportTasker) Export(ctx context.Context, jobID uint) error {
+	job := &models.LogExportJob{}
+	if err := t.DB.DB().WithContext(ctx).First(job, jobID).Error; err != nil {
+		return err
+	}
+	if job.Status == models.LogExportJobSuccess {
+		return nil
+	}
+	if err := t.export(ctx, job); err != nil {
+		now := time.Now()
+		t.DB.DB().WithContext(ctx).Model(job).Updates(map[string]interface{}{
+			"status":      models.LogExportJobFailed,
+			"message":     err.Error(),
+			"finished_at": &now,
+		})
+		t.notify(ctx, job, i18n.Sprintf(ctx, "log export %s failed: %s", job.Filename, err.Error()))
+		return err
+	}
+	return nil
+}
+
+func (t *LogExportTasker) export(ctx context.Context, job *models.LogExportJob) error {
+	window, err := parseDuration(t.Options.Window)
+	if err != nil {
+		return err
+	}
+	retention, err := parseDuration(t.Options.Retention)
+	if err != nil {
+		return err
+	}
+	expiration, err := parseDuration(t.Options.LinkExpiration)
+	if err != nil {
+		return err
+	}
+	db := t.DB.DB().WithContext(ctx)
+	if err := db.Model(job).Updates(map[string]interface{}{"status": models.LogExportJobRunning, "progress": 0, "message": ""}).Error; err != nil {
+		return err
+	}
+
+	writer, err := logexport.NewWriter(job.Format, job.Compress)
+	if err != nil {
+		return err
+	}
+	defer writer.Remove()
+	req := logexport.Request{
+		Query:    job.LogQL,
+		Start:    job.StartTime,
+		End:      job.EndTime,
+		Window:   window,
+		PageSize: t.Options.PageSize,
+	}
+	progress := func(percent int, lines int64) error {
+		return db.Model(job).Updates(map[string]interface{}{"progress": percent, "lines": lines, "size": writer.Size()}).Error
+	}
+	if err := logexport.Export(ctx, logexport.AgentQueryRange(t.Agents, job.Cluster), req, writer, progress); err != nil {
+		return err
+	}
+	content, size, err := writer.Close()
+	if err != nil {
+		return err
+	}
+	key := path.Join(job.CreatedAt.Format("2006/01/02"), fmt.Sprintf("%d-%s", job.ID, job.Filename))
+	if err := t.Store.Put(ctx, key, content, size, logexport.ContentType(job.Format, job.Compress)); err != nil {
+		return err
+	}
+	now := time.Now()
+	expiredAt := now.Add(retention)
+	if err := db.Model(job).Updates(map[string]interface{}{
+		"status":      models.LogExportJobSuccess,
+		"progress":    100,
+		"lines":       writer.Lines,
+		"size":        size,
+		"storage_key": key,
+		"finished_at": &now,
+		"expired_at":  &expiredAt,
+	}).Error; err != nil {
+		return err
+	}
+
+	link, err := t.Store.SignedURL(ctx, key, job.Filename, expiration)
+	if err != nil {
+		// 无法生成签名链接时使用需要登录的下载地址
+		link = fmt.Sprintf("/v1/log/exports/%d/download", job.ID)
+	}
+	t.notify(ctx, job, i18n.Sprintf(ctx, "log export %s is ready, %d lines, download link (valid for %s): %s", job.Filename, writer.Lines, expiration.String(), link))
+	return nil
+}
+
+func (t *LogExportTasker) notify(ctx context.Context, job *models.LogExportJob, detail string) {
+	msg := &msgclient.MsgRequest{
+		MessageType:   msgbus.Message,
+		EventKind:     msgbus.Update,
+		ResourceType:  msgbus.User,
+		ResourceID:    job.CreatorID,
+		Username:      "system",
+		Detail:        detail,
+		ToUsers:       set.NewSet[uint]().Append(job.CreatorID),
+		AffectedUsers: set.NewSet[uint](),
+	}
+	sendSystemMessage(t.Msgbus, t.JWT, msg)
+}
+
+// Cleanup 删除过期的导出文件及任务
+func (t *LogExportTasker) Cleanup(ctx context.Context) error {
+	db := t.DB.DB().WithContext(ctx)
+	jobs := []models.LogExportJob{}
+	if err := db.Where("expired_at < ?", time.Now()).Find(&jobs).Error; err != nil {
+		return err
+	}
+	logger := log.FromContextOrDiscard(ctx)
+	for i := range jobs {
+		if jobs[i].StorageKey != "" {
+			if err := t.Store.Delete(ctx, jobs[i].StorageKey); err != nil {
+				logger.Error(err, "delete expired log export", "key", jobs[i].StorageKey)
+				continue
+			}
+		}
+		if err := db.Delete(&jobs[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	d, err := model.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(d), nil
+}
+
+const (
+	TaskFunction_LogExport        = "log-export"
+	TaskFunction_LogExportCleanup = "log-export-cleanup"
+)
+
+func (t *LogExportTasker) ProvideFuntions() map[string]interface{} {
+	return map[string]interface{}{
+		TaskFunction_LogExport:        t.Export,
+		TaskFunction_LogExportCleanup: t.Cleanup,
+	}
+}
+
+func (t *LogExportTasker) Crontasks() map[string]Task {
+	return map[string]Task{
+		"@every 1h": {
+			Name:  "log-export-cleanup",
+			Group: "log",
+			Steps: []workflow.Step{{Function: TaskFunction_LogExportCleanup}},
+		},
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"time"
+
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/service/observe"
+	"kubegems.io/kubegems/pkg/utils/agents"
+	"kubegems.io/kubegems/pkg/utils/database"
+	"kubegems.io/kubegems/pkg/utils/workflow"
+)
+
+// LogReportTasker 每分钟检查定时日志报告, 到达 cron 时间的报告生成后发送至告警渠道
+type LogReportTasker struct {
+	DB     *database.Database
+	Agents *agents.ClientSet
+}
+
+func (t *LogReportTasker) Dispatch(ctx context.Context) error {
+	reports := []models.LogReport{}
+	if err := t.DB.DB().WithContext(ctx).Where("enabled = ?", true).Find(&reports).Error; err != nil {
+		return err
+	}
+	now := time.Now()
+	logger := log.FromContextOrDiscard(ctx)
+	for i := range reports {
+		next, err := observe.NextLogReportTime(&reports[i])
+		if err != nil {
+			logger.Error(err, "invalid log report cron", "report", reports[i].Name)
+			continue
+		}
+		if next.After(now) {
+			continue
+		}
+		if claimed, err := t.claim(ctx, &reports[i], now); err != nil || !claimed {
+			continue
+		}
+		if err := t.Run(ctx, &reports[i], now); err != nil {
+			logger.Error(err, "run log report", "report", reports[i].Name)
+		}
+	}
+	return nil
+}
+
+// claim 更新执行时间, 避免多个 worker 同时执行同一个报告
+func (t *LogReportTasker) claim(ctx context.Context, report *models.LogReport, now time.Time) (bool, error) {
+	query := t.DB.DB().WithContext(ctx).Model(&models.LogReport{}).Where("id = ?", report.ID)
+	if report.LastRunAt == nil {
+		query = query.Where("last_run_at is null")
+	} else {
+		query = query.Where("last_run_at = ?", *report.LastRunAt)
+	}
+	result := query.Update("last_run_at", &now)
+	return result.RowsAffected == 1, result.Error
+}
+
+// Run 生成并发送报告, 记录执行结果
+func (t *LogReportTasker) Run(ctx context.Context, report *models.LogReport, now time.Time) error {
+	err := func() error {
+		cli, err := t.Agents.ClientOf(ctx, report.Cluster)
+		if err != nil {
+			return err
+		}
+		result, err := observe.GenerateLogReport(ctx, cli, report, now)
+		if err != nil {
+			return err
+		}
+		return observe.SendLogReport(t.DB.DB().WithContext(ctx), report, result)
+	}()
+	updates := map[string]interface{}{
+		"last_run_at": &now,
+		"last_status": models.LogReportStatusSuccess,
+		"last_error":  "",
+	}
+	if err != nil {
+		updates["last_status"] = models.LogReportStatusFailed
+		updates["last_error"] = err.Error()
+	}
+	if dberr := t.DB.DB().WithContext(ctx).Model(report).Updates(updates).Error; dberr != nil {
+		return dberr
+	}
+	return err
+}
+
+const TaskFunction_LogReportDispatch = "log-report-dispatch"
+
+func (t *LogReportTasker) ProvideFuntions() map[string]interface{} {
+	return map[string]interface{}{
+		TaskFunction_LogReportDispatch: t.Dispatch,
+	}
+}
+
+func (t *LogReportTasker) Crontasks() map[string]Task {
+	return map[string]Task{
+		"@every 1m": {
+			Name:  "log-report-dispatch",
+			Group: "log",
+			Steps: []workflow.Step{{Function: TaskFunction_LogReportDispatch}},
+		},
+	}
+}
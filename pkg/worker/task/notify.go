@@ -0,0 +1,73 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"os"
+	"time"
+
+	msgclient "kubegems.io/kubegems/pkg/msgbus/client"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/database"
+	"kubegems.io/kubegems/pkg/utils/jwt"
+)
+
+type taskEnvironment struct {
+	EnvironmentID   uint
+	EnvironmentName string
+	ProjectID       uint
+	ProjectName     string
+	TenantName      string
+}
+
+func listTaskEnvironments(ctx context.Context, db *database.Database) ([]taskEnvironment, error) {
+	envs := []taskEnvironment{}
+	err := db.DB().WithContext(ctx).Model(&models.Environment{}).
+		Select("environments.id as environment_id, environments.environment_name, projects.id as project_id, projects.project_name, tenants.tenant_name").
+		Joins("left join projects on projects.id = environments.project_id").
+		Joins("left join tenants on tenants.id = projects.tenant_id").
+		Scan(&envs).Error
+	return envs, err
+}
+
+// sendSystemMessage 以 system 用户发送消息, 没有可用的签名证书时仅保存消息, 用户在消息中心中查看
+func sendSystemMessage(cli *msgclient.MsgBusClient, opts *jwt.Options, msg *msgclient.MsgRequest) {
+	if cli == nil {
+		return
+	}
+	if token := systemAuthorization(opts); token != "" {
+		msg.Authorization = token
+		cli.Send(msg)
+	} else {
+		cli.Save(msg)
+	}
+}
+
+func systemAuthorization(opts *jwt.Options) string {
+	if opts == nil {
+		return ""
+	}
+	for _, file := range []string{opts.Key, opts.Cert} {
+		if _, err := os.Stat(file); err != nil {
+			return ""
+		}
+	}
+	token, _, err := opts.ToJWT().GenerateToken(&models.User{Username: "system"}, "system", time.Minute)
+	if err != nil {
+		return ""
+	}
+	return "Bearer " + token
+}
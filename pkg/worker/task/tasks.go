@@ -22,11 +22,14 @@ import (
 	"github.com/go-redsync/redsync/v4/redis/goredis/v8"
 	"golang.org/x/sync/errgroup"
 	"kubegems.io/kubegems/pkg/log"
+	msgclient "kubegems.io/kubegems/pkg/msgbus/client"
 	"kubegems.io/kubegems/pkg/utils/agents"
 	"kubegems.io/kubegems/pkg/utils/argo"
 	"kubegems.io/kubegems/pkg/utils/database"
 	"kubegems.io/kubegems/pkg/utils/git"
 	"kubegems.io/kubegems/pkg/utils/helm"
+	"kubegems.io/kubegems/pkg/utils/jwt"
+	"kubegems.io/kubegems/pkg/utils/logexport"
 	"kubegems.io/kubegems/pkg/utils/redis"
 	"kubegems.io/kubegems/pkg/utils/workflow"
 )
@@ -54,6 +57,9 @@ func Run(ctx context.Context, rediscli *redis.Client,
 	argocd *argo.Client,
 	helmOptions *helm.Options,
 	agents *agents.ClientSet,
+	msgbuscli *msgclient.MsgBusClient,
+	jwtOptions *jwt.Options,
+	logexportOptions *logexport.Options,
 ) error {
 
 	p := &ProcessorContext{
@@ -64,18 +70,31 @@ func Run(ctx context.Context, rediscli *redis.Client,
 		Logger:    log.FromContextOrDiscard(ctx),
 	}
 
+	apptasker := MustNewApplicationTasker(db, gitp, argocd, rediscli, agents, helmOptions)
+	logexporttasker, err := NewLogExportTasker(ctx, db, agents, logexportOptions, msgbuscli, jwtOptions)
+	if err != nil {
+		return err
+	}
 	// 注册支持的处理函数
 	taskers := []Tasker{
 		// 示例
 		&SampleTasker{},
 		// application 应用部署相关
-		MustNewApplicationTasker(db, gitp, argocd, rediscli, agents),
+		apptasker,
 		// task-archive 持久化过期任务至database
 		NewTaskArchiverTasker(db, rediscli),
 		// chart-sync 同步helmchart
 		&HelmSyncTasker{DB: db, ChartRepoUrl: helmOptions.Addr},
 		// cluster
 		&ClusterSyncTasker{DB: db, cs: agents},
+		// drift 环境漂移检测
+		&DriftTasker{DB: db, App: apptasker.ApplicationProcessor, Msgbus: msgbuscli, JWT: jwtOptions},
+		// appstore-upgrade 应用商店应用升级检测
+		&AppstoreUpgradeTasker{DB: db, App: apptasker.ApplicationProcessor, Msgbus: msgbuscli, JWT: jwtOptions},
+		// log-export 日志异步导出
+		logexporttasker,
+		// log-report 定时日志报告
+		&LogReportTasker{DB: db, Agents: agents},
 	}
 	if err := p.RegisterTasker(taskers...); err != nil {
 		return err
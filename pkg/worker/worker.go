@@ -21,6 +21,7 @@ import (
 	"github.com/go-logr/logr"
 	"golang.org/x/sync/errgroup"
 	"kubegems.io/kubegems/pkg/log"
+	msgclient "kubegems.io/kubegems/pkg/msgbus/client"
 	"kubegems.io/kubegems/pkg/utils/agents"
 	"kubegems.io/kubegems/pkg/utils/argo"
 	"kubegems.io/kubegems/pkg/utils/database"
@@ -40,6 +41,7 @@ type Dependencies struct {
 	Argocli   *argo.Client
 	Git       *git.SimpleLocalProvider
 	Agentscli *agents.ClientSet
+	Msgbus    *msgclient.MsgBusClient
 	Logger    logr.Logger
 }
 
@@ -79,6 +81,7 @@ func prepareDependencies(ctx context.Context, options *Options) (*Dependencies,
 		Argocli:   argocli,
 		Git:       gitprovider,
 		Agentscli: agentclientset,
+		Msgbus:    msgclient.NewMessageBusClient(databasecli, options.Msgbus),
 	}, nil
 }
 
@@ -108,6 +111,11 @@ func Run(ctx context.Context, options *Options) error {
 		} else {
 			w.Write([]byte("ok"))
 		}
+		if err := cache.RecommendationSync(); err != nil {
+			w.Write([]byte(err.Error()))
+		} else {
+			w.Write([]byte("ok"))
+		}
 	})
 
 	exporterHandler := exporter.NewHandler("gems_worker", map[string]exporter.Collectorfunc{})
@@ -120,7 +128,7 @@ func Run(ctx context.Context, options *Options) error {
 		return exporterHandler.Run(ctx, options.Exporter)
 	})
 	eg.Go(func() error {
-		return task.Run(ctx, deps.Redis, deps.Databse, deps.Git, deps.Argocli, options.AppStore, deps.Agentscli)
+		return task.Run(ctx, deps.Redis, deps.Databse, deps.Git, deps.Argocli, options.AppStore, deps.Agentscli, deps.Msgbus, options.JWT, options.LogExport)
 	})
 	return eg.Wait()
 }